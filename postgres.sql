@@ -69,3 +69,62 @@ CREATE TABLE audit_logs (
   metadata    JSONB,
   created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
 );
+
+-- Seller API keys. Only the SHA-256 of a key is stored; prefix is its first characters, shown so
+-- sellers can tell keys apart.
+CREATE TABLE api_keys (
+  id            UUID PRIMARY KEY,
+  seller_id     UUID NOT NULL REFERENCES users(id),
+  name          TEXT NOT NULL,
+  prefix        TEXT NOT NULL,
+  hash          TEXT NOT NULL UNIQUE,
+  scopes        TEXT[] NOT NULL,      -- 'listings:read' | 'listings:write' | 'orders:read'
+  last_used_at  TIMESTAMPTZ,
+  revoked_at    TIMESTAMPTZ,
+  version       BIGINT NOT NULL DEFAULT 1,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX api_keys_seller ON api_keys (seller_id, created_at);
+
+-- Sellers' HTTPS webhook endpoints and the events each is sent.
+CREATE TABLE webhook_endpoints (
+  id               UUID PRIMARY KEY,
+  seller_id        UUID NOT NULL REFERENCES users(id),
+  url              TEXT NOT NULL,
+  description      TEXT,
+  events           TEXT[] NOT NULL,   -- 'order.paid' | 'listing.sold_out' | 'offer.received' ...
+  secret           TEXT NOT NULL,     -- signs deliveries
+  status           TEXT NOT NULL DEFAULT 'enabled', -- enabled | disabled
+  disabled_reason  TEXT,
+  failing_since    TIMESTAMPTZ,       -- first failure since the last success
+  version          BIGINT NOT NULL DEFAULT 1,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX webhook_endpoints_seller ON webhook_endpoints (seller_id, created_at);
+
+-- One event sent, or to be sent, to one endpoint, with the outcome of its last attempt. Endpoints
+-- are deleted without their deliveries, which stay in the log.
+CREATE TABLE webhook_deliveries (
+  id               TEXT PRIMARY KEY,  -- derived from the event and endpoint, so an event is queued once
+  endpoint_id      UUID NOT NULL,
+  seller_id        UUID NOT NULL REFERENCES users(id),
+  event_id         UUID NOT NULL,
+  event_name       TEXT NOT NULL,
+  payload          JSONB NOT NULL,
+  status           TEXT NOT NULL DEFAULT 'pending', -- pending | succeeded | failed
+  attempts         INT NOT NULL DEFAULT 0,
+  next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  response_status  INT,
+  response_body    TEXT,
+  last_error       TEXT,
+  duration_millis  BIGINT,
+  delivered_at     TIMESTAMPTZ,
+  redelivery_of    TEXT REFERENCES webhook_deliveries(id),
+  version          BIGINT NOT NULL DEFAULT 1,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at) WHERE status <> 'succeeded';
+CREATE INDEX webhook_deliveries_endpoint ON webhook_deliveries (endpoint_id, created_at DESC);
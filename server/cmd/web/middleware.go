@@ -1,15 +1,21 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/justinas/nosurf"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/apikeys"
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
 )
 
 // NoSurf adds CSRF protection to all POST requests
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	csrfHandler.ExemptGlob("/webhooks/*")               // signed by the sender instead
+	csrfHandler.ExemptGlobs("/api/v1/*", "/api/v1/*/*") // authenticated by API key, not cookies
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
@@ -40,6 +46,54 @@ func Auth(next http.Handler) http.Handler {
 	})
 }
 
+// Admin checks to see if the signed-in user is an admin
+func Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.IsAdmin(r) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIKey checks the request's bearer API key and that it has scope, and puts the key in the
+// request context for the handler
+func APIKey(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				apiError(w, http.StatusUnauthorized, "send your API key as a bearer token")
+				return
+			}
+			k, err := app.APIKeys.Authenticate(r.Context(), strings.TrimSpace(token), scope)
+			switch {
+			case errors.Is(err, apikeys.ErrUnauthorized):
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+				apiError(w, http.StatusUnauthorized, "the API key is invalid or has been revoked")
+				return
+			case errors.Is(err, apikeys.ErrForbidden):
+				apiError(w, http.StatusForbidden, "the API key doesn't have the "+scope+" scope")
+				return
+			case err != nil:
+				app.ErrorLog.Printf("API key check failed: %v", err)
+				apiError(w, http.StatusInternalServerError, "something went wrong")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(apikeys.WithKey(r.Context(), k)))
+		})
+	}
+}
+
+// apiError writes a JSON error response for the seller API
+func apiError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
 func ProxyFix(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" {
@@ -8,6 +8,8 @@ import (
 
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/config"
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/handlers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/photos"
 )
 
 // routes sets up the application's HTTP routes and middleware.
@@ -28,17 +30,164 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Post("/login", handlers.Repo.PostLogin)
 	mux.Get("/email-verification", handlers.Repo.GetEmailVerification)
 	mux.Post("/email-verification", handlers.Repo.PostEmailVerification)
+	mux.Get("/search", handlers.Repo.GetSearch)
+	mux.Get("/api/search", handlers.Repo.GetSearchJSON)
+	mux.Get("/printings/{id}", handlers.Repo.GetPrinting)
+	mux.Get("/api/printings/{id}/prices", handlers.Repo.GetPrintingPricesJSON)
+	mux.Get("/products/{id}", handlers.Repo.GetProduct)
+	mux.Get("/api/products/{id}/prices", handlers.Repo.GetProductPricesJSON)
+	mux.Get("/sellers/{id}", handlers.Repo.GetSellerReviews)
+	mux.Get("/auctions", handlers.Repo.GetAuctions)
+	mux.Get("/auctions/{id}", handlers.Repo.GetAuction)
+	mux.Get("/cart", handlers.Repo.GetCart)
+	mux.Post("/cart/add", handlers.Repo.PostCartAdd)
+	mux.Post("/cart/update", handlers.Repo.PostCartUpdate)
+	mux.Post("/cart/remove", handlers.Repo.PostCartRemove)
+	mux.Post("/cart/refresh", handlers.Repo.PostCartRefresh)
+	mux.Post("/webhooks/stripe", handlers.Repo.PostStripeWebhook)
+	mux.Post("/webhooks/tracking", handlers.Repo.PostTrackingWebhook)
+
+	// Seller API, for sellers' own systems; authenticated by API key rather than the session
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.With(APIKey(models.ScopeListingsRead)).Get("/listings", handlers.Repo.GetAPIListings)
+		mux.With(APIKey(models.ScopeListingsWrite)).Patch("/listings/{id}", handlers.Repo.PatchAPIListing)
+		mux.With(APIKey(models.ScopeOrdersRead)).Get("/orders", handlers.Repo.GetAPIOrders)
+	})
 
 	// Protected routes (require authentication)
 	mux.Route("/", func(mux chi.Router) {
 		mux.Use(Auth) // Authentication middleware
 		mux.Get("/dashboard", handlers.Repo.GetBuyerDashboard)
 		mux.Get("/logout", handlers.Repo.GetLogout)
+
+		mux.Get("/checkout", handlers.Repo.GetCheckout)
+		mux.Post("/checkout", handlers.Repo.PostCheckout)
+		mux.Get("/checkout/{id}/pay", handlers.Repo.GetCheckoutPay)
+		mux.Post("/checkout/{id}/simulate", handlers.Repo.PostCheckoutSimulate)
+		mux.Get("/checkout/{id}/complete", handlers.Repo.GetCheckoutComplete)
+		mux.Get("/orders", handlers.Repo.GetBuyerOrders)
+		mux.Get("/orders/{id}", handlers.Repo.GetBuyerOrder)
+		mux.Post("/orders/{id}/cancel", handlers.Repo.PostBuyerOrderCancel)
+		mux.Post("/orders/{id}/received", handlers.Repo.PostBuyerOrderReceived)
+		mux.Get("/orders/{id}/dispute", handlers.Repo.GetOrderDispute)
+		mux.Post("/orders/{id}/dispute", handlers.Repo.PostOrderDispute)
+		mux.Get("/disputes/{id}", handlers.Repo.GetDispute)
+		mux.Post("/disputes/{id}/messages", handlers.Repo.PostDisputeMessage)
+		mux.Post("/disputes/{id}/escalate", handlers.Repo.PostDisputeEscalate)
+		mux.Post("/disputes/{id}/resolve", handlers.Repo.PostDisputeResolve)
+		mux.Post("/disputes/{id}/return-received", handlers.Repo.PostDisputeReturnReceived)
+		mux.Get("/orders/{id}/review", handlers.Repo.GetOrderReview)
+		mux.Post("/orders/{id}/review", handlers.Repo.PostOrderReview)
+		mux.Post("/reviews/{id}/report", handlers.Repo.PostReviewReport)
+		mux.Post("/listings/{id}/offers", handlers.Repo.PostListingOffer)
+		mux.Get("/offers", handlers.Repo.GetBuyerOffers)
+		mux.Get("/offers/{id}", handlers.Repo.GetOffer)
+		mux.Post("/offers/{id}/counter", handlers.Repo.PostOfferCounter)
+		mux.Post("/offers/{id}/accept", handlers.Repo.PostOfferAccept)
+		mux.Post("/offers/{id}/decline", handlers.Repo.PostOfferDecline)
+		mux.Post("/offers/{id}/withdraw", handlers.Repo.PostOfferWithdraw)
+		mux.Post("/auctions/{id}/bids", handlers.Repo.PostAuctionBid)
+		mux.Get("/bids", handlers.Repo.GetBuyerBids)
+		mux.Get("/wants", handlers.Repo.GetWants)
+		mux.Get("/wants/new", handlers.Repo.GetWantNew)
+		mux.Post("/wants", handlers.Repo.PostWant)
+		mux.Post("/wants/{id}/remove", handlers.Repo.PostWantRemove)
+		mux.Get("/optimize", handlers.Repo.GetOptimize)
+		mux.Post("/optimize/cart", handlers.Repo.PostOptimizeCart)
+		mux.Get("/api/optimize", handlers.Repo.GetOptimizeJSON)
+		mux.Get("/decklists/import", handlers.Repo.GetDecklistImport)
+		mux.Post("/decklists/import", handlers.Repo.PostDecklistImport)
+		mux.Post("/decklists/shop", handlers.Repo.PostDecklistShop)
+		mux.Post("/decklists/wants", handlers.Repo.PostDecklistWants)
+		mux.Get("/collection", handlers.Repo.GetCollection)
+		mux.Get("/collection/new", handlers.Repo.GetCollectionNew)
+		mux.Post("/collection", handlers.Repo.PostCollection)
+		mux.Get("/collection/import", handlers.Repo.GetCollectionImport)
+		mux.Post("/collection/import", handlers.Repo.PostCollectionImport)
+		mux.Post("/collection/list", handlers.Repo.PostCollectionList)
+		mux.Post("/collection/{id}/remove", handlers.Repo.PostCollectionRemove)
+		mux.Get("/messages", handlers.Repo.GetMessages)
+		mux.Get("/messages/new", handlers.Repo.GetMessageNew)
+		mux.Post("/messages/new", handlers.Repo.PostMessageNew)
+		mux.Get("/messages/{id}", handlers.Repo.GetMessageThread)
+		mux.Post("/messages/{id}", handlers.Repo.PostMessageReply)
+		mux.Post("/messages/{id}/report", handlers.Repo.PostMessageReport)
+		mux.Post("/messages/{id}/block", handlers.Repo.PostMessageBlock)
+		mux.Post("/messages/{id}/unblock", handlers.Repo.PostMessageUnblock)
+		mux.Get("/notifications", handlers.Repo.GetNotifications)
+		mux.Get("/notifications/preferences", handlers.Repo.GetNotificationPreferences)
+		mux.Post("/notifications/preferences", handlers.Repo.PostNotificationPreferences)
+
+		mux.Get("/seller/dashboard", handlers.Repo.GetSellerDashboard)
+		mux.Get("/seller/listings", handlers.Repo.GetSellerListings)
+		mux.Get("/seller/listings/new", handlers.Repo.GetSellerListingNew)
+		mux.Post("/seller/listings", handlers.Repo.PostSellerListing)
+		mux.Get("/api/fees/preview", handlers.Repo.GetFeePreview)
+		mux.Get("/seller/payouts", handlers.Repo.GetSellerPayouts)
+		mux.Get("/seller/payouts/return", handlers.Repo.GetSellerPayoutsReturn)
+		mux.Post("/seller/payouts/connect", handlers.Repo.PostSellerPayoutsConnect)
+		mux.Post("/seller/payouts/withdraw", handlers.Repo.PostSellerPayoutsWithdraw)
+		mux.Get("/seller/shipping", handlers.Repo.GetSellerShipping)
+		mux.Post("/seller/shipping", handlers.Repo.PostSellerShipping)
+		mux.Get("/seller/orders", handlers.Repo.GetSellerOrders)
+		mux.Get("/seller/orders/{id}", handlers.Repo.GetSellerOrder)
+		mux.Post("/seller/orders/{id}/ship", handlers.Repo.PostSellerOrderShip)
+		mux.Post("/seller/orders/{id}/label", handlers.Repo.PostSellerOrderLabel)
+		mux.Post("/seller/orders/{id}/cancel", handlers.Repo.PostSellerOrderCancel)
+		mux.Post("/seller/orders/{id}/review/response", handlers.Repo.PostSellerReviewResponse)
+		mux.Get("/seller/offers", handlers.Repo.GetSellerOffers)
+		mux.Get("/seller/auctions", handlers.Repo.GetSellerAuctions)
+		mux.Get("/seller/listings/{id}/auction", handlers.Repo.GetSellerAuctionNew)
+		mux.Post("/seller/listings/{id}/auction", handlers.Repo.PostSellerAuction)
+		mux.Post("/seller/auctions/{id}/cancel", handlers.Repo.PostSellerAuctionCancel)
+		mux.Get("/seller/repricing", handlers.Repo.GetSellerRepricing)
+		mux.Post("/seller/repricing/rules", handlers.Repo.PostSellerRepriceRule)
+		mux.Post("/seller/repricing/rules/{id}/toggle", handlers.Repo.PostSellerRepriceRuleToggle)
+		mux.Post("/seller/repricing/rules/{id}/delete", handlers.Repo.PostSellerRepriceRuleDelete)
+		mux.Post("/seller/repricing/rules/{id}/rollback", handlers.Repo.PostSellerRepriceRuleRollback)
+		mux.Post("/seller/repricing/preview", handlers.Repo.PostSellerRepricePreview)
+		mux.Post("/seller/repricing/apply", handlers.Repo.PostSellerRepriceApply)
+		mux.Post("/seller/repricing/rollback", handlers.Repo.PostSellerRepriceRollback)
+		mux.Get("/seller/repricing/runs/{id}", handlers.Repo.GetSellerRepriceRun)
+		mux.Get("/seller/integrations", handlers.Repo.GetSellerIntegrations)
+		mux.Post("/seller/api-keys", handlers.Repo.PostSellerAPIKey)
+		mux.Post("/seller/api-keys/{id}/revoke", handlers.Repo.PostSellerAPIKeyRevoke)
+		mux.Post("/seller/webhooks", handlers.Repo.PostSellerWebhook)
+		mux.Get("/seller/webhooks/{id}", handlers.Repo.GetSellerWebhook)
+		mux.Post("/seller/webhooks/{id}/toggle", handlers.Repo.PostSellerWebhookToggle)
+		mux.Post("/seller/webhooks/{id}/roll", handlers.Repo.PostSellerWebhookRoll)
+		mux.Post("/seller/webhooks/{id}/delete", handlers.Repo.PostSellerWebhookDelete)
+		mux.Post("/seller/webhooks/{id}/deliveries/{deliveryID}/redeliver", handlers.Repo.PostSellerWebhookRedeliver)
+
+		mux.Route("/admin", func(mux chi.Router) {
+			mux.Use(Admin)
+			mux.Get("/ledger", handlers.Repo.GetAdminLedger)
+			mux.Get("/disputes", handlers.Repo.GetAdminDisputes)
+			mux.Get("/reviews", handlers.Repo.GetAdminReviews)
+			mux.Post("/reviews/{id}/moderate", handlers.Repo.PostAdminReviewModerate)
+			mux.Get("/messages", handlers.Repo.GetAdminMessages)
+			mux.Post("/messages/{id}/moderate", handlers.Repo.PostAdminMessageModerate)
+			mux.Get("/notifications", handlers.Repo.GetAdminNotifications)
+			mux.Post("/notifications/{id}/retry", handlers.Repo.PostAdminNotificationRetry)
+			mux.Get("/jobs", handlers.Repo.GetAdminJobs)
+			mux.Post("/jobs/{id}/revive", handlers.Repo.PostAdminJobRevive)
+			mux.Get("/events", handlers.Repo.GetAdminEvents)
+			mux.Post("/events/{id}/retry", handlers.Repo.PostAdminEventRetry)
+			mux.Get("/fees", handlers.Repo.GetAdminFees)
+			mux.Post("/fees", handlers.Repo.PostAdminFees)
+			mux.Get("/catalog/import", handlers.Repo.GetAdminCatalogImport)
+			mux.Post("/catalog/import", handlers.Repo.PostAdminCatalogImport)
+		})
 	})
 
 	// Serve static files from the ./static directory
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	// Serve uploaded photos when they are kept on local disk
+	if disk, ok := app.Photos.(*photos.DiskStorage); ok {
+		mux.Handle("/media/*", http.StripPrefix("/media", http.FileServer(http.Dir(disk.Dir()))))
+	}
+
 	return mux
 }
@@ -0,0 +1,27 @@
+// Command worker runs background jobs without serving HTTP. It takes the same flags as the web
+// server; run the web server with -jobs=false when workers run the jobs instead.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/bootstrap"
+)
+
+func main() {
+	app, err := bootstrap.App()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app.InfoLog.Println("Running background jobs")
+	app.Jobs.Run(ctx)
+	app.InfoLog.Println("Background jobs drained, exiting")
+}
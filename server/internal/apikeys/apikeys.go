@@ -0,0 +1,253 @@
+// Package apikeys issues and checks the API keys sellers' own systems use with the seller API.
+//
+// A key looks like "tcg_<key ID>_<secret>". Only its SHA-256 hash is stored, so a key is shown to
+// the seller once, when it's created, and can't be recovered afterwards. Each key carries scopes
+// limiting what it may do, records when it was last used and can be revoked at any time.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist or belongs to another seller
+	ErrNotFound = errors.New("apikeys: not found")
+	// ErrConflict is returned when a key was changed concurrently
+	ErrConflict = errors.New("apikeys: version conflict")
+	// ErrInvalid is returned when a new key's name or scopes are unacceptable
+	ErrInvalid = errors.New("apikeys: invalid key")
+	// ErrTooMany is returned when a seller already has MaxKeys active keys
+	ErrTooMany = errors.New("apikeys: too many keys")
+	// ErrUnauthorized is returned for a key that is malformed, unknown or revoked
+	ErrUnauthorized = errors.New("apikeys: unauthorized")
+	// ErrForbidden is returned for a valid key that lacks the scope a request needs
+	ErrForbidden = errors.New("apikeys: missing scope")
+)
+
+// tokenPrefix starts every key, so leaked keys are easy to spot
+const tokenPrefix = "tcg_"
+
+// Store persists API keys.
+type Store interface {
+	Create(ctx context.Context, k models.APIKey) error
+	Get(ctx context.Context, keyID string) (models.APIKey, error)
+	// Update writes a key if its stored version is still expectedVersion
+	Update(ctx context.Context, k models.APIKey, expectedVersion int64) error
+	// ForSeller returns a seller's keys, oldest first
+	ForSeller(ctx context.Context, sellerID string) ([]models.APIKey, error)
+}
+
+// Options controls key limits and usage tracking
+type Options struct {
+	MaxKeys    int           // active keys a seller may have
+	TouchEvery time.Duration // how often a key's last used time is written while it's in use
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	MaxKeys:    10,
+	TouchEvery: time.Minute,
+}
+
+// Service issues, checks and revokes API keys.
+type Service struct {
+	store    Store
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+}
+
+// New creates an API key Service
+func New(store Store, opts Options, errorLog *log.Logger) *Service {
+	if opts.MaxKeys <= 0 {
+		opts.MaxKeys = DefaultOptions.MaxKeys
+	}
+	if opts.TouchEvery <= 0 {
+		opts.TouchEvery = DefaultOptions.TouchEvery
+	}
+	return &Service{store: store, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// Create issues a key with the given scopes. It returns the stored key and the key itself, which
+// is never available again.
+func (s *Service) Create(ctx context.Context, sellerID, name string, scopes []string) (models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return models.APIKey{}, "", fmt.Errorf("%w: give the key a name of up to 100 characters", ErrInvalid)
+	}
+	if len(scopes) == 0 {
+		return models.APIKey{}, "", fmt.Errorf("%w: choose at least one scope", ErrInvalid)
+	}
+	for _, scope := range scopes {
+		if !known(scope) {
+			return models.APIKey{}, "", fmt.Errorf("%w: unknown scope %q", ErrInvalid, scope)
+		}
+	}
+
+	existing, err := s.store.ForSeller(ctx, sellerID)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	active := 0
+	for _, k := range existing {
+		if !k.Revoked() {
+			active++
+		}
+	}
+	if active >= s.opts.MaxKeys {
+		return models.APIKey{}, "", ErrTooMany
+	}
+
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return models.APIKey{}, "", err
+	}
+	now := s.now().UTC().Format(time.RFC3339)
+	k := models.APIKey{
+		KeyID:     ids.New(),
+		SellerID:  sellerID,
+		Name:      name,
+		Scopes:    scopes,
+		Version:   1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	token := tokenPrefix + k.KeyID + "_" + hex.EncodeToString(secret[:])
+	k.Prefix = token[:len(tokenPrefix)+8]
+	k.Hash = hash(token)
+	setKeys(&k)
+
+	if err := s.store.Create(ctx, k); err != nil {
+		return models.APIKey{}, "", err
+	}
+	return k, token, nil
+}
+
+// ForSeller returns a seller's keys, revoked ones included, oldest first
+func (s *Service) ForSeller(ctx context.Context, sellerID string) ([]models.APIKey, error) {
+	return s.store.ForSeller(ctx, sellerID)
+}
+
+// Revoke stops a key working straight away
+func (s *Service) Revoke(ctx context.Context, sellerID, keyID string) (models.APIKey, error) {
+	return s.update(ctx, keyID, func(k *models.APIKey) error {
+		if k.SellerID != sellerID {
+			return ErrNotFound
+		}
+		if k.RevokedAt == "" {
+			k.RevokedAt = k.UpdatedAt
+		}
+		return nil
+	})
+}
+
+// Authenticate checks a key and that it has scope, and records that it was used. Failing to
+// record the use doesn't fail the request.
+func (s *Service) Authenticate(ctx context.Context, token, scope string) (models.APIKey, error) {
+	rest, ok := strings.CutPrefix(token, tokenPrefix)
+	if !ok {
+		return models.APIKey{}, ErrUnauthorized
+	}
+	keyID, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return models.APIKey{}, ErrUnauthorized
+	}
+
+	k, err := s.store.Get(ctx, keyID)
+	if errors.Is(err, ErrNotFound) {
+		return models.APIKey{}, ErrUnauthorized
+	}
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hash(token)), []byte(k.Hash)) != 1 || k.Revoked() {
+		return models.APIKey{}, ErrUnauthorized
+	}
+	if !k.Allows(scope) {
+		return k, ErrForbidden
+	}
+
+	now := s.now().UTC()
+	if last, err := time.Parse(time.RFC3339, k.LastUsedAt); err != nil || now.Sub(last) >= s.opts.TouchEvery {
+		touched, err := s.update(ctx, keyID, func(k *models.APIKey) error {
+			k.LastUsedAt = now.Format(time.RFC3339)
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrConflict) {
+			s.errorLog.Printf("recording use of API key %s failed: %v", keyID, err)
+		}
+		if err == nil {
+			k = touched
+		}
+	}
+	return k, nil
+}
+
+// update reads a key, applies mutate and writes it back conditional on the version read
+func (s *Service) update(ctx context.Context, keyID string, mutate func(*models.APIKey) error) (models.APIKey, error) {
+	k, err := s.store.Get(ctx, keyID)
+	if err != nil {
+		return k, err
+	}
+
+	expected := k.Version
+	k.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	if err := mutate(&k); err != nil {
+		return k, err
+	}
+	k.Version++
+
+	if err := s.store.Update(ctx, k, expected); err != nil {
+		return k, err
+	}
+	return k, nil
+}
+
+// known reports whether a scope is one keys may have
+func known(scope string) bool {
+	for _, s := range models.APIScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hash returns the hex SHA-256 a key is stored as
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// setKeys fills in the single-table keys for an API key
+func setKeys(k *models.APIKey) {
+	k.PK, k.SK = models.APIKeyKey(k.KeyID)
+	k.GSI1PK, k.GSI1SK = models.SellerAPIKeyKey(k.SellerID, k.CreatedAt, k.KeyID)
+	k.Type = models.ItemTypeAPIKey
+}
+
+// contextKey is the type of the request context key holding the authenticated API key
+type contextKey struct{}
+
+// WithKey returns a context carrying the key a request was authenticated with
+func WithKey(ctx context.Context, k models.APIKey) context.Context {
+	return context.WithValue(ctx, contextKey{}, k)
+}
+
+// FromContext returns the key a request was authenticated with
+func FromContext(ctx context.Context) (models.APIKey, bool) {
+	k, ok := ctx.Value(contextKey{}).(models.APIKey)
+	return k, ok
+}
@@ -0,0 +1,57 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Create stores a new key
+func (s *DynamoStore) Create(ctx context.Context, k models.APIKey) error {
+	return s.put(ctx, k, dynamo.IfNotExists())
+}
+
+// Get returns a key by ID
+func (s *DynamoStore) Get(ctx context.Context, keyID string) (models.APIKey, error) {
+	var k models.APIKey
+	pk, sk := models.APIKeyKey(keyID)
+	err := s.table.Get(ctx, pk, sk, &k)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return k, ErrNotFound
+	}
+	return k, err
+}
+
+// Update writes a key if its stored version is still expectedVersion
+func (s *DynamoStore) Update(ctx context.Context, k models.APIKey, expectedVersion int64) error {
+	return s.put(ctx, k, dynamo.IfVersion(expectedVersion))
+}
+
+// ForSeller returns a seller's keys, oldest first
+func (s *DynamoStore) ForSeller(ctx context.Context, sellerID string) ([]models.APIKey, error) {
+	var out []models.APIKey
+	pk, _ := models.SellerAPIKeyKey(sellerID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, SKPrefix: "APIKEY#"}, &out)
+	return out, err
+}
+
+// put writes a key, mapping a failed condition to ErrConflict
+func (s *DynamoStore) put(ctx context.Context, k models.APIKey, cond dynamo.Condition) error {
+	err := s.table.Put(ctx, k, cond)
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
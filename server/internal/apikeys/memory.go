@@ -0,0 +1,72 @@
+package apikeys
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	keys map[string]models.APIKey // keyID -> key
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: map[string]models.APIKey{}}
+}
+
+// Create stores a new key
+func (s *MemoryStore) Create(ctx context.Context, k models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[k.KeyID]; ok {
+		return ErrConflict
+	}
+	s.keys[k.KeyID] = k
+	return nil
+}
+
+// Get returns a key
+func (s *MemoryStore) Get(ctx context.Context, keyID string) (models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[keyID]
+	if !ok {
+		return k, ErrNotFound
+	}
+	return k, nil
+}
+
+// Update writes a key if its stored version is still expectedVersion
+func (s *MemoryStore) Update(ctx context.Context, k models.APIKey, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.keys[k.KeyID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.keys[k.KeyID] = k
+	return nil
+}
+
+// ForSeller returns a seller's keys, oldest first
+func (s *MemoryStore) ForSeller(ctx context.Context, sellerID string) ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pk, _ := models.SellerAPIKeyKey(sellerID, "", "")
+	var out []models.APIKey
+	for _, k := range s.keys {
+		if k.GSI1PK == pk {
+			out = append(out, k)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK < out[j].GSI1SK })
+	return out, nil
+}
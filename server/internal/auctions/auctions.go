@@ -0,0 +1,562 @@
+// Package auctions runs timed auctions of single cards with proxy bidding.
+//
+// A seller puts one copy of a listing up with a start price, an optional reserve, a bid increment
+// and a duration; the copy is taken out of stock while the auction runs. Each bidder leaves a
+// maximum and the auction bids on their behalf, one increment at a time, so the price only rises as
+// far as the second-highest maximum. A bid in the final minutes pushes the close time back so
+// nobody can snipe. When the auction ends a background closer turns the winning bid into an order
+// awaiting payment, or puts the copy back on sale if there was no winner.
+package auctions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+)
+
+var (
+	// ErrNotFound is returned when an auction or proxy bid does not exist
+	ErrNotFound = errors.New("auctions: not found")
+	// ErrConflict is returned when an auction was changed concurrently
+	ErrConflict = errors.New("auctions: version conflict")
+	// ErrNotAllowed is returned when the user may not take the action
+	ErrNotAllowed = errors.New("auctions: not allowed")
+	// ErrClosed is returned when bidding on or changing an auction that has ended
+	ErrClosed = errors.New("auctions: auction has ended")
+	// ErrUnavailable is returned when the listing has no copy to put up for auction
+	ErrUnavailable = errors.New("auctions: listing has no copies available to auction")
+	// ErrBidTooLow is returned when a bid doesn't reach the minimum
+	ErrBidTooLow = errors.New("auctions: bid too low")
+	// ErrInvalid is returned for bad auction terms or a bid without a shipping address
+	ErrInvalid = errors.New("auctions: invalid auction")
+)
+
+// bidAttempts bounds how often a bid is retried when other bids land first
+const bidAttempts = 50
+
+// Store persists auctions, proxy bids and bid history.
+type Store interface {
+	Create(ctx context.Context, a models.Auction) error
+	Get(ctx context.Context, auctionID string) (models.Auction, error)
+	// Update writes an auction conditional on expectedVersion
+	Update(ctx context.Context, a models.Auction, expectedVersion int64) error
+	// PlaceBid writes an auction conditional on expectedVersion, together with the bidder's proxy
+	// and the bid's history entry, in one transaction
+	PlaceBid(ctx context.Context, a models.Auction, expectedVersion int64, p models.AuctionProxy, b models.AuctionBid) error
+	// ByStatus returns the auctions in a status, soonest ending first
+	ByStatus(ctx context.Context, status string) ([]models.Auction, error)
+	// BySeller returns a seller's auctions, newest first
+	BySeller(ctx context.Context, sellerID string) ([]models.Auction, error)
+	Proxy(ctx context.Context, auctionID, bidderID string) (models.AuctionProxy, error)
+	// ProxiesByBidder returns a buyer's proxy bids, newest first
+	ProxiesByBidder(ctx context.Context, bidderID string) ([]models.AuctionProxy, error)
+	// Bids returns an auction's bid history, newest first
+	Bids(ctx context.Context, auctionID string) ([]models.AuctionBid, error)
+}
+
+// Options controls auction durations and anti-sniping
+type Options struct {
+	MinDuration  time.Duration
+	MaxDuration  time.Duration
+	ExtendWithin time.Duration // a bid this close to the end extends the auction...
+	ExtendBy     time.Duration // ...so it ends this long after the bid
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	MinDuration:  time.Hour,
+	MaxDuration:  14 * 24 * time.Hour,
+	ExtendWithin: 2 * time.Minute,
+	ExtendBy:     2 * time.Minute,
+}
+
+// Terms are what a seller chooses when starting an auction
+type Terms struct {
+	StartCents     int64
+	ReserveCents   int64 // zero means no reserve
+	IncrementCents int64 // zero means the default tiered increments
+	Duration       time.Duration
+}
+
+// Bid is a bidder's maximum, with where the card ships if they win. ShipTo and ShippingMethod can
+// be left empty on later bids to keep the ones given before.
+type Bid struct {
+	BidderID       string
+	MaxCents       int64
+	ShipTo         models.Address
+	ShippingMethod string
+}
+
+// Service runs auctions and closes them when they end.
+type Service struct {
+	store    Store
+	catalog  *catalog.Catalog
+	orders   *orders.Service
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+}
+
+// New creates an auctions Service
+func New(store Store, c *catalog.Catalog, o *orders.Service, opts Options, errorLog *log.Logger) *Service {
+	if opts.MinDuration <= 0 {
+		opts.MinDuration = DefaultOptions.MinDuration
+	}
+	if opts.MaxDuration <= 0 {
+		opts.MaxDuration = DefaultOptions.MaxDuration
+	}
+	if opts.ExtendWithin <= 0 {
+		opts.ExtendWithin = DefaultOptions.ExtendWithin
+	}
+	if opts.ExtendBy <= 0 {
+		opts.ExtendBy = DefaultOptions.ExtendBy
+	}
+	return &Service{store: store, catalog: c, orders: o, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// DefaultIncrement is the bid increment at a price when the seller didn't set one
+func DefaultIncrement(priceCents int64) int64 {
+	switch {
+	case priceCents < 1000:
+		return 25
+	case priceCents < 5000:
+		return 100
+	case priceCents < 25000:
+		return 250
+	case priceCents < 100000:
+		return 500
+	}
+	return 1000
+}
+
+// Increment returns the step the auction's price rises by from its current price
+func Increment(a models.Auction) int64 {
+	if a.IncrementCents > 0 {
+		return a.IncrementCents
+	}
+	return DefaultIncrement(a.CurrentCents)
+}
+
+// MinBid returns the lowest maximum a new bidder may leave
+func MinBid(a models.Auction) int64 {
+	if a.LeaderID == "" {
+		return a.StartCents
+	}
+	return a.CurrentCents + Increment(a)
+}
+
+// Start puts one copy of a seller's listing up for auction, taking it out of stock
+func (s *Service) Start(ctx context.Context, sellerID, listingID string, t Terms) (models.Auction, error) {
+	if err := s.validate(t); err != nil {
+		return models.Auction{}, err
+	}
+
+	l, err := s.catalog.Listing(ctx, listingID)
+	if errors.Is(err, catalog.ErrNotFound) {
+		return models.Auction{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Auction{}, err
+	}
+	if l.SellerID != sellerID {
+		return models.Auction{}, ErrNotAllowed
+	}
+	if l.IsSealed() {
+		return models.Auction{}, fmt.Errorf("%w: only single cards can be auctioned", ErrInvalid)
+	}
+
+	quantities := map[string]int{listingID: 1}
+	if _, err := s.catalog.Reserve(ctx, quantities); errors.Is(err, catalog.ErrInsufficientStock) {
+		return models.Auction{}, ErrUnavailable
+	} else if err != nil {
+		return models.Auction{}, err
+	}
+
+	now := s.now().UTC()
+	at := now.Format(time.RFC3339)
+	a := models.Auction{
+		AuctionID:      ids.New(),
+		ListingID:      listingID,
+		SellerID:       sellerID,
+		StartCents:     t.StartCents,
+		ReserveCents:   t.ReserveCents,
+		IncrementCents: t.IncrementCents,
+		CurrentCents:   t.StartCents,
+		Status:         models.AuctionStatusOpen,
+		StartsAt:       at,
+		EndsAt:         now.Add(t.Duration).Format(time.RFC3339),
+		Version:        1,
+		CreatedAt:      at,
+		UpdatedAt:      at,
+	}
+	setKeys(&a)
+
+	if err := s.store.Create(ctx, a); err != nil {
+		s.release(ctx, a)
+		return a, err
+	}
+	return a, nil
+}
+
+// Get returns an auction
+func (s *Service) Get(ctx context.Context, auctionID string) (models.Auction, error) {
+	return s.store.Get(ctx, auctionID)
+}
+
+// Open returns the running auctions, soonest ending first
+func (s *Service) Open(ctx context.Context) ([]models.Auction, error) {
+	return s.store.ByStatus(ctx, models.AuctionStatusOpen)
+}
+
+// ForSeller returns a seller's auctions, newest first
+func (s *Service) ForSeller(ctx context.Context, sellerID string) ([]models.Auction, error) {
+	return s.store.BySeller(ctx, sellerID)
+}
+
+// ForBidder returns the auctions a buyer has bid on, most recently entered first
+func (s *Service) ForBidder(ctx context.Context, bidderID string) ([]models.Auction, error) {
+	proxies, err := s.store.ProxiesByBidder(ctx, bidderID)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]models.Auction, 0, len(proxies))
+	for _, p := range proxies {
+		a, err := s.store.Get(ctx, p.AuctionID)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// Proxy returns a bidder's standing maximum on an auction
+func (s *Service) Proxy(ctx context.Context, auctionID, bidderID string) (models.AuctionProxy, error) {
+	return s.store.Proxy(ctx, auctionID, bidderID)
+}
+
+// Bids returns an auction's public bid history, newest first
+func (s *Service) Bids(ctx context.Context, auctionID string) ([]models.AuctionBid, error) {
+	return s.store.Bids(ctx, auctionID)
+}
+
+// PlaceBid records a bidder's maximum and bids on their behalf. Bids on one auction are accepted
+// one at a time: each is a conditional write on the auction's version, and a bid that loses the
+// race is re-evaluated against the auction as the winning bid left it.
+func (s *Service) PlaceBid(ctx context.Context, auctionID string, bid Bid) (models.Auction, error) {
+	for attempt := 0; attempt < bidAttempts; attempt++ {
+		a, err := s.store.Get(ctx, auctionID)
+		if err != nil {
+			return a, err
+		}
+		now := s.now().UTC()
+		if a.Status != models.AuctionStatusOpen || a.EndsAt <= now.Format(time.RFC3339) {
+			return a, ErrClosed
+		}
+		if a.SellerID == bid.BidderID {
+			return a, ErrNotAllowed
+		}
+
+		p, err := s.store.Proxy(ctx, auctionID, bid.BidderID)
+		if errors.Is(err, ErrNotFound) {
+			if bid.ShipTo.Line1 == "" || bid.ShippingMethod == "" {
+				return a, fmt.Errorf("%w: add a shipping address with your first bid", ErrInvalid)
+			}
+			a.Bidders++
+			p = models.AuctionProxy{
+				AuctionID: auctionID,
+				BidderID:  bid.BidderID,
+				Alias:     fmt.Sprintf("Bidder %d", a.Bidders),
+				CreatedAt: now.Format(time.RFC3339),
+			}
+		} else if err != nil {
+			return a, err
+		}
+		if bid.ShipTo.Line1 != "" && bid.ShippingMethod != "" {
+			p.ShipTo = bid.ShipTo
+			p.ShippingMethod = bid.ShippingMethod
+		}
+
+		expected := a.Version
+		if err := raise(&a, p, bid.MaxCents); err != nil {
+			return a, err
+		}
+		p.MaxCents = bid.MaxCents
+
+		// anti-sniping: a late bid pushes the close back so others get a chance to answer
+		extended := false
+		if ends, err := time.Parse(time.RFC3339, a.EndsAt); err == nil && ends.Sub(now) < s.opts.ExtendWithin {
+			if later := now.Add(s.opts.ExtendBy); later.After(ends) {
+				a.EndsAt = later.Format(time.RFC3339)
+				a.Extensions++
+				extended = true
+			}
+		}
+
+		at := now.Format(time.RFC3339)
+		a.BidCount++
+		a.Version++
+		a.UpdatedAt = at
+		setKeys(&a)
+		p.UpdatedAt = at
+		p.PK, p.SK = models.AuctionProxyKey(auctionID, p.BidderID)
+		p.GSI1PK, p.GSI1SK = models.BidderProxyKey(p.BidderID, p.CreatedAt, auctionID)
+		p.Type = models.ItemTypeProxy
+
+		b := models.AuctionBid{
+			AuctionID:   auctionID,
+			BidderID:    p.BidderID,
+			Alias:       p.Alias,
+			PriceCents:  a.CurrentCents,
+			LeaderAlias: a.LeaderAlias,
+			Extended:    extended,
+			At:          at,
+			Type:        models.ItemTypeBid,
+		}
+		b.PK, b.SK = models.AuctionBidKey(auctionID, at, a.Version)
+
+		err = s.store.PlaceBid(ctx, a, expected, p, b)
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return a, err
+		}
+		return a, nil
+	}
+	return models.Auction{}, ErrConflict
+}
+
+// raise applies a bidder's new maximum to the auction, moving the price and the lead the way
+// competing proxy bids would: the higher maximum leads, at one increment over the other, and an
+// earlier maximum wins a tie.
+func raise(a *models.Auction, p models.AuctionProxy, maxCents int64) error {
+	switch {
+	case a.LeaderID == p.BidderID:
+		if maxCents <= a.LeaderMaxCents {
+			return fmt.Errorf("%w: raise your maximum above %s", ErrBidTooLow, money.Format(a.LeaderMaxCents))
+		}
+		a.LeaderMaxCents = maxCents
+	case maxCents < MinBid(*a):
+		return fmt.Errorf("%w: bid at least %s", ErrBidTooLow, money.Format(MinBid(*a)))
+	case a.LeaderID == "":
+		a.LeaderID, a.LeaderAlias, a.LeaderMaxCents = p.BidderID, p.Alias, maxCents
+		a.CurrentCents = a.StartCents
+	case maxCents > a.LeaderMaxCents:
+		a.CurrentCents = min(maxCents, a.LeaderMaxCents+Increment(*a))
+		a.LeaderID, a.LeaderAlias, a.LeaderMaxCents = p.BidderID, p.Alias, maxCents
+	default:
+		a.CurrentCents = min(a.LeaderMaxCents, maxCents+Increment(*a))
+	}
+
+	// a leader whose maximum clears the reserve is bid straight up to it
+	if a.CurrentCents < a.ReserveCents && a.LeaderMaxCents >= a.ReserveCents {
+		a.CurrentCents = a.ReserveCents
+	}
+	return nil
+}
+
+// Cancel ends an auction nobody has bid on yet and puts the copy back on sale
+func (s *Service) Cancel(ctx context.Context, auctionID, sellerID string) (models.Auction, error) {
+	a, err := s.store.Get(ctx, auctionID)
+	if err != nil {
+		return a, err
+	}
+	if a.SellerID != sellerID {
+		return a, ErrNotAllowed
+	}
+	if a.Status != models.AuctionStatusOpen {
+		return a, ErrClosed
+	}
+	if a.BidCount > 0 {
+		return a, fmt.Errorf("%w: the auction already has bids", ErrNotAllowed)
+	}
+
+	a, err = s.update(ctx, a, func(a *models.Auction) {
+		a.Status = models.AuctionStatusCancelled
+		a.Note = "cancelled by seller"
+	})
+	if err != nil {
+		return a, err
+	}
+	s.release(ctx, a)
+	return a, nil
+}
+
+// CloseDue closes every open auction whose end has passed, returning how many it closed
+func (s *Service) CloseDue(ctx context.Context) (int, error) {
+	list, err := s.store.ByStatus(ctx, models.AuctionStatusOpen)
+	if err != nil {
+		return 0, err
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	closed := 0
+	for _, a := range list {
+		if a.EndsAt > now {
+			break
+		}
+		err := s.close(ctx, a)
+		switch {
+		case errors.Is(err, ErrConflict):
+			// a last-second bid landed first; it may have extended the auction
+		case err != nil:
+			return closed, err
+		default:
+			closed++
+		}
+	}
+	return closed, nil
+}
+
+// Run closes due auctions every interval until ctx is done
+func (s *Service) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := s.CloseDue(ctx); err != nil {
+			s.errorLog.Printf("closing auctions failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// close settles an ended auction. The status is written first, conditional on the version the
+// closer saw, so a bid that lands at the same moment either wins the race or is turned away.
+func (s *Service) close(ctx context.Context, a models.Auction) error {
+	sold := a.ReserveMet()
+	a, err := s.update(ctx, a, func(a *models.Auction) {
+		switch {
+		case sold:
+			a.Status = models.AuctionStatusSold
+		case a.LeaderID == "":
+			a.Status, a.Note = models.AuctionStatusUnsold, "no bids"
+		default:
+			a.Status, a.Note = models.AuctionStatusUnsold, "reserve not met"
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !sold {
+		s.release(ctx, a)
+		return nil
+	}
+
+	orderID, err := s.checkout(ctx, a)
+	if err != nil {
+		s.errorLog.Printf("creating the order for auction %s failed: %v", a.AuctionID, err)
+		a, err = s.update(ctx, a, func(a *models.Auction) {
+			a.Status, a.Note = models.AuctionStatusUnsold, "the winning bid couldn't be turned into an order"
+		})
+		if err != nil {
+			return err
+		}
+		s.release(ctx, a)
+		return nil
+	}
+
+	_, err = s.update(ctx, a, func(a *models.Auction) {
+		a.OrderID = orderID
+	})
+	return err
+}
+
+// checkout turns the winning bid into an order awaiting payment, shipped where the winner asked
+func (s *Service) checkout(ctx context.Context, a models.Auction) (string, error) {
+	p, err := s.store.Proxy(ctx, a.AuctionID, a.LeaderID)
+	if err != nil {
+		return "", err
+	}
+	l, err := s.catalog.Listing(ctx, a.ListingID)
+	if err != nil {
+		return "", err
+	}
+	pr, err := s.catalog.Printing(ctx, l.PrintingID)
+	if err != nil {
+		return "", err
+	}
+
+	// the copy was taken out of stock when the auction started, so it checks out as a held line
+	line := cart.Line{
+		Item: models.CartItem{
+			ListingID:  a.ListingID,
+			Quantity:   1,
+			PriceCents: a.CurrentCents,
+			AddedAt:    a.UpdatedAt,
+			AuctionID:  a.AuctionID,
+		},
+		Listing:        l,
+		Printing:       pr,
+		LineTotalCents: a.CurrentCents,
+	}
+	view := cart.View{
+		Groups:     []cart.SellerGroup{{SellerID: a.SellerID, Lines: []cart.Line{line}, SubtotalCents: a.CurrentCents}},
+		TotalCents: a.CurrentCents,
+		ItemCount:  1,
+	}
+	ship := orders.Shipment{Address: p.ShipTo, Methods: map[string]string{a.SellerID: p.ShippingMethod}}
+
+	created, err := s.orders.Checkout(ctx, a.LeaderID, view, ship)
+	if err != nil {
+		return "", err
+	}
+	return created[0].OrderID, nil
+}
+
+// release puts an auction's copy back on sale
+func (s *Service) release(ctx context.Context, a models.Auction) {
+	if _, err := s.catalog.Release(ctx, map[string]int{a.ListingID: 1}); err != nil {
+		s.errorLog.Printf("releasing the copy for auction %s failed: %v", a.AuctionID, err)
+	}
+}
+
+// update applies a change to an auction and writes it conditional on its version
+func (s *Service) update(ctx context.Context, a models.Auction, mutate func(*models.Auction)) (models.Auction, error) {
+	expected := a.Version
+	mutate(&a)
+	a.Version++
+	a.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	setKeys(&a)
+
+	if err := s.store.Update(ctx, a, expected); err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// validate checks a seller's terms
+func (s *Service) validate(t Terms) error {
+	switch {
+	case t.StartCents <= 0:
+		return fmt.Errorf("%w: start price must be more than zero", ErrInvalid)
+	case t.ReserveCents < 0 || (t.ReserveCents > 0 && t.ReserveCents < t.StartCents):
+		return fmt.Errorf("%w: reserve can't be below the start price", ErrInvalid)
+	case t.IncrementCents < 0:
+		return fmt.Errorf("%w: bid increment can't be negative", ErrInvalid)
+	case t.Duration < s.opts.MinDuration || t.Duration > s.opts.MaxDuration:
+		return fmt.Errorf("%w: choose one of the offered durations", ErrInvalid)
+	}
+	return nil
+}
+
+// setKeys fills in the single-table keys for an auction
+func setKeys(a *models.Auction) {
+	a.PK, a.SK = models.AuctionKey(a.AuctionID)
+	a.GSI1PK, a.GSI1SK = models.AuctionStatusKey(a.Status, a.EndsAt, a.AuctionID)
+	a.GSI2PK, a.GSI2SK = models.SellerAuctionKey(a.SellerID, a.CreatedAt, a.AuctionID)
+	a.Type = models.ItemTypeAuction
+}
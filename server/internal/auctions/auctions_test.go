@@ -0,0 +1,356 @@
+package auctions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+)
+
+// clock is a settable time source for the service
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
+
+// countingStore counts the bids turned away because another bid changed the auction first. When
+// race is set, it runs once before the first bid is written, so that bid always loses.
+type countingStore struct {
+	*MemoryStore
+	conflicts atomic.Int64
+
+	raced atomic.Bool
+	race  func()
+}
+
+func (s *countingStore) PlaceBid(ctx context.Context, a models.Auction, expectedVersion int64, p models.AuctionProxy, b models.AuctionBid) error {
+	if s.race != nil && s.raced.CompareAndSwap(false, true) {
+		s.race()
+	}
+	err := s.MemoryStore.PlaceBid(ctx, a, expectedVersion, p, b)
+	if errors.Is(err, ErrConflict) {
+		s.conflicts.Add(1)
+	}
+	return err
+}
+
+// fixture is an auctions Service over in-memory stores with one single-card listing to auction
+type fixture struct {
+	svc     *Service
+	store   *countingStore
+	catalog *catalog.Catalog
+	orders  *orders.Service
+	clock   *clock
+	listing models.Listing
+}
+
+func newFixture(t *testing.T, opts Options) *fixture {
+	t.Helper()
+	ctx := context.Background()
+	discard := log.New(io.Discard, "", 0)
+
+	c := catalog.New(catalog.NewMemoryStore(nil))
+	p, err := c.SavePrinting(ctx, models.Printing{Game: "mtg", SetCode: "LEA", SetName: "Limited Edition Alpha", CardName: "Black Lotus", Rarity: "rare"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.SaveListing(ctx, models.Listing{SellerID: "seller", PrintingID: p.PrintingID, Condition: "NM", Language: "en", PriceCents: 5000, Quantity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fixture{
+		store:   &countingStore{MemoryStore: NewMemoryStore()},
+		catalog: c,
+		orders:  orders.New(orders.NewMemoryStore(nil), c, discard),
+		clock:   &clock{t: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		listing: l,
+	}
+	f.svc = New(f.store, c, f.orders, opts, discard)
+	f.svc.now = f.clock.now
+	return f
+}
+
+func (f *fixture) start(t *testing.T, terms Terms) models.Auction {
+	t.Helper()
+	a, err := f.svc.Start(context.Background(), "seller", f.listing.ListingID, terms)
+	if err != nil {
+		t.Fatalf("starting auction: %v", err)
+	}
+	return a
+}
+
+func bid(bidderID string, maxCents int64) Bid {
+	return Bid{
+		BidderID:       bidderID,
+		MaxCents:       maxCents,
+		ShipTo:         models.Address{Name: bidderID, Line1: "1 Main St", City: "Springfield", Region: "IL", PostalCode: "62701", Country: "US"},
+		ShippingMethod: "standard",
+	}
+}
+
+func TestPlaceBidConcurrent(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, Options{})
+	a := f.start(t, Terms{StartCents: 1000, IncrementCents: 50, Duration: 24 * time.Hour})
+
+	const bidders = 20
+	maxes := map[string]int64{}
+	for i := 0; i < bidders; i++ {
+		maxes[fmt.Sprintf("bidder-%02d", i)] = 1000 + int64(i)*100
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted = map[string]bool{}
+		begin    = make(chan struct{})
+	)
+	for id, max := range maxes {
+		wg.Add(1)
+		go func(id string, max int64) {
+			defer wg.Done()
+			<-begin
+			_, err := f.svc.PlaceBid(ctx, a.AuctionID, bid(id, max))
+			switch {
+			case err == nil:
+				mu.Lock()
+				accepted[id] = true
+				mu.Unlock()
+			case errors.Is(err, ErrBidTooLow):
+				// outbid by the time it landed
+			default:
+				t.Errorf("bid from %s: %v", id, err)
+			}
+		}(id, max)
+	}
+	close(begin)
+	wg.Wait()
+
+	a, err := f.svc.Get(ctx, a.AuctionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top := "bidder-19"
+	if a.LeaderID != top || a.LeaderMaxCents != maxes[top] {
+		t.Fatalf("leader is %s at max %d, want %s at %d", a.LeaderID, a.LeaderMaxCents, top, maxes[top])
+	}
+	if !accepted[top] {
+		t.Fatalf("the highest maximum was turned away")
+	}
+
+	// the price settles one increment over the best maximum the winner beat, as proxy bidding would
+	var runnerUp int64
+	for id := range accepted {
+		if id != top && maxes[id] > runnerUp {
+			runnerUp = maxes[id]
+		}
+	}
+	if want := min(maxes[top], runnerUp+50); a.CurrentCents != want {
+		t.Fatalf("price is %d, want %d", a.CurrentCents, want)
+	}
+
+	if a.BidCount != len(accepted) || a.Version != int64(1+len(accepted)) {
+		t.Fatalf("bid count %d and version %d after %d accepted bids", a.BidCount, a.Version, len(accepted))
+	}
+
+	history, err := f.svc.Bids(ctx, a.AuctionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(accepted) {
+		t.Fatalf("%d bids in history, want %d", len(history), len(accepted))
+	}
+	for i := len(history) - 1; i > 0; i-- {
+		if history[i-1].PriceCents < history[i].PriceCents {
+			t.Fatalf("price fell from %d to %d", history[i].PriceCents, history[i-1].PriceCents)
+		}
+	}
+	t.Logf("%d of %d bids accepted, %d retried after losing a race", len(accepted), bidders, f.store.conflicts.Load())
+}
+
+func TestPlaceBidRetriesAfterConflict(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, Options{})
+	a := f.start(t, Terms{StartCents: 1000, IncrementCents: 100, Duration: 24 * time.Hour})
+
+	// another bid lands between the first bid reading the auction and writing it
+	f.store.race = func() {
+		if _, err := f.svc.PlaceBid(ctx, a.AuctionID, bid("early", 1500)); err != nil {
+			t.Errorf("racing bid: %v", err)
+		}
+	}
+
+	got, err := f.svc.PlaceBid(ctx, a.AuctionID, bid("late", 3000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.store.conflicts.Load(); n != 1 {
+		t.Fatalf("%d conflicts, want 1", n)
+	}
+	// re-evaluated against the racing bid: it leads and the price sits one increment over 1500
+	if got.LeaderID != "late" || got.CurrentCents != 1600 || got.BidCount != 2 || got.Version != 3 {
+		t.Fatalf("after retry: leader %s, price %d, %d bids, version %d", got.LeaderID, got.CurrentCents, got.BidCount, got.Version)
+	}
+}
+
+func TestLateBidExtendsAuction(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, Options{ExtendWithin: 2 * time.Minute, ExtendBy: 2 * time.Minute})
+	opened := f.clock.now()
+	a := f.start(t, Terms{StartCents: 1000, Duration: time.Hour})
+	ends := opened.Add(time.Hour)
+
+	f.clock.set(opened.Add(30 * time.Minute))
+	a, err := f.svc.PlaceBid(ctx, a.AuctionID, bid("early", 1200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Extensions != 0 || a.EndsAt != ends.Format(time.RFC3339) {
+		t.Fatalf("an early bid moved the end to %s", a.EndsAt)
+	}
+
+	lateAt := ends.Add(-time.Minute)
+	f.clock.set(lateAt)
+	a, err = f.svc.PlaceBid(ctx, a.AuctionID, bid("sniper", 2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended := lateAt.Add(2 * time.Minute)
+	if a.Extensions != 1 || a.EndsAt != extended.Format(time.RFC3339) {
+		t.Fatalf("late bid: %d extensions, ends %s, want 1 and %s", a.Extensions, a.EndsAt, extended.Format(time.RFC3339))
+	}
+	history, err := f.svc.Bids(ctx, a.AuctionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !history[0].Extended {
+		t.Fatal("the late bid isn't marked as extending the auction")
+	}
+
+	// the original end has passed but the auction runs on
+	midAt := ends.Add(30 * time.Second)
+	f.clock.set(midAt)
+	if n, err := f.svc.CloseDue(ctx); err != nil || n != 0 {
+		t.Fatalf("closed %d (%v) before the extended end", n, err)
+	}
+	// a bid inside the extension extends it again
+	again, err := f.svc.PlaceBid(ctx, a.AuctionID, bid("early", 2500))
+	if err != nil {
+		t.Fatalf("bid during the extension: %v", err)
+	}
+	reextended := midAt.Add(2 * time.Minute)
+	if again.Extensions != 2 || again.EndsAt != reextended.Format(time.RFC3339) {
+		t.Fatalf("second late bid: %d extensions, ends %s, want 2 and %s", again.Extensions, again.EndsAt, reextended.Format(time.RFC3339))
+	}
+
+	f.clock.set(reextended.Add(time.Second))
+	if _, err := f.svc.PlaceBid(ctx, a.AuctionID, bid("sniper", 4000)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("bid after the end: %v, want ErrClosed", err)
+	}
+}
+
+func TestCloseChecksOutWinner(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, Options{})
+	opened := f.clock.now()
+	a := f.start(t, Terms{StartCents: 1000, ReserveCents: 1500, IncrementCents: 100, Duration: time.Hour})
+
+	if _, err := f.svc.PlaceBid(ctx, a.AuctionID, bid("alice", 1400)); err != nil {
+		t.Fatal(err)
+	}
+	winner := bid("bob", 2500)
+	winner.ShippingMethod = "tracked"
+	if _, err := f.svc.PlaceBid(ctx, a.AuctionID, winner); err != nil {
+		t.Fatal(err)
+	}
+
+	f.clock.set(opened.Add(time.Hour + time.Second))
+	if n, err := f.svc.CloseDue(ctx); err != nil || n != 1 {
+		t.Fatalf("closed %d (%v), want 1", n, err)
+	}
+
+	a, err := f.svc.Get(ctx, a.AuctionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Status != models.AuctionStatusSold || a.OrderID == "" {
+		t.Fatalf("auction is %s with order %q", a.Status, a.OrderID)
+	}
+
+	o, err := f.orders.Get(ctx, a.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.BuyerID != "bob" || o.SellerID != "seller" || o.Status != models.OrderStatusPendingPayment {
+		t.Fatalf("order for %s from %s is %s", o.BuyerID, o.SellerID, o.Status)
+	}
+	if len(o.Items) != 1 || o.Items[0].AuctionID != a.AuctionID || o.Items[0].UnitPriceCents != 1500 || o.SubtotalCents != 1500 {
+		t.Fatalf("order items %+v, subtotal %d", o.Items, o.SubtotalCents)
+	}
+	if o.ShipTo != winner.ShipTo || o.ShippingMethod != "tracked" {
+		t.Fatalf("ships to %+v by %q", o.ShipTo, o.ShippingMethod)
+	}
+
+	// the copy came out of stock when the auction started and isn't taken again at checkout
+	l, err := f.catalog.Listing(ctx, f.listing.ListingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Quantity != f.listing.Quantity-1 {
+		t.Fatalf("listing has %d copies, want %d", l.Quantity, f.listing.Quantity-1)
+	}
+}
+
+func TestCloseWithoutReserveReleasesCopy(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, Options{})
+	opened := f.clock.now()
+	a := f.start(t, Terms{StartCents: 1000, ReserveCents: 5000, Duration: time.Hour})
+
+	if _, err := f.svc.PlaceBid(ctx, a.AuctionID, bid("alice", 2000)); err != nil {
+		t.Fatal(err)
+	}
+
+	f.clock.set(opened.Add(time.Hour + time.Second))
+	if n, err := f.svc.CloseDue(ctx); err != nil || n != 1 {
+		t.Fatalf("closed %d (%v), want 1", n, err)
+	}
+
+	a, err := f.svc.Get(ctx, a.AuctionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Status != models.AuctionStatusUnsold || a.OrderID != "" {
+		t.Fatalf("auction is %s with order %q", a.Status, a.OrderID)
+	}
+	l, err := f.catalog.Listing(ctx, f.listing.ListingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Quantity != f.listing.Quantity {
+		t.Fatalf("listing has %d copies, want %d back on sale", l.Quantity, f.listing.Quantity)
+	}
+}
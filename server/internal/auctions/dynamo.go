@@ -0,0 +1,105 @@
+package auctions
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. An auction's proxies and bids share its
+// partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Create stores a new auction
+func (s *DynamoStore) Create(ctx context.Context, a models.Auction) error {
+	return s.write(ctx, dynamo.PutOp(a, dynamo.IfNotExists()))
+}
+
+// Get returns an auction
+func (s *DynamoStore) Get(ctx context.Context, auctionID string) (models.Auction, error) {
+	var a models.Auction
+	pk, sk := models.AuctionKey(auctionID)
+	err := s.get(ctx, pk, sk, &a)
+	return a, err
+}
+
+// Update writes an auction if the stored version is still expectedVersion
+func (s *DynamoStore) Update(ctx context.Context, a models.Auction, expectedVersion int64) error {
+	return s.write(ctx, dynamo.PutOp(a, dynamo.IfVersion(expectedVersion)))
+}
+
+// PlaceBid writes an auction, a proxy and a bid together if the auction's version is still expectedVersion
+func (s *DynamoStore) PlaceBid(ctx context.Context, a models.Auction, expectedVersion int64, p models.AuctionProxy, b models.AuctionBid) error {
+	return s.write(ctx,
+		dynamo.PutOp(a, dynamo.IfVersion(expectedVersion)),
+		dynamo.PutOp(p, dynamo.Condition{}),
+		dynamo.PutOp(b, dynamo.Condition{}),
+	)
+}
+
+// ByStatus returns the auctions in a status, soonest ending first
+func (s *DynamoStore) ByStatus(ctx context.Context, status string) ([]models.Auction, error) {
+	var out []models.Auction
+	pk, _ := models.AuctionStatusKey(status, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk}, &out)
+	return out, err
+}
+
+// BySeller returns a seller's auctions, newest first
+func (s *DynamoStore) BySeller(ctx context.Context, sellerID string) ([]models.Auction, error) {
+	var out []models.Auction
+	pk, _ := models.SellerAuctionKey(sellerID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk, SKPrefix: "AUCTION#", Descending: true}, &out)
+	return out, err
+}
+
+// Proxy returns a bidder's proxy bid on an auction
+func (s *DynamoStore) Proxy(ctx context.Context, auctionID, bidderID string) (models.AuctionProxy, error) {
+	var p models.AuctionProxy
+	pk, sk := models.AuctionProxyKey(auctionID, bidderID)
+	err := s.get(ctx, pk, sk, &p)
+	return p, err
+}
+
+// ProxiesByBidder returns a buyer's proxy bids, newest first
+func (s *DynamoStore) ProxiesByBidder(ctx context.Context, bidderID string) ([]models.AuctionProxy, error) {
+	var out []models.AuctionProxy
+	pk, _ := models.BidderProxyKey(bidderID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, Descending: true}, &out)
+	return out, err
+}
+
+// Bids returns an auction's bid history, newest first
+func (s *DynamoStore) Bids(ctx context.Context, auctionID string) ([]models.AuctionBid, error) {
+	var out []models.AuctionBid
+	pk, _ := models.AuctionKey(auctionID)
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "BID#", Descending: true}, &out)
+	return out, err
+}
+
+// get reads an item, mapping a missing one to ErrNotFound
+func (s *DynamoStore) get(ctx context.Context, pk, sk string, out interface{}) error {
+	err := s.table.Get(ctx, pk, sk, out)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// write applies ops in one transaction, mapping a failed condition to ErrConflict
+func (s *DynamoStore) write(ctx context.Context, ops ...dynamo.Op) error {
+	err := s.table.Write(ctx, ops...)
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package auctions
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	auctions map[string]models.Auction
+	proxies  map[string]map[string]models.AuctionProxy // auction ID -> bidder ID -> proxy
+	bids     map[string][]models.AuctionBid
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		auctions: map[string]models.Auction{},
+		proxies:  map[string]map[string]models.AuctionProxy{},
+		bids:     map[string][]models.AuctionBid{},
+	}
+}
+
+// Create stores a new auction
+func (s *MemoryStore) Create(ctx context.Context, a models.Auction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.auctions[a.AuctionID]; ok {
+		return ErrConflict
+	}
+	s.auctions[a.AuctionID] = a
+	return nil
+}
+
+// Get returns an auction
+func (s *MemoryStore) Get(ctx context.Context, auctionID string) (models.Auction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.auctions[auctionID]
+	if !ok {
+		return a, ErrNotFound
+	}
+	return a, nil
+}
+
+// Update writes an auction if the stored version is still expectedVersion
+func (s *MemoryStore) Update(ctx context.Context, a models.Auction, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(a, expectedVersion)
+}
+
+// PlaceBid writes an auction, a proxy and a bid together if the auction's version is still expectedVersion
+func (s *MemoryStore) PlaceBid(ctx context.Context, a models.Auction, expectedVersion int64, p models.AuctionProxy, b models.AuctionBid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.put(a, expectedVersion); err != nil {
+		return err
+	}
+	if s.proxies[a.AuctionID] == nil {
+		s.proxies[a.AuctionID] = map[string]models.AuctionProxy{}
+	}
+	s.proxies[a.AuctionID][p.BidderID] = p
+	s.bids[a.AuctionID] = append(s.bids[a.AuctionID], b)
+	return nil
+}
+
+// ByStatus returns the auctions in a status, soonest ending first
+func (s *MemoryStore) ByStatus(ctx context.Context, status string) ([]models.Auction, error) {
+	list := s.filter(func(a models.Auction) bool { return a.Status == status })
+	sort.Slice(list, func(i, j int) bool { return list[i].GSI1SK < list[j].GSI1SK })
+	return list, nil
+}
+
+// BySeller returns a seller's auctions, newest first
+func (s *MemoryStore) BySeller(ctx context.Context, sellerID string) ([]models.Auction, error) {
+	list := s.filter(func(a models.Auction) bool { return a.SellerID == sellerID })
+	sort.Slice(list, func(i, j int) bool { return list[i].GSI2SK > list[j].GSI2SK })
+	return list, nil
+}
+
+// Proxy returns a bidder's proxy bid on an auction
+func (s *MemoryStore) Proxy(ctx context.Context, auctionID, bidderID string) (models.AuctionProxy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.proxies[auctionID][bidderID]
+	if !ok {
+		return p, ErrNotFound
+	}
+	return p, nil
+}
+
+// ProxiesByBidder returns a buyer's proxy bids, newest first
+func (s *MemoryStore) ProxiesByBidder(ctx context.Context, bidderID string) ([]models.AuctionProxy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.AuctionProxy
+	for _, byBidder := range s.proxies {
+		if p, ok := byBidder[bidderID]; ok {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK > out[j].GSI1SK })
+	return out, nil
+}
+
+// Bids returns an auction's bid history, newest first
+func (s *MemoryStore) Bids(ctx context.Context, auctionID string) ([]models.AuctionBid, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := append([]models.AuctionBid(nil), s.bids[auctionID]...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].SK > out[j].SK })
+	return out, nil
+}
+
+// put writes an auction conditional on its version; callers hold the lock
+func (s *MemoryStore) put(a models.Auction, expectedVersion int64) error {
+	cur, ok := s.auctions[a.AuctionID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.auctions[a.AuctionID] = a
+	return nil
+}
+
+// filter returns copies of the auctions matching keep
+func (s *MemoryStore) filter(keep func(models.Auction) bool) []models.Auction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Auction
+	for _, a := range s.auctions {
+		if keep(a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,646 @@
+// Package bootstrap builds the application from its flags and environment. The web server and the
+// job worker both start from it, so they run against the same services and queue. In production
+// the stores are kept in the DynamoDB table and sessions and jobs in Redis; in development
+// everything is kept in process memory.
+package bootstrap
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/redisstore"
+	"github.com/alexedwards/scs/v2"
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/apikeys"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/auctions"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cognito"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/collection"
+	appConfig "github.com/mcgigglepop/tcg-marketplace/server/internal/config"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/decklists"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/disputes"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/easypost"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/fees"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/grading"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/handlers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/hooks"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/jobs"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ledger"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/messages"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/notifications"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/offers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/optimizer"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/payments"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/photos"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/pricing"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/repricing"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/reviews"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/search"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/sellers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/shipping"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/tracking"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/wants"
+)
+
+var app appConfig.AppConfig
+var session *scs.SessionManager
+var infoLog *log.Logger
+var errorLog *log.Logger
+
+func getEnvOrExit(key string) string {
+	val := os.Getenv(key)
+	if val == "" {
+		log.Fatalf("Missing required env var: %s", key)
+	}
+	return val
+}
+
+// envOr returns an environment variable, or def when it isn't set
+func envOr(key, def string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return def
+}
+
+// App parses the flags, connects to Redis and AWS, and wires every service into the application
+// config, ready for the web server or the job runner
+func App() (*appConfig.AppConfig, error) {
+	gob.Register(map[string]int{})
+	gob.Register(models.Cart{})
+	gob.Register([]decklists.Entry{})
+	inProduction := flag.Bool("production", true, "Application is in production")
+	useCache := flag.Bool("cache", true, "Use template cache")
+
+	cognitoUserPoolID := flag.String(
+		"cognito-user-pool-id",
+		os.Getenv("COGNITO_USER_POOL_ID"),
+		"Cognito user pool ID",
+	)
+
+	cognitoClientID := flag.String(
+		"cognito-client-id",
+		os.Getenv("COGNITO_CLIENT_ID"),
+		"Cognito app client ID",
+	)
+
+	dynamoTable := flag.String(
+		"dynamodb-table",
+		os.Getenv("DYNAMODB_TABLE"),
+		"DynamoDB table every store is kept in; stores are kept in memory when empty",
+	)
+
+	stripeSecretKey := flag.String(
+		"stripe-secret-key",
+		os.Getenv("STRIPE_SECRET_KEY"),
+		"Stripe secret key; the fake payment provider is used when empty",
+	)
+
+	stripePublishableKey := flag.String(
+		"stripe-publishable-key",
+		os.Getenv("STRIPE_PUBLISHABLE_KEY"),
+		"Stripe publishable key",
+	)
+
+	stripeWebhookSecret := flag.String(
+		"stripe-webhook-secret",
+		os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		"Stripe webhook endpoint signing secret",
+	)
+
+	stripeBaseURL := flag.String(
+		"stripe-base-url",
+		payments.DefaultStripeBaseURL,
+		"Stripe API base URL",
+	)
+
+	processingBasisPoints := flag.Int64(
+		"processing-fee-bps",
+		290,
+		"What the payment processor charges per payment, in basis points of the amount",
+	)
+	processingFixedCents := flag.Int64(
+		"processing-fee-fixed",
+		30,
+		"What the payment processor charges per payment on top of the percentage, in cents",
+	)
+
+	easyPostAPIKey := flag.String(
+		"easypost-api-key",
+		os.Getenv("EASYPOST_API_KEY"),
+		"EasyPost API key for buying postage and tracking shipments",
+	)
+
+	easyPostBaseURL := flag.String(
+		"easypost-base-url",
+		easypost.DefaultBaseURL,
+		"EasyPost API base URL",
+	)
+
+	psaAPIToken := flag.String(
+		"psa-api-token",
+		os.Getenv("PSA_API_TOKEN"),
+		"PSA public API token for verifying the cert numbers on graded listings",
+	)
+
+	psaBaseURL := flag.String(
+		"psa-base-url",
+		grading.DefaultPSABaseURL,
+		"PSA public API base URL",
+	)
+
+	trackingWebhookSecret := flag.String(
+		"tracking-webhook-secret",
+		os.Getenv("TRACKING_WEBHOOK_SECRET"),
+		"Secret EasyPost signs tracker webhooks with",
+	)
+
+	autoCompleteAfter := flag.Duration(
+		"auto-complete-after",
+		tracking.DefaultOptions.CompleteAfter,
+		"How long after delivery an order completes and releases the seller's funds",
+	)
+
+	disputeWindow := flag.Duration(
+		"dispute-window",
+		disputes.DefaultOptions.Window,
+		"How long after delivery a buyer can open a dispute",
+	)
+
+	unpaidOrderTTL := flag.Duration(
+		"unpaid-order-ttl",
+		time.Hour,
+		"How long an order waits for payment before it is cancelled and its stock released",
+	)
+
+	offerTTL := flag.Duration(
+		"offer-ttl",
+		offers.DefaultOptions.TTL,
+		"How long an offer or counter-offer stays open before it expires",
+	)
+
+	auctionExtendWithin := flag.Duration(
+		"auction-extend-within",
+		auctions.DefaultOptions.ExtendWithin,
+		"A bid this close to an auction's end extends it",
+	)
+
+	auctionExtendBy := flag.Duration(
+		"auction-extend-by",
+		auctions.DefaultOptions.ExtendBy,
+		"How long after a late bid an extended auction ends",
+	)
+
+	wantDigestEvery := flag.Duration(
+		"want-digest-every",
+		wants.DefaultOptions.DigestEvery,
+		"The shortest gap between two want list digests to the same buyer",
+	)
+
+	optimizerBudget := flag.Duration(
+		"optimizer-budget",
+		optimizer.DefaultOptions.Budget,
+		"How long the cart optimizer may search before settling for its best plan so far",
+	)
+
+	priceGuideEvery := flag.Duration(
+		"price-guide-every",
+		15*time.Minute,
+		"How often the price guide is recomputed from completed sales",
+	)
+
+	repriceEvery := flag.Duration(
+		"reprice-every",
+		time.Hour,
+		"How often sellers' enabled repricing rules are applied",
+	)
+
+	uploadsDir := flag.String(
+		"uploads-dir",
+		"./uploads",
+		"Directory uploaded photos are stored in",
+	)
+
+	catalogFile := flag.String(
+		"catalog-file",
+		"",
+		"CSV file of printings and sealed products to import into the catalog at startup",
+	)
+
+	adminUserIDs := flag.String(
+		"admin-user-ids",
+		os.Getenv("ADMIN_USER_IDS"),
+		"Comma-separated Cognito user IDs allowed into the admin pages",
+	)
+
+	emailSender := flag.String(
+		"email-sender",
+		"file",
+		"How email is sent: 'file' writes .eml files to -mail-dir, 'smtp' uses -smtp-addr, 'ses' uses Amazon SES",
+	)
+
+	emailFrom := flag.String(
+		"email-from",
+		envOr("EMAIL_FROM", "TCG Marketplace <no-reply@localhost>"),
+		"From address for email; must be a verified identity when sending with SES",
+	)
+
+	smtpAddr := flag.String(
+		"smtp-addr",
+		"localhost:1025",
+		"SMTP server host:port for -email-sender=smtp; SMTP_USERNAME and SMTP_PASSWORD are used if set",
+	)
+
+	mailDir := flag.String(
+		"mail-dir",
+		"./mail",
+		"Directory emails are written to for -email-sender=file",
+	)
+
+	baseURL := flag.String(
+		"base-url",
+		envOr("BASE_URL", "http://localhost"),
+		"The site's public address, for links in emails and back from payout onboarding",
+	)
+
+	jobWorkers := flag.Int(
+		"job-workers",
+		jobs.DefaultOptions.Workers,
+		"Background jobs run at once by each job runner",
+	)
+
+	// parse flags
+	flag.Parse()
+
+	if *cognitoUserPoolID == "" || *cognitoClientID == "" {
+		fmt.Println("Missing Cognito flags")
+		os.Exit(1)
+	}
+
+	app.InProduction = *inProduction
+	app.BaseURL = strings.TrimRight(*baseURL, "/")
+	app.UseCache = *useCache
+
+	app.Admins = map[string]bool{}
+	for _, id := range strings.Split(*adminUserIDs, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			app.Admins[id] = true
+		}
+	}
+
+	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	app.InfoLog = infoLog
+	app.ErrorLog = errorLog
+
+	// Set up session based on production mode
+	session = scs.New()
+	session.Lifetime = 24 * time.Hour
+	session.Cookie.Persist = true
+	session.Cookie.SameSite = http.SameSiteLaxMode
+	session.Cookie.Secure = app.InProduction
+
+	var pool *redis.Pool
+	if app.InProduction {
+		// Redis connection setup using redigo for production
+		redisEndpoint := getEnvOrExit("REDIS_ENDPOINT") // format: host:port
+		redisPassword := os.Getenv("REDIS_PASSWORD")    // optional
+
+		// Create a redigo connection pool
+		pool = &redis.Pool{
+			MaxIdle:     10,
+			MaxActive:   100,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				opts := []redis.DialOption{}
+				if redisPassword != "" {
+					opts = append(opts, redis.DialPassword(redisPassword))
+				}
+				c, err := redis.Dial("tcp", redisEndpoint, opts...)
+				if err != nil {
+					return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+				}
+				return c, nil
+			},
+			TestOnBorrow: func(c redis.Conn, t time.Time) error {
+				if time.Since(t) < time.Minute {
+					return nil
+				}
+				_, err := c.Do("PING")
+				return err
+			},
+		}
+
+		// Test connection immediately
+		conn := pool.Get()
+		defer conn.Close()
+		if _, err := conn.Do("PING"); err != nil {
+			log.Fatalf("failed to connect to Redis: %v", err)
+		}
+		infoLog.Println("Connected to Redis for session storage and jobs")
+
+		// Use Redis store for production
+		session.Store = redisstore.New(pool)
+	} else {
+		// Use in-memory store for development
+		infoLog.Println("Using in-memory session store (development mode)")
+	}
+
+	app.Session = session
+
+	// AWS SDK config
+	awsCfg, err := awsConfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal("failed to load AWS config:", err)
+	}
+
+	// Cognito client
+	cognitoClient, err := cognito.NewCognitoClientWithCfg(awsCfg, *cognitoUserPoolID, *cognitoClientID)
+	if err != nil {
+		log.Fatal("failed to create Cognito client:", err)
+	}
+
+	app.CognitoClient = cognitoClient
+
+	// Every store lives in the DynamoDB table in production; in development they're kept in memory
+	var st stores
+	if *dynamoTable != "" {
+		st = dynamoStores(awsCfg, *dynamoTable)
+		infoLog.Printf("Using DynamoDB table %s for storage", *dynamoTable)
+	} else {
+		if app.InProduction {
+			log.Fatal("dynamodb-table is required in production")
+		}
+		infoLog.Println("Using in-memory stores (development mode)")
+		st = memoryStores()
+	}
+
+	// Stores write domain events into the outbox with each change; a periodic job relays them to
+	// subscribers as jobs of their own
+	app.Events = outbox.New(st.Events, outbox.Options{}, errorLog)
+
+	// Catalog and search index; the index follows printing writes and listing events incrementally
+	app.Catalog = catalog.New(st.Catalog)
+	if *catalogFile != "" {
+		if err := importCatalog(app.Catalog, *catalogFile); err != nil {
+			log.Fatal("failed to import catalog:", err)
+		}
+	}
+
+	searchIndex := search.NewIndex()
+	if err := searchIndex.Rebuild(context.TODO(), app.Catalog); err != nil {
+		log.Fatal("failed to build search index:", err)
+	}
+	searchIndex.Attach(app.Catalog, app.Events)
+	app.Search = searchIndex
+
+	// Graded listings have their PSA certs looked up when an API token is configured; the fake
+	// verifier knows no certs, so slabs list unverified in development
+	var certs grading.CertVerifier
+	if *psaAPIToken != "" {
+		certs = grading.NewPSAVerifier(*psaAPIToken, *psaBaseURL)
+	} else {
+		if app.InProduction {
+			log.Fatal("psa-api-token is required in production")
+		}
+		infoLog.Println("Using fake cert verifier (development mode)")
+		fake := grading.NewFakeVerifier()
+		fake.Missing = grading.ErrUnavailable
+		certs = fake
+	}
+	app.Grading = grading.New(certs)
+
+	app.Carts = cart.New(st.Carts, app.Catalog)
+	app.Orders = orders.New(st.Orders, app.Catalog, errorLog)
+	app.Sellers = sellers.New(st.Sellers)
+
+	// Shipping is priced on each order at checkout. Labels come from EasyPost when an API key is
+	// configured, otherwise from the local fake carrier.
+	easyPost := easypost.New(*easyPostAPIKey, *easyPostBaseURL)
+	var carrier shipping.Carrier
+	if *easyPostAPIKey != "" {
+		carrier = shipping.NewEasyPostCarrier(easyPost)
+	} else {
+		if app.InProduction {
+			log.Fatal("easypost-api-key is required in production")
+		}
+		infoLog.Println("Using fake shipping carrier (development mode)")
+		carrier = shipping.NewFakeCarrier()
+	}
+	app.Shipping = shipping.New(st.Shipping, carrier)
+	app.Shipping.Attach(app.Orders)
+
+	// Fees are assessed on each order at checkout; publish the defaults if no schedule exists yet
+	app.Fees = fees.New(st.Fees, app.Sellers)
+	if _, err := app.Fees.Current(context.TODO()); errors.Is(err, fees.ErrNoSchedule) {
+		if _, err := app.Fees.Publish(context.TODO(), fees.DefaultRules(), "system"); err != nil {
+			log.Fatal("failed to publish default fee schedule:", err)
+		}
+	} else if err != nil {
+		log.Fatal("failed to load fee schedule:", err)
+	}
+	app.Fees.Attach(app.Orders)
+
+	// Payments: Stripe when a secret key is configured, otherwise the local fake
+	var provider payments.Provider
+	webhookSecret := *stripeWebhookSecret
+	if *stripeSecretKey != "" {
+		if webhookSecret == "" {
+			log.Fatal("stripe-webhook-secret is required when stripe-secret-key is set")
+		}
+		provider = payments.NewStripeProvider(*stripeSecretKey, *stripeBaseURL)
+		app.StripeKey = *stripePublishableKey
+	} else {
+		if app.InProduction {
+			log.Fatal("stripe-secret-key is required in production")
+		}
+		infoLog.Println("Using fake payment provider (development mode)")
+		provider = payments.NewFakeProvider()
+		if webhookSecret == "" {
+			webhookSecret = "whsec_fake"
+		}
+	}
+	app.Payments = payments.New(provider, app.Orders, app.Sellers, webhookSecret, errorLog)
+	app.Catalog.BeforeList(app.Payments.RequirePayoutAccount)
+	app.Payments.SetFeeFunc(app.Fees.OrderFee)
+	app.Payments.Attach(app.Events)
+
+	app.Ledger = ledger.New(st.Ledger, ledger.Options{
+		ProcessingBasisPoints: *processingBasisPoints,
+		ProcessingFixedCents:  *processingFixedCents,
+	}, errorLog)
+	app.Ledger.Attach(app.Events, app.Orders)
+	app.Payments.SetRefundRecorder(app.Ledger.RecordRefund)
+	app.Ledger.SetBalanceFunc(app.Payments.ConnectedBalance)
+
+	// Tracking follows shipped orders, marks them delivered and completes them after the window
+	trackingSecret := *trackingWebhookSecret
+	if trackingSecret == "" {
+		if app.InProduction {
+			log.Fatal("tracking-webhook-secret is required in production")
+		}
+		trackingSecret = "whsec_tracking_fake"
+	}
+	var scans tracking.Provider
+	if *easyPostAPIKey != "" {
+		scans = tracking.NewEasyPostProvider(easyPost)
+	} else {
+		if app.InProduction {
+			log.Fatal("easypost-api-key is required in production for tracking")
+		}
+		infoLog.Println("Using fake tracking provider (development mode)")
+		scans = tracking.NewFakeProvider()
+	}
+	app.Tracking = tracking.New(st.Tracking, scans, app.Orders, trackingSecret,
+		tracking.Options{CompleteAfter: *autoCompleteAfter}, errorLog)
+	app.Tracking.Attach(app.Orders)
+
+	// Disputes hold their order so it doesn't auto-complete while a problem is being worked out
+	app.Photos = photos.NewDiskStorage(*uploadsDir, "/media")
+	app.Disputes = disputes.New(st.Disputes, app.Orders, app.Payments, app.Ledger,
+		disputes.Options{Window: *disputeWindow}, errorLog)
+	app.Tracking.SetHold(app.Disputes.Holds)
+
+	// Reviews of completed orders feed the seller rating shown and filtered on in search
+	app.Reviews = reviews.New(st.Reviews, app.Orders, reviews.Options{}, errorLog)
+	app.Search.SetSellerRatings(app.Reviews.Rating)
+
+	// Accepted offers hold their copies in the buyer's cart at the agreed price
+	app.Offers = offers.New(st.Offers, app.Catalog, app.Carts, offers.Options{TTL: *offerTTL}, errorLog)
+	app.Offers.Attach(app.Orders)
+
+	// Auctions hold their copy until they close; the winning bid becomes an order awaiting payment
+	app.Auctions = auctions.New(st.Auctions, app.Catalog, app.Orders,
+		auctions.Options{ExtendWithin: *auctionExtendWithin, ExtendBy: *auctionExtendBy}, errorLog)
+
+	// The optimizer sources carts and want lists from the fewest, cheapest sellers
+	app.Optimizer = optimizer.New(app.Catalog, app.Shipping, optimizer.Options{Budget: *optimizerBudget}, errorLog)
+
+	// Want lists match listing events as listings are published or repriced and batch the matches
+	// into digests
+	app.Wants = wants.New(st.Wants, app.Catalog, app.Optimizer,
+		wants.Options{DigestEvery: *wantDigestEvery}, errorLog)
+	app.Wants.Attach(app.Events)
+
+	// The price guide records completed sales and is recomputed from them in the background
+	app.Pricing = pricing.New(st.Pricing, pricing.Options{}, errorLog)
+	app.Pricing.Attach(app.Events, app.Orders)
+	app.Collection = collection.New(st.Collection, app.Catalog, app.Pricing, collection.Options{}, errorLog)
+	app.Collection.Attach(app.Events)
+	app.Repricing = repricing.New(st.Repricing, app.Catalog, app.Pricing, repricing.Options{}, errorLog)
+
+	// Buyers and sellers message each other about listings and orders
+	app.Messages = messages.New(st.Messages, app.Catalog, app.Orders, errorLog)
+
+	// Notifications go to the site and, through a retrying outbox, by email. Users' addresses come
+	// from Cognito.
+	sender, err := newEmailSender(*emailSender, *emailFrom, *smtpAddr, *mailDir, awsCfg)
+	if err != nil {
+		log.Fatal("failed to set up email:", err)
+	}
+	app.Notifications = notifications.New(st.Notifications, sender, render.Email,
+		cognitoClient.ExtractEmailFromSub, notifications.Options{BaseURL: *baseURL}, errorLog)
+	app.Notifications.Attach(app.Events)
+	app.Notifications.AttachOffers(app.Offers)
+	app.Notifications.AttachDisputes(app.Disputes)
+	app.Wants.SetNotifier(func(ctx context.Context, d wants.Digest) error {
+		return app.Notifications.Notify(ctx, notifications.WantsMatched{UserID: d.UserID, Matches: d.Matches})
+	})
+	app.Messages.SetNotifier(func(ctx context.Context, n messages.Notice) error {
+		return app.Notifications.Notify(ctx, notifications.MessageReceived{UserID: n.UserID, Thread: n.Thread, Message: n.Message})
+	})
+
+	// Sellers' own systems use the seller API with API keys and receive signed webhooks
+	app.APIKeys = apikeys.New(st.APIKeys, apikeys.Options{}, errorLog)
+	app.Webhooks = hooks.New(st.Webhooks, hooks.Options{}, errorLog)
+	app.Webhooks.Attach(app.Events)
+
+	// Background work runs as jobs, from Redis in production so any number of web servers and
+	// workers can share it
+	var queue jobs.Queue = jobs.NewMemoryQueue()
+	if pool != nil {
+		queue = jobs.NewRedisQueue(pool, "jobs:")
+	}
+	app.Jobs = jobs.New(queue, jobs.Options{Workers: *jobWorkers}, errorLog)
+	app.Events.Dispatch(app.Jobs)
+	registerJobs(app.Jobs, schedules{
+		PriceGuide:   *priceGuideEvery,
+		Reprice:      *repriceEvery,
+		UnpaidOrders: *unpaidOrderTTL,
+	})
+
+	tc, err := render.CreateTemplateCache()
+	if err != nil {
+		log.Fatal("Cannot create template cache")
+		return nil, err
+	}
+	app.TemplateCache = tc
+
+	ec, err := render.CreateEmailTemplateCache()
+	if err != nil {
+		log.Fatal("Cannot create email template cache")
+		return nil, err
+	}
+	app.EmailCache = ec
+
+	repo := handlers.NewRepo(&app)
+	handlers.NewHandlers(repo)
+	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
+
+	return &app, nil
+}
+
+// newEmailSender builds the EmailSender named by the -email-sender flag
+func newEmailSender(kind, from, smtpAddr, mailDir string, awsCfg aws.Config) (notifications.EmailSender, error) {
+	switch kind {
+	case "file":
+		return notifications.NewFileSender(mailDir, from)
+	case "smtp":
+		return &notifications.SMTPSender{
+			Addr:     smtpAddr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, nil
+	case "ses":
+		return notifications.NewSESSender(awsCfg, from), nil
+	}
+	return nil, fmt.Errorf("unknown email sender %q", kind)
+}
+
+// importCatalog loads a catalog CSV file, failing on the first problem so a bad file is noticed
+func importCatalog(c *catalog.Catalog, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, problems, err := c.Import(context.TODO(), f)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %d problems, the first: %v", path, len(problems), problems[0])
+	}
+	infoLog.Printf("imported %s: %+v", path, res)
+	return nil
+}
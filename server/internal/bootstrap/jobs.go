@@ -0,0 +1,66 @@
+package bootstrap
+
+import (
+	"context"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/jobs"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/tracking"
+)
+
+// Job kinds for the services' periodic work
+const (
+	JobOrdersExpireUnpaid = "orders.expire_unpaid"
+	JobTrackingPoll       = "tracking.poll"
+	JobTrackingComplete   = "tracking.complete"
+	JobDisputesEscalate   = "disputes.escalate"
+	JobOffersExpire       = "offers.expire"
+	JobAuctionsClose      = "auctions.close"
+	JobWantsDigests       = "wants.digests"
+	JobPricingAggregate   = "pricing.aggregate"
+	JobRepricingRun       = "repricing.run"
+	JobCollectionSnapshot = "collection.snapshot"
+	JobNotificationsSend  = "notifications.send"
+	JobEventsPublish      = "events.publish"
+	JobWebhooksDeliver    = "webhooks.deliver"
+)
+
+// schedules are the configurable intervals and timeouts of periodic jobs
+type schedules struct {
+	PriceGuide   time.Duration
+	Reprice      time.Duration
+	UnpaidOrders time.Duration
+}
+
+// registerJobs registers a handler for each service's periodic work and the schedule it runs on
+func registerJobs(r *jobs.Runner, every schedules) {
+	periodic := []struct {
+		kind  string
+		every time.Duration
+		run   func(ctx context.Context) (int, error)
+	}{
+		{JobOrdersExpireUnpaid, time.Minute, func(ctx context.Context) (int, error) {
+			return app.Orders.ExpireUnpaid(ctx, every.UnpaidOrders)
+		}},
+		{JobTrackingPoll, tracking.DefaultOptions.PollEvery, app.Tracking.Poll},
+		{JobTrackingComplete, tracking.DefaultOptions.PollEvery, app.Tracking.AutoComplete},
+		{JobDisputesEscalate, time.Hour, app.Disputes.EscalateOverdue},
+		{JobOffersExpire, time.Minute, app.Offers.ExpireDue},
+		{JobAuctionsClose, 15 * time.Second, app.Auctions.CloseDue},
+		{JobWantsDigests, time.Minute, app.Wants.FlushDigests},
+		{JobPricingAggregate, every.PriceGuide, app.Pricing.Aggregate},
+		{JobRepricingRun, every.Reprice, app.Repricing.RunAll},
+		{JobCollectionSnapshot, time.Hour, app.Collection.SnapshotAll},
+		{JobNotificationsSend, 30 * time.Second, app.Notifications.Deliver},
+		{JobEventsPublish, 5 * time.Second, app.Events.Publish},
+		{JobWebhooksDeliver, 15 * time.Second, app.Webhooks.Deliver},
+	}
+	for _, p := range periodic {
+		run := p.run
+		r.Handle(p.kind, func(ctx context.Context, j jobs.Job) error {
+			_, err := run(ctx)
+			return err
+		})
+		r.Every(p.kind, p.every, p.kind)
+	}
+}
@@ -0,0 +1,111 @@
+package bootstrap
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/apikeys"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/auctions"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/collection"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/disputes"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/fees"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/hooks"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ledger"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/messages"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/notifications"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/offers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/pricing"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/repricing"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/reviews"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/sellers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/shipping"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/tracking"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/wants"
+)
+
+// stores holds the store behind every service
+type stores struct {
+	Events        outbox.Store
+	Catalog       catalog.Store
+	Carts         cart.Store
+	Orders        orders.Store
+	Sellers       sellers.Store
+	Shipping      shipping.Store
+	Fees          fees.Store
+	Ledger        ledger.Store
+	Tracking      tracking.Store
+	Disputes      disputes.Store
+	Reviews       reviews.Store
+	Offers        offers.Store
+	Auctions      auctions.Store
+	Wants         wants.Store
+	Pricing       pricing.Store
+	Collection    collection.Store
+	Repricing     repricing.Store
+	Messages      messages.Store
+	Notifications notifications.Store
+	APIKeys       apikeys.Store
+	Webhooks      hooks.Store
+}
+
+// dynamoStores keeps everything in the marketplace DynamoDB table, shared by every web and worker
+// process
+func dynamoStores(awsCfg aws.Config, tableName string) stores {
+	table := dynamo.New(dynamodb.NewFromConfig(awsCfg), tableName)
+	return stores{
+		Events:        outbox.NewDynamoStore(table),
+		Catalog:       catalog.NewDynamoStore(table),
+		Carts:         cart.NewDynamoStore(table),
+		Orders:        orders.NewDynamoStore(table),
+		Sellers:       sellers.NewDynamoStore(table),
+		Shipping:      shipping.NewDynamoStore(table),
+		Fees:          fees.NewDynamoStore(table),
+		Ledger:        ledger.NewDynamoStore(table),
+		Tracking:      tracking.NewDynamoStore(table),
+		Disputes:      disputes.NewDynamoStore(table),
+		Reviews:       reviews.NewDynamoStore(table),
+		Offers:        offers.NewDynamoStore(table),
+		Auctions:      auctions.NewDynamoStore(table),
+		Wants:         wants.NewDynamoStore(table),
+		Pricing:       pricing.NewDynamoStore(table),
+		Collection:    collection.NewDynamoStore(table),
+		Repricing:     repricing.NewDynamoStore(table),
+		Messages:      messages.NewDynamoStore(table),
+		Notifications: notifications.NewDynamoStore(table),
+		APIKeys:       apikeys.NewDynamoStore(table),
+		Webhooks:      hooks.NewDynamoStore(table),
+	}
+}
+
+// memoryStores keeps everything in this process's memory, for development. Nothing is shared
+// with other processes or survives a restart.
+func memoryStores() stores {
+	events := outbox.NewMemoryStore()
+	return stores{
+		Events:        events,
+		Catalog:       catalog.NewMemoryStore(events),
+		Carts:         cart.NewMemoryStore(),
+		Orders:        orders.NewMemoryStore(events),
+		Sellers:       sellers.NewMemoryStore(events),
+		Shipping:      shipping.NewMemoryStore(),
+		Fees:          fees.NewMemoryStore(),
+		Ledger:        ledger.NewMemoryStore(),
+		Tracking:      tracking.NewMemoryStore(),
+		Disputes:      disputes.NewMemoryStore(),
+		Reviews:       reviews.NewMemoryStore(),
+		Offers:        offers.NewMemoryStore(events),
+		Auctions:      auctions.NewMemoryStore(),
+		Wants:         wants.NewMemoryStore(),
+		Pricing:       pricing.NewMemoryStore(),
+		Collection:    collection.NewMemoryStore(),
+		Repricing:     repricing.NewMemoryStore(),
+		Messages:      messages.NewMemoryStore(),
+		Notifications: notifications.NewMemoryStore(),
+		APIKeys:       apikeys.NewMemoryStore(),
+		Webhooks:      hooks.NewMemoryStore(),
+	}
+}
@@ -0,0 +1,382 @@
+// Package cart implements shopping carts: line item changes, stock and price validation, and grouping by seller.
+package cart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+)
+
+var (
+	// ErrInvalidQuantity is returned for zero or negative quantities
+	ErrInvalidQuantity = errors.New("cart: quantity must be at least 1")
+	// ErrUnavailable is returned when a listing is no longer for sale
+	ErrUnavailable = errors.New("cart: listing is no longer available")
+	// ErrInsufficientStock is returned when more copies are requested than the listing has
+	ErrInsufficientStock = errors.New("cart: not enough copies in stock")
+	// ErrOwnListing is returned when a seller tries to buy their own listing
+	ErrOwnListing = errors.New("cart: cannot buy your own listing")
+	// ErrNotInCart is returned when updating a listing that is not in the cart
+	ErrNotInCart = errors.New("cart: item is not in the cart")
+	// ErrReservationExpired is returned for an accepted offer whose held copies went back on sale
+	ErrReservationExpired = errors.New("cart: offer reservation expired")
+)
+
+// Store persists carts for signed-in users.
+type Store interface {
+	Get(ctx context.Context, userID string) (models.Cart, error)
+	Put(ctx context.Context, c models.Cart) error
+}
+
+// Service applies cart changes against the catalog.
+type Service struct {
+	store   Store
+	catalog *catalog.Catalog
+}
+
+// New creates a cart Service
+func New(store Store, c *catalog.Catalog) *Service {
+	return &Service{store: store, catalog: c}
+}
+
+// Load returns a user's persisted cart, or an empty cart if they have none
+func (s *Service) Load(ctx context.Context, userID string) (models.Cart, error) {
+	c, err := s.store.Get(ctx, userID)
+	if err != nil {
+		return models.Cart{}, err
+	}
+	c.UserID = userID
+	return c, nil
+}
+
+// Save persists a signed-in user's cart
+func (s *Service) Save(ctx context.Context, c models.Cart) error {
+	if c.UserID == "" {
+		return errors.New("cart: cannot persist an anonymous cart")
+	}
+	c.PK, c.SK = models.CartKey(c.UserID)
+	c.Type = models.ItemTypeCart
+	c.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	return s.store.Put(ctx, c)
+}
+
+// Add puts qty copies of a listing in the cart, adding to any quantity already there.
+// Stock and the current price are checked at add time.
+func (s *Service) Add(ctx context.Context, c *models.Cart, listingID string, qty int) error {
+	if qty < 1 {
+		return ErrInvalidQuantity
+	}
+
+	l, err := s.available(ctx, c.UserID, listingID)
+	if err != nil {
+		return err
+	}
+
+	for i := range c.Items {
+		if c.Items[i].ListingID == listingID && !c.Items[i].Reserved() {
+			if c.Items[i].Quantity+qty > l.Quantity {
+				return ErrInsufficientStock
+			}
+			c.Items[i].Quantity += qty
+			c.Items[i].PriceCents = l.PriceCents
+			return nil
+		}
+	}
+
+	if qty > l.Quantity {
+		return ErrInsufficientStock
+	}
+	c.Items = append(c.Items, models.CartItem{
+		ListingID:  listingID,
+		Quantity:   qty,
+		PriceCents: l.PriceCents,
+		AddedAt:    time.Now().UTC().Format(time.RFC3339),
+	})
+	return nil
+}
+
+// Update sets the quantity of a line; a quantity of zero removes it
+func (s *Service) Update(ctx context.Context, c *models.Cart, listingID string, qty int) error {
+	if qty == 0 {
+		return s.Remove(c, listingID)
+	}
+	if qty < 0 {
+		return ErrInvalidQuantity
+	}
+
+	for i := range c.Items {
+		if c.Items[i].ListingID != listingID || c.Items[i].Reserved() {
+			continue
+		}
+		l, err := s.available(ctx, c.UserID, listingID)
+		if err != nil {
+			return err
+		}
+		if qty > l.Quantity {
+			return ErrInsufficientStock
+		}
+		c.Items[i].Quantity = qty
+		c.Items[i].PriceCents = l.PriceCents
+		return nil
+	}
+	return ErrNotInCart
+}
+
+// Remove deletes a line from the cart
+func (s *Service) Remove(c *models.Cart, listingID string) error {
+	for i := range c.Items {
+		if c.Items[i].ListingID == listingID && !c.Items[i].Reserved() {
+			c.Items = append(c.Items[:i], c.Items[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotInCart
+}
+
+// AddOffer puts copies held for an accepted offer in the cart at the negotiated unit price. The
+// copies have already been taken out of stock; the line can't be changed, only removed.
+func (s *Service) AddOffer(c *models.Cart, o models.Offer) {
+	c.Items = append(c.Items, models.CartItem{
+		ListingID:     o.ListingID,
+		Quantity:      o.Quantity,
+		PriceCents:    o.AmountCents,
+		AddedAt:       time.Now().UTC().Format(time.RFC3339),
+		OfferID:       o.OfferID,
+		ReservedUntil: o.ReservedUntil,
+	})
+}
+
+// RemoveOffer deletes an accepted offer's line from the cart
+func (s *Service) RemoveOffer(c *models.Cart, offerID string) error {
+	for i := range c.Items {
+		if c.Items[i].OfferID == offerID {
+			c.Items = append(c.Items[:i], c.Items[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotInCart
+}
+
+// Merge folds an anonymous session cart into a user's persisted cart. Quantities for the same
+// listing are combined and clamped to the stock available now; unavailable lines are dropped.
+func (s *Service) Merge(ctx context.Context, into *models.Cart, from models.Cart) {
+	for _, it := range from.Items {
+		l, err := s.available(ctx, into.UserID, it.ListingID)
+		if err != nil {
+			continue
+		}
+
+		merged := false
+		for i := range into.Items {
+			if into.Items[i].ListingID == it.ListingID && !into.Items[i].Reserved() {
+				into.Items[i].Quantity = min(into.Items[i].Quantity+it.Quantity, l.Quantity)
+				into.Items[i].PriceCents = l.PriceCents
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			it.Quantity = min(it.Quantity, l.Quantity)
+			it.PriceCents = l.PriceCents
+			into.Items = append(into.Items, it)
+		}
+	}
+}
+
+// available loads a listing and checks that the buyer may purchase it
+func (s *Service) available(ctx context.Context, buyerID, listingID string) (models.Listing, error) {
+	l, err := s.catalog.Listing(ctx, listingID)
+	if errors.Is(err, catalog.ErrNotFound) {
+		return l, ErrUnavailable
+	}
+	if err != nil {
+		return l, err
+	}
+	if !l.IsActive() {
+		return l, ErrUnavailable
+	}
+	if buyerID != "" && l.SellerID == buyerID {
+		return l, ErrOwnListing
+	}
+	return l, nil
+}
+
+// held loads the listing behind an accepted offer's line. Its copies are already out of stock, so
+// only the reservation window is checked.
+func (s *Service) held(ctx context.Context, it models.CartItem) (models.Listing, error) {
+	l, err := s.catalog.Listing(ctx, it.ListingID)
+	if errors.Is(err, catalog.ErrNotFound) {
+		return l, ErrUnavailable
+	}
+	if err != nil {
+		return l, err
+	}
+	if it.ReservedUntil <= time.Now().UTC().Format(time.RFC3339) {
+		return l, ErrReservationExpired
+	}
+	return l, nil
+}
+
+// Issue is a problem with a cart line found during validation
+type Issue struct {
+	ListingID string
+	Message   string
+}
+
+// Line is a cart item joined with its listing and printing, or sealed product, for display and checkout
+type Line struct {
+	Item           models.CartItem
+	Listing        models.Listing
+	Printing       models.Printing
+	Product        models.Product
+	LineTotalCents int64
+	Issue          string
+}
+
+// Game returns the game of the card or product on the line
+func (l Line) Game() string {
+	if l.Listing.IsSealed() {
+		return l.Product.Game
+	}
+	return l.Printing.Game
+}
+
+// Name returns the card's or sealed product's name
+func (l Line) Name() string {
+	if l.Listing.IsSealed() {
+		return l.Product.Name
+	}
+	return l.Printing.CardName
+}
+
+// SetName returns the set the card or product is from
+func (l Line) SetName() string {
+	if l.Listing.IsSealed() {
+		return l.Product.SetName
+	}
+	return l.Printing.SetName
+}
+
+// SellerGroup is the set of cart lines sold by one seller
+type SellerGroup struct {
+	SellerID      string
+	Lines         []Line
+	SubtotalCents int64
+}
+
+// View is a cart grouped by seller with totals and any validation issues
+type View struct {
+	Groups     []SellerGroup
+	TotalCents int64
+	ItemCount  int
+	Issues     []Issue
+}
+
+// Valid reports whether the cart can be checked out as-is
+func (v View) Valid() bool {
+	return len(v.Issues) == 0 && v.ItemCount > 0
+}
+
+// Build joins every line with the catalog, groups lines by seller and re-validates stock and
+// price. It is used both to render the cart page and again at checkout.
+func (s *Service) Build(ctx context.Context, c models.Cart) (View, error) {
+	var v View
+	groups := map[string]*SellerGroup{}
+
+	for _, it := range c.Items {
+		line := Line{Item: it}
+
+		var (
+			l   models.Listing
+			err error
+		)
+		if it.Reserved() {
+			l, err = s.held(ctx, it)
+		} else {
+			l, err = s.available(ctx, c.UserID, it.ListingID)
+		}
+		switch {
+		case errors.Is(err, ErrUnavailable), errors.Is(err, ErrOwnListing), errors.Is(err, ErrReservationExpired):
+			line.Issue = err.Error()
+		case err != nil:
+			return v, err
+		case it.Reserved():
+			// held copies keep the offer price and are already out of stock
+		case it.Quantity > l.Quantity:
+			line.Issue = fmt.Sprintf("only %d left in stock", l.Quantity)
+		case it.PriceCents != l.PriceCents:
+			line.Issue = fmt.Sprintf("price changed from %s to %s", money.Format(it.PriceCents), money.Format(l.PriceCents))
+		}
+		line.Listing = l
+
+		if l.PrintingID != "" {
+			p, err := s.catalog.Printing(ctx, l.PrintingID)
+			if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+				return v, err
+			}
+			line.Printing = p
+		}
+		if l.ProductID != "" {
+			p, err := s.catalog.Product(ctx, l.ProductID)
+			if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+				return v, err
+			}
+			line.Product = p
+		}
+
+		if line.Issue != "" {
+			v.Issues = append(v.Issues, Issue{ListingID: it.ListingID, Message: line.Issue})
+		}
+
+		line.LineTotalCents = int64(it.Quantity) * it.PriceCents
+		g, ok := groups[l.SellerID]
+		if !ok {
+			g = &SellerGroup{SellerID: l.SellerID}
+			groups[l.SellerID] = g
+		}
+		g.Lines = append(g.Lines, line)
+		g.SubtotalCents += line.LineTotalCents
+		v.TotalCents += line.LineTotalCents
+		v.ItemCount += it.Quantity
+	}
+
+	for _, g := range groups {
+		v.Groups = append(v.Groups, *g)
+	}
+	sort.Slice(v.Groups, func(i, j int) bool { return v.Groups[i].SellerID < v.Groups[j].SellerID })
+	return v, nil
+}
+
+// Reprice updates every line to the listing's current price and clamps quantities to stock,
+// dropping lines that can no longer be bought. Buyers use it to accept changes flagged by Build.
+func (s *Service) Reprice(ctx context.Context, c *models.Cart) error {
+	kept := c.Items[:0]
+	for _, it := range c.Items {
+		if it.Reserved() {
+			if _, err := s.held(ctx, it); err == nil {
+				kept = append(kept, it)
+			} else if !errors.Is(err, ErrUnavailable) && !errors.Is(err, ErrReservationExpired) {
+				return err
+			}
+			continue
+		}
+		l, err := s.available(ctx, c.UserID, it.ListingID)
+		if errors.Is(err, ErrUnavailable) || errors.Is(err, ErrOwnListing) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		it.PriceCents = l.PriceCents
+		it.Quantity = min(it.Quantity, l.Quantity)
+		kept = append(kept, it)
+	}
+	c.Items = kept
+	return nil
+}
@@ -0,0 +1,35 @@
+package cart
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Get returns a user's cart, or an empty cart if none is stored
+func (s *DynamoStore) Get(ctx context.Context, userID string) (models.Cart, error) {
+	var c models.Cart
+	pk, sk := models.CartKey(userID)
+	err := s.table.Get(ctx, pk, sk, &c)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return models.Cart{}, nil
+	}
+	return c, err
+}
+
+// Put stores a user's cart
+func (s *DynamoStore) Put(ctx context.Context, c models.Cart) error {
+	return s.table.Put(ctx, c, dynamo.Condition{})
+}
@@ -0,0 +1,37 @@
+package cart
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	carts map[string]models.Cart
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{carts: map[string]models.Cart{}}
+}
+
+// Get returns a user's cart, or an empty cart if none is stored
+func (s *MemoryStore) Get(ctx context.Context, userID string) (models.Cart, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := s.carts[userID]
+	c.Items = append([]models.CartItem(nil), c.Items...)
+	return c, nil
+}
+
+// Put stores a user's cart
+func (s *MemoryStore) Put(ctx context.Context, c models.Cart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Items = append([]models.CartItem(nil), c.Items...)
+	s.carts[c.UserID] = c
+	return nil
+}
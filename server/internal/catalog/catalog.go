@@ -0,0 +1,394 @@
+// Package catalog manages card printings, sealed products and the seller listings that reference
+// them.
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+var (
+	// ErrNotFound is returned when a printing, product or listing does not exist
+	ErrNotFound = errors.New("catalog: not found")
+	// ErrInvalid is returned for a product missing its name, game or type, or with a bad UPC, and
+	// for a sealed listing in a condition its product can't be sold in
+	ErrInvalid = errors.New("catalog: invalid")
+	// ErrConflict is returned when a conditional write loses to a concurrent update
+	ErrConflict = errors.New("catalog: version conflict")
+	// ErrInsufficientStock is returned when a reservation asks for more copies than a listing has
+	ErrInsufficientStock = errors.New("catalog: insufficient stock")
+)
+
+// reserveAttempts bounds how often a reservation is retried after losing a conditional write
+const reserveAttempts = 5
+
+// Store is the persistence layer for printings, products and listings.
+type Store interface {
+	GetPrinting(ctx context.Context, printingID string) (models.Printing, error)
+	PutPrinting(ctx context.Context, p models.Printing) error
+	ListPrintings(ctx context.Context) ([]models.Printing, error)
+
+	GetProduct(ctx context.Context, productID string) (models.Product, error)
+	PutProduct(ctx context.Context, p models.Product) error
+	ListProducts(ctx context.Context) ([]models.Product, error)
+
+	GetListing(ctx context.Context, listingID string) (models.Listing, error)
+	// PutListing writes a listing and its domain events in one transaction. When
+	// expectedVersion is non-negative the write only succeeds if the stored version matches it,
+	// otherwise ErrConflict is returned.
+	PutListing(ctx context.Context, l models.Listing, expectedVersion int64, out []models.DomainEvent) error
+	ListListings(ctx context.Context) ([]models.Listing, error)
+	ListingsBySeller(ctx context.Context, sellerID string) ([]models.Listing, error)
+	// ListingsByPrinting returns every listing of a printing (the listings share its partition)
+	ListingsByPrinting(ctx context.Context, printingID string) ([]models.Listing, error)
+	// ListingsByProduct returns every listing of a sealed product (the listings share its partition)
+	ListingsByProduct(ctx context.Context, productID string) ([]models.Listing, error)
+	// PutListings writes several listings and their domain events in one all-or-nothing
+	// transaction, each listing conditional on its matching expected version.
+	PutListings(ctx context.Context, ls []models.Listing, expectedVersions []int64, out []models.DomainEvent) error
+}
+
+// ListingChange describes a write to a listing. Previous is nil for newly created listings.
+type ListingChange struct {
+	Previous *models.Listing
+	Current  models.Listing
+}
+
+// PrintingListener is notified after a printing has been written
+type PrintingListener func(ctx context.Context, p models.Printing)
+
+// ProductListener is notified after a sealed product has been written
+type ProductListener func(ctx context.Context, p models.Product)
+
+// SellerCheck returns an error if a seller may not put up new listings
+type SellerCheck func(ctx context.Context, sellerID string) error
+
+// Catalog wraps a Store and notifies listeners of printing and product changes. Listing changes
+// are published as domain events through the outbox instead.
+type Catalog struct {
+	store Store
+
+	mu                sync.RWMutex
+	printingListeners []PrintingListener
+	productListeners  []ProductListener
+	sellerChecks      []SellerCheck
+}
+
+// New creates a Catalog backed by the given store
+func New(store Store) *Catalog {
+	return &Catalog{store: store}
+}
+
+// OnPrintingChange registers a listener for printing writes
+func (c *Catalog) OnPrintingChange(fn PrintingListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.printingListeners = append(c.printingListeners, fn)
+}
+
+// OnProductChange registers a listener for sealed product writes
+func (c *Catalog) OnProductChange(fn ProductListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.productListeners = append(c.productListeners, fn)
+}
+
+// BeforeList registers a check that a seller must pass to create a listing
+func (c *Catalog) BeforeList(fn SellerCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sellerChecks = append(c.sellerChecks, fn)
+}
+
+// Printing returns a single printing
+func (c *Catalog) Printing(ctx context.Context, printingID string) (models.Printing, error) {
+	return c.store.GetPrinting(ctx, printingID)
+}
+
+// Printings returns every printing in the catalog
+func (c *Catalog) Printings(ctx context.Context) ([]models.Printing, error) {
+	return c.store.ListPrintings(ctx)
+}
+
+// SavePrinting creates or updates a printing
+func (c *Catalog) SavePrinting(ctx context.Context, p models.Printing) (models.Printing, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if p.PrintingID == "" {
+		p.PrintingID = ids.New()
+	}
+	if p.CreatedAt == "" {
+		p.CreatedAt = now
+	}
+	p.UpdatedAt = now
+	p.PK, p.SK = models.PrintingKey(p.PrintingID)
+	p.GSI1PK, p.GSI1SK = models.PrintingIndexKey(p.CardName, p.PrintingID)
+	p.Type = models.ItemTypePrinting
+
+	if err := c.store.PutPrinting(ctx, p); err != nil {
+		return p, err
+	}
+
+	c.mu.RLock()
+	listeners := c.printingListeners
+	c.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(ctx, p)
+	}
+	return p, nil
+}
+
+// Product returns a single sealed product
+func (c *Catalog) Product(ctx context.Context, productID string) (models.Product, error) {
+	return c.store.GetProduct(ctx, productID)
+}
+
+// Products returns every sealed product in the catalog
+func (c *Catalog) Products(ctx context.Context) ([]models.Product, error) {
+	return c.store.ListProducts(ctx)
+}
+
+// SaveProduct validates and creates or updates a sealed product
+func (c *Catalog) SaveProduct(ctx context.Context, p models.Product) (models.Product, error) {
+	p.Name = strings.TrimSpace(p.Name)
+	p.UPC = strings.TrimSpace(p.UPC)
+	if err := validateProduct(p); err != nil {
+		return p, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if p.ProductID == "" {
+		p.ProductID = ids.New()
+	}
+	if p.CreatedAt == "" {
+		p.CreatedAt = now
+	}
+	p.UpdatedAt = now
+	p.PK, p.SK = models.ProductKey(p.ProductID)
+	p.GSI1PK, p.GSI1SK = models.ProductIndexKey(p.Name, p.ProductID)
+	p.Type = models.ItemTypeProduct
+
+	if err := c.store.PutProduct(ctx, p); err != nil {
+		return p, err
+	}
+
+	c.mu.RLock()
+	listeners := c.productListeners
+	c.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(ctx, p)
+	}
+	return p, nil
+}
+
+// validateProduct checks a product has a name, game and known type, a well-formed UPC if any, and
+// complete contents lines
+func validateProduct(p models.Product) error {
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("%w: the product needs a name", ErrInvalid)
+	case p.Game == "":
+		return fmt.Errorf("%w: the product needs a game", ErrInvalid)
+	case models.ProductTypeNames[p.ProductType] == "":
+		return fmt.Errorf("%w: unknown product type %q", ErrInvalid, p.ProductType)
+	case p.UPC != "" && (len(p.UPC) != 12 && len(p.UPC) != 13 || strings.Trim(p.UPC, "0123456789") != ""):
+		return fmt.Errorf("%w: the UPC should be 12 or 13 digits", ErrInvalid)
+	}
+	for _, it := range p.Contents {
+		if it.Quantity < 1 || strings.TrimSpace(it.Item) == "" {
+			return fmt.Errorf("%w: each line of the contents needs a quantity and an item", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// Listing returns a single listing
+func (c *Catalog) Listing(ctx context.Context, listingID string) (models.Listing, error) {
+	return c.store.GetListing(ctx, listingID)
+}
+
+// Listings returns every listing
+func (c *Catalog) Listings(ctx context.Context) ([]models.Listing, error) {
+	return c.store.ListListings(ctx)
+}
+
+// ListingsBySeller returns the listings owned by a seller
+func (c *Catalog) ListingsBySeller(ctx context.Context, sellerID string) ([]models.Listing, error) {
+	return c.store.ListingsBySeller(ctx, sellerID)
+}
+
+// ListingsByPrinting returns the listings for a printing
+func (c *Catalog) ListingsByPrinting(ctx context.Context, printingID string) ([]models.Listing, error) {
+	return c.store.ListingsByPrinting(ctx, printingID)
+}
+
+// ListingsByProduct returns the listings for a sealed product
+func (c *Catalog) ListingsByProduct(ctx context.Context, productID string) ([]models.Listing, error) {
+	return c.store.ListingsByProduct(ctx, productID)
+}
+
+// SaveListing creates or updates a listing. Updates are conditional on the version the
+// caller read, so a stale copy returns ErrConflict instead of overwriting newer data.
+func (c *Catalog) SaveListing(ctx context.Context, l models.Listing) (models.Listing, error) {
+	var previous *models.Listing
+	expected := int64(-1)
+
+	if l.ListingID == "" {
+		l.ListingID = ids.New()
+	} else if existing, err := c.store.GetListing(ctx, l.ListingID); err == nil {
+		previous = &existing
+		expected = l.Version
+	} else if !errors.Is(err, ErrNotFound) {
+		return l, err
+	}
+
+	if previous == nil {
+		if err := c.checkSeller(ctx, l.SellerID); err != nil {
+			return l, err
+		}
+	}
+	if l.IsSealed() && (previous == nil || previous.Condition != l.Condition) {
+		p, err := c.store.GetProduct(ctx, l.ProductID)
+		if err != nil {
+			return l, err
+		}
+		if !p.AllowsCondition(l.Condition) || l.Grading != nil {
+			return l, fmt.Errorf("%w: a %s is listed as %s", ErrInvalid, strings.ToLower(p.TypeName()), strings.Join(p.Conditions(), " or "))
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if l.CreatedAt == "" {
+		l.CreatedAt = now
+	}
+	l.UpdatedAt = now
+	if l.Status == "" {
+		l.Status = models.ListingStatusActive
+	}
+	if l.Status == models.ListingStatusActive && l.Quantity <= 0 {
+		l.Status = models.ListingStatusSoldOut
+	}
+	l.Version++
+	if l.IsSealed() {
+		l.PrintingID = ""
+		l.PK, l.SK = models.ProductListingKey(l.ProductID, l.ListingID)
+	} else {
+		l.PK, l.SK = models.ListingKey(l.PrintingID, l.ListingID)
+	}
+	l.GSI1PK, l.GSI1SK = models.SellerListingKey(l.SellerID, l.CreatedAt, l.ListingID)
+	l.GSI2PK, l.GSI2SK = models.AllListingsKey(l.CreatedAt, l.ListingID)
+	l.Type = models.ItemTypeListing
+
+	change := ListingChange{Previous: previous, Current: l}
+	ev, err := listingEvent(change, now)
+	if err != nil {
+		return l, err
+	}
+	if err := c.store.PutListing(ctx, l, expected, []models.DomainEvent{ev}); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
+// checkSeller runs every seller check for a new listing
+func (c *Catalog) checkSeller(ctx context.Context, sellerID string) error {
+	c.mu.RLock()
+	checks := c.sellerChecks
+	c.mu.RUnlock()
+	for _, check := range checks {
+		if err := check(ctx, sellerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listingEvent builds the domain event for a listing change: sold out when the change took its
+// last copy, otherwise created or updated
+func listingEvent(change ListingChange, at string) (models.DomainEvent, error) {
+	name := models.EventListingUpdated
+	switch {
+	case change.Current.Status == models.ListingStatusSoldOut && (change.Previous == nil || change.Previous.Status != models.ListingStatusSoldOut):
+		name = models.EventListingSoldOut
+	case change.Previous == nil:
+		name = models.EventListingCreated
+	}
+	return outbox.NewEvent(name, "listing", change.Current.ListingID, change.Current.Version, at, change)
+}
+
+// Reserve takes copies out of stock for every listing in quantities (listingID -> copies) in a
+// single conditional transaction, so two buyers can never both take the last copy. Either every
+// listing is decremented or none is.
+func (c *Catalog) Reserve(ctx context.Context, quantities map[string]int) ([]ListingChange, error) {
+	return c.adjustStock(ctx, quantities, -1)
+}
+
+// Release returns previously reserved copies to stock
+func (c *Catalog) Release(ctx context.Context, quantities map[string]int) ([]ListingChange, error) {
+	return c.adjustStock(ctx, quantities, 1)
+}
+
+// adjustStock applies sign*quantity to each listing, retrying when a concurrent write wins
+func (c *Catalog) adjustStock(ctx context.Context, quantities map[string]int, sign int) ([]ListingChange, error) {
+	for attempt := 0; attempt < reserveAttempts; attempt++ {
+		var (
+			changes  []ListingChange
+			updated  []models.Listing
+			versions []int64
+			out      []models.DomainEvent
+		)
+		now := time.Now().UTC().Format(time.RFC3339)
+
+		for listingID, qty := range quantities {
+			l, err := c.store.GetListing(ctx, listingID)
+			if err != nil {
+				return nil, err
+			}
+			previous := l
+
+			if sign < 0 {
+				if !l.IsActive() || l.Quantity < qty {
+					return nil, fmt.Errorf("%w: listing %s", ErrInsufficientStock, listingID)
+				}
+				l.Quantity -= qty
+				if l.Quantity == 0 {
+					l.Status = models.ListingStatusSoldOut
+				}
+			} else {
+				l.Quantity += qty
+				if l.Status == models.ListingStatusSoldOut && l.Quantity > 0 {
+					l.Status = models.ListingStatusActive
+				}
+			}
+			l.Version++
+			l.UpdatedAt = now
+
+			updated = append(updated, l)
+			versions = append(versions, previous.Version)
+			change := ListingChange{Previous: &previous, Current: l}
+			ev, err := listingEvent(change, now)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, change)
+			out = append(out, ev)
+		}
+
+		err := c.store.PutListings(ctx, updated, versions, out)
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return changes, nil
+	}
+	return nil, ErrConflict
+}
@@ -0,0 +1,197 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// listingRef records which partition a listing is stored in, so a listing can be read by its ID
+// alone
+type listingRef struct {
+	PK        string `dynamodbav:"PK"`
+	SK        string `dynamodbav:"SK"`
+	Type      string `dynamodbav:"Type"`
+	ListingID string `dynamodbav:"listingID"`
+	ListingPK string `dynamodbav:"listingPK"`
+	ListingSK string `dynamodbav:"listingSK"`
+}
+
+// DynamoStore is a Store over the marketplace table. Listings live in their printing's or
+// product's partition, with a reference item keyed by listing ID pointing at them.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// GetPrinting returns a printing by ID
+func (s *DynamoStore) GetPrinting(ctx context.Context, printingID string) (models.Printing, error) {
+	var p models.Printing
+	pk, sk := models.PrintingKey(printingID)
+	err := s.get(ctx, pk, sk, &p)
+	return p, err
+}
+
+// PutPrinting stores a printing
+func (s *DynamoStore) PutPrinting(ctx context.Context, p models.Printing) error {
+	return s.table.Put(ctx, p, dynamo.Condition{})
+}
+
+// ListPrintings returns all printings ordered by card name
+func (s *DynamoStore) ListPrintings(ctx context.Context) ([]models.Printing, error) {
+	var out []models.Printing
+	pk, _ := models.PrintingIndexKey("", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk}, &out)
+	return out, err
+}
+
+// GetProduct returns a sealed product by ID
+func (s *DynamoStore) GetProduct(ctx context.Context, productID string) (models.Product, error) {
+	var p models.Product
+	pk, sk := models.ProductKey(productID)
+	err := s.get(ctx, pk, sk, &p)
+	return p, err
+}
+
+// PutProduct stores a sealed product
+func (s *DynamoStore) PutProduct(ctx context.Context, p models.Product) error {
+	return s.table.Put(ctx, p, dynamo.Condition{})
+}
+
+// ListProducts returns all sealed products ordered by name
+func (s *DynamoStore) ListProducts(ctx context.Context) ([]models.Product, error) {
+	var out []models.Product
+	pk, _ := models.ProductIndexKey("", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk}, &out)
+	return out, err
+}
+
+// GetListing returns a listing by ID
+func (s *DynamoStore) GetListing(ctx context.Context, listingID string) (models.Listing, error) {
+	var l models.Listing
+	ref, err := s.ref(ctx, listingID)
+	if err != nil {
+		return l, err
+	}
+	err = s.get(ctx, ref.ListingPK, ref.ListingSK, &l)
+	return l, err
+}
+
+// PutListing stores a listing and its events, enforcing the expected version when one is given
+func (s *DynamoStore) PutListing(ctx context.Context, l models.Listing, expectedVersion int64, out []models.DomainEvent) error {
+	ops, err := s.listingOps(ctx, l, expectedVersion)
+	if err != nil {
+		return err
+	}
+	return s.write(ctx, append(ops, outbox.PutOps(out)...))
+}
+
+// PutListings writes several listings and their events in one transaction, each listing
+// conditional on its expected version
+func (s *DynamoStore) PutListings(ctx context.Context, ls []models.Listing, expectedVersions []int64, out []models.DomainEvent) error {
+	var ops []dynamo.Op
+	for i, l := range ls {
+		lops, err := s.listingOps(ctx, l, expectedVersions[i])
+		if err != nil {
+			return err
+		}
+		ops = append(ops, lops...)
+	}
+	return s.write(ctx, append(ops, outbox.PutOps(out)...))
+}
+
+// listingOps returns the writes that store a listing and its reference. A listing whose printing
+// or product changed moves to the new partition, and the old copy is deleted.
+func (s *DynamoStore) listingOps(ctx context.Context, l models.Listing, expectedVersion int64) ([]dynamo.Op, error) {
+	ref := listingRef{ListingID: l.ListingID, ListingPK: l.PK, ListingSK: l.SK, Type: models.ItemTypeListingRef}
+	ref.PK, ref.SK = models.ListingRefKey(l.ListingID)
+	if expectedVersion < 0 {
+		return []dynamo.Op{dynamo.PutOp(l, dynamo.Condition{}), dynamo.PutOp(ref, dynamo.Condition{})}, nil
+	}
+
+	old, err := s.ref(ctx, l.ListingID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	if old.ListingPK == l.PK && old.ListingSK == l.SK {
+		return []dynamo.Op{dynamo.PutOp(l, dynamo.IfVersion(expectedVersion))}, nil
+	}
+	return []dynamo.Op{
+		dynamo.DeleteOp(old.ListingPK, old.ListingSK, dynamo.IfVersion(expectedVersion)),
+		dynamo.PutOp(l, dynamo.Condition{}),
+		dynamo.PutOp(ref, dynamo.Condition{}),
+	}, nil
+}
+
+// ListListings returns all listings, newest first
+func (s *DynamoStore) ListListings(ctx context.Context) ([]models.Listing, error) {
+	var out []models.Listing
+	pk, _ := models.AllListingsKey("", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk, Descending: true}, &out)
+	return out, err
+}
+
+// ListingsBySeller returns a seller's listings, newest first
+func (s *DynamoStore) ListingsBySeller(ctx context.Context, sellerID string) ([]models.Listing, error) {
+	var out []models.Listing
+	pk, _ := models.SellerListingKey(sellerID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, SKPrefix: "LISTING#", Descending: true}, &out)
+	return out, err
+}
+
+// ListingsByPrinting returns the listings for a printing
+func (s *DynamoStore) ListingsByPrinting(ctx context.Context, printingID string) ([]models.Listing, error) {
+	pk, _ := models.ListingKey(printingID, "")
+	return s.partitionListings(ctx, pk)
+}
+
+// ListingsByProduct returns the listings for a sealed product
+func (s *DynamoStore) ListingsByProduct(ctx context.Context, productID string) ([]models.Listing, error) {
+	pk, _ := models.ProductListingKey(productID, "")
+	return s.partitionListings(ctx, pk)
+}
+
+// partitionListings returns the listings stored in a printing's or product's partition, newest
+// first
+func (s *DynamoStore) partitionListings(ctx context.Context, pk string) ([]models.Listing, error) {
+	var out []models.Listing
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "LISTING#"}, &out)
+	sortListings(out)
+	return out, err
+}
+
+// ref reads a listing's reference item
+func (s *DynamoStore) ref(ctx context.Context, listingID string) (listingRef, error) {
+	var ref listingRef
+	pk, sk := models.ListingRefKey(listingID)
+	err := s.get(ctx, pk, sk, &ref)
+	return ref, err
+}
+
+// get reads an item, mapping a missing one to ErrNotFound
+func (s *DynamoStore) get(ctx context.Context, pk, sk string, out interface{}) error {
+	err := s.table.Get(ctx, pk, sk, out)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// write applies ops in one transaction, mapping a failed condition to ErrConflict
+func (s *DynamoStore) write(ctx context.Context, ops []dynamo.Op) error {
+	err := s.table.Write(ctx, ops...)
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,347 @@
+package catalog
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// RowError is a problem with one row of a catalog import
+type RowError struct {
+	Row     int // 1-based, counting the header
+	Message string
+}
+
+// Error implements error
+func (e RowError) Error() string {
+	if e.Row == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("Row %d: %s", e.Row, e.Message)
+}
+
+// ImportResult counts what a catalog import wrote
+type ImportResult struct {
+	PrintingsCreated int
+	PrintingsUpdated int
+	ProductsCreated  int
+	ProductsUpdated  int
+}
+
+// importColumns maps the header names a catalog file may use to the field they fill
+var importColumns = map[string]string{
+	"kind":             "kind",
+	"type":             "kind",
+	"game":             "game",
+	"set_code":         "set_code",
+	"set":              "set_code",
+	"set_name":         "set_name",
+	"name":             "name",
+	"card_name":        "name",
+	"product_name":     "name",
+	"collector_number": "collector_number",
+	"number":           "collector_number",
+	"card_code":        "card_code",
+	"rarity":           "rarity",
+	"image_url":        "image_url",
+	"image":            "image_url",
+	"product_type":     "product_type",
+	"upc":              "upc",
+	"ean":              "upc",
+	"barcode":          "upc",
+	"contents":         "contents",
+}
+
+// maxImportRows caps the rows in one catalog import
+const maxImportRows = 20000
+
+// Import reads a CSV file of printings and sealed products into the catalog. Each row's kind is
+// "card" or "sealed"; a card row gives its game, set_code, name and collector_number, and may give
+// set_name, card_code, rarity and image_url, while a sealed row gives its game, name and
+// product_type, and may give set_code, set_name, upc, image_url and contents, written as
+// "36x Draft Booster; 1x Box Topper". Rows update the printing with the same game, set and
+// collector number, or the product with the same UPC or else the same game, set and name, and
+// create one otherwise. Either every row is written or, when any row has a problem, none is.
+func (c *Catalog) Import(ctx context.Context, r io.Reader) (ImportResult, []RowError, error) {
+	var res ImportResult
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return res, []RowError{{Message: "The file is empty."}}, nil
+	}
+	if err != nil {
+		return res, []RowError{{Message: "The file isn't a CSV file we can read."}}, nil
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\uFEFF")))
+		h = strings.NewReplacer(" ", "_", "-", "_").Replace(h)
+		if field, ok := importColumns[h]; ok {
+			if _, dup := cols[field]; !dup {
+				cols[field] = i
+			}
+		}
+	}
+	for _, required := range []string{"kind", "game", "name"} {
+		if _, ok := cols[required]; !ok {
+			return res, []RowError{{Row: 1, Message: "The header needs kind, game and name columns."}}, nil
+		}
+	}
+
+	idx, err := c.importIndex(ctx)
+	if err != nil {
+		return res, nil, err
+	}
+
+	var printings []models.Printing
+	var products []models.Product
+	var problems []RowError
+	for row := 2; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			problems = append(problems, RowError{Row: row, Message: "the row isn't valid CSV"})
+			continue
+		}
+		if err != nil {
+			return res, nil, err
+		}
+		get := func(field string) string {
+			if i, ok := cols[field]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		if strings.Join(rec, "") == "" {
+			continue
+		}
+		if len(printings)+len(products)+len(problems) >= maxImportRows {
+			return res, []RowError{{Message: fmt.Sprintf("Import at most %d rows at a time.", maxImportRows)}}, nil
+		}
+
+		switch kind := strings.ToLower(get("kind")); kind {
+		case "card", "single", "printing":
+			p, msg := idx.printing(get)
+			if msg != "" {
+				problems = append(problems, RowError{Row: row, Message: msg})
+				continue
+			}
+			printings = append(printings, p)
+		case "sealed", "product":
+			p, msg := idx.product(get)
+			if msg != "" {
+				problems = append(problems, RowError{Row: row, Message: msg})
+				continue
+			}
+			products = append(products, p)
+		default:
+			problems = append(problems, RowError{Row: row, Message: fmt.Sprintf("the kind should be card or sealed, not %q", get("kind"))})
+		}
+	}
+	if len(problems) > 0 {
+		return res, problems, nil
+	}
+	if len(printings)+len(products) == 0 {
+		return res, []RowError{{Message: "The file has nothing in it to import."}}, nil
+	}
+
+	for _, p := range printings {
+		if p.PrintingID == "" {
+			res.PrintingsCreated++
+		} else {
+			res.PrintingsUpdated++
+		}
+		if _, err := c.SavePrinting(ctx, p); err != nil {
+			return res, nil, err
+		}
+	}
+	for _, p := range products {
+		if p.ProductID == "" {
+			res.ProductsCreated++
+		} else {
+			res.ProductsUpdated++
+		}
+		if _, err := c.SaveProduct(ctx, p); err != nil {
+			return res, nil, err
+		}
+	}
+	return res, nil, nil
+}
+
+// importIndex finds the existing printings and products an import row may update. Rows earlier in
+// the same file are added as they are read, so a file that names something twice updates it once.
+type importIndex struct {
+	printings map[string]*models.Printing // game#SET#number
+	products  map[string]*models.Product  // UPC, or game#SET#lower-case name
+}
+
+// importIndex loads the catalog into an index
+func (c *Catalog) importIndex(ctx context.Context) (importIndex, error) {
+	idx := importIndex{printings: map[string]*models.Printing{}, products: map[string]*models.Product{}}
+	printings, err := c.store.ListPrintings(ctx)
+	if err != nil {
+		return idx, err
+	}
+	for i := range printings {
+		p := &printings[i]
+		idx.printings[printingImportKey(p.Game, p.SetCode, p.CollectorNumber)] = p
+	}
+	products, err := c.store.ListProducts(ctx)
+	if err != nil {
+		return idx, err
+	}
+	for i := range products {
+		p := &products[i]
+		idx.products[productImportKey(p.Game, p.SetCode, p.Name)] = p
+		if p.UPC != "" {
+			idx.products[p.UPC] = p
+		}
+	}
+	return idx, nil
+}
+
+// printingImportKey is the natural key an import matches printings on
+func printingImportKey(game, set, number string) string {
+	return strings.ToLower(game) + "#" + strings.ToUpper(set) + "#" + strings.TrimLeft(number, "#")
+}
+
+// productImportKey is the natural key an import matches products without a UPC on
+func productImportKey(game, set, name string) string {
+	return strings.ToLower(game) + "#" + strings.ToUpper(set) + "#" + strings.ToLower(name)
+}
+
+// printing turns a card row into the printing to save, or returns what's wrong with it
+func (idx importIndex) printing(get func(string) string) (models.Printing, string) {
+	set, number := get("set_code"), get("collector_number")
+	switch {
+	case get("game") == "" || get("name") == "":
+		return models.Printing{}, "a card needs a game and name"
+	case set == "" || number == "":
+		return models.Printing{}, "a card needs a set_code and collector_number"
+	}
+	key := printingImportKey(get("game"), set, number)
+
+	var p models.Printing
+	if existing, ok := idx.printings[key]; ok {
+		p = *existing
+	}
+	p.Game = strings.ToLower(get("game"))
+	p.SetCode = strings.ToUpper(set)
+	p.CardName = get("name")
+	p.CollectorNumber = strings.TrimLeft(number, "#")
+	for field, dst := range map[string]*string{"set_name": &p.SetName, "card_code": &p.CardCode, "rarity": &p.Rarity, "image_url": &p.ImageURL} {
+		if v := get(field); v != "" {
+			*dst = v
+		}
+	}
+	idx.printings[key] = &p
+	return p, ""
+}
+
+// product turns a sealed row into the product to save, or returns what's wrong with it
+func (idx importIndex) product(get func(string) string) (models.Product, string) {
+	productType, ok := parseProductType(get("product_type"))
+	if !ok {
+		return models.Product{}, fmt.Sprintf("unknown product_type %q", get("product_type"))
+	}
+	contents, msg := parseContents(get("contents"))
+	if msg != "" {
+		return models.Product{}, msg
+	}
+	upc := strings.ReplaceAll(get("upc"), "-", "")
+	key := productImportKey(get("game"), get("set_code"), get("name"))
+
+	var p models.Product
+	if existing, ok := idx.products[upc]; upc != "" && ok {
+		p = *existing
+	} else if existing, ok := idx.products[key]; ok {
+		p = *existing
+	}
+	p.Game = strings.ToLower(get("game"))
+	p.SetCode = strings.ToUpper(get("set_code"))
+	p.Name = get("name")
+	p.ProductType = productType
+	if upc != "" {
+		p.UPC = upc
+	}
+	if contents != nil {
+		p.Contents = contents
+	}
+	for field, dst := range map[string]*string{"set_name": &p.SetName, "image_url": &p.ImageURL} {
+		if v := get(field); v != "" {
+			*dst = v
+		}
+	}
+	if err := validateProduct(p); err != nil {
+		_, msg, _ := strings.Cut(err.Error(), ": invalid: ")
+		return models.Product{}, msg
+	}
+	idx.products[key] = &p
+	if p.UPC != "" {
+		idx.products[p.UPC] = &p
+	}
+	return p, ""
+}
+
+// parseProductType reads a product type by its key or display name, such as "booster_box" or
+// "Booster box", or the common abbreviation ETB
+func parseProductType(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "etb" {
+		return models.ProductETB, true
+	}
+	for _, t := range models.ProductTypes {
+		if s == t || s == strings.ToLower(models.ProductTypeNames[t]) || strings.ReplaceAll(s, " ", "_") == t {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// parseContents reads a contents list such as "36x Draft Booster; 1x Box Topper". A line
+// without a quantity counts once.
+func parseContents(s string) ([]models.ProductContent, string) {
+	if strings.TrimSpace(s) == "" {
+		return nil, ""
+	}
+	var out []models.ProductContent
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '|' }) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		qty, item := 1, part
+		if i := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' }); i > 0 {
+			n, err := strconv.Atoi(part[:i])
+			if err != nil || n < 1 {
+				return nil, fmt.Sprintf("the contents line %q has a bad quantity", part)
+			}
+			qty = n
+			item = strings.TrimSpace(part[i:])
+			for _, times := range []string{"x ", "X ", "×"} {
+				if strings.HasPrefix(item, times) {
+					item = strings.TrimSpace(item[len(times):])
+					break
+				}
+			}
+		}
+		if item == "" {
+			return nil, fmt.Sprintf("the contents line %q doesn't say what it is", part)
+		}
+		out = append(out, models.ProductContent{Quantity: qty, Item: item})
+	}
+	return out, ""
+}
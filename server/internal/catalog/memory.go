@@ -0,0 +1,210 @@
+package catalog
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	printings map[string]models.Printing
+	products  map[string]models.Product
+	listings  map[string]models.Listing
+	outbox    *outbox.MemoryStore
+}
+
+// NewMemoryStore creates an empty MemoryStore that writes domain events to ob, or drops them when
+// ob is nil
+func NewMemoryStore(ob *outbox.MemoryStore) *MemoryStore {
+	return &MemoryStore{
+		printings: map[string]models.Printing{},
+		products:  map[string]models.Product{},
+		listings:  map[string]models.Listing{},
+		outbox:    ob,
+	}
+}
+
+// GetPrinting returns a printing by ID
+func (s *MemoryStore) GetPrinting(ctx context.Context, printingID string) (models.Printing, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.printings[printingID]
+	if !ok {
+		return models.Printing{}, ErrNotFound
+	}
+	return p, nil
+}
+
+// PutPrinting stores a printing
+func (s *MemoryStore) PutPrinting(ctx context.Context, p models.Printing) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.printings[p.PrintingID] = p
+	return nil
+}
+
+// ListPrintings returns all printings ordered by card name
+func (s *MemoryStore) ListPrintings(ctx context.Context) ([]models.Printing, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]models.Printing, 0, len(s.printings))
+	for _, p := range s.printings {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CardName != out[j].CardName {
+			return out[i].CardName < out[j].CardName
+		}
+		return out[i].PrintingID < out[j].PrintingID
+	})
+	return out, nil
+}
+
+// GetProduct returns a sealed product by ID
+func (s *MemoryStore) GetProduct(ctx context.Context, productID string) (models.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.products[productID]
+	if !ok {
+		return models.Product{}, ErrNotFound
+	}
+	return p, nil
+}
+
+// PutProduct stores a sealed product
+func (s *MemoryStore) PutProduct(ctx context.Context, p models.Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.products[p.ProductID] = p
+	return nil
+}
+
+// ListProducts returns all sealed products ordered by name
+func (s *MemoryStore) ListProducts(ctx context.Context) ([]models.Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]models.Product, 0, len(s.products))
+	for _, p := range s.products {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].ProductID < out[j].ProductID
+	})
+	return out, nil
+}
+
+// GetListing returns a listing by ID
+func (s *MemoryStore) GetListing(ctx context.Context, listingID string) (models.Listing, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.listings[listingID]
+	if !ok {
+		return models.Listing{}, ErrNotFound
+	}
+	return l, nil
+}
+
+// PutListing stores a listing and its events, enforcing the expected version when one is given
+func (s *MemoryStore) PutListing(ctx context.Context, l models.Listing, expectedVersion int64, out []models.DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expectedVersion >= 0 {
+		existing, ok := s.listings[l.ListingID]
+		if !ok || existing.Version != expectedVersion {
+			return ErrConflict
+		}
+	}
+	s.listings[l.ListingID] = l
+	s.outbox.Append(out...)
+	return nil
+}
+
+// PutListings writes several listings and their events atomically, each listing conditional on
+// its expected version
+func (s *MemoryStore) PutListings(ctx context.Context, ls []models.Listing, expectedVersions []int64, out []models.DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, l := range ls {
+		existing, ok := s.listings[l.ListingID]
+		if !ok || existing.Version != expectedVersions[i] {
+			return ErrConflict
+		}
+	}
+	for _, l := range ls {
+		s.listings[l.ListingID] = l
+	}
+	s.outbox.Append(out...)
+	return nil
+}
+
+// ListListings returns all listings, newest first
+func (s *MemoryStore) ListListings(ctx context.Context) ([]models.Listing, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]models.Listing, 0, len(s.listings))
+	for _, l := range s.listings {
+		out = append(out, l)
+	}
+	sortListings(out)
+	return out, nil
+}
+
+// ListingsBySeller returns a seller's listings, newest first
+func (s *MemoryStore) ListingsBySeller(ctx context.Context, sellerID string) ([]models.Listing, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Listing
+	for _, l := range s.listings {
+		if l.SellerID == sellerID {
+			out = append(out, l)
+		}
+	}
+	sortListings(out)
+	return out, nil
+}
+
+// ListingsByPrinting returns the listings for a printing
+func (s *MemoryStore) ListingsByPrinting(ctx context.Context, printingID string) ([]models.Listing, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Listing
+	for _, l := range s.listings {
+		if l.PrintingID == printingID && !l.IsSealed() {
+			out = append(out, l)
+		}
+	}
+	sortListings(out)
+	return out, nil
+}
+
+// ListingsByProduct returns the listings for a sealed product
+func (s *MemoryStore) ListingsByProduct(ctx context.Context, productID string) ([]models.Listing, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Listing
+	for _, l := range s.listings {
+		if l.IsSealed() && l.ProductID == productID {
+			out = append(out, l)
+		}
+	}
+	sortListings(out)
+	return out, nil
+}
+
+// sortListings orders listings newest first, breaking ties by ID
+func sortListings(ls []models.Listing) {
+	sort.Slice(ls, func(i, j int) bool {
+		if ls[i].CreatedAt != ls[j].CreatedAt {
+			return ls[i].CreatedAt > ls[j].CreatedAt
+		}
+		return ls[i].ListingID < ls[j].ListingID
+	})
+}
@@ -0,0 +1,246 @@
+// Package collection keeps track of the cards users own and what their collections are worth.
+//
+// Items are entered by hand, imported from a CSV file, or added automatically when an order the
+// user bought completes. Each item is valued at the price guide's market price for its condition,
+// falling back to the every-condition price, and the portfolio's value and cost are recorded once
+// a day so users can follow their gain or loss over time. Items can be put up for sale in one go.
+package collection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/pricing"
+)
+
+// dayLayout formats acquisition and snapshot days
+const dayLayout = "2006-01-02"
+
+var (
+	// ErrNotFound is returned when a collection item does not exist
+	ErrNotFound = errors.New("collection: not found")
+	// ErrInvalid is returned for an item with a bad printing, condition, quantity, price or date
+	ErrInvalid = errors.New("collection: invalid item")
+	// ErrTooMany is returned when a collection is full
+	ErrTooMany = errors.New("collection: your collection is full")
+)
+
+// Store persists collection items and portfolio snapshots.
+type Store interface {
+	PutItem(ctx context.Context, it models.CollectionItem) error
+	GetItem(ctx context.Context, userID, itemID string) (models.CollectionItem, error)
+	DeleteItem(ctx context.Context, userID, itemID string) error
+	// ItemsByUser returns a user's collection, newest first
+	ItemsByUser(ctx context.Context, userID string) ([]models.CollectionItem, error)
+	// Collectors returns the users with at least one item
+	Collectors(ctx context.Context) ([]string, error)
+	PutSnapshot(ctx context.Context, snap models.PortfolioSnapshot) error
+	// Snapshots returns a user's snapshots from a day on, oldest first
+	Snapshots(ctx context.Context, userID, sinceDay string) ([]models.PortfolioSnapshot, error)
+}
+
+// Options limits collection sizes and imports
+type Options struct {
+	MaxItems      int // items allowed in one collection
+	MaxImportRows int // rows allowed in one CSV import
+	HistoryDays   int // days of portfolio history kept for display
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	MaxItems:      5000,
+	MaxImportRows: 2000,
+	HistoryDays:   365,
+}
+
+// Service manages collections and values them.
+type Service struct {
+	store    Store
+	catalog  *catalog.Catalog
+	pricing  *pricing.Service
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+}
+
+// New creates a collection Service
+func New(store Store, c *catalog.Catalog, p *pricing.Service, opts Options, errorLog *log.Logger) *Service {
+	if opts.MaxItems <= 0 {
+		opts.MaxItems = DefaultOptions.MaxItems
+	}
+	if opts.MaxImportRows <= 0 {
+		opts.MaxImportRows = DefaultOptions.MaxImportRows
+	}
+	if opts.HistoryDays <= 0 {
+		opts.HistoryDays = DefaultOptions.HistoryDays
+	}
+	return &Service{store: store, catalog: c, pricing: p, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// Attach adds the items of every completed order to the buyer's collection as the order's
+// completed event is published from the outbox
+func (s *Service) Attach(relay *outbox.Relay) {
+	relay.Subscribe("collection", func(ctx context.Context, ev models.DomainEvent) error {
+		var change orders.Change
+		if err := ev.Decode(&change); err != nil {
+			return err
+		}
+		_, err := s.AddFromOrder(ctx, change.Order)
+		return err
+	}, models.EventOrderCompleted)
+}
+
+// Add validates and stores a manually entered item
+func (s *Service) Add(ctx context.Context, it models.CollectionItem) (models.CollectionItem, error) {
+	it.Source = models.CollectionSourceManual
+	items, err := s.addAll(ctx, it.UserID, []models.CollectionItem{it})
+	if err != nil {
+		return models.CollectionItem{}, err
+	}
+	return items[0], nil
+}
+
+// AddFromOrder adds a completed order's items to the buyer's collection at the price paid. Items
+// already added from the order are skipped, so it's safe to call more than once.
+func (s *Service) AddFromOrder(ctx context.Context, o models.Order) ([]models.CollectionItem, error) {
+	existing, err := s.store.ItemsByUser(ctx, o.BuyerID)
+	if err != nil {
+		return nil, err
+	}
+	added := map[string]bool{}
+	for _, it := range existing {
+		if it.OrderID == o.OrderID {
+			added[it.OrderListing] = true
+		}
+	}
+
+	acquired := s.now().UTC().Format(dayLayout)
+	if t, err := time.Parse(time.RFC3339, o.CreatedAt); err == nil {
+		acquired = t.UTC().Format(dayLayout)
+	}
+	var items []models.CollectionItem
+	for _, oi := range o.Items {
+		// collections track raw cards, so slabs, whose condition is a grade label, aren't added
+		if oi.PrintingID == "" || oi.Quantity < 1 || added[oi.ListingID] || models.IsGradeLabel(oi.Condition) {
+			continue
+		}
+		items = append(items, models.CollectionItem{
+			UserID:       o.BuyerID,
+			PrintingID:   oi.PrintingID,
+			Condition:    oi.Condition,
+			Language:     oi.Language,
+			Foil:         oi.Foil,
+			Quantity:     oi.Quantity,
+			CostCents:    oi.UnitPriceCents,
+			AcquiredOn:   acquired,
+			Source:       models.CollectionSourceOrder,
+			OrderID:      o.OrderID,
+			OrderListing: oi.ListingID,
+		})
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return s.addAll(ctx, o.BuyerID, items)
+}
+
+// addAll validates a batch of a user's items and stores them, or none of them if any is invalid
+// or they would overfill the collection
+func (s *Service) addAll(ctx context.Context, userID string, items []models.CollectionItem) ([]models.CollectionItem, error) {
+	today := s.now().UTC().Format(dayLayout)
+	for i := range items {
+		it := &items[i]
+		it.UserID = userID
+		it.Language = strings.TrimSpace(it.Language)
+		if it.Language == "" {
+			it.Language = "en"
+		}
+		if it.AcquiredOn == "" {
+			it.AcquiredOn = today
+		}
+		if err := validate(*it, today); err != nil {
+			return nil, err
+		}
+		if _, err := s.catalog.Printing(ctx, it.PrintingID); err != nil {
+			if errors.Is(err, catalog.ErrNotFound) {
+				return nil, fmt.Errorf("%w: unknown printing", ErrInvalid)
+			}
+			return nil, err
+		}
+	}
+
+	existing, err := s.store.ItemsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing)+len(items) > s.opts.MaxItems {
+		return nil, ErrTooMany
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	for i := range items {
+		it := &items[i]
+		it.ItemID = ids.New()
+		it.Type = models.ItemTypeCollection
+		it.PK, it.SK = models.CollectionItemKey(userID, it.ItemID)
+		it.GSI1PK, it.GSI1SK = models.CollectorKey(userID, it.ItemID)
+		it.CreatedAt, it.UpdatedAt = now, now
+		if err := s.store.PutItem(ctx, *it); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// Remove deletes an item from a user's collection
+func (s *Service) Remove(ctx context.Context, userID, itemID string) error {
+	return s.store.DeleteItem(ctx, userID, itemID)
+}
+
+// Items returns a user's collection, newest first
+func (s *Service) Items(ctx context.Context, userID string) ([]models.CollectionItem, error) {
+	return s.store.ItemsByUser(ctx, userID)
+}
+
+// validate checks an item's condition, quantity, cost and acquisition day
+func validate(it models.CollectionItem, today string) error {
+	if it.PrintingID == "" {
+		return fmt.Errorf("%w: choose a card", ErrInvalid)
+	}
+	if models.ConditionRank(it.Condition) == len(models.Conditions) {
+		return fmt.Errorf("%w: unknown condition %q", ErrInvalid, it.Condition)
+	}
+	if it.Quantity < 1 || it.Quantity > 9999 {
+		return fmt.Errorf("%w: the quantity must be from 1 to 9999", ErrInvalid)
+	}
+	if it.CostCents < 0 {
+		return fmt.Errorf("%w: the price paid can't be negative", ErrInvalid)
+	}
+	if _, err := time.Parse(dayLayout, it.AcquiredOn); err != nil {
+		return fmt.Errorf("%w: the acquisition date must look like 2024-03-31", ErrInvalid)
+	}
+	if it.AcquiredOn > today {
+		return fmt.Errorf("%w: the acquisition date can't be in the future", ErrInvalid)
+	}
+	return nil
+}
+
+// sortItems orders items newest first
+func sortItems(items []models.CollectionItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].CreatedAt != items[j].CreatedAt {
+			return items[i].CreatedAt > items[j].CreatedAt
+		}
+		return items[i].ItemID > items[j].ItemID
+	})
+}
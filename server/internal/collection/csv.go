@@ -0,0 +1,277 @@
+package collection
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+)
+
+// RowError is a problem with one row of a CSV import
+type RowError struct {
+	Row     int // 1-based, counting the header
+	Message string
+}
+
+// Error implements error
+func (e RowError) Error() string {
+	if e.Row == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("Row %d: %s", e.Row, e.Message)
+}
+
+// csvColumns maps the header names a CSV file may use to the field they fill
+var csvColumns = map[string]string{
+	"printing_id":      "printing_id",
+	"printing":         "printing_id",
+	"set_code":         "set_code",
+	"set":              "set_code",
+	"collector_number": "collector_number",
+	"number":           "collector_number",
+	"name":             "name",
+	"card":             "name",
+	"card_name":        "name",
+	"condition":        "condition",
+	"language":         "language",
+	"foil":             "foil",
+	"quantity":         "quantity",
+	"qty":              "quantity",
+	"count":            "quantity",
+	"price":            "price",
+	"cost":             "price",
+	"purchase_price":   "price",
+	"acquired":         "acquired",
+	"acquired_on":      "acquired",
+	"date":             "acquired",
+}
+
+// conditionNames maps the spelled-out conditions exports use to ours
+var conditionNames = map[string]string{
+	"near mint":         "NM",
+	"mint":              "NM",
+	"lightly played":    "LP",
+	"excellent":         "LP",
+	"moderately played": "MP",
+	"good":              "MP",
+	"heavily played":    "HP",
+	"played":            "HP",
+	"damaged":           "DMG",
+	"poor":              "DMG",
+}
+
+// Import reads a CSV file with a header row into a user's collection. A row names its card by
+// printing_id, by set_code and collector_number, or by name with an optional set_code, and may
+// give its condition, language, foil, quantity, price paid per copy and acquired date. Either
+// every row is added or, when any row has a problem, none is and the problems are returned.
+func (s *Service) Import(ctx context.Context, userID string, r io.Reader) ([]models.CollectionItem, []RowError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, []RowError{{Message: "The file is empty."}}, nil
+	}
+	if err != nil {
+		return nil, []RowError{{Message: "The file isn't a CSV file we can read."}}, nil
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\uFEFF")))
+		h = strings.NewReplacer(" ", "_", "-", "_").Replace(h)
+		if field, ok := csvColumns[h]; ok {
+			if _, dup := cols[field]; !dup {
+				cols[field] = i
+			}
+		}
+	}
+	_, byID := cols["printing_id"]
+	_, byNumber := cols["collector_number"]
+	_, byName := cols["name"]
+	if !byID && !byNumber && !byName {
+		return nil, []RowError{{Row: 1, Message: "The header needs a printing_id, collector_number or name column."}}, nil
+	}
+
+	idx, err := s.printingIndex(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	today := s.now().UTC().Format(dayLayout)
+	var items []models.CollectionItem
+	var problems []RowError
+	for row := 2; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			problems = append(problems, RowError{Row: row, Message: "the row isn't valid CSV"})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		get := func(field string) string {
+			if i, ok := cols[field]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		if strings.Join(rec, "") == "" {
+			continue
+		}
+		if len(items)+len(problems) >= s.opts.MaxImportRows {
+			return nil, []RowError{{Message: fmt.Sprintf("Import at most %d rows at a time.", s.opts.MaxImportRows)}}, nil
+		}
+
+		it, msg := idx.row(get)
+		if msg != "" {
+			problems = append(problems, RowError{Row: row, Message: msg})
+			continue
+		}
+		it.Source = models.CollectionSourceImport
+		if err := validate(withDefaults(it, today), today); err != nil {
+			_, msg, _ := strings.Cut(err.Error(), ": invalid item: ")
+			problems = append(problems, RowError{Row: row, Message: msg})
+			continue
+		}
+		items = append(items, it)
+	}
+	if len(problems) > 0 {
+		return nil, problems, nil
+	}
+	if len(items) == 0 {
+		return nil, []RowError{{Message: "The file has no cards in it."}}, nil
+	}
+
+	items, err = s.addAll(ctx, userID, items)
+	if errors.Is(err, ErrTooMany) {
+		return nil, []RowError{{Message: "These cards would overfill your collection."}}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return items, nil, nil
+}
+
+// withDefaults fills in the language and acquisition day addAll would
+func withDefaults(it models.CollectionItem, today string) models.CollectionItem {
+	if it.Language == "" {
+		it.Language = "en"
+	}
+	if it.AcquiredOn == "" {
+		it.AcquiredOn = today
+	}
+	return it
+}
+
+// printingIndex finds catalog printings the ways a CSV row can name them
+type printingIndex struct {
+	byID     map[string]models.Printing
+	byNumber map[string]models.Printing   // SET#number
+	byName   map[string][]models.Printing // lower-case name
+}
+
+// printingIndex loads the catalog into an index
+func (s *Service) printingIndex(ctx context.Context) (printingIndex, error) {
+	all, err := s.catalog.Printings(ctx)
+	if err != nil {
+		return printingIndex{}, err
+	}
+	idx := printingIndex{
+		byID:     map[string]models.Printing{},
+		byNumber: map[string]models.Printing{},
+		byName:   map[string][]models.Printing{},
+	}
+	for _, p := range all {
+		idx.byID[p.PrintingID] = p
+		idx.byNumber[strings.ToUpper(p.SetCode)+"#"+p.CollectorNumber] = p
+		name := strings.ToLower(p.CardName)
+		idx.byName[name] = append(idx.byName[name], p)
+	}
+	return idx, nil
+}
+
+// row turns a CSV row into an item, or returns what's wrong with it
+func (idx printingIndex) row(get func(string) string) (models.CollectionItem, string) {
+	var it models.CollectionItem
+
+	set := strings.ToUpper(get("set_code"))
+	switch id, number, name := get("printing_id"), get("collector_number"), get("name"); {
+	case id != "":
+		p, ok := idx.byID[id]
+		if !ok {
+			return it, fmt.Sprintf("no card has the printing ID %q", id)
+		}
+		it.PrintingID = p.PrintingID
+	case number != "" && set != "":
+		p, ok := idx.byNumber[set+"#"+strings.TrimLeft(number, "#")]
+		if !ok {
+			return it, fmt.Sprintf("no card is number %s in set %s", number, set)
+		}
+		it.PrintingID = p.PrintingID
+	case name != "":
+		var matches []models.Printing
+		for _, p := range idx.byName[strings.ToLower(name)] {
+			if set == "" || strings.EqualFold(p.SetCode, set) {
+				matches = append(matches, p)
+			}
+		}
+		switch {
+		case len(matches) == 0 && set != "":
+			return it, fmt.Sprintf("no card named %q is in set %s", name, set)
+		case len(matches) == 0:
+			return it, fmt.Sprintf("no card is named %q", name)
+		case len(matches) > 1 && set != "":
+			return it, fmt.Sprintf("set %s has more than one %q, so add a collector_number", set, name)
+		case len(matches) > 1:
+			return it, fmt.Sprintf("%q is in more than one set, so add a set_code", name)
+		}
+		it.PrintingID = matches[0].PrintingID
+	default:
+		return it, "the row doesn't say which card it is"
+	}
+
+	it.Condition = models.Conditions[0]
+	if c := get("condition"); c != "" {
+		if known, ok := conditionNames[strings.ToLower(c)]; ok {
+			c = known
+		}
+		it.Condition = strings.ToUpper(c)
+	}
+	it.Language = get("language")
+	switch strings.ToLower(get("foil")) {
+	case "", "0", "false", "no", "n", "normal", "nonfoil", "non-foil":
+	case "1", "true", "yes", "y", "foil", "etched":
+		it.Foil = true
+	default:
+		return it, fmt.Sprintf("foil should be yes or no, not %q", get("foil"))
+	}
+
+	it.Quantity = 1
+	if q := get("quantity"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil {
+			return it, fmt.Sprintf("the quantity %q isn't a whole number", q)
+		}
+		it.Quantity = n
+	}
+	if p := get("price"); p != "" {
+		cents, err := money.Parse(p)
+		if err != nil {
+			return it, fmt.Sprintf("the price %q isn't an amount such as 4.99", p)
+		}
+		it.CostCents = cents
+	}
+	it.AcquiredOn = get("acquired")
+	return it, ""
+}
@@ -0,0 +1,88 @@
+package collection
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. A user's items and snapshots share the
+// user's partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// PutItem creates or replaces an item
+func (s *DynamoStore) PutItem(ctx context.Context, it models.CollectionItem) error {
+	return s.table.Put(ctx, it, dynamo.Condition{})
+}
+
+// GetItem returns one of a user's items
+func (s *DynamoStore) GetItem(ctx context.Context, userID, itemID string) (models.CollectionItem, error) {
+	var it models.CollectionItem
+	pk, sk := models.CollectionItemKey(userID, itemID)
+	err := s.table.Get(ctx, pk, sk, &it)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return models.CollectionItem{}, ErrNotFound
+	}
+	return it, err
+}
+
+// DeleteItem removes one of a user's items
+func (s *DynamoStore) DeleteItem(ctx context.Context, userID, itemID string) error {
+	pk, sk := models.CollectionItemKey(userID, itemID)
+	err := s.table.Delete(ctx, pk, sk, dynamo.IfExists())
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ItemsByUser returns a user's collection, newest first
+func (s *DynamoStore) ItemsByUser(ctx context.Context, userID string) ([]models.CollectionItem, error) {
+	var out []models.CollectionItem
+	pk, _ := models.CollectionItemKey(userID, "")
+	if err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "COLLECTION#"}, &out); err != nil {
+		return nil, err
+	}
+	sortItems(out)
+	return out, nil
+}
+
+// Collectors returns the users with at least one item
+func (s *DynamoStore) Collectors(ctx context.Context) ([]string, error) {
+	var items []models.CollectionItem
+	pk, _ := models.CollectorKey("", "")
+	if err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk}, &items); err != nil {
+		return nil, err
+	}
+	// the index is ordered by user, so each user's items are together
+	var out []string
+	for _, it := range items {
+		if len(out) == 0 || out[len(out)-1] != it.UserID {
+			out = append(out, it.UserID)
+		}
+	}
+	return out, nil
+}
+
+// PutSnapshot writes a day's snapshot, replacing any earlier one for the day
+func (s *DynamoStore) PutSnapshot(ctx context.Context, snap models.PortfolioSnapshot) error {
+	return s.table.Put(ctx, snap, dynamo.Condition{})
+}
+
+// Snapshots returns a user's snapshots from a day on, oldest first
+func (s *DynamoStore) Snapshots(ctx context.Context, userID, sinceDay string) ([]models.PortfolioSnapshot, error) {
+	var out []models.PortfolioSnapshot
+	pk, from := models.PortfolioSnapshotKey(userID, sinceDay)
+	_, prefix := models.PortfolioSnapshotKey(userID, "")
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKFrom: from, SKTo: dynamo.Through(prefix)}, &out)
+	return out, err
+}
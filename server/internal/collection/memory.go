@@ -0,0 +1,109 @@
+package collection
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	items     map[string]models.CollectionItem               // item ID -> item
+	snapshots map[string]map[string]models.PortfolioSnapshot // user ID -> day -> snapshot
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		items:     map[string]models.CollectionItem{},
+		snapshots: map[string]map[string]models.PortfolioSnapshot{},
+	}
+}
+
+// PutItem creates or replaces an item
+func (s *MemoryStore) PutItem(ctx context.Context, it models.CollectionItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[it.ItemID] = it
+	return nil
+}
+
+// GetItem returns one of a user's items
+func (s *MemoryStore) GetItem(ctx context.Context, userID, itemID string) (models.CollectionItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	it, ok := s.items[itemID]
+	if !ok || it.UserID != userID {
+		return models.CollectionItem{}, ErrNotFound
+	}
+	return it, nil
+}
+
+// DeleteItem removes one of a user's items
+func (s *MemoryStore) DeleteItem(ctx context.Context, userID, itemID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if it, ok := s.items[itemID]; !ok || it.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.items, itemID)
+	return nil
+}
+
+// ItemsByUser returns a user's collection, newest first
+func (s *MemoryStore) ItemsByUser(ctx context.Context, userID string) ([]models.CollectionItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.CollectionItem
+	for _, it := range s.items {
+		if it.UserID == userID {
+			out = append(out, it)
+		}
+	}
+	sortItems(out)
+	return out, nil
+}
+
+// Collectors returns the users with at least one item
+func (s *MemoryStore) Collectors(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := map[string]bool{}
+	var out []string
+	for _, it := range s.items {
+		if !seen[it.UserID] {
+			seen[it.UserID] = true
+			out = append(out, it.UserID)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// PutSnapshot writes a day's snapshot, replacing any earlier one for the day
+func (s *MemoryStore) PutSnapshot(ctx context.Context, snap models.PortfolioSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshots[snap.UserID] == nil {
+		s.snapshots[snap.UserID] = map[string]models.PortfolioSnapshot{}
+	}
+	s.snapshots[snap.UserID][snap.Day] = snap
+	return nil
+}
+
+// Snapshots returns a user's snapshots from a day on, oldest first
+func (s *MemoryStore) Snapshots(ctx context.Context, userID, sinceDay string) ([]models.PortfolioSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.PortfolioSnapshot
+	for day, snap := range s.snapshots[userID] {
+		if day >= sinceDay {
+			out = append(out, snap)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Day < out[j].Day })
+	return out, nil
+}
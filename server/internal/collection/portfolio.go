@@ -0,0 +1,235 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// ListingTag is put on listings created from collection items, so repricing rules can target them
+const ListingTag = "collection"
+
+// Holding is a collection item with its printing and what it's worth
+type Holding struct {
+	Item       models.CollectionItem
+	Printing   models.Printing
+	UnitCents  int64 // market price per copy
+	Priced     bool  // the printing has a market price
+	ValueCents int64
+	CostCents  int64
+	GainCents  int64 // value less cost, when the item is priced and its cost is known
+	HasGain    bool
+}
+
+// Portfolio is a user's collection valued at market prices
+type Portfolio struct {
+	Holdings   []Holding
+	ValueCents int64 // market value of the priced copies
+	CostCents  int64 // what the user paid for the copies with a known cost
+	GainCents  int64 // gain over the copies that are priced and have a known cost
+	GainBps    int64 // GainCents relative to what those copies cost
+	Copies     int
+	Unpriced   int // copies with no market price
+}
+
+// Skipped is a collection item that wasn't put up for sale, with why
+type Skipped struct {
+	Item     models.CollectionItem
+	CardName string
+	Reason   string
+}
+
+// Value prices a user's collection at the current market prices
+func (s *Service) Value(ctx context.Context, userID string) (Portfolio, error) {
+	items, err := s.store.ItemsByUser(ctx, userID)
+	if err != nil {
+		return Portfolio{}, err
+	}
+
+	var p Portfolio
+	var basis int64
+	printings := map[string]models.Printing{}
+	for _, it := range items {
+		printing, ok := printings[it.PrintingID]
+		if !ok {
+			printing, err = s.catalog.Printing(ctx, it.PrintingID)
+			if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+				return Portfolio{}, err
+			}
+			printings[it.PrintingID] = printing
+		}
+		unit, priced, err := s.marketPrice(ctx, it)
+		if err != nil {
+			return Portfolio{}, err
+		}
+
+		h := Holding{Item: it, Printing: printing, UnitCents: unit, Priced: priced}
+		h.CostCents = it.CostCents * int64(it.Quantity)
+		p.Copies += it.Quantity
+		p.CostCents += h.CostCents
+		if priced {
+			h.ValueCents = unit * int64(it.Quantity)
+			p.ValueCents += h.ValueCents
+		} else {
+			p.Unpriced += it.Quantity
+		}
+		if priced && it.CostCents > 0 {
+			h.GainCents, h.HasGain = h.ValueCents-h.CostCents, true
+			p.GainCents += h.GainCents
+			basis += h.CostCents
+		}
+		p.Holdings = append(p.Holdings, h)
+	}
+	if basis > 0 {
+		p.GainBps = p.GainCents * 10000 / basis
+	}
+	return p, nil
+}
+
+// marketPrice returns the market price of one copy of an item, in its condition if that has
+// sold recently and otherwise across every condition
+func (s *Service) marketPrice(ctx context.Context, it models.CollectionItem) (int64, bool, error) {
+	cents, ok, err := s.pricing.MarketPrice(ctx, it.PrintingID, it.Condition)
+	if err != nil || ok {
+		return cents, ok, err
+	}
+	return s.pricing.MarketPrice(ctx, it.PrintingID, "")
+}
+
+// Snapshot records today's value of a user's collection
+func (s *Service) Snapshot(ctx context.Context, userID string) (models.PortfolioSnapshot, error) {
+	p, err := s.Value(ctx, userID)
+	if err != nil {
+		return models.PortfolioSnapshot{}, err
+	}
+	now := s.now().UTC()
+	snap := models.PortfolioSnapshot{
+		Type:       models.ItemTypePortfolio,
+		UserID:     userID,
+		Day:        now.Format(dayLayout),
+		ValueCents: p.ValueCents,
+		CostCents:  p.CostCents,
+		Copies:     p.Copies,
+		Unpriced:   p.Unpriced,
+		UpdatedAt:  now.Format(time.RFC3339),
+	}
+	snap.PK, snap.SK = models.PortfolioSnapshotKey(userID, snap.Day)
+	if err := s.store.PutSnapshot(ctx, snap); err != nil {
+		return models.PortfolioSnapshot{}, err
+	}
+	return snap, nil
+}
+
+// SnapshotAll records today's value of every collection. It returns how many were recorded.
+func (s *Service) SnapshotAll(ctx context.Context) (int, error) {
+	users, err := s.store.Collectors(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, userID := range users {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if _, err := s.Snapshot(ctx, userID); err != nil {
+			s.errorLog.Printf("valuing %s's collection failed: %v", userID, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// History returns up to days of a user's daily portfolio value, oldest first
+func (s *Service) History(ctx context.Context, userID string, days int) ([]models.PortfolioSnapshot, error) {
+	if days <= 0 || days > s.opts.HistoryDays {
+		days = s.opts.HistoryDays
+	}
+	since := s.now().UTC().AddDate(0, 0, -days+1).Format(dayLayout)
+	return s.store.Snapshots(ctx, userID, since)
+}
+
+// Run values every collection now and then on every tick until ctx is cancelled. Each day's
+// snapshot is rewritten on every tick, so it ends up holding the day's closing value.
+func (s *Service) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := s.SnapshotAll(ctx); err != nil && ctx.Err() == nil {
+			s.errorLog.Printf("valuing collections failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListForSale puts collection items up for sale at their market price, one listing per item.
+// Items that are already for sale or have no market price are skipped.
+func (s *Service) ListForSale(ctx context.Context, userID string, itemIDs []string) ([]models.Listing, []Skipped, error) {
+	var listed []models.Listing
+	var skipped []Skipped
+	for _, id := range itemIDs {
+		it, err := s.store.GetItem(ctx, userID, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return listed, skipped, err
+		}
+		printing, err := s.catalog.Printing(ctx, it.PrintingID)
+		if errors.Is(err, catalog.ErrNotFound) {
+			skipped = append(skipped, Skipped{Item: it, Reason: "the card is no longer in the catalog"})
+			continue
+		}
+		if err != nil {
+			return listed, skipped, err
+		}
+
+		if it.ListingID != "" {
+			l, err := s.catalog.Listing(ctx, it.ListingID)
+			if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+				return listed, skipped, err
+			}
+			if err == nil && l.IsActive() {
+				skipped = append(skipped, Skipped{Item: it, CardName: printing.CardName, Reason: "already for sale"})
+				continue
+			}
+		}
+		price, ok, err := s.marketPrice(ctx, it)
+		if err != nil {
+			return listed, skipped, err
+		}
+		if !ok || price < 1 {
+			skipped = append(skipped, Skipped{Item: it, CardName: printing.CardName, Reason: "no market price yet"})
+			continue
+		}
+
+		l, err := s.catalog.SaveListing(ctx, models.Listing{
+			SellerID:   userID,
+			PrintingID: it.PrintingID,
+			Condition:  it.Condition,
+			Language:   it.Language,
+			Foil:       it.Foil,
+			PriceCents: price,
+			Quantity:   it.Quantity,
+			Tags:       []string{ListingTag},
+		})
+		if err != nil {
+			return listed, skipped, err
+		}
+		listed = append(listed, l)
+
+		now := s.now().UTC().Format(time.RFC3339)
+		it.ListingID, it.ListedAt, it.UpdatedAt = l.ListingID, now, now
+		if err := s.store.PutItem(ctx, it); err != nil {
+			return listed, skipped, err
+		}
+	}
+	return listed, skipped, nil
+}
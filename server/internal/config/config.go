@@ -5,16 +5,74 @@ import (
 	"log"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/apikeys"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/auctions"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/cognito"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/collection"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/disputes"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/fees"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/grading"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/hooks"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/jobs"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ledger"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/messages"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/notifications"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/offers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/optimizer"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/payments"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/photos"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/pricing"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/repricing"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/reviews"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/search"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/sellers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/shipping"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/tracking"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/wants"
 )
 
 // AppConfig holds the application configuration and shared dependencies.
 type AppConfig struct {
 	UseCache      bool                          // Whether to use the template cache
 	TemplateCache map[string]*template.Template // Cached templates
+	EmailCache    map[string]*template.Template // Cached email templates
 	InfoLog       *log.Logger                   // Logger for informational messages
 	ErrorLog      *log.Logger                   // Logger for error messages
 	InProduction  bool                          // True if running in production
+	BaseURL       string                        // The site's public address, for links that leave the site and come back
 	Session       *scs.SessionManager           // Session manager
 	CognitoClient *cognito.CognitoClient        // AWS Cognito client for authentication
+	Catalog       *catalog.Catalog              // Card printings and seller listings
+	Search        *search.Index                 // Full-text index over active listings
+	Grading       *grading.Service              // Grade scale checks and cert verification for graded listings
+	Carts         *cart.Service                 // Shopping carts for signed-in and anonymous buyers
+	Orders        *orders.Service               // Checkout and the order lifecycle
+	Payments      *payments.Service             // Payment intents and payment webhooks
+	StripeKey     string                        // Stripe publishable key for the browser; empty when using the fake provider
+	Ledger        *ledger.Service               // Double-entry journal of payments, fees, refunds and payouts
+	Sellers       *sellers.Service              // Seller profiles
+	Fees          *fees.Engine                  // Versioned commission rules
+	Shipping      *shipping.Service             // Seller shipping profiles, checkout rates and labels
+	Tracking      *tracking.Service             // Carrier tracking timelines, delivery and auto-completion
+	Disputes      *disputes.Service             // Buyer disputes, returns and dispute refunds
+	Photos        photos.Storage                // Uploaded listing photos and dispute evidence
+	Reviews       *reviews.Service              // Buyer reviews of sellers and seller scores
+	Offers        *offers.Service               // Buyer offers and counter-offers on listings
+	Auctions      *auctions.Service             // Timed single-card auctions with proxy bidding
+	Optimizer     *optimizer.Optimizer          // Picks listings to minimize card plus shipping cost
+	Wants         *wants.Service                // Buyer want lists, match digests and the want list optimizer
+	Pricing       *pricing.Service              // Completed sales, the market price guide and price history
+	Collection    *collection.Service           // Users' card collections and their market value over time
+	Repricing     *repricing.Service            // Sellers' automatic repricing rules and their runs
+	Messages      *messages.Service             // Buyer and seller conversations about listings and orders
+	Notifications *notifications.Service        // Email and on-site notifications, preferences and the email outbox
+	Jobs          *jobs.Runner                  // Background job queue, schedules and dead letters
+	Events        *outbox.Relay                 // Domain events written with each change, and the relay publishing them
+	APIKeys       *apikeys.Service              // Sellers' API keys for the seller API
+	Webhooks      *hooks.Service                // Sellers' webhook endpoints and signed deliveries to them
+	Admins        map[string]bool               // User IDs allowed into the admin pages
 }
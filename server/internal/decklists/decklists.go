@@ -0,0 +1,395 @@
+// Package decklists reads pasted decklists and resolves their cards against the catalog.
+//
+// Three text formats are understood: MTG Arena and MTGO exports ("4 Lightning Bolt (M10) 146"),
+// Pokémon TCG Live exports ("4 Pikachu ex SVI 57" under "Pokémon: 12" style headings) and YDK
+// files for Yu-Gi-Oh!, which list one passcode per copy. Problems are reported per line so the
+// form can show the player exactly which lines to fix.
+package decklists
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// Formats
+const (
+	FormatAuto  = "auto"
+	FormatArena = "arena" // MTG Arena and MTGO text
+	FormatPTCGL = "ptcgl" // Pokémon TCG Live export
+	FormatYDK   = "ydk"   // Yu-Gi-Oh! deck file
+)
+
+// Formats lists the formats a decklist can be parsed as
+var Formats = []string{FormatAuto, FormatArena, FormatPTCGL, FormatYDK}
+
+// Sections of a deck
+const (
+	SectionMain      = "main"
+	SectionSideboard = "sideboard"
+	SectionExtra     = "extra"
+	SectionCommander = "commander"
+)
+
+// MaxLines caps how many lines a decklist may have
+const MaxLines = 500
+
+// Line is one card line of a decklist
+type Line struct {
+	Number          int // 1-based line number in the pasted text
+	Section         string
+	Quantity        int
+	Name            string
+	SetCode         string
+	CollectorNumber string
+	CardCode        string // YDK passcode
+}
+
+// Deck is a parsed decklist
+type Deck struct {
+	Format string
+	Game   string
+	Lines  []Line
+}
+
+// LineError is a problem with one line of a decklist
+type LineError struct {
+	Line    int // 0 for problems with the list as a whole
+	Message string
+}
+
+// Error formats the problem for display
+func (e LineError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("Line %d: %s", e.Line, e.Message)
+}
+
+// Card is a decklist line resolved against the catalog
+type Card struct {
+	Line     Line
+	Printing models.Printing   // the chosen printing, the newest when several match
+	Choices  []models.Printing // every printing that matches the line
+}
+
+// Ambiguous reports whether more than one printing matches the line
+func (c Card) Ambiguous() bool {
+	return len(c.Choices) > 1
+}
+
+// Entry is a printing and how many copies of it a decklist needs
+type Entry struct {
+	PrintingID string
+	Quantity   int
+}
+
+var (
+	arenaLine   = regexp.MustCompile(`^(?i:SB:\s*)?(\d+)x?\s+(.+?)(?:\s+\(([A-Za-z0-9]+)\)(?:\s+(\S+))?)?$`)
+	ptcglLine   = regexp.MustCompile(`^(\d+)\s+(.+?)(?:\s+([A-Z0-9]{2,5}(?:-[A-Z0-9]+)?)\s+(\d+[a-zA-Z]?))?$`)
+	ptcglHeader = regexp.MustCompile(`^(?i)(Pok[eé]mon|Trainer|Energy)\s*:\s*(\d+)$`)
+	ptcglTotal  = regexp.MustCompile(`^(?i)Total Cards\s*:\s*(\d+)$`)
+	ydkCode     = regexp.MustCompile(`^\d{1,10}$`)
+)
+
+// Detect guesses a decklist's format from its contents
+func Detect(text string) string {
+	sawCode, sawOther := false, false
+	for _, raw := range strings.Split(text, "\n") {
+		line := strings.TrimSpace(raw)
+		switch {
+		case line == "":
+		case strings.EqualFold(line, "#main"), strings.EqualFold(line, "!side"), strings.EqualFold(line, "#extra"):
+			return FormatYDK
+		case ptcglHeader.MatchString(line), ptcglTotal.MatchString(line):
+			return FormatPTCGL
+		case ydkCode.MatchString(line):
+			sawCode = true
+		default:
+			sawOther = true
+		}
+	}
+	if sawCode && !sawOther {
+		return FormatYDK
+	}
+	return FormatArena
+}
+
+// Parse reads a decklist in format, detecting the format first when it is FormatAuto or
+// empty. It returns every line it could read along with a LineError for each it couldn't.
+func Parse(text, format string) (Deck, []LineError) {
+	if format == "" || format == FormatAuto {
+		format = Detect(text)
+	}
+
+	var deck Deck
+	var errs []LineError
+	switch format {
+	case FormatArena:
+		deck, errs = parseArena(text)
+	case FormatPTCGL:
+		deck, errs = parsePTCGL(text)
+	case FormatYDK:
+		deck, errs = parseYDK(text)
+	default:
+		return Deck{}, []LineError{{Message: fmt.Sprintf("Unknown decklist format %q", format)}}
+	}
+	deck.Format = format
+
+	if len(deck.Lines) == 0 && len(errs) == 0 {
+		errs = append(errs, LineError{Message: "The decklist has no cards"})
+	}
+	return deck, errs
+}
+
+// lines splits text into numbered, trimmed lines, reporting an error when there are too many or
+// the text can't be read, such as a line too long for the scanner
+func lines(text string) ([]string, []LineError) {
+	var out []string
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		out = append(out, strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\uFEFF")))
+		if len(out) > MaxLines {
+			return nil, []LineError{{Message: fmt.Sprintf("Decklists can have at most %d lines", MaxLines)}}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, []LineError{{Line: len(out) + 1, Message: "the line is too long to be a card"}}
+		}
+		return nil, []LineError{{Message: fmt.Sprintf("The decklist couldn't be read: %v", err)}}
+	}
+	return out, nil
+}
+
+// parseArena reads MTG Arena and MTGO text. Arena marks sections with headings such as
+// "Sideboard"; MTGO separates the sideboard with a blank line or "SB:" prefixes.
+func parseArena(text string) (Deck, []LineError) {
+	deck := Deck{Game: models.GameMTG}
+	rows, errs := lines(text)
+	section := SectionMain
+	skipping := false // inside Arena's "About" block
+
+	for i, line := range rows {
+		n := i + 1
+		switch lower := strings.ToLower(line); {
+		case line == "":
+			skipping = false
+			if section == SectionMain && len(deck.Lines) > 0 {
+				section = SectionSideboard
+			}
+			continue
+		case strings.HasPrefix(line, "//"), strings.HasPrefix(line, "#"):
+			continue
+		case lower == "about":
+			skipping = true
+			continue
+		case skipping:
+			continue
+		case lower == "deck" || lower == "main" || lower == "maindeck":
+			section = SectionMain
+			continue
+		case lower == "sideboard" || lower == "maybeboard":
+			section = SectionSideboard
+			continue
+		case lower == "commander" || lower == "companion":
+			section = SectionCommander
+			continue
+		}
+
+		m := arenaLine.FindStringSubmatch(line)
+		if m == nil {
+			errs = append(errs, LineError{Line: n, Message: "expected a count and a card name, like \"4 Lightning Bolt (M10) 146\""})
+			continue
+		}
+		qty, _ := strconv.Atoi(m[1])
+		if qty < 1 {
+			errs = append(errs, LineError{Line: n, Message: "the count must be at least 1"})
+			continue
+		}
+		lineSection := section
+		if strings.HasPrefix(strings.ToUpper(line), "SB:") {
+			lineSection = SectionSideboard
+		}
+		deck.Lines = append(deck.Lines, Line{
+			Number:          n,
+			Section:         lineSection,
+			Quantity:        qty,
+			Name:            strings.TrimSpace(m[2]),
+			SetCode:         strings.ToUpper(m[3]),
+			CollectorNumber: m[4],
+		})
+	}
+	return deck, errs
+}
+
+// parsePTCGL reads a Pokémon TCG Live export, checking each heading's count against the lines
+// under it and the "Total Cards" line against the whole list
+func parsePTCGL(text string) (Deck, []LineError) {
+	deck := Deck{Game: models.GamePokemon}
+	rows, errs := lines(text)
+
+	headerLine, headerWant, headerGot := 0, 0, 0
+	checkHeader := func() {
+		if headerLine > 0 && headerGot != headerWant {
+			errs = append(errs, LineError{Line: headerLine, Message: fmt.Sprintf("the heading says %d cards but %d are listed", headerWant, headerGot)})
+		}
+	}
+	total := 0
+
+	for i, line := range rows {
+		n := i + 1
+		if line == "" {
+			continue
+		}
+		if m := ptcglHeader.FindStringSubmatch(line); m != nil {
+			checkHeader()
+			headerLine, headerGot = n, 0
+			headerWant, _ = strconv.Atoi(m[2])
+			continue
+		}
+		if m := ptcglTotal.FindStringSubmatch(line); m != nil {
+			want, _ := strconv.Atoi(m[1])
+			if want != total {
+				errs = append(errs, LineError{Line: n, Message: fmt.Sprintf("the total says %d cards but %d are listed", want, total)})
+			}
+			continue
+		}
+
+		m := ptcglLine.FindStringSubmatch(line)
+		if m == nil {
+			errs = append(errs, LineError{Line: n, Message: "expected a count, a card name, a set and a number, like \"4 Pikachu ex SVI 57\""})
+			continue
+		}
+		qty, _ := strconv.Atoi(m[1])
+		if qty < 1 {
+			errs = append(errs, LineError{Line: n, Message: "the count must be at least 1"})
+			continue
+		}
+		headerGot += qty
+		total += qty
+		deck.Lines = append(deck.Lines, Line{
+			Number:          n,
+			Section:         SectionMain,
+			Quantity:        qty,
+			Name:            strings.TrimSpace(m[2]),
+			SetCode:         m[3],
+			CollectorNumber: m[4],
+		})
+	}
+	checkHeader()
+	return deck, errs
+}
+
+// parseYDK reads a YDK file. Each line is one copy of a card, so repeated passcodes within a
+// section are folded into one line counted by quantity.
+func parseYDK(text string) (Deck, []LineError) {
+	deck := Deck{Game: models.GameYugioh}
+	rows, errs := lines(text)
+	section := SectionMain
+	index := map[string]int{} // section/code -> position in deck.Lines
+
+	for i, line := range rows {
+		n := i + 1
+		switch lower := strings.ToLower(line); {
+		case line == "":
+			continue
+		case lower == "#main":
+			section = SectionMain
+			continue
+		case lower == "#extra":
+			section = SectionExtra
+			continue
+		case lower == "!side":
+			section = SectionSideboard
+			continue
+		case strings.HasPrefix(line, "#"), strings.HasPrefix(line, "!"):
+			continue
+		}
+
+		if !ydkCode.MatchString(line) {
+			errs = append(errs, LineError{Line: n, Message: "expected a card passcode made of digits"})
+			continue
+		}
+		code := strings.TrimLeft(line, "0")
+		key := section + "/" + code
+		if at, ok := index[key]; ok {
+			deck.Lines[at].Quantity++
+			continue
+		}
+		index[key] = len(deck.Lines)
+		deck.Lines = append(deck.Lines, Line{Number: n, Section: section, Quantity: 1, CardCode: code})
+	}
+	return deck, errs
+}
+
+// Resolve matches each line of a deck to the catalog's printings. A line with a set and
+// collector number must match that printing exactly; otherwise every printing of the card is
+// a choice and the newest is picked. Lines that match nothing are reported as errors.
+func Resolve(ctx context.Context, c *catalog.Catalog, deck Deck) ([]Card, []LineError) {
+	all, err := c.Printings(ctx)
+	if err != nil {
+		return nil, []LineError{{Message: "The catalog couldn't be searched. Please try again."}}
+	}
+
+	byName := map[string][]models.Printing{}
+	byCode := map[string][]models.Printing{}
+	for _, p := range all {
+		if deck.Game != "" && !strings.EqualFold(p.Game, deck.Game) {
+			continue
+		}
+		byName[strings.ToLower(p.CardName)] = append(byName[strings.ToLower(p.CardName)], p)
+		if p.CardCode != "" {
+			code := strings.TrimLeft(p.CardCode, "0")
+			byCode[code] = append(byCode[code], p)
+		}
+	}
+
+	var cards []Card
+	var errs []LineError
+	for _, l := range deck.Lines {
+		var choices []models.Printing
+		if l.CardCode != "" {
+			choices = byCode[l.CardCode]
+		} else {
+			for _, p := range byName[strings.ToLower(l.Name)] {
+				if l.SetCode != "" && !strings.EqualFold(p.SetCode, l.SetCode) {
+					continue
+				}
+				if l.CollectorNumber != "" && !strings.EqualFold(p.CollectorNumber, l.CollectorNumber) {
+					continue
+				}
+				choices = append(choices, p)
+			}
+		}
+
+		if len(choices) == 0 {
+			errs = append(errs, LineError{Line: l.Number, Message: notFound(l)})
+			continue
+		}
+		sort.SliceStable(choices, func(i, j int) bool { return choices[i].CreatedAt > choices[j].CreatedAt })
+		cards = append(cards, Card{Line: l, Printing: choices[0], Choices: choices})
+	}
+	return cards, errs
+}
+
+// notFound describes a line that matched no printing
+func notFound(l Line) string {
+	switch {
+	case l.CardCode != "":
+		return fmt.Sprintf("no card with passcode %s", l.CardCode)
+	case l.CollectorNumber != "":
+		return fmt.Sprintf("%s isn't #%s in set %s", l.Name, l.CollectorNumber, l.SetCode)
+	case l.SetCode != "":
+		return fmt.Sprintf("%s isn't in set %s", l.Name, l.SetCode)
+	}
+	return fmt.Sprintf("no card named %q", l.Name)
+}
@@ -0,0 +1,564 @@
+// Package disputes lets buyers report problems with an order and drives each dispute through
+// open → seller_response → escalated → resolved.
+//
+// The seller can settle a dispute themselves by refunding or asking for a return. If they don't
+// reply within the response window, or either party escalates, an admin decides it. Refunds go
+// through the payment provider and are recorded on the ledger. While a dispute is open the order
+// is held so it doesn't complete and release the seller's funds.
+package disputes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ledger"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/payments"
+)
+
+var (
+	// ErrNotFound is returned when a dispute does not exist
+	ErrNotFound = errors.New("disputes: not found")
+	// ErrConflict is returned when a dispute was changed concurrently
+	ErrConflict = errors.New("disputes: version conflict")
+	// ErrNotAllowed is returned when the actor may not take the action
+	ErrNotAllowed = errors.New("disputes: not allowed")
+	// ErrInvalidTransition is returned when the dispute can't move to the requested status
+	ErrInvalidTransition = errors.New("disputes: invalid status change")
+	// ErrWindowClosed is returned when the order is too old, or not far enough along, to dispute
+	ErrWindowClosed = errors.New("disputes: this order can no longer be disputed")
+	// ErrAlreadyOpen is returned when the order already has a dispute in progress
+	ErrAlreadyOpen = errors.New("disputes: order already has an open dispute")
+	// ErrInvalid is returned for a missing reason, message or bad refund amount
+	ErrInvalid = errors.New("disputes: invalid request")
+)
+
+// transitions lists, for each status, the statuses it may move to and which actors may move it there
+var transitions = map[string]map[string][]string{
+	models.DisputeStatusOpen: {
+		models.DisputeStatusSellerResponse: {orders.ActorSeller},
+		models.DisputeStatusEscalated:      {orders.ActorAdmin, orders.ActorSystem},
+		models.DisputeStatusResolved:       {orders.ActorSeller, orders.ActorAdmin},
+	},
+	models.DisputeStatusSellerResponse: {
+		models.DisputeStatusEscalated: {orders.ActorBuyer, orders.ActorSeller, orders.ActorAdmin},
+		models.DisputeStatusResolved:  {orders.ActorSeller, orders.ActorAdmin},
+	},
+	models.DisputeStatusEscalated: {
+		models.DisputeStatusResolved: {orders.ActorAdmin},
+	},
+}
+
+// CanTransition reports whether a dispute may move from one status to another by the given actor kind
+func CanTransition(from, to, actorKind string) bool {
+	for _, k := range transitions[from][to] {
+		if k == actorKind {
+			return true
+		}
+	}
+	return false
+}
+
+// CanResolveWith reports whether an actor kind may settle a dispute with an outcome. Only admins
+// can deny a dispute; sellers settle by refunding or taking the cards back.
+func CanResolveWith(outcome, actorKind string) bool {
+	switch outcome {
+	case models.DisputeOutcomeRefundFull, models.DisputeOutcomeRefundPartial, models.DisputeOutcomeReturnRequired:
+		return actorKind == orders.ActorSeller || actorKind == orders.ActorAdmin
+	case models.DisputeOutcomeDenied:
+		return actorKind == orders.ActorAdmin
+	}
+	return false
+}
+
+// Store persists disputes and their message threads.
+type Store interface {
+	Create(ctx context.Context, d models.Dispute, first models.DisputeMessage) error
+	Get(ctx context.Context, disputeID string) (models.Dispute, error)
+	// Update writes a dispute conditional on expectedVersion
+	Update(ctx context.Context, d models.Dispute, expectedVersion int64) error
+	ByOrder(ctx context.Context, orderID string) ([]models.Dispute, error)
+	ByStatus(ctx context.Context, status string) ([]models.Dispute, error)
+	AddMessage(ctx context.Context, m models.DisputeMessage) error
+	// Messages returns a dispute's thread, oldest first
+	Messages(ctx context.Context, disputeID string) ([]models.DisputeMessage, error)
+}
+
+// Options controls the dispute windows
+type Options struct {
+	Window         time.Duration // how long after delivery a buyer may open a dispute
+	ResponseWindow time.Duration // how long the seller has to reply before the dispute escalates
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	Window:         30 * 24 * time.Hour,
+	ResponseWindow: 3 * 24 * time.Hour,
+}
+
+// Opening is what a buyer submits to open a dispute
+type Opening struct {
+	Reason      string
+	Description string
+	Photos      []string
+}
+
+// Resolution is how a dispute is settled
+type Resolution struct {
+	Outcome     string
+	RefundCents int64 // partial refunds only
+	Note        string
+}
+
+// Listener is notified after a dispute is opened, replied to or changes status, with the actor
+// who did it
+type Listener func(ctx context.Context, d models.Dispute, actor orders.Actor)
+
+// Service opens, discusses and resolves disputes.
+type Service struct {
+	store    Store
+	orders   *orders.Service
+	payments *payments.Service
+	ledger   *ledger.Service
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+
+	mu        sync.RWMutex
+	listeners []Listener
+}
+
+// New creates a disputes Service
+func New(store Store, o *orders.Service, p *payments.Service, l *ledger.Service, opts Options, errorLog *log.Logger) *Service {
+	if opts.Window <= 0 {
+		opts.Window = DefaultOptions.Window
+	}
+	if opts.ResponseWindow <= 0 {
+		opts.ResponseWindow = DefaultOptions.ResponseWindow
+	}
+	return &Service{store: store, orders: o, payments: p, ledger: l, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// OnChange registers a listener for new disputes, replies and status changes
+func (s *Service) OnChange(fn Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// CanOpen reports whether an order can be disputed now, returning ErrWindowClosed if not.
+// Orders can be disputed once shipped and until the window after delivery has passed.
+func (s *Service) CanOpen(ctx context.Context, o models.Order) error {
+	switch o.Status {
+	case models.OrderStatusShipped:
+		return nil
+	case models.OrderStatusDelivered, models.OrderStatusCompleted:
+		delivered, err := time.Parse(time.RFC3339, o.DeliveredAt)
+		if err != nil || s.now().After(delivered.Add(s.opts.Window)) {
+			return ErrWindowClosed
+		}
+		return nil
+	}
+	return ErrWindowClosed
+}
+
+// Open starts a dispute on an order for its buyer
+func (s *Service) Open(ctx context.Context, o models.Order, buyerID string, in Opening) (models.Dispute, error) {
+	if o.BuyerID != buyerID {
+		return models.Dispute{}, ErrNotAllowed
+	}
+	if _, ok := models.DisputeReasons[in.Reason]; !ok {
+		return models.Dispute{}, fmt.Errorf("%w: choose a reason", ErrInvalid)
+	}
+	if strings.TrimSpace(in.Description) == "" {
+		return models.Dispute{}, fmt.Errorf("%w: describe the problem", ErrInvalid)
+	}
+	if err := s.CanOpen(ctx, o); err != nil {
+		return models.Dispute{}, err
+	}
+	if d, err := s.Active(ctx, o.OrderID); err != nil {
+		return models.Dispute{}, err
+	} else if d != nil {
+		return *d, ErrAlreadyOpen
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	d := models.Dispute{
+		DisputeID:   ids.New(),
+		OrderID:     o.OrderID,
+		BuyerID:     o.BuyerID,
+		SellerID:    o.SellerID,
+		Status:      models.DisputeStatusOpen,
+		Reason:      in.Reason,
+		Description: strings.TrimSpace(in.Description),
+		Photos:      in.Photos,
+		Version:     1,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	setKeys(&d)
+
+	first := newMessage(d.DisputeID, orders.Buyer(buyerID), d.Description, in.Photos, now)
+	if err := s.store.Create(ctx, d, first); err != nil {
+		return d, err
+	}
+	s.notify(ctx, d, orders.Buyer(buyerID))
+	return d, nil
+}
+
+// Get returns a dispute
+func (s *Service) Get(ctx context.Context, disputeID string) (models.Dispute, error) {
+	return s.store.Get(ctx, disputeID)
+}
+
+// ForOrder returns an order's disputes, newest first
+func (s *Service) ForOrder(ctx context.Context, orderID string) ([]models.Dispute, error) {
+	return s.store.ByOrder(ctx, orderID)
+}
+
+// Active returns the order's dispute that is still in progress, or nil
+func (s *Service) Active(ctx context.Context, orderID string) (*models.Dispute, error) {
+	list, err := s.store.ByOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range list {
+		if d.Open() || d.AwaitingReturn() {
+			return &d, nil
+		}
+	}
+	return nil, nil
+}
+
+// Holds reports whether an order is held by a dispute, so it must not complete automatically
+func (s *Service) Holds(ctx context.Context, o models.Order) (bool, error) {
+	d, err := s.Active(ctx, o.OrderID)
+	return d != nil, err
+}
+
+// Queue returns the disputes needing attention, escalated ones first and oldest first within a status
+func (s *Service) Queue(ctx context.Context) ([]models.Dispute, error) {
+	var out []models.Dispute
+	for _, status := range []string{models.DisputeStatusEscalated, models.DisputeStatusSellerResponse, models.DisputeStatusOpen} {
+		list, err := s.store.ByStatus(ctx, status)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, list...)
+	}
+	return out, nil
+}
+
+// Messages returns a dispute's thread, oldest first
+func (s *Service) Messages(ctx context.Context, disputeID string) ([]models.DisputeMessage, error) {
+	return s.store.Messages(ctx, disputeID)
+}
+
+// Role returns the part an actor plays in a dispute, or "" if they have none
+func Role(d models.Dispute, actor orders.Actor) string {
+	switch {
+	case actor.Kind == orders.ActorAdmin, actor.Kind == orders.ActorSystem:
+		return actor.Kind
+	case actor.ID == d.BuyerID:
+		return orders.ActorBuyer
+	case actor.ID == d.SellerID:
+		return orders.ActorSeller
+	}
+	return ""
+}
+
+// Reply adds a message to a dispute's thread. The seller's first reply to an open dispute moves it
+// to seller_response.
+func (s *Service) Reply(ctx context.Context, disputeID string, actor orders.Actor, body string, photos []string) (models.Dispute, error) {
+	d, err := s.store.Get(ctx, disputeID)
+	if err != nil {
+		return d, err
+	}
+	if actor.Kind != orders.ActorAdmin && actor.Kind != Role(d, actor) {
+		return d, ErrNotAllowed
+	}
+	if strings.TrimSpace(body) == "" && len(photos) == 0 {
+		return d, fmt.Errorf("%w: write a message", ErrInvalid)
+	}
+	if !d.Open() {
+		return d, fmt.Errorf("%w: the dispute is resolved", ErrNotAllowed)
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	if err := s.store.AddMessage(ctx, newMessage(d.DisputeID, actor, strings.TrimSpace(body), photos, now)); err != nil {
+		return d, err
+	}
+
+	if d.Status == models.DisputeStatusOpen && actor.Kind == orders.ActorSeller {
+		return s.move(ctx, d, models.DisputeStatusSellerResponse, actor, "", nil)
+	}
+	s.notify(ctx, d, actor)
+	return d, nil
+}
+
+// Escalate hands a dispute to an admin
+func (s *Service) Escalate(ctx context.Context, disputeID string, actor orders.Actor, reason string) (models.Dispute, error) {
+	d, err := s.store.Get(ctx, disputeID)
+	if err != nil {
+		return d, err
+	}
+	note := "Escalated to the marketplace team."
+	if reason = strings.TrimSpace(reason); reason != "" {
+		note += " " + reason
+	}
+	return s.move(ctx, d, models.DisputeStatusEscalated, actor, note, nil)
+}
+
+// Resolve settles a dispute. Refunds are sent through the payment provider and recorded on the
+// ledger; a full refund also marks the order refunded. A required return is refunded once the
+// return arrives.
+//
+// The buyer is refunded before the dispute is marked resolved, so a refund that fails leaves the
+// dispute as it was to try again. Refunds are keyed by the dispute, so a retry never pays twice.
+func (s *Service) Resolve(ctx context.Context, disputeID string, actor orders.Actor, res Resolution) (models.Dispute, error) {
+	d, err := s.store.Get(ctx, disputeID)
+	if err != nil {
+		return d, err
+	}
+	if !CanResolveWith(res.Outcome, actor.Kind) {
+		return d, ErrNotAllowed
+	}
+	if err := s.check(d, models.DisputeStatusResolved, actor); err != nil {
+		return d, err
+	}
+	o, err := s.orders.Get(ctx, d.OrderID)
+	if err != nil {
+		return d, err
+	}
+	if res.Outcome == models.DisputeOutcomeRefundPartial {
+		// earlier refunds on the order, from another dispute for example, count against the total
+		refundable, err := s.ledger.Refundable(ctx, o)
+		if err != nil {
+			return d, err
+		}
+		if res.RefundCents <= 0 || res.RefundCents >= refundable {
+			return d, fmt.Errorf("%w: a partial refund must be more than nothing and less than the %s not yet refunded", ErrInvalid, money.Format(refundable))
+		}
+	} else {
+		res.RefundCents = 0
+	}
+
+	switch res.Outcome {
+	case models.DisputeOutcomeRefundFull:
+		err = s.refundFull(ctx, d, o)
+	case models.DisputeOutcomeRefundPartial:
+		err = s.refundPartial(ctx, d, o, res.RefundCents)
+	}
+	if err != nil {
+		return d, fmt.Errorf("refunding dispute %s: %w", d.DisputeID, err)
+	}
+
+	return s.move(ctx, d, models.DisputeStatusResolved, actor, outcomeNote(res), func(d *models.Dispute) {
+		d.Outcome = res.Outcome
+		d.RefundCents = res.RefundCents
+		d.Resolution = strings.TrimSpace(res.Note)
+		d.ResolvedBy = actor.String()
+		d.ResolvedAt = d.UpdatedAt
+	})
+}
+
+// ConfirmReturn refunds the buyer in full on a dispute resolved with a return, then records that
+// the seller got the cards back. As with Resolve, a failed refund leaves the return outstanding.
+func (s *Service) ConfirmReturn(ctx context.Context, disputeID string, actor orders.Actor) (models.Dispute, error) {
+	d, err := s.store.Get(ctx, disputeID)
+	if err != nil {
+		return d, err
+	}
+	if role := Role(d, actor); role != orders.ActorSeller && role != orders.ActorAdmin {
+		return d, ErrNotAllowed
+	}
+	if !d.AwaitingReturn() {
+		return d, fmt.Errorf("%w: no return is expected", ErrInvalidTransition)
+	}
+	o, err := s.orders.Get(ctx, d.OrderID)
+	if err != nil {
+		return d, err
+	}
+
+	if err := s.refundFull(ctx, d, o); err != nil {
+		return d, fmt.Errorf("refunding return for dispute %s: %w", d.DisputeID, err)
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	expected := d.Version
+	d.ReturnReceivedAt = now
+	d.UpdatedAt = now
+	d.Version++
+	if err := s.store.Update(ctx, d, expected); err != nil {
+		return d, err
+	}
+	if err := s.store.AddMessage(ctx, newMessage(d.DisputeID, orders.System, "Return received. The buyer has been refunded in full.", nil, now)); err != nil {
+		return d, err
+	}
+	s.notify(ctx, d, actor)
+	return d, nil
+}
+
+// EscalateOverdue escalates open disputes the seller hasn't replied to within the response window
+// and returns how many it escalated
+func (s *Service) EscalateOverdue(ctx context.Context) (int, error) {
+	list, err := s.store.ByStatus(ctx, models.DisputeStatusOpen)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := s.now().Add(-s.opts.ResponseWindow).UTC().Format(time.RFC3339)
+	escalated := 0
+	for _, d := range list {
+		if d.CreatedAt > cutoff {
+			continue
+		}
+		_, err := s.move(ctx, d, models.DisputeStatusEscalated, orders.System, "The seller didn't respond in time, so the marketplace team will decide.", nil)
+		switch {
+		case errors.Is(err, ErrConflict), errors.Is(err, ErrInvalidTransition):
+			// the seller replied in the meantime
+		case err != nil:
+			return escalated, err
+		default:
+			escalated++
+		}
+	}
+	return escalated, nil
+}
+
+// Run escalates overdue disputes every interval until ctx is done
+func (s *Service) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := s.EscalateOverdue(ctx); err != nil {
+			s.errorLog.Printf("escalating overdue disputes failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check reports whether an actor may move a dispute to a status
+func (s *Service) check(d models.Dispute, to string, actor orders.Actor) error {
+	if _, ok := transitions[d.Status][to]; !ok {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, d.Status, to)
+	}
+	if !CanTransition(d.Status, to, actor.Kind) {
+		return fmt.Errorf("%w: %s cannot move dispute from %s to %s", ErrNotAllowed, actor.Kind, d.Status, to)
+	}
+	if role := Role(d, actor); role != actor.Kind {
+		return ErrNotAllowed
+	}
+	return nil
+}
+
+// move validates and applies a status change, recording it in the thread
+func (s *Service) move(ctx context.Context, d models.Dispute, to string, actor orders.Actor, note string, mutate func(*models.Dispute)) (models.Dispute, error) {
+	if err := s.check(d, to, actor); err != nil {
+		return d, err
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	expected := d.Version
+	d.Status = to
+	d.Version++
+	d.UpdatedAt = now
+	if mutate != nil {
+		mutate(&d)
+	}
+	setKeys(&d)
+
+	if err := s.store.Update(ctx, d, expected); err != nil {
+		return d, err
+	}
+	if note != "" {
+		if err := s.store.AddMessage(ctx, newMessage(d.DisputeID, orders.System, note, nil, now)); err != nil {
+			return d, err
+		}
+	}
+	s.notify(ctx, d, actor)
+	return d, nil
+}
+
+// notify fans a dispute change out to every registered listener
+func (s *Service) notify(ctx context.Context, d models.Dispute, actor orders.Actor) {
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(ctx, d, actor)
+	}
+}
+
+// refundFull refunds what remains of an order and marks it refunded; the ledger records the
+// refund when the order moves to refunded. An order already refunded by an earlier attempt is
+// left as it is.
+func (s *Service) refundFull(ctx context.Context, d models.Dispute, o models.Order) error {
+	if _, err := s.payments.RefundOrder(ctx, o, 0, "dispute-"+d.DisputeID+"-refund"); err != nil {
+		return err
+	}
+	if o.Status == models.OrderStatusRefunded {
+		return nil
+	}
+	_, err := s.orders.Transition(ctx, o.OrderID, models.OrderStatusRefunded, orders.System, "dispute "+d.DisputeID+" refunded in full")
+	return err
+}
+
+// refundPartial refunds part of an order and records it on the ledger; the order keeps its status
+func (s *Service) refundPartial(ctx context.Context, d models.Dispute, o models.Order, amountCents int64) error {
+	key := "dispute-" + d.DisputeID + "-refund"
+	if _, err := s.payments.RefundOrder(ctx, o, amountCents, key); err != nil {
+		return err
+	}
+	return s.ledger.RecordRefund(ctx, o, amountCents, key)
+}
+
+// outcomeNote describes an outcome for the thread
+func outcomeNote(res Resolution) string {
+	switch res.Outcome {
+	case models.DisputeOutcomeRefundFull:
+		return "Resolved with a full refund."
+	case models.DisputeOutcomeRefundPartial:
+		return "Resolved with a partial refund of " + money.Format(res.RefundCents) + "."
+	case models.DisputeOutcomeReturnRequired:
+		return "Resolved with a return. The buyer will be refunded in full once the seller receives the cards."
+	default:
+		return "Resolved without a refund."
+	}
+}
+
+// setKeys fills in the single-table keys for a dispute
+func setKeys(d *models.Dispute) {
+	d.PK, d.SK = models.DisputeKey(d.DisputeID)
+	d.GSI1PK, d.GSI1SK = models.OrderDisputeKey(d.OrderID, d.CreatedAt, d.DisputeID)
+	d.GSI2PK, d.GSI2SK = models.DisputeStatusKey(d.Status, d.CreatedAt, d.DisputeID)
+	d.Type = models.ItemTypeDispute
+}
+
+// newMessage builds a thread message
+func newMessage(disputeID string, actor orders.Actor, body string, photos []string, at string) models.DisputeMessage {
+	m := models.DisputeMessage{
+		MessageID: ids.New(),
+		DisputeID: disputeID,
+		Author:    actor.String(),
+		Role:      actor.Kind,
+		Body:      body,
+		Photos:    photos,
+		CreatedAt: at,
+		Type:      models.ItemTypeDisputeMsg,
+	}
+	m.PK, m.SK = models.DisputeMessageKey(disputeID, at, m.MessageID)
+	return m
+}
@@ -0,0 +1,240 @@
+package disputes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ledger"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/payments"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/sellers"
+)
+
+const secret = "whsec_test"
+
+// flakyProvider fails refunds while failRefunds is set
+type flakyProvider struct {
+	*payments.FakeProvider
+	failRefunds atomic.Bool
+}
+
+func (p *flakyProvider) Refund(ctx context.Context, intentID string, amountCents int64, idempotencyKey string) (payments.Refund, error) {
+	if p.failRefunds.Load() {
+		return payments.Refund{}, errors.New("processor unavailable")
+	}
+	return p.FakeProvider.Refund(ctx, intentID, amountCents, idempotencyKey)
+}
+
+// fixture is a disputes Service over in-memory stores and the fake provider, with a paid and
+// shipped $10 order from a seller whose payout account is connected
+type fixture struct {
+	svc      *Service
+	provider *flakyProvider
+	orders   *orders.Service
+	ledger   *ledger.Service
+	relay    *outbox.Relay
+	order    models.Order
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	ctx := context.Background()
+	discard := log.New(io.Discard, "", 0)
+
+	ob := outbox.NewMemoryStore()
+	c := catalog.New(catalog.NewMemoryStore(nil))
+	p, err := c.SavePrinting(ctx, models.Printing{Game: "mtg", SetCode: "M10", SetName: "Magic 2010", CardName: "Lightning Bolt", Rarity: "common"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.SaveListing(ctx, models.Listing{SellerID: "seller", PrintingID: p.PrintingID, Condition: "NM", Language: "en", PriceCents: 1000, Quantity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fixture{
+		provider: &flakyProvider{FakeProvider: payments.NewFakeProvider()},
+		orders:   orders.New(orders.NewMemoryStore(ob), c, discard),
+		ledger:   ledger.New(ledger.NewMemoryStore(), ledger.Options{}, discard),
+		relay:    outbox.New(ob, outbox.Options{RetryBase: time.Nanosecond}, discard),
+	}
+	pay := payments.New(f.provider, f.orders, sellers.New(sellers.NewMemoryStore(nil)), secret, discard)
+	pay.SetFeeFunc(func(context.Context, models.Order) (int64, error) { return 100, nil })
+	pay.SetRefundRecorder(f.ledger.RecordRefund)
+	pay.Attach(f.relay)
+	f.ledger.Attach(f.relay, f.orders)
+	f.svc = New(NewMemoryStore(), f.orders, pay, f.ledger, Options{}, discard)
+
+	if _, err := pay.OnboardingLink(ctx, "seller", "https://example.test/refresh", "https://example.test/return"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pay.RefreshAccount(ctx, "seller"); err != nil {
+		t.Fatal(err)
+	}
+
+	carts := cart.New(cart.NewMemoryStore(), c)
+	cartOf := models.Cart{UserID: "buyer"}
+	if err := carts.Add(ctx, &cartOf, l.ListingID, 1); err != nil {
+		t.Fatal(err)
+	}
+	view, err := carts.Build(ctx, cartOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := f.orders.Checkout(ctx, "buyer", view, orders.Shipment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prepared, err := pay.PrepareCheckout(ctx, "buyer", list[0].CheckoutID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := f.provider.Succeed(prepared[0].Order.PaymentIntent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, err := payments.SimulatedSucceededEvent(in, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pay.HandleWebhook(ctx, payload, sig); err != nil {
+		t.Fatal(err)
+	}
+	if f.order, err = f.orders.Ship(ctx, list[0].OrderID, orders.Seller("seller"), "usps", "9400"); err != nil {
+		t.Fatal(err)
+	}
+	f.publish(t)
+	return f
+}
+
+func (f *fixture) publish(t *testing.T) {
+	t.Helper()
+	if _, err := f.relay.Publish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *fixture) open(t *testing.T) models.Dispute {
+	t.Helper()
+	d, err := f.svc.Open(context.Background(), f.order, "buyer", Opening{Reason: models.DisputeReasonDamaged, Description: "bent corner"})
+	if err != nil {
+		t.Fatalf("opening dispute: %v", err)
+	}
+	return d
+}
+
+// refundable returns what the ledger and the processor each have left to refund on the order
+func (f *fixture) refundable(t *testing.T) (booked, charged int64) {
+	t.Helper()
+	ctx := context.Background()
+	booked, err := f.ledger.Refundable(ctx, f.order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := f.provider.Retrieve(ctx, f.order.PaymentIntent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return booked, in.AmountReceivedCents
+}
+
+func partial(cents int64) Resolution {
+	return Resolution{Outcome: models.DisputeOutcomeRefundPartial, RefundCents: cents}
+}
+
+func TestPartialRefundCaps(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	seller := orders.Seller("seller")
+	d := f.open(t)
+
+	for _, cents := range []int64{0, -1, 1000, 1001} {
+		if _, err := f.svc.Resolve(ctx, d.DisputeID, seller, partial(cents)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("partial refund of %d: err = %v, want ErrInvalid", cents, err)
+		}
+	}
+
+	resolved, err := f.svc.Resolve(ctx, d.DisputeID, seller, partial(400))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Status != models.DisputeStatusResolved || resolved.RefundCents != 400 {
+		t.Fatalf("dispute = %s refunding %d, want resolved refunding 400", resolved.Status, resolved.RefundCents)
+	}
+	if booked, charged := f.refundable(t); booked != 600 || charged != 600 {
+		t.Fatalf("left to refund: ledger %d, processor %d; want 600", booked, charged)
+	}
+
+	// a later dispute on the same order is capped by what's left, not the order total
+	next := f.open(t)
+	if _, err := f.svc.Resolve(ctx, next.DisputeID, seller, partial(600)); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("partial refund of the rest: err = %v, want ErrInvalid", err)
+	}
+	if _, err := f.svc.Resolve(ctx, next.DisputeID, seller, Resolution{Outcome: models.DisputeOutcomeRefundFull}); err != nil {
+		t.Fatal(err)
+	}
+	f.publish(t)
+
+	o, err := f.orders.Get(ctx, f.order.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != models.OrderStatusRefunded {
+		t.Fatalf("order is %s, want refunded", o.Status)
+	}
+	if booked, charged := f.refundable(t); booked != 0 || charged != 0 {
+		t.Fatalf("left to refund after a full refund: ledger %d, processor %d; want 0", booked, charged)
+	}
+	// the seller's whole share was taken back from their connected account
+	if got, err := f.ledger.Balance(ctx, ledger.SellerConnected("seller")); err != nil || got != 0 {
+		t.Fatalf("connected account holds %d, %v; want 0", got, err)
+	}
+}
+
+func TestFailedRefundLeavesDisputeOpen(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	seller := orders.Seller("seller")
+	d := f.open(t)
+
+	f.provider.failRefunds.Store(true)
+	if _, err := f.svc.Resolve(ctx, d.DisputeID, seller, partial(400)); err == nil {
+		t.Fatal("resolved although the refund failed")
+	}
+	if got, _ := f.svc.Get(ctx, d.DisputeID); got.Status != models.DisputeStatusOpen {
+		t.Fatalf("dispute is %s after a failed refund, want open", got.Status)
+	}
+	if booked, _ := f.refundable(t); booked != 1000 {
+		t.Fatalf("ledger has %d left to refund after a failed refund, want 1000", booked)
+	}
+
+	f.provider.failRefunds.Store(false)
+	if _, err := f.svc.Resolve(ctx, d.DisputeID, seller, partial(400)); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+}
+
+func TestRefundKeyedByDispute(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	d := f.open(t)
+
+	// a retry after the refund went through, but before the dispute was saved, pays once
+	for i := 0; i < 2; i++ {
+		if err := f.svc.refundPartial(ctx, d, f.order, 400); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if booked, charged := f.refundable(t); booked != 600 || charged != 600 {
+		t.Fatalf("left to refund: ledger %d, processor %d; want 600", booked, charged)
+	}
+}
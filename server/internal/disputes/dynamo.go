@@ -0,0 +1,82 @@
+package disputes
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. A dispute's thread shares its partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Create stores a new dispute with its opening message
+func (s *DynamoStore) Create(ctx context.Context, d models.Dispute, first models.DisputeMessage) error {
+	err := s.table.Write(ctx, dynamo.PutOp(d, dynamo.IfNotExists()), dynamo.PutOp(first, dynamo.Condition{}))
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
+
+// Get returns a dispute
+func (s *DynamoStore) Get(ctx context.Context, disputeID string) (models.Dispute, error) {
+	var d models.Dispute
+	pk, sk := models.DisputeKey(disputeID)
+	err := s.table.Get(ctx, pk, sk, &d)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return d, ErrNotFound
+	}
+	return d, err
+}
+
+// Update writes a dispute if its stored version is still expectedVersion
+func (s *DynamoStore) Update(ctx context.Context, d models.Dispute, expectedVersion int64) error {
+	err := s.table.Put(ctx, d, dynamo.IfVersion(expectedVersion))
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
+
+// ByOrder returns an order's disputes, newest first
+func (s *DynamoStore) ByOrder(ctx context.Context, orderID string) ([]models.Dispute, error) {
+	var out []models.Dispute
+	pk, _ := models.OrderDisputeKey(orderID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, SKPrefix: "DISPUTE#", Descending: true}, &out)
+	return out, err
+}
+
+// ByStatus returns the disputes in a status, oldest first
+func (s *DynamoStore) ByStatus(ctx context.Context, status string) ([]models.Dispute, error) {
+	var out []models.Dispute
+	pk, _ := models.DisputeStatusKey(status, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk}, &out)
+	return out, err
+}
+
+// AddMessage appends a message to a dispute's thread
+func (s *DynamoStore) AddMessage(ctx context.Context, m models.DisputeMessage) error {
+	pk, sk := models.DisputeKey(m.DisputeID)
+	err := s.table.Write(ctx, dynamo.CheckOp(pk, sk, dynamo.IfExists()), dynamo.PutOp(m, dynamo.Condition{}))
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Messages returns a dispute's thread, oldest first
+func (s *DynamoStore) Messages(ctx context.Context, disputeID string) ([]models.DisputeMessage, error) {
+	var out []models.DisputeMessage
+	pk, _ := models.DisputeKey(disputeID)
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "MESSAGE#"}, &out)
+	return out, err
+}
@@ -0,0 +1,109 @@
+package disputes
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	disputes map[string]models.Dispute
+	messages map[string][]models.DisputeMessage
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		disputes: map[string]models.Dispute{},
+		messages: map[string][]models.DisputeMessage{},
+	}
+}
+
+// Create stores a new dispute with its opening message
+func (s *MemoryStore) Create(ctx context.Context, d models.Dispute, first models.DisputeMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.disputes[d.DisputeID]; ok {
+		return ErrConflict
+	}
+	s.disputes[d.DisputeID] = d
+	s.messages[d.DisputeID] = append(s.messages[d.DisputeID], first)
+	return nil
+}
+
+// Get returns a dispute
+func (s *MemoryStore) Get(ctx context.Context, disputeID string) (models.Dispute, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.disputes[disputeID]
+	if !ok {
+		return d, ErrNotFound
+	}
+	return d, nil
+}
+
+// Update writes a dispute if its stored version is still expectedVersion
+func (s *MemoryStore) Update(ctx context.Context, d models.Dispute, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.disputes[d.DisputeID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.disputes[d.DisputeID] = d
+	return nil
+}
+
+// ByOrder returns an order's disputes, newest first
+func (s *MemoryStore) ByOrder(ctx context.Context, orderID string) ([]models.Dispute, error) {
+	list := s.filter(func(d models.Dispute) bool { return d.OrderID == orderID })
+	sort.Slice(list, func(i, j int) bool { return list[i].GSI1SK > list[j].GSI1SK })
+	return list, nil
+}
+
+// ByStatus returns the disputes in a status, oldest first
+func (s *MemoryStore) ByStatus(ctx context.Context, status string) ([]models.Dispute, error) {
+	list := s.filter(func(d models.Dispute) bool { return d.Status == status })
+	sort.Slice(list, func(i, j int) bool { return list[i].GSI2SK < list[j].GSI2SK })
+	return list, nil
+}
+
+// AddMessage appends a message to a dispute's thread
+func (s *MemoryStore) AddMessage(ctx context.Context, m models.DisputeMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.disputes[m.DisputeID]; !ok {
+		return ErrNotFound
+	}
+	s.messages[m.DisputeID] = append(s.messages[m.DisputeID], m)
+	return nil
+}
+
+// Messages returns a dispute's thread, oldest first
+func (s *MemoryStore) Messages(ctx context.Context, disputeID string) ([]models.DisputeMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := append([]models.DisputeMessage(nil), s.messages[disputeID]...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt < out[j].CreatedAt })
+	return out, nil
+}
+
+// filter returns copies of the disputes matching keep
+func (s *MemoryStore) filter(keep func(models.Dispute) bool) []models.Dispute {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Dispute
+	for _, d := range s.disputes {
+		if keep(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
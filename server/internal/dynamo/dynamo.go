@@ -0,0 +1,342 @@
+// Package dynamo reads and writes the marketplace's single DynamoDB table.
+//
+// Every persistent store goes through a Table. It knows the table's key attributes and indexes,
+// marshals items by their dynamodbav tags, keeps empty index keys out of the sparse indexes, and
+// turns failed write conditions into ErrConflict so stores can map them to their own errors.
+// Writes that must land together, such as an aggregate and the domain events describing its
+// change, go through Write as one TransactWriteItems call.
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var (
+	// ErrNotFound is returned when an item does not exist
+	ErrNotFound = errors.New("dynamo: not found")
+	// ErrConflict is returned when a write's condition failed, or a transaction collided with
+	// another one
+	ErrConflict = errors.New("dynamo: condition failed")
+)
+
+// Index names. The table's own key is PK and SK; each global secondary index n is keyed by
+// GSInPK and GSInSK.
+const (
+	Primary = ""
+	GSI1    = "GSI1"
+	GSI2    = "GSI2"
+	GSI3    = "GSI3"
+)
+
+// MaxTransactItems is the most writes DynamoDB accepts in one transaction
+const MaxTransactItems = 100
+
+// indexKeys are the index key attributes, which DynamoDB rejects when they hold an empty string
+var indexKeys = []string{"GSI1PK", "GSI1SK", "GSI2PK", "GSI2SK", "GSI3PK", "GSI3SK"}
+
+// Client is the part of the DynamoDB API a Table uses. *dynamodb.Client satisfies it, and so
+// does MemoryClient.
+type Client interface {
+	GetItem(ctx context.Context, in *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, in *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Query(ctx context.Context, in *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	TransactWriteItems(ctx context.Context, in *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// Table is the marketplace table
+type Table struct {
+	client Client
+	name   string
+}
+
+// New creates a Table for the named table
+func New(client Client, name string) *Table {
+	return &Table{client: client, name: name}
+}
+
+// Condition guards a write. The zero Condition writes unconditionally.
+type Condition struct {
+	expr   string
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+// IfNotExists only writes an item that isn't stored yet
+func IfNotExists() Condition {
+	return Condition{expr: "attribute_not_exists(#pk)", names: map[string]string{"#pk": "PK"}}
+}
+
+// IfExists only writes over an item that is stored
+func IfExists() Condition {
+	return Condition{expr: "attribute_exists(#pk)", names: map[string]string{"#pk": "PK"}}
+}
+
+// IfVersion only writes over a stored item whose version attribute is v
+func IfVersion(v int64) Condition {
+	return Condition{
+		expr:   "#version = :version",
+		names:  map[string]string{"#version": "version"},
+		values: map[string]types.AttributeValue{":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}},
+	}
+}
+
+// Get reads the item with a primary key into out
+func (t *Table) Get(ctx context.Context, pk, sk string, out interface{}) error {
+	res, err := t.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(t.name),
+		Key:            Key(pk, sk),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("dynamo: get %s %s: %w", pk, sk, err)
+	}
+	if len(res.Item) == 0 {
+		return ErrNotFound
+	}
+	if err := attributevalue.UnmarshalMap(res.Item, out); err != nil {
+		return fmt.Errorf("dynamo: decoding %s %s: %w", pk, sk, err)
+	}
+	return nil
+}
+
+// Put writes an item if cond holds
+func (t *Table) Put(ctx context.Context, item interface{}, cond Condition) error {
+	av, err := marshal(item)
+	if err != nil {
+		return err
+	}
+	in := &dynamodb.PutItemInput{TableName: aws.String(t.name), Item: av}
+	if cond.expr != "" {
+		in.ConditionExpression = aws.String(cond.expr)
+		in.ExpressionAttributeNames = cond.names
+		in.ExpressionAttributeValues = cond.values
+	}
+	if _, err := t.client.PutItem(ctx, in); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
+
+// Delete removes the item with a primary key if cond holds. Deleting an item that doesn't exist
+// is not an error unless cond requires it to.
+func (t *Table) Delete(ctx context.Context, pk, sk string, cond Condition) error {
+	in := &dynamodb.DeleteItemInput{TableName: aws.String(t.name), Key: Key(pk, sk)}
+	if cond.expr != "" {
+		in.ConditionExpression = aws.String(cond.expr)
+		in.ExpressionAttributeNames = cond.names
+		in.ExpressionAttributeValues = cond.values
+	}
+	if _, err := t.client.DeleteItem(ctx, in); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
+
+// Op is one write in a transaction
+type Op struct {
+	item types.TransactWriteItem
+	err  error
+}
+
+// PutOp writes an item as part of a transaction if cond holds
+func PutOp(item interface{}, cond Condition) Op {
+	av, err := marshal(item)
+	if err != nil {
+		return Op{err: err}
+	}
+	put := &types.Put{Item: av}
+	if cond.expr != "" {
+		put.ConditionExpression = aws.String(cond.expr)
+		put.ExpressionAttributeNames = cond.names
+		put.ExpressionAttributeValues = cond.values
+	}
+	return Op{item: types.TransactWriteItem{Put: put}}
+}
+
+// DeleteOp removes an item as part of a transaction if cond holds
+func DeleteOp(pk, sk string, cond Condition) Op {
+	del := &types.Delete{Key: Key(pk, sk)}
+	if cond.expr != "" {
+		del.ConditionExpression = aws.String(cond.expr)
+		del.ExpressionAttributeNames = cond.names
+		del.ExpressionAttributeValues = cond.values
+	}
+	return Op{item: types.TransactWriteItem{Delete: del}}
+}
+
+// CheckOp fails a transaction unless cond holds for an item, without writing it
+func CheckOp(pk, sk string, cond Condition) Op {
+	return Op{item: types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+		Key:                       Key(pk, sk),
+		ConditionExpression:       aws.String(cond.expr),
+		ExpressionAttributeNames:  cond.names,
+		ExpressionAttributeValues: cond.values,
+	}}}
+}
+
+// Write applies ops in one all-or-nothing transaction. It returns ErrConflict if any op's
+// condition failed.
+func (t *Table) Write(ctx context.Context, ops ...Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	if len(ops) > MaxTransactItems {
+		return fmt.Errorf("dynamo: %d writes is more than one transaction holds", len(ops))
+	}
+	items := make([]types.TransactWriteItem, len(ops))
+	for i, op := range ops {
+		if op.err != nil {
+			return op.err
+		}
+		items[i] = op.item
+		switch {
+		case op.item.Put != nil:
+			op.item.Put.TableName = aws.String(t.name)
+		case op.item.Delete != nil:
+			op.item.Delete.TableName = aws.String(t.name)
+		case op.item.ConditionCheck != nil:
+			op.item.ConditionCheck.TableName = aws.String(t.name)
+		}
+	}
+	if _, err := t.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items}); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
+
+// Query selects items from the table or one of its indexes by partition key, optionally
+// narrowed by a sort key prefix or an inclusive sort key range.
+type Query struct {
+	Index      string // Primary, GSI1, GSI2 or GSI3
+	PK         string
+	SKPrefix   string
+	SKFrom     string // lowest sort key, "" for no lower bound
+	SKTo       string // highest sort key, "" for no upper bound
+	Descending bool   // highest sort key first
+	Limit      int    // most items returned, 0 for every match
+}
+
+// Query reads the items matching q, in sort key order, into out, which must point to a slice
+func (t *Table) Query(ctx context.Context, q Query, out interface{}) error {
+	pkName, skName := KeyNames(q.Index)
+	expr := "#pk = :pk"
+	names := map[string]string{"#pk": pkName}
+	values := map[string]types.AttributeValue{":pk": &types.AttributeValueMemberS{Value: q.PK}}
+	switch {
+	case q.SKPrefix != "":
+		expr += " AND begins_with(#sk, :sk)"
+		values[":sk"] = &types.AttributeValueMemberS{Value: q.SKPrefix}
+	case q.SKFrom != "" && q.SKTo != "":
+		expr += " AND #sk BETWEEN :from AND :to"
+		values[":from"] = &types.AttributeValueMemberS{Value: q.SKFrom}
+		values[":to"] = &types.AttributeValueMemberS{Value: q.SKTo}
+	case q.SKFrom != "":
+		expr += " AND #sk >= :from"
+		values[":from"] = &types.AttributeValueMemberS{Value: q.SKFrom}
+	case q.SKTo != "":
+		expr += " AND #sk <= :to"
+		values[":to"] = &types.AttributeValueMemberS{Value: q.SKTo}
+	}
+	if len(values) > 1 {
+		names["#sk"] = skName
+	}
+	in := &dynamodb.QueryInput{
+		TableName:                 aws.String(t.name),
+		KeyConditionExpression:    aws.String(expr),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(!q.Descending),
+	}
+	if q.Index == Primary {
+		in.ConsistentRead = aws.Bool(true)
+	} else {
+		in.IndexName = aws.String(q.Index)
+	}
+
+	var items []map[string]types.AttributeValue
+	for {
+		if q.Limit > 0 {
+			in.Limit = aws.Int32(int32(q.Limit - len(items)))
+		}
+		res, err := t.client.Query(ctx, in)
+		if err != nil {
+			return fmt.Errorf("dynamo: query %s %s: %w", q.Index, q.PK, err)
+		}
+		items = append(items, res.Items...)
+		if len(res.LastEvaluatedKey) == 0 || (q.Limit > 0 && len(items) >= q.Limit) {
+			break
+		}
+		in.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+	if err := attributevalue.UnmarshalListOfMaps(items, out); err != nil {
+		return fmt.Errorf("dynamo: decoding %s %s: %w", q.Index, q.PK, err)
+	}
+	return nil
+}
+
+// Through returns a sort key above every key that starts with prefix, to end a query range
+// with all of them
+func Through(prefix string) string {
+	return prefix + "\uffff"
+}
+
+// Key builds a primary key
+func Key(pk, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pk},
+		"SK": &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+// KeyNames returns the partition and sort key attributes of the table or an index
+func KeyNames(index string) (string, string) {
+	if index == Primary {
+		return "PK", "SK"
+	}
+	return index + "PK", index + "SK"
+}
+
+// marshal encodes an item, leaving out empty index keys so the item stays out of indexes it
+// doesn't belong in
+func marshal(item interface{}) (map[string]types.AttributeValue, error) {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return nil, fmt.Errorf("dynamo: encoding item: %w", err)
+	}
+	for _, name := range indexKeys {
+		if s, ok := av[name].(*types.AttributeValueMemberS); ok && s.Value == "" {
+			delete(av, name)
+		}
+	}
+	return av, nil
+}
+
+// writeError maps a failed condition or transaction collision to ErrConflict
+func writeError(err error) error {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrConflict
+	}
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		for _, r := range tce.CancellationReasons {
+			if code := aws.ToString(r.Code); code == "ConditionalCheckFailed" || code == "TransactionConflict" {
+				return ErrConflict
+			}
+		}
+	}
+	var tcf *types.TransactionConflictException
+	if errors.As(err, &tcf) {
+		return ErrConflict
+	}
+	return fmt.Errorf("dynamo: write: %w", err)
+}
@@ -0,0 +1,211 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MemoryClient is an in-process Client for tests and local runs against the persistent stores.
+// It understands only the key conditions and write conditions a Table builds, keeps one table,
+// and returns every query match in a single page.
+type MemoryClient struct {
+	mu    sync.RWMutex
+	items map[string]map[string]types.AttributeValue // PK + "\x00" + SK -> item
+}
+
+// NewMemoryClient creates an empty MemoryClient
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{items: map[string]map[string]types.AttributeValue{}}
+}
+
+// GetItem returns an item by primary key
+func (c *MemoryClient) GetItem(ctx context.Context, in *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return &dynamodb.GetItemOutput{Item: copyItem(c.items[itemKey(in.Key)])}, nil
+}
+
+// PutItem writes an item if its condition holds
+func (c *MemoryClient) PutItem(ctx context.Context, in *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := itemKey(in.Item)
+	ok, err := holds(c.items[k], aws.ToString(in.ConditionExpression), in.ExpressionAttributeNames, in.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	c.items[k] = copyItem(in.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// DeleteItem removes an item if its condition holds
+func (c *MemoryClient) DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := itemKey(in.Key)
+	ok, err := holds(c.items[k], aws.ToString(in.ConditionExpression), in.ExpressionAttributeNames, in.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	delete(c.items, k)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// Query returns the items of the table or an index matching a key condition
+func (c *MemoryClient) Query(ctx context.Context, in *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	pkName, skName := KeyNames(aws.ToString(in.IndexName))
+	expr := aws.ToString(in.KeyConditionExpression)
+	pk := stringValue(in.ExpressionAttributeValues[":pk"])
+	from, to := stringValue(in.ExpressionAttributeValues[":from"]), stringValue(in.ExpressionAttributeValues[":to"])
+	prefix := stringValue(in.ExpressionAttributeValues[":sk"])
+
+	var out []map[string]types.AttributeValue
+	for _, item := range c.items {
+		if stringValue(item[pkName]) != pk {
+			continue
+		}
+		sk, ok := item[skName].(*types.AttributeValueMemberS)
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.Contains(expr, "begins_with"):
+			ok = strings.HasPrefix(sk.Value, prefix)
+		case strings.Contains(expr, "BETWEEN"):
+			ok = sk.Value >= from && sk.Value <= to
+		case strings.Contains(expr, ">="):
+			ok = sk.Value >= from
+		case strings.Contains(expr, "<="):
+			ok = sk.Value <= to
+		case expr != "#pk = :pk":
+			return nil, fmt.Errorf("dynamo: MemoryClient can't evaluate key condition %q", expr)
+		}
+		if ok {
+			out = append(out, copyItem(item))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := stringValue(out[i][skName]), stringValue(out[j][skName])
+		if a == b {
+			a, b = itemKey(out[i]), itemKey(out[j])
+		}
+		if in.ScanIndexForward != nil && !*in.ScanIndexForward {
+			return a > b
+		}
+		return a < b
+	})
+	if in.Limit != nil && int(*in.Limit) < len(out) {
+		out = out[:*in.Limit]
+	}
+	return &dynamodb.QueryOutput{Items: out, Count: int32(len(out))}, nil
+}
+
+// TransactWriteItems applies writes all or nothing
+func (c *MemoryClient) TransactWriteItems(ctx context.Context, in *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reasons := make([]types.CancellationReason, len(in.TransactItems))
+	seen := map[string]bool{}
+	failed := false
+	for i, ti := range in.TransactItems {
+		var key map[string]types.AttributeValue
+		var cond *string
+		var names map[string]string
+		var values map[string]types.AttributeValue
+		switch {
+		case ti.Put != nil:
+			key, cond, names, values = ti.Put.Item, ti.Put.ConditionExpression, ti.Put.ExpressionAttributeNames, ti.Put.ExpressionAttributeValues
+		case ti.Delete != nil:
+			key, cond, names, values = ti.Delete.Key, ti.Delete.ConditionExpression, ti.Delete.ExpressionAttributeNames, ti.Delete.ExpressionAttributeValues
+		case ti.ConditionCheck != nil:
+			key, cond, names, values = ti.ConditionCheck.Key, ti.ConditionCheck.ConditionExpression, ti.ConditionCheck.ExpressionAttributeNames, ti.ConditionCheck.ExpressionAttributeValues
+		default:
+			return nil, errors.New("dynamo: MemoryClient only handles puts, deletes and condition checks")
+		}
+		k := itemKey(key)
+		if seen[k] {
+			return nil, errors.New("dynamo: transaction writes one item more than once")
+		}
+		seen[k] = true
+		ok, err := holds(c.items[k], aws.ToString(cond), names, values)
+		if err != nil {
+			return nil, err
+		}
+		reasons[i].Code = aws.String("None")
+		if !ok {
+			reasons[i].Code = aws.String("ConditionalCheckFailed")
+			failed = true
+		}
+	}
+	if failed {
+		return nil, &types.TransactionCanceledException{Message: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+	for _, ti := range in.TransactItems {
+		switch {
+		case ti.Put != nil:
+			c.items[itemKey(ti.Put.Item)] = copyItem(ti.Put.Item)
+		case ti.Delete != nil:
+			delete(c.items, itemKey(ti.Delete.Key))
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// holds evaluates one of the write conditions a Table builds against the stored item, nil when
+// there is none
+func holds(item map[string]types.AttributeValue, expr string, names map[string]string, values map[string]types.AttributeValue) (bool, error) {
+	switch expr {
+	case "":
+		return true, nil
+	case "attribute_not_exists(#pk)":
+		return item == nil, nil
+	case "attribute_exists(#pk)":
+		return item != nil, nil
+	case "#version = :version":
+		v, ok := item[names["#version"]].(*types.AttributeValueMemberN)
+		want, _ := values[":version"].(*types.AttributeValueMemberN)
+		return ok && want != nil && v.Value == want.Value, nil
+	}
+	return false, fmt.Errorf("dynamo: MemoryClient can't evaluate condition %q", expr)
+}
+
+// itemKey joins an item's primary key into a map key
+func itemKey(item map[string]types.AttributeValue) string {
+	return stringValue(item["PK"]) + "\x00" + stringValue(item["SK"])
+}
+
+// stringValue returns a string attribute's value, or "" for any other attribute
+func stringValue(av types.AttributeValue) string {
+	if s, ok := av.(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+
+// copyItem copies an item's top-level attributes so callers can't change the stored map
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	out := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		out[k] = v
+	}
+	return out
+}
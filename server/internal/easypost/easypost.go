@@ -0,0 +1,138 @@
+// Package easypost is a small client for the EasyPost API, shared by shipping for buying postage
+// and by tracking for following shipments, and the decoding and verification of the webhook events
+// EasyPost sends.
+package easypost
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/webhooks"
+)
+
+// DefaultBaseURL is the EasyPost API root
+const DefaultBaseURL = "https://api.easypost.com"
+
+// SignaturePrefix starts the X-Hmac-Signature header EasyPost signs webhook events with
+const SignaturePrefix = "hmac-sha256-hex="
+
+// Client sends requests to the EasyPost API.
+type Client struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+// New creates a Client. An empty baseURL uses DefaultBaseURL; overriding it points the client at a
+// mock server.
+func New(apiKey, baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// apiError is the error envelope returned by the EasyPost API
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Tracker is the subset of an EasyPost tracker we read
+type Tracker struct {
+	ID              string           `json:"id"`
+	TrackingCode    string           `json:"tracking_code"`
+	Carrier         string           `json:"carrier"`
+	Status          string           `json:"status"`
+	TrackingDetails []TrackingDetail `json:"tracking_details"`
+}
+
+// TrackingDetail is one scan on a tracker
+type TrackingDetail struct {
+	Message          string    `json:"message"`
+	Status           string    `json:"status"`
+	Datetime         time.Time `json:"datetime"`
+	TrackingLocation struct {
+		City    string `json:"city"`
+		State   string `json:"state"`
+		Country string `json:"country"`
+	} `json:"tracking_location"`
+}
+
+// Event is a webhook event. Result holds the object the event is about, such as a Tracker for
+// "tracker.updated".
+type Event struct {
+	ID          string          `json:"id"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// Do sends a JSON request and decodes the JSON response into out
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.apiKey, "")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("easypost %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("easypost %s %s: reading response: %w", method, path, err)
+	}
+	if resp.StatusCode >= 300 {
+		var ee apiError
+		_ = json.Unmarshal(raw, &ee)
+		return fmt.Errorf("easypost %s %s: %d %s: %s", method, path, resp.StatusCode, ee.Error.Code, ee.Error.Message)
+	}
+
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("easypost %s %s: decoding response: %w", method, path, err)
+	}
+	return nil
+}
+
+// Sign returns the X-Hmac-Signature header EasyPost sends with a webhook payload: the hex
+// HMAC-SHA256 of the raw body keyed by the webhook's secret
+func Sign(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return SignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a webhook's X-Hmac-Signature header against the raw payload and secret
+func Verify(payload []byte, header, secret string) error {
+	if secret == "" || !strings.HasPrefix(header, SignaturePrefix) {
+		return webhooks.ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(header), []byte(Sign(payload, secret))) {
+		return webhooks.ErrInvalidSignature
+	}
+	return nil
+}
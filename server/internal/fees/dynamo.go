@@ -0,0 +1,61 @@
+package fees
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. Every version shares one partition, sorted
+// by version number.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Put stores a new schedule version
+func (s *DynamoStore) Put(ctx context.Context, fs models.FeeSchedule) error {
+	err := s.table.Put(ctx, fs, dynamo.IfNotExists())
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
+
+// Get returns a schedule version
+func (s *DynamoStore) Get(ctx context.Context, version int) (models.FeeSchedule, error) {
+	var fs models.FeeSchedule
+	pk, sk := models.FeeScheduleKey(version)
+	err := s.table.Get(ctx, pk, sk, &fs)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return fs, ErrNotFound
+	}
+	return fs, err
+}
+
+// Latest returns the highest schedule version
+func (s *DynamoStore) Latest(ctx context.Context) (models.FeeSchedule, error) {
+	var out []models.FeeSchedule
+	pk, _ := models.FeeScheduleKey(0)
+	if err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "VERSION#", Descending: true, Limit: 1}, &out); err != nil {
+		return models.FeeSchedule{}, err
+	}
+	if len(out) == 0 {
+		return models.FeeSchedule{}, ErrNoSchedule
+	}
+	return out[0], nil
+}
+
+// List returns every schedule, newest first
+func (s *DynamoStore) List(ctx context.Context) ([]models.FeeSchedule, error) {
+	var out []models.FeeSchedule
+	pk, _ := models.FeeScheduleKey(0)
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "VERSION#", Descending: true}, &out)
+	return out, err
+}
@@ -0,0 +1,298 @@
+// Package fees computes platform commission on order lines from a versioned schedule of rules.
+//
+// Each line is charged by the single rule that matches it best: an active promotional rule beats a
+// regular one, then the rule with the most match fields set wins, then the earliest in the schedule.
+// Publishing rules creates a new schedule version; orders record the version they were charged
+// under, so changing the rules never changes the fees on existing orders.
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+)
+
+var (
+	// ErrNoSchedule is returned when no fee schedule has been published yet
+	ErrNoSchedule = errors.New("fees: no schedule published")
+	// ErrNotFound is returned when a schedule version does not exist
+	ErrNotFound = errors.New("fees: schedule version not found")
+	// ErrConflict is returned when a schedule version has already been published
+	ErrConflict = errors.New("fees: schedule version already exists")
+	// ErrInvalidRule is returned when a rule is malformed
+	ErrInvalidRule = errors.New("fees: invalid rule")
+	// ErrNoMatchingRule is returned when no rule applies to a line
+	ErrNoMatchingRule = errors.New("fees: no rule matches line")
+)
+
+// Store persists fee schedules. Published versions are never modified.
+type Store interface {
+	// Put writes a new schedule version, returning ErrConflict if the version already exists
+	Put(ctx context.Context, s models.FeeSchedule) error
+	Get(ctx context.Context, version int) (models.FeeSchedule, error)
+	// Latest returns the highest published version, or ErrNoSchedule
+	Latest(ctx context.Context) (models.FeeSchedule, error)
+	List(ctx context.Context) ([]models.FeeSchedule, error)
+}
+
+// RiskTiers looks up a seller's risk tier
+type RiskTiers interface {
+	RiskTier(ctx context.Context, sellerID string) (string, error)
+}
+
+// Line is an order line to price
+type Line struct {
+	Game           string
+	Category       string
+	UnitPriceCents int64
+	Quantity       int
+}
+
+// LineFee is the commission on one line and the rule that produced it
+type LineFee struct {
+	FeeCents int64
+	Rule     string
+}
+
+// Quote is the commission on a set of lines under one schedule version
+type Quote struct {
+	Version    int
+	Lines      []LineFee
+	TotalCents int64
+}
+
+// Engine prices order lines against the current fee schedule.
+type Engine struct {
+	store Store
+	tiers RiskTiers
+	now   func() time.Time
+
+	mu sync.Mutex // serialises publishing so versions are assigned in order
+}
+
+// New creates a fee Engine
+func New(store Store, tiers RiskTiers) *Engine {
+	return &Engine{store: store, tiers: tiers, now: time.Now}
+}
+
+// Attach assesses fees on every order at checkout, so the order carries its fees and schedule version
+func (e *Engine) Attach(o *orders.Service) {
+	o.BeforeCreate(e.Assess)
+}
+
+// Assess fills in an order's per-line and total fees from the current schedule
+func (e *Engine) Assess(ctx context.Context, o *models.Order) error {
+	lines := make([]Line, len(o.Items))
+	for i, it := range o.Items {
+		lines[i] = Line{Game: it.Game, Category: it.Category, UnitPriceCents: it.UnitPriceCents, Quantity: it.Quantity}
+	}
+
+	q, err := e.Quote(ctx, o.SellerID, lines)
+	if err != nil {
+		return err
+	}
+
+	for i := range o.Items {
+		o.Items[i].FeeCents = q.Lines[i].FeeCents
+	}
+	o.FeeCents = q.TotalCents
+	o.FeeVersion = q.Version
+	return nil
+}
+
+// OrderFee returns the fee an order was assessed at checkout. Orders created before fees were
+// assessed are priced against the current schedule.
+func (e *Engine) OrderFee(ctx context.Context, o models.Order) (int64, error) {
+	if o.FeeVersion > 0 {
+		return o.FeeCents, nil
+	}
+	if err := e.Assess(ctx, &o); err != nil {
+		return 0, err
+	}
+	return o.FeeCents, nil
+}
+
+// Quote prices lines for a seller against the current schedule
+func (e *Engine) Quote(ctx context.Context, sellerID string, lines []Line) (Quote, error) {
+	schedule, err := e.store.Latest(ctx)
+	if err != nil {
+		return Quote{}, err
+	}
+	return e.QuoteWith(ctx, schedule, sellerID, lines)
+}
+
+// QuoteWith prices lines for a seller against a specific schedule
+func (e *Engine) QuoteWith(ctx context.Context, schedule models.FeeSchedule, sellerID string, lines []Line) (Quote, error) {
+	tier, err := e.tiers.RiskTier(ctx, sellerID)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	now := e.now()
+	q := Quote{Version: schedule.Version, Lines: make([]LineFee, len(lines))}
+	for i, l := range lines {
+		r, ok := bestRule(schedule.Rules, l, sellerID, tier, now)
+		if !ok {
+			return Quote{}, fmt.Errorf("%w: %s %s at %d", ErrNoMatchingRule, l.Game, l.Category, l.UnitPriceCents)
+		}
+		fee := Charge(r, l.UnitPriceCents*int64(l.Quantity))
+		q.Lines[i] = LineFee{FeeCents: fee, Rule: r.Name}
+		q.TotalCents += fee
+	}
+	return q, nil
+}
+
+// Publish validates rules and stores them as the next schedule version
+func (e *Engine) Publish(ctx context.Context, rules []models.FeeRule, publishedBy string) (models.FeeSchedule, error) {
+	for i, r := range rules {
+		if err := Validate(r); err != nil {
+			return models.FeeSchedule{}, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	version := 1
+	latest, err := e.store.Latest(ctx)
+	switch {
+	case err == nil:
+		version = latest.Version + 1
+	case !errors.Is(err, ErrNoSchedule):
+		return models.FeeSchedule{}, err
+	}
+
+	s := models.FeeSchedule{
+		Version:     version,
+		Rules:       rules,
+		PublishedBy: publishedBy,
+		PublishedAt: e.now().UTC().Format(time.RFC3339),
+		Type:        models.ItemTypeFees,
+	}
+	s.PK, s.SK = models.FeeScheduleKey(version)
+
+	if err := e.store.Put(ctx, s); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// Current returns the latest schedule
+func (e *Engine) Current(ctx context.Context) (models.FeeSchedule, error) {
+	return e.store.Latest(ctx)
+}
+
+// Schedules returns every published schedule, newest first
+func (e *Engine) Schedules(ctx context.Context) ([]models.FeeSchedule, error) {
+	return e.store.List(ctx)
+}
+
+// Charge computes a rule's fee on a line total: the fixed part plus the percentage, rounded half
+// up, then held within the rule's minimum and cap. The fee never exceeds the line total.
+func Charge(r models.FeeRule, lineCents int64) int64 {
+	fee := r.FixedCents + (lineCents*r.PercentBps+5000)/10000
+	if fee < r.MinCents {
+		fee = r.MinCents
+	}
+	if r.MaxCents > 0 && fee > r.MaxCents {
+		fee = r.MaxCents
+	}
+	return min(fee, lineCents)
+}
+
+// Validate checks a rule is well formed
+func Validate(r models.FeeRule) error {
+	switch {
+	case r.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidRule)
+	case r.PercentBps < 0 || r.PercentBps > 10000:
+		return fmt.Errorf("%w: percentage must be between 0 and 100%%", ErrInvalidRule)
+	case r.FixedCents < 0 || r.MinCents < 0 || r.MaxCents < 0 || r.MinPriceCents < 0 || r.MaxPriceCents < 0:
+		return fmt.Errorf("%w: amounts cannot be negative", ErrInvalidRule)
+	case r.MaxCents > 0 && r.MinCents > r.MaxCents:
+		return fmt.Errorf("%w: minimum fee is above the cap", ErrInvalidRule)
+	case r.MaxPriceCents > 0 && r.MinPriceCents > r.MaxPriceCents:
+		return fmt.Errorf("%w: price band is empty", ErrInvalidRule)
+	}
+	for _, ts := range []string{r.StartsAt, r.EndsAt} {
+		if ts == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, ts); err != nil {
+			return fmt.Errorf("%w: %q is not an RFC3339 time", ErrInvalidRule, ts)
+		}
+	}
+	return nil
+}
+
+// bestRule picks the rule that applies to a line
+func bestRule(rules []models.FeeRule, l Line, sellerID, tier string, now time.Time) (models.FeeRule, bool) {
+	best, bestRank := -1, -1
+	for i, r := range rules {
+		if !matches(r, l, sellerID, tier, now) {
+			continue
+		}
+		rank := specificity(r)
+		if r.Promo {
+			rank += 100
+		}
+		if rank > bestRank {
+			best, bestRank = i, rank
+		}
+	}
+	if best < 0 {
+		return models.FeeRule{}, false
+	}
+	return rules[best], true
+}
+
+// matches reports whether a rule applies to a line
+func matches(r models.FeeRule, l Line, sellerID, tier string, now time.Time) bool {
+	if (r.Game != "" && r.Game != l.Game) ||
+		(r.Category != "" && r.Category != l.Category) ||
+		(r.RiskTier != "" && r.RiskTier != tier) ||
+		(r.SellerID != "" && r.SellerID != sellerID) {
+		return false
+	}
+	if l.UnitPriceCents < r.MinPriceCents || (r.MaxPriceCents > 0 && l.UnitPriceCents > r.MaxPriceCents) {
+		return false
+	}
+	if r.StartsAt != "" {
+		if t, err := time.Parse(time.RFC3339, r.StartsAt); err != nil || now.Before(t) {
+			return false
+		}
+	}
+	if r.EndsAt != "" {
+		if t, err := time.Parse(time.RFC3339, r.EndsAt); err != nil || !now.Before(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// specificity counts how many match fields a rule sets
+func specificity(r models.FeeRule) int {
+	n := 0
+	for _, set := range []bool{r.Game != "", r.Category != "", r.RiskTier != "", r.SellerID != "", r.MinPriceCents > 0 || r.MaxPriceCents > 0} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// DefaultRules is the schedule published when none exists yet
+func DefaultRules() []models.FeeRule {
+	return []models.FeeRule{
+		{Name: "Standard", PercentBps: 950, FixedCents: 30},
+		{Name: "High risk sellers", RiskTier: models.RiskTierHigh, PercentBps: 1250, FixedCents: 30},
+		{Name: "Low risk sellers", RiskTier: models.RiskTierLow, PercentBps: 850, FixedCents: 30},
+		{Name: "High value singles", Category: models.CategorySingle, MinPriceCents: 10000, PercentBps: 650, MaxCents: 5000},
+		{Name: "Sealed product", Category: models.CategorySealed, PercentBps: 850, FixedCents: 30},
+	}
+}
@@ -0,0 +1,206 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// tiers is a fixed seller -> risk tier lookup; unknown sellers are standard
+type tiers map[string]string
+
+func (t tiers) RiskTier(ctx context.Context, sellerID string) (string, error) {
+	if tier, ok := t[sellerID]; ok {
+		return tier, nil
+	}
+	return models.RiskTierStandard, nil
+}
+
+var now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newEngine() *Engine {
+	e := New(NewMemoryStore(), tiers{"risky": models.RiskTierHigh})
+	e.now = func() time.Time { return now }
+	return e
+}
+
+func publish(t *testing.T, e *Engine, rules ...models.FeeRule) models.FeeSchedule {
+	t.Helper()
+	s, err := e.Publish(context.Background(), rules, "admin")
+	if err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+	return s
+}
+
+func single(priceCents int64, qty int) Line {
+	return Line{Game: "mtg", Category: models.CategorySingle, UnitPriceCents: priceCents, Quantity: qty}
+}
+
+func order(items ...models.OrderItem) *models.Order {
+	return &models.Order{SellerID: "seller", Items: items}
+}
+
+func TestAssessRecordsVersion(t *testing.T) {
+	ctx := context.Background()
+	e := newEngine()
+
+	o := order(models.OrderItem{Game: "mtg", Category: models.CategorySingle, UnitPriceCents: 1000, Quantity: 2})
+	if err := e.Assess(ctx, o); !errors.Is(err, ErrNoSchedule) {
+		t.Fatalf("assessing before any schedule: err = %v, want ErrNoSchedule", err)
+	}
+
+	publish(t, e, models.FeeRule{Name: "Ten percent", PercentBps: 1000})
+	if err := e.Assess(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	if o.FeeVersion != 1 || o.FeeCents != 200 || o.Items[0].FeeCents != 200 {
+		t.Fatalf("assessed v%d fee %d (line %d), want v1 fee 200", o.FeeVersion, o.FeeCents, o.Items[0].FeeCents)
+	}
+
+	// new rules don't change what an existing order was charged
+	publish(t, e, models.FeeRule{Name: "Twenty percent", PercentBps: 2000})
+	fee, err := e.OrderFee(ctx, *o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee != 200 {
+		t.Fatalf("fee on the v1 order = %d after publishing v2, want 200", fee)
+	}
+
+	next := order(models.OrderItem{Game: "mtg", Category: models.CategorySingle, UnitPriceCents: 1000, Quantity: 2})
+	if err := e.Assess(ctx, next); err != nil {
+		t.Fatal(err)
+	}
+	if next.FeeVersion != 2 || next.FeeCents != 400 {
+		t.Fatalf("new order assessed v%d fee %d, want v2 fee 400", next.FeeVersion, next.FeeCents)
+	}
+}
+
+func TestOrderFeeAssessesLegacyOrder(t *testing.T) {
+	e := newEngine()
+	publish(t, e, models.FeeRule{Name: "Ten percent", PercentBps: 1000})
+
+	// an order from before fees were assessed has no version and is priced now
+	o := order(models.OrderItem{Game: "mtg", Category: models.CategorySingle, UnitPriceCents: 500, Quantity: 1})
+	fee, err := e.OrderFee(context.Background(), *o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fee != 50 {
+		t.Fatalf("fee = %d, want 50", fee)
+	}
+}
+
+func TestQuoteWithOldSchedule(t *testing.T) {
+	ctx := context.Background()
+	e := newEngine()
+	v1 := publish(t, e, models.FeeRule{Name: "Ten percent", PercentBps: 1000})
+	publish(t, e, models.FeeRule{Name: "Twenty percent", PercentBps: 2000})
+
+	q, err := e.QuoteWith(ctx, v1, "seller", []Line{single(1000, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Version != 1 || q.TotalCents != 100 || q.Lines[0].Rule != "Ten percent" {
+		t.Fatalf("quote = %+v, want v1 at 100 by Ten percent", q)
+	}
+
+	schedules, err := e.Schedules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 2 || schedules[0].Version != 2 {
+		t.Fatalf("schedules = %d starting v%d, want 2 newest first", len(schedules), schedules[0].Version)
+	}
+}
+
+func TestBestRule(t *testing.T) {
+	ctx := context.Background()
+	e := newEngine()
+	publish(t, e,
+		models.FeeRule{Name: "Standard", PercentBps: 1000},
+		models.FeeRule{Name: "Also standard", PercentBps: 500},
+		models.FeeRule{Name: "High risk", RiskTier: models.RiskTierHigh, PercentBps: 1500},
+		models.FeeRule{Name: "Pricey singles", Category: models.CategorySingle, MinPriceCents: 10000, PercentBps: 600},
+		models.FeeRule{Name: "Pricey mtg singles", Game: "mtg", Category: models.CategorySingle, MinPriceCents: 10000, PercentBps: 500},
+		models.FeeRule{Name: "Sealed promo", Category: models.CategorySealed, Promo: true, PercentBps: 0,
+			StartsAt: now.Add(-time.Hour).Format(time.RFC3339), EndsAt: now.Add(time.Hour).Format(time.RFC3339)},
+		models.FeeRule{Name: "Expired promo", Promo: true, PercentBps: 100, EndsAt: now.Format(time.RFC3339)},
+		models.FeeRule{Name: "Future promo", Promo: true, PercentBps: 100, StartsAt: now.Add(time.Minute).Format(time.RFC3339)},
+	)
+
+	cases := []struct {
+		name   string
+		seller string
+		line   Line
+		want   string
+	}{
+		{"the earliest of equally specific rules wins", "seller", single(1000, 1), "Standard"},
+		{"a matching risk tier beats the default", "risky", single(1000, 1), "High risk"},
+		{"more match fields win", "seller", single(10000, 1), "Pricey mtg singles"},
+		{"a price band is inclusive", "seller", Line{Game: "pokemon", Category: models.CategorySingle, UnitPriceCents: 10000, Quantity: 1}, "Pricey singles"},
+		{"an open promo beats everything", "risky", Line{Game: "mtg", Category: models.CategorySealed, UnitPriceCents: 5000, Quantity: 1}, "Sealed promo"},
+	}
+	for _, c := range cases {
+		q, err := e.Quote(ctx, c.seller, []Line{c.line})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := q.Lines[0].Rule; got != c.want {
+			t.Errorf("%s: charged by %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestQuoteWithoutMatchingRule(t *testing.T) {
+	e := newEngine()
+	publish(t, e, models.FeeRule{Name: "Pokemon only", Game: "pokemon", PercentBps: 1000})
+	if _, err := e.Quote(context.Background(), "seller", []Line{single(1000, 1)}); !errors.Is(err, ErrNoMatchingRule) {
+		t.Fatalf("err = %v, want ErrNoMatchingRule", err)
+	}
+}
+
+func TestCharge(t *testing.T) {
+	cases := []struct {
+		name string
+		rule models.FeeRule
+		line int64
+		want int64
+	}{
+		{"percentage plus fixed", models.FeeRule{PercentBps: 950, FixedCents: 30}, 1000, 125},
+		{"rounds half up", models.FeeRule{PercentBps: 250}, 10, 0},
+		{"rounds half up at half", models.FeeRule{PercentBps: 500}, 10, 1},
+		{"held at the minimum", models.FeeRule{PercentBps: 100, MinCents: 25}, 1000, 25},
+		{"held at the cap", models.FeeRule{PercentBps: 650, MaxCents: 5000}, 100000, 5000},
+		{"never more than the line", models.FeeRule{FixedCents: 30}, 20, 20},
+	}
+	for _, c := range cases {
+		if got := Charge(c.rule, c.line); got != c.want {
+			t.Errorf("%s: Charge = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPublishValidates(t *testing.T) {
+	e := newEngine()
+	bad := []models.FeeRule{
+		{PercentBps: 100},
+		{Name: "Over 100%", PercentBps: 10001},
+		{Name: "Negative", FixedCents: -1},
+		{Name: "Min over cap", MinCents: 10, MaxCents: 5},
+		{Name: "Empty band", MinPriceCents: 10, MaxPriceCents: 5},
+		{Name: "Bad time", StartsAt: "tomorrow"},
+	}
+	for _, r := range bad {
+		if _, err := e.Publish(context.Background(), []models.FeeRule{r}, "admin"); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("%+v: err = %v, want ErrInvalidRule", r, err)
+		}
+	}
+	if _, err := e.Current(context.Background()); !errors.Is(err, ErrNoSchedule) {
+		t.Fatalf("invalid rules were published: err = %v", err)
+	}
+}
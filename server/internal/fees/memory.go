@@ -0,0 +1,71 @@
+package fees
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	schedules map[int]models.FeeSchedule
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{schedules: map[int]models.FeeSchedule{}}
+}
+
+// Put stores a new schedule version
+func (s *MemoryStore) Put(ctx context.Context, fs models.FeeSchedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.schedules[fs.Version]; exists {
+		return ErrConflict
+	}
+	fs.Rules = append([]models.FeeRule(nil), fs.Rules...)
+	s.schedules[fs.Version] = fs
+	return nil
+}
+
+// Get returns a schedule version
+func (s *MemoryStore) Get(ctx context.Context, version int) (models.FeeSchedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fs, ok := s.schedules[version]
+	if !ok {
+		return models.FeeSchedule{}, ErrNotFound
+	}
+	return fs, nil
+}
+
+// Latest returns the highest schedule version
+func (s *MemoryStore) Latest(ctx context.Context) (models.FeeSchedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var latest models.FeeSchedule
+	for v, fs := range s.schedules {
+		if v > latest.Version {
+			latest = fs
+		}
+	}
+	if latest.Version == 0 {
+		return latest, ErrNoSchedule
+	}
+	return latest, nil
+}
+
+// List returns every schedule, newest first
+func (s *MemoryStore) List(ctx context.Context) ([]models.FeeSchedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]models.FeeSchedule, 0, len(s.schedules))
+	for _, fs := range s.schedules {
+		out = append(out, fs)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version > out[j].Version })
+	return out, nil
+}
@@ -0,0 +1,41 @@
+package grading
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeVerifier is an in-memory CertVerifier for tests and local development. It knows the certs
+// that have been added, and returns Missing for any other number.
+type FakeVerifier struct {
+	Missing error // returned for unknown certs, ErrCertNotFound when nil
+
+	mu    sync.Mutex
+	certs map[string]Cert // company#cert number -> cert
+}
+
+// NewFakeVerifier creates an empty FakeVerifier
+func NewFakeVerifier() *FakeVerifier {
+	return &FakeVerifier{certs: map[string]Cert{}}
+}
+
+// Add records a cert the verifier will report
+func (f *FakeVerifier) Add(c Cert) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.certs[c.Company+"#"+c.CertNumber] = c
+}
+
+// Lookup returns the cert added for a number
+func (f *FakeVerifier) Lookup(ctx context.Context, company, certNumber string) (Cert, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.certs[company+"#"+certNumber]
+	if !ok {
+		if f.Missing != nil {
+			return Cert{}, f.Missing
+		}
+		return Cert{}, ErrCertNotFound
+	}
+	return c, nil
+}
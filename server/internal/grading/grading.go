@@ -0,0 +1,181 @@
+// Package grading validates the grades on slabbed cards and confirms their cert numbers.
+//
+// Each grading company has its own grade scale: PSA grades whole numbers with half grades below
+// 9 and prints no subgrades, while BGS and CGC grade in half steps and may print centering,
+// corners, edges and surface subgrades. A slab's cert number is looked up with a CertVerifier, and
+// the cert must be for the listing's printing at the listing's grade before the slab is marked
+// verified. When the verifier can't be reached the listing goes up unverified rather than failing.
+package grading
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+var (
+	// ErrInvalid is returned for an unknown company or a grade off the company's scale
+	ErrInvalid = errors.New("grading: invalid grading")
+	// ErrInvalidCert is returned for a cert number that isn't the right shape
+	ErrInvalidCert = errors.New("grading: invalid cert number")
+	// ErrCertNotFound is returned when the grading company has no cert with the number
+	ErrCertNotFound = errors.New("grading: cert not found")
+	// ErrMismatch is returned when a cert is for a different card or grade than the listing
+	ErrMismatch = errors.New("grading: cert does not match")
+	// ErrUnavailable is returned when the grading company's lookup can't be reached
+	ErrUnavailable = errors.New("grading: cert lookup unavailable")
+)
+
+// Cert is a grading company's record of one slab
+type Cert struct {
+	Company         string
+	CertNumber      string
+	Grade           string
+	Subgrades       models.Subgrades
+	CardName        string
+	SetCode         string // may be empty when the company doesn't record it
+	CollectorNumber string // may be empty when the company doesn't record it
+}
+
+// CertVerifier looks up a slab's cert with its grading company, returning ErrCertNotFound for
+// an unknown number and ErrUnavailable when the lookup can't be made.
+type CertVerifier interface {
+	Lookup(ctx context.Context, company, certNumber string) (Cert, error)
+}
+
+// scale is the grades a company gives
+type scale struct {
+	halves    func(grade float64) bool // whether a half grade is given at this level
+	subgrades bool                     // whether the company prints subgrades
+}
+
+// scales holds each company's grade scale
+var scales = map[string]scale{
+	models.GraderPSA: {halves: func(g float64) bool { return g > 1 && g < 9 }},
+	models.GraderBGS: {halves: func(g float64) bool { return true }, subgrades: true},
+	models.GraderCGC: {halves: func(g float64) bool { return true }, subgrades: true},
+}
+
+// Normalize cleans up a grading as entered, upper-casing the company and writing grades and the
+// cert number in canonical form, then validates it against the company's scale
+func Normalize(g models.Grading) (models.Grading, error) {
+	g.Company = strings.ToUpper(strings.TrimSpace(g.Company))
+	sc, ok := scales[g.Company]
+	if !ok {
+		return g, fmt.Errorf("%w: choose PSA, BGS or CGC", ErrInvalid)
+	}
+
+	grade, err := parseGrade(g.Grade, sc)
+	if err != nil {
+		return g, fmt.Errorf("%w: %s doesn't give a grade of %q", ErrInvalid, g.Company, strings.TrimSpace(g.Grade))
+	}
+	g.Grade = formatGrade(grade)
+
+	subs := []*string{&g.Subgrades.Centering, &g.Subgrades.Corners, &g.Subgrades.Edges, &g.Subgrades.Surface}
+	set := 0
+	for _, s := range subs {
+		if *s = strings.TrimSpace(*s); *s != "" {
+			set++
+		}
+	}
+	switch {
+	case set > 0 && !sc.subgrades:
+		return g, fmt.Errorf("%w: %s slabs have no subgrades", ErrInvalid, g.Company)
+	case set > 0 && set < len(subs):
+		return g, fmt.Errorf("%w: give all four subgrades or none", ErrInvalid)
+	case set > 0:
+		highest := 0.0
+		for _, s := range subs {
+			v, err := parseGrade(*s, scales[models.GraderBGS])
+			if err != nil {
+				return g, fmt.Errorf("%w: the subgrade %q isn't from 1 to 10 in half steps", ErrInvalid, *s)
+			}
+			*s = formatGrade(v)
+			if v > highest {
+				highest = v
+			}
+		}
+		if grade > highest {
+			return g, fmt.Errorf("%w: the grade can't be higher than every subgrade", ErrInvalid)
+		}
+	}
+
+	g.CertNumber = strings.TrimSpace(strings.ReplaceAll(g.CertNumber, " ", ""))
+	if len(g.CertNumber) < 6 || len(g.CertNumber) > 12 || strings.Trim(g.CertNumber, "0123456789") != "" {
+		return g, fmt.Errorf("%w: enter the 6 to 12 digits on the label", ErrInvalidCert)
+	}
+	return g, nil
+}
+
+// parseGrade reads a grade and checks it is on a scale
+func parseGrade(s string, sc scale) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v < 1 || v > 10 {
+		return 0, ErrInvalid
+	}
+	switch whole := v == float64(int(v)); {
+	case whole:
+		return v, nil
+	case v*2 == float64(int(v*2)) && sc.halves(v):
+		return v, nil
+	}
+	return 0, ErrInvalid
+}
+
+// formatGrade writes a grade the way labels do, such as "10" or "9.5"
+func formatGrade(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Service validates gradings and verifies their certs.
+type Service struct {
+	verifier CertVerifier
+	now      func() time.Time
+}
+
+// New creates a grading Service
+func New(v CertVerifier) *Service {
+	return &Service{verifier: v, now: time.Now}
+}
+
+// Verify normalizes a grading and looks up its cert, which must be for the printing at the same
+// company and grade. A matching cert sets VerifiedAt. When the lookup is unavailable the grading
+// is returned unverified along with ErrUnavailable, which callers may treat as a warning.
+func (s *Service) Verify(ctx context.Context, g models.Grading, p models.Printing) (models.Grading, error) {
+	g, err := Normalize(g)
+	if err != nil {
+		return g, err
+	}
+	g.VerifiedAt = ""
+
+	cert, err := s.verifier.Lookup(ctx, g.Company, g.CertNumber)
+	if err != nil {
+		return g, err
+	}
+	if cert.CardName != "" && !strings.EqualFold(strings.TrimSpace(cert.CardName), p.CardName) {
+		return g, fmt.Errorf("%w: cert %s is for %s", ErrMismatch, g.CertNumber, cert.CardName)
+	}
+	if cert.SetCode != "" && !strings.EqualFold(cert.SetCode, p.SetCode) {
+		return g, fmt.Errorf("%w: cert %s is for a card from %s", ErrMismatch, g.CertNumber, strings.ToUpper(cert.SetCode))
+	}
+	if cert.CollectorNumber != "" && strings.TrimLeft(cert.CollectorNumber, "#0") != strings.TrimLeft(p.CollectorNumber, "#0") {
+		return g, fmt.Errorf("%w: cert %s is for card number %s", ErrMismatch, g.CertNumber, cert.CollectorNumber)
+	}
+	if c, err := Normalize(models.Grading{Company: g.Company, Grade: cert.Grade, CertNumber: g.CertNumber}); err != nil || c.Grade != g.Grade {
+		return g, fmt.Errorf("%w: cert %s is graded %s %s", ErrMismatch, g.CertNumber, g.Company, cert.Grade)
+	}
+	if !cert.Subgrades.IsZero() && !g.Subgrades.IsZero() && cert.Subgrades != g.Subgrades {
+		return g, fmt.Errorf("%w: cert %s has different subgrades", ErrMismatch, g.CertNumber)
+	}
+	if g.Subgrades.IsZero() {
+		g.Subgrades = cert.Subgrades
+	}
+
+	g.VerifiedAt = s.now().UTC().Format(time.RFC3339)
+	return g, nil
+}
@@ -0,0 +1,165 @@
+package grading
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+var bolt = models.Printing{Game: "mtg", SetCode: "M10", CardName: "Lightning Bolt", CollectorNumber: "146"}
+
+func newService(v CertVerifier) *Service {
+	s := New(v)
+	s.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	return s
+}
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		name string
+		in   models.Grading
+		want error
+	}{
+		{"a PSA half grade", models.Grading{Company: " psa ", Grade: "8.5", CertNumber: "12345678"}, nil},
+		{"BGS with all subgrades", models.Grading{Company: "BGS", Grade: "9.5", CertNumber: "0012345678",
+			Subgrades: models.Subgrades{Centering: "9.5", Corners: "9.5", Edges: "10", Surface: "9"}}, nil},
+		{"an unknown company", models.Grading{Company: "SGC", Grade: "10", CertNumber: "12345678"}, ErrInvalid},
+		{"PSA gives no 9.5", models.Grading{Company: "PSA", Grade: "9.5", CertNumber: "12345678"}, ErrInvalid},
+		{"off the scale", models.Grading{Company: "CGC", Grade: "11", CertNumber: "12345678"}, ErrInvalid},
+		{"PSA prints no subgrades", models.Grading{Company: "PSA", Grade: "10", CertNumber: "12345678",
+			Subgrades: models.Subgrades{Centering: "10", Corners: "10", Edges: "10", Surface: "10"}}, ErrInvalid},
+		{"only some subgrades", models.Grading{Company: "BGS", Grade: "9", CertNumber: "12345678",
+			Subgrades: models.Subgrades{Centering: "9"}}, ErrInvalid},
+		{"a grade over every subgrade", models.Grading{Company: "BGS", Grade: "10", CertNumber: "12345678",
+			Subgrades: models.Subgrades{Centering: "9.5", Corners: "9.5", Edges: "9.5", Surface: "9.5"}}, ErrInvalid},
+		{"a short cert", models.Grading{Company: "PSA", Grade: "10", CertNumber: "12345"}, ErrInvalidCert},
+		{"letters in the cert", models.Grading{Company: "PSA", Grade: "10", CertNumber: "1234567A"}, ErrInvalidCert},
+	}
+	for _, c := range cases {
+		if _, err := Normalize(c.in); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	g, err := Normalize(models.Grading{Company: "psa", Grade: "10.0", CertNumber: "1234 5678"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Company != "PSA" || g.Grade != "10" || g.CertNumber != "12345678" {
+		t.Fatalf("normalized = %+v, want PSA 10 cert 12345678", g)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeVerifier()
+	fake.Add(Cert{Company: "PSA", CertNumber: "11111111", Grade: "10", CardName: "Lightning Bolt", SetCode: "m10", CollectorNumber: "#0146"})
+	fake.Add(Cert{Company: "PSA", CertNumber: "22222222", Grade: "10", CardName: "Shock"})
+	fake.Add(Cert{Company: "PSA", CertNumber: "33333333", Grade: "10", SetCode: "M11"})
+	fake.Add(Cert{Company: "PSA", CertNumber: "44444444", Grade: "10", CollectorNumber: "147"})
+	fake.Add(Cert{Company: "PSA", CertNumber: "55555555", Grade: "9"})
+	fake.Add(Cert{Company: "BGS", CertNumber: "66666666", Grade: "9.5",
+		Subgrades: models.Subgrades{Centering: "9.5", Corners: "9.5", Edges: "10", Surface: "9"}})
+	s := newService(fake)
+
+	cases := []struct {
+		name string
+		in   models.Grading
+		want error
+	}{
+		{"a cert for the printing", models.Grading{Company: "PSA", Grade: "10", CertNumber: "11111111"}, nil},
+		{"another card", models.Grading{Company: "PSA", Grade: "10", CertNumber: "22222222"}, ErrMismatch},
+		{"another set", models.Grading{Company: "PSA", Grade: "10", CertNumber: "33333333"}, ErrMismatch},
+		{"another collector number", models.Grading{Company: "PSA", Grade: "10", CertNumber: "44444444"}, ErrMismatch},
+		{"another grade", models.Grading{Company: "PSA", Grade: "10", CertNumber: "55555555"}, ErrMismatch},
+		{"other subgrades", models.Grading{Company: "BGS", Grade: "9.5", CertNumber: "66666666",
+			Subgrades: models.Subgrades{Centering: "9.5", Corners: "9.5", Edges: "9.5", Surface: "9.5"}}, ErrMismatch},
+		{"an unknown cert", models.Grading{Company: "PSA", Grade: "10", CertNumber: "99999999"}, ErrCertNotFound},
+		{"an invalid grading is never looked up", models.Grading{Company: "PSA", Grade: "9.5", CertNumber: "11111111"}, ErrInvalid},
+	}
+	for _, c := range cases {
+		g, err := s.Verify(ctx, c.in, bolt)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+		}
+		if verified := g.VerifiedAt != ""; verified != (c.want == nil) {
+			t.Errorf("%s: verified at %q", c.name, g.VerifiedAt)
+		}
+	}
+
+	// subgrades left blank are filled in from the cert
+	g, err := s.Verify(ctx, models.Grading{Company: "BGS", Grade: "9.5", CertNumber: "66666666"}, bolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Subgrades.Edges != "10" {
+		t.Fatalf("subgrades = %+v, want the cert's", g.Subgrades)
+	}
+}
+
+func TestVerifyUnavailable(t *testing.T) {
+	fake := NewFakeVerifier()
+	fake.Missing = ErrUnavailable
+	g, err := newService(fake).Verify(context.Background(), models.Grading{Company: "PSA", Grade: "10", CertNumber: "11111111", VerifiedAt: "2020-01-01T00:00:00Z"}, bolt)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+	// the slab can still be listed, but not as verified
+	if g.Grade != "10" || g.VerifiedAt != "" {
+		t.Fatalf("grading = %+v, want PSA 10 unverified", g)
+	}
+}
+
+func TestPSAVerifier(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/cert/GetByCertNumber/11111111":
+			w.Write([]byte(`{"PSACert":{"CertNumber":"11111111","CardNumber":"146","CardGrade":"GEM MT 10"},"IsValidRequest":true}`))
+		case "/cert/GetByCertNumber/22222222":
+			w.Write([]byte(`{"IsValidRequest":true,"ServerMessage":"No data found"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	v := NewPSAVerifier("token", srv.URL)
+
+	c, err := v.Lookup(ctx, models.GraderPSA, "11111111")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Grade != "10" || c.CollectorNumber != "146" {
+		t.Fatalf("cert = %+v, want grade 10 number 146", c)
+	}
+	if g, err := newService(v).Verify(ctx, models.Grading{Company: "PSA", Grade: "10", CertNumber: "11111111"}, bolt); err != nil || g.VerifiedAt == "" {
+		t.Fatalf("verifying = %+v, %v", g, err)
+	}
+
+	cases := []struct {
+		name    string
+		v       *PSAVerifier
+		company string
+		cert    string
+		want    error
+	}{
+		{"no cert", v, models.GraderPSA, "22222222", ErrCertNotFound},
+		{"a server error", v, models.GraderPSA, "33333333", ErrUnavailable},
+		{"a bad token", NewPSAVerifier("wrong", srv.URL), models.GraderPSA, "11111111", ErrUnavailable},
+		{"another company", v, models.GraderBGS, "11111111", ErrUnavailable},
+	}
+	for _, c := range cases {
+		if _, err := c.v.Lookup(ctx, c.company, c.cert); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package grading
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DefaultPSABaseURL is the PSA public API root
+const DefaultPSABaseURL = "https://api.psacard.com/publicapi"
+
+// PSAVerifier implements CertVerifier with PSA's public cert lookup. BGS and CGC offer no lookup
+// API, so their certs are reported unavailable and those slabs list unverified.
+type PSAVerifier struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+// NewPSAVerifier creates a PSA verifier. An empty baseURL uses DefaultPSABaseURL; overriding it
+// points the client at a mock server.
+func NewPSAVerifier(token, baseURL string) *PSAVerifier {
+	if baseURL == "" {
+		baseURL = DefaultPSABaseURL
+	}
+	return &PSAVerifier{
+		token:   token,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// psaCertResponse is the subset of a PSA cert lookup we read
+type psaCertResponse struct {
+	PSACert *struct {
+		CertNumber string `json:"CertNumber"`
+		CardNumber string `json:"CardNumber"`
+		CardGrade  string `json:"CardGrade"` // such as "GEM MT 10" or "NM-MT 8"
+	} `json:"PSACert"`
+	IsValidRequest bool   `json:"IsValidRequest"`
+	ServerMessage  string `json:"ServerMessage"`
+}
+
+// Lookup returns a PSA cert. PSA records the set by name and the card under its own subject line,
+// so only the collector number and grade are compared against the listing.
+func (v *PSAVerifier) Lookup(ctx context.Context, company, certNumber string) (Cert, error) {
+	if company != models.GraderPSA {
+		return Cert{}, ErrUnavailable
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.baseURL+"/cert/GetByCertNumber/"+url.PathEscape(certNumber), nil)
+	if err != nil {
+		return Cert{}, err
+	}
+	req.Header.Set("Authorization", "bearer "+v.token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return Cert{}, fmt.Errorf("%w: psa cert %s: %v", ErrUnavailable, certNumber, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Cert{}, fmt.Errorf("%w: psa cert %s: reading response: %v", ErrUnavailable, certNumber, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return Cert{}, ErrCertNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return Cert{}, fmt.Errorf("%w: psa cert %s: %d %s", ErrUnavailable, certNumber, resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+
+	var r psaCertResponse
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return Cert{}, fmt.Errorf("%w: psa cert %s: decoding response: %v", ErrUnavailable, certNumber, err)
+	}
+	if !r.IsValidRequest {
+		return Cert{}, fmt.Errorf("%w: psa cert %s: %s", ErrUnavailable, certNumber, r.ServerMessage)
+	}
+	if r.PSACert == nil || r.PSACert.CertNumber == "" {
+		return Cert{}, ErrCertNotFound
+	}
+
+	// The numeric grade ends the label, after the grade's name
+	fields := strings.Fields(r.PSACert.CardGrade)
+	grade := ""
+	if len(fields) > 0 {
+		grade = fields[len(fields)-1]
+	}
+	return Cert{
+		Company:         models.GraderPSA,
+		CertNumber:      r.PSACert.CertNumber,
+		Grade:           grade,
+		CollectorNumber: r.PSACert.CardNumber,
+	}, nil
+}
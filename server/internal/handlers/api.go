@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/apikeys"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/hooks"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// The seller API is for sellers' own systems. Its routes sit behind the APIKey middleware, which
+// puts the key a request was made with in its context, so every handler acts for that key's
+// seller. Responses use the same shapes as webhook payloads.
+
+// maxAPIBody caps the size of a seller API request body
+const maxAPIBody = 64 << 10
+
+// apiSeller returns the seller the request's API key belongs to
+func apiSeller(r *http.Request) string {
+	k, _ := apikeys.FromContext(r.Context())
+	return k.SellerID
+}
+
+// apiError writes a seller API error response
+func (m *Repository) apiError(w http.ResponseWriter, status int, message string) {
+	m.writeJSON(w, status, map[string]string{"error": message})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetAPIListings returns the seller's listings
+func (m *Repository) GetAPIListings(w http.ResponseWriter, r *http.Request) {
+	listings, err := m.App.Catalog.ListingsBySeller(r.Context(), apiSeller(r))
+	if err != nil {
+		m.App.ErrorLog.Printf("API listings failed: %v", err)
+		m.apiError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	out := make([]hooks.Listing, len(listings))
+	for i, l := range listings {
+		out[i] = hooks.PublicListing(l)
+	}
+	m.writeJSON(w, http.StatusOK, map[string]interface{}{"listings": out})
+}
+
+// GetAPIOrders returns the seller's orders, newest first, optionally only those in ?status=
+func (m *Repository) GetAPIOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := m.App.Orders.ForSeller(r.Context(), apiSeller(r))
+	if err != nil {
+		m.App.ErrorLog.Printf("API orders failed: %v", err)
+		m.apiError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	out := []hooks.Order{}
+	for _, o := range orders {
+		if status == "" || o.Status == status {
+			out = append(out, hooks.PublicOrder(o))
+		}
+	}
+	m.writeJSON(w, http.StatusOK, map[string]interface{}{"orders": out})
+}
+
+// //////////////////////////////////////////////////////////////
+// /////////////////// PATCH REQUESTS ///////////////////////////
+// //////////////////////////////////////////////////////////////
+
+// apiListingChange is the body of a listing update. Fields left out are unchanged; a version,
+// when given, must match the listing's so a stale update is refused.
+type apiListingChange struct {
+	PriceCents *int64 `json:"price_cents"`
+	Quantity   *int   `json:"quantity"`
+	Version    *int64 `json:"version"`
+}
+
+// PatchAPIListing changes a listing's price or quantity, for example to keep stock in step with a
+// store's point of sale
+func (m *Repository) PatchAPIListing(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var change apiListingChange
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&change); err != nil {
+		m.apiError(w, http.StatusBadRequest, "the body must be a JSON object with price_cents, quantity or version")
+		return
+	}
+	if change.PriceCents == nil && change.Quantity == nil {
+		m.apiError(w, http.StatusBadRequest, "send price_cents, quantity or both")
+		return
+	}
+
+	l, err := m.App.Catalog.Listing(ctx, chi.URLParam(r, "id"))
+	if errors.Is(err, catalog.ErrNotFound) || (err == nil && l.SellerID != apiSeller(r)) {
+		m.apiError(w, http.StatusNotFound, "no such listing")
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Printf("API listing lookup failed: %v", err)
+		m.apiError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	if l.Status != models.ListingStatusActive && l.Status != models.ListingStatusSoldOut {
+		m.apiError(w, http.StatusConflict, "the listing is "+l.Status+" and can't be changed")
+		return
+	}
+	if change.Version != nil && *change.Version != l.Version {
+		m.apiError(w, http.StatusConflict, "the listing has changed since that version")
+		return
+	}
+
+	if p := change.PriceCents; p != nil {
+		if *p <= 0 {
+			m.apiError(w, http.StatusUnprocessableEntity, "price_cents must be positive")
+			return
+		}
+		l.PriceCents = *p
+	}
+	if q := change.Quantity; q != nil {
+		if *q < 0 || (l.Grading != nil && *q > 1) {
+			m.apiError(w, http.StatusUnprocessableEntity, "quantity must be 0 or more, and at most 1 for a graded card")
+			return
+		}
+		l.Quantity = *q
+		if l.Quantity > 0 {
+			l.Status = models.ListingStatusActive // restocked
+		}
+	}
+
+	l, err = m.App.Catalog.SaveListing(ctx, l)
+	switch {
+	case errors.Is(err, catalog.ErrConflict):
+		m.apiError(w, http.StatusConflict, "the listing changed while updating it; fetch it and try again")
+		return
+	case errors.Is(err, catalog.ErrInvalid):
+		m.apiError(w, http.StatusUnprocessableEntity, "the change isn't allowed for this listing")
+		return
+	case err != nil:
+		m.App.ErrorLog.Printf("API listing update failed: %v", err)
+		m.apiError(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	m.App.InfoLog.Printf("listing %s updated through the API by %s", l.ListingID, l.SellerID)
+	m.writeJSON(w, http.StatusOK, hooks.PublicListing(l))
+}
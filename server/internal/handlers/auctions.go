@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/auctions"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/shipping"
+)
+
+// auctionDurations are the auction lengths offered to sellers, in days
+var auctionDurations = []int{1, 3, 5, 7, 10}
+
+// auctionRow pairs an auction with the listing and printing it sells, for display
+type auctionRow struct {
+	Auction  models.Auction
+	Listing  models.Listing
+	Printing models.Printing
+}
+
+// auctionRows joins auctions with their listings and printings
+func (m *Repository) auctionRows(r *http.Request, list []models.Auction) ([]auctionRow, error) {
+	rows := make([]auctionRow, 0, len(list))
+	for _, a := range list {
+		row := auctionRow{Auction: a}
+		l, err := m.App.Catalog.Listing(r.Context(), a.ListingID)
+		if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+			return nil, err
+		}
+		row.Listing = l
+		if l.PrintingID != "" {
+			p, err := m.App.Catalog.Printing(r.Context(), l.PrintingID)
+			if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+				return nil, err
+			}
+			row.Printing = p
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// auctionErrorMessage turns an auction error into a message suitable for a toast
+func auctionErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, auctions.ErrInvalid), errors.Is(err, auctions.ErrBidTooLow), errors.Is(err, auctions.ErrNotAllowed):
+		_, msg, _ := strings.Cut(err.Error(), ": ")
+		if _, detail, ok := strings.Cut(msg, ": "); ok {
+			msg = detail
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, auctions.ErrClosed):
+		return "This auction has ended."
+	case errors.Is(err, auctions.ErrUnavailable):
+		return "That listing has no copies left to auction."
+	case errors.Is(err, auctions.ErrConflict):
+		return "Bidding is very busy right now. Please try again."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// renderAuction renders an auction with its bid history and, for signed-in buyers, the bid form
+func (m *Repository) renderAuction(w http.ResponseWriter, r *http.Request, form *forms.Form, a models.Auction) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	rows, err := m.auctionRows(r, []models.Auction{a})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	bids, err := m.App.Auctions.Bids(ctx, a.AuctionID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Row":    rows[0],
+		"Bids":   bids,
+		"MinBid": auctions.MinBid(a),
+		"CanBid": userID != "" && userID != a.SellerID && a.Status == models.AuctionStatusOpen,
+	}
+	if userID != "" {
+		p, err := m.App.Auctions.Proxy(ctx, a.AuctionID, userID)
+		if err == nil {
+			data["Proxy"] = p
+		} else if !errors.Is(err, auctions.ErrNotFound) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	country := form.Get("country")
+	if _, ok := shipping.Countries[country]; !ok {
+		country = shipping.DefaultCountry
+	}
+	profile, err := m.App.Shipping.Profile(ctx, a.SellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["Methods"] = profile.Methods
+	data["Countries"] = shipping.Countries
+
+	render.Template(w, r, "auction.page.tmpl", &models.TemplateData{
+		Form: form,
+		StringMap: map[string]string{
+			"country": country,
+			"user_id": userID,
+		},
+		Data: data,
+	})
+}
+
+// sellerListingFor loads the listing in the URL if it belongs to the signed-in seller, writing a 404 otherwise
+func (m *Repository) sellerListingFor(w http.ResponseWriter, r *http.Request) (models.Listing, models.Printing, bool) {
+	ctx := r.Context()
+
+	l, err := m.App.Catalog.Listing(ctx, chi.URLParam(r, "id"))
+	if err != nil || l.SellerID != m.App.Session.GetString(ctx, "user_id") || l.IsSealed() {
+		helpers.ClientError(w, http.StatusNotFound)
+		return l, models.Printing{}, false
+	}
+	p, err := m.App.Catalog.Printing(ctx, l.PrintingID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return l, p, false
+	}
+	return l, p, true
+}
+
+// renderAuctionNew renders the form a seller starts an auction from
+func (m *Repository) renderAuctionNew(w http.ResponseWriter, r *http.Request, form *forms.Form, l models.Listing, p models.Printing) {
+	render.Template(w, r, "seller-auction-new.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: map[string]interface{}{
+			"Listing":   l,
+			"Printing":  p,
+			"Durations": auctionDurations,
+		},
+	})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetAuctions lists the running auctions, ending soonest first
+func (m *Repository) GetAuctions(w http.ResponseWriter, r *http.Request) {
+	list, err := m.App.Auctions.Open(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rows, err := m.auctionRows(r, list)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "auctions.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Auctions": rows,
+		},
+	})
+}
+
+// GetAuction is the public auction page
+func (m *Repository) GetAuction(w http.ResponseWriter, r *http.Request) {
+	a, err := m.App.Auctions.Get(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, auctions.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.renderAuction(w, r, forms.New(nil), a)
+}
+
+// GetBuyerBids lists the auctions the signed-in buyer has bid on
+func (m *Repository) GetBuyerBids(w http.ResponseWriter, r *http.Request) {
+	list, err := m.App.Auctions.ForBidder(r.Context(), m.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rows, err := m.auctionRows(r, list)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "bids.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"user_id": m.App.Session.GetString(r.Context(), "user_id")},
+		Data: map[string]interface{}{
+			"Auctions": rows,
+		},
+	})
+}
+
+// GetSellerAuctions lists the signed-in seller's auctions
+func (m *Repository) GetSellerAuctions(w http.ResponseWriter, r *http.Request) {
+	list, err := m.App.Auctions.ForSeller(r.Context(), m.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rows, err := m.auctionRows(r, list)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "seller-auctions.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Auctions": rows,
+		},
+	})
+}
+
+// GetSellerAuctionNew is the form for putting a copy of a listing up for auction
+func (m *Repository) GetSellerAuctionNew(w http.ResponseWriter, r *http.Request) {
+	l, p, ok := m.sellerListingFor(w, r)
+	if !ok {
+		return
+	}
+	m.renderAuctionNew(w, r, forms.New(nil), l, p)
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostSellerAuction starts an auction for one copy of a listing
+func (m *Repository) PostSellerAuction(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	l, p, ok := m.sellerListingFor(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("auction form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "duration")
+
+	start, err := money.Parse(form.Get("start"))
+	if form.Has("start") && (err != nil || start <= 0) {
+		form.Errors.Add("start", "Enter a start price such as 4.99")
+	}
+	var reserve, increment int64
+	if form.Has("reserve") {
+		if reserve, err = money.Parse(form.Get("reserve")); err != nil || reserve < start {
+			form.Errors.Add("reserve", "Enter a reserve at or above the start price, or leave it blank")
+		}
+	}
+	if form.Has("increment") {
+		if increment, err = money.Parse(form.Get("increment")); err != nil || increment <= 0 {
+			form.Errors.Add("increment", "Enter an increment such as 0.50, or leave it blank")
+		}
+	}
+	days := form.GetInt("duration")
+	known := false
+	for _, d := range auctionDurations {
+		known = known || d == days
+	}
+	if form.Has("duration") && !known {
+		form.Errors.Add("duration", "Choose how long the auction runs")
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderAuctionNew(w, r, form, l, p)
+		return
+	}
+
+	a, err := m.App.Auctions.Start(ctx, l.SellerID, l.ListingID, auctions.Terms{
+		StartCents:     start,
+		ReserveCents:   reserve,
+		IncrementCents: increment,
+		Duration:       time.Duration(days) * 24 * time.Hour,
+	})
+	if err != nil {
+		m.App.InfoLog.Printf("auction for listing %s rejected: %v", l.ListingID, err)
+		m.App.Session.Put(ctx, "error", auctionErrorMessage(err))
+		http.Redirect(w, r, "/seller/listings", http.StatusSeeOther)
+		return
+	}
+
+	m.App.InfoLog.Printf("auction %s started by %s for listing %s", a.AuctionID, a.SellerID, a.ListingID)
+	m.App.Session.Put(ctx, "flash", "Your auction is live.")
+	http.Redirect(w, r, "/auctions/"+a.AuctionID, http.StatusSeeOther)
+}
+
+// PostSellerAuctionCancel ends an auction nobody has bid on
+func (m *Repository) PostSellerAuctionCancel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	_, err := m.App.Auctions.Cancel(ctx, chi.URLParam(r, "id"), m.App.Session.GetString(ctx, "user_id"))
+	if errors.Is(err, auctions.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		m.App.InfoLog.Printf("cancelling auction %s rejected: %v", chi.URLParam(r, "id"), err)
+		m.App.Session.Put(ctx, "error", auctionErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Auction cancelled. The card is back on sale.")
+	}
+	http.Redirect(w, r, "/seller/auctions", http.StatusSeeOther)
+}
+
+// PostAuctionBid places or raises the signed-in buyer's maximum bid. A buyer's first bid on an
+// auction also says where the card ships if they win.
+func (m *Repository) PostAuctionBid(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	a, err := m.App.Auctions.Get(ctx, chi.URLParam(r, "id"))
+	if errors.Is(err, auctions.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("bid form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("max")
+	maxCents, err := money.Parse(form.Get("max"))
+	if form.Has("max") && (err != nil || maxCents <= 0) {
+		form.Errors.Add("max", "Enter your maximum bid such as 25.00")
+	}
+
+	bid := auctions.Bid{BidderID: userID, MaxCents: maxCents}
+	_, err = m.App.Auctions.Proxy(ctx, a.AuctionID, userID)
+	if errors.Is(err, auctions.ErrNotFound) || form.Has("line1") {
+		form.Required("name", "line1", "city", "postal_code", "country", "shipping_method")
+		if _, known := shipping.Countries[form.Get("country")]; form.Has("country") && !known {
+			form.Errors.Add("country", "We don't ship to that country yet")
+		}
+		bid.ShipTo = models.Address{
+			Name:       strings.TrimSpace(form.Get("name")),
+			Line1:      strings.TrimSpace(form.Get("line1")),
+			Line2:      strings.TrimSpace(form.Get("line2")),
+			City:       strings.TrimSpace(form.Get("city")),
+			Region:     strings.TrimSpace(form.Get("region")),
+			PostalCode: strings.TrimSpace(form.Get("postal_code")),
+			Country:    form.Get("country"),
+		}
+		bid.ShippingMethod = form.Get("shipping_method")
+
+		if form.Valid() {
+			rates, err := m.App.Shipping.Rates(ctx, a.SellerID, 1, maxCents, bid.ShipTo.Country)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			offered := false
+			for _, rate := range rates {
+				offered = offered || rate.Method.MethodID == bid.ShippingMethod
+			}
+			if !offered {
+				form.Errors.Add("shipping_method", "The seller doesn't ship that way to your country")
+			}
+		}
+	} else if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderAuction(w, r, form, a)
+		return
+	}
+
+	a, err = m.App.Auctions.PlaceBid(ctx, a.AuctionID, bid)
+	redirect := "/auctions/" + chi.URLParam(r, "id")
+	switch {
+	case err != nil:
+		m.App.InfoLog.Printf("bid on auction %s by %s rejected: %v", chi.URLParam(r, "id"), userID, err)
+		m.App.Session.Put(ctx, "error", auctionErrorMessage(err))
+	case a.LeaderID == userID:
+		m.App.Session.Put(ctx, "flash", "You're the high bidder at "+money.Format(a.CurrentCents)+".")
+	default:
+		m.App.Session.Put(ctx, "warning", "You've been outbid. Another bidder's maximum is at least "+money.Format(a.CurrentCents)+".")
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
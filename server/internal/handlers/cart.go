@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/offers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// loadCart returns the signed-in user's persisted cart, or the anonymous cart held in the session
+func (m *Repository) loadCart(ctx context.Context) (models.Cart, error) {
+	if userID := m.App.Session.GetString(ctx, "user_id"); userID != "" {
+		return m.App.Carts.Load(ctx, userID)
+	}
+	c, _ := m.App.Session.Get(ctx, "cart").(models.Cart)
+	return c, nil
+}
+
+// saveCart writes the cart back to wherever loadCart found it and refreshes the header count
+func (m *Repository) saveCart(ctx context.Context, c models.Cart) error {
+	if c.UserID != "" {
+		if err := m.App.Carts.Save(ctx, c); err != nil {
+			return err
+		}
+	} else {
+		m.App.Session.Put(ctx, "cart", c)
+	}
+	m.App.Session.Put(ctx, "cart_count", c.ItemCount())
+	return nil
+}
+
+// mergeSessionCart folds the anonymous session cart into the user's persisted cart after login
+func (m *Repository) mergeSessionCart(ctx context.Context, userID string) error {
+	persisted, err := m.App.Carts.Load(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if anon, ok := m.App.Session.Pop(ctx, "cart").(models.Cart); ok && len(anon.Items) > 0 {
+		m.App.Carts.Merge(ctx, &persisted, anon)
+		if err := m.App.Carts.Save(ctx, persisted); err != nil {
+			return err
+		}
+	}
+
+	m.App.Session.Put(ctx, "cart_count", persisted.ItemCount())
+	return nil
+}
+
+// cartErrorMessage turns a cart error into a message suitable for a toast
+func cartErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, cart.ErrInvalidQuantity),
+		errors.Is(err, cart.ErrUnavailable),
+		errors.Is(err, cart.ErrInsufficientStock),
+		errors.Is(err, cart.ErrOwnListing),
+		errors.Is(err, cart.ErrNotInCart):
+		return "Could not update cart: " + strings.TrimPrefix(err.Error(), "cart: ")
+	default:
+		return "Could not update cart. Please try again."
+	}
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetCart is the cart page handler; lines are grouped by seller with per-seller subtotals
+func (m *Repository) GetCart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	c, err := m.loadCart(ctx)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	view, err := m.App.Carts.Build(ctx, c)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "cart.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Cart": view,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostCartAdd adds a listing to the cart
+func (m *Repository) PostCartAdd(w http.ResponseWriter, r *http.Request) {
+	m.changeCart(w, r, true, func(ctx context.Context, c *models.Cart, form *forms.Form) error {
+		qty := 1
+		if form.Has("quantity") {
+			qty = form.GetInt("quantity")
+		}
+		return m.App.Carts.Add(ctx, c, form.Get("listing_id"), qty)
+	}, "Added to cart.")
+}
+
+// PostCartUpdate changes the quantity of a cart line
+func (m *Repository) PostCartUpdate(w http.ResponseWriter, r *http.Request) {
+	m.changeCart(w, r, true, func(ctx context.Context, c *models.Cart, form *forms.Form) error {
+		form.Required("quantity")
+		form.IsNumber("quantity")
+		if !form.Valid() {
+			return cart.ErrInvalidQuantity
+		}
+		return m.App.Carts.Update(ctx, c, form.Get("listing_id"), form.GetInt("quantity"))
+	}, "Cart updated.")
+}
+
+// PostCartRemove removes a line from the cart. Removing an accepted offer's line gives its held
+// copies back to the seller.
+func (m *Repository) PostCartRemove(w http.ResponseWriter, r *http.Request) {
+	m.changeCart(w, r, true, func(ctx context.Context, c *models.Cart, form *forms.Form) error {
+		if offerID := form.Get("offer_id"); offerID != "" {
+			if err := m.App.Carts.RemoveOffer(c, offerID); err != nil {
+				return err
+			}
+			_, err := m.App.Offers.Release(ctx, offerID, orders.Buyer(c.UserID))
+			if err != nil && !errors.Is(err, offers.ErrClosed) {
+				m.App.ErrorLog.Printf("releasing offer %s failed: %v", offerID, err)
+			}
+			return nil
+		}
+		return m.App.Carts.Remove(c, form.Get("listing_id"))
+	}, "Removed from cart.")
+}
+
+// PostCartRefresh accepts current prices and stock for every line in the cart
+func (m *Repository) PostCartRefresh(w http.ResponseWriter, r *http.Request) {
+	m.changeCart(w, r, false, func(ctx context.Context, c *models.Cart, form *forms.Form) error {
+		return m.App.Carts.Reprice(ctx, c)
+	}, "Cart updated to current prices.")
+}
+
+// changeCart loads the cart, applies fn, saves it and redirects back to the cart page
+func (m *Repository) changeCart(w http.ResponseWriter, r *http.Request, needsListing bool, fn func(context.Context, *models.Cart, *forms.Form) error, success string) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("cart form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	if needsListing && !form.Has("listing_id") {
+		m.App.Session.Put(ctx, "error", "Missing listing.")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	c, err := m.loadCart(ctx)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if err := fn(ctx, &c, form); err != nil {
+		m.App.InfoLog.Printf("cart change rejected: %v", err)
+		m.App.Session.Put(ctx, "error", cartErrorMessage(err))
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	if err := m.saveCart(ctx, c); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(ctx, "flash", success)
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// maxCatalogCSVBytes caps the size of a catalog CSV import
+const maxCatalogCSVBytes = 8 << 20
+
+// renderCatalogImport renders the admin catalog import form
+func (m *Repository) renderCatalogImport(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	render.Template(w, r, "admin-catalog-import.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: map[string]interface{}{
+			"ProductTypes": models.ProductTypes,
+		},
+	})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetAdminCatalogImport is the form for importing printings and sealed products
+func (m *Repository) GetAdminCatalogImport(w http.ResponseWriter, r *http.Request) {
+	m.renderCatalogImport(w, r, forms.New(nil))
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostAdminCatalogImport imports the printings and sealed products in an uploaded or pasted CSV file
+func (m *Repository) PostAdminCatalogImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCatalogCSVBytes+1<<10)
+	if err := r.ParseMultipartForm(maxCatalogCSVBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		helpers.ClientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+	form := forms.New(r.PostForm)
+
+	text := csvUpload(r, form, maxCatalogCSVBytes)
+	if strings.TrimSpace(text) == "" && form.Errors.Get("file") == "" {
+		form.Errors.Add("csv", "Paste the CSV or choose a file")
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderCatalogImport(w, r, form)
+		return
+	}
+
+	res, problems, err := m.App.Catalog.Import(ctx, strings.NewReader(text))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			form.Errors.Add("csv", p.Error())
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderCatalogImport(w, r, form)
+		return
+	}
+
+	m.App.InfoLog.Printf("catalog import by %s: %+v", m.App.Session.GetString(ctx, "user_id"), res)
+	m.App.Session.Put(ctx, "flash", fmt.Sprintf(
+		"Imported %d new and %d updated cards, and %d new and %d updated sealed products.",
+		res.PrintingsCreated, res.PrintingsUpdated, res.ProductsCreated, res.ProductsUpdated,
+	))
+	http.Redirect(w, r, "/admin/catalog/import", http.StatusSeeOther)
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/collection"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/payments"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// maxCollectionCSVBytes caps the size of a collection CSV import
+const maxCollectionCSVBytes = 1 << 20
+
+// portfolioChart is a collection's value history laid out for the chart on the collection page
+type portfolioChart struct {
+	Days  []string
+	Value []int64
+	Cost  []int64
+}
+
+// collectionErrorMessage turns a collection error into a message suitable for a toast
+func collectionErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, collection.ErrInvalid):
+		_, msg, _ := strings.Cut(err.Error(), ": invalid item: ")
+		if msg == "" {
+			return "Please check the card and try again."
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, collection.ErrTooMany):
+		return "Your collection is full. Remove something first."
+	case errors.Is(err, collection.ErrNotFound):
+		return "That card isn't in your collection."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// renderCollectionForm renders the add-to-collection form for a printing, or a printing search when none is chosen
+func (m *Repository) renderCollectionForm(w http.ResponseWriter, r *http.Request, form *forms.Form, printingID, q string) {
+	ctx := r.Context()
+	data := map[string]interface{}{
+		"Conditions": models.Conditions,
+		"Today":      time.Now().UTC().Format("2006-01-02"),
+	}
+
+	if printingID != "" {
+		p, err := m.App.Catalog.Printing(ctx, printingID)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		data["Printing"] = p
+	} else if q = strings.TrimSpace(q); q != "" {
+		matches, err := m.findPrintings(ctx, q)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["Matches"] = matches
+	}
+
+	render.Template(w, r, "collection-new.page.tmpl", &models.TemplateData{
+		Form:      form,
+		StringMap: map[string]string{"q": q},
+		Data:      data,
+	})
+}
+
+// csvUpload returns the CSV text of an import form: the uploaded file when there is one, otherwise
+// what was pasted into the csv field. Problems reading the file are added to the form.
+func csvUpload(r *http.Request, form *forms.Form, maxBytes int) string {
+	text := form.Get("csv")
+	if r.MultipartForm == nil {
+		return text
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		return text
+	}
+	f, err := files[0].Open()
+	if err != nil {
+		form.Errors.Add("file", "We couldn't read "+files[0].Filename)
+		return text
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, int64(maxBytes)+1))
+	switch {
+	case err != nil:
+		form.Errors.Add("file", "We couldn't read "+files[0].Filename)
+	case len(data) > maxBytes:
+		form.Errors.Add("file", files[0].Filename+" is too large to import")
+	default:
+		text = string(data)
+		form.Set("csv", text)
+	}
+	return text
+}
+
+// renderCollectionImport renders the CSV import form
+func (m *Repository) renderCollectionImport(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	render.Template(w, r, "collection-import.page.tmpl", &models.TemplateData{Form: form})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetCollection is the user's collection page with its value and gain or loss over time
+func (m *Repository) GetCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	portfolio, err := m.App.Collection.Value(ctx, userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+	if days <= 0 {
+		days = 90
+	}
+	history, err := m.App.Collection.History(ctx, userID, days)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	chart := portfolioChart{Days: []string{}, Value: []int64{}, Cost: []int64{}}
+	for _, snap := range history {
+		chart.Days = append(chart.Days, snap.Day)
+		chart.Value = append(chart.Value, snap.ValueCents)
+		chart.Cost = append(chart.Cost, snap.CostCents)
+	}
+
+	render.Template(w, r, "collection.page.tmpl", &models.TemplateData{
+		IntMap: map[string]int{"days": days},
+		Data: map[string]interface{}{
+			"Portfolio": portfolio,
+			"Chart":     chart,
+		},
+	})
+}
+
+// GetCollectionNew is the form for adding a printing to the collection
+func (m *Repository) GetCollectionNew(w http.ResponseWriter, r *http.Request) {
+	m.renderCollectionForm(w, r, forms.New(nil), r.URL.Query().Get("printing_id"), r.URL.Query().Get("q"))
+}
+
+// GetCollectionImport is the CSV import form
+func (m *Repository) GetCollectionImport(w http.ResponseWriter, r *http.Request) {
+	m.renderCollectionImport(w, r, forms.New(nil))
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostCollection adds a printing to the collection
+func (m *Repository) PostCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("collection form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("printing_id", "condition", "quantity")
+
+	qty, err := strconv.Atoi(form.Get("quantity"))
+	if form.Has("quantity") && (err != nil || qty <= 0) {
+		form.Errors.Add("quantity", "Enter how many copies you have")
+	}
+	var cost int64
+	if form.Has("cost") {
+		cost, err = money.Parse(form.Get("cost"))
+		if err != nil || cost < 0 {
+			form.Errors.Add("cost", "Enter a price such as 4.99, or leave it empty")
+		}
+	}
+	condition := form.Get("condition")
+	if form.Has("condition") && !isCondition(condition) {
+		form.Errors.Add("condition", "Choose a condition")
+	}
+	acquired := form.Get("acquired_on")
+	if _, err := time.Parse("2006-01-02", acquired); acquired != "" && err != nil {
+		form.Errors.Add("acquired_on", "Enter a date such as 2024-03-31")
+	}
+	printingID := form.Get("printing_id")
+	if _, err := m.App.Catalog.Printing(ctx, printingID); form.Has("printing_id") && err != nil {
+		form.Errors.Add("printing_id", "Choose a card")
+		printingID = ""
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderCollectionForm(w, r, form, printingID, "")
+		return
+	}
+
+	it, err := m.App.Collection.Add(ctx, models.CollectionItem{
+		UserID:     m.App.Session.GetString(ctx, "user_id"),
+		PrintingID: printingID,
+		Condition:  condition,
+		Language:   form.Get("language"),
+		Foil:       form.Has("foil"),
+		Quantity:   qty,
+		CostCents:  cost,
+		AcquiredOn: acquired,
+	})
+	if errors.Is(err, collection.ErrInvalid) || errors.Is(err, collection.ErrTooMany) {
+		m.App.Session.Put(ctx, "error", collectionErrorMessage(err))
+		http.Redirect(w, r, "/collection/new?printing_id="+printingID, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.InfoLog.Printf("collection item %s added by %s", it.ItemID, it.UserID)
+	m.App.Session.Put(ctx, "flash", "Added to your collection.")
+	http.Redirect(w, r, "/collection", http.StatusSeeOther)
+}
+
+// PostCollectionRemove removes an item from the collection
+func (m *Repository) PostCollectionRemove(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	err := m.App.Collection.Remove(ctx, m.App.Session.GetString(ctx, "user_id"), chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, collection.ErrNotFound):
+		m.App.Session.Put(ctx, "error", collectionErrorMessage(err))
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.Session.Put(ctx, "flash", "Removed from your collection.")
+	}
+	http.Redirect(w, r, "/collection", http.StatusSeeOther)
+}
+
+// PostCollectionImport adds the cards in an uploaded or pasted CSV file to the collection
+func (m *Repository) PostCollectionImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCollectionCSVBytes+1<<10)
+	if err := r.ParseMultipartForm(maxCollectionCSVBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		helpers.ClientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+	form := forms.New(r.PostForm)
+
+	text := csvUpload(r, form, maxCollectionCSVBytes)
+	if strings.TrimSpace(text) == "" && form.Errors.Get("file") == "" {
+		form.Errors.Add("csv", "Paste your CSV or choose a file")
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderCollectionImport(w, r, form)
+		return
+	}
+
+	items, problems, err := m.App.Collection.Import(ctx, m.App.Session.GetString(ctx, "user_id"), strings.NewReader(text))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			form.Errors.Add("csv", p.Error())
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderCollectionImport(w, r, form)
+		return
+	}
+
+	copies := 0
+	for _, it := range items {
+		copies += it.Quantity
+	}
+	m.App.Session.Put(ctx, "flash", fmt.Sprintf("Imported %d cards into your collection.", copies))
+	http.Redirect(w, r, "/collection", http.StatusSeeOther)
+}
+
+// PostCollectionList puts the chosen collection items up for sale at their market price
+func (m *Repository) PostCollectionList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("collection list form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+	itemIDs := r.PostForm["item_id"]
+	if len(itemIDs) == 0 {
+		m.App.Session.Put(ctx, "warning", "Choose the cards you want to sell.")
+		http.Redirect(w, r, "/collection", http.StatusSeeOther)
+		return
+	}
+
+	userID := m.App.Session.GetString(ctx, "user_id")
+	listed, skipped, err := m.App.Collection.ListForSale(ctx, userID, itemIDs)
+	if errors.Is(err, payments.ErrNoPayoutAccount) {
+		m.App.Session.Put(ctx, "error", "Connect your payout account before listing.")
+		http.Redirect(w, r, "/seller/payouts", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if len(skipped) > 0 {
+		reasons := make([]string, 0, len(skipped))
+		for _, s := range skipped {
+			reasons = append(reasons, s.CardName+" ("+s.Reason+")")
+		}
+		m.App.Session.Put(ctx, "warning", "Not listed: "+strings.Join(reasons, ", ")+".")
+	}
+	if len(listed) == 0 {
+		http.Redirect(w, r, "/collection", http.StatusSeeOther)
+		return
+	}
+
+	m.App.InfoLog.Printf("%d collection items listed by %s", len(listed), userID)
+	m.App.Session.Put(ctx, "flash", fmt.Sprintf("Listed %d cards at their market price.", len(listed)))
+	http.Redirect(w, r, "/seller/listings", http.StatusSeeOther)
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/decklists"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/wants"
+)
+
+// maxDecklistBytes caps the size of a pasted or uploaded decklist
+const maxDecklistBytes = 64 << 10
+
+// renderDecklistImport renders the decklist import form
+func (m *Repository) renderDecklistImport(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	render.Template(w, r, "decklist-import.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: map[string]interface{}{"Formats": decklists.Formats},
+	})
+}
+
+// decklistEntries reads the reviewed decklist's parallel printing_id and quantity fields,
+// checking that every printing exists. Errors are suitable for a toast.
+func (m *Repository) decklistEntries(r *http.Request) ([]decklists.Entry, error) {
+	printingIDs, quantities := r.PostForm["printing_id"], r.PostForm["quantity"]
+	if len(printingIDs) == 0 || len(printingIDs) != len(quantities) {
+		return nil, errors.New("The decklist is empty.")
+	}
+
+	index := map[string]int{}
+	var entries []decklists.Entry
+	for i, id := range printingIDs {
+		qty, err := strconv.Atoi(quantities[i])
+		if err != nil || qty < 1 {
+			return nil, errors.New("Every card needs a quantity of at least 1.")
+		}
+		if _, err := m.App.Catalog.Printing(r.Context(), id); err != nil {
+			return nil, errors.New("A card on the list is no longer in the catalog.")
+		}
+		if at, ok := index[id]; ok {
+			entries[at].Quantity += qty
+			continue
+		}
+		index[id] = len(entries)
+		entries = append(entries, decklists.Entry{PrintingID: id, Quantity: qty})
+	}
+	return entries, nil
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetDecklistImport is the form for pasting or uploading a decklist
+func (m *Repository) GetDecklistImport(w http.ResponseWriter, r *http.Request) {
+	m.renderDecklistImport(w, r, forms.New(nil))
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostDecklistImport parses a decklist and resolves its cards against the catalog. Lines that
+// can't be read or matched are shown on the form; otherwise the buyer reviews the matched cards,
+// choosing a printing wherever a line matches more than one.
+func (m *Repository) PostDecklistImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDecklistBytes+1<<10)
+	if err := r.ParseMultipartForm(maxDecklistBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		helpers.ClientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+	form := forms.New(r.PostForm)
+
+	text := form.Get("decklist")
+	if r.MultipartForm != nil {
+		if files := r.MultipartForm.File["file"]; len(files) > 0 {
+			f, err := files[0].Open()
+			if err != nil {
+				form.Errors.Add("file", "We couldn't read "+files[0].Filename)
+			} else {
+				data, err := io.ReadAll(io.LimitReader(f, maxDecklistBytes+1))
+				f.Close()
+				switch {
+				case err != nil:
+					form.Errors.Add("file", "We couldn't read "+files[0].Filename)
+				case len(data) > maxDecklistBytes:
+					form.Errors.Add("file", files[0].Filename+" is too large for a decklist")
+				default:
+					text = string(data)
+					form.Set("decklist", text)
+				}
+			}
+		}
+	}
+	if strings.TrimSpace(text) == "" && form.Errors.Get("file") == "" {
+		form.Errors.Add("decklist", "Paste a decklist or choose a file")
+	}
+
+	format := form.Get("format")
+	known := format == ""
+	for _, f := range decklists.Formats {
+		known = known || format == f
+	}
+	if !known {
+		form.Errors.Add("format", "Choose a format")
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderDecklistImport(w, r, form)
+		return
+	}
+
+	deck, lineErrs := decklists.Parse(text, format)
+	cards, resolveErrs := decklists.Resolve(r.Context(), m.App.Catalog, deck)
+	for _, e := range append(lineErrs, resolveErrs...) {
+		form.Errors.Add("decklist", e.Error())
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderDecklistImport(w, r, form)
+		return
+	}
+
+	ambiguous := 0
+	for _, c := range cards {
+		if c.Ambiguous() {
+			ambiguous++
+		}
+	}
+	m.App.InfoLog.Printf("decklist imported as %s: %d lines, %d ambiguous", deck.Format, len(cards), ambiguous)
+
+	render.Template(w, r, "decklist-review.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"format": deck.Format},
+		IntMap:    map[string]int{"ambiguous": ambiguous},
+		Data:      map[string]interface{}{"Cards": cards},
+	})
+}
+
+// PostDecklistShop keeps the reviewed decklist in the session and sends the buyer to the
+// optimizer to find the cheapest way to buy it
+func (m *Repository) PostDecklistShop(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("decklist form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+	entries, err := m.decklistEntries(r)
+	if err != nil {
+		m.App.Session.Put(ctx, "error", err.Error())
+		http.Redirect(w, r, "/decklists/import", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(ctx, "decklist", entries)
+	http.Redirect(w, r, "/optimize?source="+optimizeFromDeck, http.StatusSeeOther)
+}
+
+// PostDecklistWants adds every card of the reviewed decklist to the want list
+func (m *Repository) PostDecklistWants(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("decklist form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+	entries, err := m.decklistEntries(r)
+	if err != nil {
+		m.App.Session.Put(ctx, "error", err.Error())
+		http.Redirect(w, r, "/decklists/import", http.StatusSeeOther)
+		return
+	}
+
+	added := 0
+	for _, e := range entries {
+		_, err := m.App.Wants.Add(ctx, models.Want{
+			UserID:     userID,
+			PrintingID: e.PrintingID,
+			Quantity:   e.Quantity,
+			Foil:       models.FoilAny,
+		})
+		if errors.Is(err, wants.ErrInvalid) || errors.Is(err, wants.ErrTooMany) {
+			m.App.Session.Put(ctx, "error", fmt.Sprintf("Added %d of %d cards. %s", added, len(entries), wantErrorMessage(err)))
+			http.Redirect(w, r, "/wants", http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		added++
+	}
+
+	m.App.InfoLog.Printf("decklist of %d cards added to %s's want list", added, userID)
+	m.App.Session.Put(ctx, "flash", fmt.Sprintf("Added %d cards to your want list.", added))
+	http.Redirect(w, r, "/wants", http.StatusSeeOther)
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/disputes"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/photos"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// maxEvidencePhotos caps how many photos can be attached to one dispute message
+const maxEvidencePhotos = 5
+
+// maxUploadBytes caps the size of a multipart form with photos
+const maxUploadBytes = maxEvidencePhotos*photos.MaxBytes + 1<<20
+
+// disputeFor loads the dispute in the URL and works out who the signed-in user is in it.
+// It writes a 404 and returns false when the dispute is missing or the user has no part in it.
+func (m *Repository) disputeFor(w http.ResponseWriter, r *http.Request) (models.Dispute, orders.Actor, bool) {
+	userID := m.App.Session.GetString(r.Context(), "user_id")
+
+	d, err := m.App.Disputes.Get(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, disputes.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return d, orders.Actor{}, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return d, orders.Actor{}, false
+	}
+
+	switch {
+	case userID == d.BuyerID:
+		return d, orders.Buyer(userID), true
+	case userID == d.SellerID:
+		return d, orders.Seller(userID), true
+	case helpers.IsAdmin(r):
+		return d, orders.Admin(userID), true
+	}
+	helpers.ClientError(w, http.StatusNotFound)
+	return d, orders.Actor{}, false
+}
+
+// disputeErrorMessage turns a dispute error into a message suitable for a toast
+func disputeErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, disputes.ErrWindowClosed):
+		return "This order can no longer be disputed."
+	case errors.Is(err, disputes.ErrAlreadyOpen):
+		return "This order already has a dispute in progress."
+	case errors.Is(err, disputes.ErrInvalid):
+		_, msg, _ := strings.Cut(err.Error(), ": invalid request: ")
+		if msg == "" {
+			return "Please check the form and try again."
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, disputes.ErrInvalidTransition), errors.Is(err, disputes.ErrNotAllowed):
+		return "That action isn't available for this dispute."
+	case errors.Is(err, disputes.ErrConflict):
+		return "The dispute was just updated. Please review it and try again."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// parseUpload parses a form that may carry photo uploads
+func parseUpload(w http.ResponseWriter, r *http.Request) (*forms.Form, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if err := r.ParseMultipartForm(maxUploadBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		helpers.ClientError(w, http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return forms.New(r.PostForm), true
+}
+
+// saveEvidence stores the photos uploaded in the "photos" field under prefix and returns their
+// URLs. Problems are added to the form's "photos" errors.
+func (m *Repository) saveEvidence(r *http.Request, form *forms.Form, prefix string) []string {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	files := r.MultipartForm.File["photos"]
+	if len(files) > maxEvidencePhotos {
+		form.Errors.Add("photos", fmt.Sprintf("Attach at most %d photos", maxEvidencePhotos))
+		return nil
+	}
+
+	var urls []string
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			form.Errors.Add("photos", "We couldn't read "+fh.Filename)
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(f, photos.MaxBytes+1))
+		f.Close()
+		if err != nil {
+			form.Errors.Add("photos", "We couldn't read "+fh.Filename)
+			continue
+		}
+
+		url, err := photos.Save(r.Context(), m.App.Photos, prefix, data)
+		switch {
+		case errors.Is(err, photos.ErrTooLarge):
+			form.Errors.Add("photos", fh.Filename+" is larger than 5 MB")
+		case errors.Is(err, photos.ErrUnsupportedType):
+			form.Errors.Add("photos", fh.Filename+" isn't a JPEG, PNG or WebP image")
+		case err != nil:
+			m.App.ErrorLog.Printf("saving photo %s failed: %v", fh.Filename, err)
+			form.Errors.Add("photos", "We couldn't save "+fh.Filename)
+		default:
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// renderDisputeNew renders the form for opening a dispute on an order
+func (m *Repository) renderDisputeNew(w http.ResponseWriter, r *http.Request, form *forms.Form, o models.Order) {
+	render.Template(w, r, "dispute-new.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: map[string]interface{}{
+			"Order":   o,
+			"Reasons": models.DisputeReasons,
+		},
+	})
+}
+
+// GetOrderDispute is the page for reporting a problem with an order
+func (m *Repository) GetOrderDispute(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, ok := m.orderFor(w, r, orders.ActorBuyer)
+	if !ok {
+		return
+	}
+
+	active, err := m.App.Disputes.Active(ctx, o.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if active != nil {
+		http.Redirect(w, r, "/disputes/"+active.DisputeID, http.StatusSeeOther)
+		return
+	}
+	if err := m.App.Disputes.CanOpen(ctx, o); err != nil {
+		m.App.Session.Put(ctx, "error", disputeErrorMessage(err))
+		http.Redirect(w, r, "/orders/"+o.OrderID, http.StatusSeeOther)
+		return
+	}
+
+	m.renderDisputeNew(w, r, forms.New(nil), o)
+}
+
+// GetDispute is the dispute page shared by the buyer, the seller and admins
+func (m *Repository) GetDispute(w http.ResponseWriter, r *http.Request) {
+	d, actor, ok := m.disputeFor(w, r)
+	if !ok {
+		return
+	}
+
+	o, err := m.App.Orders.Get(r.Context(), d.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	thread, err := m.App.Disputes.Messages(r.Context(), d.DisputeID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var outcomes []string
+	if disputes.CanTransition(d.Status, models.DisputeStatusResolved, actor.Kind) {
+		for _, oc := range []string{models.DisputeOutcomeRefundFull, models.DisputeOutcomeRefundPartial, models.DisputeOutcomeReturnRequired, models.DisputeOutcomeDenied} {
+			if disputes.CanResolveWith(oc, actor.Kind) {
+				outcomes = append(outcomes, oc)
+			}
+		}
+	}
+
+	render.Template(w, r, "dispute.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{
+			"role":   actor.Kind,
+			"reason": models.DisputeReasons[d.Reason],
+		},
+		Form: forms.New(nil),
+		Data: map[string]interface{}{
+			"Dispute":          d,
+			"Order":            o,
+			"Messages":         thread,
+			"Outcomes":         outcomes,
+			"CanReply":         d.Open(),
+			"CanEscalate":      disputes.CanTransition(d.Status, models.DisputeStatusEscalated, actor.Kind),
+			"CanConfirmReturn": d.AwaitingReturn() && actor.Kind != orders.ActorBuyer,
+		},
+	})
+}
+
+// GetAdminDisputes is the admin queue of disputes in progress
+func (m *Repository) GetAdminDisputes(w http.ResponseWriter, r *http.Request) {
+	queue, err := m.App.Disputes.Queue(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-disputes.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Disputes": queue,
+			"Reasons":  models.DisputeReasons,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostOrderDispute opens a dispute on an order with a reason, a description and evidence photos
+func (m *Repository) PostOrderDispute(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, ok := m.orderFor(w, r, orders.ActorBuyer)
+	if !ok {
+		return
+	}
+	form, ok := parseUpload(w, r)
+	if !ok {
+		return
+	}
+
+	form.Required("reason", "description")
+	if _, ok := models.DisputeReasons[form.Get("reason")]; form.Has("reason") && !ok {
+		form.Errors.Add("reason", "Choose a reason")
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderDisputeNew(w, r, form, o)
+		return
+	}
+
+	evidence := m.saveEvidence(r, form, "disputes/"+o.OrderID)
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderDisputeNew(w, r, form, o)
+		return
+	}
+
+	d, err := m.App.Disputes.Open(ctx, o, o.BuyerID, disputes.Opening{
+		Reason:      form.Get("reason"),
+		Description: form.Get("description"),
+		Photos:      evidence,
+	})
+	if err != nil {
+		m.App.InfoLog.Printf("dispute on order %s rejected: %v", o.OrderID, err)
+		m.App.Session.Put(ctx, "error", disputeErrorMessage(err))
+		http.Redirect(w, r, "/orders/"+o.OrderID, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(ctx, "flash", "Your dispute is open. The seller has been asked to respond.")
+	http.Redirect(w, r, "/disputes/"+d.DisputeID, http.StatusSeeOther)
+}
+
+// PostDisputeMessage adds a message, optionally with photos, to a dispute's thread
+func (m *Repository) PostDisputeMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, actor, ok := m.disputeFor(w, r)
+	if !ok {
+		return
+	}
+	form, ok := parseUpload(w, r)
+	if !ok {
+		return
+	}
+	redirect := "/disputes/" + d.DisputeID
+
+	evidence := m.saveEvidence(r, form, "disputes/"+d.OrderID)
+	if !form.Valid() {
+		m.App.Session.Put(ctx, "error", form.Errors.Get("photos"))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	if _, err := m.App.Disputes.Reply(ctx, d.DisputeID, actor, form.Get("body"), evidence); err != nil {
+		m.App.InfoLog.Printf("reply to dispute %s rejected: %v", d.DisputeID, err)
+		m.App.Session.Put(ctx, "error", disputeErrorMessage(err))
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// PostDisputeEscalate hands a dispute to the marketplace team
+func (m *Repository) PostDisputeEscalate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, actor, ok := m.disputeFor(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := m.App.Disputes.Escalate(ctx, d.DisputeID, actor, r.FormValue("reason")); err != nil {
+		m.App.InfoLog.Printf("escalating dispute %s rejected: %v", d.DisputeID, err)
+		m.App.Session.Put(ctx, "error", disputeErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "The dispute has been escalated to the marketplace team.")
+	}
+	http.Redirect(w, r, "/disputes/"+d.DisputeID, http.StatusSeeOther)
+}
+
+// PostDisputeResolve settles a dispute with a refund, a return or, for admins, a denial
+func (m *Repository) PostDisputeResolve(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, actor, ok := m.disputeFor(w, r)
+	if !ok {
+		return
+	}
+	redirect := "/disputes/" + d.DisputeID
+
+	res := disputes.Resolution{Outcome: r.FormValue("outcome"), Note: r.FormValue("note")}
+	if res.Outcome == models.DisputeOutcomeRefundPartial {
+		cents, err := money.Parse(r.FormValue("refund"))
+		if err != nil {
+			m.App.Session.Put(ctx, "error", "Enter the partial refund as an amount, like 4.50.")
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+		res.RefundCents = cents
+	}
+
+	if _, err := m.App.Disputes.Resolve(ctx, d.DisputeID, actor, res); err != nil {
+		m.App.ErrorLog.Printf("resolving dispute %s failed: %v", d.DisputeID, err)
+		m.App.Session.Put(ctx, "error", disputeErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "The dispute has been resolved.")
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// PostDisputeReturnReceived records that the seller got a returned order back, refunding the buyer
+func (m *Repository) PostDisputeReturnReceived(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	d, actor, ok := m.disputeFor(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := m.App.Disputes.ConfirmReturn(ctx, d.DisputeID, actor); err != nil {
+		m.App.ErrorLog.Printf("confirming return for dispute %s failed: %v", d.DisputeID, err)
+		m.App.Session.Put(ctx, "error", disputeErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Return received. The buyer has been refunded.")
+	}
+	http.Redirect(w, r, "/disputes/"+d.DisputeID, http.StatusSeeOther)
+}
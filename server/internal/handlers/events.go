@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// GetAdminEvents lists domain events that failed to publish or are waiting to be published
+func (m *Repository) GetAdminEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	failed, err := m.App.Events.Outbox(ctx, models.EventStatusFailed)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	pending, err := m.App.Events.Outbox(ctx, models.EventStatusPending)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-events.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Failed":  failed,
+			"Pending": pending,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostAdminEventRetry puts a failed event back in the outbox. Subscribers that handled it the
+// first time are skipped.
+func (m *Repository) PostAdminEventRetry(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	_, err := m.App.Events.Retry(ctx, chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, outbox.ErrNotFound):
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	case errors.Is(err, outbox.ErrNotAllowed), errors.Is(err, outbox.ErrConflict):
+		m.App.Session.Put(ctx, "error", "Only failed events can be retried.")
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.Session.Put(ctx, "flash", "The event will be published again shortly.")
+	}
+	http.Redirect(w, r, "/admin/events", http.StatusSeeOther)
+}
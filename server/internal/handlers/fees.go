@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/fees"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetAdminFees shows the current fee schedule, its history and an editor for the next version
+func (m *Repository) GetAdminFees(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	schedules, err := m.App.Fees.Schedules(ctx)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var current models.FeeSchedule
+	if len(schedules) > 0 {
+		current = schedules[0]
+	}
+	rules, err := json.MarshalIndent(current.Rules, "", "  ")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-fees.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"rules": string(rules)},
+		Data: map[string]interface{}{
+			"Current":   current,
+			"Schedules": schedules,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostAdminFees publishes a new fee schedule version from the JSON rules in the editor
+func (m *Repository) PostAdminFees(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("fee schedule form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	var rules []models.FeeRule
+	if err := json.Unmarshal([]byte(r.PostForm.Get("rules")), &rules); err != nil || len(rules) == 0 {
+		m.App.Session.Put(ctx, "error", "Rules must be a non-empty JSON list.")
+		http.Redirect(w, r, "/admin/fees", http.StatusSeeOther)
+		return
+	}
+
+	adminID := m.App.Session.GetString(ctx, "user_id")
+	s, err := m.App.Fees.Publish(ctx, rules, orders.Admin(adminID).String())
+	if errors.Is(err, fees.ErrInvalidRule) {
+		m.App.Session.Put(ctx, "error", err.Error())
+		http.Redirect(w, r, "/admin/fees", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.InfoLog.Printf("fee schedule version %d published by %s", s.Version, adminID)
+	m.App.Session.Put(ctx, "flash", "Fee schedule published.")
+	http.Redirect(w, r, "/admin/fees", http.StatusSeeOther)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/config"
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/notifications"
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
 )
 
@@ -198,6 +199,11 @@ func (m *Repository) PostEmailVerification(w http.ResponseWriter, r *http.Reques
 	}
 
 	m.App.InfoLog.Printf("email verified for %s", email)
+	if m.App.Notifications != nil {
+		if err := m.App.Notifications.Notify(ctx, notifications.Welcome{Email: email}); err != nil {
+			m.App.ErrorLog.Printf("welcome email to %s failed: %v", email, err)
+		}
+	}
 
 	m.App.Session.Remove(ctx, "user_email")
 	m.App.Session.Put(ctx, "flash", "Email verified successfully. You can now log in.")
@@ -265,6 +271,10 @@ func (m *Repository) PostLogin(w http.ResponseWriter, r *http.Request) {
 	m.App.Session.Put(ctx, "access_token", authResponse.AccessToken)
 	m.App.Session.Put(ctx, "refresh_token", authResponse.RefreshToken)
 
+	if err := m.mergeSessionCart(ctx, sub); err != nil {
+		m.App.ErrorLog.Printf("failed merging session cart for %s: %v", sub, err)
+	}
+
 	m.App.Session.Put(ctx, "flash", "Logged in successfully.")
 	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 }
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/apikeys"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/hooks"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// maxDeliveryLog caps the deliveries listed on an endpoint's page
+const maxDeliveryLog = 50
+
+// newAPIKeySession is the session key holding a just-created API key until the page shows it once
+const newAPIKeySession = "new_api_key"
+
+// integrationErrorMessage turns an API key or webhook error into a message suitable for a toast
+func integrationErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, apikeys.ErrInvalid), errors.Is(err, hooks.ErrInvalid):
+		_, msg, _ := strings.Cut(err.Error(), ": ")
+		_, msg, _ = strings.Cut(msg, ": ")
+		if msg == "" {
+			return "Please check the form and try again."
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, apikeys.ErrTooMany):
+		return "You have as many API keys as allowed. Revoke one first."
+	case errors.Is(err, hooks.ErrTooMany):
+		return "You have as many webhook endpoints as allowed. Delete one first."
+	case errors.Is(err, apikeys.ErrNotFound), errors.Is(err, hooks.ErrNotFound):
+		return "That key, endpoint or delivery doesn't exist."
+	case errors.Is(err, hooks.ErrNotAllowed):
+		return "Enable the endpoint before redelivering to it."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// renderIntegrations renders the seller's API keys and webhook endpoints
+func (m *Repository) renderIntegrations(w http.ResponseWriter, r *http.Request, keyForm, hookForm *forms.Form) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	keys, err := m.App.APIKeys.ForSeller(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	endpoints, err := m.App.Webhooks.Endpoints(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "seller-integrations.page.tmpl", &models.TemplateData{
+		Form: keyForm,
+		Data: map[string]interface{}{
+			"Keys":      keys,
+			"NewKey":    m.App.Session.PopString(ctx, newAPIKeySession),
+			"Scopes":    models.APIScopes,
+			"Endpoints": endpoints,
+			"Events":    hooks.Events,
+			"HookForm":  hookForm,
+		},
+	})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetSellerIntegrations lists the seller's API keys and webhook endpoints, with forms for new ones
+func (m *Repository) GetSellerIntegrations(w http.ResponseWriter, r *http.Request) {
+	m.renderIntegrations(w, r, forms.New(nil), forms.New(nil))
+}
+
+// GetSellerWebhook shows a webhook endpoint, its signing secret and its delivery log
+func (m *Repository) GetSellerWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	e, err := m.App.Webhooks.Endpoint(ctx, sellerID, chi.URLParam(r, "id"))
+	if errors.Is(err, hooks.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	deliveries, err := m.App.Webhooks.Deliveries(ctx, sellerID, e.EndpointID, maxDeliveryLog)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "seller-webhook.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Endpoint":   e,
+			"Deliveries": deliveries,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostSellerAPIKey creates an API key and shows it once on the integrations page
+func (m *Repository) PostSellerAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("API key form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	scopes := r.PostForm["scope"]
+	if len(scopes) == 0 {
+		form.Errors.Add("scope", "Choose what the key may do")
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderIntegrations(w, r, form, forms.New(nil))
+		return
+	}
+
+	k, token, err := m.App.APIKeys.Create(ctx, m.App.Session.GetString(ctx, "user_id"), form.Get("name"), scopes)
+	if errors.Is(err, apikeys.ErrInvalid) || errors.Is(err, apikeys.ErrTooMany) {
+		m.App.Session.Put(ctx, "error", integrationErrorMessage(err))
+		http.Redirect(w, r, "/seller/integrations", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.InfoLog.Printf("API key %s created by %s", k.KeyID, k.SellerID)
+	m.App.Session.Put(ctx, newAPIKeySession, token)
+	m.App.Session.Put(ctx, "flash", "API key created. Copy it now; it won't be shown again.")
+	http.Redirect(w, r, "/seller/integrations", http.StatusSeeOther)
+}
+
+// PostSellerAPIKeyRevoke revokes an API key
+func (m *Repository) PostSellerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	k, err := m.App.APIKeys.Revoke(ctx, m.App.Session.GetString(ctx, "user_id"), chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, apikeys.ErrNotFound):
+		m.App.Session.Put(ctx, "error", integrationErrorMessage(err))
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.InfoLog.Printf("API key %s revoked by %s", k.KeyID, k.SellerID)
+		m.App.Session.Put(ctx, "flash", "API key revoked. Requests using it are refused from now on.")
+	}
+	http.Redirect(w, r, "/seller/integrations", http.StatusSeeOther)
+}
+
+// PostSellerWebhook registers a webhook endpoint
+func (m *Repository) PostSellerWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("webhook form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("url")
+	if form.Has("url") && !strings.HasPrefix(form.Get("url"), "https://") {
+		form.Errors.Add("url", "Enter an https:// URL")
+	}
+	events := r.PostForm["event"]
+	if len(events) == 0 {
+		form.Errors.Add("event", "Choose at least one event")
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderIntegrations(w, r, forms.New(nil), form)
+		return
+	}
+
+	e, err := m.App.Webhooks.CreateEndpoint(ctx, m.App.Session.GetString(ctx, "user_id"), form.Get("url"), form.Get("description"), events)
+	if errors.Is(err, hooks.ErrInvalid) || errors.Is(err, hooks.ErrTooMany) {
+		m.App.Session.Put(ctx, "error", integrationErrorMessage(err))
+		http.Redirect(w, r, "/seller/integrations", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.InfoLog.Printf("webhook endpoint %s added by %s", e.EndpointID, e.SellerID)
+	m.App.Session.Put(ctx, "flash", "Endpoint added. Use its signing secret to check that deliveries come from us.")
+	http.Redirect(w, r, "/seller/webhooks/"+e.EndpointID, http.StatusSeeOther)
+}
+
+// PostSellerWebhookToggle enables or disables a webhook endpoint
+func (m *Repository) PostSellerWebhookToggle(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("webhook toggle form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+	enable := r.PostForm.Get("enabled") == "1"
+
+	e, err := m.App.Webhooks.SetEnabled(ctx, m.App.Session.GetString(ctx, "user_id"), chi.URLParam(r, "id"), enable)
+	switch {
+	case errors.Is(err, hooks.ErrNotFound):
+		m.App.Session.Put(ctx, "error", integrationErrorMessage(err))
+		http.Redirect(w, r, "/seller/integrations", http.StatusSeeOther)
+		return
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	case e.Enabled():
+		m.App.Session.Put(ctx, "flash", "Endpoint enabled. New events will be delivered to it.")
+	default:
+		m.App.Session.Put(ctx, "flash", "Endpoint disabled.")
+	}
+	http.Redirect(w, r, "/seller/webhooks/"+e.EndpointID, http.StatusSeeOther)
+}
+
+// PostSellerWebhookRoll gives a webhook endpoint a new signing secret
+func (m *Repository) PostSellerWebhookRoll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	e, err := m.App.Webhooks.RollSecret(ctx, m.App.Session.GetString(ctx, "user_id"), chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, hooks.ErrNotFound):
+		m.App.Session.Put(ctx, "error", integrationErrorMessage(err))
+		http.Redirect(w, r, "/seller/integrations", http.StatusSeeOther)
+		return
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.InfoLog.Printf("webhook endpoint %s secret rolled by %s", e.EndpointID, e.SellerID)
+	m.App.Session.Put(ctx, "flash", "Signing secret replaced. Deliveries are signed with the new secret from now on.")
+	http.Redirect(w, r, "/seller/webhooks/"+e.EndpointID, http.StatusSeeOther)
+}
+
+// PostSellerWebhookDelete deletes a webhook endpoint
+func (m *Repository) PostSellerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	err := m.App.Webhooks.DeleteEndpoint(ctx, m.App.Session.GetString(ctx, "user_id"), chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, hooks.ErrNotFound):
+		m.App.Session.Put(ctx, "error", integrationErrorMessage(err))
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.Session.Put(ctx, "flash", "Endpoint deleted.")
+	}
+	http.Redirect(w, r, "/seller/integrations", http.StatusSeeOther)
+}
+
+// PostSellerWebhookRedeliver sends a delivery's payload to its endpoint again
+func (m *Repository) PostSellerWebhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	back := "/seller/webhooks/" + chi.URLParam(r, "id")
+
+	d, err := m.App.Webhooks.Redeliver(ctx, m.App.Session.GetString(ctx, "user_id"), chi.URLParam(r, "deliveryID"))
+	switch {
+	case errors.Is(err, hooks.ErrNotFound), errors.Is(err, hooks.ErrNotAllowed):
+		m.App.Session.Put(ctx, "error", integrationErrorMessage(err))
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.InfoLog.Printf("webhook delivery %s redelivered as %s by %s", d.RedeliveryOf, d.DeliveryID, d.SellerID)
+		m.App.Session.Put(ctx, "flash", "Redelivery queued. It will appear in the log once it's sent.")
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/jobs"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// GetAdminJobs shows how many background jobs are waiting and running, and the ones that gave up
+func (m *Repository) GetAdminJobs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	stats, err := m.App.Jobs.Stats(ctx)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	dead, err := m.App.Jobs.Dead(ctx, 100)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-jobs.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Stats": stats,
+			"Dead":  dead,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostAdminJobRevive puts a dead job back on the queue with its attempts reset
+func (m *Repository) PostAdminJobRevive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	_, err := m.App.Jobs.Revive(ctx, chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(ctx, "flash", "The job will run again shortly.")
+	http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetSellerDashboard shows the seller's pending and available balances and recent ledger activity
+func (m *Repository) GetSellerDashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	balances, err := m.App.Ledger.SellerBalances(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	lines, err := m.App.Ledger.SellerStatement(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "seller-dashboard.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Balances": balances,
+			"Lines":    lines,
+		},
+	})
+}
+
+// GetAdminLedger is the ledger reconciliation report
+func (m *Repository) GetAdminLedger(w http.ResponseWriter, r *http.Request) {
+	report, err := m.App.Ledger.Reconcile(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-ledger.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Report": report,
+		},
+	})
+}
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/fees"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/grading"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/payments"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// maxPrintingMatches caps the printings, and separately the sealed products, offered when
+// searching on the new listing form
+const maxPrintingMatches = 25
+
+// sellerListing pairs a listing with the printing or sealed product it sells for display
+type sellerListing struct {
+	Listing  models.Listing
+	Printing models.Printing
+	Product  models.Product
+}
+
+// feePreview is the JSON body returned by GetFeePreview
+type feePreview struct {
+	FeeCents int64  `json:"feeCents"`
+	NetCents int64  `json:"netCents"`
+	Fee      string `json:"fee"`
+	Net      string `json:"net"`
+	Rule     string `json:"rule"`
+	Version  int    `json:"version"`
+}
+
+// isCondition reports whether c is one of the listing conditions
+func isCondition(c string) bool {
+	for _, known := range models.Conditions {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// gradingErrorMessage turns a grading error into a message suitable for a form field
+func gradingErrorMessage(err error) string {
+	for _, prefix := range []string{": invalid grading: ", ": invalid cert number: ", ": cert does not match: "} {
+		if _, msg, ok := strings.Cut(err.Error(), prefix); ok && msg != "" {
+			return strings.ToUpper(msg[:1]) + msg[1:]
+		}
+	}
+	if errors.Is(err, grading.ErrCertNotFound) {
+		return "The grading company has no cert with this number"
+	}
+	return "Please check the grading details"
+}
+
+// certListed reports whether a cert is already on another active listing
+func (m *Repository) certListed(ctx context.Context, g models.Grading) (bool, error) {
+	all, err := m.App.Catalog.Listings(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, l := range all {
+		if l.IsActive() && l.Grading != nil && l.Grading.Company == g.Company && l.Grading.CertNumber == g.CertNumber {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// maxListingTags caps how many tags a listing can carry
+const maxListingTags = 10
+
+// parseTags splits a comma-separated tag field into lowercase, de-duplicated tags
+func parseTags(field string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(field, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// findPrintings returns up to maxPrintingMatches printings whose card name contains q
+func (m *Repository) findPrintings(ctx context.Context, q string) ([]models.Printing, error) {
+	all, err := m.App.Catalog.Printings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matches []models.Printing
+	for _, p := range all {
+		if strings.Contains(strings.ToLower(p.CardName), strings.ToLower(q)) {
+			matches = append(matches, p)
+			if len(matches) == maxPrintingMatches {
+				break
+			}
+		}
+	}
+	return matches, nil
+}
+
+// findProducts returns up to maxPrintingMatches sealed products whose name contains q
+func (m *Repository) findProducts(ctx context.Context, q string) ([]models.Product, error) {
+	all, err := m.App.Catalog.Products(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matches []models.Product
+	for _, p := range all {
+		if strings.Contains(strings.ToLower(p.Name), strings.ToLower(q)) {
+			matches = append(matches, p)
+			if len(matches) == maxPrintingMatches {
+				break
+			}
+		}
+	}
+	return matches, nil
+}
+
+// renderListingForm renders the new listing form for a printing or sealed product, or a search
+// when neither is chosen
+func (m *Repository) renderListingForm(w http.ResponseWriter, r *http.Request, form *forms.Form, printingID, productID, q string) {
+	ctx := r.Context()
+	data := map[string]interface{}{
+		"Conditions": models.Conditions,
+		"Graders":    models.Graders,
+	}
+
+	switch {
+	case productID != "":
+		p, err := m.App.Catalog.Product(ctx, productID)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		data["Product"] = p
+		data["Conditions"] = p.Conditions()
+	case printingID != "":
+		p, err := m.App.Catalog.Printing(ctx, printingID)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		data["Printing"] = p
+	default:
+		if q = strings.TrimSpace(q); q == "" {
+			break
+		}
+		matches, err := m.findPrintings(ctx, q)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		products, err := m.findProducts(ctx, q)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["Matches"] = matches
+		data["ProductMatches"] = products
+	}
+
+	render.Template(w, r, "seller-listing-new.page.tmpl", &models.TemplateData{
+		Form:      form,
+		StringMap: map[string]string{"q": q},
+		Data:      data,
+	})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetSellerListings is the seller's listings page handler
+func (m *Repository) GetSellerListings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	list, err := m.App.Catalog.ListingsBySeller(ctx, m.App.Session.GetString(ctx, "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rows := make([]sellerListing, 0, len(list))
+	for _, l := range list {
+		row := sellerListing{Listing: l}
+		if l.IsSealed() {
+			row.Product, err = m.App.Catalog.Product(ctx, l.ProductID)
+		} else {
+			row.Printing, err = m.App.Catalog.Printing(ctx, l.PrintingID)
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Listing.CreatedAt > rows[j].Listing.CreatedAt
+	})
+
+	render.Template(w, r, "seller-listings.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Listings": rows,
+		},
+	})
+}
+
+// GetSellerListingNew is the new listing form
+func (m *Repository) GetSellerListingNew(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ok, err := m.App.Payments.CanSell(ctx, m.App.Session.GetString(ctx, "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !ok {
+		m.App.Session.Put(ctx, "warning", "Connect your payout account before listing.")
+		http.Redirect(w, r, "/seller/payouts", http.StatusSeeOther)
+		return
+	}
+
+	params := r.URL.Query()
+	m.renderListingForm(w, r, forms.New(nil), params.Get("printing_id"), params.Get("product_id"), params.Get("q"))
+}
+
+// GetFeePreview returns the fee a seller would pay on one copy of a printing or sealed product at a price
+func (m *Repository) GetFeePreview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	params := r.URL.Query()
+
+	var listing models.Listing
+	var game string
+	if id := params.Get("product_id"); id != "" {
+		p, err := m.App.Catalog.Product(ctx, id)
+		if err != nil {
+			m.writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown product"})
+			return
+		}
+		listing.ProductID, game = p.ProductID, p.Game
+	} else {
+		p, err := m.App.Catalog.Printing(ctx, params.Get("printing_id"))
+		if err != nil {
+			m.writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown printing"})
+			return
+		}
+		listing.PrintingID, game = p.PrintingID, p.Game
+	}
+
+	price, err := money.Parse(params.Get("price"))
+	if err != nil || price <= 0 {
+		m.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid price"})
+		return
+	}
+
+	listing.PriceCents = price
+	q, err := m.App.Fees.Quote(ctx, m.App.Session.GetString(ctx, "user_id"), []fees.Line{
+		{Game: game, Category: listing.Category(), UnitPriceCents: price, Quantity: 1},
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	fee := q.Lines[0].FeeCents
+	m.writeJSON(w, http.StatusOK, feePreview{
+		FeeCents: fee,
+		NetCents: price - fee,
+		Fee:      money.Format(fee),
+		Net:      money.Format(price - fee),
+		Rule:     q.Lines[0].Rule,
+		Version:  q.Version,
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostSellerListing creates a listing from the new listing form
+func (m *Repository) PostSellerListing(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("listing form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	sealed := form.Has("product_id")
+	if sealed {
+		form.Required("product_id", "condition", "price", "quantity")
+	} else {
+		form.Required("printing_id", "price", "quantity")
+	}
+	graded := !sealed && form.Has("graded")
+	if graded {
+		form.Required("grader", "grade", "cert_number")
+	} else {
+		form.Required("condition")
+	}
+
+	price, err := money.Parse(form.Get("price"))
+	if form.Has("price") && (err != nil || price <= 0) {
+		form.Errors.Add("price", "Enter a price such as 4.99")
+	}
+	qty, err := strconv.Atoi(form.Get("quantity"))
+	if form.Has("quantity") && (err != nil || qty <= 0) {
+		form.Errors.Add("quantity", "Enter how many copies you have")
+	}
+	condition := form.Get("condition")
+	if !sealed && !graded && form.Has("condition") && !isCondition(condition) {
+		form.Errors.Add("condition", "Choose a condition")
+	}
+	if graded && qty > 1 {
+		form.Errors.Add("quantity", "List each graded card on its own")
+	}
+	tags := parseTags(form.Get("tags"))
+	if len(tags) > maxListingTags {
+		form.Errors.Add("tags", fmt.Sprintf("Use at most %d tags", maxListingTags))
+	}
+	var printing models.Printing
+	printingID, productID := form.Get("printing_id"), form.Get("product_id")
+	if sealed {
+		product, err := m.App.Catalog.Product(ctx, productID)
+		if err != nil {
+			form.Errors.Add("product_id", "Choose a product to sell")
+			productID = ""
+		} else if form.Has("condition") && !product.AllowsCondition(condition) {
+			form.Errors.Add("condition", fmt.Sprintf("Choose %s", strings.Join(product.Conditions(), " or ")))
+		}
+		printingID = ""
+	} else {
+		printing, err = m.App.Catalog.Printing(ctx, printingID)
+		if form.Has("printing_id") && err != nil {
+			form.Errors.Add("printing_id", "Choose a card to sell")
+			printingID = ""
+		}
+	}
+
+	// a slab's grading is checked last, so a cert is only looked up for an otherwise valid listing
+	var slab *models.Grading
+	verified := true
+	if graded && form.Valid() {
+		g, err := m.App.Grading.Verify(ctx, models.Grading{
+			Company:    form.Get("grader"),
+			Grade:      form.Get("grade"),
+			CertNumber: form.Get("cert_number"),
+			Subgrades: models.Subgrades{
+				Centering: form.Get("centering"),
+				Corners:   form.Get("corners"),
+				Edges:     form.Get("edges"),
+				Surface:   form.Get("surface"),
+			},
+		}, printing)
+		switch {
+		case errors.Is(err, grading.ErrInvalid):
+			form.Errors.Add("grade", gradingErrorMessage(err))
+		case errors.Is(err, grading.ErrInvalidCert), errors.Is(err, grading.ErrCertNotFound), errors.Is(err, grading.ErrMismatch):
+			form.Errors.Add("cert_number", gradingErrorMessage(err))
+		case err != nil:
+			// the grading company couldn't be asked, so the slab is listed without the verified badge
+			m.App.ErrorLog.Printf("cert %s %s lookup failed: %v", g.Company, g.CertNumber, err)
+			verified = false
+		}
+		if form.Valid() {
+			listed, err := m.certListed(ctx, g)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			if listed {
+				form.Errors.Add("cert_number", "This slab is already for sale")
+			}
+		}
+		slab, condition, qty = &g, g.Label(), 1
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderListingForm(w, r, form, printingID, productID, "")
+		return
+	}
+
+	language := strings.TrimSpace(form.Get("language"))
+	if language == "" {
+		language = "en"
+	}
+
+	l, err := m.App.Catalog.SaveListing(ctx, models.Listing{
+		SellerID:      m.App.Session.GetString(ctx, "user_id"),
+		PrintingID:    printingID,
+		ProductID:     productID,
+		Condition:     condition,
+		Language:      language,
+		Foil:          !sealed && form.Has("foil"),
+		PriceCents:    price,
+		Quantity:      qty,
+		AcceptsOffers: !sealed && form.Has("accepts_offers"),
+		Tags:          tags,
+		Grading:       slab,
+	})
+	if errors.Is(err, payments.ErrNoPayoutAccount) {
+		m.App.Session.Put(ctx, "error", "Connect your payout account before listing.")
+		http.Redirect(w, r, "/seller/payouts", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.InfoLog.Printf("listing %s created by %s", l.ListingID, l.SellerID)
+	if !verified {
+		m.App.Session.Put(ctx, "warning", "Listing created, but we couldn't check the cert right now, so it isn't marked verified.")
+	} else {
+		m.App.Session.Put(ctx, "flash", "Listing created.")
+	}
+	http.Redirect(w, r, "/seller/listings", http.StatusSeeOther)
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/messages"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// messageErrorMessage turns a messaging error into a message suitable for a toast or form field
+func messageErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, messages.ErrContactInfo):
+		_, msg, _ := strings.Cut(err.Error(), " before an order: ")
+		if msg == "" {
+			return "Contact details can't be shared until you have an order together."
+		}
+		return "Contact details can't be shared until you have an order together. Please " + msg + "."
+	case errors.Is(err, messages.ErrInvalid):
+		_, msg, _ := strings.Cut(err.Error(), ": invalid message: ")
+		if msg == "" {
+			return "Please check the message and try again."
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, messages.ErrBlocked):
+		return "You can't message this user."
+	case errors.Is(err, messages.ErrNotFound), errors.Is(err, messages.ErrNotAllowed):
+		return "That action isn't available for this conversation."
+	case errors.Is(err, messages.ErrConflict):
+		return "The conversation was just updated. Please try again."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// messageScope reads the listing or order a new conversation is about from a request
+func messageScope(r *http.Request) messages.Scope {
+	return messages.Scope{ListingID: r.FormValue("listing_id"), OrderID: r.FormValue("order_id")}
+}
+
+// renderMessageNew renders the form for starting a conversation
+func (m *Repository) renderMessageNew(w http.ResponseWriter, r *http.Request, form *forms.Form, scope messages.Scope, subject string) {
+	render.Template(w, r, "message-new.page.tmpl", &models.TemplateData{
+		Form: form,
+		StringMap: map[string]string{
+			"listing_id": scope.ListingID,
+			"order_id":   scope.OrderID,
+			"subject":    subject,
+		},
+	})
+}
+
+// renderMessageThread renders a conversation with the reply form
+func (m *Repository) renderMessageThread(w http.ResponseWriter, r *http.Request, form *forms.Form, threadID string) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	t, list, err := m.App.Messages.Thread(ctx, threadID, userID)
+	if errors.Is(err, messages.ErrNotFound) || errors.Is(err, messages.ErrNotAllowed) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	role := "buyer"
+	if userID == t.SellerID {
+		role = "seller"
+	}
+	render.Template(w, r, "message-thread.page.tmpl", &models.TemplateData{
+		Form: form,
+		StringMap: map[string]string{
+			"user_id": userID,
+			"role":    role,
+		},
+		Data: map[string]interface{}{
+			"Thread":   t,
+			"Messages": list,
+		},
+	})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetMessages is the signed-in user's inbox of conversations as a buyer and as a seller
+func (m *Repository) GetMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	threads, err := m.App.Messages.Inbox(ctx, userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "messages.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"user_id": userID},
+		Data:      map[string]interface{}{"Threads": threads},
+	})
+}
+
+// GetMessageNew is the form for asking about a listing or writing about an order. A user who
+// already has a conversation about it is taken there instead.
+func (m *Repository) GetMessageNew(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+	scope := messageScope(r)
+
+	subject, err := m.App.Messages.Subject(ctx, userID, scope)
+	if errors.Is(err, messages.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(ctx, "error", messageErrorMessage(err))
+		http.Redirect(w, r, "/messages", http.StatusSeeOther)
+		return
+	}
+
+	m.renderMessageNew(w, r, forms.New(nil), scope, subject)
+}
+
+// GetMessageThread is a conversation. Viewing it marks it read.
+func (m *Repository) GetMessageThread(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	threadID := chi.URLParam(r, "id")
+
+	_, err := m.App.Messages.MarkRead(ctx, threadID, m.App.Session.GetString(ctx, "user_id"))
+	if err != nil && !errors.Is(err, messages.ErrNotFound) && !errors.Is(err, messages.ErrNotAllowed) {
+		m.App.ErrorLog.Printf("marking thread %s read failed: %v", threadID, err)
+	}
+
+	m.renderMessageThread(w, r, forms.New(nil), threadID)
+}
+
+// GetAdminMessages is the admin moderation queue of reported messages, with recent blocks
+func (m *Repository) GetAdminMessages(w http.ResponseWriter, r *http.Request) {
+	queue, err := m.App.Messages.Queue(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	blocks, err := m.App.Messages.Blocks(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-messages.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Queue":  queue,
+			"Blocks": blocks,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostMessageNew sends the first message about a listing or an order
+func (m *Repository) PostMessageNew(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	scope := messageScope(r)
+	form := forms.New(r.PostForm)
+	form.Required("body")
+
+	subject, err := m.App.Messages.Subject(ctx, userID, scope)
+	if errors.Is(err, messages.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(ctx, "error", messageErrorMessage(err))
+		http.Redirect(w, r, "/messages", http.StatusSeeOther)
+		return
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderMessageNew(w, r, form, scope, subject)
+		return
+	}
+
+	t, _, err := m.App.Messages.Start(ctx, userID, scope, form.Get("body"))
+	if errors.Is(err, messages.ErrInvalid) || errors.Is(err, messages.ErrContactInfo) || errors.Is(err, messages.ErrBlocked) {
+		form.Errors.Add("body", messageErrorMessage(err))
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderMessageNew(w, r, form, scope, subject)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(ctx, "flash", "Your message has been sent.")
+	http.Redirect(w, r, "/messages/"+t.ThreadID, http.StatusSeeOther)
+}
+
+// PostMessageReply adds a message to a conversation
+func (m *Repository) PostMessageReply(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	threadID := chi.URLParam(r, "id")
+
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("body")
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderMessageThread(w, r, form, threadID)
+		return
+	}
+
+	_, _, err := m.App.Messages.Send(ctx, threadID, m.App.Session.GetString(ctx, "user_id"), form.Get("body"))
+	if errors.Is(err, messages.ErrNotFound) || errors.Is(err, messages.ErrNotAllowed) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, messages.ErrInvalid) || errors.Is(err, messages.ErrContactInfo) || errors.Is(err, messages.ErrBlocked) {
+		form.Errors.Add("body", messageErrorMessage(err))
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderMessageThread(w, r, form, threadID)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/messages/"+threadID, http.StatusSeeOther)
+}
+
+// PostMessageReport flags the other side's message for the moderation queue
+func (m *Repository) PostMessageReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	threadID := chi.URLParam(r, "id")
+
+	_, err := m.App.Messages.Report(ctx, threadID, r.FormValue("message_id"), m.App.Session.GetString(ctx, "user_id"), r.FormValue("reason"))
+	if err != nil {
+		m.App.InfoLog.Printf("report of message in thread %s rejected: %v", threadID, err)
+		m.App.Session.Put(ctx, "error", messageErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Thanks, the message has been reported to the marketplace team.")
+	}
+	http.Redirect(w, r, "/messages/"+threadID, http.StatusSeeOther)
+}
+
+// PostMessageBlock stops the other side of a conversation from messaging the user
+func (m *Repository) PostMessageBlock(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	threadID := chi.URLParam(r, "id")
+
+	if _, err := m.App.Messages.Block(ctx, threadID, m.App.Session.GetString(ctx, "user_id"), r.FormValue("reason")); err != nil {
+		m.App.InfoLog.Printf("block from thread %s rejected: %v", threadID, err)
+		m.App.Session.Put(ctx, "error", messageErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "They can no longer message you.")
+	}
+	http.Redirect(w, r, "/messages/"+threadID, http.StatusSeeOther)
+}
+
+// PostMessageUnblock lifts a block the user made from a conversation
+func (m *Repository) PostMessageUnblock(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	threadID := chi.URLParam(r, "id")
+
+	if _, err := m.App.Messages.Unblock(ctx, threadID, m.App.Session.GetString(ctx, "user_id")); err != nil {
+		m.App.InfoLog.Printf("unblock from thread %s rejected: %v", threadID, err)
+		m.App.Session.Put(ctx, "error", messageErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "They can message you again.")
+	}
+	http.Redirect(w, r, "/messages/"+threadID, http.StatusSeeOther)
+}
+
+// PostAdminMessageModerate hides a reported message or keeps it
+func (m *Repository) PostAdminMessageModerate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	actor := orders.Admin(m.App.Session.GetString(ctx, "user_id"))
+	messageID := chi.URLParam(r, "id")
+
+	hide := r.FormValue("action") == "hide"
+	if _, err := m.App.Messages.Moderate(ctx, r.FormValue("thread_id"), messageID, actor, hide, r.FormValue("note")); err != nil {
+		m.App.ErrorLog.Printf("moderating message %s failed: %v", messageID, err)
+		m.App.Session.Put(ctx, "error", messageErrorMessage(err))
+	} else if hide {
+		m.App.Session.Put(ctx, "flash", "The message has been hidden.")
+	} else {
+		m.App.Session.Put(ctx, "flash", "The message has been kept.")
+	}
+	http.Redirect(w, r, "/admin/messages", http.StatusSeeOther)
+}
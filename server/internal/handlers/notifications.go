@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/notifications"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// GetNotifications lists the signed-in user's notifications and marks them read
+func (m *Repository) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	feed, err := m.App.Notifications.Feed(ctx, userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if err := m.App.Notifications.MarkAllRead(ctx, userID); err != nil {
+		m.App.ErrorLog.Printf("marking notifications read for %s failed: %v", userID, err)
+	}
+
+	render.Template(w, r, "notifications.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Notifications": feed,
+		},
+	})
+}
+
+// GetNotificationPreferences shows which notifications the user gets by email and on the site
+func (m *Repository) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	prefs, err := m.App.Notifications.Preferences(ctx, m.App.Session.GetString(ctx, "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "notification-preferences.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Categories":  notifications.Categories,
+			"Preferences": prefs,
+		},
+	})
+}
+
+// GetAdminNotifications lists emails that failed or are waiting to be retried
+func (m *Repository) GetAdminNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	failed, err := m.App.Notifications.Outbox(ctx, models.NotificationStatusFailed)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	pending, err := m.App.Notifications.Outbox(ctx, models.NotificationStatusPending)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-notifications.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Failed":  failed,
+			"Pending": pending,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostNotificationPreferences saves the categories the user has turned off on each channel.
+// The form has a checkbox for every category and channel that is on.
+func (m *Repository) PostNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var emailOff, siteOff []string
+	for _, c := range notifications.Categories {
+		if r.PostForm.Get("email_"+c.Key) == "" {
+			emailOff = append(emailOff, c.Key)
+		}
+		if r.PostForm.Get("site_"+c.Key) == "" {
+			siteOff = append(siteOff, c.Key)
+		}
+	}
+
+	if _, err := m.App.Notifications.SetPreferences(ctx, m.App.Session.GetString(ctx, "user_id"), emailOff, siteOff); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(ctx, "flash", "Notification settings saved.")
+	http.Redirect(w, r, "/notifications/preferences", http.StatusSeeOther)
+}
+
+// PostAdminNotificationRetry puts a failed email back in the outbox
+func (m *Repository) PostAdminNotificationRetry(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	notificationID := chi.URLParam(r, "id")
+
+	_, err := m.App.Notifications.Retry(ctx, notificationID)
+	switch {
+	case errors.Is(err, notifications.ErrNotFound):
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	case errors.Is(err, notifications.ErrNotAllowed), errors.Is(err, notifications.ErrConflict):
+		m.App.Session.Put(ctx, "error", "Only failed emails can be retried.")
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.Session.Put(ctx, "flash", "The email will be sent again shortly.")
+	}
+	http.Redirect(w, r, "/admin/notifications", http.StatusSeeOther)
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/offers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// offerRow pairs an offer with the listing and printing it is for, for display
+type offerRow struct {
+	Offer    models.Offer
+	Listing  models.Listing
+	Printing models.Printing
+}
+
+// offerRows joins offers with their listings and printings
+func (m *Repository) offerRows(r *http.Request, list []models.Offer) ([]offerRow, error) {
+	rows := make([]offerRow, 0, len(list))
+	for _, o := range list {
+		row := offerRow{Offer: o}
+		l, err := m.App.Catalog.Listing(r.Context(), o.ListingID)
+		if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+			return nil, err
+		}
+		row.Listing = l
+		if l.PrintingID != "" {
+			p, err := m.App.Catalog.Printing(r.Context(), l.PrintingID)
+			if err != nil && !errors.Is(err, catalog.ErrNotFound) {
+				return nil, err
+			}
+			row.Printing = p
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// offerFor loads the offer in the URL and works out whether the signed-in user is its buyer or seller.
+// It writes a 404 and returns false when the offer is missing or the user has no part in it.
+func (m *Repository) offerFor(w http.ResponseWriter, r *http.Request) (models.Offer, orders.Actor, bool) {
+	userID := m.App.Session.GetString(r.Context(), "user_id")
+
+	o, err := m.App.Offers.Get(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, offers.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return o, orders.Actor{}, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return o, orders.Actor{}, false
+	}
+
+	switch userID {
+	case o.BuyerID:
+		return o, orders.Buyer(userID), true
+	case o.SellerID:
+		return o, orders.Seller(userID), true
+	}
+	helpers.ClientError(w, http.StatusNotFound)
+	return o, orders.Actor{}, false
+}
+
+// offerErrorMessage turns an offer error into a message suitable for a toast
+func offerErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, offers.ErrInvalid):
+		_, msg, _ := strings.Cut(err.Error(), ": invalid offer: ")
+		if msg == "" {
+			return "Please check the amount and try again."
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, offers.ErrNotAccepting), errors.Is(err, offers.ErrAlreadyOpen),
+		errors.Is(err, offers.ErrRateLimited), errors.Is(err, offers.ErrUnavailable):
+		msg := strings.TrimPrefix(err.Error(), "offers: ")
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, offers.ErrClosed):
+		return "This offer is no longer open."
+	case errors.Is(err, offers.ErrNotAllowed):
+		return "That action isn't available for this offer."
+	case errors.Is(err, offers.ErrConflict):
+		return "The offer was just updated. Please review it and try again."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetBuyerOffers lists the offers the signed-in buyer has made
+func (m *Repository) GetBuyerOffers(w http.ResponseWriter, r *http.Request) {
+	list, err := m.App.Offers.ForBuyer(r.Context(), m.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rows, err := m.offerRows(r, list)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "offers.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"role": orders.ActorBuyer},
+		Data: map[string]interface{}{
+			"Offers": rows,
+		},
+	})
+}
+
+// GetSellerOffers lists the offers the signed-in seller has received
+func (m *Repository) GetSellerOffers(w http.ResponseWriter, r *http.Request) {
+	list, err := m.App.Offers.ForSeller(r.Context(), m.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rows, err := m.offerRows(r, list)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "offers.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"role": orders.ActorSeller},
+		Data: map[string]interface{}{
+			"Offers": rows,
+		},
+	})
+}
+
+// GetOffer is the offer page shared by the buyer and the seller, with its history
+func (m *Repository) GetOffer(w http.ResponseWriter, r *http.Request) {
+	o, actor, ok := m.offerFor(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := m.offerRows(r, []models.Offer{o})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	history, err := m.App.Offers.History(r.Context(), o.OfferID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "offer.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"role": actor.Kind},
+		Data: map[string]interface{}{
+			"Row":         rows[0],
+			"History":     history,
+			"CanRespond":  o.AwaitingRole() == actor.Kind,
+			"CanWithdraw": o.Open() && actor.Kind == orders.ActorBuyer,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostListingOffer makes an offer on a listing
+func (m *Repository) PostListingOffer(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	buyerID := m.App.Session.GetString(ctx, "user_id")
+
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm)
+
+	amount, err := money.Parse(form.Get("amount"))
+	if err != nil {
+		m.App.Session.Put(ctx, "error", "Enter your offer as an amount per copy, like 12.50.")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+	qty := 1
+	if form.Has("quantity") {
+		qty = form.GetInt("quantity")
+	}
+
+	o, err := m.App.Offers.Make(ctx, buyerID, chi.URLParam(r, "id"), qty, amount, form.Get("note"))
+	if errors.Is(err, offers.ErrAlreadyOpen) {
+		m.App.Session.Put(ctx, "warning", offerErrorMessage(err))
+		http.Redirect(w, r, "/offers/"+o.OfferID, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.InfoLog.Printf("offer on listing %s rejected: %v", chi.URLParam(r, "id"), err)
+		m.App.Session.Put(ctx, "error", offerErrorMessage(err))
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(ctx, "flash", "Your offer has been sent to the seller.")
+	http.Redirect(w, r, "/offers/"+o.OfferID, http.StatusSeeOther)
+}
+
+// PostOfferCounter answers an offer with a different amount
+func (m *Repository) PostOfferCounter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, actor, ok := m.offerFor(w, r)
+	if !ok {
+		return
+	}
+	redirect := "/offers/" + o.OfferID
+
+	amount, err := money.Parse(r.FormValue("amount"))
+	if err != nil {
+		m.App.Session.Put(ctx, "error", "Enter your counter as an amount per copy, like 12.50.")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	if _, err := m.App.Offers.Counter(ctx, o.OfferID, actor, amount, r.FormValue("note")); err != nil {
+		m.App.InfoLog.Printf("counter on offer %s rejected: %v", o.OfferID, err)
+		m.App.Session.Put(ctx, "error", offerErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Your counter-offer has been sent.")
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// PostOfferAccept accepts the amount on the table, holding the copies in the buyer's cart
+func (m *Repository) PostOfferAccept(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, actor, ok := m.offerFor(w, r)
+	if !ok {
+		return
+	}
+
+	o, err := m.App.Offers.Accept(ctx, o.OfferID, actor)
+	if err != nil {
+		m.App.ErrorLog.Printf("accepting offer %s failed: %v", o.OfferID, err)
+		m.App.Session.Put(ctx, "error", offerErrorMessage(err))
+		http.Redirect(w, r, "/offers/"+o.OfferID, http.StatusSeeOther)
+		return
+	}
+
+	if actor.Kind == orders.ActorBuyer {
+		c, err := m.App.Carts.Load(ctx, actor.ID)
+		if err == nil {
+			m.App.Session.Put(ctx, "cart_count", c.ItemCount())
+		}
+		m.App.Session.Put(ctx, "flash", "Offer accepted. The cards are in your cart at the agreed price.")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+	m.App.Session.Put(ctx, "flash", "Offer accepted. The cards are being held for the buyer.")
+	http.Redirect(w, r, "/offers/"+o.OfferID, http.StatusSeeOther)
+}
+
+// PostOfferDecline turns down the amount on the table
+func (m *Repository) PostOfferDecline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, actor, ok := m.offerFor(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := m.App.Offers.Decline(ctx, o.OfferID, actor, r.FormValue("note")); err != nil {
+		m.App.InfoLog.Printf("declining offer %s rejected: %v", o.OfferID, err)
+		m.App.Session.Put(ctx, "error", offerErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Offer declined.")
+	}
+	http.Redirect(w, r, "/offers/"+o.OfferID, http.StatusSeeOther)
+}
+
+// PostOfferWithdraw lets the buyer take back an offer that is still open
+func (m *Repository) PostOfferWithdraw(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, actor, ok := m.offerFor(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := m.App.Offers.Withdraw(ctx, o.OfferID, actor); err != nil {
+		m.App.InfoLog.Printf("withdrawing offer %s rejected: %v", o.OfferID, err)
+		m.App.Session.Put(ctx, "error", offerErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Offer withdrawn.")
+	}
+	http.Redirect(w, r, "/offers/"+o.OfferID, http.StatusSeeOther)
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/decklists"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/optimizer"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/shipping"
+)
+
+// Sources of the cards the optimizer buys
+const (
+	optimizeFromCart  = "cart"
+	optimizeFromWants = "wants"
+	optimizeFromDeck  = "deck" // the decklist last imported, held in the session
+)
+
+// optimizeLine is one listing in a JSON plan
+type optimizeLine struct {
+	ListingID  string `json:"listingId"`
+	PrintingID string `json:"printingId"`
+	Condition  string `json:"condition"`
+	Quantity   int    `json:"quantity"`
+	PriceCents int64  `json:"priceCents"`
+}
+
+// optimizePackage is one seller's package in a JSON plan
+type optimizePackage struct {
+	SellerID      string         `json:"sellerId"`
+	Method        string         `json:"method"`
+	ItemsCents    int64          `json:"itemsCents"`
+	ShippingCents int64          `json:"shippingCents"`
+	Lines         []optimizeLine `json:"lines"`
+}
+
+// optimizeShort is a card a JSON plan couldn't fully fill
+type optimizeShort struct {
+	PrintingID string `json:"printingId"`
+	Missing    int    `json:"missing"`
+}
+
+// optimizePlan is the JSON body returned by GetOptimizeJSON
+type optimizePlan struct {
+	Source        string            `json:"source"`
+	Country       string            `json:"country"`
+	Objective     string            `json:"objective"`
+	Exact         bool              `json:"exact"`
+	ElapsedMillis int64             `json:"elapsedMillis"`
+	ItemsCents    int64             `json:"itemsCents"`
+	ShippingCents int64             `json:"shippingCents"`
+	TotalCents    int64             `json:"totalCents"`
+	Total         string            `json:"total"`
+	Packages      []optimizePackage `json:"packages"`
+	Short         []optimizeShort   `json:"short"`
+}
+
+// optimizeParams reads the source, country, objective and condition for the optimizer from
+// query or form values, falling back to defaults for anything unknown
+func optimizeParams(v url.Values) (source string, req optimizer.Request) {
+	source = v.Get("source")
+	if source != optimizeFromWants && source != optimizeFromDeck {
+		source = optimizeFromCart
+	}
+	req.Country = strings.ToUpper(v.Get("country"))
+	if _, ok := shipping.Countries[req.Country]; !ok {
+		req.Country = shipping.DefaultCountry
+	}
+	req.Objective = optimizer.ObjectiveCost
+	if v.Get("objective") == optimizer.ObjectivePackages {
+		req.Objective = optimizer.ObjectivePackages
+	}
+	if c := v.Get("condition"); isCondition(c) {
+		req.MinCondition = c
+	}
+	return source, req
+}
+
+// cartNeeds turns the cart's lines into optimizer needs. Each line can be refilled by any copy
+// of its printing in the same language and finish; lines held for offers and auctions stay put.
+func (m *Repository) cartNeeds(ctx context.Context, c models.Cart) ([]optimizer.Need, error) {
+	index := map[string]int{}
+	var needs []optimizer.Need
+	for _, it := range c.Items {
+		if it.Reserved() {
+			continue
+		}
+		l, err := m.App.Catalog.Listing(ctx, it.ListingID)
+		if errors.Is(err, catalog.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key := fmt.Sprintf("%s/%s/%t", l.PrintingID, l.Language, l.Foil)
+		if i, ok := index[key]; ok {
+			needs[i].Quantity += it.Quantity
+			continue
+		}
+		language, foil := l.Language, l.Foil
+		index[key] = len(needs)
+		needs = append(needs, optimizer.Need{
+			Key:        key,
+			PrintingID: l.PrintingID,
+			Quantity:   it.Quantity,
+			Accept: func(l models.Listing) bool {
+				return l.Language == language && l.Foil == foil
+			},
+		})
+	}
+	return needs, nil
+}
+
+// deckNeeds turns the decklist held in the session into optimizer needs
+func (m *Repository) deckNeeds(ctx context.Context) []optimizer.Need {
+	entries, _ := m.App.Session.Get(ctx, "decklist").([]decklists.Entry)
+	needs := make([]optimizer.Need, 0, len(entries))
+	for _, e := range entries {
+		needs = append(needs, optimizer.Need{Key: e.PrintingID, PrintingID: e.PrintingID, Quantity: e.Quantity})
+	}
+	return needs
+}
+
+// solve runs the optimizer over the cart, the want list or the imported decklist
+func (m *Repository) solve(ctx context.Context, source string, req optimizer.Request) (optimizer.Plan, error) {
+	userID := m.App.Session.GetString(ctx, "user_id")
+	switch source {
+	case optimizeFromWants:
+		return m.App.Wants.Optimize(ctx, userID, req)
+	case optimizeFromDeck:
+		req.BuyerID = userID
+		req.Needs = m.deckNeeds(ctx)
+		return m.App.Optimizer.Solve(ctx, req)
+	}
+
+	c, err := m.loadCart(ctx)
+	if err != nil {
+		return optimizer.Plan{}, err
+	}
+	req.BuyerID = userID
+	req.Needs, err = m.cartNeeds(ctx, c)
+	if err != nil {
+		return optimizer.Plan{}, err
+	}
+	return m.App.Optimizer.Solve(ctx, req)
+}
+
+// optimizeErrorMessage turns an optimizer error into a message suitable for a toast
+func optimizeErrorMessage(err error) string {
+	if errors.Is(err, optimizer.ErrInvalid) {
+		_, msg, _ := strings.Cut(err.Error(), ": invalid request: ")
+		if msg == "" {
+			return "Please check the cards and try again."
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	}
+	return "Something went wrong. Please try again."
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetOptimize shows the cheapest way to buy the cart, the want list or a decklist, shipping included
+func (m *Repository) GetOptimize(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	source, req := optimizeParams(r.URL.Query())
+
+	plan, err := m.solve(ctx, source, req)
+	var message string
+	switch {
+	case errors.Is(err, optimizer.ErrInvalid):
+		message = optimizeErrorMessage(err)
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var printingIDs []string
+	for _, pick := range plan.Picks() {
+		printingIDs = append(printingIDs, pick.Need.PrintingID)
+	}
+	for _, short := range plan.Short {
+		printingIDs = append(printingIDs, short.Need.PrintingID)
+	}
+	printings, err := m.printingsFor(r, printingIDs)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "optimize.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{
+			"source":    source,
+			"country":   req.Country,
+			"objective": req.Objective,
+			"condition": req.MinCondition,
+			"message":   message,
+		},
+		Data: map[string]interface{}{
+			"Plan":       plan,
+			"Printings":  printings,
+			"Countries":  shipping.Countries,
+			"Conditions": models.Conditions,
+		},
+	})
+}
+
+// GetOptimizeJSON returns the optimizer's plan for the cart, the want list or a decklist as JSON
+func (m *Repository) GetOptimizeJSON(w http.ResponseWriter, r *http.Request) {
+	source, req := optimizeParams(r.URL.Query())
+
+	plan, err := m.solve(r.Context(), source, req)
+	if errors.Is(err, optimizer.ErrInvalid) {
+		m.writeJSON(w, http.StatusBadRequest, map[string]string{"error": optimizeErrorMessage(err)})
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	out := optimizePlan{
+		Source:        source,
+		Country:       plan.Country,
+		Objective:     plan.Objective,
+		Exact:         plan.Exact,
+		ElapsedMillis: plan.Elapsed.Milliseconds(),
+		ItemsCents:    plan.ItemsCents,
+		ShippingCents: plan.ShippingCents,
+		TotalCents:    plan.TotalCents,
+		Total:         money.Format(plan.TotalCents),
+		Packages:      []optimizePackage{},
+		Short:         []optimizeShort{},
+	}
+	for _, pkg := range plan.Packages {
+		p := optimizePackage{
+			SellerID:      pkg.SellerID,
+			Method:        pkg.Method,
+			ItemsCents:    pkg.ItemsCents,
+			ShippingCents: pkg.ShippingCents,
+		}
+		for _, pick := range pkg.Picks {
+			p.Lines = append(p.Lines, optimizeLine{
+				ListingID:  pick.Listing.ListingID,
+				PrintingID: pick.Listing.PrintingID,
+				Condition:  pick.Listing.Condition,
+				Quantity:   pick.Quantity,
+				PriceCents: pick.Listing.PriceCents,
+			})
+		}
+		out.Packages = append(out.Packages, p)
+	}
+	for _, short := range plan.Short {
+		out.Short = append(out.Short, optimizeShort{PrintingID: short.Need.PrintingID, Missing: short.Missing})
+	}
+	m.writeJSON(w, http.StatusOK, out)
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostOptimizeCart fills the cart with the optimizer's plan. Optimizing the cart replaces its
+// lines, apart from held offer and auction lines; optimizing the want list or a decklist adds to
+// the cart.
+func (m *Repository) PostOptimizeCart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("optimize form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+	source, req := optimizeParams(r.PostForm)
+	back := "/optimize?" + url.Values{
+		"source":    {source},
+		"country":   {req.Country},
+		"objective": {req.Objective},
+		"condition": {req.MinCondition},
+	}.Encode()
+
+	plan, err := m.solve(ctx, source, req)
+	if errors.Is(err, optimizer.ErrInvalid) {
+		m.App.Session.Put(ctx, "error", optimizeErrorMessage(err))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	picks := plan.Picks()
+	if len(picks) == 0 {
+		m.App.Session.Put(ctx, "warning", "None of those cards are for sale right now.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	c, err := m.loadCart(ctx)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if source == optimizeFromCart {
+		kept := c.Items[:0]
+		for _, it := range c.Items {
+			if it.Reserved() {
+				kept = append(kept, it)
+			}
+		}
+		c.Items = kept
+	}
+	for _, pick := range picks {
+		if err := m.App.Carts.Add(ctx, &c, pick.Listing.ListingID, pick.Quantity); err != nil {
+			m.App.InfoLog.Printf("optimized cart rejected: %v", err)
+			m.App.Session.Put(ctx, "error", cartErrorMessage(err))
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+	}
+	if err := m.saveCart(ctx, c); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(ctx, "flash", fmt.Sprintf("Cart filled from %d sellers for %s including shipping.",
+		len(plan.Packages), money.Format(plan.TotalCents)))
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
@@ -0,0 +1,483 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/shipping"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/tracking"
+)
+
+// orderFor loads the order in the URL and checks it belongs to the user in the given role.
+// It writes a 404 and returns false when the order is missing or belongs to someone else.
+func (m *Repository) orderFor(w http.ResponseWriter, r *http.Request, role string) (models.Order, bool) {
+	userID := m.App.Session.GetString(r.Context(), "user_id")
+
+	o, err := m.App.Orders.Get(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, orders.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return o, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return o, false
+	}
+
+	if (role == orders.ActorBuyer && o.BuyerID != userID) || (role == orders.ActorSeller && o.SellerID != userID) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return o, false
+	}
+	return o, true
+}
+
+// latestDispute returns an order's most recent dispute, or nil
+func (m *Repository) latestDispute(r *http.Request, orderID string) (*models.Dispute, error) {
+	list, err := m.App.Disputes.ForOrder(r.Context(), orderID)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// orderErrorMessage turns an order error into a message suitable for a toast
+func orderErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, orders.ErrInvalidTransition), errors.Is(err, orders.ErrNotAllowed):
+		return "That action isn't available for this order."
+	case errors.Is(err, orders.ErrConflict):
+		return "This order was just updated. Please try again."
+	case errors.Is(err, orders.ErrLabelInProgress):
+		return "A label is already being bought for this order. Please wait a moment and refresh."
+	case errors.Is(err, orders.ErrCartInvalid):
+		return "Your cart changed. Please review it before checking out."
+	case errors.Is(err, catalog.ErrInsufficientStock):
+		return "Sorry, an item in your cart just sold out."
+	case errors.Is(err, shipping.ErrNoMethod):
+		return "That shipping method isn't available for your order."
+	case errors.Is(err, tracking.ErrTrackingInUse):
+		return "That tracking number is already attached to another order."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// checkoutGroup is one seller's part of the cart with the shipping rates available for it
+type checkoutGroup struct {
+	Group    cart.SellerGroup
+	Rates    []shipping.Rate
+	Selected string
+}
+
+// checkoutView loads the cart for checkout. It redirects back to the cart and returns false
+// when the cart is empty or has unresolved issues.
+func (m *Repository) checkoutView(w http.ResponseWriter, r *http.Request) (models.Cart, cart.View, bool) {
+	ctx := r.Context()
+
+	c, err := m.loadCart(ctx)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return c, cart.View{}, false
+	}
+
+	view, err := m.App.Carts.Build(ctx, c)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return c, view, false
+	}
+	if !view.Valid() {
+		m.App.Session.Put(ctx, "error", orderErrorMessage(orders.ErrCartInvalid))
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return c, view, false
+	}
+	return c, view, true
+}
+
+// renderCheckout renders the address and shipping form with rates for the chosen country
+func (m *Repository) renderCheckout(w http.ResponseWriter, r *http.Request, form *forms.Form, view cart.View) {
+	ctx := r.Context()
+
+	country := form.Get("country")
+	if _, ok := shipping.Countries[country]; !ok {
+		country = shipping.DefaultCountry
+	}
+
+	groups := make([]checkoutGroup, 0, len(view.Groups))
+	for _, g := range view.Groups {
+		items := 0
+		for _, line := range g.Lines {
+			items += line.Item.Quantity
+		}
+		rates, err := m.App.Shipping.Rates(ctx, g.SellerID, items, g.SubtotalCents, country)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		selected := form.Get("shipping_" + g.SellerID)
+		if selected == "" && len(rates) > 0 {
+			selected = rates[0].Method.MethodID
+		}
+		groups = append(groups, checkoutGroup{Group: g, Rates: rates, Selected: selected})
+	}
+
+	render.Template(w, r, "checkout.page.tmpl", &models.TemplateData{
+		Form:      form,
+		StringMap: map[string]string{"country": country},
+		Data: map[string]interface{}{
+			"Cart":      view,
+			"Groups":    groups,
+			"Countries": shipping.Countries,
+		},
+	})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetCheckout is the shipping address and shipping method page
+func (m *Repository) GetCheckout(w http.ResponseWriter, r *http.Request) {
+	_, view, ok := m.checkoutView(w, r)
+	if !ok {
+		return
+	}
+	m.renderCheckout(w, r, forms.New(r.URL.Query()), view)
+}
+
+// GetBuyerOrders is the buyer's order history page handler
+func (m *Repository) GetBuyerOrders(w http.ResponseWriter, r *http.Request) {
+	list, err := m.App.Orders.ForBuyer(r.Context(), m.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "orders.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Orders": list,
+		},
+	})
+}
+
+// GetBuyerOrder is the buyer's order detail page handler
+func (m *Repository) GetBuyerOrder(w http.ResponseWriter, r *http.Request) {
+	o, ok := m.orderFor(w, r, orders.ActorBuyer)
+	if !ok {
+		return
+	}
+
+	events, err := m.App.Orders.Events(r.Context(), o.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	timeline, err := m.App.Tracking.Timeline(r.Context(), o.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	completesAt, _ := m.App.Tracking.CompletesAt(o)
+	dispute, err := m.latestDispute(r, o.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	review, err := m.orderReview(r, o.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "order.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Order":       o,
+			"Events":      events,
+			"Tracking":    timeline,
+			"CompletesAt": completesAt,
+			"Dispute":     dispute,
+			"CanDispute":  (dispute == nil || !dispute.Open()) && m.App.Disputes.CanOpen(r.Context(), o) == nil,
+			"Review":      review,
+			"CanReview":   review == nil && m.App.Reviews.CanReview(r.Context(), o, o.BuyerID) == nil,
+			"CanCancel":   orders.CanTransition(o.Status, models.OrderStatusCancelled, orders.ActorBuyer),
+			"CanReceive":  orders.CanTransition(o.Status, models.OrderStatusDelivered, orders.ActorBuyer),
+		},
+	})
+}
+
+// GetSellerOrders is the seller's incoming orders page handler
+func (m *Repository) GetSellerOrders(w http.ResponseWriter, r *http.Request) {
+	list, err := m.App.Orders.ForSeller(r.Context(), m.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "seller-orders.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Orders": list,
+		},
+	})
+}
+
+// GetSellerOrder is the seller's order detail page handler
+func (m *Repository) GetSellerOrder(w http.ResponseWriter, r *http.Request) {
+	o, ok := m.orderFor(w, r, orders.ActorSeller)
+	if !ok {
+		return
+	}
+
+	events, err := m.App.Orders.Events(r.Context(), o.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	timeline, err := m.App.Tracking.Timeline(r.Context(), o.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	completesAt, _ := m.App.Tracking.CompletesAt(o)
+	dispute, err := m.latestDispute(r, o.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	review, err := m.orderReview(r, o.OrderID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "seller-order.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Order":       o,
+			"Events":      events,
+			"Tracking":    timeline,
+			"CompletesAt": completesAt,
+			"Dispute":     dispute,
+			"Review":      review,
+			"CanRespond":  review != nil && review.Visible(),
+			"CanShip":     orders.CanTransition(o.Status, models.OrderStatusShipped, orders.ActorSeller),
+			"CanCancel":   orders.CanTransition(o.Status, models.OrderStatusCancelled, orders.ActorSeller),
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostCheckout re-validates the cart and turns it into one order per seller, shipped to the
+// given address with the chosen method for each seller
+func (m *Repository) PostCheckout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("checkout form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	c, view, ok := m.checkoutView(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "line1", "city", "postal_code", "country")
+	if _, known := shipping.Countries[form.Get("country")]; form.Has("country") && !known {
+		form.Errors.Add("country", "We don't ship to that country yet")
+	}
+
+	ship := orders.Shipment{
+		Address: models.Address{
+			Name:       strings.TrimSpace(form.Get("name")),
+			Line1:      strings.TrimSpace(form.Get("line1")),
+			Line2:      strings.TrimSpace(form.Get("line2")),
+			City:       strings.TrimSpace(form.Get("city")),
+			Region:     strings.TrimSpace(form.Get("region")),
+			PostalCode: strings.TrimSpace(form.Get("postal_code")),
+			Country:    form.Get("country"),
+		},
+		Methods: map[string]string{},
+	}
+	for _, g := range view.Groups {
+		field := "shipping_" + g.SellerID
+		form.Required(field)
+		ship.Methods[g.SellerID] = form.Get(field)
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderCheckout(w, r, form, view)
+		return
+	}
+
+	created, err := m.App.Orders.Checkout(ctx, userID, view, ship)
+	if err != nil {
+		m.App.InfoLog.Printf("checkout rejected for %s: %v", userID, err)
+		m.App.Session.Put(ctx, "error", orderErrorMessage(err))
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	c.Items = nil
+	if err := m.saveCart(ctx, c); err != nil {
+		m.App.ErrorLog.Printf("failed clearing cart for %s after checkout: %v", userID, err)
+	}
+
+	m.App.InfoLog.Printf("checkout by %s created %d orders", userID, len(created))
+	http.Redirect(w, r, "/checkout/"+created[0].CheckoutID+"/pay", http.StatusSeeOther)
+}
+
+// PostBuyerOrderCancel cancels an unpaid order
+func (m *Repository) PostBuyerOrderCancel(w http.ResponseWriter, r *http.Request) {
+	m.postOrderTransition(w, r, orders.ActorBuyer, models.OrderStatusCancelled, "cancelled by buyer", "Order cancelled.")
+}
+
+// PostBuyerOrderReceived lets the buyer confirm a shipped order arrived
+func (m *Repository) PostBuyerOrderReceived(w http.ResponseWriter, r *http.Request) {
+	m.postOrderTransition(w, r, orders.ActorBuyer, models.OrderStatusDelivered, "received by buyer", "Thanks for confirming delivery.")
+}
+
+// PostSellerOrderCancel cancels an order the seller cannot fulfil
+func (m *Repository) PostSellerOrderCancel(w http.ResponseWriter, r *http.Request) {
+	m.postOrderTransition(w, r, orders.ActorSeller, models.OrderStatusCancelled, "cancelled by seller", "Order cancelled.")
+}
+
+// PostSellerOrderShip marks a paid order as shipped, with an optional carrier and tracking number
+func (m *Repository) PostSellerOrderShip(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, ok := m.orderFor(w, r, orders.ActorSeller)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("ship form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	carrier := strings.TrimSpace(r.PostForm.Get("carrier"))
+	tracking := strings.TrimSpace(r.PostForm.Get("tracking_number"))
+	if tracking != "" {
+		if err := m.App.Tracking.Claim(ctx, o.OrderID, carrier, tracking); err != nil {
+			m.App.InfoLog.Printf("ship rejected for order %s: %v", o.OrderID, err)
+			m.App.Session.Put(ctx, "error", orderErrorMessage(err))
+			http.Redirect(w, r, "/seller/orders/"+o.OrderID, http.StatusSeeOther)
+			return
+		}
+	}
+	if _, err := m.App.Orders.Ship(ctx, o.OrderID, orders.Seller(o.SellerID), carrier, tracking); err != nil {
+		m.App.InfoLog.Printf("ship rejected for order %s: %v", o.OrderID, err)
+		m.App.Session.Put(ctx, "error", orderErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Order marked as shipped.")
+	}
+	http.Redirect(w, r, "/seller/orders/"+o.OrderID, http.StatusSeeOther)
+}
+
+// PostSellerOrderLabel buys postage for a paid order, charges it to the seller and marks the order
+// shipped with the label's tracking number
+func (m *Repository) PostSellerOrderLabel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, ok := m.orderFor(w, r, orders.ActorSeller)
+	if !ok {
+		return
+	}
+	redirect := "/seller/orders/" + o.OrderID
+
+	seller := orders.Seller(o.SellerID)
+
+	// the order is marked before buying, so a repeated or concurrent request can't buy a second
+	// label, and a label already bought by an earlier attempt is used instead of another
+	o, err := m.App.Orders.StartLabel(ctx, o.OrderID, seller)
+	if err != nil {
+		m.App.Session.Put(ctx, "error", orderErrorMessage(err))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	if o.Label == nil {
+		label, err := m.App.Shipping.BuyLabel(ctx, o)
+		if err != nil {
+			if _, aerr := m.App.Orders.AbortLabel(ctx, o.OrderID, seller); aerr != nil {
+				m.App.ErrorLog.Printf("clearing label purchase on order %s failed: %v", o.OrderID, aerr)
+			}
+			if errors.Is(err, shipping.ErrNoShipFrom) {
+				m.App.Session.Put(ctx, "error", "Add your ship-from address in shipping settings before buying labels.")
+			} else {
+				m.App.ErrorLog.Printf("label purchase for order %s failed: %v", o.OrderID, err)
+				m.App.Session.Put(ctx, "error", "We couldn't buy a label for this order. Please try again.")
+			}
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+		updated, err := m.App.Orders.SetLabel(ctx, o.OrderID, seller, label)
+		if err != nil {
+			m.App.ErrorLog.Printf("label %s bought but recording it on order %s failed: %v", label.LabelID, o.OrderID, err)
+			m.App.Session.Put(ctx, "error", orderErrorMessage(err))
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+		o = updated
+	}
+	label := *o.Label
+
+	// the order isn't shipped until the postage is charged, so trying again charges it
+	if err := m.App.Ledger.ChargeLabel(ctx, o, label, m.App.Payments.ReverseTransfer); err != nil {
+		m.App.ErrorLog.Printf("label %s bought but charging %d to seller %s failed: %v", label.LabelID, label.CostCents, o.SellerID, err)
+		m.App.Session.Put(ctx, "error", "Your label was bought but we couldn't record its cost. Please try again.")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	if _, err := m.App.Orders.ShipWithLabel(ctx, o.OrderID, seller, label); err != nil {
+		m.App.ErrorLog.Printf("label %s bought but shipping order %s failed: %v", label.LabelID, o.OrderID, err)
+		m.App.Session.Put(ctx, "error", orderErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Label purchased and order marked as shipped.")
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// postOrderTransition applies a status change on behalf of the signed-in buyer or seller
+func (m *Repository) postOrderTransition(w http.ResponseWriter, r *http.Request, role, to, reason, success string) {
+	ctx := r.Context()
+
+	o, ok := m.orderFor(w, r, role)
+	if !ok {
+		return
+	}
+
+	actor := orders.Buyer(o.BuyerID)
+	redirect := "/orders/" + o.OrderID
+	if role == orders.ActorSeller {
+		actor = orders.Seller(o.SellerID)
+		redirect = "/seller/orders/" + o.OrderID
+	}
+
+	if _, err := m.App.Orders.Transition(ctx, o.OrderID, to, actor, reason); err != nil {
+		m.App.InfoLog.Printf("transition to %s rejected for order %s: %v", to, o.OrderID, err)
+		m.App.Session.Put(ctx, "error", orderErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", success)
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
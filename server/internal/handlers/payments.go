@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/payments"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// maxWebhookBytes caps the size of an inbound webhook body
+const maxWebhookBytes = 64 << 10
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetCheckoutPay is the payment page for the orders created by a checkout
+func (m *Repository) GetCheckoutPay(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	pending, err := m.App.Payments.PrepareCheckout(ctx, userID, chi.URLParam(r, "id"))
+	if errors.Is(err, orders.ErrNotAllowed) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Printf("preparing payment for checkout %s failed: %v", chi.URLParam(r, "id"), err)
+		m.App.Session.Put(ctx, "error", "We couldn't start your payment. Please try again.")
+		http.Redirect(w, r, "/orders", http.StatusSeeOther)
+		return
+	}
+
+	if len(pending) == 0 {
+		http.Redirect(w, r, "/orders", http.StatusSeeOther)
+		return
+	}
+
+	var total int64
+	for _, p := range pending {
+		total += p.Order.TotalCents
+	}
+
+	_, fake := m.App.Payments.Provider().(*payments.FakeProvider)
+	render.Template(w, r, "checkout-pay.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{
+			"checkout_id":     chi.URLParam(r, "id"),
+			"publishable_key": m.App.StripeKey,
+		},
+		Data: map[string]interface{}{
+			"Payments":   pending,
+			"TotalCents": total,
+			"Simulated":  fake,
+		},
+	})
+}
+
+// GetCheckoutComplete is where the browser lands after confirming payment. It never marks orders
+// paid itself; that only happens when the verified payment webhook arrives.
+func (m *Repository) GetCheckoutComplete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	list, err := m.App.Orders.ForCheckout(ctx, chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	for _, o := range list {
+		if o.BuyerID != userID {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+	}
+
+	render.Template(w, r, "checkout-complete.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Orders": list,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostStripeWebhook receives signed Stripe events
+func (m *Repository) PostStripeWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.App.Payments.HandleWebhook(r.Context(), payload, r.Header.Get("Stripe-Signature"))
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		m.App.InfoLog.Printf("rejected stripe webhook: %v", err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// a non-2xx response makes Stripe redeliver the event later
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// PostCheckoutSimulate completes payment with the fake provider in development. It goes through
+// the same signed webhook path Stripe uses, so orders are still only marked paid by a verified event.
+func (m *Repository) PostCheckoutSimulate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	checkoutID := chi.URLParam(r, "id")
+
+	fake, ok := m.App.Payments.Provider().(*payments.FakeProvider)
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	pending, err := m.App.Payments.PrepareCheckout(ctx, m.App.Session.GetString(ctx, "user_id"), checkoutID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, p := range pending {
+		in, err := fake.Succeed(p.Order.PaymentIntent)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		payload, sig, err := payments.SimulatedSucceededEvent(in, m.App.Payments.WebhookSecret())
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if err := m.App.Payments.HandleWebhook(ctx, payload, sig); err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/checkout/"+checkoutID+"/complete", http.StatusSeeOther)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ledger"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/payments"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetSellerPayouts shows whether the seller's payout account is connected and what can be paid out
+func (m *Repository) GetSellerPayouts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	p, err := m.App.Sellers.Profile(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	balances, err := m.App.Ledger.SellerBalances(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "seller-payouts.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Profile":  p,
+			"Balances": balances,
+		},
+	})
+}
+
+// GetSellerPayoutsReturn is where the seller lands after the payment provider's onboarding. The
+// provider is asked for the account's state rather than trusting the redirect.
+func (m *Repository) GetSellerPayoutsReturn(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	ready, err := m.App.Payments.RefreshAccount(ctx, sellerID)
+	switch {
+	case err != nil:
+		m.App.ErrorLog.Printf("checking payout account of %s failed: %v", sellerID, err)
+		m.App.Session.Put(ctx, "error", "We couldn't check your payout account. Please try again.")
+	case ready:
+		m.App.Session.Put(ctx, "flash", "Payout account connected. You can start listing.")
+	default:
+		m.App.Session.Put(ctx, "warning", "Your payout account isn't finished yet. Continue setup to start listing.")
+	}
+	http.Redirect(w, r, "/seller/payouts", http.StatusSeeOther)
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostSellerPayoutsConnect sends the seller to the payment provider to set up their payout account
+func (m *Repository) PostSellerPayoutsConnect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	link, err := m.App.Payments.OnboardingLink(ctx, sellerID, m.App.BaseURL+"/seller/payouts", m.App.BaseURL+"/seller/payouts/return")
+	if err != nil {
+		m.App.ErrorLog.Printf("starting payout onboarding for %s failed: %v", sellerID, err)
+		m.App.Session.Put(ctx, "error", "We couldn't start setting up your payout account. Please try again.")
+		http.Redirect(w, r, "/seller/payouts", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, link, http.StatusSeeOther)
+}
+
+// PostSellerPayoutsWithdraw pays the seller's available balance out to their bank
+func (m *Repository) PostSellerPayoutsWithdraw(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	amount, err := m.App.Ledger.Payout(ctx, sellerID, m.App.Payments.Payout)
+	switch {
+	case errors.Is(err, ledger.ErrInsufficientFunds):
+		m.App.Session.Put(ctx, "error", "You have nothing available to pay out yet.")
+	case errors.Is(err, payments.ErrNoPayoutAccount):
+		m.App.Session.Put(ctx, "error", "Connect your payout account before paying out.")
+	case err != nil:
+		m.App.ErrorLog.Printf("payout for %s failed: %v", sellerID, err)
+		m.App.Session.Put(ctx, "error", "We couldn't pay out your balance. Please try again.")
+	default:
+		m.App.Session.Put(ctx, "flash", fmt.Sprintf("%s is on its way to your bank.", money.Format(amount)))
+	}
+	http.Redirect(w, r, "/seller/payouts", http.StatusSeeOther)
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// maxRecentSales caps the recent sales shown on a printing page
+const maxRecentSales = 10
+
+// priceGuideJSON is one condition's guide in the JSON price endpoint
+type priceGuideJSON struct {
+	Condition   string `json:"condition"` // "" for every raw condition, or a grade label such as "PSA 10"
+	Graded      bool   `json:"graded"`
+	MarketCents int64  `json:"marketCents"`
+	Market      string `json:"market"`
+	LowCents    int64  `json:"lowCents"`
+	MedianCents int64  `json:"medianCents"`
+	HighCents   int64  `json:"highCents"`
+	Sales       int    `json:"sales"`
+	Outliers    int    `json:"outliers"`
+	TrendBps    *int64 `json:"trendBps"` // null until there are sales in both trend periods
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+// pricePointJSON is one day of history in the JSON price endpoint
+type pricePointJSON struct {
+	Day         string `json:"day"`
+	MarketCents int64  `json:"marketCents"`
+	LowCents    int64  `json:"lowCents"`
+	MedianCents int64  `json:"medianCents"`
+	HighCents   int64  `json:"highCents"`
+	Sales       int    `json:"sales"`
+}
+
+// priceChart is a printing's or product's price history laid out for the chart on its page
+type priceChart struct {
+	Days   []string
+	Market []int64
+	Low    []int64
+	High   []int64
+}
+
+// newPriceChart lays out price history for the chart
+func newPriceChart(history []models.PricePoint) priceChart {
+	chart := priceChart{Days: []string{}, Market: []int64{}, Low: []int64{}, High: []int64{}}
+	for _, pt := range history {
+		chart.Days = append(chart.Days, pt.Day)
+		chart.Market = append(chart.Market, pt.MarketCents)
+		chart.Low = append(chart.Low, pt.LowCents)
+		chart.High = append(chart.High, pt.HighCents)
+	}
+	return chart
+}
+
+// pricesJSON converts price guides and history for the JSON price endpoints
+func pricesJSON(guides []models.PriceGuide, history []models.PricePoint) ([]priceGuideJSON, []pricePointJSON) {
+	gs, pts := []priceGuideJSON{}, []pricePointJSON{}
+	for _, g := range guides {
+		j := priceGuideJSON{
+			Condition:   g.Condition,
+			Graded:      models.IsGradeLabel(g.Condition),
+			MarketCents: g.MarketCents,
+			Market:      money.Format(g.MarketCents),
+			LowCents:    g.LowCents,
+			MedianCents: g.MedianCents,
+			HighCents:   g.HighCents,
+			Sales:       g.Sales,
+			Outliers:    g.Outliers,
+			UpdatedAt:   g.UpdatedAt,
+		}
+		if g.HasTrend {
+			trend := g.TrendBps
+			j.TrendBps = &trend
+		}
+		gs = append(gs, j)
+	}
+	for _, pt := range history {
+		pts = append(pts, pricePointJSON{
+			Day:         pt.Day,
+			MarketCents: pt.MarketCents,
+			LowCents:    pt.LowCents,
+			MedianCents: pt.MedianCents,
+			HighCents:   pt.HighCents,
+			Sales:       pt.Sales,
+		})
+	}
+	return gs, pts
+}
+
+// priceParams reads the condition or grade and number of history days for a printing's or
+// product's prices from the query, falling back to every raw condition and the full history
+func priceParams(r *http.Request) (condition string, days int) {
+	c := r.URL.Query().Get("condition")
+	if isCondition(c) || models.IsGradeLabel(c) || c == models.ConditionSealed || c == models.ConditionOpened {
+		condition = c
+	}
+	days, _ = strconv.Atoi(r.URL.Query().Get("days"))
+	return condition, days
+}
+
+// printingOr404 loads the printing named in the URL, writing a 404 when it doesn't exist. It
+// reports whether the caller should carry on.
+func (m *Repository) printingOr404(w http.ResponseWriter, r *http.Request) (models.Printing, bool) {
+	p, err := m.App.Catalog.Printing(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, catalog.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return p, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return p, false
+	}
+	return p, true
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetPrinting is a printing's page, with its price guide, price history chart, listings for sale
+// and recent sales
+func (m *Repository) GetPrinting(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p, ok := m.printingOr404(w, r)
+	if !ok {
+		return
+	}
+	condition, days := priceParams(r)
+
+	guides, err := m.App.Pricing.Guides(ctx, p.PrintingID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	history, err := m.App.Pricing.History(ctx, p.PrintingID, condition, days)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	sales, err := m.App.Pricing.RecentSales(ctx, p.PrintingID, maxRecentSales)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	all, err := m.App.Catalog.ListingsByPrinting(ctx, p.PrintingID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	var listings []models.Listing
+	for _, l := range all {
+		if l.IsActive() {
+			listings = append(listings, l)
+		}
+	}
+	sort.SliceStable(listings, func(i, j int) bool { return listings[i].PriceCents < listings[j].PriceCents })
+
+	var raw, graded []models.PriceGuide
+	var grades []string
+	for _, g := range guides {
+		if models.IsGradeLabel(g.Condition) {
+			graded = append(graded, g)
+			grades = append(grades, g.Condition)
+		} else {
+			raw = append(raw, g)
+		}
+	}
+
+	render.Template(w, r, "printing.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"condition": condition},
+		Data: map[string]interface{}{
+			"Printing":     p,
+			"Guides":       raw,
+			"GradedGuides": graded,
+			"Chart":        newPriceChart(history),
+			"Sales":        sales,
+			"Listings":     listings,
+			"Conditions":   models.Conditions,
+			"Grades":       grades,
+		},
+	})
+}
+
+// GetPrintingPricesJSON returns a printing's price guide in every condition and its price
+// history in one condition as JSON
+func (m *Repository) GetPrintingPricesJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p, ok := m.printingOr404(w, r)
+	if !ok {
+		return
+	}
+	condition, days := priceParams(r)
+
+	guides, err := m.App.Pricing.Guides(ctx, p.PrintingID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	history, err := m.App.Pricing.History(ctx, p.PrintingID, condition, days)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	out := struct {
+		PrintingID string           `json:"printingId"`
+		Condition  string           `json:"condition"`
+		Guides     []priceGuideJSON `json:"guides"`
+		History    []pricePointJSON `json:"history"`
+	}{PrintingID: p.PrintingID, Condition: condition}
+	out.Guides, out.History = pricesJSON(guides, history)
+	m.writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+)
+
+// productOr404 loads the sealed product named in the URL, writing a 404 when it doesn't exist. It
+// reports whether the caller should carry on.
+func (m *Repository) productOr404(w http.ResponseWriter, r *http.Request) (models.Product, bool) {
+	p, err := m.App.Catalog.Product(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, catalog.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return p, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return p, false
+	}
+	return p, true
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetProduct is a sealed product's page, with its contents, price guide, price history chart,
+// listings for sale and recent sales
+func (m *Repository) GetProduct(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p, ok := m.productOr404(w, r)
+	if !ok {
+		return
+	}
+	condition, days := priceParams(r)
+	if condition != "" && !p.AllowsCondition(condition) {
+		condition = ""
+	}
+
+	guides, err := m.App.Pricing.Guides(ctx, p.ProductID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	history, err := m.App.Pricing.History(ctx, p.ProductID, condition, days)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	sales, err := m.App.Pricing.RecentSales(ctx, p.ProductID, maxRecentSales)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	all, err := m.App.Catalog.ListingsByProduct(ctx, p.ProductID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	var listings []models.Listing
+	for _, l := range all {
+		if l.IsActive() {
+			listings = append(listings, l)
+		}
+	}
+	sort.SliceStable(listings, func(i, j int) bool { return listings[i].PriceCents < listings[j].PriceCents })
+
+	render.Template(w, r, "product.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{"condition": condition},
+		Data: map[string]interface{}{
+			"Product":    p,
+			"Guides":     guides,
+			"Chart":      newPriceChart(history),
+			"Sales":      sales,
+			"Listings":   listings,
+			"Conditions": p.Conditions(),
+		},
+	})
+}
+
+// GetProductPricesJSON returns a sealed product's price guide in every condition and its price
+// history in one condition as JSON
+func (m *Repository) GetProductPricesJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p, ok := m.productOr404(w, r)
+	if !ok {
+		return
+	}
+	condition, days := priceParams(r)
+	if condition != "" && !p.AllowsCondition(condition) {
+		condition = ""
+	}
+
+	guides, err := m.App.Pricing.Guides(ctx, p.ProductID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	history, err := m.App.Pricing.History(ctx, p.ProductID, condition, days)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	out := struct {
+		ProductID string           `json:"productId"`
+		Condition string           `json:"condition"`
+		Guides    []priceGuideJSON `json:"guides"`
+		History   []pricePointJSON `json:"history"`
+	}{ProductID: p.ProductID, Condition: condition}
+	out.Guides, out.History = pricesJSON(guides, history)
+	m.writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/repricing"
+)
+
+// maxRepriceRuns caps the runs listed on the repricing page
+const maxRepriceRuns = 20
+
+// repriceErrorMessage turns a repricing error into a message suitable for a toast
+func repriceErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, repricing.ErrInvalid):
+		_, msg, _ := strings.Cut(err.Error(), ": invalid rule: ")
+		if msg == "" {
+			return "Please check the rule and try again."
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, repricing.ErrTooMany):
+		return "You have as many rules as allowed. Delete one first."
+	case errors.Is(err, repricing.ErrNotFound):
+		return "That rule or run doesn't exist."
+	case errors.Is(err, repricing.ErrNothingToRollBack):
+		return "There are no applied price changes left to roll back."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// parsePercent reads a percentage such as "5" or "2.5" as basis points
+func parsePercent(s string) (int64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New("invalid percentage")
+	}
+	return int64(math.Round(f * 100)), nil
+}
+
+// renderRepricing renders the seller's repricing rules and recent runs
+func (m *Repository) renderRepricing(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	rules, err := m.App.Repricing.Rules(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	runs, err := m.App.Repricing.History(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(runs) > maxRepriceRuns {
+		runs = runs[:maxRepriceRuns]
+	}
+
+	render.Template(w, r, "seller-repricing.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: map[string]interface{}{
+			"Rules": rules,
+			"Runs":  runs,
+			"Kinds": models.RepriceKinds,
+		},
+	})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetSellerRepricing lists the seller's repricing rules and runs, with a form for a new rule
+func (m *Repository) GetSellerRepricing(w http.ResponseWriter, r *http.Request) {
+	m.renderRepricing(w, r, forms.New(nil))
+}
+
+// GetSellerRepriceRun shows a repricing run's report
+func (m *Repository) GetSellerRepriceRun(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	run, err := m.App.Repricing.Report(ctx, sellerID, chi.URLParam(r, "id"))
+	if errors.Is(err, repricing.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rules, err := m.App.Repricing.Rules(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	names := map[string]string{}
+	for _, rule := range rules {
+		names[rule.RuleID] = rule.Name
+	}
+
+	render.Template(w, r, "seller-repricing-run.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Run":       run,
+			"RuleNames": names,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostSellerRepriceRule adds a repricing rule. New rules start disabled.
+func (m *Repository) PostSellerRepriceRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("repricing rule form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("kind")
+
+	rule := models.RepriceRule{
+		SellerID: m.App.Session.GetString(ctx, "user_id"),
+		Name:     form.Get("name"),
+		Kind:     form.Get("kind"),
+		SetCode:  form.Get("set_code"),
+		Rarity:   form.Get("rarity"),
+		Tag:      form.Get("tag"),
+	}
+	switch rule.Kind {
+	case models.RepriceMarketMinus:
+		bps, err := parsePercent(form.Get("percent"))
+		if err != nil || bps < 0 || bps >= 10000 {
+			form.Errors.Add("percent", "Enter a discount such as 5 for 5%")
+		}
+		rule.PercentBps = bps
+	case models.RepriceUndercut, models.RepriceFloor:
+		if form.Has("amount") {
+			cents, err := money.Parse(form.Get("amount"))
+			if err != nil || cents < 0 {
+				form.Errors.Add("amount", "Enter an amount such as 0.01")
+			}
+			rule.AmountCents = cents
+		} else if rule.Kind == models.RepriceFloor {
+			form.Errors.Add("amount", "Enter the lowest price to allow")
+		}
+	case "":
+	default:
+		form.Errors.Add("kind", "Choose a kind of rule")
+	}
+	if form.Has("priority") {
+		p, err := strconv.Atoi(form.Get("priority"))
+		if err != nil {
+			form.Errors.Add("priority", "Enter a whole number")
+		}
+		rule.Priority = p
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderRepricing(w, r, form)
+		return
+	}
+
+	rule, err := m.App.Repricing.AddRule(ctx, rule)
+	if errors.Is(err, repricing.ErrInvalid) || errors.Is(err, repricing.ErrTooMany) {
+		m.App.Session.Put(ctx, "error", repriceErrorMessage(err))
+		http.Redirect(w, r, "/seller/repricing", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.InfoLog.Printf("repricing rule %s added by %s", rule.RuleID, rule.SellerID)
+	m.App.Session.Put(ctx, "flash", "Rule added. Preview it, then enable it to start repricing.")
+	http.Redirect(w, r, "/seller/repricing", http.StatusSeeOther)
+}
+
+// PostSellerRepriceRuleToggle enables or disables a repricing rule
+func (m *Repository) PostSellerRepriceRuleToggle(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("repricing toggle form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+	enable := r.PostForm.Get("enabled") == "1"
+
+	rule, err := m.App.Repricing.SetEnabled(ctx, m.App.Session.GetString(ctx, "user_id"), chi.URLParam(r, "id"), enable)
+	switch {
+	case errors.Is(err, repricing.ErrNotFound):
+		m.App.Session.Put(ctx, "error", repriceErrorMessage(err))
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	case rule.Enabled:
+		m.App.Session.Put(ctx, "flash", "Rule enabled. It will apply on the next scheduled run.")
+	default:
+		m.App.Session.Put(ctx, "flash", "Rule disabled.")
+	}
+	http.Redirect(w, r, "/seller/repricing", http.StatusSeeOther)
+}
+
+// PostSellerRepriceRuleDelete deletes a repricing rule
+func (m *Repository) PostSellerRepriceRuleDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	err := m.App.Repricing.DeleteRule(ctx, m.App.Session.GetString(ctx, "user_id"), chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, repricing.ErrNotFound):
+		m.App.Session.Put(ctx, "error", repriceErrorMessage(err))
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.Session.Put(ctx, "flash", "Rule deleted.")
+	}
+	http.Redirect(w, r, "/seller/repricing", http.StatusSeeOther)
+}
+
+// PostSellerRepricePreview records a dry run of the enabled rules, and of the rule named in the
+// form even if it's disabled, and shows its report
+func (m *Repository) PostSellerRepricePreview(w http.ResponseWriter, r *http.Request) {
+	m.reprice(w, r, true)
+}
+
+// PostSellerRepriceApply applies the enabled rules now rather than waiting for the schedule
+func (m *Repository) PostSellerRepriceApply(w http.ResponseWriter, r *http.Request) {
+	m.reprice(w, r, false)
+}
+
+// reprice runs the seller's rules and redirects to the run's report
+func (m *Repository) reprice(w http.ResponseWriter, r *http.Request, dryRun bool) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("repricing form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+	include := ""
+	if dryRun {
+		include = r.PostForm.Get("rule_id")
+	}
+
+	run, err := m.App.Repricing.Reprice(ctx, m.App.Session.GetString(ctx, "user_id"), dryRun, repricing.TriggerSeller, include)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if dryRun {
+		m.App.Session.Put(ctx, "flash", fmt.Sprintf("Preview ready: %d listings would change.", len(run.Changes)))
+	} else {
+		m.App.InfoLog.Printf("repricing run %s applied by %s: %d changes", run.RunID, run.SellerID, len(run.Changes))
+		m.App.Session.Put(ctx, "flash", fmt.Sprintf("Repriced %d listings.", len(run.Changes)))
+	}
+	http.Redirect(w, r, "/seller/repricing/runs/"+run.RunID, http.StatusSeeOther)
+}
+
+// PostSellerRepriceRollback reverts the last applied run
+func (m *Repository) PostSellerRepriceRollback(w http.ResponseWriter, r *http.Request) {
+	m.rollback(w, r, "")
+}
+
+// PostSellerRepriceRuleRollback reverts one rule's changes in the last applied run that has any
+func (m *Repository) PostSellerRepriceRuleRollback(w http.ResponseWriter, r *http.Request) {
+	m.rollback(w, r, chi.URLParam(r, "id"))
+}
+
+// rollback reverts the last run, or one rule's part of it, and redirects to the run's report
+func (m *Repository) rollback(w http.ResponseWriter, r *http.Request, ruleID string) {
+	ctx := r.Context()
+	sellerID := m.App.Session.GetString(ctx, "user_id")
+
+	run, reverted, err := m.App.Repricing.Rollback(ctx, sellerID, ruleID)
+	if errors.Is(err, repricing.ErrNothingToRollBack) {
+		m.App.Session.Put(ctx, "warning", repriceErrorMessage(err))
+		http.Redirect(w, r, "/seller/repricing", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.InfoLog.Printf("repricing run %s rolled back by %s: %d listings", run.RunID, sellerID, reverted)
+	m.App.Session.Put(ctx, "flash", fmt.Sprintf("Restored the previous price on %d listings.", reverted))
+	http.Redirect(w, r, "/seller/repricing/runs/"+run.RunID, http.StatusSeeOther)
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/reviews"
+)
+
+// reviewErrorMessage turns a review error into a message suitable for a toast
+func reviewErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, reviews.ErrAlreadyReviewed):
+		return "You've already reviewed this order."
+	case errors.Is(err, reviews.ErrNotReviewable):
+		return "You can review this order once it's complete."
+	case errors.Is(err, reviews.ErrInvalid):
+		_, msg, _ := strings.Cut(err.Error(), ": invalid review: ")
+		if msg == "" {
+			return "Please check the form and try again."
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, reviews.ErrNotFound), errors.Is(err, reviews.ErrNotAllowed):
+		return "That action isn't available for this review."
+	case errors.Is(err, reviews.ErrConflict):
+		return "The review was just updated. Please try again."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// orderReview returns an order's review, or nil if it has none
+func (m *Repository) orderReview(r *http.Request, orderID string) (*models.Review, error) {
+	rv, err := m.App.Reviews.ForOrder(r.Context(), orderID)
+	if errors.Is(err, reviews.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// renderReviewNew renders the review form for an order
+func (m *Repository) renderReviewNew(w http.ResponseWriter, r *http.Request, form *forms.Form, o models.Order) {
+	render.Template(w, r, "review-new.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: map[string]interface{}{
+			"Order": o,
+			"Stars": []int{5, 4, 3, 2, 1},
+		},
+	})
+}
+
+// GetOrderReview is the page for reviewing the seller of a completed order
+func (m *Repository) GetOrderReview(w http.ResponseWriter, r *http.Request) {
+	o, ok := m.orderFor(w, r, orders.ActorBuyer)
+	if !ok {
+		return
+	}
+
+	if err := m.App.Reviews.CanReview(r.Context(), o, o.BuyerID); err != nil {
+		m.App.Session.Put(r.Context(), "error", reviewErrorMessage(err))
+		http.Redirect(w, r, "/orders/"+o.OrderID, http.StatusSeeOther)
+		return
+	}
+
+	m.renderReviewNew(w, r, forms.New(nil), o)
+}
+
+// GetSellerReviews is a seller's public page of scores and reviews
+func (m *Repository) GetSellerReviews(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sellerID := chi.URLParam(r, "id")
+
+	profile, err := m.App.Sellers.Profile(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	score, err := m.App.Reviews.Score(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	list, err := m.App.Reviews.ForSeller(ctx, sellerID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "seller-reviews.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{
+			"user_id": m.App.Session.GetString(ctx, "user_id"),
+		},
+		Data: map[string]interface{}{
+			"Profile": profile,
+			"Score":   score,
+			"Reviews": list,
+		},
+	})
+}
+
+// GetAdminReviews is the admin moderation queue of reported reviews
+func (m *Repository) GetAdminReviews(w http.ResponseWriter, r *http.Request) {
+	queue, err := m.App.Reviews.Queue(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	hidden, err := m.App.Reviews.Hidden(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	render.Template(w, r, "admin-reviews.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Queue":  queue,
+			"Hidden": hidden,
+		},
+	})
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostOrderReview records the buyer's ratings and review of a completed order
+func (m *Repository) PostOrderReview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, ok := m.orderFor(w, r, orders.ActorBuyer)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	for _, field := range []string{"item_rating", "shipping_rating", "communication_rating"} {
+		if stars := form.GetInt(field); stars < 1 || stars > 5 {
+			form.Errors.Add(field, "Choose from 1 to 5 stars")
+		}
+	}
+	if len(strings.TrimSpace(form.Get("body"))) > reviews.MaxBodyLength {
+		form.Errors.Add("body", "Keep your review under 2000 characters")
+	}
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderReviewNew(w, r, form, o)
+		return
+	}
+
+	_, err := m.App.Reviews.Submit(ctx, o, o.BuyerID, reviews.Ratings{
+		Item:          form.GetInt("item_rating"),
+		Shipping:      form.GetInt("shipping_rating"),
+		Communication: form.GetInt("communication_rating"),
+		Body:          form.Get("body"),
+	})
+	if err != nil {
+		m.App.InfoLog.Printf("review of order %s rejected: %v", o.OrderID, err)
+		m.App.Session.Put(ctx, "error", reviewErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Thanks for your review!")
+	}
+	http.Redirect(w, r, "/orders/"+o.OrderID, http.StatusSeeOther)
+}
+
+// PostSellerReviewResponse sets the seller's public reply to the review of one of their orders
+func (m *Repository) PostSellerReviewResponse(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	o, ok := m.orderFor(w, r, orders.ActorSeller)
+	if !ok {
+		return
+	}
+
+	if _, err := m.App.Reviews.Respond(ctx, o.OrderID, o.SellerID, r.FormValue("response")); err != nil {
+		m.App.InfoLog.Printf("response to review of order %s rejected: %v", o.OrderID, err)
+		m.App.Session.Put(ctx, "error", reviewErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Your reply has been posted.")
+	}
+	http.Redirect(w, r, "/seller/orders/"+o.OrderID, http.StatusSeeOther)
+}
+
+// PostReviewReport flags a review as abusive for the moderation queue
+func (m *Repository) PostReviewReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	rv, err := m.App.Reviews.Report(ctx, chi.URLParam(r, "id"), orders.Buyer(userID), r.FormValue("reason"))
+	if errors.Is(err, reviews.ErrNotFound) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		m.App.InfoLog.Printf("report of review %s rejected: %v", rv.OrderID, err)
+		m.App.Session.Put(ctx, "error", reviewErrorMessage(err))
+	} else {
+		m.App.Session.Put(ctx, "flash", "Thanks, the review has been reported to the marketplace team.")
+	}
+	http.Redirect(w, r, "/sellers/"+rv.SellerID, http.StatusSeeOther)
+}
+
+// PostAdminReviewModerate hides a reported review or keeps it published
+func (m *Repository) PostAdminReviewModerate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	actor := orders.Admin(m.App.Session.GetString(ctx, "user_id"))
+	orderID := chi.URLParam(r, "id")
+
+	hide := r.FormValue("action") == "hide"
+	if _, err := m.App.Reviews.Moderate(ctx, orderID, actor, hide, r.FormValue("note")); err != nil {
+		m.App.ErrorLog.Printf("moderating review of order %s failed: %v", orderID, err)
+		m.App.Session.Put(ctx, "error", reviewErrorMessage(err))
+	} else if hide {
+		m.App.Session.Put(ctx, "flash", "The review has been hidden.")
+	} else {
+		m.App.Session.Put(ctx, "flash", "The review has been kept.")
+	}
+	http.Redirect(w, r, "/admin/reviews", http.StatusSeeOther)
+}
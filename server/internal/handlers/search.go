@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/search"
+)
+
+// GetSearch is the marketplace search page handler
+func (m *Repository) GetSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	result := m.App.Search.Search(search.ParseQuery(params))
+
+	// selected lets the template re-check the facet boxes the buyer already picked
+	facetNames := []string{"category", "product_type", "game", "set", "rarity", "condition", "language", "foil", "graded", "grader", "grade"}
+	selected := map[string]map[string]bool{}
+	for _, name := range facetNames {
+		selected[name] = map[string]bool{}
+		for _, v := range params[name] {
+			selected[name][v] = true
+		}
+	}
+
+	// ratingCounts keys the seller rating facet by the min_rating value that selects it
+	ratingCounts := map[string]int{}
+	for _, fc := range result.Facets["seller_rating"] {
+		ratingCounts[strings.TrimSuffix(fc.Value, "-plus")] = fc.Count
+	}
+
+	var nextPage string
+	if result.NextCursor != "" {
+		next := url.Values{}
+		for k, v := range params {
+			next[k] = v
+		}
+		next.Set("cursor", result.NextCursor)
+		nextPage = "/search?" + next.Encode()
+	}
+
+	render.Template(w, r, "search.page.tmpl", &models.TemplateData{
+		StringMap: map[string]string{
+			"q":          params.Get("q"),
+			"sort":       params.Get("sort"),
+			"min_price":  params.Get("min_price"),
+			"max_price":  params.Get("max_price"),
+			"min_rating": params.Get("min_rating"),
+			"next_page":  nextPage,
+		},
+		Data: map[string]interface{}{
+			"Result":       result,
+			"Selected":     selected,
+			"FacetNames":   facetNames,
+			"RatingCounts": ratingCounts,
+			"RatingStars":  []string{"4", "3", "2"},
+		},
+	})
+}
+
+// GetSearchJSON returns search results, facets and the next cursor as JSON
+func (m *Repository) GetSearchJSON(w http.ResponseWriter, r *http.Request) {
+	result := m.App.Search.Search(search.ParseQuery(r.URL.Query()))
+	m.writeJSON(w, http.StatusOK, result)
+}
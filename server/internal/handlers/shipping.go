@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/shipping"
+)
+
+// renderShippingProfile renders the shipping settings form, with one blank row for a new method
+func (m *Repository) renderShippingProfile(w http.ResponseWriter, r *http.Request, form *forms.Form, p models.ShippingProfile) {
+	methods := append(p.Methods, models.ShippingMethod{Service: models.ShippingServiceBubble, Zone: models.ShippingZoneDomestic})
+
+	render.Template(w, r, "seller-shipping.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: map[string]interface{}{
+			"Profile":   p,
+			"Methods":   methods,
+			"Countries": shipping.Countries,
+			"Services":  []string{models.ShippingServicePWE, models.ShippingServiceBubble, models.ShippingServiceBox},
+			"Zones":     []string{models.ShippingZoneDomestic, models.ShippingZoneInternational},
+		},
+	})
+}
+
+// parseShippingMethods reads the method rows of the shipping settings form. Rows are submitted as
+// parallel lists; rows without a name are dropped so sellers can delete a method by clearing it.
+func parseShippingMethods(form *forms.Form) ([]models.ShippingMethod, error) {
+	ids := form.Values["method_id"]
+	names := form.Values["method_name"]
+	field := func(name string, i int) string {
+		if vals := form.Values[name]; i < len(vals) {
+			return strings.TrimSpace(vals[i])
+		}
+		return ""
+	}
+	cents := func(name string, i int) (int64, error) {
+		v := field(name, i)
+		if v == "" {
+			return 0, nil
+		}
+		return money.Parse(v)
+	}
+
+	var methods []models.ShippingMethod
+	for i := range names {
+		name := strings.TrimSpace(names[i])
+		if name == "" {
+			continue
+		}
+
+		meth := models.ShippingMethod{
+			Name:    name,
+			Service: field("method_service", i),
+			Zone:    field("method_zone", i),
+		}
+		if i < len(ids) {
+			meth.MethodID = ids[i]
+		}
+
+		var err error
+		if meth.BaseCents, err = cents("method_base", i); err != nil {
+			return nil, fmt.Errorf("%s: base cost %w", name, err)
+		}
+		if meth.PerItemCents, err = cents("method_per_item", i); err != nil {
+			return nil, fmt.Errorf("%s: per card cost %w", name, err)
+		}
+		if meth.FreeOverCents, err = cents("method_free_over", i); err != nil {
+			return nil, fmt.Errorf("%s: free shipping threshold %w", name, err)
+		}
+		if v := field("method_max_items", i); v != "" {
+			if meth.MaxItems, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("%s: maximum cards must be a whole number", name)
+			}
+		}
+		methods = append(methods, meth)
+	}
+	return methods, nil
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetSellerShipping is the seller's shipping settings page
+func (m *Repository) GetSellerShipping(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	p, err := m.App.Shipping.Profile(ctx, m.App.Session.GetString(ctx, "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.renderShippingProfile(w, r, forms.New(nil), p)
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostSellerShipping saves the seller's ship-from address and shipping methods
+func (m *Repository) PostSellerShipping(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("shipping form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	p := models.ShippingProfile{
+		SellerID: m.App.Session.GetString(ctx, "user_id"),
+		ShipFrom: models.Address{
+			Name:       strings.TrimSpace(form.Get("from_name")),
+			Line1:      strings.TrimSpace(form.Get("from_line1")),
+			Line2:      strings.TrimSpace(form.Get("from_line2")),
+			City:       strings.TrimSpace(form.Get("from_city")),
+			Region:     strings.TrimSpace(form.Get("from_region")),
+			PostalCode: strings.TrimSpace(form.Get("from_postal_code")),
+			Country:    form.Get("from_country"),
+		},
+	}
+	if _, ok := shipping.Countries[p.ShipFrom.Country]; !ok {
+		form.Errors.Add("from_country", "Choose a country")
+	}
+
+	methods, err := parseShippingMethods(form)
+	if err != nil {
+		form.Errors.Add("methods", err.Error())
+	}
+	p.Methods = methods
+
+	if form.Valid() {
+		_, err = m.App.Shipping.SaveProfile(ctx, p)
+		if errors.Is(err, shipping.ErrInvalidProfile) {
+			form.Errors.Add("methods", strings.TrimPrefix(err.Error(), "shipping: invalid profile: "))
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderShippingProfile(w, r, form, p)
+		return
+	}
+
+	m.App.Session.Put(ctx, "flash", "Shipping settings saved.")
+	http.Redirect(w, r, "/seller/shipping", http.StatusSeeOther)
+}
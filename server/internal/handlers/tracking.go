@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/tracking"
+)
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostTrackingWebhook receives signed EasyPost tracker events
+func (m *Repository) PostTrackingWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBytes))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.App.Tracking.HandleWebhook(r.Context(), payload, r.Header.Get("X-Hmac-Signature"))
+	if errors.Is(err, tracking.ErrInvalidSignature) {
+		m.App.InfoLog.Printf("rejected tracking webhook: %v", err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// a non-2xx response makes the carrier redeliver the update later
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/forms"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/helpers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/render"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/wants"
+)
+
+// maxWantMatches caps the recent matches shown on the want list page
+const maxWantMatches = 20
+
+// wantRow pairs a want with the printing it is for, for display
+type wantRow struct {
+	Want     models.Want
+	Printing models.Printing
+}
+
+// wantMatchRow pairs a want match with the printing it is for, for display
+type wantMatchRow struct {
+	Match    models.WantMatch
+	Printing models.Printing
+}
+
+// printingsFor loads the printings with the given IDs, skipping any that no longer exist
+func (m *Repository) printingsFor(r *http.Request, printingIDs []string) (map[string]models.Printing, error) {
+	out := map[string]models.Printing{}
+	for _, id := range printingIDs {
+		if _, ok := out[id]; ok {
+			continue
+		}
+		p, err := m.App.Catalog.Printing(r.Context(), id)
+		if errors.Is(err, catalog.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[id] = p
+	}
+	return out, nil
+}
+
+// wantErrorMessage turns a wants error into a message suitable for a toast
+func wantErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, wants.ErrInvalid):
+		_, msg, _ := strings.Cut(err.Error(), ": invalid want: ")
+		if msg == "" {
+			return "Please check the want and try again."
+		}
+		return strings.ToUpper(msg[:1]) + msg[1:] + "."
+	case errors.Is(err, wants.ErrTooMany):
+		return "Your want list is full. Remove something first."
+	case errors.Is(err, wants.ErrNotFound):
+		return "That card isn't on your want list."
+	default:
+		return "Something went wrong. Please try again."
+	}
+}
+
+// renderWantForm renders the add-to-want-list form for a printing, or a printing search when none is chosen
+func (m *Repository) renderWantForm(w http.ResponseWriter, r *http.Request, form *forms.Form, printingID, q string) {
+	ctx := r.Context()
+	data := map[string]interface{}{
+		"Conditions":      models.Conditions,
+		"FoilPreferences": models.FoilPreferences,
+	}
+
+	if printingID != "" {
+		p, err := m.App.Catalog.Printing(ctx, printingID)
+		if err != nil {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		data["Printing"] = p
+	} else if q = strings.TrimSpace(q); q != "" {
+		matches, err := m.findPrintings(ctx, q)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["Matches"] = matches
+	}
+
+	render.Template(w, r, "want-new.page.tmpl", &models.TemplateData{
+		Form:      form,
+		StringMap: map[string]string{"q": q},
+		Data:      data,
+	})
+}
+
+// ////////////////////////////////////////////////////////////
+// /////////////////// GET REQUESTS ///////////////////////////
+// ////////////////////////////////////////////////////////////
+
+// GetWants is the buyer's want list page, with recent matching listings
+func (m *Repository) GetWants(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := m.App.Session.GetString(ctx, "user_id")
+
+	list, err := m.App.Wants.ForUser(ctx, userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	matches, err := m.App.Wants.Matches(ctx, userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(matches) > maxWantMatches {
+		matches = matches[:maxWantMatches]
+	}
+
+	var printingIDs []string
+	for _, want := range list {
+		printingIDs = append(printingIDs, want.PrintingID)
+	}
+	for _, match := range matches {
+		printingIDs = append(printingIDs, match.PrintingID)
+	}
+	printings, err := m.printingsFor(r, printingIDs)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rows := make([]wantRow, 0, len(list))
+	for _, want := range list {
+		rows = append(rows, wantRow{Want: want, Printing: printings[want.PrintingID]})
+	}
+	matchRows := make([]wantMatchRow, 0, len(matches))
+	for _, match := range matches {
+		matchRows = append(matchRows, wantMatchRow{Match: match, Printing: printings[match.PrintingID]})
+	}
+
+	render.Template(w, r, "wants.page.tmpl", &models.TemplateData{
+		Data: map[string]interface{}{
+			"Wants":   rows,
+			"Matches": matchRows,
+		},
+	})
+}
+
+// GetWantNew is the form for adding a printing to the want list
+func (m *Repository) GetWantNew(w http.ResponseWriter, r *http.Request) {
+	m.renderWantForm(w, r, forms.New(nil), r.URL.Query().Get("printing_id"), r.URL.Query().Get("q"))
+}
+
+// /////////////////////////////////////////////////////////////
+// /////////////////// POST REQUESTS ///////////////////////////
+// /////////////////////////////////////////////////////////////
+
+// PostWant adds a printing to the want list
+func (m *Repository) PostWant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		m.App.ErrorLog.Printf("want form parse failed: %v", err)
+		http.Error(w, "invalid form submission", http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("printing_id", "quantity")
+
+	qty, err := strconv.Atoi(form.Get("quantity"))
+	if form.Has("quantity") && (err != nil || qty <= 0) {
+		form.Errors.Add("quantity", "Enter how many copies you want")
+	}
+	var maxPrice int64
+	if form.Has("max_price") {
+		maxPrice, err = money.Parse(form.Get("max_price"))
+		if err != nil || maxPrice <= 0 {
+			form.Errors.Add("max_price", "Enter a price such as 4.99, or leave it empty for any price")
+		}
+	}
+	condition := form.Get("min_condition")
+	if condition != "" && !isCondition(condition) {
+		form.Errors.Add("min_condition", "Choose a condition")
+	}
+	printingID := form.Get("printing_id")
+	if _, err := m.App.Catalog.Printing(ctx, printingID); form.Has("printing_id") && err != nil {
+		form.Errors.Add("printing_id", "Choose a card")
+		printingID = ""
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderWantForm(w, r, form, printingID, "")
+		return
+	}
+
+	want, err := m.App.Wants.Add(ctx, models.Want{
+		UserID:        m.App.Session.GetString(ctx, "user_id"),
+		PrintingID:    printingID,
+		Quantity:      qty,
+		MaxPriceCents: maxPrice,
+		MinCondition:  condition,
+		Language:      strings.TrimSpace(form.Get("language")),
+		Foil:          form.Get("foil"),
+	})
+	if err != nil {
+		if errors.Is(err, wants.ErrInvalid) || errors.Is(err, wants.ErrTooMany) {
+			m.App.Session.Put(ctx, "error", wantErrorMessage(err))
+			http.Redirect(w, r, "/wants", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.InfoLog.Printf("want %s added by %s", want.WantID, want.UserID)
+	m.App.Session.Put(ctx, "flash", "Added to your want list.")
+	http.Redirect(w, r, "/wants", http.StatusSeeOther)
+}
+
+// PostWantRemove takes a printing off the want list
+func (m *Repository) PostWantRemove(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	err := m.App.Wants.Remove(ctx, m.App.Session.GetString(ctx, "user_id"), chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, wants.ErrNotFound):
+		m.App.Session.Put(ctx, "error", wantErrorMessage(err))
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.App.Session.Put(ctx, "flash", "Removed from your want list.")
+	}
+	http.Redirect(w, r, "/wants", http.StatusSeeOther)
+}
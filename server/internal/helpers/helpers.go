@@ -33,3 +33,8 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// IsAdmin is a helper that checks if the signed-in user is one of the configured admins
+func IsAdmin(r *http.Request) bool {
+	return app.Admins[app.Session.GetString(r.Context(), "user_id")]
+}
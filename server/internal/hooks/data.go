@@ -0,0 +1,153 @@
+package hooks
+
+import "github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+
+// The types below are what sellers' systems see of orders, listings and offers, in webhook
+// payloads and from the seller API. They're kept apart from the stored models so internal
+// fields (keys, payment references, fee schedule versions) never leak and can change freely.
+
+// Order is an order as sellers' systems see it
+type Order struct {
+	ID             string      `json:"id"`
+	Status         string      `json:"status"`
+	BuyerID        string      `json:"buyer_id"`
+	Items          []OrderItem `json:"items"`
+	SubtotalCents  int64       `json:"subtotal_cents"`
+	ShippingCents  int64       `json:"shipping_cents"`
+	TotalCents     int64       `json:"total_cents"`
+	FeeCents       int64       `json:"fee_cents"`
+	ShipTo         Address     `json:"ship_to"`
+	ShippingMethod string      `json:"shipping_method"`
+	Carrier        string      `json:"carrier,omitempty"`
+	TrackingNumber string      `json:"tracking_number,omitempty"`
+	DeliveredAt    string      `json:"delivered_at,omitempty"`
+	CreatedAt      string      `json:"created_at"`
+	UpdatedAt      string      `json:"updated_at"`
+}
+
+// OrderItem is a line of an Order
+type OrderItem struct {
+	ListingID      string `json:"listing_id"`
+	Name           string `json:"name"`
+	SetName        string `json:"set_name,omitempty"`
+	Condition      string `json:"condition,omitempty"`
+	Language       string `json:"language,omitempty"`
+	Foil           bool   `json:"foil"`
+	Quantity       int    `json:"quantity"`
+	UnitPriceCents int64  `json:"unit_price_cents"`
+}
+
+// Address is where an Order ships to
+type Address struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+// Listing is a listing as sellers' systems see it
+type Listing struct {
+	ID            string   `json:"id"`
+	PrintingID    string   `json:"printing_id,omitempty"`
+	ProductID     string   `json:"product_id,omitempty"`
+	Condition     string   `json:"condition,omitempty"`
+	Language      string   `json:"language,omitempty"`
+	Foil          bool     `json:"foil"`
+	PriceCents    int64    `json:"price_cents"`
+	Quantity      int      `json:"quantity"`
+	Status        string   `json:"status"`
+	AcceptsOffers bool     `json:"accepts_offers"`
+	Tags          []string `json:"tags,omitempty"`
+	Version       int64    `json:"version"`
+	UpdatedAt     string   `json:"updated_at"`
+}
+
+// Offer is an offer as sellers' systems see it
+type Offer struct {
+	ID             string `json:"id"`
+	ListingID      string `json:"listing_id"`
+	Status         string `json:"status"`
+	Quantity       int    `json:"quantity"`
+	AmountCents    int64  `json:"amount_cents"`
+	ListPriceCents int64  `json:"list_price_cents"`
+	ExpiresAt      string `json:"expires_at,omitempty"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// PublicOrder returns what sellers' systems see of an order
+func PublicOrder(o models.Order) Order {
+	items := make([]OrderItem, len(o.Items))
+	for i, it := range o.Items {
+		items[i] = OrderItem{
+			ListingID:      it.ListingID,
+			Name:           it.CardName,
+			SetName:        it.SetName,
+			Condition:      it.Condition,
+			Language:       it.Language,
+			Foil:           it.Foil,
+			Quantity:       it.Quantity,
+			UnitPriceCents: it.UnitPriceCents,
+		}
+	}
+	return Order{
+		ID:            o.OrderID,
+		Status:        o.Status,
+		BuyerID:       o.BuyerID,
+		Items:         items,
+		SubtotalCents: o.SubtotalCents,
+		ShippingCents: o.ShippingCents,
+		TotalCents:    o.TotalCents,
+		FeeCents:      o.FeeCents,
+		ShipTo: Address{
+			Name:       o.ShipTo.Name,
+			Line1:      o.ShipTo.Line1,
+			Line2:      o.ShipTo.Line2,
+			City:       o.ShipTo.City,
+			Region:     o.ShipTo.Region,
+			PostalCode: o.ShipTo.PostalCode,
+			Country:    o.ShipTo.Country,
+		},
+		ShippingMethod: o.ShippingName,
+		Carrier:        o.Carrier,
+		TrackingNumber: o.TrackingNumber,
+		DeliveredAt:    o.DeliveredAt,
+		CreatedAt:      o.CreatedAt,
+		UpdatedAt:      o.UpdatedAt,
+	}
+}
+
+// PublicListing returns what sellers' systems see of a listing
+func PublicListing(l models.Listing) Listing {
+	return Listing{
+		ID:            l.ListingID,
+		PrintingID:    l.PrintingID,
+		ProductID:     l.ProductID,
+		Condition:     l.Condition,
+		Language:      l.Language,
+		Foil:          l.Foil,
+		PriceCents:    l.PriceCents,
+		Quantity:      l.Quantity,
+		Status:        l.Status,
+		AcceptsOffers: l.AcceptsOffers,
+		Tags:          l.Tags,
+		Version:       l.Version,
+		UpdatedAt:     l.UpdatedAt,
+	}
+}
+
+// PublicOffer returns what sellers' systems see of an offer
+func PublicOffer(o models.Offer) Offer {
+	return Offer{
+		ID:             o.OfferID,
+		ListingID:      o.ListingID,
+		Status:         o.Status,
+		Quantity:       o.Quantity,
+		AmountCents:    o.AmountCents,
+		ListPriceCents: o.ListPriceCents,
+		ExpiresAt:      o.ExpiresAt,
+		CreatedAt:      o.CreatedAt,
+	}
+}
@@ -0,0 +1,111 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// CreateEndpoint stores a new endpoint
+func (s *DynamoStore) CreateEndpoint(ctx context.Context, e models.WebhookEndpoint) error {
+	return s.put(ctx, e, dynamo.IfNotExists())
+}
+
+// GetEndpoint returns an endpoint
+func (s *DynamoStore) GetEndpoint(ctx context.Context, endpointID string) (models.WebhookEndpoint, error) {
+	var e models.WebhookEndpoint
+	pk, sk := models.WebhookEndpointKey(endpointID)
+	err := s.get(ctx, pk, sk, &e)
+	return e, err
+}
+
+// UpdateEndpoint writes an endpoint if its stored version is still expectedVersion
+func (s *DynamoStore) UpdateEndpoint(ctx context.Context, e models.WebhookEndpoint, expectedVersion int64) error {
+	return s.put(ctx, e, dynamo.IfVersion(expectedVersion))
+}
+
+// DeleteEndpoint removes an endpoint
+func (s *DynamoStore) DeleteEndpoint(ctx context.Context, endpointID string) error {
+	pk, sk := models.WebhookEndpointKey(endpointID)
+	err := s.table.Delete(ctx, pk, sk, dynamo.IfExists())
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// EndpointsForSeller returns a seller's endpoints, oldest first
+func (s *DynamoStore) EndpointsForSeller(ctx context.Context, sellerID string) ([]models.WebhookEndpoint, error) {
+	var out []models.WebhookEndpoint
+	pk, _ := models.SellerWebhookKey(sellerID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, SKPrefix: "WEBHOOK#"}, &out)
+	return out, err
+}
+
+// CreateDelivery stores a new delivery, or returns ErrConflict if its ID is taken
+func (s *DynamoStore) CreateDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	return s.put(ctx, d, dynamo.IfNotExists())
+}
+
+// GetDelivery returns a delivery
+func (s *DynamoStore) GetDelivery(ctx context.Context, deliveryID string) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	pk, sk := models.WebhookDeliveryKey(deliveryID)
+	err := s.get(ctx, pk, sk, &d)
+	return d, err
+}
+
+// UpdateDelivery writes a delivery if its stored version is still expectedVersion
+func (s *DynamoStore) UpdateDelivery(ctx context.Context, d models.WebhookDelivery, expectedVersion int64) error {
+	return s.put(ctx, d, dynamo.IfVersion(expectedVersion))
+}
+
+// Outbox returns the deliveries in a status, due soonest first
+func (s *DynamoStore) Outbox(ctx context.Context, status, dueBy string, limit int) ([]models.WebhookDelivery, error) {
+	pk, _ := models.DeliveryOutboxKey(status, "", "")
+	q := dynamo.Query{Index: dynamo.GSI1, PK: pk, Limit: limit}
+	if dueBy != "" {
+		q.SKTo = dynamo.Through(dueBy)
+	}
+	var out []models.WebhookDelivery
+	err := s.table.Query(ctx, q, &out)
+	return out, err
+}
+
+// Deliveries returns an endpoint's deliveries, newest first
+func (s *DynamoStore) Deliveries(ctx context.Context, endpointID string, limit int) ([]models.WebhookDelivery, error) {
+	var out []models.WebhookDelivery
+	pk, _ := models.EndpointDeliveryKey(endpointID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk, SKPrefix: "DELIVERY#", Descending: true, Limit: limit}, &out)
+	return out, err
+}
+
+// get reads an item, mapping a missing one to ErrNotFound
+func (s *DynamoStore) get(ctx context.Context, pk, sk string, out interface{}) error {
+	err := s.table.Get(ctx, pk, sk, out)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// put writes an item, mapping a failed condition to ErrConflict
+func (s *DynamoStore) put(ctx context.Context, item interface{}, cond dynamo.Condition) error {
+	err := s.table.Put(ctx, item, cond)
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,693 @@
+// Package hooks sends marketplace events to sellers' own HTTPS endpoints.
+//
+// A seller registers an endpoint and picks the events it wants. The package subscribes to those
+// events in the outbox and records a delivery for every enabled endpoint that wants each one.
+// Deliver then POSTs what's due, signed with the endpoint's secret in the same
+// "t=<unix>,v1=<hex hmac>" scheme the webhooks package verifies incoming webhooks with, and
+// retries failures with exponential backoff until MaxAttempts. Every attempt is kept in the
+// endpoint's delivery log, from which the seller can redeliver. An endpoint that has failed
+// without a single success for DisableAfter is disabled until the seller enables it again.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/offers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/webhooks"
+)
+
+var (
+	// ErrNotFound is returned when an endpoint or delivery does not exist or belongs to another seller
+	ErrNotFound = errors.New("hooks: not found")
+	// ErrConflict is returned when an endpoint or delivery was changed concurrently
+	ErrConflict = errors.New("hooks: version conflict")
+	// ErrInvalid is returned when an endpoint's URL or events are unacceptable
+	ErrInvalid = errors.New("hooks: invalid endpoint")
+	// ErrTooMany is returned when a seller already has MaxEndpoints endpoints
+	ErrTooMany = errors.New("hooks: too many endpoints")
+	// ErrNotAllowed is returned when redelivering to a disabled endpoint
+	ErrNotAllowed = errors.New("hooks: endpoint is disabled")
+	// ErrPrivateAddress is returned when an endpoint resolves to an address inside a private network
+	ErrPrivateAddress = errors.New("hooks: endpoint address is not public")
+)
+
+// Request headers sent with every delivery
+const (
+	HeaderSignature = "X-Marketplace-Signature"
+	HeaderEvent     = "X-Marketplace-Event"
+	HeaderDelivery  = "X-Marketplace-Delivery"
+)
+
+// maxResponseBody is how much of an endpoint's response is kept in the delivery log
+const maxResponseBody = 1024
+
+// EventType is an event an endpoint can subscribe to
+type EventType struct {
+	Name        string
+	Description string
+}
+
+// Events lists the events endpoints can subscribe to, in the order they're offered to sellers
+var Events = []EventType{
+	{models.EventOrderPaid, "An order was paid and is ready to ship"},
+	{models.EventOrderCancelled, "An order was cancelled"},
+	{models.EventOrderRefunded, "An order was refunded"},
+	{models.EventOrderCompleted, "An order was completed"},
+	{models.EventListingSoldOut, "A listing sold its last copy"},
+	{models.EventOfferReceived, "A buyer made an offer on a listing"},
+}
+
+// Store persists endpoints and their deliveries.
+type Store interface {
+	CreateEndpoint(ctx context.Context, e models.WebhookEndpoint) error
+	GetEndpoint(ctx context.Context, endpointID string) (models.WebhookEndpoint, error)
+	// UpdateEndpoint writes an endpoint if its stored version is still expectedVersion
+	UpdateEndpoint(ctx context.Context, e models.WebhookEndpoint, expectedVersion int64) error
+	DeleteEndpoint(ctx context.Context, endpointID string) error
+	// EndpointsForSeller returns a seller's endpoints, oldest first
+	EndpointsForSeller(ctx context.Context, sellerID string) ([]models.WebhookEndpoint, error)
+
+	// CreateDelivery stores a new delivery, or returns ErrConflict if its ID is taken
+	CreateDelivery(ctx context.Context, d models.WebhookDelivery) error
+	GetDelivery(ctx context.Context, deliveryID string) (models.WebhookDelivery, error)
+	// UpdateDelivery writes a delivery if its stored version is still expectedVersion
+	UpdateDelivery(ctx context.Context, d models.WebhookDelivery, expectedVersion int64) error
+	// Outbox returns the deliveries in a status, due soonest first. When dueBy is set only
+	// deliveries due by then are returned; when limit is positive at most that many are.
+	Outbox(ctx context.Context, status, dueBy string, limit int) ([]models.WebhookDelivery, error)
+	// Deliveries returns an endpoint's deliveries, newest first, up to limit when it's positive
+	Deliveries(ctx context.Context, endpointID string, limit int) ([]models.WebhookDelivery, error)
+}
+
+// Options controls delivery, retries and limits
+type Options struct {
+	Client       *http.Client  // sends deliveries; by default one that refuses private addresses and redirects
+	Timeout      time.Duration // how long an attempt may take, for the default client
+	BatchSize    int           // deliveries attempted per Deliver call
+	RetryBase    time.Duration // wait before the first retry; each later retry waits twice as long
+	RetryMax     time.Duration // longest wait between retries
+	MaxAttempts  int           // attempts before a delivery is marked failed
+	DisableAfter time.Duration // how long an endpoint may fail without a success before it's disabled
+	MaxEndpoints int           // endpoints a seller may have
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	Timeout:      10 * time.Second,
+	BatchSize:    50,
+	RetryBase:    30 * time.Second,
+	RetryMax:     6 * time.Hour,
+	MaxAttempts:  10,
+	DisableAfter: 72 * time.Hour,
+	MaxEndpoints: 10,
+}
+
+// Service manages sellers' endpoints and delivers events to them.
+type Service struct {
+	store    Store
+	client   *http.Client
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+}
+
+// New creates a webhook delivery Service
+func New(store Store, opts Options, errorLog *log.Logger) *Service {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions.Timeout
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultOptions.BatchSize
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = DefaultOptions.RetryBase
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = DefaultOptions.RetryMax
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if opts.DisableAfter <= 0 {
+		opts.DisableAfter = DefaultOptions.DisableAfter
+	}
+	if opts.MaxEndpoints <= 0 {
+		opts.MaxEndpoints = DefaultOptions.MaxEndpoints
+	}
+	client := opts.Client
+	if client == nil {
+		client = newClient(opts.Timeout)
+	}
+	return &Service{store: store, client: client, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// newClient returns an HTTP client that only connects to public addresses and doesn't follow
+// redirects, so an endpoint can't be pointed at the marketplace's own network
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !public(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// public reports whether an address is on the public internet
+func public(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// Attach subscribes to the outbox events endpoints can ask for
+func (s *Service) Attach(relay *outbox.Relay) {
+	names := make([]string, len(Events))
+	for i, t := range Events {
+		names[i] = t.Name
+	}
+	relay.Subscribe("hooks", s.queue, names...)
+}
+
+// queue records a delivery of an event for each of its seller's endpoints that wants it. The
+// delivery IDs come from the event and endpoint, so an event the relay publishes twice is
+// only delivered once.
+func (s *Service) queue(ctx context.Context, ev models.DomainEvent) error {
+	sellerID, data, err := project(ev)
+	if err != nil {
+		return err
+	}
+	endpoints, err := s.store.EndpointsForSeller(ctx, sellerID)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	for _, e := range endpoints {
+		if !e.Enabled() || !e.Wants(ev.Name) {
+			continue
+		}
+		if body == nil {
+			if body, err = json.Marshal(envelope{ID: ev.EventID, Type: ev.Name, CreatedAt: ev.OccurredAt, Data: data}); err != nil {
+				return err
+			}
+		}
+		sum := sha256.Sum256([]byte(ev.EventID + "#" + e.EndpointID))
+		d := s.newDelivery(hex.EncodeToString(sum[:16]), e, ev.EventID, ev.Name, string(body))
+		if err := s.store.CreateDelivery(ctx, d); err != nil && !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return nil
+}
+
+// newDelivery builds a pending delivery due straight away
+func (s *Service) newDelivery(deliveryID string, e models.WebhookEndpoint, eventID, eventName, payload string) models.WebhookDelivery {
+	now := s.now().UTC().Format(time.RFC3339)
+	d := models.WebhookDelivery{
+		DeliveryID:    deliveryID,
+		EndpointID:    e.EndpointID,
+		SellerID:      e.SellerID,
+		EventID:       eventID,
+		EventName:     eventName,
+		Payload:       payload,
+		Status:        models.DeliveryPending,
+		NextAttemptAt: now,
+		Version:       1,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	setDeliveryKeys(&d)
+	return d
+}
+
+// Deliver makes one pass over the due deliveries, sending each. A delivery is claimed before
+// it's sent so concurrent workers don't share it. It returns how many were delivered.
+func (s *Service) Deliver(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+	due, err := s.store.Outbox(ctx, models.DeliveryPending, now.Format(time.RFC3339), s.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, d := range due {
+		d, err := s.updateDelivery(ctx, d.DeliveryID, func(d *models.WebhookDelivery) error {
+			if d.Status != models.DeliveryPending {
+				return ErrConflict
+			}
+			d.Attempts++
+			d.NextAttemptAt = now.Add(s.backoff(d.Attempts)).Format(time.RFC3339)
+			return nil
+		})
+		if errors.Is(err, ErrConflict) {
+			continue // another worker has it
+		}
+		if err != nil {
+			return delivered, err
+		}
+
+		e, err := s.store.GetEndpoint(ctx, d.EndpointID)
+		if errors.Is(err, ErrNotFound) || (err == nil && !e.Enabled()) {
+			_, err = s.updateDelivery(ctx, d.DeliveryID, func(d *models.WebhookDelivery) error {
+				d.Status = models.DeliveryFailed
+				d.LastError = "the endpoint was disabled or deleted"
+				return nil
+			})
+			if err != nil {
+				return delivered, err
+			}
+			continue
+		}
+		if err != nil {
+			return delivered, err
+		}
+
+		res := s.post(ctx, e, d)
+		_, err = s.updateDelivery(ctx, d.DeliveryID, func(d *models.WebhookDelivery) error {
+			d.ResponseStatus = res.status
+			d.ResponseBody = res.body
+			d.DurationMillis = res.millis
+			switch {
+			case res.err == nil:
+				d.Status = models.DeliverySucceeded
+				d.DeliveredAt = d.UpdatedAt
+				d.LastError = ""
+			case d.Attempts >= s.opts.MaxAttempts:
+				d.Status = models.DeliveryFailed
+				d.LastError = res.err.Error()
+			default:
+				d.LastError = res.err.Error()
+			}
+			return nil
+		})
+		if err != nil {
+			return delivered, err
+		}
+		if err := s.recordHealth(ctx, e.EndpointID, res.err == nil, now); err != nil {
+			return delivered, err
+		}
+		if res.err != nil {
+			s.errorLog.Printf("delivering %s webhook %s to %s failed (attempt %d): %v", d.EventName, d.DeliveryID, e.URL, d.Attempts, res.err)
+			continue
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// attempt is the outcome of sending a delivery once
+type attempt struct {
+	status int
+	body   string
+	millis int64
+	err    error
+}
+
+// post sends a delivery to its endpoint, signed with the endpoint's secret. Any 2xx answer is
+// success.
+func (s *Service) post(ctx context.Context, e models.WebhookEndpoint, d models.WebhookDelivery) attempt {
+	payload := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(payload))
+	if err != nil {
+		return attempt{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tcg-marketplace-webhooks/1.0")
+	req.Header.Set(HeaderEvent, d.EventName)
+	req.Header.Set(HeaderDelivery, d.DeliveryID)
+	req.Header.Set(HeaderSignature, webhooks.SignatureHeader(payload, e.Secret, s.now()))
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	millis := time.Since(start).Milliseconds()
+	if err != nil {
+		return attempt{millis: millis, err: err}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+
+	res := attempt{status: resp.StatusCode, body: string(body), millis: millis}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		res.err = fmt.Errorf("endpoint answered %d", resp.StatusCode)
+	}
+	return res
+}
+
+// errUnchanged tells updateEndpoint there's nothing to write
+var errUnchanged = errors.New("hooks: unchanged")
+
+// recordHealth notes whether an attempt to an endpoint succeeded, and disables an endpoint that
+// has failed for DisableAfter without a success
+func (s *Service) recordHealth(ctx context.Context, endpointID string, ok bool, now time.Time) error {
+	e, err := s.updateEndpoint(ctx, endpointID, func(e *models.WebhookEndpoint) error {
+		switch {
+		case ok && e.FailingSince == "":
+			return errUnchanged
+		case ok:
+			e.FailingSince = ""
+		case e.FailingSince == "":
+			e.FailingSince = now.Format(time.RFC3339)
+		default:
+			since, err := time.Parse(time.RFC3339, e.FailingSince)
+			if err != nil || now.Sub(since) < s.opts.DisableAfter || !e.Enabled() {
+				return errUnchanged
+			}
+			e.Status = models.WebhookDisabled
+			e.DisabledReason = "Every delivery has failed since " + since.Format("Jan 2, 2006 15:04 MST") + "."
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errUnchanged), errors.Is(err, ErrConflict), errors.Is(err, ErrNotFound):
+		return nil // a conflicting write or the next attempt records it
+	case err != nil:
+		return err
+	}
+	if !e.Enabled() {
+		s.errorLog.Printf("webhook endpoint %s for seller %s disabled after failing since %s", e.EndpointID, e.SellerID, e.FailingSince)
+	}
+	return nil
+}
+
+// backoff returns how long to wait after an attempt before the next one
+func (s *Service) backoff(attempts int) time.Duration {
+	wait := s.opts.RetryBase
+	for i := 1; i < attempts && wait < s.opts.RetryMax; i++ {
+		wait *= 2
+	}
+	if wait > s.opts.RetryMax {
+		wait = s.opts.RetryMax
+	}
+	return wait
+}
+
+// Run delivers due webhooks every interval until ctx is done
+func (s *Service) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Deliver(ctx); err != nil {
+			s.errorLog.Printf("delivering webhooks failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CreateEndpoint registers an HTTPS endpoint for some of the Events
+func (s *Service) CreateEndpoint(ctx context.Context, sellerID, rawURL, description string, events []string) (models.WebhookEndpoint, error) {
+	if err := checkURL(rawURL); err != nil {
+		return models.WebhookEndpoint{}, err
+	}
+	if len(events) == 0 {
+		return models.WebhookEndpoint{}, fmt.Errorf("%w: choose at least one event", ErrInvalid)
+	}
+	for _, name := range events {
+		if !offered(name) {
+			return models.WebhookEndpoint{}, fmt.Errorf("%w: unknown event %q", ErrInvalid, name)
+		}
+	}
+	existing, err := s.store.EndpointsForSeller(ctx, sellerID)
+	if err != nil {
+		return models.WebhookEndpoint{}, err
+	}
+	if len(existing) >= s.opts.MaxEndpoints {
+		return models.WebhookEndpoint{}, ErrTooMany
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return models.WebhookEndpoint{}, err
+	}
+	now := s.now().UTC().Format(time.RFC3339)
+	e := models.WebhookEndpoint{
+		EndpointID:  ids.New(),
+		SellerID:    sellerID,
+		URL:         rawURL,
+		Description: strings.TrimSpace(description),
+		Events:      events,
+		Secret:      secret,
+		Status:      models.WebhookEnabled,
+		Version:     1,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	setEndpointKeys(&e)
+	if err := s.store.CreateEndpoint(ctx, e); err != nil {
+		return models.WebhookEndpoint{}, err
+	}
+	return e, nil
+}
+
+// Endpoints returns a seller's endpoints, oldest first
+func (s *Service) Endpoints(ctx context.Context, sellerID string) ([]models.WebhookEndpoint, error) {
+	return s.store.EndpointsForSeller(ctx, sellerID)
+}
+
+// Endpoint returns one of a seller's endpoints
+func (s *Service) Endpoint(ctx context.Context, sellerID, endpointID string) (models.WebhookEndpoint, error) {
+	e, err := s.store.GetEndpoint(ctx, endpointID)
+	if err == nil && e.SellerID != sellerID {
+		return models.WebhookEndpoint{}, ErrNotFound
+	}
+	return e, err
+}
+
+// SetEnabled turns an endpoint on or off. Enabling one that was disabled for failing gives it a
+// fresh start.
+func (s *Service) SetEnabled(ctx context.Context, sellerID, endpointID string, enabled bool) (models.WebhookEndpoint, error) {
+	return s.updateEndpoint(ctx, endpointID, func(e *models.WebhookEndpoint) error {
+		if e.SellerID != sellerID {
+			return ErrNotFound
+		}
+		e.Status = models.WebhookDisabled
+		if enabled {
+			e.Status = models.WebhookEnabled
+			e.DisabledReason = ""
+			e.FailingSince = ""
+		}
+		return nil
+	})
+}
+
+// RollSecret gives an endpoint a new signing secret, for when the old one may have leaked
+func (s *Service) RollSecret(ctx context.Context, sellerID, endpointID string) (models.WebhookEndpoint, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return models.WebhookEndpoint{}, err
+	}
+	return s.updateEndpoint(ctx, endpointID, func(e *models.WebhookEndpoint) error {
+		if e.SellerID != sellerID {
+			return ErrNotFound
+		}
+		e.Secret = secret
+		return nil
+	})
+}
+
+// DeleteEndpoint removes an endpoint. Its deliveries that are still pending fail.
+func (s *Service) DeleteEndpoint(ctx context.Context, sellerID, endpointID string) error {
+	if _, err := s.Endpoint(ctx, sellerID, endpointID); err != nil {
+		return err
+	}
+	return s.store.DeleteEndpoint(ctx, endpointID)
+}
+
+// Deliveries returns an endpoint's most recent deliveries, newest first
+func (s *Service) Deliveries(ctx context.Context, sellerID, endpointID string, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := s.Endpoint(ctx, sellerID, endpointID); err != nil {
+		return nil, err
+	}
+	return s.store.Deliveries(ctx, endpointID, limit)
+}
+
+// Redeliver sends a delivery's payload to its endpoint again as a new delivery. The payload keeps
+// its event ID, so an endpoint that already processed it can tell.
+func (s *Service) Redeliver(ctx context.Context, sellerID, deliveryID string) (models.WebhookDelivery, error) {
+	d, err := s.store.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return d, err
+	}
+	if d.SellerID != sellerID {
+		return models.WebhookDelivery{}, ErrNotFound
+	}
+	e, err := s.Endpoint(ctx, sellerID, d.EndpointID)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	if !e.Enabled() {
+		return models.WebhookDelivery{}, ErrNotAllowed
+	}
+
+	again := s.newDelivery(ids.New(), e, d.EventID, d.EventName, d.Payload)
+	again.RedeliveryOf = d.DeliveryID
+	if err := s.store.CreateDelivery(ctx, again); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return again, nil
+}
+
+// updateEndpoint reads an endpoint, applies mutate and writes it back conditional on the version read
+func (s *Service) updateEndpoint(ctx context.Context, endpointID string, mutate func(*models.WebhookEndpoint) error) (models.WebhookEndpoint, error) {
+	e, err := s.store.GetEndpoint(ctx, endpointID)
+	if err != nil {
+		return e, err
+	}
+
+	expected := e.Version
+	e.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	if err := mutate(&e); err != nil {
+		return e, err
+	}
+	e.Version++
+	setEndpointKeys(&e)
+
+	if err := s.store.UpdateEndpoint(ctx, e, expected); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// updateDelivery reads a delivery, applies mutate and writes it back conditional on the version read
+func (s *Service) updateDelivery(ctx context.Context, deliveryID string, mutate func(*models.WebhookDelivery) error) (models.WebhookDelivery, error) {
+	d, err := s.store.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return d, err
+	}
+
+	expected := d.Version
+	d.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	if err := mutate(&d); err != nil {
+		return d, err
+	}
+	d.Version++
+	setDeliveryKeys(&d)
+
+	if err := s.store.UpdateDelivery(ctx, d, expected); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// checkURL accepts absolute HTTPS URLs without credentials
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil || len(rawURL) > 2048 {
+		return fmt.Errorf("%w: enter an https:// URL", ErrInvalid)
+	}
+	if strings.EqualFold(u.Hostname(), "localhost") {
+		return fmt.Errorf("%w: the URL must be reachable from the internet", ErrInvalid)
+	}
+	return nil
+}
+
+// offered reports whether endpoints may subscribe to an event
+func offered(name string) bool {
+	for _, t := range Events {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newSecret returns a new endpoint signing secret
+func newSecret() (string, error) {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b[:]), nil
+}
+
+// setEndpointKeys fills in the single-table keys for an endpoint
+func setEndpointKeys(e *models.WebhookEndpoint) {
+	e.PK, e.SK = models.WebhookEndpointKey(e.EndpointID)
+	e.GSI1PK, e.GSI1SK = models.SellerWebhookKey(e.SellerID, e.CreatedAt, e.EndpointID)
+	e.Type = models.ItemTypeWebhook
+}
+
+// setDeliveryKeys fills in the single-table keys for a delivery. Only pending and failed
+// deliveries sit in the outbox index.
+func setDeliveryKeys(d *models.WebhookDelivery) {
+	d.PK, d.SK = models.WebhookDeliveryKey(d.DeliveryID)
+	d.GSI1PK, d.GSI1SK = "", ""
+	if d.Status != models.DeliverySucceeded {
+		d.GSI1PK, d.GSI1SK = models.DeliveryOutboxKey(d.Status, d.NextAttemptAt, d.DeliveryID)
+	}
+	d.GSI2PK, d.GSI2SK = models.EndpointDeliveryKey(d.EndpointID, d.CreatedAt, d.DeliveryID)
+	d.Type = models.ItemTypeDelivery
+}
+
+// envelope is the JSON body of every delivery
+type envelope struct {
+	ID        string      `json:"id"` // the event's ID, the same on every retry and redelivery
+	Type      string      `json:"type"`
+	CreatedAt string      `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// project returns the seller an event is for and what an endpoint is told about it
+func project(ev models.DomainEvent) (string, interface{}, error) {
+	switch ev.Aggregate {
+	case "order":
+		var change orders.Change
+		if err := ev.Decode(&change); err != nil {
+			return "", nil, err
+		}
+		return change.Order.SellerID, PublicOrder(change.Order), nil
+	case "listing":
+		var change catalog.ListingChange
+		if err := ev.Decode(&change); err != nil {
+			return "", nil, err
+		}
+		return change.Current.SellerID, PublicListing(change.Current), nil
+	case "offer":
+		var change offers.Change
+		if err := ev.Decode(&change); err != nil {
+			return "", nil, err
+		}
+		return change.Offer.SellerID, PublicOffer(change.Offer), nil
+	}
+	return "", nil, fmt.Errorf("hooks: no webhook payload for %s events", ev.Aggregate)
+}
@@ -0,0 +1,164 @@
+package hooks
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	endpoints  map[string]models.WebhookEndpoint // endpointID -> endpoint
+	deliveries map[string]models.WebhookDelivery // deliveryID -> delivery
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		endpoints:  map[string]models.WebhookEndpoint{},
+		deliveries: map[string]models.WebhookDelivery{},
+	}
+}
+
+// CreateEndpoint stores a new endpoint
+func (s *MemoryStore) CreateEndpoint(ctx context.Context, e models.WebhookEndpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.endpoints[e.EndpointID]; ok {
+		return ErrConflict
+	}
+	s.endpoints[e.EndpointID] = e
+	return nil
+}
+
+// GetEndpoint returns an endpoint
+func (s *MemoryStore) GetEndpoint(ctx context.Context, endpointID string) (models.WebhookEndpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.endpoints[endpointID]
+	if !ok {
+		return e, ErrNotFound
+	}
+	return e, nil
+}
+
+// UpdateEndpoint writes an endpoint if its stored version is still expectedVersion
+func (s *MemoryStore) UpdateEndpoint(ctx context.Context, e models.WebhookEndpoint, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.endpoints[e.EndpointID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.endpoints[e.EndpointID] = e
+	return nil
+}
+
+// DeleteEndpoint removes an endpoint
+func (s *MemoryStore) DeleteEndpoint(ctx context.Context, endpointID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.endpoints[endpointID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.endpoints, endpointID)
+	return nil
+}
+
+// EndpointsForSeller returns a seller's endpoints, oldest first
+func (s *MemoryStore) EndpointsForSeller(ctx context.Context, sellerID string) ([]models.WebhookEndpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pk, _ := models.SellerWebhookKey(sellerID, "", "")
+	var out []models.WebhookEndpoint
+	for _, e := range s.endpoints {
+		if e.GSI1PK == pk {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK < out[j].GSI1SK })
+	return out, nil
+}
+
+// CreateDelivery stores a new delivery, or returns ErrConflict if its ID is taken
+func (s *MemoryStore) CreateDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.deliveries[d.DeliveryID]; ok {
+		return ErrConflict
+	}
+	s.deliveries[d.DeliveryID] = d
+	return nil
+}
+
+// GetDelivery returns a delivery
+func (s *MemoryStore) GetDelivery(ctx context.Context, deliveryID string) (models.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.deliveries[deliveryID]
+	if !ok {
+		return d, ErrNotFound
+	}
+	return d, nil
+}
+
+// UpdateDelivery writes a delivery if its stored version is still expectedVersion
+func (s *MemoryStore) UpdateDelivery(ctx context.Context, d models.WebhookDelivery, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.deliveries[d.DeliveryID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.deliveries[d.DeliveryID] = d
+	return nil
+}
+
+// Outbox returns the deliveries in a status, due soonest first
+func (s *MemoryStore) Outbox(ctx context.Context, status, dueBy string, limit int) ([]models.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pk, _ := models.DeliveryOutboxKey(status, "", "")
+	var out []models.WebhookDelivery
+	for _, d := range s.deliveries {
+		if d.GSI1PK != pk {
+			continue
+		}
+		if dueBy != "" && d.NextAttemptAt > dueBy {
+			continue
+		}
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK < out[j].GSI1SK })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// Deliveries returns an endpoint's deliveries, newest first
+func (s *MemoryStore) Deliveries(ctx context.Context, endpointID string, limit int) ([]models.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pk, _ := models.EndpointDeliveryKey(endpointID, "", "")
+	var out []models.WebhookDelivery
+	for _, d := range s.deliveries {
+		if d.GSI2PK == pk {
+			out = append(out, d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI2SK > out[j].GSI2SK })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
@@ -0,0 +1,409 @@
+// Package jobs runs background work from a durable queue.
+//
+// Work is enqueued as a Job of some kind with a JSON payload and run by the handler registered for
+// that kind. A job whose handler fails is retried with exponential backoff until MaxAttempts, then
+// moved to the dead letters where an admin can look at it and revive it. A job may carry a unique
+// key; a second job with the same key is refused until the first finishes. Schedules enqueue a
+// job every interval or on a cron spec, at most once per slot however many runners share the
+// queue.
+//
+// Delivery is at least once: a job is leased to one worker while it runs, and if that worker dies
+// the job goes back on the queue when the lease runs out. Handlers should be safe to run twice.
+//
+// The Runner can be embedded in the web server or run on its own by cmd/worker; with the Redis
+// queue any number of runners can share the work.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+)
+
+var (
+	// ErrNotFound is returned when a job does not exist
+	ErrNotFound = errors.New("jobs: not found")
+	// ErrDuplicate is returned when a job's unique key is held by another job
+	ErrDuplicate = errors.New("jobs: duplicate job")
+)
+
+// Job is one unit of background work
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	UniqueKey   string          `json:"uniqueKey,omitempty"` // no other job with this key may be queued or running
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"` // when the job is next due
+	LastError   string          `json:"lastError,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	FailedAt    time.Time       `json:"failedAt,omitempty"` // when the job was moved to the dead letters
+}
+
+// Decode unmarshals the job's payload into v
+func (j Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Stats counts the jobs in each state
+type Stats struct {
+	Queued  int // waiting, including retries not yet due
+	Running int
+	Dead    int
+}
+
+// Queue stores jobs. Implementations must be safe for several runners at once.
+type Queue interface {
+	// Push adds a new job. A job with a UniqueKey is refused with ErrDuplicate while another job
+	// holds the key.
+	Push(ctx context.Context, j Job) error
+	// Pop claims the next job due by now, hiding it from other workers until the lease ends. ok is
+	// false when nothing is due.
+	Pop(ctx context.Context, now time.Time, lease time.Duration) (j Job, ok bool, err error)
+	// Ack removes a claimed job that finished and releases its unique key
+	Ack(ctx context.Context, j Job) error
+	// Retry puts a claimed job back on the queue to run again at j.RunAt
+	Retry(ctx context.Context, j Job) error
+	// Bury moves a claimed job to the dead letters and releases its unique key
+	Bury(ctx context.Context, j Job) error
+	// Requeue puts claimed jobs whose lease ran out by now back on the queue and returns how many
+	Requeue(ctx context.Context, now time.Time) (int, error)
+	// Dead returns the dead letters, most recently failed first
+	Dead(ctx context.Context, limit int) ([]Job, error)
+	// Revive moves a dead job back on the queue to run at now with its attempts reset
+	Revive(ctx context.Context, jobID string, now time.Time) (Job, error)
+	// Lock takes a named lock for ttl, reporting false if it's already held
+	Lock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Stats(ctx context.Context) (Stats, error)
+}
+
+// Handler runs one kind of job. Returning an error retries the job unless it is Permanent.
+type Handler func(ctx context.Context, j Job) error
+
+// permanentError marks a failure that retrying can't fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error so the job goes straight to the dead letters, for failures such as a
+// payload that can't be decoded
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Options controls how jobs are run and retried
+type Options struct {
+	Workers      int           // jobs run at once
+	Poll         time.Duration // how often idle workers look for due jobs
+	Lease        time.Duration // how long a job may run before another worker may take it over
+	RetryBase    time.Duration // wait before the first retry; each later retry waits twice as long
+	RetryMax     time.Duration // longest wait between retries
+	MaxAttempts  int           // attempts before a job is moved to the dead letters
+	DrainTimeout time.Duration // how long Run waits for running jobs after it is stopped
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	Workers:      4,
+	Poll:         time.Second,
+	Lease:        5 * time.Minute,
+	RetryBase:    10 * time.Second,
+	RetryMax:     time.Hour,
+	MaxAttempts:  10,
+	DrainTimeout: 30 * time.Second,
+}
+
+// schedule enqueues a kind of job at the start of every slot
+type schedule struct {
+	name string
+	kind string
+	next func(t time.Time) time.Time // the first slot after t
+	due  time.Time
+}
+
+// Runner registers handlers and schedules and runs jobs from a Queue.
+type Runner struct {
+	queue    Queue
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+
+	mu        sync.RWMutex
+	handlers  map[string]Handler
+	schedules []*schedule
+}
+
+// New creates a Runner
+func New(queue Queue, opts Options, errorLog *log.Logger) *Runner {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultOptions.Workers
+	}
+	if opts.Poll <= 0 {
+		opts.Poll = DefaultOptions.Poll
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = DefaultOptions.Lease
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = DefaultOptions.RetryBase
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = DefaultOptions.RetryMax
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = DefaultOptions.DrainTimeout
+	}
+	return &Runner{
+		queue:    queue,
+		opts:     opts,
+		errorLog: errorLog,
+		now:      time.Now,
+		handlers: map[string]Handler{},
+	}
+}
+
+// Handle registers the handler for a kind of job
+func (r *Runner) Handle(kind string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[kind] = h
+}
+
+// Every enqueues a kind of job every interval, in slots aligned to the interval so runners
+// started at different times agree on them. The current slot runs straight away.
+func (r *Runner) Every(name string, every time.Duration, kind string) {
+	next := func(t time.Time) time.Time { return t.Truncate(every).Add(every) }
+	r.addSchedule(&schedule{name: name, kind: kind, next: next, due: r.now().Truncate(every)})
+}
+
+// Cron enqueues a kind of job on a five-field cron spec, such as "30 3 * * *" for 03:30 UTC
+// daily
+func (r *Runner) Cron(name, spec, kind string) error {
+	c, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	r.addSchedule(&schedule{name: name, kind: kind, next: c.Next, due: c.Next(r.now())})
+	return nil
+}
+
+func (r *Runner) addSchedule(s *schedule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schedules = append(r.schedules, s)
+}
+
+// Enqueue adds a job to run as soon as a worker is free
+func (r *Runner) Enqueue(ctx context.Context, kind string, payload interface{}) (Job, error) {
+	return r.EnqueueJob(ctx, Job{Kind: kind}, payload)
+}
+
+// EnqueueJob adds a job with its own run time, unique key or attempt limit. It returns
+// ErrDuplicate if the unique key is held by a job that's queued or running.
+func (r *Runner) EnqueueJob(ctx context.Context, j Job, payload interface{}) (Job, error) {
+	if j.Kind == "" {
+		return j, errors.New("jobs: a job needs a kind")
+	}
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return j, fmt.Errorf("jobs: encoding %s payload: %w", j.Kind, err)
+		}
+		j.Payload = b
+	}
+	now := r.now().UTC()
+	j.ID = ids.New()
+	j.Attempts = 0
+	j.CreatedAt = now
+	if j.RunAt.IsZero() {
+		j.RunAt = now
+	}
+	if j.MaxAttempts <= 0 {
+		j.MaxAttempts = r.opts.MaxAttempts
+	}
+	return j, r.queue.Push(ctx, j)
+}
+
+// Run works through due jobs and fires schedules until ctx is done, then stops taking new jobs
+// and waits up to DrainTimeout for running ones to finish. Jobs still running after that have
+// their context cancelled and go back on the queue when their lease runs out.
+func (r *Runner) Run(ctx context.Context) {
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx, jobCtx)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.tick(ctx)
+	}()
+
+	<-ctx.Done()
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(r.opts.DrainTimeout):
+		r.errorLog.Printf("jobs: still running after %s, cancelling", r.opts.DrainTimeout)
+		cancelJobs()
+		<-drained
+	}
+}
+
+// work runs due jobs one at a time until ctx is done
+func (r *Runner) work(ctx, jobCtx context.Context) {
+	for ctx.Err() == nil {
+		j, ok, err := r.queue.Pop(ctx, r.now().UTC(), r.opts.Lease)
+		if err != nil && ctx.Err() == nil {
+			r.errorLog.Printf("jobs: taking a job failed: %v", err)
+		}
+		if !ok {
+			select {
+			case <-ctx.Done():
+			case <-time.After(r.opts.Poll):
+			}
+			continue
+		}
+		r.process(jobCtx, j)
+	}
+}
+
+// process runs one claimed job and records how it went
+func (r *Runner) process(ctx context.Context, j Job) {
+	j.Attempts++
+	err := r.call(ctx, j)
+	if err == nil {
+		if err := r.queue.Ack(ctx, j); err != nil {
+			r.errorLog.Printf("jobs: acknowledging %s job %s failed: %v", j.Kind, j.ID, err)
+		}
+		return
+	}
+
+	now := r.now().UTC()
+	j.LastError = err.Error()
+	var permanent permanentError
+	if errors.As(err, &permanent) || j.Attempts >= j.MaxAttempts {
+		r.errorLog.Printf("jobs: %s job %s failed for good after %d attempts: %v", j.Kind, j.ID, j.Attempts, err)
+		j.FailedAt = now
+		err = r.queue.Bury(ctx, j)
+	} else {
+		r.errorLog.Printf("jobs: %s job %s failed (attempt %d), retrying: %v", j.Kind, j.ID, j.Attempts, err)
+		j.RunAt = now.Add(r.backoff(j.Attempts))
+		err = r.queue.Retry(ctx, j)
+	}
+	if err != nil {
+		r.errorLog.Printf("jobs: recording the failure of %s job %s failed: %v", j.Kind, j.ID, err)
+	}
+}
+
+// call runs a job's handler, turning a panic into an error
+func (r *Runner) call(ctx context.Context, j Job) (err error) {
+	r.mu.RLock()
+	h, ok := r.handlers[j.Kind]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for %q jobs", j.Kind)
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+	return h(ctx, j)
+}
+
+// backoff returns how long to wait after an attempt before the next one
+func (r *Runner) backoff(attempts int) time.Duration {
+	wait := r.opts.RetryBase
+	for i := 1; i < attempts && wait < r.opts.RetryMax; i++ {
+		wait *= 2
+	}
+	if wait > r.opts.RetryMax {
+		wait = r.opts.RetryMax
+	}
+	return wait
+}
+
+// tick fires due schedules and requeues jobs whose worker died, every Poll until ctx is done
+func (r *Runner) tick(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.Poll)
+	defer ticker.Stop()
+	for {
+		r.fire(ctx)
+		if _, err := r.queue.Requeue(ctx, r.now().UTC()); err != nil && ctx.Err() == nil {
+			r.errorLog.Printf("jobs: requeueing expired leases failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fire enqueues the job for each schedule whose slot has come. A lock on the slot stops two
+// runners enqueuing it twice, and the schedule's unique key stops a slow job overlapping itself.
+func (r *Runner) fire(ctx context.Context) {
+	now := r.now()
+	r.mu.RLock()
+	schedules := append([]*schedule(nil), r.schedules...)
+	r.mu.RUnlock()
+
+	for _, s := range schedules {
+		if now.Before(s.due) {
+			continue
+		}
+		slot := s.due
+		s.due = s.next(now)
+
+		key := fmt.Sprintf("schedule:%s:%d", s.name, slot.UnixMilli())
+		got, err := r.queue.Lock(ctx, key, s.next(slot).Sub(slot)+time.Minute)
+		if err != nil {
+			r.errorLog.Printf("jobs: locking schedule %s failed: %v", s.name, err)
+			continue
+		}
+		if !got {
+			continue // another runner has it
+		}
+		_, err = r.EnqueueJob(ctx, Job{Kind: s.kind, UniqueKey: "schedule:" + s.name}, nil)
+		if err != nil && !errors.Is(err, ErrDuplicate) {
+			r.errorLog.Printf("jobs: enqueueing scheduled %s job failed: %v", s.name, err)
+		}
+	}
+}
+
+// Stats counts the jobs in each state
+func (r *Runner) Stats(ctx context.Context) (Stats, error) {
+	return r.queue.Stats(ctx)
+}
+
+// Dead returns the most recent dead letters
+func (r *Runner) Dead(ctx context.Context, limit int) ([]Job, error) {
+	return r.queue.Dead(ctx, limit)
+}
+
+// Revive puts a dead job back on the queue to run straight away
+func (r *Runner) Revive(ctx context.Context, jobID string) (Job, error) {
+	return r.queue.Revive(ctx, jobID, r.now().UTC())
+}
@@ -0,0 +1,181 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryQueue is an in-process Queue used for development and local runs. Jobs are lost when the
+// process exits.
+type MemoryQueue struct {
+	mu      sync.Mutex
+	queued  map[string]Job       // jobID -> waiting job
+	running map[string]time.Time // jobID -> lease end
+	jobs    map[string]Job       // jobID -> running job
+	dead    map[string]Job
+	locks   map[string]time.Time // key -> expiry
+	unique  map[string]string    // unique key -> jobID holding it
+}
+
+// NewMemoryQueue creates an empty MemoryQueue
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		queued:  map[string]Job{},
+		running: map[string]time.Time{},
+		jobs:    map[string]Job{},
+		dead:    map[string]Job{},
+		locks:   map[string]time.Time{},
+		unique:  map[string]string{},
+	}
+}
+
+// Push adds a new job
+func (q *MemoryQueue) Push(ctx context.Context, j Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if j.UniqueKey != "" {
+		if _, held := q.unique[j.UniqueKey]; held {
+			return ErrDuplicate
+		}
+		q.unique[j.UniqueKey] = j.ID
+	}
+	q.queued[j.ID] = j
+	return nil
+}
+
+// Pop claims the job due soonest, if any is due by now
+func (q *MemoryQueue) Pop(ctx context.Context, now time.Time, lease time.Duration) (Job, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var next Job
+	found := false
+	for _, j := range q.queued {
+		if j.RunAt.After(now) {
+			continue
+		}
+		if !found || j.RunAt.Before(next.RunAt) {
+			next, found = j, true
+		}
+	}
+	if !found {
+		return next, false, nil
+	}
+	delete(q.queued, next.ID)
+	q.jobs[next.ID] = next
+	q.running[next.ID] = now.Add(lease)
+	return next, true, nil
+}
+
+// Ack removes a finished job
+func (q *MemoryQueue) Ack(ctx context.Context, j Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.jobs, j.ID)
+	delete(q.running, j.ID)
+	q.release(j)
+	return nil
+}
+
+// Retry puts a claimed job back on the queue
+func (q *MemoryQueue) Retry(ctx context.Context, j Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.jobs, j.ID)
+	delete(q.running, j.ID)
+	q.queued[j.ID] = j
+	return nil
+}
+
+// Bury moves a claimed job to the dead letters
+func (q *MemoryQueue) Bury(ctx context.Context, j Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.jobs, j.ID)
+	delete(q.running, j.ID)
+	q.dead[j.ID] = j
+	q.release(j)
+	return nil
+}
+
+// release frees a job's unique key if the job still holds it
+func (q *MemoryQueue) release(j Job) {
+	if j.UniqueKey != "" && q.unique[j.UniqueKey] == j.ID {
+		delete(q.unique, j.UniqueKey)
+	}
+}
+
+// Requeue puts jobs whose lease ran out back on the queue
+func (q *MemoryQueue) Requeue(ctx context.Context, now time.Time) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for id, until := range q.running {
+		if until.After(now) {
+			continue
+		}
+		j := q.jobs[id]
+		j.RunAt = now
+		q.queued[id] = j
+		delete(q.jobs, id)
+		delete(q.running, id)
+		n++
+	}
+	return n, nil
+}
+
+// Dead returns the dead letters, most recently failed first
+func (q *MemoryQueue) Dead(ctx context.Context, limit int) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([]Job, 0, len(q.dead))
+	for _, j := range q.dead {
+		out = append(out, j)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FailedAt.After(out[j].FailedAt) })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// Revive moves a dead job back on the queue
+func (q *MemoryQueue) Revive(ctx context.Context, jobID string, now time.Time) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.dead[jobID]
+	if !ok {
+		return j, ErrNotFound
+	}
+	delete(q.dead, jobID)
+	j.Attempts = 0
+	j.RunAt = now
+	j.FailedAt = time.Time{}
+	q.queued[jobID] = j
+	return j, nil
+}
+
+// Lock takes a named lock for ttl
+func (q *MemoryQueue) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	if until, ok := q.locks[key]; ok && until.After(now) {
+		return false, nil
+	}
+	q.locks[key] = now.Add(ttl)
+	for k, until := range q.locks {
+		if !until.After(now) {
+			delete(q.locks, k)
+		}
+	}
+	return true, nil
+}
+
+// Stats counts the jobs in each state
+func (q *MemoryQueue) Stats(ctx context.Context) (Stats, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return Stats{Queued: len(q.queued), Running: len(q.running), Dead: len(q.dead)}, nil
+}
@@ -0,0 +1,316 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// DefaultUniqueTTL is how long a unique key is held at most, in case its job is lost
+const DefaultUniqueTTL = 24 * time.Hour
+
+// DefaultMaxDead is how many dead letters the Redis queue keeps
+const DefaultMaxDead = 10000
+
+// RedisQueue is a Queue kept in Redis, shared by every runner using the same pool and prefix.
+//
+// Each job is a JSON string under <prefix>job:<id>. Its ID sits in one of three sorted sets:
+// <prefix>queued scored by when it's due, <prefix>running scored by when its lease ends, and
+// <prefix>dead scored by when it failed. Moves between them are Lua scripts, so a job is never in
+// two sets or lost between them.
+type RedisQueue struct {
+	pool      *redis.Pool
+	prefix    string
+	UniqueTTL time.Duration // DefaultUniqueTTL when zero
+	MaxDead   int           // DefaultMaxDead when zero; the oldest dead letters are dropped
+}
+
+// NewRedisQueue creates a RedisQueue with keys under prefix, such as "jobs:"
+func NewRedisQueue(pool *redis.Pool, prefix string) *RedisQueue {
+	return &RedisQueue{pool: pool, prefix: prefix}
+}
+
+func (q *RedisQueue) jobKey(id string) string   { return q.prefix + "job:" + id }
+func (q *RedisQueue) uniqueKey(k string) string { return q.prefix + "unique:" + k }
+func (q *RedisQueue) queuedKey() string         { return q.prefix + "queued" }
+func (q *RedisQueue) runningKey() string        { return q.prefix + "running" }
+func (q *RedisQueue) deadKey() string           { return q.prefix + "dead" }
+
+// millis is the score a time is stored with
+func millis(t time.Time) int64 { return t.UnixMilli() }
+
+// boolArg is a script argument for a yes or no
+func boolArg(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+var pushScript = redis.NewScript(3, `
+if ARGV[4] == "1" then
+  if not redis.call("SET", KEYS[3], ARGV[1], "NX", "PX", ARGV[5]) then return 0 end
+end
+redis.call("SET", KEYS[1], ARGV[2])
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+return 1
+`)
+
+// Push adds a new job
+func (q *RedisQueue) Push(ctx context.Context, j Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	ttl := q.UniqueTTL
+	if ttl <= 0 {
+		ttl = DefaultUniqueTTL
+	}
+	return q.with(ctx, func(c redis.Conn) error {
+		added, err := redis.Int(pushScript.DoContext(ctx, c,
+			q.jobKey(j.ID), q.queuedKey(), q.uniqueKey(j.UniqueKey),
+			j.ID, b, millis(j.RunAt), boolArg(j.UniqueKey != ""), ttl.Milliseconds()))
+		if err != nil {
+			return err
+		}
+		if added == 0 {
+			return ErrDuplicate
+		}
+		return nil
+	})
+}
+
+var popScript = redis.NewScript(2, `
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 1)
+if #ids == 0 then return false end
+redis.call("ZREM", KEYS[1], ids[1])
+local job = redis.call("GET", ARGV[3] .. ids[1])
+if not job then return false end
+redis.call("ZADD", KEYS[2], ARGV[2], ids[1])
+return job
+`)
+
+// Pop claims the job due soonest, if any is due by now
+func (q *RedisQueue) Pop(ctx context.Context, now time.Time, lease time.Duration) (Job, bool, error) {
+	var j Job
+	var ok bool
+	err := q.with(ctx, func(c redis.Conn) error {
+		b, err := redis.Bytes(popScript.DoContext(ctx, c, q.queuedKey(), q.runningKey(),
+			millis(now), millis(now.Add(lease)), q.prefix+"job:"))
+		if errors.Is(err, redis.ErrNil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ok = true
+		return json.Unmarshal(b, &j)
+	})
+	return j, ok, err
+}
+
+var ackScript = redis.NewScript(3, `
+redis.call("DEL", KEYS[1])
+redis.call("ZREM", KEYS[2], ARGV[1])
+if ARGV[2] == "1" and redis.call("GET", KEYS[3]) == ARGV[1] then redis.call("DEL", KEYS[3]) end
+return 1
+`)
+
+// Ack removes a finished job and releases its unique key
+func (q *RedisQueue) Ack(ctx context.Context, j Job) error {
+	return q.with(ctx, func(c redis.Conn) error {
+		_, err := ackScript.DoContext(ctx, c, q.jobKey(j.ID), q.runningKey(), q.uniqueKey(j.UniqueKey),
+			j.ID, boolArg(j.UniqueKey != ""))
+		return err
+	})
+}
+
+var retryScript = redis.NewScript(3, `
+redis.call("SET", KEYS[1], ARGV[2])
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("ZADD", KEYS[3], ARGV[3], ARGV[1])
+return 1
+`)
+
+// Retry puts a claimed job back on the queue to run at j.RunAt
+func (q *RedisQueue) Retry(ctx context.Context, j Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return q.with(ctx, func(c redis.Conn) error {
+		_, err := retryScript.DoContext(ctx, c, q.jobKey(j.ID), q.runningKey(), q.queuedKey(),
+			j.ID, b, millis(j.RunAt))
+		return err
+	})
+}
+
+var buryScript = redis.NewScript(4, `
+redis.call("SET", KEYS[1], ARGV[2])
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("ZADD", KEYS[3], ARGV[3], ARGV[1])
+if ARGV[4] == "1" and redis.call("GET", KEYS[4]) == ARGV[1] then redis.call("DEL", KEYS[4]) end
+local extra = redis.call("ZCARD", KEYS[3]) - tonumber(ARGV[5])
+if extra > 0 then
+  for _, id in ipairs(redis.call("ZRANGE", KEYS[3], 0, extra - 1)) do
+    redis.call("DEL", ARGV[6] .. id)
+  end
+  redis.call("ZREMRANGEBYRANK", KEYS[3], 0, extra - 1)
+end
+return 1
+`)
+
+// Bury moves a claimed job to the dead letters, dropping the oldest past MaxDead
+func (q *RedisQueue) Bury(ctx context.Context, j Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	maxDead := q.MaxDead
+	if maxDead <= 0 {
+		maxDead = DefaultMaxDead
+	}
+	return q.with(ctx, func(c redis.Conn) error {
+		_, err := buryScript.DoContext(ctx, c, q.jobKey(j.ID), q.runningKey(), q.deadKey(), q.uniqueKey(j.UniqueKey),
+			j.ID, b, millis(j.FailedAt), boolArg(j.UniqueKey != ""), maxDead, q.prefix+"job:")
+		return err
+	})
+}
+
+var requeueScript = redis.NewScript(2, `
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+for _, id in ipairs(ids) do
+  redis.call("ZREM", KEYS[1], id)
+  redis.call("ZADD", KEYS[2], ARGV[1], id)
+end
+return #ids
+`)
+
+// Requeue puts jobs whose lease ran out back on the queue
+func (q *RedisQueue) Requeue(ctx context.Context, now time.Time) (int, error) {
+	var n int
+	err := q.with(ctx, func(c redis.Conn) error {
+		var err error
+		n, err = redis.Int(requeueScript.DoContext(ctx, c, q.runningKey(), q.queuedKey(), millis(now)))
+		return err
+	})
+	return n, err
+}
+
+// Dead returns the dead letters, most recently failed first
+func (q *RedisQueue) Dead(ctx context.Context, limit int) ([]Job, error) {
+	var out []Job
+	err := q.with(ctx, func(c redis.Conn) error {
+		ids, err := redis.Strings(redis.DoContext(c, ctx, "ZREVRANGE", q.deadKey(), 0, limit-1))
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		args := make([]interface{}, len(ids))
+		for i, id := range ids {
+			args[i] = q.jobKey(id)
+		}
+		values, err := redis.ByteSlices(redis.DoContext(c, ctx, "MGET", args...))
+		if err != nil {
+			return err
+		}
+		for _, b := range values {
+			if b == nil {
+				continue
+			}
+			var j Job
+			if err := json.Unmarshal(b, &j); err != nil {
+				return err
+			}
+			out = append(out, j)
+		}
+		return nil
+	})
+	return out, err
+}
+
+var reviveScript = redis.NewScript(3, `
+if redis.call("ZREM", KEYS[2], ARGV[1]) == 0 then return 0 end
+redis.call("SET", KEYS[1], ARGV[2])
+redis.call("ZADD", KEYS[3], ARGV[3], ARGV[1])
+return 1
+`)
+
+// Revive moves a dead job back on the queue to run at now
+func (q *RedisQueue) Revive(ctx context.Context, jobID string, now time.Time) (Job, error) {
+	var j Job
+	err := q.with(ctx, func(c redis.Conn) error {
+		b, err := redis.Bytes(redis.DoContext(c, ctx, "GET", q.jobKey(jobID)))
+		if errors.Is(err, redis.ErrNil) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &j); err != nil {
+			return err
+		}
+		j.Attempts = 0
+		j.RunAt = now
+		j.FailedAt = time.Time{}
+		if b, err = json.Marshal(j); err != nil {
+			return err
+		}
+		moved, err := redis.Int(reviveScript.DoContext(ctx, c, q.jobKey(jobID), q.deadKey(), q.queuedKey(),
+			jobID, b, millis(now)))
+		if err != nil {
+			return err
+		}
+		if moved == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return j, err
+}
+
+// Lock takes a named lock for ttl
+func (q *RedisQueue) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	var got bool
+	err := q.with(ctx, func(c redis.Conn) error {
+		reply, err := redis.DoContext(c, ctx, "SET", q.prefix+"lock:"+key, "1", "NX", "PX", ttl.Milliseconds())
+		got = reply != nil
+		return err
+	})
+	return got, err
+}
+
+// Stats counts the jobs in each state
+func (q *RedisQueue) Stats(ctx context.Context) (Stats, error) {
+	var s Stats
+	err := q.with(ctx, func(c redis.Conn) error {
+		for _, field := range []struct {
+			key string
+			n   *int
+		}{
+			{q.queuedKey(), &s.Queued},
+			{q.runningKey(), &s.Running},
+			{q.deadKey(), &s.Dead},
+		} {
+			n, err := redis.Int(redis.DoContext(c, ctx, "ZCARD", field.key))
+			if err != nil {
+				return err
+			}
+			*field.n = n
+		}
+		return nil
+	})
+	return s, err
+}
+
+// with runs fn on a pooled connection
+func (q *RedisQueue) with(ctx context.Context, fn func(redis.Conn) error) error {
+	c, err := q.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return fn(c)
+}
@@ -0,0 +1,121 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// idempotencyClaim records that a journal entry's idempotency key has been used
+type idempotencyClaim struct {
+	PK      string `dynamodbav:"PK"`
+	SK      string `dynamodbav:"SK"`
+	Type    string `dynamodbav:"Type"`
+	Key     string `dynamodbav:"key"`
+	EntryID string `dynamodbav:"entryID"`
+}
+
+// DynamoStore is a Store over the marketplace table. Each entry is written together with a claim
+// on its idempotency key and with copies in its order's partition and in the partition of every
+// account it posts to, so balances are read consistently rather than from an index. Entries are
+// never changed, so the copies can't go stale.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Append stores entries in one transaction, rejecting the batch if any idempotency key is reused
+func (s *DynamoStore) Append(ctx context.Context, entries []models.LedgerEntry) error {
+	var ops []dynamo.Op
+	for _, e := range entries {
+		claim := idempotencyClaim{Key: e.IdempotencyKey, EntryID: e.EntryID, Type: models.ItemTypeLedgerKey}
+		claim.PK, claim.SK = models.LedgerIdempotencyKey(e.IdempotencyKey)
+		ops = append(ops, dynamo.PutOp(e, dynamo.IfNotExists()), dynamo.PutOp(claim, dynamo.IfNotExists()))
+
+		if e.OrderID != "" {
+			c := entryCopy(e)
+			c.PK, c.SK = models.OrderLedgerKey(e.OrderID, e.CreatedAt, e.EntryID)
+			ops = append(ops, dynamo.PutOp(c, dynamo.Condition{}))
+		}
+		seen := map[string]bool{}
+		for _, p := range e.Postings {
+			if seen[p.Account] {
+				continue
+			}
+			seen[p.Account] = true
+			c := entryCopy(e)
+			c.PK, c.SK = models.AccountLedgerKey(p.Account, e.CreatedAt, e.EntryID)
+			ops = append(ops, dynamo.PutOp(c, dynamo.Condition{}))
+		}
+	}
+
+	err := s.table.Write(ctx, ops...)
+	if !errors.Is(err, dynamo.ErrConflict) {
+		return err
+	}
+	// the transaction failed a condition or collided with another; it's only a duplicate if one
+	// of the keys is already claimed
+	for _, e := range entries {
+		var claim idempotencyClaim
+		pk, sk := models.LedgerIdempotencyKey(e.IdempotencyKey)
+		switch err := s.table.Get(ctx, pk, sk, &claim); {
+		case err == nil:
+			return ErrDuplicate
+		case !errors.Is(err, dynamo.ErrNotFound):
+			return err
+		}
+	}
+	return err
+}
+
+// ByOrder returns an order's entries, oldest first
+func (s *DynamoStore) ByOrder(ctx context.Context, orderID string) ([]models.LedgerEntry, error) {
+	pk, _ := models.OrderLedgerKey(orderID, "", "")
+	return s.copies(ctx, pk)
+}
+
+// ByAccount returns the entries posting to an account, oldest first
+func (s *DynamoStore) ByAccount(ctx context.Context, account string) ([]models.LedgerEntry, error) {
+	pk, _ := models.AccountLedgerKey(account, "", "")
+	return s.copies(ctx, pk)
+}
+
+// All returns every entry, oldest first
+func (s *DynamoStore) All(ctx context.Context) ([]models.LedgerEntry, error) {
+	var out []models.LedgerEntry
+	pk, _ := models.JournalKey("", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk}, &out)
+	return out, err
+}
+
+// copies reads the entry copies in a partition, restoring each to the entry it copies
+func (s *DynamoStore) copies(ctx context.Context, pk string) ([]models.LedgerEntry, error) {
+	var out []models.LedgerEntry
+	if err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "LEDGER#"}, &out); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		e := &out[i]
+		e.Type = models.ItemTypeLedger
+		e.PK, e.SK = models.LedgerEntryKey(e.CreatedAt, e.EntryID)
+		e.GSI2PK, e.GSI2SK = models.JournalKey(e.CreatedAt, e.EntryID)
+		if e.OrderID != "" {
+			e.GSI1PK, e.GSI1SK = models.OrderLedgerKey(e.OrderID, e.CreatedAt, e.EntryID)
+		}
+	}
+	return out, nil
+}
+
+// entryCopy returns a copy of an entry kept out of the indexes, so each entry is listed there
+// once
+func entryCopy(e models.LedgerEntry) models.LedgerEntry {
+	e.Type = models.ItemTypeLedgerCopy
+	e.GSI1PK, e.GSI1SK, e.GSI2PK, e.GSI2SK = "", "", "", ""
+	return e
+}
@@ -0,0 +1,483 @@
+// Package ledger records every movement of money as immutable double-entry journal entries.
+//
+// Amounts are integer cents. Each posting debits (positive) or credits (negative) one account and
+// every entry's postings sum to zero, so the ledger as a whole always nets to zero. Accounts are:
+//
+//	buyer:<id>              what a buyer owes for their orders; nets to zero once they have paid
+//	seller:<id>:pending     seller earnings on orders that have not completed yet, less postage
+//	                        bought for them
+//	seller:<id>:available   seller earnings on completed orders
+//	seller:<id>:connected   money in the seller's connected account at the payment processor,
+//	                        which their share of each payment is transferred to and paid out from
+//	platform:revenue        platform fees
+//	platform:processing     what the payment processor charges the platform to take payments
+//	processor:clearing      money held at the payment processor
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+var (
+	// ErrUnbalanced is returned when an entry's postings do not sum to zero
+	ErrUnbalanced = errors.New("ledger: entry does not balance")
+	// ErrDuplicate is returned by a Store when an idempotency key has already been used
+	ErrDuplicate = errors.New("ledger: duplicate entry")
+	// ErrInsufficientFunds is returned when a seller has no available balance to pay out
+	ErrInsufficientFunds = errors.New("ledger: no available balance to pay out")
+	// ErrRefundExceedsSale is returned when a refund is larger than what remains of the sale
+	ErrRefundExceedsSale = errors.New("ledger: refund exceeds remaining sale amount")
+)
+
+// Platform accounts
+const (
+	PlatformRevenue    = "platform:revenue"
+	PlatformProcessing = "platform:processing"
+	ProcessorClearing  = "processor:clearing"
+)
+
+// BuyerAccount is the receivable account for a buyer
+func BuyerAccount(buyerID string) string { return "buyer:" + buyerID }
+
+// SellerPending holds a seller's earnings on orders that have not completed
+func SellerPending(sellerID string) string { return "seller:" + sellerID + ":pending" }
+
+// SellerAvailable holds a seller's earnings that can be paid out
+func SellerAvailable(sellerID string) string { return "seller:" + sellerID + ":available" }
+
+// SellerConnected holds what is in a seller's connected account at the payment processor
+func SellerConnected(sellerID string) string { return "seller:" + sellerID + ":connected" }
+
+// PayoutFunc pays amountCents from a seller's connected account to their bank and returns the
+// processor's payout ID. key makes the payout idempotent.
+type PayoutFunc func(ctx context.Context, sellerID string, amountCents int64, key string) (string, error)
+
+// ReverseFunc takes amountCents of what an order's payment transferred to the seller's connected
+// account back to the platform. key makes the reversal idempotent.
+type ReverseFunc func(ctx context.Context, o models.Order, amountCents int64, key string) error
+
+// BalanceFunc returns what the payment processor says a seller's connected account holds, in
+// cents, such as payments.Service.ConnectedBalance
+type BalanceFunc func(ctx context.Context, sellerID string) (int64, error)
+
+// Store persists journal entries. Entries are append-only; there is no update or delete.
+type Store interface {
+	// Append writes entries in one all-or-nothing transaction. It returns ErrDuplicate if any
+	// entry's idempotency key has already been used.
+	Append(ctx context.Context, entries []models.LedgerEntry) error
+	ByOrder(ctx context.Context, orderID string) ([]models.LedgerEntry, error)
+	ByAccount(ctx context.Context, account string) ([]models.LedgerEntry, error)
+	All(ctx context.Context) ([]models.LedgerEntry, error)
+}
+
+// Balances are a seller's earnings, in cents owed to the seller
+type Balances struct {
+	PendingCents   int64
+	AvailableCents int64
+}
+
+// Line is a journal entry as it affects one seller, with amounts in cents owed to the seller
+type Line struct {
+	Entry          models.LedgerEntry
+	PendingCents   int64
+	AvailableCents int64
+}
+
+// Options sets what the payment processor charges per payment, e.g. 290 basis points and 30 cents
+// for 2.9% + 30¢. Zero fields are left at zero, so no processing fee is recorded.
+type Options struct {
+	ProcessingBasisPoints int64
+	ProcessingFixedCents  int64
+}
+
+// Service writes journal entries for order payments and refunds and reads balances.
+type Service struct {
+	store    Store
+	opts     Options
+	errorLog *log.Logger
+
+	mu      sync.Mutex // also serialises payouts so a balance can't be paid out twice
+	orders  *orders.Service
+	balance BalanceFunc
+}
+
+// New creates a ledger Service
+func New(store Store, opts Options, errorLog *log.Logger) *Service {
+	return &Service{store: store, opts: opts, errorLog: errorLog}
+}
+
+// ProcessingFee returns what the payment processor keeps from a payment of totalCents
+func (s *Service) ProcessingFee(totalCents int64) int64 {
+	if totalCents <= 0 {
+		return 0
+	}
+	// round the percentage to the nearest cent, as processors do
+	return (totalCents*s.opts.ProcessingBasisPoints+5000)/10000 + s.opts.ProcessingFixedCents
+}
+
+// Attach records ledger entries as orders are paid, completed, cancelled and refunded, as the
+// orders' events are published from the outbox. Every entry is idempotent, and the sale is
+// recorded before any entry that depends on it, so the entries come out right in whatever order
+// the events arrive.
+func (s *Service) Attach(relay *outbox.Relay, o *orders.Service) {
+	s.mu.Lock()
+	s.orders = o
+	s.mu.Unlock()
+
+	relay.Subscribe("ledger", func(ctx context.Context, ev models.DomainEvent) error {
+		var change orders.Change
+		if err := ev.Decode(&change); err != nil {
+			return err
+		}
+		order, to := change.Order, change.Event.To
+		if change.Event.From == models.OrderStatusPendingPayment && to != models.OrderStatusPaid {
+			return nil // never paid, so nothing was recorded
+		}
+		if err := s.RecordSale(ctx, order); err != nil {
+			return err
+		}
+		switch to {
+		case models.OrderStatusCompleted:
+			return s.Release(ctx, order)
+		case models.OrderStatusRefunded:
+			return s.RecordRefund(ctx, order, 0, "order-"+order.OrderID+"-"+to)
+		}
+		// a cancelled order's refund is booked by payments once the provider has made it
+		return nil
+	}, models.EventOrderPaid, models.EventOrderCompleted, models.EventOrderCancelled, models.EventOrderRefunded)
+}
+
+// SetBalanceFunc sets how reconciliation asks the payment processor what sellers' connected
+// accounts hold
+func (s *Service) SetBalanceFunc(fn BalanceFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balance = fn
+}
+
+// RecordSale records the buyer's payment for an order, splits it between the seller's pending
+// balance and platform revenue, and charges the processor's fee for taking it to the platform.
+// The processor keeps that fee even if the order is later refunded. Payments are destination
+// charges, so the seller's share is transferred to their connected account as the payment lands.
+func (s *Service) RecordSale(ctx context.Context, o models.Order) error {
+	if o.FeeCents < 0 || o.FeeCents > o.TotalCents {
+		return fmt.Errorf("ledger: fee %d is outside order %s total %d", o.FeeCents, o.OrderID, o.TotalCents)
+	}
+
+	buyer := BuyerAccount(o.BuyerID)
+	entries := []models.LedgerEntry{
+		entry(models.LedgerKindPayment, o.OrderID, "order-"+o.OrderID+"-payment", "payment received",
+			posting(ProcessorClearing, o.TotalCents),
+			posting(buyer, -o.TotalCents),
+		),
+		entry(models.LedgerKindSale, o.OrderID, "order-"+o.OrderID+"-sale", "order sold",
+			posting(buyer, o.TotalCents),
+			posting(SellerPending(o.SellerID), -(o.TotalCents-o.FeeCents)),
+			posting(PlatformRevenue, -o.FeeCents),
+		),
+		entry(models.LedgerKindTransfer, o.OrderID, "order-"+o.OrderID+"-transfer", "transfer for "+o.PaymentIntent,
+			posting(SellerConnected(o.SellerID), o.TotalCents-o.FeeCents),
+			posting(ProcessorClearing, -(o.TotalCents-o.FeeCents)),
+		),
+	}
+	if processing := s.ProcessingFee(o.TotalCents); processing > 0 {
+		entries = append(entries, entry(models.LedgerKindProcessingFee, o.OrderID, "order-"+o.OrderID+"-processing", "payment processing fee",
+			posting(PlatformProcessing, processing),
+			posting(ProcessorClearing, -processing),
+		))
+	}
+	return s.post(ctx, entries...)
+}
+
+// Release moves a completed order's earnings from the seller's pending to available balance
+func (s *Service) Release(ctx context.Context, o models.Order) error {
+	nets, err := s.orderNets(ctx, o.OrderID)
+	if err != nil {
+		return err
+	}
+
+	held := -nets[SellerPending(o.SellerID)]
+	if held <= 0 {
+		return nil
+	}
+	return s.post(ctx, entry(models.LedgerKindRelease, o.OrderID, "order-"+o.OrderID+"-release", "order completed",
+		posting(SellerPending(o.SellerID), held),
+		posting(SellerAvailable(o.SellerID), -held),
+	))
+}
+
+// ChargeLabel charges the postage for a label bought through the marketplace to the seller's
+// pending balance on the order. The platform pays the carrier, and takes the cost back from the
+// order's transfer to the seller's connected account with reverse before booking it, so a
+// failed reversal books nothing and can be retried. The seller still owes the postage if the
+// order is later refunded.
+func (s *Service) ChargeLabel(ctx context.Context, o models.Order, label models.ShippingLabel, reverse ReverseFunc) error {
+	if label.CostCents <= 0 {
+		return nil
+	}
+	key := "label-" + label.LabelID
+	if err := reverse(ctx, o, label.CostCents, key+"-reversal"); err != nil {
+		return fmt.Errorf("ledger: recovering postage for order %s: %w", o.OrderID, err)
+	}
+	return s.post(ctx,
+		entry(models.LedgerKindLabel, o.OrderID, key, "shipping label "+label.TrackingNumber,
+			posting(SellerPending(o.SellerID), label.CostCents),
+			posting(ProcessorClearing, -label.CostCents),
+		),
+		entry(models.LedgerKindReversal, o.OrderID, key+"-reversal", "transfer reversed for postage",
+			posting(ProcessorClearing, label.CostCents),
+			posting(SellerConnected(o.SellerID), -label.CostCents),
+		),
+	)
+}
+
+// Refundable returns how much of an order's sale hasn't been refunded yet
+func (s *Service) Refundable(ctx context.Context, o models.Order) (int64, error) {
+	nets, err := s.orderNets(ctx, o.OrderID, models.LedgerKindLabel)
+	if err != nil {
+		return 0, err
+	}
+	return -(nets[SellerPending(o.SellerID)] + nets[SellerAvailable(o.SellerID)] + nets[PlatformRevenue]), nil
+}
+
+// RecordRefund reverses amountCents of an order's sale back to the buyer; zero refunds whatever
+// remains. The platform fee is returned in proportion, and the seller's share comes out of their
+// pending balance first and then their available balance, and is taken back from their connected
+// account as the processor reverses that much of the transfer. key makes the refund idempotent. The
+// sale is recorded first, in case the refund arrives before the order's paid event.
+func (s *Service) RecordRefund(ctx context.Context, o models.Order, amountCents int64, key string) error {
+	if err := s.RecordSale(ctx, o); err != nil {
+		return err
+	}
+	// postage isn't part of the sale, so it neither limits nor adds to what can be refunded
+	nets, err := s.orderNets(ctx, o.OrderID, models.LedgerKindLabel)
+	if err != nil {
+		return err
+	}
+
+	pending, available := SellerPending(o.SellerID), SellerAvailable(o.SellerID)
+	sellerHeld := -(nets[pending] + nets[available])
+	feeHeld := -nets[PlatformRevenue]
+	remaining := sellerHeld + feeHeld
+
+	if amountCents == 0 {
+		amountCents = remaining
+	}
+	if amountCents <= 0 {
+		return nil
+	}
+	if amountCents > remaining {
+		return fmt.Errorf("%w: %d of %d on order %s", ErrRefundExceedsSale, amountCents, remaining, o.OrderID)
+	}
+
+	feeBack := feeHeld * amountCents / remaining
+	sellerBack := amountCents - feeBack
+	fromPending := sellerBack
+	if held := -nets[pending]; fromPending > held {
+		fromPending = max(held, 0)
+	}
+
+	buyer := BuyerAccount(o.BuyerID)
+	return s.post(ctx,
+		entry(models.LedgerKindRefund, o.OrderID, key, "order refunded",
+			posting(pending, fromPending),
+			posting(available, sellerBack-fromPending),
+			posting(PlatformRevenue, feeBack),
+			posting(buyer, -amountCents),
+		),
+		entry(models.LedgerKindReversal, o.OrderID, key+"-reversal", "transfer reversed for refund",
+			posting(ProcessorClearing, sellerBack),
+			posting(SellerConnected(o.SellerID), -sellerBack),
+		),
+		entry(models.LedgerKindRefundPayment, o.OrderID, key+"-payment", "refund sent",
+			posting(buyer, amountCents),
+			posting(ProcessorClearing, -amountCents),
+		),
+	)
+}
+
+// Payout pays a seller's whole available balance out of their connected account with pay and
+// records it against the processor's payout ID. The payout is keyed by the latest entry on the
+// available balance, so retrying before anything else changes it pays the same payout, never a
+// second one. It returns ErrInsufficientFunds when nothing is available.
+func (s *Service) Payout(ctx context.Context, sellerID string, pay PayoutFunc) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	available := SellerAvailable(sellerID)
+	entries, err := s.store.ByAccount(ctx, available)
+	if err != nil {
+		return 0, err
+	}
+	var amount int64
+	var latest models.LedgerEntry
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			if p.Account == available {
+				amount -= p.AmountCents
+			}
+		}
+		if e.SK > latest.SK {
+			latest = e
+		}
+	}
+	if amount <= 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInsufficientFunds, amount)
+	}
+
+	payoutID, err := pay(ctx, sellerID, amount, "seller-"+sellerID+"-payout-"+latest.EntryID)
+	if err != nil {
+		return 0, err
+	}
+	err = s.post(ctx, entry(models.LedgerKindPayout, "", "payout-"+payoutID, "payout "+payoutID,
+		posting(available, amount),
+		posting(SellerConnected(sellerID), -amount),
+	))
+	return amount, err
+}
+
+// Balance returns an account's balance, debits positive
+func (s *Service) Balance(ctx context.Context, account string) (int64, error) {
+	entries, err := s.store.ByAccount(ctx, account)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			if p.Account == account {
+				total += p.AmountCents
+			}
+		}
+	}
+	return total, nil
+}
+
+// SellerBalances returns what the platform owes a seller, split into pending and available
+func (s *Service) SellerBalances(ctx context.Context, sellerID string) (Balances, error) {
+	pending, err := s.Balance(ctx, SellerPending(sellerID))
+	if err != nil {
+		return Balances{}, err
+	}
+	available, err := s.Balance(ctx, SellerAvailable(sellerID))
+	if err != nil {
+		return Balances{}, err
+	}
+	return Balances{PendingCents: -pending, AvailableCents: -available}, nil
+}
+
+// SellerStatement returns the entries touching a seller's balances, newest first
+func (s *Service) SellerStatement(ctx context.Context, sellerID string) ([]Line, error) {
+	pending, available := SellerPending(sellerID), SellerAvailable(sellerID)
+
+	byID := map[string]Line{}
+	for _, account := range []string{pending, available} {
+		entries, err := s.store.ByAccount(ctx, account)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if _, seen := byID[e.EntryID]; seen {
+				continue
+			}
+			line := Line{Entry: e}
+			for _, p := range e.Postings {
+				switch p.Account {
+				case pending:
+					line.PendingCents -= p.AmountCents
+				case available:
+					line.AvailableCents -= p.AmountCents
+				}
+			}
+			byID[e.EntryID] = line
+		}
+	}
+
+	out := make([]Line, 0, len(byID))
+	for _, l := range byID {
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Entry.SK > out[j].Entry.SK
+	})
+	return out, nil
+}
+
+// orderNets sums an order's postings per account, leaving out entries of the skipped kinds
+func (s *Service) orderNets(ctx context.Context, orderID string, skip ...string) (map[string]int64, error) {
+	entries, err := s.store.ByOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	nets := map[string]int64{}
+	for _, e := range entries {
+		if slices.Contains(skip, e.Kind) {
+			continue
+		}
+		for _, p := range e.Postings {
+			nets[p.Account] += p.AmountCents
+		}
+	}
+	return nets, nil
+}
+
+// post validates and appends entries together. Entries already recorded under the same
+// idempotency key are treated as success, so replaying an event is harmless.
+func (s *Service) post(ctx context.Context, entries ...models.LedgerEntry) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for i := range entries {
+		e := &entries[i]
+
+		var sum int64
+		for _, p := range e.Postings {
+			sum += p.AmountCents
+		}
+		if sum != 0 || len(e.Postings) < 2 {
+			return fmt.Errorf("%w: %s entry %q sums to %d", ErrUnbalanced, e.Kind, e.IdempotencyKey, sum)
+		}
+
+		e.EntryID = ids.New()
+		e.CreatedAt = now
+		e.Type = models.ItemTypeLedger
+		e.PK, e.SK = models.LedgerEntryKey(now, e.EntryID)
+		e.GSI2PK, e.GSI2SK = models.JournalKey(now, e.EntryID)
+		if e.OrderID != "" {
+			e.GSI1PK, e.GSI1SK = models.OrderLedgerKey(e.OrderID, now, e.EntryID)
+		}
+	}
+
+	if err := s.store.Append(ctx, entries); err != nil && !errors.Is(err, ErrDuplicate) {
+		return err
+	}
+	return nil
+}
+
+// entry builds an unsaved journal entry
+func entry(kind, orderID, key, memo string, postings ...models.LedgerPosting) models.LedgerEntry {
+	e := models.LedgerEntry{Kind: kind, OrderID: orderID, IdempotencyKey: key, Memo: memo}
+	for _, p := range postings {
+		if p.AmountCents != 0 {
+			e.Postings = append(e.Postings, p)
+		}
+	}
+	return e
+}
+
+// posting builds a posting; zero postings are dropped by entry
+func posting(account string, amountCents int64) models.LedgerPosting {
+	return models.LedgerPosting{Account: account, AmountCents: amountCents}
+}
@@ -0,0 +1,350 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+func newService() *Service {
+	return New(NewMemoryStore(), Options{ProcessingBasisPoints: 290, ProcessingFixedCents: 30}, log.New(io.Discard, "", 0))
+}
+
+// order is a paid $100 order with a $10 platform fee
+func order(id string) models.Order {
+	return models.Order{OrderID: id, BuyerID: "buyer", SellerID: "seller", TotalCents: 10000, FeeCents: 1000, PaymentIntent: "pi_" + id}
+}
+
+func balance(t *testing.T, s *Service, account string) int64 {
+	t.Helper()
+	b, err := s.Balance(context.Background(), account)
+	if err != nil {
+		t.Fatalf("balance of %s: %v", account, err)
+	}
+	return b
+}
+
+// noReverse stands in for the processor when a test doesn't care about reversals
+func noReverse(context.Context, models.Order, int64, string) error { return nil }
+
+func TestRecordSaleBalances(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	o := order("o1")
+
+	// replaying the sale, as a redelivered event would, records it once
+	for i := 0; i < 2; i++ {
+		if err := s.RecordSale(ctx, o); err != nil {
+			t.Fatalf("recording sale: %v", err)
+		}
+	}
+
+	b, err := s.SellerBalances(ctx, "seller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.PendingCents != 9000 || b.AvailableCents != 0 {
+		t.Fatalf("balances = %+v, want 9000 pending", b)
+	}
+	if got := balance(t, s, PlatformRevenue); got != -1000 {
+		t.Errorf("platform revenue = %d, want -1000", got)
+	}
+	// 2.9% + 30¢ of $100
+	if got := balance(t, s, PlatformProcessing); got != 320 {
+		t.Errorf("processing = %d, want 320", got)
+	}
+	// the seller's share went straight to their connected account, leaving the fee less processing
+	if got := balance(t, s, SellerConnected("seller")); got != 9000 {
+		t.Errorf("connected = %d, want 9000", got)
+	}
+	if got := balance(t, s, ProcessorClearing); got != 10000-9000-320 {
+		t.Errorf("clearing = %d, want %d", got, 10000-9000-320)
+	}
+
+	if err := s.Release(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ = s.SellerBalances(ctx, "seller"); b.PendingCents != 0 || b.AvailableCents != 9000 {
+		t.Fatalf("balances after release = %+v, want 9000 available", b)
+	}
+}
+
+func TestRecordSaleRejectsFeeOverTotal(t *testing.T) {
+	o := order("o1")
+	o.FeeCents = o.TotalCents + 1
+	if err := newService().RecordSale(context.Background(), o); err == nil {
+		t.Fatal("sale with a fee over the total was recorded")
+	}
+}
+
+func TestRecordRefund(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	o := order("o1")
+	if err := s.RecordSale(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+
+	// a quarter back returns a quarter of the fee and takes the rest from the seller
+	if err := s.RecordRefund(ctx, o, 2500, "r1"); err != nil {
+		t.Fatalf("partial refund: %v", err)
+	}
+	if err := s.RecordRefund(ctx, o, 2500, "r1"); err != nil {
+		t.Fatalf("replayed refund: %v", err)
+	}
+	if b, _ := s.SellerBalances(ctx, "seller"); b.PendingCents != 6750 {
+		t.Errorf("pending after partial refund = %d, want 6750", b.PendingCents)
+	}
+	if got := balance(t, s, PlatformRevenue); got != -750 {
+		t.Errorf("revenue after partial refund = %d, want -750", got)
+	}
+	if got := balance(t, s, SellerConnected("seller")); got != 6750 {
+		t.Errorf("connected after partial refund = %d, want 6750", got)
+	}
+
+	left, err := s.Refundable(ctx, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != 7500 {
+		t.Fatalf("refundable = %d, want 7500", left)
+	}
+	if err := s.RecordRefund(ctx, o, left+1, "r2"); !errors.Is(err, ErrRefundExceedsSale) {
+		t.Fatalf("refund over what's left: err = %v, want ErrRefundExceedsSale", err)
+	}
+
+	// zero refunds whatever remains, and the processor keeps its fee
+	if err := s.RecordRefund(ctx, o, 0, "r3"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := s.SellerBalances(ctx, "seller"); b.PendingCents != 0 || b.AvailableCents != 0 {
+		t.Errorf("balances after full refund = %+v, want zero", b)
+	}
+	if got := balance(t, s, BuyerAccount("buyer")); got != 0 {
+		t.Errorf("buyer = %d, want 0", got)
+	}
+	if got := balance(t, s, ProcessorClearing); got != -320 {
+		t.Errorf("clearing = %d, want the processing fee -320", got)
+	}
+}
+
+func TestRefundAfterReleaseComesFromAvailable(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	o := order("o1")
+	if err := s.RecordSale(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Release(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordRefund(ctx, o, 0, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := s.SellerBalances(ctx, "seller"); b.PendingCents != 0 || b.AvailableCents != 0 {
+		t.Fatalf("balances = %+v, want zero", b)
+	}
+}
+
+func TestChargeLabel(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	o := order("o1")
+	if err := s.RecordSale(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	label := models.ShippingLabel{LabelID: "l1", TrackingNumber: "1Z", CostCents: 450}
+
+	failing := func(context.Context, models.Order, int64, string) error { return errors.New("processor down") }
+	if err := s.ChargeLabel(ctx, o, label, failing); err == nil {
+		t.Fatal("label booked although the postage couldn't be recovered")
+	}
+	if b, _ := s.SellerBalances(ctx, "seller"); b.PendingCents != 9000 {
+		t.Fatalf("pending after failed charge = %d, want 9000", b.PendingCents)
+	}
+
+	var keys []string
+	reverse := func(_ context.Context, _ models.Order, amount int64, key string) error {
+		if amount != 450 {
+			t.Errorf("reversed %d, want 450", amount)
+		}
+		keys = append(keys, key)
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.ChargeLabel(ctx, o, label, reverse); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(keys) != 2 || keys[0] != keys[1] {
+		t.Errorf("reversal keys = %v, want the same key each time", keys)
+	}
+	if b, _ := s.SellerBalances(ctx, "seller"); b.PendingCents != 8550 {
+		t.Errorf("pending = %d, want 8550", b.PendingCents)
+	}
+	if got := balance(t, s, SellerConnected("seller")); got != 8550 {
+		t.Errorf("connected = %d, want 8550", got)
+	}
+
+	// postage isn't part of the sale, so the buyer can still get all of it back
+	if left, _ := s.Refundable(ctx, o); left != 10000 {
+		t.Errorf("refundable = %d, want 10000", left)
+	}
+}
+
+func TestPayout(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	o := order("o1")
+	if err := s.RecordSale(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+
+	var paid []string
+	pay := func(_ context.Context, sellerID string, amount int64, key string) (string, error) {
+		paid = append(paid, key)
+		return "po_" + key, nil
+	}
+	if _, err := s.Payout(ctx, "seller", pay); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("payout of pending earnings: err = %v, want ErrInsufficientFunds", err)
+	}
+	if len(paid) != 0 {
+		t.Fatal("processor asked to pay out pending earnings")
+	}
+
+	if err := s.Release(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	amount, err := s.Payout(ctx, "seller", pay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount != 9000 {
+		t.Fatalf("paid out %d, want 9000", amount)
+	}
+	if b, _ := s.SellerBalances(ctx, "seller"); b.AvailableCents != 0 {
+		t.Errorf("available after payout = %d, want 0", b.AvailableCents)
+	}
+	if got := balance(t, s, SellerConnected("seller")); got != 0 {
+		t.Errorf("connected after payout = %d, want 0", got)
+	}
+	if _, err := s.Payout(ctx, "seller", pay); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("second payout: err = %v, want ErrInsufficientFunds", err)
+	}
+}
+
+func TestPayoutRetryReusesKey(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	o := order("o1")
+	if err := s.RecordSale(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Release(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	failing := func(_ context.Context, _ string, _ int64, key string) (string, error) {
+		keys = append(keys, key)
+		return "", errors.New("timeout")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Payout(ctx, "seller", failing); err == nil {
+			t.Fatal("failed payout reported success")
+		}
+	}
+	if keys[0] != keys[1] {
+		t.Fatalf("retried payout keys %q and %q differ, so the processor could pay twice", keys[0], keys[1])
+	}
+	if b, _ := s.SellerBalances(ctx, "seller"); b.AvailableCents != 9000 {
+		t.Errorf("available after failed payouts = %d, want 9000", b.AvailableCents)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	for _, id := range []string{"o1", "o2"} {
+		if err := s.RecordSale(ctx, order(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.RecordRefund(ctx, order("o2"), 0, "r"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ChargeLabel(ctx, order("o1"), models.ShippingLabel{LabelID: "l1", CostCents: 450}, noReverse); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Reconcile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Balanced() {
+		t.Fatalf("clean ledger flagged: %+v", report.Issues)
+	}
+	if report.Orders != 2 {
+		t.Errorf("orders = %d, want 2", report.Orders)
+	}
+
+	// the processor holding something else than the ledger says is flagged per seller
+	var held int64 = 8550
+	s.SetBalanceFunc(func(context.Context, string) (int64, error) { return held, nil })
+	if report, _ = s.Reconcile(ctx); !report.Balanced() {
+		t.Fatalf("matching processor balance flagged: %+v", report.Issues)
+	}
+	held = 8000
+	report, _ = s.Reconcile(ctx)
+	if len(report.Issues) != 1 || report.Issues[0].SellerID != "seller" || report.Issues[0].AmountCents != -550 {
+		t.Fatalf("issues = %+v, want the seller's connected account 550 short", report.Issues)
+	}
+}
+
+func TestReconcileFlagsShareNotSent(t *testing.T) {
+	ctx := context.Background()
+	s := newService()
+	o := order("o1")
+
+	// a sale booked without its transfer, as a writer that skipped it would leave it
+	err := s.post(ctx,
+		entry(models.LedgerKindPayment, o.OrderID, "p", "payment received",
+			posting(ProcessorClearing, o.TotalCents),
+			posting(BuyerAccount(o.BuyerID), -o.TotalCents),
+		),
+		entry(models.LedgerKindSale, o.OrderID, "s", "order sold",
+			posting(BuyerAccount(o.BuyerID), o.TotalCents),
+			posting(SellerPending(o.SellerID), -(o.TotalCents-o.FeeCents)),
+			posting(PlatformRevenue, -o.FeeCents),
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Reconcile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].OrderID != "o1" || report.Issues[0].AmountCents != -9000 {
+		t.Fatalf("issues = %+v, want o1's 9000 share flagged as not sent", report.Issues)
+	}
+	if report.TrialBalanceCents != 0 {
+		t.Errorf("trial balance = %d, want 0", report.TrialBalanceCents)
+	}
+}
+
+func TestPostRejectsUnbalancedEntry(t *testing.T) {
+	s := newService()
+	err := s.post(context.Background(), entry(models.LedgerKindSale, "o1", "k", "bad",
+		posting(PlatformRevenue, 100),
+		posting(ProcessorClearing, -99),
+	))
+	if !errors.Is(err, ErrUnbalanced) {
+		t.Fatalf("err = %v, want ErrUnbalanced", err)
+	}
+}
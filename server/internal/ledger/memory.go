@@ -0,0 +1,84 @@
+package ledger
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	entries   []models.LedgerEntry
+	keys      map[string]bool
+	byOrder   map[string][]int
+	byAccount map[string][]int
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		keys:      map[string]bool{},
+		byOrder:   map[string][]int{},
+		byAccount: map[string][]int{},
+	}
+}
+
+// Append stores entries atomically, rejecting the batch if any idempotency key is reused
+func (s *MemoryStore) Append(ctx context.Context, entries []models.LedgerEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range entries {
+		if s.keys[e.IdempotencyKey] {
+			return ErrDuplicate
+		}
+	}
+
+	for _, e := range entries {
+		i := len(s.entries)
+		s.entries = append(s.entries, e)
+		s.keys[e.IdempotencyKey] = true
+		if e.OrderID != "" {
+			s.byOrder[e.OrderID] = append(s.byOrder[e.OrderID], i)
+		}
+		seen := map[string]bool{}
+		for _, p := range e.Postings {
+			if !seen[p.Account] {
+				seen[p.Account] = true
+				s.byAccount[p.Account] = append(s.byAccount[p.Account], i)
+			}
+		}
+	}
+	return nil
+}
+
+// ByOrder returns an order's entries, oldest first
+func (s *MemoryStore) ByOrder(ctx context.Context, orderID string) ([]models.LedgerEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.collect(s.byOrder[orderID]), nil
+}
+
+// ByAccount returns the entries posting to an account, oldest first
+func (s *MemoryStore) ByAccount(ctx context.Context, account string) ([]models.LedgerEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.collect(s.byAccount[account]), nil
+}
+
+// All returns every entry, oldest first
+func (s *MemoryStore) All(ctx context.Context) ([]models.LedgerEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.LedgerEntry(nil), s.entries...), nil
+}
+
+// collect copies the entries at the given positions
+func (s *MemoryStore) collect(idx []int) []models.LedgerEntry {
+	out := make([]models.LedgerEntry, 0, len(idx))
+	for _, i := range idx {
+		out = append(out, s.entries[i])
+	}
+	return out
+}
@@ -0,0 +1,174 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// Issue is a problem found by reconciliation, with an order or with a seller's connected account
+type Issue struct {
+	OrderID     string
+	SellerID    string
+	Problem     string
+	AmountCents int64
+}
+
+// Report is the result of reconciling the ledger against itself, orders and the payment processor
+type Report struct {
+	GeneratedAt       string
+	Entries           int
+	Orders            int
+	TrialBalanceCents int64 // sum of every posting; anything but zero means the journal is corrupt
+	Issues            []Issue
+}
+
+// Balanced reports whether reconciliation found nothing wrong
+func (r Report) Balanced() bool {
+	return r.TrialBalanceCents == 0 && len(r.Issues) == 0
+}
+
+// paidStatuses are the order statuses in which the buyer's payment should be on the ledger
+var paidStatuses = []string{
+	models.OrderStatusPaid,
+	models.OrderStatusShipped,
+	models.OrderStatusDelivered,
+	models.OrderStatusCompleted,
+}
+
+// Reconcile checks that each buyer has paid exactly what they were charged, that what an order
+// owes its seller is what was sent to their connected account for it, and, once attached to
+// orders, that the ledger agrees with order state. With a BalanceFunc set, each seller's connected
+// account on the ledger is also checked against what the payment processor says it holds.
+func (s *Service) Reconcile(ctx context.Context) (Report, error) {
+	report := Report{GeneratedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	entries, err := s.store.All(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Entries = len(entries)
+
+	byOrder := map[string][]models.LedgerEntry{}
+	connected := map[string]int64{}
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			report.TrialBalanceCents += p.AmountCents
+			if sellerID, ok := connectedSeller(p.Account); ok {
+				connected[sellerID] += p.AmountCents
+			}
+		}
+		if e.OrderID != "" {
+			byOrder[e.OrderID] = append(byOrder[e.OrderID], e)
+		}
+	}
+	report.Orders = len(byOrder)
+
+	sales := map[string]int64{}
+	held := map[string]int64{}
+	for orderID, list := range byOrder {
+		var owed, sent, buyer int64
+		for _, e := range list {
+			for _, p := range e.Postings {
+				earnings := earningsAccount(p.Account)
+				if earnings {
+					owed -= p.AmountCents
+				}
+				if _, ok := connectedSeller(p.Account); ok {
+					sent += p.AmountCents
+				}
+				switch {
+				case strings.HasPrefix(p.Account, "buyer:"):
+					buyer += p.AmountCents
+				case e.Kind == models.LedgerKindLabel:
+					// postage stays charged to the seller whatever happens to the order
+				case earnings || p.Account == PlatformRevenue:
+					held[orderID] -= p.AmountCents
+				}
+			}
+			if e.Kind == models.LedgerKindSale {
+				for _, p := range e.Postings {
+					if p.AmountCents > 0 {
+						sales[orderID] += p.AmountCents
+					}
+				}
+			}
+		}
+		if owed != sent {
+			report.Issues = append(report.Issues, Issue{OrderID: orderID, Problem: "seller's share differs from what their connected account was sent", AmountCents: sent - owed})
+		}
+		if buyer != 0 {
+			report.Issues = append(report.Issues, Issue{OrderID: orderID, Problem: "buyer charges and payments differ", AmountCents: buyer})
+		}
+	}
+
+	s.mu.Lock()
+	o, balance := s.orders, s.balance
+	s.mu.Unlock()
+	if o != nil {
+		for _, status := range paidStatuses {
+			list, err := o.WithStatus(ctx, status)
+			if err != nil {
+				return report, err
+			}
+			for _, order := range list {
+				switch sale, ok := sales[order.OrderID]; {
+				case !ok:
+					report.Issues = append(report.Issues, Issue{OrderID: order.OrderID, Problem: fmt.Sprintf("%s order has no sale entry", status), AmountCents: order.TotalCents})
+				case sale != order.TotalCents:
+					report.Issues = append(report.Issues, Issue{OrderID: order.OrderID, Problem: "sale entry does not match order total", AmountCents: sale - order.TotalCents})
+				}
+			}
+		}
+		for _, status := range []string{models.OrderStatusCancelled, models.OrderStatusRefunded} {
+			list, err := o.WithStatus(ctx, status)
+			if err != nil {
+				return report, err
+			}
+			for _, order := range list {
+				if h := held[order.OrderID]; h != 0 {
+					report.Issues = append(report.Issues, Issue{OrderID: order.OrderID, Problem: fmt.Sprintf("%s order still holds funds", status), AmountCents: h})
+				}
+			}
+		}
+	}
+
+	if balance != nil {
+		for sellerID, onLedger := range connected {
+			held, err := balance(ctx, sellerID)
+			if err != nil {
+				return report, fmt.Errorf("ledger: balance of seller %s: %w", sellerID, err)
+			}
+			if held != onLedger {
+				report.Issues = append(report.Issues, Issue{SellerID: sellerID, Problem: "connected account differs from the payment processor", AmountCents: held - onLedger})
+			}
+		}
+	}
+
+	sort.Slice(report.Issues, func(i, j int) bool {
+		a, b := report.Issues[i], report.Issues[j]
+		if a.OrderID != b.OrderID {
+			return a.OrderID < b.OrderID
+		}
+		return a.SellerID < b.SellerID
+	})
+	return report, nil
+}
+
+// earningsAccount reports whether an account holds a seller's pending or available earnings
+func earningsAccount(account string) bool {
+	return strings.HasPrefix(account, "seller:") && (strings.HasSuffix(account, ":pending") || strings.HasSuffix(account, ":available"))
+}
+
+// connectedSeller returns the seller whose connected account an account name is
+func connectedSeller(account string) (string, bool) {
+	rest, ok := strings.CutPrefix(account, "seller:")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(rest, ":connected")
+}
@@ -0,0 +1,49 @@
+package messages
+
+import (
+	"regexp"
+	"strings"
+)
+
+// contactPatterns find the ways people try to take a sale off the platform, each with the name
+// used in the error. They are deliberately broad; a false positive costs the sender a rewording,
+// while a miss costs the buyer their purchase protection.
+var contactPatterns = []struct {
+	what string
+	re   *regexp.Regexp
+}{
+	{"an email address", regexp.MustCompile(`(?i)[a-z0-9._%+\-]+\s*@\s*[a-z0-9\-]+(\.[a-z0-9\-]+)*\.[a-z]{2,}`)},
+	{"an email address", regexp.MustCompile(`(?i)[a-z0-9._%+\-]+\s*(\(at\)|\[at\])\s*[a-z0-9\-]+\s*(\.|\(dot\)|\[dot\]|\sdot\s)\s*[a-z]{2,}`)},
+	{"an email address", regexp.MustCompile(`(?i)[a-z0-9._%+\-]+\s+at\s+[a-z0-9\-]+\s+dot\s+[a-z]{2,}`)},
+	{"a phone number", regexp.MustCompile(`(\+?\d[\s.\-()]*){7,}`)},
+	{"a link", regexp.MustCompile(`(?i)(https?://|www\.)\S+|\b[a-z0-9\-]+\.(com|net|org|io|co|me|gg|shop|store)\b`)},
+	{"a payment or chat handle", regexp.MustCompile(`(?i)\b(venmo|paypal|cash\s*app|cashapp|zelle|whatsapp|telegram|signal|discord|instagram|insta|snapchat|facebook|fb)\b|(^|\s)[@$][a-z][a-z0-9_.]{2,}`)},
+}
+
+// findContactInfo returns what kind of contact details the text shares, or "" when it has none
+func findContactInfo(text string) string {
+	for _, p := range contactPatterns {
+		if p.re.MatchString(text) {
+			return p.what
+		}
+	}
+	// digits spelled out as words, such as "five five five one two three four"
+	digits := 0
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return r == ' ' || r == '-' || r == ',' }) {
+		if spelledDigits[w] {
+			digits++
+			if digits >= 7 {
+				return "a phone number"
+			}
+		} else {
+			digits = 0
+		}
+	}
+	return ""
+}
+
+// spelledDigits are the words a phone number may be written out in
+var spelledDigits = map[string]bool{
+	"zero": true, "oh": true, "one": true, "two": true, "three": true, "four": true,
+	"five": true, "six": true, "seven": true, "eight": true, "nine": true,
+}
@@ -0,0 +1,147 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. A thread's messages share its partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// GetThread returns a thread
+func (s *DynamoStore) GetThread(ctx context.Context, threadID string) (models.MessageThread, error) {
+	var t models.MessageThread
+	pk, sk := models.ThreadKey(threadID)
+	err := s.table.Get(ctx, pk, sk, &t)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return t, ErrNotFound
+	}
+	return t, err
+}
+
+// UpdateThread writes a thread if its stored version is still expectedVersion
+func (s *DynamoStore) UpdateThread(ctx context.Context, t models.MessageThread, expectedVersion int64) error {
+	return s.write(ctx, dynamo.PutOp(t, dynamo.IfVersion(expectedVersion)))
+}
+
+// ThreadsByUser returns a user's threads as buyer or seller, most recent activity first
+func (s *DynamoStore) ThreadsByUser(ctx context.Context, userID string) ([]models.MessageThread, error) {
+	var asBuyer, asSeller []models.MessageThread
+	pk, _ := models.BuyerThreadKey(userID, "", "")
+	if err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, SKPrefix: "THREAD#"}, &asBuyer); err != nil {
+		return nil, err
+	}
+	pk, _ = models.SellerThreadKey(userID, "", "")
+	if err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk, SKPrefix: "THREAD#"}, &asSeller); err != nil {
+		return nil, err
+	}
+	out := append(asBuyer, asSeller...)
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK > out[j].GSI1SK })
+	return out, nil
+}
+
+// AddMessage writes a message and its thread if the thread is still at expectedVersion
+func (s *DynamoStore) AddMessage(ctx context.Context, t models.MessageThread, expectedVersion int64, m models.Message) error {
+	cond := dynamo.IfVersion(expectedVersion)
+	if expectedVersion == 0 {
+		cond = dynamo.IfNotExists()
+	}
+	return s.write(ctx, dynamo.PutOp(t, cond), dynamo.PutOp(m, dynamo.Condition{}))
+}
+
+// Messages returns a thread's messages, oldest first
+func (s *DynamoStore) Messages(ctx context.Context, threadID string) ([]models.Message, error) {
+	var out []models.Message
+	pk, _ := models.ThreadKey(threadID)
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "MESSAGE#"}, &out)
+	return out, err
+}
+
+// GetMessage returns one message in a thread. A message's key starts with when it was sent, so
+// it is found among the thread's messages.
+func (s *DynamoStore) GetMessage(ctx context.Context, threadID, messageID string) (models.Message, error) {
+	list, err := s.Messages(ctx, threadID)
+	if err != nil {
+		return models.Message{}, err
+	}
+	for _, m := range list {
+		if m.MessageID == messageID {
+			return m, nil
+		}
+	}
+	return models.Message{}, ErrNotFound
+}
+
+// UpdateMessage replaces a stored message
+func (s *DynamoStore) UpdateMessage(ctx context.Context, m models.Message) error {
+	err := s.table.Put(ctx, m, dynamo.IfExists())
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// MessagesByStatus returns the messages in a status, oldest first
+func (s *DynamoStore) MessagesByStatus(ctx context.Context, status string) ([]models.Message, error) {
+	var out []models.Message
+	pk, _ := models.MessageStatusKey(status, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk}, &out)
+	return out, err
+}
+
+// PutBlock stores a block, replacing an earlier one between the same users
+func (s *DynamoStore) PutBlock(ctx context.Context, b models.MessageBlock) error {
+	return s.table.Put(ctx, b, dynamo.Condition{})
+}
+
+// DeleteBlock removes a block
+func (s *DynamoStore) DeleteBlock(ctx context.Context, blockerID, blockedID string) error {
+	pk, sk := models.MessageBlockKey(blockerID, blockedID)
+	err := s.table.Delete(ctx, pk, sk, dynamo.IfExists())
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Blocked reports whether blockerID has blocked blockedID
+func (s *DynamoStore) Blocked(ctx context.Context, blockerID, blockedID string) (bool, error) {
+	var b models.MessageBlock
+	pk, sk := models.MessageBlockKey(blockerID, blockedID)
+	switch err := s.table.Get(ctx, pk, sk, &b); {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, dynamo.ErrNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Blocks returns every block, newest first
+func (s *DynamoStore) Blocks(ctx context.Context) ([]models.MessageBlock, error) {
+	var out []models.MessageBlock
+	pk, _ := models.RecentBlockKey("", "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, Descending: true}, &out)
+	return out, err
+}
+
+// write applies ops in one transaction, mapping a failed condition to ErrConflict
+func (s *DynamoStore) write(ctx context.Context, ops ...dynamo.Op) error {
+	err := s.table.Write(ctx, ops...)
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,168 @@
+package messages
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	threads  map[string]models.MessageThread // threadID -> thread
+	messages map[string][]models.Message     // threadID -> messages, oldest first
+	blocks   map[[2]string]models.MessageBlock
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		threads:  map[string]models.MessageThread{},
+		messages: map[string][]models.Message{},
+		blocks:   map[[2]string]models.MessageBlock{},
+	}
+}
+
+// GetThread returns a thread
+func (s *MemoryStore) GetThread(ctx context.Context, threadID string) (models.MessageThread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.threads[threadID]
+	if !ok {
+		return t, ErrNotFound
+	}
+	return t, nil
+}
+
+// UpdateThread writes a thread if its stored version is still expectedVersion
+func (s *MemoryStore) UpdateThread(ctx context.Context, t models.MessageThread, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.threads[t.ThreadID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.threads[t.ThreadID] = t
+	return nil
+}
+
+// ThreadsByUser returns a user's threads as buyer or seller, most recent activity first
+func (s *MemoryStore) ThreadsByUser(ctx context.Context, userID string) ([]models.MessageThread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.MessageThread
+	for _, t := range s.threads {
+		if t.Participant(userID) {
+			out = append(out, t)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK > out[j].GSI1SK })
+	return out, nil
+}
+
+// AddMessage writes a message and its thread if the thread is still at expectedVersion
+func (s *MemoryStore) AddMessage(ctx context.Context, t models.MessageThread, expectedVersion int64, m models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.threads[t.ThreadID]
+	if ok != (expectedVersion > 0) || cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.threads[t.ThreadID] = t
+	s.messages[t.ThreadID] = append(s.messages[t.ThreadID], m)
+	return nil
+}
+
+// Messages returns a thread's messages, oldest first
+func (s *MemoryStore) Messages(ctx context.Context, threadID string) ([]models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.Message(nil), s.messages[threadID]...), nil
+}
+
+// GetMessage returns one message in a thread
+func (s *MemoryStore) GetMessage(ctx context.Context, threadID, messageID string) (models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range s.messages[threadID] {
+		if m.MessageID == messageID {
+			return m, nil
+		}
+	}
+	return models.Message{}, ErrNotFound
+}
+
+// UpdateMessage replaces a stored message
+func (s *MemoryStore) UpdateMessage(ctx context.Context, m models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.messages[m.ThreadID]
+	for i := range list {
+		if list[i].MessageID == m.MessageID {
+			list[i] = m
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// MessagesByStatus returns the messages in a status, oldest first
+func (s *MemoryStore) MessagesByStatus(ctx context.Context, status string) ([]models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Message
+	for _, list := range s.messages {
+		for _, m := range list {
+			if m.Status == status {
+				out = append(out, m)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK < out[j].GSI1SK })
+	return out, nil
+}
+
+// PutBlock stores a block, replacing an earlier one between the same users
+func (s *MemoryStore) PutBlock(ctx context.Context, b models.MessageBlock) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks[[2]string{b.BlockerID, b.BlockedID}] = b
+	return nil
+}
+
+// DeleteBlock removes a block
+func (s *MemoryStore) DeleteBlock(ctx context.Context, blockerID, blockedID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{blockerID, blockedID}
+	if _, ok := s.blocks[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.blocks, key)
+	return nil
+}
+
+// Blocked reports whether blockerID has blocked blockedID
+func (s *MemoryStore) Blocked(ctx context.Context, blockerID, blockedID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.blocks[[2]string{blockerID, blockedID}]
+	return ok, nil
+}
+
+// Blocks returns every block, newest first
+func (s *MemoryStore) Blocks(ctx context.Context) ([]models.MessageBlock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]models.MessageBlock, 0, len(s.blocks))
+	for _, b := range s.blocks {
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK > out[j].GSI1SK })
+	return out, nil
+}
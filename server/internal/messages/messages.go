@@ -0,0 +1,531 @@
+// Package messages lets buyers and sellers talk in threads scoped to a listing or an order.
+//
+// A buyer asks about a listing before buying; either side of an order can write about it after.
+// Until the buyer has an order with the seller, messages that share an email address, phone number,
+// link or payment handle are refused so sales stay on the platform. Either side can report a
+// message, which puts it in the admin moderation queue, or block the other from messaging them.
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+)
+
+var (
+	// ErrNotFound is returned when a thread, message or block does not exist
+	ErrNotFound = errors.New("messages: not found")
+	// ErrConflict is returned when a thread was changed concurrently
+	ErrConflict = errors.New("messages: version conflict")
+	// ErrNotAllowed is returned when the user isn't part of the thread or may not take the action
+	ErrNotAllowed = errors.New("messages: not allowed")
+	// ErrInvalid is returned for an empty or overlong message, or a missing reason
+	ErrInvalid = errors.New("messages: invalid message")
+	// ErrBlocked is returned when one side has blocked the other
+	ErrBlocked = errors.New("messages: blocked")
+	// ErrContactInfo is returned when a message shares contact details before the buyer has an order
+	ErrContactInfo = errors.New("messages: contact details aren't allowed before an order")
+)
+
+const (
+	// MaxBodyLength caps the length of a message
+	MaxBodyLength = 2000
+	// previewLength is how much of the latest message a thread keeps for the inbox
+	previewLength = 100
+	// maxAttempts bounds the retries when a thread changes under a new message
+	maxAttempts = 3
+)
+
+// Store persists threads, messages and blocks.
+type Store interface {
+	GetThread(ctx context.Context, threadID string) (models.MessageThread, error)
+	// UpdateThread writes a thread conditional on expectedVersion
+	UpdateThread(ctx context.Context, t models.MessageThread, expectedVersion int64) error
+	// ThreadsByUser returns the threads a user is the buyer or seller in, most recent activity first
+	ThreadsByUser(ctx context.Context, userID string) ([]models.MessageThread, error)
+	// AddMessage writes a message and its thread together, conditional on the thread still being at
+	// expectedVersion. An expectedVersion of 0 creates the thread.
+	AddMessage(ctx context.Context, t models.MessageThread, expectedVersion int64, m models.Message) error
+	// Messages returns a thread's messages, oldest first
+	Messages(ctx context.Context, threadID string) ([]models.Message, error)
+	GetMessage(ctx context.Context, threadID, messageID string) (models.Message, error)
+	UpdateMessage(ctx context.Context, m models.Message) error
+	// MessagesByStatus returns the messages in a status, oldest first
+	MessagesByStatus(ctx context.Context, status string) ([]models.Message, error)
+	PutBlock(ctx context.Context, b models.MessageBlock) error
+	DeleteBlock(ctx context.Context, blockerID, blockedID string) error
+	// Blocked reports whether blockerID has blocked blockedID
+	Blocked(ctx context.Context, blockerID, blockedID string) (bool, error)
+	// Blocks returns every block, newest first
+	Blocks(ctx context.Context) ([]models.MessageBlock, error)
+}
+
+// Scope is what a new thread is about: a listing for a buyer's question, or an order
+type Scope struct {
+	ListingID string
+	OrderID   string
+}
+
+// Notice tells a user they have a new message
+type Notice struct {
+	UserID  string // the recipient
+	Thread  models.MessageThread
+	Message models.Message
+}
+
+// Notifier emails a user about a new message. It is called when a thread goes from read to
+// unread for the recipient, so a quick back-and-forth doesn't send an email per message.
+type Notifier func(ctx context.Context, n Notice) error
+
+// Service runs buyer and seller conversations.
+type Service struct {
+	store    Store
+	catalog  *catalog.Catalog
+	orders   *orders.Service
+	errorLog *log.Logger
+	now      func() time.Time
+	notify   Notifier
+}
+
+// New creates a messages Service
+func New(store Store, c *catalog.Catalog, o *orders.Service, errorLog *log.Logger) *Service {
+	return &Service{
+		store:    store,
+		catalog:  c,
+		orders:   o,
+		errorLog: errorLog,
+		now:      time.Now,
+	}
+}
+
+// SetNotifier sets how users hear about new messages. Without one, messages are only stored.
+func (s *Service) SetNotifier(fn Notifier) {
+	s.notify = fn
+}
+
+// Start sends the first message about a listing or an order. A user who already has a thread
+// about it writes in that thread instead of starting another.
+func (s *Service) Start(ctx context.Context, senderID string, scope Scope, body string) (models.MessageThread, models.Message, error) {
+	t, err := s.scopeThread(ctx, senderID, scope)
+	if err != nil {
+		return t, models.Message{}, err
+	}
+	return s.post(ctx, t, senderID, body)
+}
+
+// Subject returns what a new thread about scope would be titled, checking the sender may start it
+func (s *Service) Subject(ctx context.Context, senderID string, scope Scope) (string, error) {
+	t, err := s.scopeThread(ctx, senderID, scope)
+	return t.Subject, err
+}
+
+// Send adds a message to a thread
+func (s *Service) Send(ctx context.Context, threadID, senderID, body string) (models.MessageThread, models.Message, error) {
+	t, err := s.store.GetThread(ctx, threadID)
+	if err != nil {
+		return t, models.Message{}, err
+	}
+	if !t.Participant(senderID) {
+		return t, models.Message{}, ErrNotAllowed
+	}
+	return s.post(ctx, t, senderID, body)
+}
+
+// Thread returns a thread and its messages, oldest first, to one of its participants
+func (s *Service) Thread(ctx context.Context, threadID, userID string) (models.MessageThread, []models.Message, error) {
+	t, err := s.store.GetThread(ctx, threadID)
+	if err != nil {
+		return t, nil, err
+	}
+	if !t.Participant(userID) {
+		return t, nil, ErrNotAllowed
+	}
+	list, err := s.store.Messages(ctx, threadID)
+	return t, list, err
+}
+
+// MarkRead clears a user's unread count on a thread
+func (s *Service) MarkRead(ctx context.Context, threadID, userID string) (models.MessageThread, error) {
+	t, err := s.store.GetThread(ctx, threadID)
+	if err != nil {
+		return t, err
+	}
+	if !t.Participant(userID) {
+		return t, ErrNotAllowed
+	}
+	if t.UnreadFor(userID) == 0 {
+		return t, nil
+	}
+	return s.updateThread(ctx, threadID, func(t *models.MessageThread) error {
+		if userID == t.BuyerID {
+			t.BuyerUnread = 0
+		} else {
+			t.SellerUnread = 0
+		}
+		return nil
+	})
+}
+
+// Inbox returns a user's threads as buyer or seller, most recent activity first
+func (s *Service) Inbox(ctx context.Context, userID string) ([]models.MessageThread, error) {
+	return s.store.ThreadsByUser(ctx, userID)
+}
+
+// Unread returns how many unread messages a user has across their threads
+func (s *Service) Unread(ctx context.Context, userID string) (int, error) {
+	list, err := s.store.ThreadsByUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, t := range list {
+		n += t.UnreadFor(userID)
+	}
+	return n, nil
+}
+
+// Report flags the other side's message for an admin to look at. Messages an admin already kept
+// or hid can't be reported again.
+func (s *Service) Report(ctx context.Context, threadID, messageID, reporterID, reason string) (models.Message, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return models.Message{}, fmt.Errorf("%w: say what's wrong with the message", ErrInvalid)
+	}
+	t, err := s.store.GetThread(ctx, threadID)
+	if err != nil {
+		return models.Message{}, err
+	}
+	m, err := s.store.GetMessage(ctx, threadID, messageID)
+	if err != nil {
+		return m, err
+	}
+	if !t.Participant(reporterID) || m.SenderID == reporterID {
+		return m, ErrNotAllowed
+	}
+	if m.Status != models.MessageStatusVisible || m.ModeratedBy != "" {
+		return m, nil
+	}
+
+	m.Status = models.MessageStatusFlagged
+	m.FlagReason = reason
+	m.FlaggedBy = orders.Buyer(reporterID).String()
+	m.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	setMessageKeys(&m)
+	return m, s.store.UpdateMessage(ctx, m)
+}
+
+// Moderate settles a message in the moderation queue: hide removes its text from the thread,
+// otherwise it is shown again
+func (s *Service) Moderate(ctx context.Context, threadID, messageID string, actor orders.Actor, hide bool, note string) (models.Message, error) {
+	if actor.Kind != orders.ActorAdmin {
+		return models.Message{}, ErrNotAllowed
+	}
+	m, err := s.store.GetMessage(ctx, threadID, messageID)
+	if err != nil {
+		return m, err
+	}
+
+	m.Status = models.MessageStatusVisible
+	if hide {
+		m.Status = models.MessageStatusHidden
+	}
+	m.ModeratedBy = actor.String()
+	m.ModerationNote = strings.TrimSpace(note)
+	m.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	setMessageKeys(&m)
+	return m, s.store.UpdateMessage(ctx, m)
+}
+
+// Queue returns the messages waiting for moderation, oldest first
+func (s *Service) Queue(ctx context.Context) ([]models.Message, error) {
+	return s.store.MessagesByStatus(ctx, models.MessageStatusFlagged)
+}
+
+// Block stops the other side of a thread from messaging the user, in this thread or any other
+func (s *Service) Block(ctx context.Context, threadID, userID, reason string) (models.MessageThread, error) {
+	t, err := s.store.GetThread(ctx, threadID)
+	if err != nil {
+		return t, err
+	}
+	if !t.Participant(userID) {
+		return t, ErrNotAllowed
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	b := models.MessageBlock{
+		BlockerID: userID,
+		BlockedID: t.Other(userID),
+		ThreadID:  threadID,
+		Reason:    strings.TrimSpace(reason),
+		CreatedAt: now,
+	}
+	b.PK, b.SK = models.MessageBlockKey(b.BlockerID, b.BlockedID)
+	b.GSI1PK, b.GSI1SK = models.RecentBlockKey(b.CreatedAt, b.BlockerID, b.BlockedID)
+	b.Type = models.ItemTypeBlock
+	if err := s.store.PutBlock(ctx, b); err != nil {
+		return t, err
+	}
+	return s.updateThread(ctx, threadID, func(t *models.MessageThread) error {
+		t.BlockedBy = userID
+		return nil
+	})
+}
+
+// Unblock lifts a block the user made from this thread
+func (s *Service) Unblock(ctx context.Context, threadID, userID string) (models.MessageThread, error) {
+	t, err := s.store.GetThread(ctx, threadID)
+	if err != nil {
+		return t, err
+	}
+	if !t.Participant(userID) || t.BlockedBy != userID {
+		return t, ErrNotAllowed
+	}
+	if err := s.store.DeleteBlock(ctx, userID, t.Other(userID)); err != nil && !errors.Is(err, ErrNotFound) {
+		return t, err
+	}
+	return s.updateThread(ctx, threadID, func(t *models.MessageThread) error {
+		t.BlockedBy = ""
+		return nil
+	})
+}
+
+// Blocks returns every block, newest first, for admins to review
+func (s *Service) Blocks(ctx context.Context) ([]models.MessageBlock, error) {
+	return s.store.Blocks(ctx)
+}
+
+// scopeThread returns the sender's existing thread about scope, or a new unsaved one
+func (s *Service) scopeThread(ctx context.Context, senderID string, scope Scope) (models.MessageThread, error) {
+	var t models.MessageThread
+	switch {
+	case scope.OrderID != "":
+		o, err := s.orders.Get(ctx, scope.OrderID)
+		if errors.Is(err, orders.ErrNotFound) {
+			return t, ErrNotFound
+		}
+		if err != nil {
+			return t, err
+		}
+		if senderID != o.BuyerID && senderID != o.SellerID {
+			return t, ErrNotAllowed
+		}
+		t = models.MessageThread{OrderID: o.OrderID, BuyerID: o.BuyerID, SellerID: o.SellerID, Subject: orderSubject(o)}
+
+	case scope.ListingID != "":
+		l, err := s.catalog.Listing(ctx, scope.ListingID)
+		if errors.Is(err, catalog.ErrNotFound) {
+			return t, ErrNotFound
+		}
+		if err != nil {
+			return t, err
+		}
+		if senderID == "" || senderID == l.SellerID {
+			return t, ErrNotAllowed
+		}
+		subject, err := s.listingSubject(ctx, l)
+		if err != nil {
+			return t, err
+		}
+		t = models.MessageThread{ListingID: l.ListingID, BuyerID: senderID, SellerID: l.SellerID, Subject: subject}
+
+	default:
+		return t, fmt.Errorf("%w: choose a listing or an order", ErrInvalid)
+	}
+
+	existing, err := s.store.ThreadsByUser(ctx, senderID)
+	if err != nil {
+		return t, err
+	}
+	for _, e := range existing {
+		if e.BuyerID == t.BuyerID && e.SellerID == t.SellerID && e.OrderID == t.OrderID && e.ListingID == t.ListingID {
+			return e, nil
+		}
+	}
+	return t, nil
+}
+
+// listingSubject names the card or sealed product a listing sells
+func (s *Service) listingSubject(ctx context.Context, l models.Listing) (string, error) {
+	if l.IsSealed() {
+		p, err := s.catalog.Product(ctx, l.ProductID)
+		return p.Name, err
+	}
+	p, err := s.catalog.Printing(ctx, l.PrintingID)
+	if err != nil {
+		return "", err
+	}
+	return p.CardName + " (" + p.SetName + ")", nil
+}
+
+// orderSubject names an order by its first item
+func orderSubject(o models.Order) string {
+	if len(o.Items) == 0 {
+		return "Order " + o.OrderID
+	}
+	subject := "Order of " + o.Items[0].CardName
+	if n := len(o.Items) - 1; n == 1 {
+		subject += " and 1 more"
+	} else if n > 1 {
+		subject += fmt.Sprintf(" and %d more", n)
+	}
+	return subject
+}
+
+// post checks and stores a message in a thread, new or existing, and tells the recipient
+func (s *Service) post(ctx context.Context, t models.MessageThread, senderID, body string) (models.MessageThread, models.Message, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(body) > MaxBodyLength {
+		return t, models.Message{}, fmt.Errorf("%w: write a message under %d characters", ErrInvalid, MaxBodyLength)
+	}
+	if err := s.checkBlocked(ctx, t); err != nil {
+		return t, models.Message{}, err
+	}
+	if what := findContactInfo(body); what != "" {
+		ok, err := s.hasOrder(ctx, t)
+		if err != nil {
+			return t, models.Message{}, err
+		}
+		if !ok {
+			return t, models.Message{}, fmt.Errorf("%w: remove %s and keep the conversation here", ErrContactInfo, what)
+		}
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	if t.ThreadID == "" {
+		t.ThreadID = ids.New()
+		t.CreatedAt = now
+	}
+	m := models.Message{
+		MessageID: ids.New(),
+		ThreadID:  t.ThreadID,
+		SenderID:  senderID,
+		Body:      body,
+		Status:    models.MessageStatusVisible,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	for attempt := 1; ; attempt++ {
+		expected := t.Version
+		t.Preview = preview(body)
+		t.LastMessageAt = now
+		t.UpdatedAt = now
+		if senderID == t.BuyerID {
+			t.BuyerUnread = 0
+			t.SellerUnread++
+		} else {
+			t.SellerUnread = 0
+			t.BuyerUnread++
+		}
+		t.Version++
+		setThreadKeys(&t)
+		m.Seq = t.Version
+		setMessageKeys(&m)
+
+		err := s.store.AddMessage(ctx, t, expected, m)
+		if errors.Is(err, ErrConflict) && expected > 0 && attempt < maxAttempts {
+			if t, err = s.store.GetThread(ctx, t.ThreadID); err != nil {
+				return t, m, err
+			}
+			continue
+		}
+		if err != nil {
+			return t, m, err
+		}
+		break
+	}
+
+	recipient := t.Other(senderID)
+	if s.notify != nil && t.UnreadFor(recipient) == 1 {
+		if err := s.notify(ctx, Notice{UserID: recipient, Thread: t, Message: m}); err != nil {
+			s.errorLog.Printf("notifying %s of message %s failed: %v", recipient, m.MessageID, err)
+		}
+	}
+	return t, m, nil
+}
+
+// checkBlocked returns ErrBlocked if either side of a thread has blocked the other
+func (s *Service) checkBlocked(ctx context.Context, t models.MessageThread) error {
+	for _, pair := range [][2]string{{t.BuyerID, t.SellerID}, {t.SellerID, t.BuyerID}} {
+		blocked, err := s.store.Blocked(ctx, pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+// hasOrder reports whether the thread's buyer has a paid order with its seller, so the two may
+// share contact details to sort out delivery
+func (s *Service) hasOrder(ctx context.Context, t models.MessageThread) (bool, error) {
+	if t.OrderID != "" {
+		return true, nil
+	}
+	list, err := s.orders.ForBuyer(ctx, t.BuyerID)
+	if err != nil {
+		return false, err
+	}
+	for _, o := range list {
+		if o.SellerID == t.SellerID && o.Status != models.OrderStatusPendingPayment && o.Status != models.OrderStatusCancelled {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// preview shortens a message for the inbox
+func preview(body string) string {
+	body = strings.Join(strings.Fields(body), " ")
+	if utf8.RuneCountInString(body) <= previewLength {
+		return body
+	}
+	return string([]rune(body)[:previewLength-1]) + "…"
+}
+
+// updateThread applies a change to a thread and writes it with a version check
+func (s *Service) updateThread(ctx context.Context, threadID string, mutate func(*models.MessageThread) error) (models.MessageThread, error) {
+	t, err := s.store.GetThread(ctx, threadID)
+	if err != nil {
+		return t, err
+	}
+
+	expected := t.Version
+	t.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	if err := mutate(&t); err != nil {
+		return t, err
+	}
+	t.Version++
+	setThreadKeys(&t)
+
+	if err := s.store.UpdateThread(ctx, t, expected); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// setThreadKeys fills in the single-table keys for a thread
+func setThreadKeys(t *models.MessageThread) {
+	t.PK, t.SK = models.ThreadKey(t.ThreadID)
+	t.GSI1PK, t.GSI1SK = models.BuyerThreadKey(t.BuyerID, t.LastMessageAt, t.ThreadID)
+	t.GSI2PK, t.GSI2SK = models.SellerThreadKey(t.SellerID, t.LastMessageAt, t.ThreadID)
+	t.Type = models.ItemTypeThread
+}
+
+// setMessageKeys fills in the single-table keys for a message
+func setMessageKeys(m *models.Message) {
+	m.PK, m.SK = models.MessageKey(m.ThreadID, m.CreatedAt, m.Seq, m.MessageID)
+	m.GSI1PK, m.GSI1SK = models.MessageStatusKey(m.Status, m.CreatedAt, m.MessageID)
+	m.Type = models.ItemTypeMessage
+}
@@ -0,0 +1,78 @@
+package models
+
+// Auction statuses
+const (
+	AuctionStatusOpen      = "open"
+	AuctionStatusSold      = "sold"      // the winning bid was turned into an order awaiting payment
+	AuctionStatusUnsold    = "unsold"    // no bids, or the reserve wasn't met; the copy went back on sale
+	AuctionStatusCancelled = "cancelled" // the seller ended it before anyone bid
+)
+
+// Auction sells one copy of a listing to the highest bidder
+type Auction struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	Type           string `dynamodbav:"Type"`
+	AuctionID      string `dynamodbav:"auctionID"`
+	ListingID      string `dynamodbav:"listingID"`
+	SellerID       string `dynamodbav:"sellerID"`
+	StartCents     int64  `dynamodbav:"startCents"`
+	ReserveCents   int64  `dynamodbav:"reserveCents"`   // lowest price the seller will sell at; zero means no reserve
+	IncrementCents int64  `dynamodbav:"incrementCents"` // zero means the default tiered increments
+	CurrentCents   int64  `dynamodbav:"currentCents"`   // price the card would sell at right now
+	LeaderID       string `dynamodbav:"leaderID"`
+	LeaderAlias    string `dynamodbav:"leaderAlias"`
+	LeaderMaxCents int64  `dynamodbav:"leaderMaxCents"` // the leader's proxy max; never shown to other bidders
+	BidCount       int    `dynamodbav:"bidCount"`
+	Bidders        int    `dynamodbav:"bidders"`
+	Status         string `dynamodbav:"status"`
+	StartsAt       string `dynamodbav:"startsAt"`
+	EndsAt         string `dynamodbav:"endsAt"`
+	Extensions     int    `dynamodbav:"extensions"` // times a late bid pushed EndsAt back
+	OrderID        string `dynamodbav:"orderID"`
+	Note           string `dynamodbav:"note"` // why an auction closed unsold
+	Version        int64  `dynamodbav:"version"`
+	GSI1PK         string `dynamodbav:"GSI1PK"`
+	GSI1SK         string `dynamodbav:"GSI1SK"`
+	GSI2PK         string `dynamodbav:"GSI2PK"`
+	GSI2SK         string `dynamodbav:"GSI2SK"`
+	CreatedAt      string `dynamodbav:"createdAt"`
+	UpdatedAt      string `dynamodbav:"updatedAt"`
+}
+
+// ReserveMet reports whether the current price clears the reserve
+func (a Auction) ReserveMet() bool {
+	return a.LeaderID != "" && a.CurrentCents >= a.ReserveCents
+}
+
+// AuctionProxy is a bidder's standing instruction on an auction: bid for them up to MaxCents.
+// It also holds where the card ships if they win.
+type AuctionProxy struct {
+	PK             string  `dynamodbav:"PK"`
+	SK             string  `dynamodbav:"SK"`
+	Type           string  `dynamodbav:"Type"`
+	AuctionID      string  `dynamodbav:"auctionID"`
+	BidderID       string  `dynamodbav:"bidderID"`
+	Alias          string  `dynamodbav:"alias"` // 'Bidder 3', shown in the public bid history
+	MaxCents       int64   `dynamodbav:"maxCents"`
+	ShipTo         Address `dynamodbav:"shipTo"`
+	ShippingMethod string  `dynamodbav:"shippingMethod"`
+	GSI1PK         string  `dynamodbav:"GSI1PK"`
+	GSI1SK         string  `dynamodbav:"GSI1SK"`
+	CreatedAt      string  `dynamodbav:"createdAt"`
+	UpdatedAt      string  `dynamodbav:"updatedAt"`
+}
+
+// AuctionBid is one accepted bid in an auction's public history
+type AuctionBid struct {
+	PK          string `dynamodbav:"PK"`
+	SK          string `dynamodbav:"SK"`
+	Type        string `dynamodbav:"Type"`
+	AuctionID   string `dynamodbav:"auctionID"`
+	BidderID    string `dynamodbav:"bidderID"`
+	Alias       string `dynamodbav:"alias"`
+	PriceCents  int64  `dynamodbav:"priceCents"`  // current price after the bid
+	LeaderAlias string `dynamodbav:"leaderAlias"` // who leads after the bid
+	Extended    bool   `dynamodbav:"extended"`    // the bid pushed the close time back
+	At          string `dynamodbav:"at"`
+}
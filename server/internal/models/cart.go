@@ -0,0 +1,37 @@
+package models
+
+// Cart is a buyer's shopping cart. Anonymous carts live in the session; signed-in carts are persisted per user.
+type Cart struct {
+	PK        string     `dynamodbav:"PK"`
+	SK        string     `dynamodbav:"SK"`
+	Type      string     `dynamodbav:"Type"`
+	UserID    string     `dynamodbav:"userID"`
+	Items     []CartItem `dynamodbav:"items"`
+	UpdatedAt string     `dynamodbav:"updatedAt"`
+}
+
+// CartItem is a single line in a cart
+type CartItem struct {
+	ListingID     string `dynamodbav:"listingID"`
+	Quantity      int    `dynamodbav:"quantity"`
+	PriceCents    int64  `dynamodbav:"priceCents"` // unit price when the item was added
+	AddedAt       string `dynamodbav:"addedAt"`
+	OfferID       string `dynamodbav:"offerID"`       // set on lines created by an accepted offer
+	AuctionID     string `dynamodbav:"auctionID"`     // set on the line a won auction checks out
+	ReservedUntil string `dynamodbav:"reservedUntil"` // when an offer line's held copies go back on sale
+}
+
+// Reserved reports whether the line holds copies for an accepted offer or a won auction. Reserved
+// lines keep their negotiated price and are already taken out of stock.
+func (it CartItem) Reserved() bool {
+	return it.OfferID != "" || it.AuctionID != ""
+}
+
+// ItemCount returns the total quantity across all lines
+func (c Cart) ItemCount() int {
+	n := 0
+	for _, it := range c.Items {
+		n += it.Quantity
+	}
+	return n
+}
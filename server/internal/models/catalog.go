@@ -0,0 +1,123 @@
+package models
+
+// Listing statuses
+const (
+	ListingStatusActive   = "active"
+	ListingStatusSoldOut  = "sold_out"
+	ListingStatusInactive = "inactive"
+)
+
+// Conditions lists the card conditions a listing can have, best first
+var Conditions = []string{"NM", "LP", "MP", "HP", "DMG"}
+
+// Games the catalog carries
+const (
+	GameMTG     = "mtg"
+	GamePokemon = "pokemon"
+	GameYugioh  = "yugioh"
+)
+
+// Listing categories, used by the fee engine
+const (
+	CategorySingle = "single"
+	CategorySealed = "sealed"
+)
+
+// Printing represents a single printing of a card within a set (the catalog entry a listing sells)
+type Printing struct {
+	PK              string `dynamodbav:"PK"`
+	SK              string `dynamodbav:"SK"`
+	Type            string `dynamodbav:"Type"`
+	PrintingID      string `dynamodbav:"printingID"`
+	Game            string `dynamodbav:"game"`
+	SetCode         string `dynamodbav:"setCode"`
+	SetName         string `dynamodbav:"setName"`
+	CardName        string `dynamodbav:"cardName"`
+	CollectorNumber string `dynamodbav:"collectorNumber"`
+	CardCode        string `dynamodbav:"cardCode"` // game-wide ID shared by every printing of a card, such as a Yu-Gi-Oh! passcode
+	Rarity          string `dynamodbav:"rarity"`
+	ImageURL        string `dynamodbav:"imageURL"`
+	GSI1PK          string `dynamodbav:"GSI1PK"` // every printing, by card name
+	GSI1SK          string `dynamodbav:"GSI1SK"`
+	CreatedAt       string `dynamodbav:"createdAt"`
+	UpdatedAt       string `dynamodbav:"updatedAt"`
+}
+
+// Listing represents a seller offering copies of a printing, or of a sealed product, at a price
+type Listing struct {
+	PK            string   `dynamodbav:"PK"`
+	SK            string   `dynamodbav:"SK"`
+	Type          string   `dynamodbav:"Type"`
+	ListingID     string   `dynamodbav:"listingID"`
+	SellerID      string   `dynamodbav:"sellerID"`
+	PrintingID    string   `dynamodbav:"printingID"` // empty for a sealed product
+	ProductID     string   `dynamodbav:"productID"`  // set instead of PrintingID for a sealed product
+	Condition     string   `dynamodbav:"condition"`
+	Language      string   `dynamodbav:"language"`
+	Foil          bool     `dynamodbav:"foil"`
+	PriceCents    int64    `dynamodbav:"priceCents"`
+	Quantity      int      `dynamodbav:"quantity"`
+	Status        string   `dynamodbav:"status"`
+	AcceptsOffers bool     `dynamodbav:"acceptsOffers"` // whether buyers may make offers below the asking price
+	Tags          []string `dynamodbav:"tags"`          // the seller's own labels, lowercase, for grouping inventory
+	Grading       *Grading `dynamodbav:"grading"`       // set for a graded card, whose Condition is then its grade label
+	Version       int64    `dynamodbav:"version"`       // incremented on every write, used for conditional updates
+	GSI1PK        string   `dynamodbav:"GSI1PK"`
+	GSI1SK        string   `dynamodbav:"GSI1SK"`
+	GSI2PK        string   `dynamodbav:"GSI2PK"` // every listing, newest last
+	GSI2SK        string   `dynamodbav:"GSI2SK"`
+	CreatedAt     string   `dynamodbav:"createdAt"`
+	UpdatedAt     string   `dynamodbav:"updatedAt"`
+}
+
+// Category is the kind of product the listing sells
+func (l Listing) Category() string {
+	if l.IsSealed() {
+		return CategorySealed
+	}
+	return CategorySingle
+}
+
+// IsSealed reports whether the listing sells a sealed product rather than a single card
+func (l Listing) IsSealed() bool {
+	return l.ProductID != ""
+}
+
+// ItemID is the ID of what the listing sells: its printing, or its sealed product
+func (l Listing) ItemID() string {
+	if l.IsSealed() {
+		return l.ProductID
+	}
+	return l.PrintingID
+}
+
+// HasTag reports whether the listing carries a tag
+func (l Listing) HasTag(tag string) bool {
+	for _, t := range l.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGraded reports whether the listing sells a graded card
+func (l Listing) IsGraded() bool {
+	return l.Grading != nil
+}
+
+// IsActive reports whether the listing can currently be bought
+func (l Listing) IsActive() bool {
+	return l.Status == ListingStatusActive && l.Quantity > 0
+}
+
+// ConditionRank returns a condition's position in Conditions, 0 being the best. Unknown
+// conditions rank below every known one.
+func ConditionRank(c string) int {
+	for i, known := range Conditions {
+		if c == known {
+			return i
+		}
+	}
+	return len(Conditions)
+}
@@ -0,0 +1,47 @@
+package models
+
+// How a collection item was added
+const (
+	CollectionSourceManual = "manual" // entered by hand
+	CollectionSourceOrder  = "order"  // added when an order the user bought completed
+	CollectionSourceImport = "import" // imported from a CSV file
+)
+
+// CollectionItem is a copy, or several identical copies, of a printing in a user's collection
+type CollectionItem struct {
+	PK           string `dynamodbav:"PK"`
+	SK           string `dynamodbav:"SK"`
+	Type         string `dynamodbav:"Type"`
+	GSI1PK       string `dynamodbav:"GSI1PK"` // every collection item, by user
+	GSI1SK       string `dynamodbav:"GSI1SK"`
+	ItemID       string `dynamodbav:"itemID"`
+	UserID       string `dynamodbav:"userID"`
+	PrintingID   string `dynamodbav:"printingID"`
+	Condition    string `dynamodbav:"condition"`
+	Language     string `dynamodbav:"language"`
+	Foil         bool   `dynamodbav:"foil"`
+	Quantity     int    `dynamodbav:"quantity"`
+	CostCents    int64  `dynamodbav:"costCents"`  // acquisition price per copy, 0 if unknown
+	AcquiredOn   string `dynamodbav:"acquiredOn"` // YYYY-MM-DD
+	Source       string `dynamodbav:"source"`
+	OrderID      string `dynamodbav:"orderID"`      // set for items added from an order
+	OrderListing string `dynamodbav:"orderListing"` // the listing the item was bought from
+	ListingID    string `dynamodbav:"listingID"`    // the listing the item was put up for sale as
+	ListedAt     string `dynamodbav:"listedAt"`
+	CreatedAt    string `dynamodbav:"createdAt"`
+	UpdatedAt    string `dynamodbav:"updatedAt"`
+}
+
+// PortfolioSnapshot is a user's collection value and cost on one day
+type PortfolioSnapshot struct {
+	PK         string `dynamodbav:"PK"`
+	SK         string `dynamodbav:"SK"`
+	Type       string `dynamodbav:"Type"`
+	UserID     string `dynamodbav:"userID"`
+	Day        string `dynamodbav:"day"` // YYYY-MM-DD, UTC
+	ValueCents int64  `dynamodbav:"valueCents"`
+	CostCents  int64  `dynamodbav:"costCents"`
+	Copies     int    `dynamodbav:"copies"`
+	Unpriced   int    `dynamodbav:"unpriced"` // copies with no market price, left out of the value
+	UpdatedAt  string `dynamodbav:"updatedAt"`
+}
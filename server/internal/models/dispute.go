@@ -0,0 +1,91 @@
+package models
+
+// Dispute statuses
+const (
+	DisputeStatusOpen           = "open"
+	DisputeStatusSellerResponse = "seller_response"
+	DisputeStatusEscalated      = "escalated"
+	DisputeStatusResolved       = "resolved"
+)
+
+// Dispute reasons
+const (
+	DisputeReasonDamaged        = "damaged"
+	DisputeReasonWrongPrinting  = "wrong_printing"
+	DisputeReasonWrongCondition = "wrong_condition"
+	DisputeReasonMissingItems   = "missing_items"
+	DisputeReasonNotReceived    = "not_received"
+	DisputeReasonOther          = "other"
+)
+
+// DisputeReasons are the reasons a buyer can give, with their labels
+var DisputeReasons = map[string]string{
+	DisputeReasonDamaged:        "Arrived damaged",
+	DisputeReasonWrongPrinting:  "Wrong printing or card",
+	DisputeReasonWrongCondition: "Condition not as described",
+	DisputeReasonMissingItems:   "Items missing",
+	DisputeReasonNotReceived:    "Never arrived",
+	DisputeReasonOther:          "Something else",
+}
+
+// Dispute outcomes
+const (
+	DisputeOutcomeRefundFull     = "refund_full"
+	DisputeOutcomeRefundPartial  = "refund_partial"
+	DisputeOutcomeReturnRequired = "return_required"
+	DisputeOutcomeDenied         = "denied"
+)
+
+// Dispute is a buyer's complaint about an order, worked through with the seller and, if they
+// can't agree, an admin
+type Dispute struct {
+	PK               string   `dynamodbav:"PK"`
+	SK               string   `dynamodbav:"SK"`
+	Type             string   `dynamodbav:"Type"`
+	DisputeID        string   `dynamodbav:"disputeID"`
+	OrderID          string   `dynamodbav:"orderID"`
+	BuyerID          string   `dynamodbav:"buyerID"`
+	SellerID         string   `dynamodbav:"sellerID"`
+	Status           string   `dynamodbav:"status"`
+	Reason           string   `dynamodbav:"reason"`
+	Description      string   `dynamodbav:"description"`
+	Photos           []string `dynamodbav:"photos"` // evidence photo URLs
+	Outcome          string   `dynamodbav:"outcome"`
+	RefundCents      int64    `dynamodbav:"refundCents"`
+	Resolution       string   `dynamodbav:"resolution"` // note explaining the outcome
+	ResolvedBy       string   `dynamodbav:"resolvedBy"`
+	ReturnReceivedAt string   `dynamodbav:"returnReceivedAt"`
+	Version          int64    `dynamodbav:"version"`
+	GSI1PK           string   `dynamodbav:"GSI1PK"`
+	GSI1SK           string   `dynamodbav:"GSI1SK"`
+	GSI2PK           string   `dynamodbav:"GSI2PK"`
+	GSI2SK           string   `dynamodbav:"GSI2SK"`
+	CreatedAt        string   `dynamodbav:"createdAt"`
+	UpdatedAt        string   `dynamodbav:"updatedAt"`
+	ResolvedAt       string   `dynamodbav:"resolvedAt"`
+}
+
+// Open reports whether the dispute is still being worked on
+func (d Dispute) Open() bool {
+	return d.Status != DisputeStatusResolved
+}
+
+// AwaitingReturn reports whether the buyer has been asked to send the cards back for a refund
+func (d Dispute) AwaitingReturn() bool {
+	return d.Outcome == DisputeOutcomeReturnRequired && d.ReturnReceivedAt == ""
+}
+
+// DisputeMessage is one message in a dispute's thread. Status changes are recorded in the thread
+// as system messages.
+type DisputeMessage struct {
+	PK        string   `dynamodbav:"PK"`
+	SK        string   `dynamodbav:"SK"`
+	Type      string   `dynamodbav:"Type"`
+	MessageID string   `dynamodbav:"messageID"`
+	DisputeID string   `dynamodbav:"disputeID"`
+	Author    string   `dynamodbav:"author"` // 'system' | 'user:<id>' | 'admin:<id>'
+	Role      string   `dynamodbav:"role"`   // 'buyer' | 'seller' | 'admin' | 'system'
+	Body      string   `dynamodbav:"body"`
+	Photos    []string `dynamodbav:"photos"`
+	CreatedAt string   `dynamodbav:"createdAt"`
+}
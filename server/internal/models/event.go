@@ -0,0 +1,78 @@
+package models
+
+import "encoding/json"
+
+// Domain event statuses
+const (
+	EventStatusPending   = "pending" // waiting in the outbox to be published, or to be retried
+	EventStatusPublished = "published"
+	EventStatusFailed    = "failed" // a subscriber still failed after the last retry
+)
+
+// Domain event names. Order events other than created and amended are named for the status the
+// order reached; offer events are named for the action taken on the offer. Seller events mark a
+// user taking on the seller role and a seller's risk tier changing.
+const (
+	EventOrderCreated   = "order.created"
+	EventOrderPaid      = "order.paid"
+	EventOrderShipped   = "order.shipped"
+	EventOrderDelivered = "order.delivered"
+	EventOrderCompleted = "order.completed"
+	EventOrderCancelled = "order.cancelled"
+	EventOrderRefunded  = "order.refunded"
+	EventOrderAmended   = "order.amended"
+	EventListingCreated = "listing.created"
+	EventListingUpdated = "listing.updated"
+	EventListingSoldOut = "listing.sold_out"
+	EventOfferReceived  = "offer.received"
+	EventOfferCountered = "offer.countered"
+	EventOfferAccepted  = "offer.accepted"
+	EventOfferDeclined  = "offer.declined"
+	EventOfferWithdrawn = "offer.withdrawn"
+	EventOfferExpired   = "offer.expired"
+	EventOfferPurchased = "offer.purchased"
+	EventOfferLapsed    = "offer.lapsed"
+
+	EventSellerRegistered  = "seller.registered"
+	EventSellerTierChanged = "seller.tier_changed"
+)
+
+// DomainEvent is a change to an order, listing or other aggregate. It's written to the outbox in
+// the same transaction as the change it describes, then published to subscribers by the relay.
+type DomainEvent struct {
+	PK               string `dynamodbav:"PK"`
+	SK               string `dynamodbav:"SK"`
+	Type             string `dynamodbav:"Type"`
+	EventID          string `dynamodbav:"eventID"`
+	Name             string `dynamodbav:"name"`      // such as 'order.paid'
+	Aggregate        string `dynamodbav:"aggregate"` // such as 'order'
+	AggregateID      string `dynamodbav:"aggregateID"`
+	AggregateVersion int64  `dynamodbav:"aggregateVersion"` // the aggregate's version after the change
+	Payload          string `dynamodbav:"payload"`          // JSON
+	Status           string `dynamodbav:"status"`
+	Attempts         int    `dynamodbav:"attempts"`
+	NextAttemptAt    string `dynamodbav:"nextAttemptAt"`
+	LastError        string `dynamodbav:"lastError"`
+	PublishedAt      string `dynamodbav:"publishedAt"`
+	Version          int64  `dynamodbav:"version"`
+	GSI1PK           string `dynamodbav:"GSI1PK"` // outbox by status
+	GSI1SK           string `dynamodbav:"GSI1SK"`
+	OccurredAt       string `dynamodbav:"occurredAt"`
+	UpdatedAt        string `dynamodbav:"updatedAt"`
+}
+
+// Decode unmarshals the event's payload into v
+func (e DomainEvent) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(e.Payload), v)
+}
+
+// EventReceipt records that a consumer has handled an event, so a redelivered event is skipped
+type EventReceipt struct {
+	PK         string `dynamodbav:"PK"`
+	SK         string `dynamodbav:"SK"`
+	Type       string `dynamodbav:"Type"`
+	Consumer   string `dynamodbav:"consumer"`
+	EventID    string `dynamodbav:"eventID"`
+	ConsumedAt string `dynamodbav:"consumedAt"`
+	ExpiresAt  int64  `dynamodbav:"ttl"` // unix seconds, for the table's TTL
+}
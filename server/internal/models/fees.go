@@ -0,0 +1,33 @@
+package models
+
+// FeeSchedule is one published, immutable version of the platform fee rules
+type FeeSchedule struct {
+	PK          string    `dynamodbav:"PK"`
+	SK          string    `dynamodbav:"SK"`
+	Type        string    `dynamodbav:"Type"`
+	Version     int       `dynamodbav:"version"`
+	Rules       []FeeRule `dynamodbav:"rules"`
+	PublishedBy string    `dynamodbav:"publishedBy"`
+	PublishedAt string    `dynamodbav:"publishedAt"`
+}
+
+// FeeRule is the commission charged on order lines it matches. Empty match fields match anything.
+type FeeRule struct {
+	Name          string `dynamodbav:"name" json:"name"`
+	Game          string `dynamodbav:"game" json:"game,omitempty"`
+	Category      string `dynamodbav:"category" json:"category,omitempty"`
+	RiskTier      string `dynamodbav:"riskTier" json:"risk_tier,omitempty"`
+	SellerID      string `dynamodbav:"sellerID" json:"seller_id,omitempty"`
+	MinPriceCents int64  `dynamodbav:"minPriceCents" json:"min_price_cents,omitempty"` // unit price band, inclusive
+	MaxPriceCents int64  `dynamodbav:"maxPriceCents" json:"max_price_cents,omitempty"` // zero means no upper bound
+
+	// Promotional rules override regular ones while their window is open
+	Promo    bool   `dynamodbav:"promo" json:"promo,omitempty"`
+	StartsAt string `dynamodbav:"startsAt" json:"starts_at,omitempty"`
+	EndsAt   string `dynamodbav:"endsAt" json:"ends_at,omitempty"`
+
+	PercentBps int64 `dynamodbav:"percentBps" json:"percent_bps"` // basis points of the line total
+	FixedCents int64 `dynamodbav:"fixedCents" json:"fixed_cents"` // added once per line
+	MinCents   int64 `dynamodbav:"minCents" json:"min_cents,omitempty"`
+	MaxCents   int64 `dynamodbav:"maxCents" json:"max_cents,omitempty"` // zero means uncapped
+}
@@ -0,0 +1,56 @@
+package models
+
+import "strings"
+
+// Grading companies
+const (
+	GraderPSA = "PSA"
+	GraderBGS = "BGS"
+	GraderCGC = "CGC"
+)
+
+// Graders lists the grading companies a graded listing can name
+var Graders = []string{GraderPSA, GraderBGS, GraderCGC}
+
+// Subgrades are the component grades some companies print on the label. Empty when the slab
+// has none.
+type Subgrades struct {
+	Centering string `dynamodbav:"centering"`
+	Corners   string `dynamodbav:"corners"`
+	Edges     string `dynamodbav:"edges"`
+	Surface   string `dynamodbav:"surface"`
+}
+
+// IsZero reports whether no subgrade is set
+func (s Subgrades) IsZero() bool {
+	return s == Subgrades{}
+}
+
+// Grading describes a card graded and encapsulated by a grading company
+type Grading struct {
+	Company    string    `dynamodbav:"company"` // one of Graders
+	Grade      string    `dynamodbav:"grade"`   // such as "10" or "9.5"
+	Subgrades  Subgrades `dynamodbav:"subgrades"`
+	CertNumber string    `dynamodbav:"certNumber"`
+	VerifiedAt string    `dynamodbav:"verifiedAt"` // when the cert was confirmed to match the printing, "" if it couldn't be checked
+}
+
+// Label names the grade as it is shown and priced, such as "PSA 10". A graded listing's
+// condition is its label.
+func (g Grading) Label() string {
+	return g.Company + " " + g.Grade
+}
+
+// IsGradeLabel reports whether a condition is a grade label rather than a raw card condition
+func IsGradeLabel(condition string) bool {
+	company, grade, ok := strings.Cut(condition, " ")
+	if !ok || grade == "" {
+		return false
+	}
+	for _, g := range Graders {
+		if company == g {
+			return true
+		}
+	}
+	return false
+}
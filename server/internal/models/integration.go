@@ -0,0 +1,126 @@
+package models
+
+// API key scopes
+const (
+	ScopeListingsRead  = "listings:read"
+	ScopeListingsWrite = "listings:write" // change price and quantity
+	ScopeOrdersRead    = "orders:read"
+)
+
+// APIScopes lists the scopes a key may be given, in the order they're offered to sellers
+var APIScopes = []string{ScopeListingsRead, ScopeListingsWrite, ScopeOrdersRead}
+
+// APIKey lets a seller's own systems, such as a point of sale, use the seller API. Only a hash of
+// the key is stored; the key itself is shown once when it's created.
+type APIKey struct {
+	PK         string   `dynamodbav:"PK"`
+	SK         string   `dynamodbav:"SK"`
+	Type       string   `dynamodbav:"Type"`
+	KeyID      string   `dynamodbav:"keyID"`
+	SellerID   string   `dynamodbav:"sellerID"`
+	Name       string   `dynamodbav:"name"`
+	Prefix     string   `dynamodbav:"prefix"` // the start of the key, so the seller can tell keys apart
+	Hash       string   `dynamodbav:"hash"`   // hex SHA-256 of the key
+	Scopes     []string `dynamodbav:"scopes"`
+	LastUsedAt string   `dynamodbav:"lastUsedAt"`
+	RevokedAt  string   `dynamodbav:"revokedAt"`
+	Version    int64    `dynamodbav:"version"`
+	GSI1PK     string   `dynamodbav:"GSI1PK"` // the seller's keys
+	GSI1SK     string   `dynamodbav:"GSI1SK"`
+	CreatedAt  string   `dynamodbav:"createdAt"`
+	UpdatedAt  string   `dynamodbav:"updatedAt"`
+}
+
+// Revoked reports whether the key has been revoked
+func (k APIKey) Revoked() bool {
+	return k.RevokedAt != ""
+}
+
+// Allows reports whether the key has a scope
+func (k APIKey) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook endpoint statuses
+const (
+	WebhookEnabled  = "enabled"
+	WebhookDisabled = "disabled"
+)
+
+// WebhookEndpoint is an HTTPS URL of the seller's that is sent the events it subscribes to
+type WebhookEndpoint struct {
+	PK             string   `dynamodbav:"PK"`
+	SK             string   `dynamodbav:"SK"`
+	Type           string   `dynamodbav:"Type"`
+	EndpointID     string   `dynamodbav:"endpointID"`
+	SellerID       string   `dynamodbav:"sellerID"`
+	URL            string   `dynamodbav:"url"`
+	Description    string   `dynamodbav:"description"`
+	Events         []string `dynamodbav:"events"` // domain event names, such as 'order.paid'
+	Secret         string   `dynamodbav:"secret"` // signs deliveries; shown to the seller to verify them with
+	Status         string   `dynamodbav:"status"`
+	DisabledReason string   `dynamodbav:"disabledReason"` // why it was disabled, when it wasn't the seller
+	FailingSince   string   `dynamodbav:"failingSince"`   // first failed attempt since the last successful one
+	Version        int64    `dynamodbav:"version"`
+	GSI1PK         string   `dynamodbav:"GSI1PK"` // the seller's endpoints
+	GSI1SK         string   `dynamodbav:"GSI1SK"`
+	CreatedAt      string   `dynamodbav:"createdAt"`
+	UpdatedAt      string   `dynamodbav:"updatedAt"`
+}
+
+// Enabled reports whether the endpoint is being sent events
+func (e WebhookEndpoint) Enabled() bool {
+	return e.Status == WebhookEnabled
+}
+
+// Wants reports whether the endpoint subscribes to an event
+func (e WebhookEndpoint) Wants(name string) bool {
+	for _, n := range e.Events {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending" // waiting to be sent, or to be retried
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // gave up after the last retry, or the endpoint was disabled
+)
+
+// WebhookDelivery is one event sent, or to be sent, to one endpoint. Attempts are retried with
+// backoff until the endpoint answers with a 2xx status.
+type WebhookDelivery struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	Type           string `dynamodbav:"Type"`
+	DeliveryID     string `dynamodbav:"deliveryID"`
+	EndpointID     string `dynamodbav:"endpointID"`
+	SellerID       string `dynamodbav:"sellerID"`
+	EventID        string `dynamodbav:"eventID"`
+	EventName      string `dynamodbav:"eventName"`
+	Payload        string `dynamodbav:"payload"` // the JSON body sent
+	Status         string `dynamodbav:"status"`
+	Attempts       int    `dynamodbav:"attempts"`
+	NextAttemptAt  string `dynamodbav:"nextAttemptAt"`
+	ResponseStatus int    `dynamodbav:"responseStatus"` // HTTP status of the last attempt, 0 if there was no response
+	ResponseBody   string `dynamodbav:"responseBody"`   // the start of the last response
+	LastError      string `dynamodbav:"lastError"`
+	DurationMillis int64  `dynamodbav:"durationMillis"`
+	DeliveredAt    string `dynamodbav:"deliveredAt"`
+	RedeliveryOf   string `dynamodbav:"redeliveryOf"` // the delivery the seller asked to send again
+	Version        int64  `dynamodbav:"version"`
+	GSI1PK         string `dynamodbav:"GSI1PK"` // deliveries by status, due soonest first
+	GSI1SK         string `dynamodbav:"GSI1SK"`
+	GSI2PK         string `dynamodbav:"GSI2PK"` // an endpoint's delivery log
+	GSI2SK         string `dynamodbav:"GSI2SK"`
+	CreatedAt      string `dynamodbav:"createdAt"`
+	UpdatedAt      string `dynamodbav:"updatedAt"`
+}
@@ -0,0 +1,476 @@
+package models
+
+import "fmt"
+
+// Item type constants for the single-table design
+const (
+	ItemTypeUser        = "USER"
+	ItemTypePrinting    = "PRINTING"
+	ItemTypeListing     = "LISTING"
+	ItemTypeListingRef  = "LISTING_REF"
+	ItemTypeProduct     = "PRODUCT"
+	ItemTypeCart        = "CART"
+	ItemTypeOrder       = "ORDER"
+	ItemTypeOrderEvent  = "ORDER_EVENT"
+	ItemTypeCheckoutRef = "CHECKOUT_ORDER"
+	ItemTypeLedger      = "LEDGER"
+	ItemTypeLedgerCopy  = "LEDGER_COPY"
+	ItemTypeLedgerKey   = "LEDGER_KEY"
+	ItemTypeSeller      = "SELLER"
+	ItemTypeFees        = "FEE_SCHEDULE"
+	ItemTypeShipping    = "SHIPPING_PROFILE"
+	ItemTypeTracking    = "TRACKING_EVENT"
+	ItemTypeShipment    = "SHIPMENT"
+	ItemTypeDispute     = "DISPUTE"
+	ItemTypeDisputeMsg  = "DISPUTE_MESSAGE"
+	ItemTypeReview      = "REVIEW"
+	ItemTypeOffer       = "OFFER"
+	ItemTypeOfferEvent  = "OFFER_EVENT"
+	ItemTypeAuction     = "AUCTION"
+	ItemTypeProxy       = "AUCTION_PROXY"
+	ItemTypeBid         = "AUCTION_BID"
+	ItemTypeWant        = "WANT"
+	ItemTypeWantMatch   = "WANT_MATCH"
+	ItemTypeWantDigest  = "WANT_DIGEST"
+	ItemTypeSale        = "SALE"
+	ItemTypePriceGuide  = "PRICE_GUIDE"
+	ItemTypePricePoint  = "PRICE_POINT"
+	ItemTypeRepriceRule = "REPRICE_RULE"
+	ItemTypeRepriceRun  = "REPRICE_RUN"
+	ItemTypeCollection  = "COLLECTION_ITEM"
+	ItemTypePortfolio   = "PORTFOLIO_SNAPSHOT"
+	ItemTypeThread      = "MESSAGE_THREAD"
+	ItemTypeMessage     = "MESSAGE"
+	ItemTypeBlock       = "MESSAGE_BLOCK"
+	ItemTypeNotice      = "NOTIFICATION"
+	ItemTypeNoticePrefs = "NOTIFICATION_PREFS"
+	ItemTypeEvent       = "DOMAIN_EVENT"
+	ItemTypeReceipt     = "EVENT_RECEIPT"
+	ItemTypeAPIKey      = "API_KEY"
+	ItemTypeWebhook     = "WEBHOOK_ENDPOINT"
+	ItemTypeDelivery    = "WEBHOOK_DELIVERY"
+)
+
+// UserKey builds the primary key for a user profile
+func UserKey(userID string) (string, string) {
+	return "USER#" + userID, "PROFILE"
+}
+
+// PrintingKey builds the primary key for a catalog printing
+func PrintingKey(printingID string) (string, string) {
+	return "PRINTING#" + printingID, "PRINTING"
+}
+
+// PrintingIndexKey builds the GSI1 key for listing every printing by card name
+func PrintingIndexKey(cardName, printingID string) (string, string) {
+	return "PRINTINGS", cardName + "#" + printingID
+}
+
+// ListingKey builds the primary key for a listing, grouped under its printing
+func ListingKey(printingID, listingID string) (string, string) {
+	return "PRINTING#" + printingID, "LISTING#" + listingID
+}
+
+// ProductKey builds the primary key for a sealed product
+func ProductKey(productID string) (string, string) {
+	return "PRODUCT#" + productID, "PRODUCT"
+}
+
+// ProductIndexKey builds the GSI1 key for listing every sealed product by name
+func ProductIndexKey(name, productID string) (string, string) {
+	return "PRODUCTS", name + "#" + productID
+}
+
+// ProductListingKey builds the primary key for a sealed product listing, grouped under its product
+func ProductListingKey(productID, listingID string) (string, string) {
+	return "PRODUCT#" + productID, "LISTING#" + listingID
+}
+
+// SellerListingKey builds the GSI1 key for looking up a seller's listings
+func SellerListingKey(sellerID, createdAt, listingID string) (string, string) {
+	return "SELLER#" + sellerID, "LISTING#" + createdAt + "#" + listingID
+}
+
+// ListingRefKey builds the primary key for finding a listing's partition from its ID alone
+func ListingRefKey(listingID string) (string, string) {
+	return "LISTING#" + listingID, "LISTING"
+}
+
+// AllListingsKey builds the GSI2 key for listing every listing, newest last
+func AllListingsKey(createdAt, listingID string) (string, string) {
+	return "LISTINGS", createdAt + "#" + listingID
+}
+
+// CartKey builds the primary key for a user's persisted cart
+func CartKey(userID string) (string, string) {
+	return "USER#" + userID, "CART"
+}
+
+// OrderKey builds the primary key for an order
+func OrderKey(orderID string) (string, string) {
+	return "ORDER#" + orderID, "ORDER"
+}
+
+// OrderEventKey builds the primary key for an order's audit event
+func OrderEventKey(orderID, at string, seq int) (string, string) {
+	return "ORDER#" + orderID, fmt.Sprintf("EVENT#%s#%04d", at, seq)
+}
+
+// BuyerOrderKey builds the GSI1 key for listing a buyer's orders
+func BuyerOrderKey(buyerID, createdAt, orderID string) (string, string) {
+	return "BUYER#" + buyerID, "ORDER#" + createdAt + "#" + orderID
+}
+
+// SellerOrderKey builds the GSI2 key for listing a seller's orders
+func SellerOrderKey(sellerID, createdAt, orderID string) (string, string) {
+	return "SELLER#" + sellerID, "ORDER#" + createdAt + "#" + orderID
+}
+
+// OrderStatusKey builds the GSI3 key for listing the orders in a status
+func OrderStatusKey(status, createdAt, orderID string) (string, string) {
+	return "ORDERS#" + status, createdAt + "#" + orderID
+}
+
+// CheckoutOrderKey builds the primary key recording that an order came from a checkout
+func CheckoutOrderKey(checkoutID, orderID string) (string, string) {
+	return "CHECKOUT#" + checkoutID, "ORDER#" + orderID
+}
+
+// LedgerEntryKey builds the primary key for a journal entry. Entries sort by time within a day partition.
+func LedgerEntryKey(createdAt, entryID string) (string, string) {
+	return "LEDGER#" + createdAt[:10], "ENTRY#" + createdAt + "#" + entryID
+}
+
+// OrderLedgerKey builds the GSI1 key for listing the journal entries of an order. The same key is
+// the primary key of the order's own copy of each entry, which is read consistently.
+func OrderLedgerKey(orderID, createdAt, entryID string) (string, string) {
+	return "ORDER#" + orderID, "LEDGER#" + createdAt + "#" + entryID
+}
+
+// JournalKey builds the GSI2 key for reading every journal entry in order
+func JournalKey(createdAt, entryID string) (string, string) {
+	return "LEDGER", createdAt + "#" + entryID
+}
+
+// AccountLedgerKey builds the primary key for an account's copy of a journal entry posting to it
+func AccountLedgerKey(account, createdAt, entryID string) (string, string) {
+	return "ACCOUNT#" + account, "LEDGER#" + createdAt + "#" + entryID
+}
+
+// LedgerIdempotencyKey builds the primary key that claims a journal entry's idempotency key
+func LedgerIdempotencyKey(key string) (string, string) {
+	return "LEDGERKEY#" + key, "LEDGERKEY"
+}
+
+// SellerProfileKey builds the primary key for a seller profile
+func SellerProfileKey(sellerID string) (string, string) {
+	return "USER#" + sellerID, "SELLER"
+}
+
+// FeeScheduleKey builds the primary key for a fee schedule version
+func FeeScheduleKey(version int) (string, string) {
+	return "FEES", fmt.Sprintf("VERSION#%06d", version)
+}
+
+// ShippingProfileKey builds the primary key for a seller's shipping profile
+func ShippingProfileKey(sellerID string) (string, string) {
+	return "USER#" + sellerID, "SHIPPING"
+}
+
+// TrackingEventKey builds the primary key for a tracking event, stored with its order
+func TrackingEventKey(orderID, occurredAt, eventID string) (string, string) {
+	return "ORDER#" + orderID, "TRACKING#" + occurredAt + "#" + eventID
+}
+
+// ShipmentKey builds the primary key for looking up an order by carrier tracking number
+func ShipmentKey(carrier, trackingNumber string) (string, string) {
+	return "SHIPMENT#" + carrier + "#" + trackingNumber, "SHIPMENT"
+}
+
+// DisputeKey builds the primary key for a dispute
+func DisputeKey(disputeID string) (string, string) {
+	return "DISPUTE#" + disputeID, "DISPUTE"
+}
+
+// DisputeMessageKey builds the primary key for a message in a dispute's thread
+func DisputeMessageKey(disputeID, createdAt, messageID string) (string, string) {
+	return "DISPUTE#" + disputeID, "MESSAGE#" + createdAt + "#" + messageID
+}
+
+// OrderDisputeKey builds the GSI1 key for listing an order's disputes
+func OrderDisputeKey(orderID, createdAt, disputeID string) (string, string) {
+	return "ORDER#" + orderID, "DISPUTE#" + createdAt + "#" + disputeID
+}
+
+// DisputeStatusKey builds the GSI2 key for the admin queue of disputes in a status
+func DisputeStatusKey(status, createdAt, disputeID string) (string, string) {
+	return "DISPUTES#" + status, createdAt + "#" + disputeID
+}
+
+// ReviewKey builds the primary key for an order's review
+func ReviewKey(orderID string) (string, string) {
+	return "ORDER#" + orderID, "REVIEW"
+}
+
+// SellerReviewKey builds the GSI1 key for listing a seller's reviews
+func SellerReviewKey(sellerID, createdAt, orderID string) (string, string) {
+	return "SELLER#" + sellerID, "REVIEW#" + createdAt + "#" + orderID
+}
+
+// ReviewStatusKey builds the GSI2 key for the moderation queue of reviews in a status
+func ReviewStatusKey(status, createdAt, orderID string) (string, string) {
+	return "REVIEWS#" + status, createdAt + "#" + orderID
+}
+
+// OfferKey builds the primary key for an offer
+func OfferKey(offerID string) (string, string) {
+	return "OFFER#" + offerID, "OFFER"
+}
+
+// OfferEventKey builds the primary key for a step in an offer's history
+func OfferEventKey(offerID, at string, seq int64) (string, string) {
+	return "OFFER#" + offerID, fmt.Sprintf("EVENT#%s#%04d", at, seq)
+}
+
+// BuyerOfferKey builds the GSI1 key for listing a buyer's offers
+func BuyerOfferKey(buyerID, createdAt, offerID string) (string, string) {
+	return "BUYER#" + buyerID, "OFFER#" + createdAt + "#" + offerID
+}
+
+// SellerOfferKey builds the GSI2 key for listing the offers a seller has received
+func SellerOfferKey(sellerID, createdAt, offerID string) (string, string) {
+	return "SELLER#" + sellerID, "OFFER#" + createdAt + "#" + offerID
+}
+
+// OfferStatusKey builds the GSI3 key for finding the offers in a status
+func OfferStatusKey(status, createdAt, offerID string) (string, string) {
+	return "OFFERS#" + status, createdAt + "#" + offerID
+}
+
+// AuctionKey builds the primary key for an auction
+func AuctionKey(auctionID string) (string, string) {
+	return "AUCTION#" + auctionID, "AUCTION"
+}
+
+// AuctionProxyKey builds the primary key for a bidder's proxy bid on an auction
+func AuctionProxyKey(auctionID, bidderID string) (string, string) {
+	return "AUCTION#" + auctionID, "PROXY#" + bidderID
+}
+
+// AuctionBidKey builds the primary key for a bid in an auction's history
+func AuctionBidKey(auctionID, at string, seq int64) (string, string) {
+	return "AUCTION#" + auctionID, fmt.Sprintf("BID#%s#%04d", at, seq)
+}
+
+// AuctionStatusKey builds the GSI1 key for finding auctions by status in closing order
+func AuctionStatusKey(status, endsAt, auctionID string) (string, string) {
+	return "AUCTION#STATUS#" + status, endsAt + "#" + auctionID
+}
+
+// SellerAuctionKey builds the GSI2 key for listing a seller's auctions
+func SellerAuctionKey(sellerID, createdAt, auctionID string) (string, string) {
+	return "SELLER#" + sellerID, "AUCTION#" + createdAt + "#" + auctionID
+}
+
+// BidderProxyKey builds the GSI1 key for listing the auctions a buyer has bid on
+func BidderProxyKey(bidderID, createdAt, auctionID string) (string, string) {
+	return "BIDDER#" + bidderID, "AUCTION#" + createdAt + "#" + auctionID
+}
+
+// WantKey builds the primary key for an entry on a buyer's want list
+func WantKey(userID, wantID string) (string, string) {
+	return "USER#" + userID, "WANT#" + wantID
+}
+
+// PrintingWantKey builds the GSI1 key for finding the wants for a printing
+func PrintingWantKey(printingID, userID, wantID string) (string, string) {
+	return "WANTED#" + printingID, userID + "#" + wantID
+}
+
+// WantMatchKey builds the primary key for a listing that matched a buyer's want
+func WantMatchKey(userID, createdAt, matchID string) (string, string) {
+	return "USER#" + userID, "WANTMATCH#" + createdAt + "#" + matchID
+}
+
+// PendingWantMatchKey builds the GSI1 key for finding matches not yet sent in a digest
+func PendingWantMatchKey(createdAt, userID, matchID string) (string, string) {
+	return "WANTMATCH#PENDING", createdAt + "#" + userID + "#" + matchID
+}
+
+// WantDigestKey builds the primary key for a digest sent to a buyer
+func WantDigestKey(userID, sentAt string) (string, string) {
+	return "USER#" + userID, "WANTDIGEST#" + sentAt
+}
+
+// PricedItemPK builds the partition key a printing's or sealed product's sales and prices are
+// grouped under
+func PricedItemPK(printingID, productID string) string {
+	if productID != "" {
+		return "PRODUCT#" + productID
+	}
+	return "PRINTING#" + printingID
+}
+
+// SaleKey builds the primary key for a completed sale, grouped under its printing or product
+func SaleKey(itemPK, soldAt, orderID, listingID string) (string, string) {
+	return itemPK, "SALE#" + soldAt + "#" + orderID + "#" + listingID
+}
+
+// RecentSaleKey builds the GSI1 key for finding the printings and products sold since a time
+func RecentSaleKey(soldAt, itemID string) (string, string) {
+	return "SALES", soldAt + "#" + itemID
+}
+
+// PriceGuideKey builds the primary key for a price guide in a condition, "" for all
+func PriceGuideKey(itemPK, condition string) (string, string) {
+	return itemPK, "PRICEGUIDE#" + priceCondition(condition)
+}
+
+// PricePointKey builds the primary key for a day of price history in a condition
+func PricePointKey(itemPK, condition, day string) (string, string) {
+	return itemPK, "PRICEPOINT#" + priceCondition(condition) + "#" + day
+}
+
+// priceCondition names the every-condition price series in keys
+func priceCondition(condition string) string {
+	if condition == "" {
+		return "ALL"
+	}
+	return condition
+}
+
+// RepriceRuleKey builds the primary key for a seller's repricing rule
+func RepriceRuleKey(sellerID, ruleID string) (string, string) {
+	return "SELLER#" + sellerID, "REPRICERULE#" + ruleID
+}
+
+// EnabledRepriceRuleKey builds the GSI1 key for finding every enabled repricing rule
+func EnabledRepriceRuleKey(sellerID, ruleID string) (string, string) {
+	return "REPRICERULE#ENABLED", sellerID + "#" + ruleID
+}
+
+// RepriceRunKey builds the primary key for a seller's repricing run
+func RepriceRunKey(sellerID, startedAt, runID string) (string, string) {
+	return "SELLER#" + sellerID, "REPRICERUN#" + startedAt + "#" + runID
+}
+
+// CollectionItemKey builds the primary key for an item in a user's collection
+func CollectionItemKey(userID, itemID string) (string, string) {
+	return "USER#" + userID, "COLLECTION#" + itemID
+}
+
+// CollectorKey builds the GSI1 key for finding the users who keep a collection
+func CollectorKey(userID, itemID string) (string, string) {
+	return "COLLECTION", userID + "#" + itemID
+}
+
+// PortfolioSnapshotKey builds the primary key for a day of a user's portfolio value
+func PortfolioSnapshotKey(userID, day string) (string, string) {
+	return "USER#" + userID, "PORTFOLIO#" + day
+}
+
+// ThreadKey builds the primary key for a message thread
+func ThreadKey(threadID string) (string, string) {
+	return "THREAD#" + threadID, "THREAD"
+}
+
+// BuyerThreadKey builds the GSI1 key for a buyer's inbox, most recent activity last
+func BuyerThreadKey(buyerID, lastMessageAt, threadID string) (string, string) {
+	return "USER#" + buyerID, "THREAD#" + lastMessageAt + "#" + threadID
+}
+
+// SellerThreadKey builds the GSI2 key for a seller's inbox, most recent activity last
+func SellerThreadKey(sellerID, lastMessageAt, threadID string) (string, string) {
+	return "SELLER#" + sellerID, "THREAD#" + lastMessageAt + "#" + threadID
+}
+
+// MessageKey builds the primary key for a message, grouped under its thread. seq orders messages
+// sent in the same second.
+func MessageKey(threadID, createdAt string, seq int64, messageID string) (string, string) {
+	return "THREAD#" + threadID, fmt.Sprintf("MESSAGE#%s#%04d#%s", createdAt, seq, messageID)
+}
+
+// MessageStatusKey builds the GSI1 key for the moderation queue of messages in a status
+func MessageStatusKey(status, createdAt, messageID string) (string, string) {
+	return "MESSAGES#" + status, createdAt + "#" + messageID
+}
+
+// MessageBlockKey builds the primary key for a user's block of another user
+func MessageBlockKey(blockerID, blockedID string) (string, string) {
+	return "USER#" + blockerID, "BLOCK#" + blockedID
+}
+
+// RecentBlockKey builds the GSI1 key for the admin list of blocks, newest last
+func RecentBlockKey(createdAt, blockerID, blockedID string) (string, string) {
+	return "BLOCKS", createdAt + "#" + blockerID + "#" + blockedID
+}
+
+// NotificationKey builds the primary key for a notification
+func NotificationKey(notificationID string) (string, string) {
+	return "NOTIFICATION#" + notificationID, "NOTIFICATION"
+}
+
+// OutboxKey builds the GSI1 key for the email outbox, due soonest first
+func OutboxKey(status, nextAttemptAt, notificationID string) (string, string) {
+	return "OUTBOX#" + status, nextAttemptAt + "#" + notificationID
+}
+
+// UserNotificationKey builds the GSI2 key for a user's site notifications, newest last
+func UserNotificationKey(userID, createdAt, notificationID string) (string, string) {
+	return "USER#" + userID, "NOTIFICATION#" + createdAt + "#" + notificationID
+}
+
+// NotificationPrefsKey builds the primary key for a user's notification preferences
+func NotificationPrefsKey(userID string) (string, string) {
+	return "USER#" + userID, "NOTIFICATION_PREFS"
+}
+
+// DomainEventKey builds the primary key for a domain event in the outbox
+func DomainEventKey(eventID string) (string, string) {
+	return "EVENT#" + eventID, "EVENT"
+}
+
+// EventOutboxKey builds the GSI1 key for domain events by status, due soonest first
+func EventOutboxKey(status, nextAttemptAt, eventID string) (string, string) {
+	return "EVENT_OUTBOX#" + status, nextAttemptAt + "#" + eventID
+}
+
+// EventReceiptKey builds the primary key recording that a consumer has handled an event
+func EventReceiptKey(consumer, eventID string) (string, string) {
+	return "RECEIPT#" + consumer, "EVENT#" + eventID
+}
+
+// APIKeyKey builds the primary key for an API key
+func APIKeyKey(keyID string) (string, string) {
+	return "APIKEY#" + keyID, "APIKEY"
+}
+
+// SellerAPIKeyKey builds the GSI1 key for a seller's API keys, oldest first
+func SellerAPIKeyKey(sellerID, createdAt, keyID string) (string, string) {
+	return "SELLER#" + sellerID, "APIKEY#" + createdAt + "#" + keyID
+}
+
+// WebhookEndpointKey builds the primary key for a webhook endpoint
+func WebhookEndpointKey(endpointID string) (string, string) {
+	return "WEBHOOK#" + endpointID, "WEBHOOK"
+}
+
+// SellerWebhookKey builds the GSI1 key for a seller's webhook endpoints, oldest first
+func SellerWebhookKey(sellerID, createdAt, endpointID string) (string, string) {
+	return "SELLER#" + sellerID, "WEBHOOK#" + createdAt + "#" + endpointID
+}
+
+// WebhookDeliveryKey builds the primary key for a webhook delivery
+func WebhookDeliveryKey(deliveryID string) (string, string) {
+	return "DELIVERY#" + deliveryID, "DELIVERY"
+}
+
+// DeliveryOutboxKey builds the GSI1 key for webhook deliveries by status, due soonest first
+func DeliveryOutboxKey(status, nextAttemptAt, deliveryID string) (string, string) {
+	return "DELIVERY_OUTBOX#" + status, nextAttemptAt + "#" + deliveryID
+}
+
+// EndpointDeliveryKey builds the GSI2 key for an endpoint's delivery log, newest last
+func EndpointDeliveryKey(endpointID, createdAt, deliveryID string) (string, string) {
+	return "WEBHOOK#" + endpointID, "DELIVERY#" + createdAt + "#" + deliveryID
+}
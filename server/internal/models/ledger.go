@@ -0,0 +1,39 @@
+package models
+
+// Ledger entry kinds
+const (
+	LedgerKindPayment       = "payment"        // buyer's payment arrives at the processor
+	LedgerKindSale          = "sale"           // order value split between seller earnings and platform fee
+	LedgerKindRelease       = "release"        // seller earnings become available once an order completes
+	LedgerKindRefund        = "refund"         // sale reversed back to the buyer
+	LedgerKindRefundPayment = "refund_payment" // refunded money leaves the processor
+	LedgerKindProcessingFee = "processing_fee" // the payment processor's charge for taking a payment
+	LedgerKindLabel         = "label"          // postage bought through the marketplace, charged to the seller
+	LedgerKindTransfer      = "transfer"       // seller's share of a payment sent to their connected account
+	LedgerKindReversal      = "reversal"       // part of a transfer taken back from the seller's connected account
+	LedgerKindPayout        = "payout"         // available earnings paid from the connected account to the seller's bank
+)
+
+// LedgerEntry is an immutable double-entry journal entry. Its postings always sum to zero.
+type LedgerEntry struct {
+	PK             string          `dynamodbav:"PK"`
+	SK             string          `dynamodbav:"SK"`
+	Type           string          `dynamodbav:"Type"`
+	EntryID        string          `dynamodbav:"entryID"`
+	Kind           string          `dynamodbav:"kind"`
+	OrderID        string          `dynamodbav:"orderID"` // empty for entries not tied to an order
+	Memo           string          `dynamodbav:"memo"`
+	Postings       []LedgerPosting `dynamodbav:"postings"`
+	IdempotencyKey string          `dynamodbav:"idempotencyKey"`
+	GSI1PK         string          `dynamodbav:"GSI1PK"`
+	GSI1SK         string          `dynamodbav:"GSI1SK"`
+	GSI2PK         string          `dynamodbav:"GSI2PK"` // the whole journal, oldest first
+	GSI2SK         string          `dynamodbav:"GSI2SK"`
+	CreatedAt      string          `dynamodbav:"createdAt"`
+}
+
+// LedgerPosting moves an amount on one account. Debits are positive and credits negative.
+type LedgerPosting struct {
+	Account     string `dynamodbav:"account"`
+	AmountCents int64  `dynamodbav:"amountCents"`
+}
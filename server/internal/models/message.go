@@ -0,0 +1,98 @@
+package models
+
+// Message statuses
+const (
+	MessageStatusVisible = "visible"
+	MessageStatusFlagged = "flagged" // reported and waiting for an admin; still shown
+	MessageStatusHidden  = "hidden"  // removed by an admin
+)
+
+// MessageThread is a conversation between a buyer and a seller about one listing or one order.
+// Each side's unread count is kept on the thread so the header can show it cheaply.
+type MessageThread struct {
+	PK            string `dynamodbav:"PK"`
+	SK            string `dynamodbav:"SK"`
+	Type          string `dynamodbav:"Type"`
+	ThreadID      string `dynamodbav:"threadID"`
+	ListingID     string `dynamodbav:"listingID"` // set for a question about a listing
+	OrderID       string `dynamodbav:"orderID"`   // set for a conversation about an order
+	BuyerID       string `dynamodbav:"buyerID"`
+	SellerID      string `dynamodbav:"sellerID"`
+	Subject       string `dynamodbav:"subject"` // the card or product asked about, or the order's items
+	Preview       string `dynamodbav:"preview"` // the start of the latest message
+	LastMessageAt string `dynamodbav:"lastMessageAt"`
+	BuyerUnread   int    `dynamodbav:"buyerUnread"`
+	SellerUnread  int    `dynamodbav:"sellerUnread"`
+	BlockedBy     string `dynamodbav:"blockedBy"` // the participant who blocked the other, if any
+	Version       int64  `dynamodbav:"version"`
+	GSI1PK        string `dynamodbav:"GSI1PK"`
+	GSI1SK        string `dynamodbav:"GSI1SK"`
+	GSI2PK        string `dynamodbav:"GSI2PK"`
+	GSI2SK        string `dynamodbav:"GSI2SK"`
+	CreatedAt     string `dynamodbav:"createdAt"`
+	UpdatedAt     string `dynamodbav:"updatedAt"`
+}
+
+// Participant reports whether a user is the thread's buyer or seller
+func (t MessageThread) Participant(userID string) bool {
+	return userID != "" && (userID == t.BuyerID || userID == t.SellerID)
+}
+
+// Other returns the participant who isn't userID
+func (t MessageThread) Other(userID string) string {
+	if userID == t.BuyerID {
+		return t.SellerID
+	}
+	return t.BuyerID
+}
+
+// UnreadFor returns how many messages in the thread userID hasn't read
+func (t MessageThread) UnreadFor(userID string) int {
+	switch userID {
+	case t.BuyerID:
+		return t.BuyerUnread
+	case t.SellerID:
+		return t.SellerUnread
+	}
+	return 0
+}
+
+// Message is one message in a thread
+type Message struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	Type           string `dynamodbav:"Type"`
+	MessageID      string `dynamodbav:"messageID"`
+	ThreadID       string `dynamodbav:"threadID"`
+	Seq            int64  `dynamodbav:"seq"` // the thread's version once the message was added
+	SenderID       string `dynamodbav:"senderID"`
+	Body           string `dynamodbav:"body"`
+	Status         string `dynamodbav:"status"`
+	FlagReason     string `dynamodbav:"flagReason"`
+	FlaggedBy      string `dynamodbav:"flaggedBy"`
+	ModeratedBy    string `dynamodbav:"moderatedBy"`
+	ModerationNote string `dynamodbav:"moderationNote"`
+	GSI1PK         string `dynamodbav:"GSI1PK"`
+	GSI1SK         string `dynamodbav:"GSI1SK"`
+	CreatedAt      string `dynamodbav:"createdAt"`
+	UpdatedAt      string `dynamodbav:"updatedAt"`
+}
+
+// Visible reports whether the message's text is shown in its thread
+func (m Message) Visible() bool {
+	return m.Status != MessageStatusHidden
+}
+
+// MessageBlock records that a user blocked another from messaging them
+type MessageBlock struct {
+	PK        string `dynamodbav:"PK"`
+	SK        string `dynamodbav:"SK"`
+	Type      string `dynamodbav:"Type"`
+	BlockerID string `dynamodbav:"blockerID"`
+	BlockedID string `dynamodbav:"blockedID"`
+	ThreadID  string `dynamodbav:"threadID"` // the thread the block was made from
+	Reason    string `dynamodbav:"reason"`
+	GSI1PK    string `dynamodbav:"GSI1PK"`
+	GSI1SK    string `dynamodbav:"GSI1SK"`
+	CreatedAt string `dynamodbav:"createdAt"`
+}
@@ -0,0 +1,76 @@
+package models
+
+// Notification channels
+const (
+	ChannelEmail = "email"
+	ChannelSite  = "site" // the notifications page on the site
+)
+
+// Notification statuses
+const (
+	NotificationStatusPending = "pending" // waiting in the outbox to be sent, or to be retried
+	NotificationStatusSent    = "sent"
+	NotificationStatusFailed  = "failed" // gave up after the last retry
+)
+
+// Notification is one message to a user on one channel. Email notifications wait in the outbox
+// until they're sent; site notifications are delivered as soon as they're stored.
+type Notification struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	Type           string `dynamodbav:"Type"`
+	NotificationID string `dynamodbav:"notificationID"`
+	UserID         string `dynamodbav:"userID"` // empty for an email to an address with no account yet
+	To             string `dynamodbav:"to"`     // email address
+	Kind           string `dynamodbav:"kind"`   // the event, such as 'order_shipped'
+	Category       string `dynamodbav:"category"`
+	Channel        string `dynamodbav:"channel"`
+	Subject        string `dynamodbav:"subject"`
+	HTML           string `dynamodbav:"html"`
+	Text           string `dynamodbav:"text"`
+	Link           string `dynamodbav:"link"` // site path the notification is about
+	Status         string `dynamodbav:"status"`
+	Attempts       int    `dynamodbav:"attempts"`
+	NextAttemptAt  string `dynamodbav:"nextAttemptAt"`
+	LastError      string `dynamodbav:"lastError"`
+	SentAt         string `dynamodbav:"sentAt"`
+	ReadAt         string `dynamodbav:"readAt"` // site notifications only
+	Version        int64  `dynamodbav:"version"`
+	GSI1PK         string `dynamodbav:"GSI1PK"` // outbox by status, set for email only
+	GSI1SK         string `dynamodbav:"GSI1SK"`
+	GSI2PK         string `dynamodbav:"GSI2PK"` // a user's site notifications, set for site only
+	GSI2SK         string `dynamodbav:"GSI2SK"`
+	CreatedAt      string `dynamodbav:"createdAt"`
+	UpdatedAt      string `dynamodbav:"updatedAt"`
+}
+
+// Unread reports whether a site notification hasn't been seen
+func (n Notification) Unread() bool {
+	return n.Channel == ChannelSite && n.ReadAt == ""
+}
+
+// NotificationPreferences are the categories of notification a user has turned off on each
+// channel. Everything is on until the user turns it off.
+type NotificationPreferences struct {
+	PK        string   `dynamodbav:"PK"`
+	SK        string   `dynamodbav:"SK"`
+	Type      string   `dynamodbav:"Type"`
+	UserID    string   `dynamodbav:"userID"`
+	EmailOff  []string `dynamodbav:"emailOff"`
+	SiteOff   []string `dynamodbav:"siteOff"`
+	UpdatedAt string   `dynamodbav:"updatedAt"`
+}
+
+// Allows reports whether the user wants a category of notification on a channel
+func (p NotificationPreferences) Allows(channel, category string) bool {
+	off := p.EmailOff
+	if channel == ChannelSite {
+		off = p.SiteOff
+	}
+	for _, c := range off {
+		if c == category {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package models
+
+// Offer statuses
+const (
+	OfferStatusPending   = "pending"   // waiting for the seller
+	OfferStatusCountered = "countered" // the seller countered; waiting for the buyer
+	OfferStatusAccepted  = "accepted"  // copies are held in the buyer's cart at the offer price
+	OfferStatusDeclined  = "declined"
+	OfferStatusWithdrawn = "withdrawn"
+	OfferStatusExpired   = "expired"   // nobody answered in time
+	OfferStatusPurchased = "purchased" // the buyer checked out the held copies
+	OfferStatusLapsed    = "lapsed"    // the buyer didn't check out in time and the copies went back on sale
+)
+
+// Offer is a buyer's proposed price for copies of a listing, negotiated back and forth with the seller
+type Offer struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	Type           string `dynamodbav:"Type"`
+	OfferID        string `dynamodbav:"offerID"`
+	ListingID      string `dynamodbav:"listingID"`
+	SellerID       string `dynamodbav:"sellerID"`
+	BuyerID        string `dynamodbav:"buyerID"`
+	Quantity       int    `dynamodbav:"quantity"`
+	AmountCents    int64  `dynamodbav:"amountCents"`    // unit price currently on the table
+	ListPriceCents int64  `dynamodbav:"listPriceCents"` // listing price when the offer was made
+	Status         string `dynamodbav:"status"`
+	ExpiresAt      string `dynamodbav:"expiresAt"`     // when an unanswered offer or counter expires
+	ReservedUntil  string `dynamodbav:"reservedUntil"` // when accepted copies go back on sale
+	OrderID        string `dynamodbav:"orderID"`
+	Version        int64  `dynamodbav:"version"`
+	GSI1PK         string `dynamodbav:"GSI1PK"`
+	GSI1SK         string `dynamodbav:"GSI1SK"`
+	GSI2PK         string `dynamodbav:"GSI2PK"`
+	GSI2SK         string `dynamodbav:"GSI2SK"`
+	GSI3PK         string `dynamodbav:"GSI3PK"` // offers by status
+	GSI3SK         string `dynamodbav:"GSI3SK"`
+	CreatedAt      string `dynamodbav:"createdAt"`
+	UpdatedAt      string `dynamodbav:"updatedAt"`
+}
+
+// Open reports whether the offer is still being negotiated
+func (o Offer) Open() bool {
+	return o.Status == OfferStatusPending || o.Status == OfferStatusCountered
+}
+
+// AwaitingRole returns who needs to answer an open offer: 'seller' or 'buyer', or "" when closed
+func (o Offer) AwaitingRole() string {
+	switch o.Status {
+	case OfferStatusPending:
+		return "seller"
+	case OfferStatusCountered:
+		return "buyer"
+	}
+	return ""
+}
+
+// OfferEvent is one step in an offer's history
+type OfferEvent struct {
+	PK          string `dynamodbav:"PK"`
+	SK          string `dynamodbav:"SK"`
+	Type        string `dynamodbav:"Type"`
+	OfferID     string `dynamodbav:"offerID"`
+	Action      string `dynamodbav:"action"` // 'offer' | 'counter' | 'accept' | 'decline' | 'withdraw' | 'expire' | 'purchase' | 'lapse'
+	Role        string `dynamodbav:"role"`   // 'buyer' | 'seller' | 'system'
+	AmountCents int64  `dynamodbav:"amountCents"`
+	Note        string `dynamodbav:"note"`
+	At          string `dynamodbav:"at"`
+}
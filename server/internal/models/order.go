@@ -0,0 +1,82 @@
+package models
+
+// Order statuses
+const (
+	OrderStatusPendingPayment = "pending_payment"
+	OrderStatusPaid           = "paid"
+	OrderStatusShipped        = "shipped"
+	OrderStatusDelivered      = "delivered"
+	OrderStatusCompleted      = "completed"
+	OrderStatusCancelled      = "cancelled"
+	OrderStatusRefunded       = "refunded"
+)
+
+// Order is a purchase from a single seller. Checkout creates one order per seller in the cart.
+type Order struct {
+	PK             string         `dynamodbav:"PK"`
+	SK             string         `dynamodbav:"SK"`
+	Type           string         `dynamodbav:"Type"`
+	OrderID        string         `dynamodbav:"orderID"`
+	CheckoutID     string         `dynamodbav:"checkoutID"` // shared by every order created from one cart
+	BuyerID        string         `dynamodbav:"buyerID"`
+	SellerID       string         `dynamodbav:"sellerID"`
+	Status         string         `dynamodbav:"status"`
+	Items          []OrderItem    `dynamodbav:"items"`
+	SubtotalCents  int64          `dynamodbav:"subtotalCents"`
+	ShippingCents  int64          `dynamodbav:"shippingCents"`
+	TotalCents     int64          `dynamodbav:"totalCents"`
+	FeeCents       int64          `dynamodbav:"feeCents"`   // platform fee charged on the order
+	FeeVersion     int            `dynamodbav:"feeVersion"` // fee schedule version the fee was assessed with
+	ShipTo         Address        `dynamodbav:"shipTo"`
+	ShippingMethod string         `dynamodbav:"shippingMethod"` // seller's shipping method ID chosen at checkout
+	ShippingName   string         `dynamodbav:"shippingName"`
+	Carrier        string         `dynamodbav:"carrier"`
+	TrackingNumber string         `dynamodbav:"trackingNumber"`
+	Label          *ShippingLabel `dynamodbav:"label"`          // set when the seller bought postage through the marketplace
+	LabelStartedAt string         `dynamodbav:"labelStartedAt"` // set while a label is being bought for the order
+	TrackingStatus string         `dynamodbav:"trackingStatus"` // latest carrier status
+	ShippedAt      string         `dynamodbav:"shippedAt"`
+	DeliveredAt    string         `dynamodbav:"deliveredAt"`
+	PaymentIntent  string         `dynamodbav:"paymentIntent"` // payment provider intent ID
+	Version        int64          `dynamodbav:"version"`
+	GSI1PK         string         `dynamodbav:"GSI1PK"`
+	GSI1SK         string         `dynamodbav:"GSI1SK"`
+	GSI2PK         string         `dynamodbav:"GSI2PK"`
+	GSI2SK         string         `dynamodbav:"GSI2SK"`
+	GSI3PK         string         `dynamodbav:"GSI3PK"` // orders by status
+	GSI3SK         string         `dynamodbav:"GSI3SK"`
+	CreatedAt      string         `dynamodbav:"createdAt"`
+	UpdatedAt      string         `dynamodbav:"updatedAt"`
+}
+
+// OrderItem is a purchased listing, snapshotted at checkout so later listing edits don't change the order
+type OrderItem struct {
+	ListingID      string `dynamodbav:"listingID"`
+	PrintingID     string `dynamodbav:"printingID"`
+	ProductID      string `dynamodbav:"productID"` // set instead of PrintingID for a sealed product
+	Game           string `dynamodbav:"game"`
+	Category       string `dynamodbav:"category"`
+	CardName       string `dynamodbav:"cardName"` // the card's name, or the sealed product's
+	SetName        string `dynamodbav:"setName"`
+	Condition      string `dynamodbav:"condition"`
+	Language       string `dynamodbav:"language"`
+	Foil           bool   `dynamodbav:"foil"`
+	Quantity       int    `dynamodbav:"quantity"`
+	UnitPriceCents int64  `dynamodbav:"unitPriceCents"`
+	FeeCents       int64  `dynamodbav:"feeCents"`
+	OfferID        string `dynamodbav:"offerID"`   // set when the item was bought at an offer price
+	AuctionID      string `dynamodbav:"auctionID"` // set when the item was won at auction
+}
+
+// OrderEvent is an audit record of an order status transition
+type OrderEvent struct {
+	PK      string `dynamodbav:"PK"`
+	SK      string `dynamodbav:"SK"`
+	Type    string `dynamodbav:"Type"`
+	OrderID string `dynamodbav:"orderID"`
+	From    string `dynamodbav:"from"`
+	To      string `dynamodbav:"to"`
+	Actor   string `dynamodbav:"actor"` // 'system' | 'user:<id>' | 'admin:<id>'
+	Reason  string `dynamodbav:"reason"`
+	At      string `dynamodbav:"at"`
+}
@@ -0,0 +1,85 @@
+package models
+
+// Sale is one completed order item, recorded for the price guide. A sale is keyed by its order and
+// listing, so recording the same order twice doesn't count it twice. It is for a printing or, when
+// ProductID is set, a sealed product. A sale whose order is refunded is kept, marked refunded, so
+// the next aggregation rewrites the guides it counted toward.
+type Sale struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	Type           string `dynamodbav:"Type"`
+	PrintingID     string `dynamodbav:"printingID"`
+	ProductID      string `dynamodbav:"productID"`
+	OrderID        string `dynamodbav:"orderID"`
+	ListingID      string `dynamodbav:"listingID"`
+	Condition      string `dynamodbav:"condition"`
+	Language       string `dynamodbav:"language"`
+	Foil           bool   `dynamodbav:"foil"`
+	Quantity       int    `dynamodbav:"quantity"`
+	UnitPriceCents int64  `dynamodbav:"unitPriceCents"`
+	SoldAt         string `dynamodbav:"soldAt"`     // when the order completed
+	RefundedAt     string `dynamodbav:"refundedAt"` // when the order was refunded, "" if it wasn't
+	GSI1PK         string `dynamodbav:"GSI1PK"`
+	GSI1SK         string `dynamodbav:"GSI1SK"`
+}
+
+// PriceGuide is the current market summary of a printing or sealed product in one condition, or in
+// every condition when Condition is empty. It covers the completed sales in the guide's window, less outliers.
+type PriceGuide struct {
+	PK          string `dynamodbav:"PK"`
+	SK          string `dynamodbav:"SK"`
+	Type        string `dynamodbav:"Type"`
+	PrintingID  string `dynamodbav:"printingID"`
+	ProductID   string `dynamodbav:"productID"`
+	Condition   string `dynamodbav:"condition"`   // "" for every condition
+	MarketCents int64  `dynamodbav:"marketCents"` // recency-weighted average sale price
+	LowCents    int64  `dynamodbav:"lowCents"`
+	MedianCents int64  `dynamodbav:"medianCents"`
+	HighCents   int64  `dynamodbav:"highCents"`
+	Sales       int    `dynamodbav:"sales"`    // copies sold in the window, outliers excluded
+	Outliers    int    `dynamodbav:"outliers"` // copies left out as mis-keyed or otherwise unrepresentative
+	TrendBps    int64  `dynamodbav:"trendBps"` // change in median price over the trend period, in basis points
+	HasTrend    bool   `dynamodbav:"hasTrend"` // false until both trend periods have sales
+	UpdatedAt   string `dynamodbav:"updatedAt"`
+}
+
+// PricePoint is one day of a printing's or sealed product's price history in one condition, or in
+// every condition when Condition is empty
+type PricePoint struct {
+	PK          string `dynamodbav:"PK"`
+	SK          string `dynamodbav:"SK"`
+	Type        string `dynamodbav:"Type"`
+	PrintingID  string `dynamodbav:"printingID"`
+	ProductID   string `dynamodbav:"productID"`
+	Condition   string `dynamodbav:"condition"`
+	Day         string `dynamodbav:"day"` // YYYY-MM-DD in UTC
+	MarketCents int64  `dynamodbav:"marketCents"`
+	LowCents    int64  `dynamodbav:"lowCents"`
+	MedianCents int64  `dynamodbav:"medianCents"`
+	HighCents   int64  `dynamodbav:"highCents"`
+	Sales       int    `dynamodbav:"sales"`
+}
+
+// ItemID is the ID of the printing or sealed product sold
+func (s Sale) ItemID() string {
+	if s.ProductID != "" {
+		return s.ProductID
+	}
+	return s.PrintingID
+}
+
+// ItemID is the ID of the printing or sealed product priced
+func (g PriceGuide) ItemID() string {
+	if g.ProductID != "" {
+		return g.ProductID
+	}
+	return g.PrintingID
+}
+
+// ItemID is the ID of the printing or sealed product priced
+func (p PricePoint) ItemID() string {
+	if p.ProductID != "" {
+		return p.ProductID
+	}
+	return p.PrintingID
+}
@@ -0,0 +1,93 @@
+package models
+
+// Sealed product types
+const (
+	ProductBoosterBox    = "booster_box"
+	ProductBoosterPack   = "booster_pack"
+	ProductETB           = "elite_trainer_box"
+	ProductBundle        = "bundle"
+	ProductCollectionBox = "collection_box"
+	ProductStarterDeck   = "starter_deck"
+	ProductCase          = "case"
+)
+
+// ProductTypes lists the sealed product types the catalog carries
+var ProductTypes = []string{
+	ProductBoosterBox, ProductBoosterPack, ProductETB, ProductBundle,
+	ProductCollectionBox, ProductStarterDeck, ProductCase,
+}
+
+// ProductTypeNames are the display names of the product types
+var ProductTypeNames = map[string]string{
+	ProductBoosterBox:    "Booster box",
+	ProductBoosterPack:   "Booster pack",
+	ProductETB:           "Elite Trainer Box",
+	ProductBundle:        "Bundle",
+	ProductCollectionBox: "Collection box",
+	ProductStarterDeck:   "Starter deck",
+	ProductCase:          "Case",
+}
+
+// Sealed product conditions
+const (
+	ConditionSealed = "Sealed" // factory sealed, shrink wrap intact
+	ConditionOpened = "Opened" // the outer seal is broken; the contents are listed as they are
+)
+
+// SealedConditions lists the conditions a sealed product listing can have, best first
+var SealedConditions = []string{ConditionSealed, ConditionOpened}
+
+// ProductContent is one line of what a sealed product holds, such as 36 draft boosters
+type ProductContent struct {
+	Quantity int    `dynamodbav:"quantity"`
+	Item     string `dynamodbav:"item"`
+}
+
+// Product is a sealed SKU such as a booster box or an Elite Trainer Box
+type Product struct {
+	PK          string           `dynamodbav:"PK"`
+	SK          string           `dynamodbav:"SK"`
+	Type        string           `dynamodbav:"Type"`
+	ProductID   string           `dynamodbav:"productID"`
+	Game        string           `dynamodbav:"game"`
+	SetCode     string           `dynamodbav:"setCode"`
+	SetName     string           `dynamodbav:"setName"`
+	Name        string           `dynamodbav:"name"`
+	ProductType string           `dynamodbav:"productType"` // one of ProductTypes
+	UPC         string           `dynamodbav:"upc"`         // UPC-A or EAN-13 barcode, when known
+	Contents    []ProductContent `dynamodbav:"contents"`
+	ImageURL    string           `dynamodbav:"imageURL"`
+	GSI1PK      string           `dynamodbav:"GSI1PK"` // every product, by name
+	GSI1SK      string           `dynamodbav:"GSI1SK"`
+	CreatedAt   string           `dynamodbav:"createdAt"`
+	UpdatedAt   string           `dynamodbav:"updatedAt"`
+}
+
+// TypeName is the display name of the product's type
+func (p Product) TypeName() string {
+	if name, ok := ProductTypeNames[p.ProductType]; ok {
+		return name
+	}
+	return p.ProductType
+}
+
+// Conditions returns the conditions the product can be listed in. Packs only sell factory
+// sealed, since an opened pack is just its cards, and so do cases, which are sold for the
+// guarantee that their boxes were never searched.
+func (p Product) Conditions() []string {
+	switch p.ProductType {
+	case ProductBoosterPack, ProductCase:
+		return SealedConditions[:1]
+	}
+	return SealedConditions
+}
+
+// AllowsCondition reports whether the product can be listed in a condition
+func (p Product) AllowsCondition(c string) bool {
+	for _, known := range p.Conditions() {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package models
+
+// Repricing rule kinds
+const (
+	RepriceMarketMinus = "market_minus" // price at the market price less a percentage
+	RepriceUndercut    = "undercut"     // price just below the cheapest other Near Mint listing
+	RepriceFloor       = "floor"        // never price below a minimum
+)
+
+// RepriceKinds lists the kinds of repricing rule, in the order they're offered to sellers
+var RepriceKinds = []string{RepriceMarketMinus, RepriceUndercut, RepriceFloor}
+
+// Repricing run statuses
+const (
+	RepriceRunDryRun     = "dry_run"     // a report of what would change; nothing was applied
+	RepriceRunApplied    = "applied"     // the changes were applied to listings
+	RepriceRunRolledBack = "rolled_back" // every applied change has since been reverted
+)
+
+// RepriceRule is one of a seller's automatic repricing rules. A rule applies to the seller's
+// active listings matching its scope; an empty scope field matches anything.
+type RepriceRule struct {
+	PK          string `dynamodbav:"PK"`
+	SK          string `dynamodbav:"SK"`
+	Type        string `dynamodbav:"Type"`
+	RuleID      string `dynamodbav:"ruleID"`
+	SellerID    string `dynamodbav:"sellerID"`
+	Name        string `dynamodbav:"name"`
+	Kind        string `dynamodbav:"kind"`        // one of RepriceKinds
+	PercentBps  int64  `dynamodbav:"percentBps"`  // market_minus: discount off the market price, in basis points
+	AmountCents int64  `dynamodbav:"amountCents"` // undercut: how far below the cheapest listing; floor: the minimum price
+	SetCode     string `dynamodbav:"setCode"`
+	Rarity      string `dynamodbav:"rarity"`
+	Tag         string `dynamodbav:"tag"`
+	Priority    int    `dynamodbav:"priority"` // lower runs first; the first pricing rule that can price a listing wins
+	Enabled     bool   `dynamodbav:"enabled"`
+	GSI1PK      string `dynamodbav:"GSI1PK"` // set only while the rule is enabled
+	GSI1SK      string `dynamodbav:"GSI1SK"`
+	CreatedAt   string `dynamodbav:"createdAt"`
+	UpdatedAt   string `dynamodbav:"updatedAt"`
+}
+
+// Matches reports whether a listing of a printing is in the rule's scope
+func (r RepriceRule) Matches(p Printing, l Listing) bool {
+	if r.SetCode != "" && r.SetCode != p.SetCode {
+		return false
+	}
+	if r.Rarity != "" && r.Rarity != p.Rarity {
+		return false
+	}
+	return r.Tag == "" || l.HasTag(r.Tag)
+}
+
+// RepriceChange is one listing's price change in a repricing run
+type RepriceChange struct {
+	ListingID   string `dynamodbav:"listingID"`
+	PrintingID  string `dynamodbav:"printingID"`
+	CardName    string `dynamodbav:"cardName"`
+	Condition   string `dynamodbav:"condition"`
+	OldCents    int64  `dynamodbav:"oldCents"`
+	NewCents    int64  `dynamodbav:"newCents"`
+	RuleID      string `dynamodbav:"ruleID"`      // the pricing rule that set the price, if any
+	FloorRuleID string `dynamodbav:"floorRuleID"` // the floor that raised the price, if any
+	Reason      string `dynamodbav:"reason"`
+	Error       string `dynamodbav:"error"`      // why an applied run couldn't make the change
+	RolledBack  bool   `dynamodbav:"rolledBack"` // the change has been reverted
+}
+
+// Touches reports whether a rule set or limited the change
+func (c RepriceChange) Touches(ruleID string) bool {
+	return c.RuleID == ruleID || c.FloorRuleID == ruleID
+}
+
+// RepriceRun is the report of one pass of a seller's repricing rules over their listings
+type RepriceRun struct {
+	PK           string          `dynamodbav:"PK"`
+	SK           string          `dynamodbav:"SK"`
+	Type         string          `dynamodbav:"Type"`
+	RunID        string          `dynamodbav:"runID"`
+	SellerID     string          `dynamodbav:"sellerID"`
+	Status       string          `dynamodbav:"status"`
+	Trigger      string          `dynamodbav:"trigger"`   // "schedule" or "seller"
+	Listings     int             `dynamodbav:"listings"`  // active listings looked at
+	Unchanged    int             `dynamodbav:"unchanged"` // listings no rule moved
+	Failed       int             `dynamodbav:"failed"`    // listings that couldn't be priced
+	Error        string          `dynamodbav:"error"`     // why the first of them couldn't be
+	Changes      []RepriceChange `dynamodbav:"changes"`
+	StartedAt    string          `dynamodbav:"startedAt"`
+	RolledBackAt string          `dynamodbav:"rolledBackAt"`
+}
@@ -0,0 +1,58 @@
+package models
+
+// Review statuses
+const (
+	ReviewStatusPublished = "published"
+	ReviewStatusFlagged   = "flagged" // reported and waiting for an admin; still shown
+	ReviewStatusHidden    = "hidden"  // removed by an admin
+)
+
+// Review is a buyer's rating of a seller for one completed order. A review is identified by its
+// order, so an order can only be reviewed once.
+type Review struct {
+	PK                  string `dynamodbav:"PK"`
+	SK                  string `dynamodbav:"SK"`
+	Type                string `dynamodbav:"Type"`
+	OrderID             string `dynamodbav:"orderID"`
+	BuyerID             string `dynamodbav:"buyerID"`
+	SellerID            string `dynamodbav:"sellerID"`
+	ItemRating          int    `dynamodbav:"itemRating"` // 1-5, item as described
+	ShippingRating      int    `dynamodbav:"shippingRating"`
+	CommunicationRating int    `dynamodbav:"communicationRating"`
+	Body                string `dynamodbav:"body"`
+	Response            string `dynamodbav:"response"` // the seller's public reply
+	RespondedAt         string `dynamodbav:"respondedAt"`
+	Status              string `dynamodbav:"status"`
+	FlagReason          string `dynamodbav:"flagReason"`
+	FlaggedBy           string `dynamodbav:"flaggedBy"`
+	ModeratedBy         string `dynamodbav:"moderatedBy"`
+	ModerationNote      string `dynamodbav:"moderationNote"`
+	Version             int64  `dynamodbav:"version"`
+	GSI1PK              string `dynamodbav:"GSI1PK"`
+	GSI1SK              string `dynamodbav:"GSI1SK"`
+	GSI2PK              string `dynamodbav:"GSI2PK"`
+	GSI2SK              string `dynamodbav:"GSI2SK"`
+	CreatedAt           string `dynamodbav:"createdAt"`
+	UpdatedAt           string `dynamodbav:"updatedAt"`
+}
+
+// Overall is the mean of the review's three ratings
+func (r Review) Overall() float64 {
+	return float64(r.ItemRating+r.ShippingRating+r.CommunicationRating) / 3
+}
+
+// Visible reports whether the review is shown to buyers and counts toward the seller's score
+func (r Review) Visible() bool {
+	return r.Status != ReviewStatusHidden
+}
+
+// SellerScore is a seller's recency-weighted average ratings. It is computed from visible
+// reviews and not stored.
+type SellerScore struct {
+	SellerID      string  `json:"sellerId"`
+	Overall       float64 `json:"overall"`
+	Item          float64 `json:"item"`
+	Shipping      float64 `json:"shipping"`
+	Communication float64 `json:"communication"`
+	Count         int     `json:"count"`
+}
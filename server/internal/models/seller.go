@@ -0,0 +1,23 @@
+package models
+
+// Seller risk tiers
+const (
+	RiskTierLow      = "low"
+	RiskTierStandard = "standard"
+	RiskTierHigh     = "high"
+)
+
+// SellerProfile holds marketplace settings for a user who sells
+type SellerProfile struct {
+	PK              string `dynamodbav:"PK"`
+	SK              string `dynamodbav:"SK"`
+	Type            string `dynamodbav:"Type"`
+	SellerID        string `dynamodbav:"sellerID"`
+	DisplayName     string `dynamodbav:"displayName"`
+	RiskTier        string `dynamodbav:"riskTier"`        // 'low' | 'standard' | 'high'
+	PayoutAccountID string `dynamodbav:"payoutAccountID"` // connected account at the payment provider, e.g. Stripe's acct_*
+	PayoutsEnabled  bool   `dynamodbav:"payoutsEnabled"`  // the connected account has finished onboarding and can be paid
+	Version         int64  `dynamodbav:"version"`
+	CreatedAt       string `dynamodbav:"createdAt"`
+	UpdatedAt       string `dynamodbav:"updatedAt"`
+}
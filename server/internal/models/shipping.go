@@ -0,0 +1,64 @@
+package models
+
+// Shipping services, from cheapest to most protected
+const (
+	ShippingServicePWE    = "pwe"    // plain white envelope, untracked
+	ShippingServiceBubble = "bubble" // tracked bubble mailer
+	ShippingServiceBox    = "box"    // insured box
+)
+
+// Shipping zones, relative to the seller's ship-from country
+const (
+	ShippingZoneDomestic      = "domestic"
+	ShippingZoneInternational = "international"
+)
+
+// Address is a postal address
+type Address struct {
+	Name       string `dynamodbav:"name"`
+	Line1      string `dynamodbav:"line1"`
+	Line2      string `dynamodbav:"line2"`
+	City       string `dynamodbav:"city"`
+	Region     string `dynamodbav:"region"`
+	PostalCode string `dynamodbav:"postalCode"`
+	Country    string `dynamodbav:"country"` // ISO 3166-1 alpha-2
+}
+
+// ShippingProfile holds how a seller ships and what it costs
+type ShippingProfile struct {
+	PK        string           `dynamodbav:"PK"`
+	SK        string           `dynamodbav:"SK"`
+	Type      string           `dynamodbav:"Type"`
+	SellerID  string           `dynamodbav:"sellerID"`
+	ShipFrom  Address          `dynamodbav:"shipFrom"`
+	Methods   []ShippingMethod `dynamodbav:"methods"`
+	UpdatedAt string           `dynamodbav:"updatedAt"`
+}
+
+// ShippingMethod is one way a seller ships to a zone
+type ShippingMethod struct {
+	MethodID      string `dynamodbav:"methodID"`
+	Name          string `dynamodbav:"name"`
+	Service       string `dynamodbav:"service"` // 'pwe' | 'bubble' | 'box'
+	Zone          string `dynamodbav:"zone"`    // 'domestic' | 'international'
+	BaseCents     int64  `dynamodbav:"baseCents"`
+	PerItemCents  int64  `dynamodbav:"perItemCents"`
+	FreeOverCents int64  `dynamodbav:"freeOverCents"` // order subtotal that ships free; zero means never
+	MaxItems      int    `dynamodbav:"maxItems"`      // most cards the method can hold; zero means no limit
+}
+
+// Tracked reports whether the method's service comes with tracking
+func (m ShippingMethod) Tracked() bool {
+	return m.Service != ShippingServicePWE
+}
+
+// ShippingLabel is a postage label bought from a carrier
+type ShippingLabel struct {
+	LabelID        string `dynamodbav:"labelID"`
+	Carrier        string `dynamodbav:"carrier"`
+	Service        string `dynamodbav:"service"`
+	TrackingNumber string `dynamodbav:"trackingNumber"`
+	LabelURL       string `dynamodbav:"labelURL"`
+	CostCents      int64  `dynamodbav:"costCents"`
+	PurchasedAt    string `dynamodbav:"purchasedAt"`
+}
@@ -14,4 +14,7 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	CartCount       int
+	UnreadMessages  int
+	UnreadNotices   int
 }
\ No newline at end of file
@@ -0,0 +1,48 @@
+package models
+
+// Tracking statuses, normalised across carriers
+const (
+	TrackingStatusPreTransit     = "pre_transit"
+	TrackingStatusInTransit      = "in_transit"
+	TrackingStatusOutForDelivery = "out_for_delivery"
+	TrackingStatusDelivered      = "delivered"
+	TrackingStatusException      = "exception"
+	TrackingStatusReturned       = "returned"
+)
+
+// Tracking event sources
+const (
+	TrackingSourceWebhook = "webhook"
+	TrackingSourcePoll    = "poll"
+)
+
+// TrackingEvent is one carrier scan on an order's shipment. Events are stored under the order so
+// the timeline reads back in the order they happened.
+type TrackingEvent struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	Type           string `dynamodbav:"Type"`
+	EventID        string `dynamodbav:"eventID"` // derived from the scan, so the same scan reported twice is stored once
+	OrderID        string `dynamodbav:"orderID"`
+	Carrier        string `dynamodbav:"carrier"`
+	TrackingNumber string `dynamodbav:"trackingNumber"`
+	Status         string `dynamodbav:"status"`
+	Description    string `dynamodbav:"description"`
+	Location       string `dynamodbav:"location"`
+	OccurredAt     string `dynamodbav:"occurredAt"`
+	Source         string `dynamodbav:"source"` // 'webhook' | 'poll'
+	ReceivedAt     string `dynamodbav:"receivedAt"`
+}
+
+// Shipment maps a carrier tracking number back to the order it was shipped with
+type Shipment struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	Type           string `dynamodbav:"Type"`
+	Carrier        string `dynamodbav:"carrier"`
+	TrackingNumber string `dynamodbav:"trackingNumber"`
+	OrderID        string `dynamodbav:"orderID"`
+	ShippedAt      string `dynamodbav:"shippedAt"` // scans dated before the order shipped are ignored
+	CheckedAt      string `dynamodbav:"checkedAt"` // when the carrier last reported on the shipment or was last polled
+	CreatedAt      string `dynamodbav:"createdAt"`
+}
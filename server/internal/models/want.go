@@ -0,0 +1,90 @@
+package models
+
+// Foil preferences on a want list entry
+const (
+	FoilAny     = "any"
+	FoilOnly    = "foil"
+	FoilNonFoil = "nonfoil"
+)
+
+// FoilPreferences lists the foil preferences a want can have
+var FoilPreferences = []string{FoilAny, FoilOnly, FoilNonFoil}
+
+// Want match reasons
+const (
+	WantMatchListed   = "listed"   // a matching listing was published or went back on sale
+	WantMatchRepriced = "repriced" // a matching listing's price dropped
+)
+
+// Want is a printing on a buyer's want list along with the copies they'd accept
+type Want struct {
+	PK            string `dynamodbav:"PK"`
+	SK            string `dynamodbav:"SK"`
+	Type          string `dynamodbav:"Type"`
+	WantID        string `dynamodbav:"wantID"`
+	UserID        string `dynamodbav:"userID"`
+	PrintingID    string `dynamodbav:"printingID"`
+	Quantity      int    `dynamodbav:"quantity"`
+	MaxPriceCents int64  `dynamodbav:"maxPriceCents"` // highest unit price the buyer will pay, 0 for any
+	MinCondition  string `dynamodbav:"minCondition"`  // worst acceptable condition, "" for any
+	Language      string `dynamodbav:"language"`      // "" for any
+	Foil          string `dynamodbav:"foil"`          // one of FoilPreferences
+	GSI1PK        string `dynamodbav:"GSI1PK"`
+	GSI1SK        string `dynamodbav:"GSI1SK"`
+	CreatedAt     string `dynamodbav:"createdAt"`
+	UpdatedAt     string `dynamodbav:"updatedAt"`
+}
+
+// Accepts reports whether a listing satisfies the want's price, condition, language and foil
+// preferences. It doesn't look at the listing's status or stock.
+func (w Want) Accepts(l Listing) bool {
+	if l.PrintingID != w.PrintingID {
+		return false
+	}
+	if w.MaxPriceCents > 0 && l.PriceCents > w.MaxPriceCents {
+		return false
+	}
+	if w.MinCondition != "" && ConditionRank(l.Condition) > ConditionRank(w.MinCondition) {
+		return false
+	}
+	if w.Language != "" && l.Language != w.Language {
+		return false
+	}
+	switch w.Foil {
+	case FoilOnly:
+		return l.Foil
+	case FoilNonFoil:
+		return !l.Foil
+	}
+	return true
+}
+
+// WantMatch records a listing that matched a want, waiting to go out in the buyer's next digest
+type WantMatch struct {
+	PK         string `dynamodbav:"PK"`
+	SK         string `dynamodbav:"SK"`
+	Type       string `dynamodbav:"Type"`
+	MatchID    string `dynamodbav:"matchID"`
+	UserID     string `dynamodbav:"userID"`
+	WantID     string `dynamodbav:"wantID"`
+	PrintingID string `dynamodbav:"printingID"`
+	ListingID  string `dynamodbav:"listingID"`
+	SellerID   string `dynamodbav:"sellerID"`
+	PriceCents int64  `dynamodbav:"priceCents"`
+	Condition  string `dynamodbav:"condition"`
+	Reason     string `dynamodbav:"reason"`
+	SentAt     string `dynamodbav:"sentAt"` // when the match went out in a digest, "" while pending
+	GSI1PK     string `dynamodbav:"GSI1PK"` // set only while pending
+	GSI1SK     string `dynamodbav:"GSI1SK"`
+	CreatedAt  string `dynamodbav:"createdAt"`
+}
+
+// WantDigest records one batch of matches sent to a buyer
+type WantDigest struct {
+	PK      string `dynamodbav:"PK"`
+	SK      string `dynamodbav:"SK"`
+	Type    string `dynamodbav:"Type"`
+	UserID  string `dynamodbav:"userID"`
+	Matches int    `dynamodbav:"matches"`
+	SentAt  string `dynamodbav:"sentAt"`
+}
@@ -0,0 +1,57 @@
+// Package money converts between display amounts and integer minor units (cents).
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidAmount is returned when an amount cannot be parsed
+var ErrInvalidAmount = errors.New("money: invalid amount")
+
+// Format renders cents as a dollar amount, e.g. 1234 -> "$12.34"
+func Format(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
+
+// Parse converts a dollar amount such as "12.34", "$12" or "0.5" into cents
+func Parse(s string) (int64, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "$"))
+	if s == "" {
+		return 0, ErrInvalidAmount
+	}
+
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > 2 {
+		return 0, ErrInvalidAmount
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w < 0 {
+		return 0, ErrInvalidAmount
+	}
+	f, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil || f < 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	cents := w*100 + f
+	if neg {
+		cents = -cents
+	}
+	return cents, nil
+}
@@ -0,0 +1,85 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Create stores a new notification
+func (s *DynamoStore) Create(ctx context.Context, n models.Notification) error {
+	return s.put(ctx, n, dynamo.IfNotExists())
+}
+
+// Get returns a notification
+func (s *DynamoStore) Get(ctx context.Context, notificationID string) (models.Notification, error) {
+	var n models.Notification
+	pk, sk := models.NotificationKey(notificationID)
+	err := s.table.Get(ctx, pk, sk, &n)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return n, ErrNotFound
+	}
+	return n, err
+}
+
+// Update writes a notification if its stored version is still expectedVersion
+func (s *DynamoStore) Update(ctx context.Context, n models.Notification, expectedVersion int64) error {
+	return s.put(ctx, n, dynamo.IfVersion(expectedVersion))
+}
+
+// Outbox returns the email notifications in a status, due soonest first
+func (s *DynamoStore) Outbox(ctx context.Context, status, dueBy string, limit int) ([]models.Notification, error) {
+	pk, _ := models.OutboxKey(status, "", "")
+	q := dynamo.Query{Index: dynamo.GSI1, PK: pk, Limit: limit}
+	if dueBy != "" {
+		q.SKTo = dynamo.Through(dueBy)
+	}
+	var out []models.Notification
+	err := s.table.Query(ctx, q, &out)
+	return out, err
+}
+
+// ForUser returns a user's site notifications, newest first
+func (s *DynamoStore) ForUser(ctx context.Context, userID string) ([]models.Notification, error) {
+	var out []models.Notification
+	pk, _ := models.UserNotificationKey(userID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk, SKPrefix: "NOTIFICATION#", Descending: true}, &out)
+	return out, err
+}
+
+// Preferences returns a user's preferences, or empty ones if they never set any
+func (s *DynamoStore) Preferences(ctx context.Context, userID string) (models.NotificationPreferences, error) {
+	var p models.NotificationPreferences
+	pk, sk := models.NotificationPrefsKey(userID)
+	err := s.table.Get(ctx, pk, sk, &p)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return models.NotificationPreferences{UserID: userID}, nil
+	}
+	return p, err
+}
+
+// PutPreferences stores a user's preferences
+func (s *DynamoStore) PutPreferences(ctx context.Context, p models.NotificationPreferences) error {
+	return s.table.Put(ctx, p, dynamo.Condition{})
+}
+
+// put writes a notification, mapping a failed condition to ErrConflict
+func (s *DynamoStore) put(ctx context.Context, n models.Notification, cond dynamo.Condition) error {
+	err := s.table.Put(ctx, n, cond)
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
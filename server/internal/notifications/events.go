@@ -0,0 +1,120 @@
+package notifications
+
+import (
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// Notification categories. Users can turn each one off per channel, except account notices.
+const (
+	CategoryAccount  = "account"
+	CategoryOrders   = "orders"
+	CategoryOffers   = "offers"
+	CategoryDisputes = "disputes"
+	CategoryWants    = "wants"
+	CategoryMessages = "messages"
+)
+
+// Category is a group of notifications a user can turn on or off
+type Category struct {
+	Key   string
+	Label string
+}
+
+// Categories are the categories shown on the preferences page, in display order
+var Categories = []Category{
+	{CategoryOrders, "Orders you've bought or sold"},
+	{CategoryOffers, "Offers and counter-offers"},
+	{CategoryDisputes, "Disputes and returns"},
+	{CategoryMessages, "New messages"},
+	{CategoryWants, "Want list matches"},
+}
+
+// Event is something a user should hear about. Each event names the email template it's rendered
+// with, templates/email/<kind>.email.tmpl, and is the data that template is executed with.
+type Event interface {
+	Kind() string
+	Category() string
+	Recipient() string // the user ID, or "" for an Addressed event to someone without an account
+	Link() string      // the site path the event is about
+}
+
+// Addressed is implemented by events sent to an email address rather than looked up from a user
+type Addressed interface {
+	Address() string
+}
+
+// Welcome greets a user who has just verified their email address
+type Welcome struct {
+	Email string
+}
+
+func (e Welcome) Kind() string      { return "welcome" }
+func (e Welcome) Category() string  { return CategoryAccount }
+func (e Welcome) Recipient() string { return "" }
+func (e Welcome) Link() string      { return "/login" }
+func (e Welcome) Address() string   { return e.Email }
+
+// OrderUpdate tells the buyer or seller that an order moved to a new status
+type OrderUpdate struct {
+	UserID string
+	Role   string // 'buyer' | 'seller'
+	Order  models.Order
+}
+
+func (e OrderUpdate) Kind() string      { return "order" }
+func (e OrderUpdate) Category() string  { return CategoryOrders }
+func (e OrderUpdate) Recipient() string { return e.UserID }
+func (e OrderUpdate) Link() string {
+	if e.Role == "seller" {
+		return "/seller/orders/" + e.Order.OrderID
+	}
+	return "/orders/" + e.Order.OrderID
+}
+
+// OfferUpdate tells one side of an offer what the other side, or the clock, did
+type OfferUpdate struct {
+	UserID string
+	Role   string // 'buyer' | 'seller'
+	Offer  models.Offer
+	Event  models.OfferEvent
+}
+
+func (e OfferUpdate) Kind() string      { return "offer" }
+func (e OfferUpdate) Category() string  { return CategoryOffers }
+func (e OfferUpdate) Recipient() string { return e.UserID }
+func (e OfferUpdate) Link() string      { return "/offers/" + e.Offer.OfferID }
+
+// DisputeUpdate tells one side of a dispute that it was opened, answered or moved on
+type DisputeUpdate struct {
+	UserID  string
+	Role    string // 'buyer' | 'seller'
+	Dispute models.Dispute
+}
+
+func (e DisputeUpdate) Kind() string      { return "dispute" }
+func (e DisputeUpdate) Category() string  { return CategoryDisputes }
+func (e DisputeUpdate) Recipient() string { return e.UserID }
+func (e DisputeUpdate) Link() string      { return "/disputes/" + e.Dispute.DisputeID }
+
+// WantsMatched sends a buyer a digest of listings matching their want list
+type WantsMatched struct {
+	UserID  string
+	Matches []models.WantMatch
+}
+
+func (e WantsMatched) Kind() string      { return "wants" }
+func (e WantsMatched) Category() string  { return CategoryWants }
+func (e WantsMatched) Recipient() string { return e.UserID }
+func (e WantsMatched) Link() string      { return "/wants" }
+
+// MessageReceived tells a user someone wrote to them
+type MessageReceived struct {
+	UserID  string
+	Thread  models.MessageThread
+	Message models.Message
+}
+
+func (e MessageReceived) Kind() string      { return "message" }
+func (e MessageReceived) Category() string  { return CategoryMessages }
+func (e MessageReceived) Recipient() string { return e.UserID }
+func (e MessageReceived) Link() string      { return "/messages/" + e.Thread.ThreadID }
@@ -0,0 +1,117 @@
+package notifications
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	notifications map[string]models.Notification            // notificationID -> notification
+	prefs         map[string]models.NotificationPreferences // userID -> preferences
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		notifications: map[string]models.Notification{},
+		prefs:         map[string]models.NotificationPreferences{},
+	}
+}
+
+// Create stores a new notification
+func (s *MemoryStore) Create(ctx context.Context, n models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.notifications[n.NotificationID]; ok {
+		return ErrConflict
+	}
+	s.notifications[n.NotificationID] = n
+	return nil
+}
+
+// Get returns a notification
+func (s *MemoryStore) Get(ctx context.Context, notificationID string) (models.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n, ok := s.notifications[notificationID]
+	if !ok {
+		return n, ErrNotFound
+	}
+	return n, nil
+}
+
+// Update writes a notification if its stored version is still expectedVersion
+func (s *MemoryStore) Update(ctx context.Context, n models.Notification, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.notifications[n.NotificationID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.notifications[n.NotificationID] = n
+	return nil
+}
+
+// Outbox returns the email notifications in a status, due soonest first
+func (s *MemoryStore) Outbox(ctx context.Context, status, dueBy string, limit int) ([]models.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pk, _ := models.OutboxKey(status, "", "")
+	var out []models.Notification
+	for _, n := range s.notifications {
+		if n.GSI1PK != pk {
+			continue
+		}
+		if dueBy != "" && n.NextAttemptAt > dueBy {
+			continue
+		}
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK < out[j].GSI1SK })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// ForUser returns a user's site notifications, newest first
+func (s *MemoryStore) ForUser(ctx context.Context, userID string) ([]models.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pk, _ := models.UserNotificationKey(userID, "", "")
+	var out []models.Notification
+	for _, n := range s.notifications {
+		if n.GSI2PK == pk {
+			out = append(out, n)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI2SK > out[j].GSI2SK })
+	return out, nil
+}
+
+// Preferences returns a user's preferences, or empty ones if they never set any
+func (s *MemoryStore) Preferences(ctx context.Context, userID string) (models.NotificationPreferences, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.prefs[userID]
+	if !ok {
+		return models.NotificationPreferences{UserID: userID}, nil
+	}
+	return p, nil
+}
+
+// PutPreferences stores a user's preferences
+func (s *MemoryStore) PutPreferences(ctx context.Context, p models.NotificationPreferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefs[p.UserID] = p
+	return nil
+}
@@ -0,0 +1,507 @@
+// Package notifications tells users about orders, offers, disputes, messages and want list
+// matches, by email and on the site's notifications page.
+//
+// Events are typed; each names the email template it is rendered with. A user can turn each
+// category off per channel. Email goes through a persisted outbox: Notify only stores the
+// rendered message, and Deliver sends what's due, retrying failures with exponential backoff
+// until MaxAttempts, so a slow or failing mail provider never holds up a request.
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/disputes"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/offers"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+var (
+	// ErrNotFound is returned when a notification does not exist
+	ErrNotFound = errors.New("notifications: not found")
+	// ErrConflict is returned when a notification was changed concurrently
+	ErrConflict = errors.New("notifications: version conflict")
+	// ErrNotAllowed is returned when a notification can't be retried because it didn't fail
+	ErrNotAllowed = errors.New("notifications: not allowed")
+	// ErrNoAddress is returned when there's no email address for the recipient
+	ErrNoAddress = errors.New("notifications: no email address")
+)
+
+// Store persists notifications and preferences.
+type Store interface {
+	Create(ctx context.Context, n models.Notification) error
+	Get(ctx context.Context, notificationID string) (models.Notification, error)
+	// Update writes a notification conditional on expectedVersion
+	Update(ctx context.Context, n models.Notification, expectedVersion int64) error
+	// Outbox returns the email notifications in a status, due soonest first. For pending ones
+	// only those due by dueBy are returned, at most limit of them.
+	Outbox(ctx context.Context, status, dueBy string, limit int) ([]models.Notification, error)
+	// ForUser returns a user's site notifications, newest first
+	ForUser(ctx context.Context, userID string) ([]models.Notification, error)
+	// Preferences returns a user's preferences, or empty ones if they never set any
+	Preferences(ctx context.Context, userID string) (models.NotificationPreferences, error)
+	PutPreferences(ctx context.Context, p models.NotificationPreferences) error
+}
+
+// Renderer renders an email template with the email layout. It matches render.Email.
+type Renderer func(name string, data interface{}) (subject, html, text string, err error)
+
+// Directory looks up a user's email address
+type Directory func(ctx context.Context, userID string) (string, error)
+
+// Options controls email delivery and retries
+type Options struct {
+	BaseURL     string        // the site's address, for links in emails
+	BatchSize   int           // emails sent per Deliver call
+	RetryBase   time.Duration // wait before the first retry; each later retry waits twice as long
+	RetryMax    time.Duration // longest wait between retries
+	MaxAttempts int           // attempts before an email is marked failed
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	BaseURL:     "http://localhost:8080",
+	BatchSize:   50,
+	RetryBase:   time.Minute,
+	RetryMax:    6 * time.Hour,
+	MaxAttempts: 8,
+}
+
+// EmailData is what email templates are executed with
+type EmailData struct {
+	Event          Event
+	BaseURL        string
+	Link           string // absolute URL of the page the event is about
+	PreferencesURL string
+}
+
+// Service renders, stores and delivers notifications.
+type Service struct {
+	store     Store
+	sender    EmailSender
+	render    Renderer
+	directory Directory
+	opts      Options
+	errorLog  *log.Logger
+	now       func() time.Time
+}
+
+// New creates a notifications Service
+func New(store Store, sender EmailSender, render Renderer, directory Directory, opts Options, errorLog *log.Logger) *Service {
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultOptions.BaseURL
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultOptions.BatchSize
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = DefaultOptions.RetryBase
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = DefaultOptions.RetryMax
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	return &Service{
+		store:     store,
+		sender:    sender,
+		render:    render,
+		directory: directory,
+		opts:      opts,
+		errorLog:  errorLog,
+		now:       time.Now,
+	}
+}
+
+// Attach notifies buyers and sellers as their orders move through checkout, shipping and refunds.
+// It subscribes to the order events in the outbox, once for each side, so a failure to notify one
+// side is retried without notifying the other twice.
+func (s *Service) Attach(relay *outbox.Relay) {
+	for _, role := range []string{orders.ActorBuyer, orders.ActorSeller} {
+		relay.Subscribe("notifications.order_"+role, func(ctx context.Context, ev models.DomainEvent) error {
+			var change orders.Change
+			if err := ev.Decode(&change); err != nil {
+				return err
+			}
+			order := change.Order
+			if !slices.Contains(orderRecipients[order.Status], role) {
+				return nil
+			}
+			userID := order.BuyerID
+			if role == orders.ActorSeller {
+				userID = order.SellerID
+			}
+			if change.Event.Actor == orders.Buyer(userID).String() {
+				return nil // they did it themselves
+			}
+			return s.Notify(ctx, OrderUpdate{UserID: userID, Role: role, Order: order})
+		}, "order.*")
+	}
+}
+
+// orderRecipients lists who hears about an order reaching each status. New orders awaiting
+// payment aren't announced; the seller hears once the buyer has paid.
+var orderRecipients = map[string][]string{
+	models.OrderStatusPaid:      {orders.ActorBuyer, orders.ActorSeller},
+	models.OrderStatusShipped:   {orders.ActorBuyer},
+	models.OrderStatusDelivered: {orders.ActorBuyer},
+	models.OrderStatusCompleted: {orders.ActorSeller},
+	models.OrderStatusCancelled: {orders.ActorBuyer, orders.ActorSeller},
+	models.OrderStatusRefunded:  {orders.ActorBuyer, orders.ActorSeller},
+}
+
+// AttachOffers tells each side of an offer when the other side answers or it expires
+func (s *Service) AttachOffers(o *offers.Service) {
+	o.OnChange(func(ctx context.Context, offer models.Offer, ev models.OfferEvent) {
+		if ev.Action == "purchase" || ev.Action == "unclaim" {
+			return // the order is announced instead, and a failed checkout leaves nothing to announce
+		}
+		for _, role := range []string{orders.ActorBuyer, orders.ActorSeller} {
+			if role == ev.Role {
+				continue
+			}
+			userID := offer.BuyerID
+			if role == orders.ActorSeller {
+				userID = offer.SellerID
+			}
+			s.notifyLogged(ctx, OfferUpdate{UserID: userID, Role: role, Offer: offer, Event: ev})
+		}
+	})
+}
+
+// AttachDisputes tells each side of a dispute when the other side or the marketplace team acts
+func (s *Service) AttachDisputes(d *disputes.Service) {
+	d.OnChange(func(ctx context.Context, dispute models.Dispute, actor orders.Actor) {
+		for _, role := range []string{orders.ActorBuyer, orders.ActorSeller} {
+			if role == disputes.Role(dispute, actor) {
+				continue
+			}
+			userID := dispute.BuyerID
+			if role == orders.ActorSeller {
+				userID = dispute.SellerID
+			}
+			s.notifyLogged(ctx, DisputeUpdate{UserID: userID, Role: role, Dispute: dispute})
+		}
+	})
+}
+
+// Notify renders an event and stores it on each channel the recipient wants it on. Email is
+// queued in the outbox and sent by Deliver.
+func (s *Service) Notify(ctx context.Context, ev Event) error {
+	userID := ev.Recipient()
+	prefs := models.NotificationPreferences{UserID: userID}
+	if userID != "" {
+		var err error
+		if prefs, err = s.store.Preferences(ctx, userID); err != nil {
+			return err
+		}
+	}
+	optional := ev.Category() != CategoryAccount
+	wantSite := userID != "" && (!optional || prefs.Allows(models.ChannelSite, ev.Category()))
+	wantEmail := !optional || prefs.Allows(models.ChannelEmail, ev.Category())
+	if !wantSite && !wantEmail {
+		return nil
+	}
+	// Look the address up first, so a failed lookup stores nothing and the caller can try again
+	var to string
+	if wantEmail {
+		var err error
+		if to, err = s.address(ctx, ev); err != nil {
+			return err
+		}
+	}
+
+	data := EmailData{
+		Event:          ev,
+		BaseURL:        s.opts.BaseURL,
+		Link:           s.opts.BaseURL + ev.Link(),
+		PreferencesURL: s.opts.BaseURL + "/notifications/preferences",
+	}
+	subject, html, text, err := s.render(ev.Kind(), data)
+	if err != nil {
+		return fmt.Errorf("rendering %s notification: %w", ev.Kind(), err)
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	n := models.Notification{
+		UserID:    userID,
+		Kind:      ev.Kind(),
+		Category:  ev.Category(),
+		Subject:   subject,
+		Link:      ev.Link(),
+		Version:   1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if wantSite {
+		site := n
+		site.NotificationID = ids.New()
+		site.Channel = models.ChannelSite
+		site.Status = models.NotificationStatusSent
+		site.SentAt = now
+		setKeys(&site)
+		if err := s.store.Create(ctx, site); err != nil {
+			return err
+		}
+	}
+
+	if wantEmail {
+		email := n
+		email.NotificationID = ids.New()
+		email.Channel = models.ChannelEmail
+		email.To = to
+		email.HTML = html
+		email.Text = text
+		email.Status = models.NotificationStatusPending
+		email.NextAttemptAt = now
+		setKeys(&email)
+		if err := s.store.Create(ctx, email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyLogged notifies and logs a failure, for listeners that can't return an error
+func (s *Service) notifyLogged(ctx context.Context, ev Event) {
+	if err := s.Notify(ctx, ev); err != nil {
+		s.errorLog.Printf("%s notification to %s failed: %v", ev.Kind(), ev.Recipient(), err)
+	}
+}
+
+// address returns where an event's email goes
+func (s *Service) address(ctx context.Context, ev Event) (string, error) {
+	if a, ok := ev.(Addressed); ok {
+		return a.Address(), nil
+	}
+	if s.directory == nil {
+		return "", ErrNoAddress
+	}
+	to, err := s.directory(ctx, ev.Recipient())
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNoAddress, err)
+	}
+	if to == "" {
+		return "", ErrNoAddress
+	}
+	return to, nil
+}
+
+// Deliver sends the emails that are due and returns how many went out. Each email is claimed
+// before it is sent by pushing back its next attempt, so a crash or a second worker can't send it
+// twice before the retry delay.
+func (s *Service) Deliver(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+	due, err := s.store.Outbox(ctx, models.NotificationStatusPending, now.Format(time.RFC3339), s.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, n := range due {
+		n, err := s.update(ctx, n.NotificationID, func(n *models.Notification) error {
+			if n.Status != models.NotificationStatusPending {
+				return ErrConflict
+			}
+			n.Attempts++
+			n.NextAttemptAt = now.Add(s.backoff(n.Attempts)).Format(time.RFC3339)
+			return nil
+		})
+		if errors.Is(err, ErrConflict) {
+			continue // another worker has it
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		sendErr := s.sender.Send(ctx, Email{To: n.To, Subject: n.Subject, HTML: n.HTML, Text: n.Text})
+		_, err = s.update(ctx, n.NotificationID, func(n *models.Notification) error {
+			switch {
+			case sendErr == nil:
+				n.Status = models.NotificationStatusSent
+				n.SentAt = n.UpdatedAt
+				n.LastError = ""
+			case n.Attempts >= s.opts.MaxAttempts:
+				n.Status = models.NotificationStatusFailed
+				n.LastError = sendErr.Error()
+			default:
+				n.LastError = sendErr.Error()
+			}
+			return nil
+		})
+		if err != nil {
+			return sent, err
+		}
+		if sendErr != nil {
+			s.errorLog.Printf("sending %s email %s to %s failed (attempt %d): %v", n.Kind, n.NotificationID, n.To, n.Attempts, sendErr)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// backoff returns how long to wait after an attempt before the next one
+func (s *Service) backoff(attempts int) time.Duration {
+	wait := s.opts.RetryBase
+	for i := 1; i < attempts && wait < s.opts.RetryMax; i++ {
+		wait *= 2
+	}
+	if wait > s.opts.RetryMax {
+		wait = s.opts.RetryMax
+	}
+	return wait
+}
+
+// Run delivers due emails every interval until ctx is done
+func (s *Service) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Deliver(ctx); err != nil {
+			s.errorLog.Printf("delivering email failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Outbox returns the emails in a status, due soonest first, for admins to check on delivery
+func (s *Service) Outbox(ctx context.Context, status string) ([]models.Notification, error) {
+	return s.store.Outbox(ctx, status, "", 0)
+}
+
+// Retry puts a failed email back in the outbox to be sent straight away
+func (s *Service) Retry(ctx context.Context, notificationID string) (models.Notification, error) {
+	return s.update(ctx, notificationID, func(n *models.Notification) error {
+		if n.Channel != models.ChannelEmail || n.Status != models.NotificationStatusFailed {
+			return ErrNotAllowed
+		}
+		n.Status = models.NotificationStatusPending
+		n.Attempts = 0
+		n.NextAttemptAt = n.UpdatedAt
+		return nil
+	})
+}
+
+// Feed returns a user's site notifications, newest first
+func (s *Service) Feed(ctx context.Context, userID string) ([]models.Notification, error) {
+	return s.store.ForUser(ctx, userID)
+}
+
+// Unread returns how many site notifications a user hasn't seen
+func (s *Service) Unread(ctx context.Context, userID string) (int, error) {
+	list, err := s.store.ForUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, item := range list {
+		if item.Unread() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// MarkAllRead marks every one of a user's site notifications as seen
+func (s *Service) MarkAllRead(ctx context.Context, userID string) error {
+	list, err := s.store.ForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, item := range list {
+		if !item.Unread() {
+			continue
+		}
+		_, err := s.update(ctx, item.NotificationID, func(n *models.Notification) error {
+			n.ReadAt = n.UpdatedAt
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Preferences returns a user's notification preferences
+func (s *Service) Preferences(ctx context.Context, userID string) (models.NotificationPreferences, error) {
+	return s.store.Preferences(ctx, userID)
+}
+
+// SetPreferences replaces the categories a user has turned off on each channel. Unknown
+// categories are ignored.
+func (s *Service) SetPreferences(ctx context.Context, userID string, emailOff, siteOff []string) (models.NotificationPreferences, error) {
+	p := models.NotificationPreferences{
+		UserID:    userID,
+		EmailOff:  knownCategories(emailOff),
+		SiteOff:   knownCategories(siteOff),
+		UpdatedAt: s.now().UTC().Format(time.RFC3339),
+		Type:      models.ItemTypeNoticePrefs,
+	}
+	p.PK, p.SK = models.NotificationPrefsKey(userID)
+	return p, s.store.PutPreferences(ctx, p)
+}
+
+// knownCategories keeps the optional categories in list, in display order
+func knownCategories(list []string) []string {
+	var out []string
+	for _, c := range Categories {
+		for _, key := range list {
+			if key == c.Key {
+				out = append(out, c.Key)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// update applies a change to a notification and writes it with a version check
+func (s *Service) update(ctx context.Context, notificationID string, mutate func(*models.Notification) error) (models.Notification, error) {
+	n, err := s.store.Get(ctx, notificationID)
+	if err != nil {
+		return n, err
+	}
+
+	expected := n.Version
+	n.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	if err := mutate(&n); err != nil {
+		return n, err
+	}
+	n.Version++
+	setKeys(&n)
+
+	if err := s.store.Update(ctx, n, expected); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// setKeys fills in the single-table keys for a notification. Only emails sit in the outbox and
+// only site notifications are listed for their user.
+func setKeys(n *models.Notification) {
+	n.PK, n.SK = models.NotificationKey(n.NotificationID)
+	n.GSI1PK, n.GSI1SK, n.GSI2PK, n.GSI2SK = "", "", "", ""
+	if n.Channel == models.ChannelEmail {
+		n.GSI1PK, n.GSI1SK = models.OutboxKey(n.Status, n.NextAttemptAt, n.NotificationID)
+	} else {
+		n.GSI2PK, n.GSI2SK = models.UserNotificationKey(n.UserID, n.CreatedAt, n.NotificationID)
+	}
+	n.Type = models.ItemTypeNotice
+}
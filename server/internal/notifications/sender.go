@@ -0,0 +1,153 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Email is a rendered message ready to send
+type Email struct {
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// EmailSender delivers email. An error means the message wasn't accepted and will be retried.
+type EmailSender interface {
+	Send(ctx context.Context, e Email) error
+}
+
+// FileSender writes each email to a .eml file in a directory instead of sending it. It is the
+// default for local runs, so every email can be opened in a mail client.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+// NewFileSender creates a FileSender, making its directory if needed
+func NewFileSender(dir, from string) (*FileSender, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileSender{Dir: dir, From: from}, nil
+}
+
+// Send writes the email to a new file
+func (s *FileSender) Send(ctx context.Context, e Email) error {
+	msg, err := buildMIME(s.From, e)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), randomToken(4))
+	return os.WriteFile(filepath.Join(s.Dir, name), msg, 0o644)
+}
+
+// SMTPSender sends email through an SMTP server, such as a local Mailpit or MailHog
+type SMTPSender struct {
+	Addr     string // host:port
+	From     string
+	Username string // empty for a server without authentication
+	Password string
+}
+
+// Send delivers the email over SMTP
+func (s *SMTPSender) Send(ctx context.Context, e Email) error {
+	msg, err := buildMIME(s.From, e)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, bareAddress(s.From), []string{e.To}, msg)
+}
+
+// buildMIME formats an email as a multipart/alternative message with text and HTML parts
+func buildMIME(from string, e Email) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", e.Text},
+		{"text/html; charset=UTF-8", e.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", e.To},
+		{"Subject", mime.QEncoding.Encode("UTF-8", e.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + randomToken(16) + "@" + domainOf(from) + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// bareAddress strips a display name, turning "TCG Market <no-reply@example.com>" into the address
+func bareAddress(addr string) string {
+	if i := strings.LastIndex(addr, "<"); i >= 0 {
+		return strings.TrimSuffix(addr[i+1:], ">")
+	}
+	return addr
+}
+
+// domainOf returns the domain of an email address, for message IDs
+func domainOf(addr string) string {
+	_, domain, ok := strings.Cut(bareAddress(addr), "@")
+	if !ok || domain == "" {
+		return "localhost"
+	}
+	return domain
+}
+
+// randomToken returns n random bytes as hex
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("notifications: crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,48 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// SESSender sends email with the Amazon SES v2 SendEmail API, using the AWS config the app already
+// loads for Cognito and so its default credential chain
+type SESSender struct {
+	client *sesv2.Client
+	from   string
+}
+
+// NewSESSender creates an SESSender that sends from a verified SES identity
+func NewSESSender(cfg aws.Config, from string) *SESSender {
+	return &SESSender{client: sesv2.NewFromConfig(cfg), from: from}
+}
+
+// Send delivers the email through SES
+func (s *SESSender) Send(ctx context.Context, e Email) error {
+	_, err := s.client.SendEmail(ctx, &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(s.from),
+		Destination:      &types.Destination{ToAddresses: []string{e.To}},
+		Content: &types.EmailContent{
+			Simple: &types.Message{
+				Subject: sesContent(e.Subject),
+				Body: &types.Body{
+					Text: sesContent(e.Text),
+					Html: sesContent(e.HTML),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("ses: %w", err)
+	}
+	return nil
+}
+
+// sesContent is a subject or body part in UTF-8
+func sesContent(data string) *types.Content {
+	return &types.Content{Data: aws.String(data), Charset: aws.String("UTF-8")}
+}
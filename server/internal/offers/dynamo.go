@@ -0,0 +1,85 @@
+package offers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// DynamoStore is a Store over the marketplace table. An offer's history shares its partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Create stores a new offer with its first history event and its domain events
+func (s *DynamoStore) Create(ctx context.Context, o models.Offer, ev models.OfferEvent, out []models.DomainEvent) error {
+	ops := []dynamo.Op{dynamo.PutOp(o, dynamo.IfNotExists()), dynamo.PutOp(ev, dynamo.Condition{})}
+	return s.write(ctx, append(ops, outbox.PutOps(out)...))
+}
+
+// Get returns an offer
+func (s *DynamoStore) Get(ctx context.Context, offerID string) (models.Offer, error) {
+	var o models.Offer
+	pk, sk := models.OfferKey(offerID)
+	err := s.table.Get(ctx, pk, sk, &o)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return o, ErrNotFound
+	}
+	return o, err
+}
+
+// Update writes an offer and its events if the stored version is still expectedVersion
+func (s *DynamoStore) Update(ctx context.Context, o models.Offer, expectedVersion int64, ev models.OfferEvent, out []models.DomainEvent) error {
+	ops := []dynamo.Op{dynamo.PutOp(o, dynamo.IfVersion(expectedVersion)), dynamo.PutOp(ev, dynamo.Condition{})}
+	return s.write(ctx, append(ops, outbox.PutOps(out)...))
+}
+
+// ByBuyer returns a buyer's offers, newest first
+func (s *DynamoStore) ByBuyer(ctx context.Context, buyerID string) ([]models.Offer, error) {
+	pk, _ := models.BuyerOfferKey(buyerID, "", "")
+	return s.query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, SKPrefix: "OFFER#", Descending: true})
+}
+
+// BySeller returns the offers a seller has received, newest first
+func (s *DynamoStore) BySeller(ctx context.Context, sellerID string) ([]models.Offer, error) {
+	pk, _ := models.SellerOfferKey(sellerID, "", "")
+	return s.query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk, SKPrefix: "OFFER#", Descending: true})
+}
+
+// ByStatus returns the offers in a status, oldest first
+func (s *DynamoStore) ByStatus(ctx context.Context, status string) ([]models.Offer, error) {
+	pk, _ := models.OfferStatusKey(status, "", "")
+	return s.query(ctx, dynamo.Query{Index: dynamo.GSI3, PK: pk})
+}
+
+// Events returns an offer's history, oldest first
+func (s *DynamoStore) Events(ctx context.Context, offerID string) ([]models.OfferEvent, error) {
+	var out []models.OfferEvent
+	pk, _ := models.OfferKey(offerID)
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "EVENT#"}, &out)
+	return out, err
+}
+
+// query returns the offers matching q
+func (s *DynamoStore) query(ctx context.Context, q dynamo.Query) ([]models.Offer, error) {
+	var out []models.Offer
+	err := s.table.Query(ctx, q, &out)
+	return out, err
+}
+
+// write applies ops in one transaction, mapping a failed condition to ErrConflict
+func (s *DynamoStore) write(ctx context.Context, ops []dynamo.Op) error {
+	err := s.table.Write(ctx, ops...)
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
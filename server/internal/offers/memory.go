@@ -0,0 +1,110 @@
+package offers
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	offers map[string]models.Offer
+	events map[string][]models.OfferEvent
+	outbox *outbox.MemoryStore
+}
+
+// NewMemoryStore creates an empty MemoryStore that writes domain events to ob, or drops them when
+// ob is nil
+func NewMemoryStore(ob *outbox.MemoryStore) *MemoryStore {
+	return &MemoryStore{
+		offers: map[string]models.Offer{},
+		events: map[string][]models.OfferEvent{},
+		outbox: ob,
+	}
+}
+
+// Create stores a new offer with its first history event
+func (s *MemoryStore) Create(ctx context.Context, o models.Offer, ev models.OfferEvent, out []models.DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.offers[o.OfferID]; ok {
+		return ErrConflict
+	}
+	s.offers[o.OfferID] = o
+	s.events[o.OfferID] = append(s.events[o.OfferID], ev)
+	s.outbox.Append(out...)
+	return nil
+}
+
+// Get returns an offer
+func (s *MemoryStore) Get(ctx context.Context, offerID string) (models.Offer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.offers[offerID]
+	if !ok {
+		return o, ErrNotFound
+	}
+	return o, nil
+}
+
+// Update writes an offer and its events if the stored version is still expectedVersion
+func (s *MemoryStore) Update(ctx context.Context, o models.Offer, expectedVersion int64, ev models.OfferEvent, out []models.DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.offers[o.OfferID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.offers[o.OfferID] = o
+	s.events[o.OfferID] = append(s.events[o.OfferID], ev)
+	s.outbox.Append(out...)
+	return nil
+}
+
+// ByBuyer returns a buyer's offers, newest first
+func (s *MemoryStore) ByBuyer(ctx context.Context, buyerID string) ([]models.Offer, error) {
+	list := s.filter(func(o models.Offer) bool { return o.BuyerID == buyerID })
+	sort.Slice(list, func(i, j int) bool { return list[i].GSI1SK > list[j].GSI1SK })
+	return list, nil
+}
+
+// BySeller returns the offers a seller has received, newest first
+func (s *MemoryStore) BySeller(ctx context.Context, sellerID string) ([]models.Offer, error) {
+	list := s.filter(func(o models.Offer) bool { return o.SellerID == sellerID })
+	sort.Slice(list, func(i, j int) bool { return list[i].GSI2SK > list[j].GSI2SK })
+	return list, nil
+}
+
+// ByStatus returns the offers in a status
+func (s *MemoryStore) ByStatus(ctx context.Context, status string) ([]models.Offer, error) {
+	return s.filter(func(o models.Offer) bool { return o.Status == status }), nil
+}
+
+// Events returns an offer's history, oldest first
+func (s *MemoryStore) Events(ctx context.Context, offerID string) ([]models.OfferEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := append([]models.OfferEvent(nil), s.events[offerID]...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].SK < out[j].SK })
+	return out, nil
+}
+
+// filter returns copies of the offers matching keep
+func (s *MemoryStore) filter(keep func(models.Offer) bool) []models.Offer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Offer
+	for _, o := range s.offers {
+		if keep(o) {
+			out = append(out, o)
+		}
+	}
+	return out
+}
@@ -0,0 +1,554 @@
+// Package offers lets buyers negotiate a price on listings that accept offers.
+//
+// A buyer offers a unit price for some copies; the seller can accept, decline or counter, and the
+// buyer can answer a counter the same way. Unanswered offers expire after a TTL. When an offer is
+// accepted the copies are taken out of stock and put in the buyer's cart at the agreed price for a
+// limited time; if the buyer doesn't check out by then the copies go back on sale.
+package offers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+var (
+	// ErrNotFound is returned when an offer does not exist
+	ErrNotFound = errors.New("offers: not found")
+	// ErrConflict is returned when an offer was changed concurrently
+	ErrConflict = errors.New("offers: version conflict")
+	// ErrNotAllowed is returned when the actor may not take the action, or it isn't their turn
+	ErrNotAllowed = errors.New("offers: not allowed")
+	// ErrClosed is returned when acting on an offer that is no longer open
+	ErrClosed = errors.New("offers: offer is no longer open")
+	// ErrNotAccepting is returned when the listing doesn't take offers or isn't for sale
+	ErrNotAccepting = errors.New("offers: listing isn't taking offers")
+	// ErrAlreadyOpen is returned when the buyer already has an open offer on the listing
+	ErrAlreadyOpen = errors.New("offers: you already have an open offer on this listing")
+	// ErrRateLimited is returned when a buyer has made too many offers recently
+	ErrRateLimited = errors.New("offers: too many offers, try again later")
+	// ErrUnavailable is returned when there aren't enough copies left to accept an offer
+	ErrUnavailable = errors.New("offers: not enough copies left")
+	// ErrInvalid is returned for a bad quantity or amount
+	ErrInvalid = errors.New("offers: invalid offer")
+)
+
+// Store persists offers and their history.
+type Store interface {
+	// Create writes a new offer with its first history event and its domain events in one transaction
+	Create(ctx context.Context, o models.Offer, ev models.OfferEvent, out []models.DomainEvent) error
+	Get(ctx context.Context, offerID string) (models.Offer, error)
+	// Update writes an offer conditional on expectedVersion, together with its history event and
+	// domain events
+	Update(ctx context.Context, o models.Offer, expectedVersion int64, ev models.OfferEvent, out []models.DomainEvent) error
+	// ByBuyer returns a buyer's offers, newest first
+	ByBuyer(ctx context.Context, buyerID string) ([]models.Offer, error)
+	// BySeller returns the offers a seller has received, newest first
+	BySeller(ctx context.Context, sellerID string) ([]models.Offer, error)
+	ByStatus(ctx context.Context, status string) ([]models.Offer, error)
+	// Events returns an offer's history, oldest first
+	Events(ctx context.Context, offerID string) ([]models.OfferEvent, error)
+}
+
+// actionEvents names the domain event for each action taken on an offer. Actions not listed, such
+// as handing back an offer whose checkout failed, aren't published.
+var actionEvents = map[string]string{
+	"offer":    models.EventOfferReceived,
+	"counter":  models.EventOfferCountered,
+	"accept":   models.EventOfferAccepted,
+	"decline":  models.EventOfferDeclined,
+	"withdraw": models.EventOfferWithdrawn,
+	"expire":   models.EventOfferExpired,
+	"purchase": models.EventOfferPurchased,
+	"lapse":    models.EventOfferLapsed,
+}
+
+// Change is the payload of every offer domain event: the offer after the change and its history event
+type Change struct {
+	Offer models.Offer
+	Event models.OfferEvent
+}
+
+// Options controls offer timing and rate limits
+type Options struct {
+	TTL        time.Duration // how long an offer or counter waits for an answer
+	HoldFor    time.Duration // how long accepted copies stay in the buyer's cart
+	RateLimit  int           // offers a buyer may make per RateWindow
+	RateWindow time.Duration
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	TTL:        48 * time.Hour,
+	HoldFor:    24 * time.Hour,
+	RateLimit:  10,
+	RateWindow: 24 * time.Hour,
+}
+
+// Listener is notified after an offer is made or changes, with the history event recording what
+// happened
+type Listener func(ctx context.Context, o models.Offer, ev models.OfferEvent)
+
+// Service negotiates offers and holds accepted copies in buyers' carts.
+type Service struct {
+	store    Store
+	catalog  *catalog.Catalog
+	carts    *cart.Service
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+
+	mu        sync.RWMutex
+	listeners []Listener
+}
+
+// New creates an offers Service
+func New(store Store, c *catalog.Catalog, carts *cart.Service, opts Options, errorLog *log.Logger) *Service {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultOptions.TTL
+	}
+	if opts.HoldFor <= 0 {
+		opts.HoldFor = DefaultOptions.HoldFor
+	}
+	if opts.RateLimit <= 0 {
+		opts.RateLimit = DefaultOptions.RateLimit
+	}
+	if opts.RateWindow <= 0 {
+		opts.RateWindow = DefaultOptions.RateWindow
+	}
+	return &Service{store: store, catalog: c, carts: carts, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// Attach marks offers purchased as checkout writes the order holding their copies
+func (s *Service) Attach(o *orders.Service) {
+	o.BeforeWrite(s.claim)
+}
+
+// OnChange registers a listener for new offers and every later step
+func (s *Service) OnChange(fn Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Role returns the part an actor plays in an offer, or "" if they have none
+func Role(o models.Offer, actor orders.Actor) string {
+	switch {
+	case actor.Kind == orders.ActorSystem:
+		return actor.Kind
+	case actor.ID == o.BuyerID:
+		return orders.ActorBuyer
+	case actor.ID == o.SellerID:
+		return orders.ActorSeller
+	}
+	return ""
+}
+
+// Make opens an offer of amountCents per copy for qty copies of a listing
+func (s *Service) Make(ctx context.Context, buyerID, listingID string, qty int, amountCents int64, note string) (models.Offer, error) {
+	l, err := s.catalog.Listing(ctx, listingID)
+	if errors.Is(err, catalog.ErrNotFound) {
+		return models.Offer{}, ErrNotAccepting
+	}
+	if err != nil {
+		return models.Offer{}, err
+	}
+	if !l.AcceptsOffers || !l.IsActive() {
+		return models.Offer{}, ErrNotAccepting
+	}
+	if l.SellerID == buyerID {
+		return models.Offer{}, ErrNotAllowed
+	}
+	if qty < 1 || qty > l.Quantity {
+		return models.Offer{}, fmt.Errorf("%w: choose between 1 and %d copies", ErrInvalid, l.Quantity)
+	}
+	if amountCents <= 0 || amountCents >= l.PriceCents {
+		return models.Offer{}, fmt.Errorf("%w: offer less than the asking price", ErrInvalid)
+	}
+
+	mine, err := s.store.ByBuyer(ctx, buyerID)
+	if err != nil {
+		return models.Offer{}, err
+	}
+	since := s.now().Add(-s.opts.RateWindow).UTC().Format(time.RFC3339)
+	recent := 0
+	for _, o := range mine {
+		if o.ListingID == listingID && o.Open() {
+			return o, ErrAlreadyOpen
+		}
+		if o.CreatedAt > since {
+			recent++
+		}
+	}
+	if recent >= s.opts.RateLimit {
+		return models.Offer{}, ErrRateLimited
+	}
+
+	now := s.now().UTC()
+	at := now.Format(time.RFC3339)
+	o := models.Offer{
+		OfferID:        ids.New(),
+		ListingID:      l.ListingID,
+		SellerID:       l.SellerID,
+		BuyerID:        buyerID,
+		Quantity:       qty,
+		AmountCents:    amountCents,
+		ListPriceCents: l.PriceCents,
+		Status:         models.OfferStatusPending,
+		ExpiresAt:      now.Add(s.opts.TTL).Format(time.RFC3339),
+		Version:        1,
+		CreatedAt:      at,
+		UpdatedAt:      at,
+	}
+	setKeys(&o)
+
+	ev := newEvent(o, "offer", orders.ActorBuyer, note, at)
+	domainEvent, err := outbox.NewEvent(actionEvents[ev.Action], "offer", o.OfferID, o.Version, at, Change{Offer: o, Event: ev})
+	if err != nil {
+		return o, err
+	}
+	if err := s.store.Create(ctx, o, ev, []models.DomainEvent{domainEvent}); err != nil {
+		return o, err
+	}
+	s.notify(ctx, o, ev)
+	return o, nil
+}
+
+// Get returns an offer
+func (s *Service) Get(ctx context.Context, offerID string) (models.Offer, error) {
+	return s.store.Get(ctx, offerID)
+}
+
+// History returns an offer's history, oldest first
+func (s *Service) History(ctx context.Context, offerID string) ([]models.OfferEvent, error) {
+	return s.store.Events(ctx, offerID)
+}
+
+// ForBuyer returns a buyer's offers, newest first
+func (s *Service) ForBuyer(ctx context.Context, buyerID string) ([]models.Offer, error) {
+	return s.store.ByBuyer(ctx, buyerID)
+}
+
+// ForSeller returns the offers a seller has received, newest first
+func (s *Service) ForSeller(ctx context.Context, sellerID string) ([]models.Offer, error) {
+	return s.store.BySeller(ctx, sellerID)
+}
+
+// Counter answers an open offer with a different unit price, handing the turn to the other party
+func (s *Service) Counter(ctx context.Context, offerID string, actor orders.Actor, amountCents int64, note string) (models.Offer, error) {
+	o, err := s.turn(ctx, offerID, actor)
+	if err != nil {
+		return o, err
+	}
+	if amountCents <= 0 || amountCents > o.ListPriceCents {
+		return o, fmt.Errorf("%w: counter with an amount up to the asking price", ErrInvalid)
+	}
+	if amountCents == o.AmountCents {
+		return o, fmt.Errorf("%w: counter with a different amount, or accept the offer", ErrInvalid)
+	}
+
+	now := s.now().UTC()
+	return s.update(ctx, o, "counter", Role(o, actor), note, func(o *models.Offer) {
+		o.AmountCents = amountCents
+		o.Status = models.OfferStatusCountered
+		if Role(*o, actor) == orders.ActorBuyer {
+			o.Status = models.OfferStatusPending
+		}
+		o.ExpiresAt = now.Add(s.opts.TTL).Format(time.RFC3339)
+	})
+}
+
+// Accept agrees to the amount on the table. The copies are taken out of stock and added to the
+// buyer's cart at that price until the hold runs out.
+func (s *Service) Accept(ctx context.Context, offerID string, actor orders.Actor) (models.Offer, error) {
+	o, err := s.turn(ctx, offerID, actor)
+	if err != nil {
+		return o, err
+	}
+
+	quantities := map[string]int{o.ListingID: o.Quantity}
+	if _, err := s.catalog.Reserve(ctx, quantities); errors.Is(err, catalog.ErrInsufficientStock) {
+		return o, ErrUnavailable
+	} else if err != nil {
+		return o, err
+	}
+
+	until := s.now().UTC().Add(s.opts.HoldFor).Format(time.RFC3339)
+	o, err = s.update(ctx, o, "accept", Role(o, actor), "", func(o *models.Offer) {
+		o.Status = models.OfferStatusAccepted
+		o.ReservedUntil = until
+	})
+	if err != nil {
+		if _, rerr := s.catalog.Release(ctx, quantities); rerr != nil {
+			s.errorLog.Printf("releasing copies for offer %s failed: %v", offerID, rerr)
+		}
+		return o, err
+	}
+
+	c, err := s.carts.Load(ctx, o.BuyerID)
+	if err != nil {
+		return o, fmt.Errorf("offer %s accepted but loading the buyer's cart failed: %w", o.OfferID, err)
+	}
+	s.carts.AddOffer(&c, o)
+	if err := s.carts.Save(ctx, c); err != nil {
+		return o, fmt.Errorf("offer %s accepted but saving the buyer's cart failed: %w", o.OfferID, err)
+	}
+	return o, nil
+}
+
+// Decline turns down the amount on the table and closes the offer
+func (s *Service) Decline(ctx context.Context, offerID string, actor orders.Actor, note string) (models.Offer, error) {
+	o, err := s.turn(ctx, offerID, actor)
+	if err != nil {
+		return o, err
+	}
+	return s.update(ctx, o, "decline", Role(o, actor), note, func(o *models.Offer) {
+		o.Status = models.OfferStatusDeclined
+	})
+}
+
+// Withdraw lets the buyer close their offer while it's still being negotiated
+func (s *Service) Withdraw(ctx context.Context, offerID string, actor orders.Actor) (models.Offer, error) {
+	o, err := s.store.Get(ctx, offerID)
+	if err != nil {
+		return o, err
+	}
+	if Role(o, actor) != orders.ActorBuyer {
+		return o, ErrNotAllowed
+	}
+	if !o.Open() {
+		return o, ErrClosed
+	}
+	return s.update(ctx, o, "withdraw", orders.ActorBuyer, "", func(o *models.Offer) {
+		o.Status = models.OfferStatusWithdrawn
+	})
+}
+
+// Release gives up an accepted offer the buyer no longer wants, putting its copies back on sale
+func (s *Service) Release(ctx context.Context, offerID string, actor orders.Actor) (models.Offer, error) {
+	o, err := s.store.Get(ctx, offerID)
+	if err != nil {
+		return o, err
+	}
+	if Role(o, actor) != orders.ActorBuyer {
+		return o, ErrNotAllowed
+	}
+	return s.lapse(ctx, o, orders.ActorBuyer, "removed from cart")
+}
+
+// ExpireDue expires open offers nobody answered in time and releases accepted copies that
+// weren't checked out, returning how many offers it closed
+func (s *Service) ExpireDue(ctx context.Context) (int, error) {
+	now := s.now().UTC().Format(time.RFC3339)
+	closed := 0
+
+	for _, status := range []string{models.OfferStatusPending, models.OfferStatusCountered} {
+		list, err := s.store.ByStatus(ctx, status)
+		if err != nil {
+			return closed, err
+		}
+		for _, o := range list {
+			if o.ExpiresAt > now {
+				continue
+			}
+			_, err := s.update(ctx, o, "expire", orders.ActorSystem, "", func(o *models.Offer) {
+				o.Status = models.OfferStatusExpired
+			})
+			switch {
+			case errors.Is(err, ErrConflict):
+				// answered in the meantime
+			case err != nil:
+				return closed, err
+			default:
+				closed++
+			}
+		}
+	}
+
+	accepted, err := s.store.ByStatus(ctx, models.OfferStatusAccepted)
+	if err != nil {
+		return closed, err
+	}
+	for _, o := range accepted {
+		if o.ReservedUntil > now {
+			continue
+		}
+		_, err := s.lapse(ctx, o, orders.ActorSystem, "hold expired")
+		switch {
+		case errors.Is(err, ErrConflict), errors.Is(err, ErrClosed):
+			// checked out in the meantime
+		case err != nil:
+			return closed, err
+		default:
+			closed++
+		}
+	}
+	return closed, nil
+}
+
+// Run expires due offers every interval until ctx is done
+func (s *Service) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := s.ExpireDue(ctx); err != nil {
+			s.errorLog.Printf("expiring offers failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// turn loads an open offer and checks it's the actor's turn to answer it
+func (s *Service) turn(ctx context.Context, offerID string, actor orders.Actor) (models.Offer, error) {
+	o, err := s.store.Get(ctx, offerID)
+	if err != nil {
+		return o, err
+	}
+	if !o.Open() {
+		return o, ErrClosed
+	}
+	if o.ExpiresAt <= s.now().UTC().Format(time.RFC3339) {
+		return o, fmt.Errorf("%w: it expired", ErrClosed)
+	}
+	if Role(o, actor) != o.AwaitingRole() {
+		return o, ErrNotAllowed
+	}
+	return o, nil
+}
+
+// lapse closes an accepted offer that wasn't checked out and returns its copies to stock
+func (s *Service) lapse(ctx context.Context, o models.Offer, role, note string) (models.Offer, error) {
+	if o.Status != models.OfferStatusAccepted {
+		return o, ErrClosed
+	}
+	o, err := s.update(ctx, o, "lapse", role, note, func(o *models.Offer) {
+		o.Status = models.OfferStatusLapsed
+	})
+	if err != nil {
+		return o, err
+	}
+	if _, err := s.catalog.Release(ctx, map[string]int{o.ListingID: o.Quantity}); err != nil {
+		return o, fmt.Errorf("offer %s lapsed but releasing its copies failed: %w", o.OfferID, err)
+	}
+	return o, nil
+}
+
+// claim marks the accepted offers an order's lines were bought at as purchased by it. Each write
+// is conditional on the version the offer was read at, so an offer is checked out at most once and
+// can't lapse while an order for it is being written. undo hands the offers back to the buyer.
+func (s *Service) claim(ctx context.Context, order models.Order) (func(context.Context), error) {
+	var claimed []models.Offer
+	undo := func(ctx context.Context) {
+		for _, o := range claimed {
+			_, err := s.update(ctx, o, "unclaim", orders.ActorSystem, "checkout failed", func(o *models.Offer) {
+				o.Status = models.OfferStatusAccepted
+				o.OrderID = ""
+			})
+			if err != nil {
+				s.errorLog.Printf("handing back offer %s after checkout failed: %v", o.OfferID, err)
+			}
+		}
+	}
+
+	for _, it := range order.Items {
+		if it.OfferID == "" {
+			continue
+		}
+		o, err := s.purchased(ctx, it.OfferID, order.OrderID)
+		if err != nil {
+			undo(ctx)
+			return nil, fmt.Errorf("offer %s: %w", it.OfferID, err)
+		}
+		claimed = append(claimed, o)
+	}
+	return undo, nil
+}
+
+// purchased records that an accepted offer's copies were checked out
+func (s *Service) purchased(ctx context.Context, offerID, orderID string) (models.Offer, error) {
+	o, err := s.store.Get(ctx, offerID)
+	if err != nil {
+		return o, err
+	}
+	if o.Status != models.OfferStatusAccepted {
+		return o, fmt.Errorf("%w: offer is %s", ErrClosed, o.Status)
+	}
+	return s.update(ctx, o, "purchase", orders.ActorBuyer, "order "+orderID, func(o *models.Offer) {
+		o.Status = models.OfferStatusPurchased
+		o.OrderID = orderID
+	})
+}
+
+// update applies a change to an offer and writes it with its history event
+func (s *Service) update(ctx context.Context, o models.Offer, action, role, note string, mutate func(*models.Offer)) (models.Offer, error) {
+	at := s.now().UTC().Format(time.RFC3339)
+	expected := o.Version
+	mutate(&o)
+	o.Version++
+	o.UpdatedAt = at
+	setKeys(&o)
+
+	ev := newEvent(o, action, role, note, at)
+	var out []models.DomainEvent
+	if name, ok := actionEvents[action]; ok {
+		domainEvent, err := outbox.NewEvent(name, "offer", o.OfferID, o.Version, at, Change{Offer: o, Event: ev})
+		if err != nil {
+			return o, err
+		}
+		out = append(out, domainEvent)
+	}
+	if err := s.store.Update(ctx, o, expected, ev, out); err != nil {
+		return o, err
+	}
+	s.notify(ctx, o, ev)
+	return o, nil
+}
+
+// notify fans an offer change out to every registered listener
+func (s *Service) notify(ctx context.Context, o models.Offer, ev models.OfferEvent) {
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(ctx, o, ev)
+	}
+}
+
+// setKeys fills in the single-table keys for an offer
+func setKeys(o *models.Offer) {
+	o.PK, o.SK = models.OfferKey(o.OfferID)
+	o.GSI1PK, o.GSI1SK = models.BuyerOfferKey(o.BuyerID, o.CreatedAt, o.OfferID)
+	o.GSI2PK, o.GSI2SK = models.SellerOfferKey(o.SellerID, o.CreatedAt, o.OfferID)
+	o.GSI3PK, o.GSI3SK = models.OfferStatusKey(o.Status, o.CreatedAt, o.OfferID)
+	o.Type = models.ItemTypeOffer
+}
+
+// newEvent builds a history event for the offer's current version
+func newEvent(o models.Offer, action, role, note, at string) models.OfferEvent {
+	ev := models.OfferEvent{
+		OfferID:     o.OfferID,
+		Action:      action,
+		Role:        role,
+		AmountCents: o.AmountCents,
+		Note:        strings.TrimSpace(note),
+		At:          at,
+		Type:        models.ItemTypeOfferEvent,
+	}
+	ev.PK, ev.SK = models.OfferEventKey(o.OfferID, at, o.Version)
+	return ev
+}
@@ -0,0 +1,252 @@
+package offers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// fixture is an offers Service attached to checkout, over in-memory stores with one listing of
+// two $10 copies that takes offers
+type fixture struct {
+	svc     *Service
+	catalog *catalog.Catalog
+	carts   *cart.Service
+	orders  *orders.Service
+	listing models.Listing
+	now     time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	ctx := context.Background()
+	discard := log.New(io.Discard, "", 0)
+
+	ob := outbox.NewMemoryStore()
+	c := catalog.New(catalog.NewMemoryStore(nil))
+	p, err := c.SavePrinting(ctx, models.Printing{Game: "mtg", SetCode: "M10", SetName: "Magic 2010", CardName: "Lightning Bolt", Rarity: "common"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.SaveListing(ctx, models.Listing{SellerID: "seller", PrintingID: p.PrintingID, Condition: "NM", Language: "en", PriceCents: 1000, Quantity: 2, AcceptsOffers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fixture{
+		catalog: c,
+		carts:   cart.New(cart.NewMemoryStore(), c),
+		orders:  orders.New(orders.NewMemoryStore(ob), c, discard),
+		listing: l,
+		now:     time.Now(),
+	}
+	f.svc = New(NewMemoryStore(ob), c, f.carts, Options{}, discard)
+	f.svc.now = func() time.Time { return f.now }
+	f.svc.Attach(f.orders)
+	return f
+}
+
+// accepted has the buyer offer $8 for one copy and the seller accept it
+func (f *fixture) accepted(t *testing.T) models.Offer {
+	t.Helper()
+	ctx := context.Background()
+	o, err := f.svc.Make(ctx, "buyer", f.listing.ListingID, 1, 800, "")
+	if err != nil {
+		t.Fatalf("making offer: %v", err)
+	}
+	if _, err := f.svc.Accept(ctx, o.OfferID, orders.Buyer("buyer")); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("buyer accepting their own offer: err = %v, want ErrNotAllowed", err)
+	}
+	if o, err = f.svc.Accept(ctx, o.OfferID, orders.Seller("seller")); err != nil {
+		t.Fatalf("accepting offer: %v", err)
+	}
+	return o
+}
+
+// view builds the buyer's saved cart
+func (f *fixture) view(t *testing.T) cart.View {
+	t.Helper()
+	ctx := context.Background()
+	c, err := f.carts.Load(ctx, "buyer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := f.carts.Build(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func (f *fixture) stock(t *testing.T) int {
+	t.Helper()
+	l, err := f.catalog.Listing(context.Background(), f.listing.ListingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l.Quantity
+}
+
+func (f *fixture) status(t *testing.T, offerID string) string {
+	t.Helper()
+	o, err := f.svc.Get(context.Background(), offerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o.Status
+}
+
+func TestMakeValidates(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+
+	cases := []struct {
+		name  string
+		buyer string
+		qty   int
+		cents int64
+		want  error
+	}{
+		{"the seller can't offer on their own listing", "seller", 1, 800, ErrNotAllowed},
+		{"more copies than are listed", "buyer", 3, 800, ErrInvalid},
+		{"the asking price", "buyer", 1, 1000, ErrInvalid},
+		{"nothing", "buyer", 1, 0, ErrInvalid},
+	}
+	for _, c := range cases {
+		if _, err := f.svc.Make(ctx, c.buyer, f.listing.ListingID, c.qty, c.cents, ""); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	if _, err := f.svc.Make(ctx, "buyer", f.listing.ListingID, 1, 800, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.svc.Make(ctx, "buyer", f.listing.ListingID, 1, 700, ""); !errors.Is(err, ErrAlreadyOpen) {
+		t.Fatalf("second open offer: err = %v, want ErrAlreadyOpen", err)
+	}
+}
+
+func TestCheckoutClaimsAcceptedOffer(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	o := f.accepted(t)
+	if got := f.stock(t); got != 1 {
+		t.Fatalf("stock after accepting = %d, want 1", got)
+	}
+
+	// the same cart checked out twice, from two tabs say, buys the offer once
+	v := f.view(t)
+	list, err := f.orders.Checkout(ctx, "buyer", v, orders.Shipment{})
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if it := list[0].Items[0]; it.OfferID != o.OfferID || it.UnitPriceCents != 800 {
+		t.Fatalf("order line = offer %q at %d, want %s at 800", it.OfferID, it.UnitPriceCents, o.OfferID)
+	}
+	if _, err := f.orders.Checkout(ctx, "buyer", v, orders.Shipment{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("second checkout: err = %v, want ErrClosed", err)
+	}
+
+	got, err := f.svc.Get(ctx, o.OfferID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != models.OfferStatusPurchased || got.OrderID != list[0].OrderID {
+		t.Fatalf("offer = %s for order %q, want purchased for %s", got.Status, got.OrderID, list[0].OrderID)
+	}
+	// the held copy was sold and nothing else was taken out of stock
+	if got := f.stock(t); got != 1 {
+		t.Fatalf("stock after checkout = %d, want 1", got)
+	}
+
+	// a purchased offer can't lapse and put its copy back
+	f.now = f.now.Add(DefaultOptions.HoldFor + time.Minute)
+	if _, err := f.svc.ExpireDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.stock(t); got != 1 {
+		t.Fatalf("stock after expiring holds = %d, want 1", got)
+	}
+}
+
+func TestExpireDueLapsesHold(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	o := f.accepted(t)
+	v := f.view(t)
+
+	if n, err := f.svc.ExpireDue(ctx); err != nil || n != 0 {
+		t.Fatalf("expiring before the hold ends = %d, %v; want 0", n, err)
+	}
+	f.now = f.now.Add(DefaultOptions.HoldFor + time.Minute)
+	n, err := f.svc.ExpireDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || f.status(t, o.OfferID) != models.OfferStatusLapsed {
+		t.Fatalf("expired %d, offer %s; want 1 lapsed", n, f.status(t, o.OfferID))
+	}
+	if got := f.stock(t); got != 2 {
+		t.Fatalf("stock after the hold lapsed = %d, want 2", got)
+	}
+
+	// a cart built before the hold lapsed can't buy the copy back at the offer price
+	if _, err := f.orders.Checkout(ctx, "buyer", v, orders.Shipment{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("checkout after lapse: err = %v, want ErrClosed", err)
+	}
+	if got := f.stock(t); got != 2 {
+		t.Fatalf("stock after the failed checkout = %d, want 2", got)
+	}
+	if _, err := f.svc.Release(ctx, o.OfferID, orders.Buyer("buyer")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("releasing a lapsed offer: err = %v, want ErrClosed", err)
+	}
+}
+
+func TestReleasePutsCopiesBack(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	o := f.accepted(t)
+
+	if _, err := f.svc.Release(ctx, o.OfferID, orders.Seller("seller")); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("seller releasing: err = %v, want ErrNotAllowed", err)
+	}
+	if _, err := f.svc.Release(ctx, o.OfferID, orders.Buyer("buyer")); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.stock(t); got != 2 {
+		t.Fatalf("stock after release = %d, want 2", got)
+	}
+}
+
+func TestExpireDueExpiresUnanswered(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	o, err := f.svc.Make(ctx, "buyer", f.listing.ListingID, 1, 800, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.now = f.now.Add(DefaultOptions.TTL + time.Minute)
+	// an answer after the deadline is refused even before the sweep runs
+	if _, err := f.svc.Accept(ctx, o.OfferID, orders.Seller("seller")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("accepting an expired offer: err = %v, want ErrClosed", err)
+	}
+	if n, err := f.svc.ExpireDue(ctx); err != nil || n != 1 {
+		t.Fatalf("expired %d, %v; want 1", n, err)
+	}
+	if got := f.status(t, o.OfferID); got != models.OfferStatusExpired {
+		t.Fatalf("offer is %s, want expired", got)
+	}
+	if got := f.stock(t); got != 2 {
+		t.Fatalf("stock = %d, want 2", got)
+	}
+}
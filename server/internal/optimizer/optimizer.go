@@ -0,0 +1,216 @@
+// Package optimizer chooses which listings to buy so a list of cards costs as little as possible
+// once every seller's shipping is added.
+//
+// Once the set of sellers to buy from is fixed, the cheapest way to fill the list is to take each
+// card from the cheapest of those sellers' copies, so the search is over seller sets. Small
+// problems are solved exactly by trying every set of the candidate sellers; larger ones, or ones
+// that run out of time, use a local search that starts from the cheapest copy of every card and
+// drops or adds one seller at a time while that lowers the total.
+package optimizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/shipping"
+)
+
+// Objectives
+const (
+	ObjectiveCost     = "cost"     // lowest total including shipping
+	ObjectivePackages = "packages" // fewest sellers, then lowest total
+)
+
+// Objectives lists the objectives a Request can ask for
+var Objectives = []string{ObjectiveCost, ObjectivePackages}
+
+// ErrInvalid is returned for a request with no cards, a bad quantity or an unknown objective
+var ErrInvalid = errors.New("optimizer: invalid request")
+
+// Need is a card the buyer wants, with how many copies
+type Need struct {
+	Key        string // the caller's reference for the need, such as a want ID
+	PrintingID string
+	Quantity   int
+	Accept     func(models.Listing) bool // optional extra filter on the listings that can fill it
+}
+
+// Request describes what to buy and how to judge plans
+type Request struct {
+	BuyerID      string
+	Country      string
+	Objective    string
+	MinCondition string // worst condition allowed for any card, "" for any
+	Needs        []Need
+}
+
+// Pick is some copies bought from one listing towards a need
+type Pick struct {
+	Need     Need
+	Listing  models.Listing
+	Quantity int
+}
+
+// Package is what one seller would ship for a plan
+type Package struct {
+	SellerID      string
+	Picks         []Pick
+	Items         int
+	ItemsCents    int64
+	ShippingCents int64
+	Method        string // name of the cheapest shipping method for the package
+}
+
+// Shortfall is a need the plan can't fully fill
+type Shortfall struct {
+	Need    Need
+	Missing int
+}
+
+// Plan is the best way the optimizer found to buy a request
+type Plan struct {
+	Country       string
+	Objective     string
+	Packages      []Package
+	Short         []Shortfall
+	ItemsCents    int64
+	ShippingCents int64
+	TotalCents    int64
+	Exact         bool          // every seller set was tried, so no cheaper plan exists
+	Elapsed       time.Duration // how long the search took
+}
+
+// Picks returns every pick in the plan
+func (p Plan) Picks() []Pick {
+	var out []Pick
+	for _, pkg := range p.Packages {
+		out = append(out, pkg.Picks...)
+	}
+	return out
+}
+
+// Options controls how long and how exhaustively the optimizer searches
+type Options struct {
+	Budget       time.Duration // time allowed for one request
+	ExactSellers int           // most candidate sellers solved by trying every set of them
+	MaxCards     int           // most copies allowed in one request
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	Budget:       2 * time.Second,
+	ExactSellers: 12,
+	MaxCards:     500,
+}
+
+// Optimizer plans purchases against the catalog and seller shipping profiles.
+type Optimizer struct {
+	catalog  *catalog.Catalog
+	shipping *shipping.Service
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+}
+
+// New creates an Optimizer
+func New(c *catalog.Catalog, ship *shipping.Service, opts Options, errorLog *log.Logger) *Optimizer {
+	if opts.Budget <= 0 {
+		opts.Budget = DefaultOptions.Budget
+	}
+	if opts.ExactSellers <= 0 {
+		opts.ExactSellers = DefaultOptions.ExactSellers
+	}
+	if opts.MaxCards <= 0 {
+		opts.MaxCards = DefaultOptions.MaxCards
+	}
+	return &Optimizer{catalog: c, shipping: ship, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// Solve finds the cheapest plan for a request within the time budget. Cards nobody is selling
+// within the request's limits are reported in Plan.Short rather than failing the request.
+func (o *Optimizer) Solve(ctx context.Context, req Request) (Plan, error) {
+	start := o.now()
+	if req.Objective == "" {
+		req.Objective = ObjectiveCost
+	}
+	if err := o.validate(req); err != nil {
+		return Plan{}, err
+	}
+
+	s := &search{ctx: ctx, o: o, req: req, deadline: start.Add(o.opts.Budget), rates: map[string]rate{}}
+	if err := s.candidates(); err != nil {
+		return Plan{}, err
+	}
+
+	all := make([]bool, len(s.sellers))
+	for i := range all {
+		all[i] = true
+	}
+	full, err := s.evaluate(all)
+	if err != nil {
+		return Plan{}, err
+	}
+	s.target = full.filled
+
+	best, err := s.improve(full)
+	if err != nil {
+		return Plan{}, err
+	}
+	exact := false
+	if len(s.sellers) <= o.opts.ExactSellers {
+		found, done, err := s.exhaust(best)
+		if err != nil {
+			return Plan{}, err
+		}
+		best, exact = found, done
+		if !done {
+			o.errorLog.Printf("optimizer: trying every set of %d sellers ran past %s, using the local search plan", len(s.sellers), o.opts.Budget)
+		}
+	}
+
+	plan := s.plan(best)
+	plan.Exact = exact
+	plan.Elapsed = o.now().Sub(start)
+	return plan, nil
+}
+
+// validate checks a request's objective and quantities
+func (o *Optimizer) validate(req Request) error {
+	known := false
+	for _, obj := range Objectives {
+		known = known || req.Objective == obj
+	}
+	if !known {
+		return fmt.Errorf("%w: unknown objective %q", ErrInvalid, req.Objective)
+	}
+	if req.MinCondition != "" && models.ConditionRank(req.MinCondition) == len(models.Conditions) {
+		return fmt.Errorf("%w: unknown condition %q", ErrInvalid, req.MinCondition)
+	}
+	if len(req.Needs) == 0 {
+		return fmt.Errorf("%w: no cards to buy", ErrInvalid)
+	}
+	cards := 0
+	for _, n := range req.Needs {
+		if n.Quantity < 1 {
+			return fmt.Errorf("%w: quantity must be at least 1", ErrInvalid)
+		}
+		cards += n.Quantity
+	}
+	if cards > o.opts.MaxCards {
+		return fmt.Errorf("%w: at most %d cards at a time", ErrInvalid, o.opts.MaxCards)
+	}
+	return nil
+}
+
+// sortPackages orders packages by what they cost, most expensive first
+func sortPackages(ps []Package) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].ItemsCents+ps[i].ShippingCents > ps[j].ItemsCents+ps[j].ShippingCents
+	})
+}
@@ -0,0 +1,283 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// rate is a memoized shipping quote; ok is false when the seller can't ship the package
+type rate struct {
+	cents  int64
+	method string
+	ok     bool
+}
+
+// take is some copies of one candidate listing
+type take struct {
+	cand int
+	qty  int
+}
+
+// result is the plan for one seller set
+type result struct {
+	takes    [][]take // per need
+	sellers  []bool   // sellers that ship something
+	filled   int      // copies filled across every need
+	packages int
+	total    int64
+	ok       bool // every package can be shipped and nothing fillable was left out
+}
+
+// search holds the candidates and quote cache for one Solve call
+type search struct {
+	ctx      context.Context
+	o        *Optimizer
+	req      Request
+	deadline time.Time
+	cands    [][]models.Listing // per need, cheapest first
+	sellers  []string
+	index    map[string]int
+	rates    map[string]rate
+	target   int // copies the full seller set can fill; -1 until known
+}
+
+// expired reports whether the time budget has run out
+func (s *search) expired() bool {
+	return !s.o.now().Before(s.deadline)
+}
+
+// candidates loads, for each need, the active listings that may fill it, cheapest first, and
+// collects the sellers that can ship to the request's country
+func (s *search) candidates() error {
+	s.index = map[string]int{}
+	s.target = -1
+	byPrinting := map[string][]models.Listing{}
+	for _, n := range s.req.Needs {
+		listings, ok := byPrinting[n.PrintingID]
+		if !ok {
+			var err error
+			listings, err = s.o.catalog.ListingsByPrinting(s.ctx, n.PrintingID)
+			if err != nil {
+				return err
+			}
+			sort.SliceStable(listings, func(i, j int) bool { return listings[i].PriceCents < listings[j].PriceCents })
+			byPrinting[n.PrintingID] = listings
+		}
+
+		var cands []models.Listing
+		for _, l := range listings {
+			if !s.accepts(n, l) {
+				continue
+			}
+			if _, known := s.index[l.SellerID]; !known {
+				r, err := s.quote(l.SellerID, 1, l.PriceCents)
+				if err != nil {
+					return err
+				}
+				if !r.ok {
+					continue
+				}
+				s.index[l.SellerID] = len(s.sellers)
+				s.sellers = append(s.sellers, l.SellerID)
+			}
+			cands = append(cands, l)
+		}
+		s.cands = append(s.cands, cands)
+	}
+	return nil
+}
+
+// accepts reports whether a listing may fill a need
+func (s *search) accepts(n Need, l models.Listing) bool {
+	if !l.IsActive() || l.SellerID == s.req.BuyerID {
+		return false
+	}
+	if s.req.MinCondition != "" && models.ConditionRank(l.Condition) > models.ConditionRank(s.req.MinCondition) {
+		return false
+	}
+	return n.Accept == nil || n.Accept(l)
+}
+
+// evaluate fills every need from the cheapest copies the allowed sellers have and prices the
+// packages that result
+func (s *search) evaluate(allowed []bool) (result, error) {
+	res := result{takes: make([][]take, len(s.req.Needs)), sellers: make([]bool, len(s.sellers))}
+	used := map[string]int{}
+	items := make([]int, len(s.sellers))
+	subtotal := make([]int64, len(s.sellers))
+
+	for n, need := range s.req.Needs {
+		left := need.Quantity
+		for c, l := range s.cands[n] {
+			if left == 0 {
+				break
+			}
+			seller := s.index[l.SellerID]
+			if !allowed[seller] {
+				continue
+			}
+			qty := l.Quantity - used[l.ListingID]
+			if qty > left {
+				qty = left
+			}
+			if qty <= 0 {
+				continue
+			}
+			used[l.ListingID] += qty
+			left -= qty
+			res.takes[n] = append(res.takes[n], take{cand: c, qty: qty})
+			res.sellers[seller] = true
+			items[seller] += qty
+			subtotal[seller] += l.PriceCents * int64(qty)
+		}
+		res.filled += need.Quantity - left
+	}
+
+	res.ok = s.target < 0 || res.filled == s.target
+	for i, ships := range res.sellers {
+		if !ships {
+			continue
+		}
+		r, err := s.quote(s.sellers[i], items[i], subtotal[i])
+		if err != nil {
+			return result{}, err
+		}
+		res.ok = res.ok && r.ok
+		res.packages++
+		res.total += subtotal[i] + r.cents
+	}
+	return res, nil
+}
+
+// better reports whether a beats b under the request's objective
+func (s *search) better(a, b result) bool {
+	if !a.ok {
+		return false
+	}
+	if !b.ok {
+		return true
+	}
+	if s.req.Objective == ObjectivePackages && a.packages != b.packages {
+		return a.packages < b.packages
+	}
+	return a.total < b.total
+}
+
+// improve runs the local search from cur: it repeatedly drops a seller from, or adds one to,
+// the set of sellers shipping something, keeping the best change, until nothing helps or time
+// runs out
+func (s *search) improve(cur result) (result, error) {
+	for !s.expired() {
+		var next result
+		found := false
+		for i := range s.sellers {
+			allowed := append([]bool(nil), cur.sellers...)
+			allowed[i] = !allowed[i]
+			res, err := s.evaluate(allowed)
+			if err != nil {
+				return result{}, err
+			}
+			if s.better(res, cur) && (!found || s.better(res, next)) {
+				next, found = res, true
+			}
+		}
+		if !found {
+			break
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// exhaust tries every set of candidate sellers, starting from best. It reports false if the time
+// budget ran out before every set was tried.
+func (s *search) exhaust(best result) (result, bool, error) {
+	allowed := make([]bool, len(s.sellers))
+	var walk func(i int) (bool, error)
+	walk = func(i int) (bool, error) {
+		if i == len(allowed) {
+			if s.expired() {
+				return false, nil
+			}
+			res, err := s.evaluate(allowed)
+			if err != nil {
+				return false, err
+			}
+			if s.better(res, best) {
+				best = res
+			}
+			return true, nil
+		}
+		for _, in := range []bool{true, false} {
+			allowed[i] = in
+			done, err := walk(i + 1)
+			if err != nil || !done {
+				return done, err
+			}
+		}
+		return true, nil
+	}
+	done, err := walk(0)
+	return best, done, err
+}
+
+// quote returns the cheapest shipping for a package from a seller, memoized
+func (s *search) quote(sellerID string, items int, subtotalCents int64) (rate, error) {
+	key := fmt.Sprintf("%s/%d/%d", sellerID, items, subtotalCents)
+	if r, ok := s.rates[key]; ok {
+		return r, nil
+	}
+	rates, err := s.o.shipping.Rates(s.ctx, sellerID, items, subtotalCents, s.req.Country)
+	if err != nil {
+		return rate{}, err
+	}
+	var r rate
+	if len(rates) > 0 {
+		r = rate{cents: rates[0].CostCents, method: rates[0].Method.Name, ok: true}
+	}
+	s.rates[key] = r
+	return r, nil
+}
+
+// plan turns a result into per-seller packages and shortfalls
+func (s *search) plan(res result) Plan {
+	plan := Plan{Country: s.req.Country, Objective: s.req.Objective}
+	bySeller := map[string]*Package{}
+	var order []string
+	for n, takes := range res.takes {
+		need := s.req.Needs[n]
+		filled := 0
+		for _, t := range takes {
+			l := s.cands[n][t.cand]
+			pkg, ok := bySeller[l.SellerID]
+			if !ok {
+				pkg = &Package{SellerID: l.SellerID}
+				bySeller[l.SellerID] = pkg
+				order = append(order, l.SellerID)
+			}
+			pkg.Picks = append(pkg.Picks, Pick{Need: need, Listing: l, Quantity: t.qty})
+			pkg.Items += t.qty
+			pkg.ItemsCents += l.PriceCents * int64(t.qty)
+			filled += t.qty
+		}
+		if filled < need.Quantity {
+			plan.Short = append(plan.Short, Shortfall{Need: need, Missing: need.Quantity - filled})
+		}
+	}
+
+	for _, seller := range order {
+		pkg := bySeller[seller]
+		r := s.rates[fmt.Sprintf("%s/%d/%d", seller, pkg.Items, pkg.ItemsCents)]
+		pkg.ShippingCents, pkg.Method = r.cents, r.method
+		plan.Packages = append(plan.Packages, *pkg)
+		plan.ItemsCents += pkg.ItemsCents
+		plan.ShippingCents += pkg.ShippingCents
+	}
+	sortPackages(plan.Packages)
+	plan.TotalCents = plan.ItemsCents + plan.ShippingCents
+	return plan
+}
@@ -0,0 +1,121 @@
+package orders
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// checkoutRef records that an order came from a checkout, so a checkout's orders can be found
+type checkoutRef struct {
+	PK         string `dynamodbav:"PK"`
+	SK         string `dynamodbav:"SK"`
+	Type       string `dynamodbav:"Type"`
+	CheckoutID string `dynamodbav:"checkoutID"`
+	OrderID    string `dynamodbav:"orderID"`
+}
+
+// DynamoStore is a Store over the marketplace table. An order's audit events share its
+// partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Create stores a batch of orders, their checkout references and events in one transaction
+func (s *DynamoStore) Create(ctx context.Context, orders []models.Order, events []models.OrderEvent, out []models.DomainEvent) error {
+	var ops []dynamo.Op
+	for _, o := range orders {
+		ref := checkoutRef{CheckoutID: o.CheckoutID, OrderID: o.OrderID, Type: models.ItemTypeCheckoutRef}
+		ref.PK, ref.SK = models.CheckoutOrderKey(o.CheckoutID, o.OrderID)
+		ops = append(ops, dynamo.PutOp(o, dynamo.IfNotExists()), dynamo.PutOp(ref, dynamo.Condition{}))
+	}
+	for _, ev := range events {
+		ops = append(ops, dynamo.PutOp(ev, dynamo.Condition{}))
+	}
+	return s.write(ctx, append(ops, outbox.PutOps(out)...))
+}
+
+// Get returns an order by ID
+func (s *DynamoStore) Get(ctx context.Context, orderID string) (models.Order, error) {
+	var o models.Order
+	pk, sk := models.OrderKey(orderID)
+	err := s.table.Get(ctx, pk, sk, &o)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return o, ErrNotFound
+	}
+	return o, err
+}
+
+// Update stores an order if its version still matches, together with its events
+func (s *DynamoStore) Update(ctx context.Context, o models.Order, expectedVersion int64, ev models.OrderEvent, out []models.DomainEvent) error {
+	ops := []dynamo.Op{dynamo.PutOp(o, dynamo.IfVersion(expectedVersion)), dynamo.PutOp(ev, dynamo.Condition{})}
+	return s.write(ctx, append(ops, outbox.PutOps(out)...))
+}
+
+// ByBuyer returns a buyer's orders, newest first
+func (s *DynamoStore) ByBuyer(ctx context.Context, buyerID string) ([]models.Order, error) {
+	pk, _ := models.BuyerOrderKey(buyerID, "", "")
+	return s.query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, SKPrefix: "ORDER#", Descending: true})
+}
+
+// BySeller returns a seller's orders, newest first
+func (s *DynamoStore) BySeller(ctx context.Context, sellerID string) ([]models.Order, error) {
+	pk, _ := models.SellerOrderKey(sellerID, "", "")
+	return s.query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk, SKPrefix: "ORDER#", Descending: true})
+}
+
+// ByCheckout returns the orders from one checkout
+func (s *DynamoStore) ByCheckout(ctx context.Context, checkoutID string) ([]models.Order, error) {
+	var refs []checkoutRef
+	pk, _ := models.CheckoutOrderKey(checkoutID, "")
+	if err := s.table.Query(ctx, dynamo.Query{PK: pk}, &refs); err != nil {
+		return nil, err
+	}
+	out := make([]models.Order, 0, len(refs))
+	for _, ref := range refs {
+		o, err := s.Get(ctx, ref.OrderID)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, nil
+}
+
+// ByStatus returns the orders in a status, newest first
+func (s *DynamoStore) ByStatus(ctx context.Context, status string) ([]models.Order, error) {
+	pk, _ := models.OrderStatusKey(status, "", "")
+	return s.query(ctx, dynamo.Query{Index: dynamo.GSI3, PK: pk, Descending: true})
+}
+
+// Events returns an order's audit events, oldest first
+func (s *DynamoStore) Events(ctx context.Context, orderID string) ([]models.OrderEvent, error) {
+	var out []models.OrderEvent
+	pk, _ := models.OrderKey(orderID)
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "EVENT#"}, &out)
+	return out, err
+}
+
+// query returns the orders matching q
+func (s *DynamoStore) query(ctx context.Context, q dynamo.Query) ([]models.Order, error) {
+	var out []models.Order
+	err := s.table.Query(ctx, q, &out)
+	return out, err
+}
+
+// write applies ops in one transaction, mapping a failed condition to ErrConflict
+func (s *DynamoStore) write(ctx context.Context, ops []dynamo.Op) error {
+	err := s.table.Write(ctx, ops...)
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package orders
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	orders map[string]models.Order
+	events map[string][]models.OrderEvent
+	outbox *outbox.MemoryStore
+}
+
+// NewMemoryStore creates an empty MemoryStore that writes domain events to ob, or drops them when
+// ob is nil
+func NewMemoryStore(ob *outbox.MemoryStore) *MemoryStore {
+	return &MemoryStore{
+		orders: map[string]models.Order{},
+		events: map[string][]models.OrderEvent{},
+		outbox: ob,
+	}
+}
+
+// Create stores a batch of orders and events atomically
+func (s *MemoryStore) Create(ctx context.Context, orders []models.Order, events []models.OrderEvent, out []models.DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range orders {
+		if _, exists := s.orders[o.OrderID]; exists {
+			return ErrConflict
+		}
+	}
+	for _, o := range orders {
+		s.orders[o.OrderID] = o
+	}
+	for _, ev := range events {
+		s.events[ev.OrderID] = append(s.events[ev.OrderID], ev)
+	}
+	s.outbox.Append(out...)
+	return nil
+}
+
+// Get returns an order by ID
+func (s *MemoryStore) Get(ctx context.Context, orderID string) (models.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.orders[orderID]
+	if !ok {
+		return models.Order{}, ErrNotFound
+	}
+	return o, nil
+}
+
+// Update stores an order if its version still matches, and appends the events
+func (s *MemoryStore) Update(ctx context.Context, o models.Order, expectedVersion int64, ev models.OrderEvent, out []models.DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.orders[o.OrderID]
+	if !ok {
+		return ErrNotFound
+	}
+	if existing.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.orders[o.OrderID] = o
+	s.events[o.OrderID] = append(s.events[o.OrderID], ev)
+	s.outbox.Append(out...)
+	return nil
+}
+
+// ByBuyer returns a buyer's orders, newest first
+func (s *MemoryStore) ByBuyer(ctx context.Context, buyerID string) ([]models.Order, error) {
+	return s.filter(func(o models.Order) bool { return o.BuyerID == buyerID }), nil
+}
+
+// BySeller returns a seller's orders, newest first
+func (s *MemoryStore) BySeller(ctx context.Context, sellerID string) ([]models.Order, error) {
+	return s.filter(func(o models.Order) bool { return o.SellerID == sellerID }), nil
+}
+
+// ByCheckout returns the orders from one checkout
+func (s *MemoryStore) ByCheckout(ctx context.Context, checkoutID string) ([]models.Order, error) {
+	return s.filter(func(o models.Order) bool { return o.CheckoutID == checkoutID }), nil
+}
+
+// ByStatus returns the orders in a status
+func (s *MemoryStore) ByStatus(ctx context.Context, status string) ([]models.Order, error) {
+	return s.filter(func(o models.Order) bool { return o.Status == status }), nil
+}
+
+// Events returns an order's audit events, oldest first
+func (s *MemoryStore) Events(ctx context.Context, orderID string) ([]models.OrderEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.OrderEvent(nil), s.events[orderID]...), nil
+}
+
+// filter returns matching orders, newest first
+func (s *MemoryStore) filter(keep func(models.Order) bool) []models.Order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Order
+	for _, o := range s.orders {
+		if keep(o) {
+			out = append(out, o)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt != out[j].CreatedAt {
+			return out[i].CreatedAt > out[j].CreatedAt
+		}
+		return out[i].OrderID < out[j].OrderID
+	})
+	return out
+}
@@ -0,0 +1,583 @@
+// Package orders turns carts into per-seller orders and drives the order lifecycle state machine.
+package orders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+var (
+	// ErrNotFound is returned when an order does not exist
+	ErrNotFound = errors.New("orders: not found")
+	// ErrConflict is returned when an order was changed concurrently
+	ErrConflict = errors.New("orders: version conflict")
+	// ErrInvalidTransition is returned when the requested status cannot follow the current one
+	ErrInvalidTransition = errors.New("orders: invalid status transition")
+	// ErrNotAllowed is returned when the actor may not perform the transition
+	ErrNotAllowed = errors.New("orders: not allowed")
+	// ErrCartInvalid is returned when checking out an empty cart or one with unresolved issues
+	ErrCartInvalid = errors.New("orders: cart has changed or is empty")
+	// ErrLabelInProgress is returned when a label is already being bought for an order
+	ErrLabelInProgress = errors.New("orders: a label is already being bought for this order")
+)
+
+// LabelTimeout is how long a label purchase may take before another attempt may start in its
+// place, when the first has presumably died
+const LabelTimeout = 10 * time.Minute
+
+// Actor kinds
+const (
+	ActorBuyer  = "buyer"
+	ActorSeller = "seller"
+	ActorAdmin  = "admin"
+	ActorSystem = "system"
+)
+
+// Actor identifies who is changing an order
+type Actor struct {
+	Kind string
+	ID   string
+}
+
+// System is the actor used for webhook- and job-driven transitions
+var System = Actor{Kind: ActorSystem}
+
+// Buyer returns a buyer actor
+func Buyer(userID string) Actor { return Actor{Kind: ActorBuyer, ID: userID} }
+
+// Seller returns a seller actor
+func Seller(userID string) Actor { return Actor{Kind: ActorSeller, ID: userID} }
+
+// Admin returns an admin actor
+func Admin(userID string) Actor { return Actor{Kind: ActorAdmin, ID: userID} }
+
+// String formats the actor the way the audit log records it
+func (a Actor) String() string {
+	switch a.Kind {
+	case ActorSystem:
+		return "system"
+	case ActorAdmin:
+		return "admin:" + a.ID
+	default:
+		return "user:" + a.ID
+	}
+}
+
+// transitions lists, for each status, the statuses it may move to and which actors may move it there
+var transitions = map[string]map[string][]string{
+	models.OrderStatusPendingPayment: {
+		models.OrderStatusPaid:      {ActorSystem},
+		models.OrderStatusCancelled: {ActorBuyer, ActorSeller, ActorAdmin, ActorSystem},
+	},
+	models.OrderStatusPaid: {
+		models.OrderStatusShipped:   {ActorSeller, ActorAdmin},
+		models.OrderStatusCancelled: {ActorSeller, ActorAdmin, ActorSystem},
+		models.OrderStatusRefunded:  {ActorAdmin, ActorSystem},
+	},
+	models.OrderStatusShipped: {
+		models.OrderStatusDelivered: {ActorBuyer, ActorAdmin, ActorSystem},
+		models.OrderStatusRefunded:  {ActorAdmin, ActorSystem},
+	},
+	models.OrderStatusDelivered: {
+		models.OrderStatusCompleted: {ActorBuyer, ActorAdmin, ActorSystem},
+		models.OrderStatusRefunded:  {ActorAdmin, ActorSystem},
+	},
+	models.OrderStatusCompleted: {
+		models.OrderStatusRefunded: {ActorAdmin, ActorSystem},
+	},
+}
+
+// CanTransition reports whether an order may move from one status to another by the given actor kind
+func CanTransition(from, to, actorKind string) bool {
+	for _, k := range transitions[from][to] {
+		if k == actorKind {
+			return true
+		}
+	}
+	return false
+}
+
+// statusEvents names the domain event for an order reaching each status
+var statusEvents = map[string]string{
+	models.OrderStatusPaid:      models.EventOrderPaid,
+	models.OrderStatusShipped:   models.EventOrderShipped,
+	models.OrderStatusDelivered: models.EventOrderDelivered,
+	models.OrderStatusCompleted: models.EventOrderCompleted,
+	models.OrderStatusCancelled: models.EventOrderCancelled,
+	models.OrderStatusRefunded:  models.EventOrderRefunded,
+}
+
+// Change is the payload of every order domain event: the order after the change and its audit event
+type Change struct {
+	Order models.Order
+	Event models.OrderEvent
+}
+
+// Store persists orders and their audit events. Domain events for the outbox are written in the
+// same transaction as the orders they describe.
+type Store interface {
+	// Create writes a batch of orders, their audit events and domain events in one all-or-nothing
+	// transaction
+	Create(ctx context.Context, orders []models.Order, events []models.OrderEvent, out []models.DomainEvent) error
+	Get(ctx context.Context, orderID string) (models.Order, error)
+	// Update writes an order conditional on expectedVersion, together with its audit event and
+	// domain events
+	Update(ctx context.Context, o models.Order, expectedVersion int64, ev models.OrderEvent, out []models.DomainEvent) error
+	ByBuyer(ctx context.Context, buyerID string) ([]models.Order, error)
+	BySeller(ctx context.Context, sellerID string) ([]models.Order, error)
+	ByCheckout(ctx context.Context, checkoutID string) ([]models.Order, error)
+	ByStatus(ctx context.Context, status string) ([]models.Order, error)
+	Events(ctx context.Context, orderID string) ([]models.OrderEvent, error)
+}
+
+// TransitionListener is notified after an order changes status
+type TransitionListener func(ctx context.Context, o models.Order, ev models.OrderEvent)
+
+// CreateHook fills in details of a new order, such as fees, before it is written. Returning an
+// error aborts the checkout.
+type CreateHook func(ctx context.Context, o *models.Order) error
+
+// ClaimHook takes something a new order's lines were promised, such as an accepted offer, for the
+// order just before it is written. The claim must be a conditional write so two checkouts can't
+// both take the same thing. undo gives it back if the checkout fails afterwards.
+type ClaimHook func(ctx context.Context, o models.Order) (undo func(context.Context), err error)
+
+// Service creates orders and applies validated, audited status transitions.
+type Service struct {
+	store    Store
+	catalog  *catalog.Catalog
+	errorLog *log.Logger
+
+	mu          sync.RWMutex
+	listeners   []TransitionListener
+	createHooks []CreateHook
+	claimHooks  []ClaimHook
+}
+
+// New creates an order Service
+func New(store Store, c *catalog.Catalog, errorLog *log.Logger) *Service {
+	return &Service{store: store, catalog: c, errorLog: errorLog}
+}
+
+// OnTransition registers a listener for status changes, including order creation
+func (s *Service) OnTransition(fn TransitionListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// BeforeCreate registers a hook run on every order checkout creates, before it is written
+func (s *Service) BeforeCreate(fn CreateHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createHooks = append(s.createHooks, fn)
+}
+
+// BeforeWrite registers a hook that claims what every order checkout creates was promised, once
+// the orders are complete and just before they are written
+func (s *Service) BeforeWrite(fn ClaimHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claimHooks = append(s.claimHooks, fn)
+}
+
+// Get returns an order
+func (s *Service) Get(ctx context.Context, orderID string) (models.Order, error) {
+	return s.store.Get(ctx, orderID)
+}
+
+// ForBuyer returns a buyer's orders, newest first
+func (s *Service) ForBuyer(ctx context.Context, buyerID string) ([]models.Order, error) {
+	return s.store.ByBuyer(ctx, buyerID)
+}
+
+// ForSeller returns a seller's orders, newest first
+func (s *Service) ForSeller(ctx context.Context, sellerID string) ([]models.Order, error) {
+	return s.store.BySeller(ctx, sellerID)
+}
+
+// ForCheckout returns the orders created by one checkout
+func (s *Service) ForCheckout(ctx context.Context, checkoutID string) ([]models.Order, error) {
+	return s.store.ByCheckout(ctx, checkoutID)
+}
+
+// WithStatus returns every order currently in a status
+func (s *Service) WithStatus(ctx context.Context, status string) ([]models.Order, error) {
+	return s.store.ByStatus(ctx, status)
+}
+
+// Events returns an order's audit trail, oldest first
+func (s *Service) Events(ctx context.Context, orderID string) ([]models.OrderEvent, error) {
+	return s.store.Events(ctx, orderID)
+}
+
+// Shipment is where a checkout ships to and the shipping method chosen for each seller
+type Shipment struct {
+	Address models.Address
+	Methods map[string]string // seller ID -> shipping method ID
+}
+
+// Checkout turns a validated cart view into one pending_payment order per seller. Inventory for
+// every line is reserved in a single conditional write before the orders are created, so two
+// buyers racing for the last copy cannot both succeed. Lines held for an accepted offer or a won
+// auction were taken out of stock earlier and are not reserved again; claim hooks take the offer
+// for the order instead, so it can be checked out only once.
+func (s *Service) Checkout(ctx context.Context, buyerID string, view cart.View, ship Shipment) ([]models.Order, error) {
+	if !view.Valid() {
+		return nil, ErrCartInvalid
+	}
+
+	quantities := map[string]int{}
+	for _, g := range view.Groups {
+		for _, line := range g.Lines {
+			if !line.Item.Reserved() {
+				quantities[line.Item.ListingID] += line.Item.Quantity
+			}
+		}
+	}
+
+	if _, err := s.catalog.Reserve(ctx, quantities); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	checkoutID := ids.New()
+
+	var (
+		created []models.Order
+		events  []models.OrderEvent
+		out     []models.DomainEvent
+	)
+	for _, g := range view.Groups {
+		o := models.Order{
+			OrderID:    ids.New(),
+			CheckoutID: checkoutID,
+			BuyerID:    buyerID,
+			SellerID:   g.SellerID,
+			Status:     models.OrderStatusPendingPayment,
+			ShipTo:     ship.Address,
+			Version:    1,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		for _, line := range g.Lines {
+			o.Items = append(o.Items, models.OrderItem{
+				ListingID:      line.Item.ListingID,
+				PrintingID:     line.Printing.PrintingID,
+				ProductID:      line.Product.ProductID,
+				Game:           line.Game(),
+				Category:       line.Listing.Category(),
+				CardName:       line.Name(),
+				SetName:        line.SetName(),
+				Condition:      line.Listing.Condition,
+				Language:       line.Listing.Language,
+				Foil:           line.Listing.Foil,
+				Quantity:       line.Item.Quantity,
+				UnitPriceCents: line.Item.PriceCents,
+				OfferID:        line.Item.OfferID,
+				AuctionID:      line.Item.AuctionID,
+			})
+			o.SubtotalCents += line.LineTotalCents
+		}
+		o.ShippingMethod = ship.Methods[g.SellerID]
+		setKeys(&o)
+
+		// hooks price shipping and fees, so the total is only known afterwards
+		if err := s.runCreateHooks(ctx, &o); err != nil {
+			return nil, s.abortCheckout(ctx, quantities, err)
+		}
+		o.TotalCents = o.SubtotalCents + o.ShippingCents
+
+		ev := newEvent(o.OrderID, "", o.Status, Buyer(buyerID), "checkout", now, int(o.Version))
+		domainEvent, err := outbox.NewEvent(models.EventOrderCreated, "order", o.OrderID, o.Version, now, Change{Order: o, Event: ev})
+		if err != nil {
+			return nil, s.abortCheckout(ctx, quantities, err)
+		}
+		created = append(created, o)
+		events = append(events, ev)
+		out = append(out, domainEvent)
+	}
+
+	undo, err := s.runClaimHooks(ctx, created)
+	if err != nil {
+		return nil, s.abortCheckout(ctx, quantities, err)
+	}
+	if err := s.store.Create(ctx, created, events, out); err != nil {
+		undo(ctx)
+		return nil, s.abortCheckout(ctx, quantities, err)
+	}
+
+	for i := range created {
+		s.notify(ctx, created[i], events[i])
+	}
+	return created, nil
+}
+
+// Transition moves an order to a new status after checking the state machine and the actor
+func (s *Service) Transition(ctx context.Context, orderID, to string, actor Actor, reason string) (models.Order, error) {
+	return s.transition(ctx, orderID, to, actor, reason, nil)
+}
+
+// Ship marks a paid order as shipped with an optional carrier and tracking number
+func (s *Service) Ship(ctx context.Context, orderID string, actor Actor, carrier, trackingNumber string) (models.Order, error) {
+	return s.transition(ctx, orderID, models.OrderStatusShipped, actor, "shipped", func(o *models.Order) {
+		o.Carrier = carrier
+		o.TrackingNumber = trackingNumber
+	})
+}
+
+// ShipWithLabel marks a paid order as shipped using postage bought through the marketplace
+func (s *Service) ShipWithLabel(ctx context.Context, orderID string, actor Actor, label models.ShippingLabel) (models.Order, error) {
+	return s.transition(ctx, orderID, models.OrderStatusShipped, actor, "label purchased", func(o *models.Order) {
+		o.Carrier = label.Carrier
+		o.TrackingNumber = label.TrackingNumber
+		o.Label = &label
+		o.LabelStartedAt = ""
+	})
+}
+
+// StartLabel marks that a label is being bought for a paid order, so a repeated request can't buy
+// a second one, and returns ErrLabelInProgress while another purchase is under way. When an
+// earlier attempt already bought the label, the order is returned with it unchanged, for the
+// caller to finish shipping with instead of buying another.
+func (s *Service) StartLabel(ctx context.Context, orderID string, actor Actor) (models.Order, error) {
+	o, err := s.store.Get(ctx, orderID)
+	if err != nil {
+		return o, err
+	}
+	if !CanTransition(o.Status, models.OrderStatusShipped, actor.Kind) {
+		return o, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, o.Status, models.OrderStatusShipped)
+	}
+	if actor.Kind == ActorSeller && actor.ID != o.SellerID {
+		return o, ErrNotAllowed
+	}
+	if o.Label != nil {
+		return o, nil
+	}
+	if started, err := time.Parse(time.RFC3339, o.LabelStartedAt); err == nil && time.Since(started) < LabelTimeout {
+		return o, ErrLabelInProgress
+	}
+	return s.amend(ctx, o, actor, "label purchase started", func(o *models.Order) {
+		o.LabelStartedAt = o.UpdatedAt
+	})
+}
+
+// SetLabel records the label bought for an order, before the order is marked shipped with it
+func (s *Service) SetLabel(ctx context.Context, orderID string, actor Actor, label models.ShippingLabel) (models.Order, error) {
+	return s.Amend(ctx, orderID, actor, "label bought", func(o *models.Order) {
+		o.Label = &label
+	})
+}
+
+// AbortLabel clears the mark left by StartLabel after a purchase failed without buying a label
+func (s *Service) AbortLabel(ctx context.Context, orderID string, actor Actor) (models.Order, error) {
+	return s.Amend(ctx, orderID, actor, "label purchase failed", func(o *models.Order) {
+		o.LabelStartedAt = ""
+	})
+}
+
+// Amend changes an order's details without changing its status. The change is audited like a transition.
+func (s *Service) Amend(ctx context.Context, orderID string, actor Actor, reason string, mutate func(*models.Order)) (models.Order, error) {
+	o, err := s.store.Get(ctx, orderID)
+	if err != nil {
+		return o, err
+	}
+	return s.amend(ctx, o, actor, reason, mutate)
+}
+
+// amend applies and audits a change to an order as read, failing with ErrConflict if it has
+// changed since
+func (s *Service) amend(ctx context.Context, o models.Order, actor Actor, reason string, mutate func(*models.Order)) (models.Order, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	expected := o.Version
+	o.Version++
+	o.UpdatedAt = now
+	mutate(&o)
+
+	ev := newEvent(o.OrderID, o.Status, o.Status, actor, reason, now, int(o.Version))
+	domainEvent, err := outbox.NewEvent(models.EventOrderAmended, "order", o.OrderID, o.Version, now, Change{Order: o, Event: ev})
+	if err != nil {
+		return o, err
+	}
+	if err := s.store.Update(ctx, o, expected, ev, []models.DomainEvent{domainEvent}); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// transition validates and applies a status change, optionally mutating the order in the same write
+func (s *Service) transition(ctx context.Context, orderID, to string, actor Actor, reason string, mutate func(*models.Order)) (models.Order, error) {
+	o, err := s.store.Get(ctx, orderID)
+	if err != nil {
+		return o, err
+	}
+
+	from := o.Status
+	if _, ok := transitions[from][to]; !ok {
+		return o, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+	if !CanTransition(from, to, actor.Kind) {
+		return o, fmt.Errorf("%w: %s cannot move order from %s to %s", ErrNotAllowed, actor.Kind, from, to)
+	}
+	if (actor.Kind == ActorBuyer && actor.ID != o.BuyerID) || (actor.Kind == ActorSeller && actor.ID != o.SellerID) {
+		return o, ErrNotAllowed
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	expected := o.Version
+	o.Status = to
+	o.Version++
+	o.UpdatedAt = now
+	switch to {
+	case models.OrderStatusShipped:
+		o.ShippedAt = now
+	case models.OrderStatusDelivered:
+		o.DeliveredAt = now
+	}
+	if mutate != nil {
+		mutate(&o)
+	}
+	setKeys(&o)
+
+	ev := newEvent(o.OrderID, from, to, actor, reason, now, int(o.Version))
+	domainEvent, err := outbox.NewEvent(statusEvents[to], "order", o.OrderID, o.Version, now, Change{Order: o, Event: ev})
+	if err != nil {
+		return o, err
+	}
+	if err := s.store.Update(ctx, o, expected, ev, []models.DomainEvent{domainEvent}); err != nil {
+		return o, err
+	}
+
+	// stock goes back on sale when an order is called off before anything shipped. The transition
+	// has already happened, so a failure here is logged rather than returned and listeners still
+	// hear about it; the copies stay off sale until the stock is corrected.
+	if to == models.OrderStatusCancelled || (to == models.OrderStatusRefunded && from == models.OrderStatusPaid) {
+		quantities := map[string]int{}
+		for _, it := range o.Items {
+			quantities[it.ListingID] += it.Quantity
+		}
+		if _, err := s.catalog.Release(ctx, quantities); err != nil {
+			s.errorLog.Printf("order %s %s but releasing stock %v failed: %v", o.OrderID, to, quantities, err)
+		}
+	}
+
+	s.notify(ctx, o, ev)
+	return o, nil
+}
+
+// ExpireUnpaid cancels orders still waiting for payment after maxAge, putting their stock back on
+// sale, and returns how many it cancelled
+func (s *Service) ExpireUnpaid(ctx context.Context, maxAge time.Duration) (int, error) {
+	list, err := s.store.ByStatus(ctx, models.OrderStatusPendingPayment)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().UTC().Add(-maxAge).Format(time.RFC3339)
+	cancelled := 0
+	for _, o := range list {
+		if o.CreatedAt > cutoff {
+			continue
+		}
+		_, err := s.Transition(ctx, o.OrderID, models.OrderStatusCancelled, System, "payment not received in time")
+		switch {
+		case errors.Is(err, ErrConflict), errors.Is(err, ErrInvalidTransition):
+			// paid or cancelled in the meantime
+		case err != nil:
+			return cancelled, err
+		default:
+			cancelled++
+		}
+	}
+	return cancelled, nil
+}
+
+// abortCheckout puts reserved stock back after a checkout fails part way
+func (s *Service) abortCheckout(ctx context.Context, quantities map[string]int, err error) error {
+	if _, rerr := s.catalog.Release(ctx, quantities); rerr != nil {
+		return fmt.Errorf("%w (and releasing reserved stock failed: %v)", err, rerr)
+	}
+	return err
+}
+
+// runCreateHooks applies every create hook to a new order
+func (s *Service) runCreateHooks(ctx context.Context, o *models.Order) error {
+	s.mu.RLock()
+	hooks := s.createHooks
+	s.mu.RUnlock()
+	for _, fn := range hooks {
+		if err := fn(ctx, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runClaimHooks runs every claim hook on each new order. If one fails, the claims already made
+// are undone; otherwise the returned func undoes them all.
+func (s *Service) runClaimHooks(ctx context.Context, created []models.Order) (func(context.Context), error) {
+	s.mu.RLock()
+	hooks := s.claimHooks
+	s.mu.RUnlock()
+
+	var undos []func(context.Context)
+	undo := func(ctx context.Context) {
+		for i := len(undos) - 1; i >= 0; i-- {
+			undos[i](ctx)
+		}
+	}
+	for _, o := range created {
+		for _, fn := range hooks {
+			u, err := fn(ctx, o)
+			if err != nil {
+				undo(ctx)
+				return nil, err
+			}
+			undos = append(undos, u)
+		}
+	}
+	return undo, nil
+}
+
+// notify fans a transition out to every registered listener
+func (s *Service) notify(ctx context.Context, o models.Order, ev models.OrderEvent) {
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(ctx, o, ev)
+	}
+}
+
+// setKeys fills in the single-table keys for an order
+func setKeys(o *models.Order) {
+	o.PK, o.SK = models.OrderKey(o.OrderID)
+	o.GSI1PK, o.GSI1SK = models.BuyerOrderKey(o.BuyerID, o.CreatedAt, o.OrderID)
+	o.GSI2PK, o.GSI2SK = models.SellerOrderKey(o.SellerID, o.CreatedAt, o.OrderID)
+	o.GSI3PK, o.GSI3SK = models.OrderStatusKey(o.Status, o.CreatedAt, o.OrderID)
+	o.Type = models.ItemTypeOrder
+}
+
+// newEvent builds an audit event for a transition
+func newEvent(orderID, from, to string, actor Actor, reason, at string, seq int) models.OrderEvent {
+	ev := models.OrderEvent{
+		OrderID: orderID,
+		From:    from,
+		To:      to,
+		Actor:   actor.String(),
+		Reason:  reason,
+		At:      at,
+		Type:    models.ItemTypeOrderEvent,
+	}
+	ev.PK, ev.SK = models.OrderEventKey(orderID, at, seq)
+	return ev
+}
@@ -0,0 +1,268 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// fixture is an orders Service over in-memory stores with one listing of two copies
+type fixture struct {
+	svc     *Service
+	catalog *catalog.Catalog
+	carts   *cart.Service
+	listing models.Listing
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	ctx := context.Background()
+
+	c := catalog.New(catalog.NewMemoryStore(nil))
+	p, err := c.SavePrinting(ctx, models.Printing{Game: "mtg", SetCode: "M10", SetName: "Magic 2010", CardName: "Lightning Bolt", Rarity: "common"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.SaveListing(ctx, models.Listing{SellerID: "seller", PrintingID: p.PrintingID, Condition: "NM", Language: "en", PriceCents: 150, Quantity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fixture{
+		svc:     New(NewMemoryStore(nil), c, log.New(io.Discard, "", 0)),
+		catalog: c,
+		carts:   cart.New(cart.NewMemoryStore(), c),
+		listing: l,
+	}
+}
+
+// view builds a buyer's cart of qty copies of the fixture's listing
+func (f *fixture) view(t *testing.T, buyerID string, qty int) cart.View {
+	t.Helper()
+	ctx := context.Background()
+	c := models.Cart{UserID: buyerID}
+	if err := f.carts.Add(ctx, &c, f.listing.ListingID, qty); err != nil {
+		t.Fatalf("adding to cart: %v", err)
+	}
+	v, err := f.carts.Build(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func (f *fixture) stock(t *testing.T) int {
+	t.Helper()
+	l, err := f.catalog.Listing(context.Background(), f.listing.ListingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l.Quantity
+}
+
+func (f *fixture) checkout(t *testing.T, buyerID string, v cart.View) models.Order {
+	t.Helper()
+	list, err := f.svc.Checkout(context.Background(), buyerID, v, Shipment{})
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("checkout created %d orders, want 1", len(list))
+	}
+	return list[0]
+}
+
+func TestCheckoutReservesStock(t *testing.T) {
+	f := newFixture(t)
+
+	// both buyers see two in stock before either checks out
+	first, second := f.view(t, "alice", 2), f.view(t, "bob", 1)
+
+	o := f.checkout(t, "alice", first)
+	if o.Status != models.OrderStatusPendingPayment || o.TotalCents != 300 {
+		t.Fatalf("order = %s for %d, want pending_payment for 300", o.Status, o.TotalCents)
+	}
+	if got := f.stock(t); got != 0 {
+		t.Fatalf("stock after checkout = %d, want 0", got)
+	}
+
+	if _, err := f.svc.Checkout(context.Background(), "bob", second, Shipment{}); !errors.Is(err, catalog.ErrInsufficientStock) {
+		t.Fatalf("second checkout of the last copies: err = %v, want ErrInsufficientStock", err)
+	}
+	if list, _ := f.svc.ForBuyer(context.Background(), "bob"); len(list) != 0 {
+		t.Fatalf("losing checkout created %d orders", len(list))
+	}
+}
+
+func TestCheckoutRejectsInvalidCart(t *testing.T) {
+	f := newFixture(t)
+	if _, err := f.svc.Checkout(context.Background(), "alice", cart.View{}, Shipment{}); !errors.Is(err, ErrCartInvalid) {
+		t.Fatalf("empty cart: err = %v, want ErrCartInvalid", err)
+	}
+}
+
+func TestFailedClaimReleasesStock(t *testing.T) {
+	f := newFixture(t)
+	f.svc.BeforeWrite(func(context.Context, models.Order) (func(context.Context), error) {
+		return nil, errors.New("offer already taken")
+	})
+
+	if _, err := f.svc.Checkout(context.Background(), "alice", f.view(t, "alice", 2), Shipment{}); err == nil {
+		t.Fatal("checkout succeeded although its claim failed")
+	}
+	if got := f.stock(t); got != 2 {
+		t.Fatalf("stock after failed checkout = %d, want 2", got)
+	}
+}
+
+func TestCancelReleasesStock(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	o := f.checkout(t, "alice", f.view(t, "alice", 2))
+
+	if _, err := f.svc.Transition(ctx, o.OrderID, models.OrderStatusCancelled, Seller("someone-else"), "no"); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("cancel by another seller: err = %v, want ErrNotAllowed", err)
+	}
+	if _, err := f.svc.Transition(ctx, o.OrderID, models.OrderStatusCancelled, Buyer("alice"), "changed my mind"); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.stock(t); got != 2 {
+		t.Fatalf("stock after cancel = %d, want 2", got)
+	}
+
+	// a second cancel is refused and doesn't put the copies back twice
+	if _, err := f.svc.Transition(ctx, o.OrderID, models.OrderStatusCancelled, Buyer("alice"), "again"); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("second cancel: err = %v, want ErrInvalidTransition", err)
+	}
+	if got := f.stock(t); got != 2 {
+		t.Fatalf("stock after second cancel = %d, want 2", got)
+	}
+}
+
+func TestShippedOrderKeepsStockWhenRefunded(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	o := f.checkout(t, "alice", f.view(t, "alice", 1))
+
+	if _, err := f.svc.Transition(ctx, o.OrderID, models.OrderStatusPaid, System, "paid"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.svc.Ship(ctx, o.OrderID, Seller("seller"), "usps", "9400"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.svc.Transition(ctx, o.OrderID, models.OrderStatusRefunded, System, "lost in the post"); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.stock(t); got != 1 {
+		t.Fatalf("stock after refunding a shipped order = %d, want 1", got)
+	}
+}
+
+func TestExpireUnpaid(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	unpaid := f.checkout(t, "alice", f.view(t, "alice", 1))
+	paid := f.checkout(t, "bob", f.view(t, "bob", 1))
+	if _, err := f.svc.Transition(ctx, paid.OrderID, models.OrderStatusPaid, System, "paid"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := f.svc.ExpireUnpaid(ctx, time.Hour); err != nil || n != 0 {
+		t.Fatalf("expiring fresh orders = %d, %v; want 0", n, err)
+	}
+	n, err := f.svc.ExpireUnpaid(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expired %d orders, want 1", n)
+	}
+
+	if o, _ := f.svc.Get(ctx, unpaid.OrderID); o.Status != models.OrderStatusCancelled {
+		t.Errorf("unpaid order is %s, want cancelled", o.Status)
+	}
+	if o, _ := f.svc.Get(ctx, paid.OrderID); o.Status != models.OrderStatusPaid {
+		t.Errorf("paid order is %s, want paid", o.Status)
+	}
+	if got := f.stock(t); got != 1 {
+		t.Errorf("stock after expiry = %d, want 1", got)
+	}
+}
+
+func TestStartLabel(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	o := f.checkout(t, "alice", f.view(t, "alice", 1))
+	seller := Seller("seller")
+
+	if _, err := f.svc.StartLabel(ctx, o.OrderID, seller); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("label for an unpaid order: err = %v, want ErrInvalidTransition", err)
+	}
+	if _, err := f.svc.Transition(ctx, o.OrderID, models.OrderStatusPaid, System, "paid"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.svc.StartLabel(ctx, o.OrderID, Seller("someone-else")); !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("label by another seller: err = %v, want ErrNotAllowed", err)
+	}
+
+	if _, err := f.svc.StartLabel(ctx, o.OrderID, seller); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.svc.StartLabel(ctx, o.OrderID, seller); !errors.Is(err, ErrLabelInProgress) {
+		t.Fatalf("second purchase: err = %v, want ErrLabelInProgress", err)
+	}
+
+	// once aborted, another purchase may start
+	if _, err := f.svc.AbortLabel(ctx, o.OrderID, seller); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.svc.StartLabel(ctx, o.OrderID, seller); err != nil {
+		t.Fatalf("purchase after abort: %v", err)
+	}
+
+	// a label bought by an earlier attempt is handed back instead of buying another
+	label := models.ShippingLabel{LabelID: "l1", Carrier: "usps", TrackingNumber: "9400"}
+	if _, err := f.svc.SetLabel(ctx, o.OrderID, seller, label); err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.svc.StartLabel(ctx, o.OrderID, seller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Label == nil || got.Label.LabelID != "l1" {
+		t.Fatalf("label = %+v, want l1", got.Label)
+	}
+
+	shipped, err := f.svc.ShipWithLabel(ctx, o.OrderID, seller, *got.Label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shipped.Status != models.OrderStatusShipped || shipped.TrackingNumber != "9400" || shipped.LabelStartedAt != "" {
+		t.Fatalf("shipped order = %s %q started %q", shipped.Status, shipped.TrackingNumber, shipped.LabelStartedAt)
+	}
+}
+
+func TestStartLabelAfterTimeout(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	o := f.checkout(t, "alice", f.view(t, "alice", 1))
+	if _, err := f.svc.Transition(ctx, o.OrderID, models.OrderStatusPaid, System, "paid"); err != nil {
+		t.Fatal(err)
+	}
+
+	// an attempt that died long ago doesn't block the seller forever
+	stale := time.Now().UTC().Add(-LabelTimeout - time.Minute).Format(time.RFC3339)
+	if _, err := f.svc.Amend(ctx, o.OrderID, System, "test", func(o *models.Order) { o.LabelStartedAt = stale }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.svc.StartLabel(ctx, o.OrderID, Seller("seller")); err != nil {
+		t.Fatalf("purchase after a stale attempt: %v", err)
+	}
+}
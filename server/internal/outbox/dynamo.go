@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. The stores of the other packages write new
+// events into the same table with PutOps, in the transaction that commits their change.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// PutOps returns the writes that add new events to the outbox, for a store to include in the
+// transaction that makes the change they describe
+func PutOps(events []models.DomainEvent) []dynamo.Op {
+	ops := make([]dynamo.Op, len(events))
+	for i, ev := range events {
+		ops[i] = dynamo.PutOp(ev, dynamo.IfNotExists())
+	}
+	return ops
+}
+
+// Get returns an event
+func (s *DynamoStore) Get(ctx context.Context, eventID string) (models.DomainEvent, error) {
+	var ev models.DomainEvent
+	pk, sk := models.DomainEventKey(eventID)
+	err := s.table.Get(ctx, pk, sk, &ev)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return ev, ErrNotFound
+	}
+	return ev, err
+}
+
+// Update writes an event if its stored version is still expectedVersion
+func (s *DynamoStore) Update(ctx context.Context, ev models.DomainEvent, expectedVersion int64) error {
+	err := s.table.Put(ctx, ev, dynamo.IfVersion(expectedVersion))
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
+
+// Outbox returns the events in a status, due soonest first
+func (s *DynamoStore) Outbox(ctx context.Context, status, dueBy string, limit int) ([]models.DomainEvent, error) {
+	pk, _ := models.EventOutboxKey(status, "", "")
+	q := dynamo.Query{Index: dynamo.GSI1, PK: pk, Limit: limit}
+	if dueBy != "" {
+		q.SKTo = dynamo.Through(dueBy)
+	}
+	var out []models.DomainEvent
+	err := s.table.Query(ctx, q, &out)
+	return out, err
+}
+
+// Received reports whether a consumer has an unexpired receipt for an event. The table's TTL
+// removes expired receipts only eventually, so the expiry is checked here too.
+func (s *DynamoStore) Received(ctx context.Context, consumer, eventID string) (bool, error) {
+	var r models.EventReceipt
+	pk, sk := models.EventReceiptKey(consumer, eventID)
+	err := s.table.Get(ctx, pk, sk, &r)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return r.ExpiresAt > time.Now().Unix(), nil
+}
+
+// PutReceipt records that a consumer has handled an event
+func (s *DynamoStore) PutReceipt(ctx context.Context, r models.EventReceipt) error {
+	return s.table.Put(ctx, r, dynamo.Condition{})
+}
@@ -0,0 +1,104 @@
+package outbox
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs. The other packages'
+// memory stores add their events to it with Append while they hold their own lock, which is as
+// atomic as a transaction for data that lives in one process.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	events   map[string]models.DomainEvent  // eventID -> event
+	receipts map[string]models.EventReceipt // consumer#eventID -> receipt
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		events:   map[string]models.DomainEvent{},
+		receipts: map[string]models.EventReceipt{},
+	}
+}
+
+// Append adds new events. It's meant to be called by another store while it commits the change
+// the events describe, so it can't fail. A nil MemoryStore drops them.
+func (s *MemoryStore) Append(events ...models.DomainEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ev := range events {
+		s.events[ev.EventID] = ev
+	}
+}
+
+// Get returns an event
+func (s *MemoryStore) Get(ctx context.Context, eventID string) (models.DomainEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ev, ok := s.events[eventID]
+	if !ok {
+		return ev, ErrNotFound
+	}
+	return ev, nil
+}
+
+// Update writes an event if its stored version is still expectedVersion
+func (s *MemoryStore) Update(ctx context.Context, ev models.DomainEvent, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.events[ev.EventID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.events[ev.EventID] = ev
+	return nil
+}
+
+// Outbox returns the events in a status, due soonest first
+func (s *MemoryStore) Outbox(ctx context.Context, status, dueBy string, limit int) ([]models.DomainEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pk, _ := models.EventOutboxKey(status, "", "")
+	var out []models.DomainEvent
+	for _, ev := range s.events {
+		if ev.GSI1PK != pk {
+			continue
+		}
+		if dueBy != "" && ev.NextAttemptAt > dueBy {
+			continue
+		}
+		out = append(out, ev)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK < out[j].GSI1SK })
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// Received reports whether a consumer has an unexpired receipt for an event
+func (s *MemoryStore) Received(ctx context.Context, consumer, eventID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.receipts[consumer+"#"+eventID]
+	return ok && r.ExpiresAt > time.Now().Unix(), nil
+}
+
+// PutReceipt records that a consumer has handled an event
+func (s *MemoryStore) PutReceipt(ctx context.Context, r models.EventReceipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.receipts[r.Consumer+"#"+r.EventID] = r
+	return nil
+}
@@ -0,0 +1,406 @@
+// Package outbox publishes domain events reliably.
+//
+// A store that changes an order, a listing, an offer or a seller writes the events describing the
+// change into the outbox as part of committing the change itself: the DynamoDB stores put the
+// events in the same transaction as the change, and the memory stores append them to the
+// outbox's MemoryStore while holding their own lock. An event is recorded exactly when its change
+// is, with no window where one is written and the other lost.
+//
+// The Relay then publishes pending events to subscribers. Once it dispatches to a job runner,
+// each event becomes one job per subscriber on the shared queue, so any worker can handle it and
+// a failing subscriber is retried on its own; otherwise subscribers are called in process.
+// Delivery is at least once. Each subscriber has a consumer name, and an event it has handled is
+// recorded under that name as an idempotency key, so a redelivered event skips the subscribers
+// it already reached. Events of one aggregate may be published out of order; AggregateVersion
+// tells a subscriber which is newest, and subscribers that keep state read the aggregate's
+// current state rather than trusting the order of events.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/jobs"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when an event does not exist
+	ErrNotFound = errors.New("outbox: not found")
+	// ErrConflict is returned when an event was changed concurrently
+	ErrConflict = errors.New("outbox: version conflict")
+	// ErrNotAllowed is returned when an event can't be retried in its current status
+	ErrNotAllowed = errors.New("outbox: not allowed")
+)
+
+// JobDeliver is the kind of job that hands one event to one subscriber
+const JobDeliver = "events.deliver"
+
+// Store reads and updates events in the outbox. New events are not added through it: the store
+// that makes each change writes them, in the same transaction as the change.
+type Store interface {
+	Get(ctx context.Context, eventID string) (models.DomainEvent, error)
+	// Update writes an event if its stored version is still expectedVersion
+	Update(ctx context.Context, ev models.DomainEvent, expectedVersion int64) error
+	// Outbox returns the events in a status, due soonest first. When dueBy is set only events
+	// due by then are returned; when limit is positive at most that many are.
+	Outbox(ctx context.Context, status, dueBy string, limit int) ([]models.DomainEvent, error)
+	// Received reports whether a consumer has handled an event
+	Received(ctx context.Context, consumer, eventID string) (bool, error)
+	PutReceipt(ctx context.Context, r models.EventReceipt) error
+}
+
+// NewEvent builds a pending event for a change, with v as its JSON payload. The store making
+// the change writes it.
+func NewEvent(name, aggregate, aggregateID string, aggregateVersion int64, at string, v interface{}) (models.DomainEvent, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return models.DomainEvent{}, fmt.Errorf("outbox: encoding %s event: %w", name, err)
+	}
+	ev := models.DomainEvent{
+		EventID:          ids.New(),
+		Name:             name,
+		Aggregate:        aggregate,
+		AggregateID:      aggregateID,
+		AggregateVersion: aggregateVersion,
+		Payload:          string(payload),
+		Status:           models.EventStatusPending,
+		NextAttemptAt:    at,
+		Version:          1,
+		OccurredAt:       at,
+		UpdatedAt:        at,
+	}
+	setKeys(&ev)
+	return ev, nil
+}
+
+// Subscriber handles a published event. Returning an error retries the event later.
+type Subscriber func(ctx context.Context, ev models.DomainEvent) error
+
+// subscription is a subscriber and the events it wants
+type subscription struct {
+	consumer string
+	names    []string
+	fn       Subscriber
+}
+
+// Options controls publishing and retries
+type Options struct {
+	BatchSize   int           // events published per Publish call
+	RetryBase   time.Duration // wait before the first retry; each later retry waits twice as long
+	RetryMax    time.Duration // longest wait between retries
+	MaxAttempts int           // attempts before an event is marked failed
+	ReceiptTTL  time.Duration // how long a consumer's receipt for an event is kept
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	BatchSize:   100,
+	RetryBase:   10 * time.Second,
+	RetryMax:    time.Hour,
+	MaxAttempts: 12,
+	ReceiptTTL:  7 * 24 * time.Hour,
+}
+
+// Relay publishes events from the outbox to subscribers.
+type Relay struct {
+	store    Store
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+
+	mu   sync.RWMutex
+	subs []subscription
+	jobs *jobs.Runner // set by Dispatch
+}
+
+// New creates a Relay
+func New(store Store, opts Options, errorLog *log.Logger) *Relay {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultOptions.BatchSize
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = DefaultOptions.RetryBase
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = DefaultOptions.RetryMax
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if opts.ReceiptTTL <= 0 {
+		opts.ReceiptTTL = DefaultOptions.ReceiptTTL
+	}
+	return &Relay{store: store, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// Subscribe registers fn for events with the given names, or for every event when none are
+// given. A name ending in ".*", such as "order.*", matches every event of that aggregate. The
+// consumer name keys the record of which events fn has handled, so it must be unique and must
+// not change between releases.
+func (r *Relay) Subscribe(consumer string, fn Subscriber, names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs = append(r.subs, subscription{consumer: consumer, names: names, fn: fn})
+}
+
+// delivery is the payload of a JobDeliver job
+type delivery struct {
+	EventID  string `json:"eventId"`
+	Consumer string `json:"consumer"`
+}
+
+// Dispatch makes Publish hand events to subscribers as jobs on runner's queue, one per subscriber
+// and event, instead of calling them itself. A subscriber that fails is retried by the runner
+// without holding up the others, and ends in the runner's dead letters if it never succeeds.
+func (r *Relay) Dispatch(runner *jobs.Runner) {
+	r.mu.Lock()
+	r.jobs = runner
+	r.mu.Unlock()
+
+	runner.Handle(JobDeliver, func(ctx context.Context, j jobs.Job) error {
+		var d delivery
+		if err := j.Decode(&d); err != nil {
+			return jobs.Permanent(err)
+		}
+		sub, ok := r.subscription(d.Consumer)
+		if !ok {
+			return jobs.Permanent(fmt.Errorf("outbox: no subscriber %q", d.Consumer))
+		}
+		ev, err := r.store.Get(ctx, d.EventID)
+		if errors.Is(err, ErrNotFound) {
+			return jobs.Permanent(err)
+		}
+		if err != nil {
+			return err
+		}
+		return r.Once(ctx, sub.consumer, ev, sub.fn)
+	})
+}
+
+// subscription returns the subscription of a consumer
+func (r *Relay) subscription(consumer string) (subscription, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, sub := range r.subs {
+		if sub.consumer == consumer {
+			return sub, true
+		}
+	}
+	return subscription{}, false
+}
+
+// Once runs fn for an event unless the consumer has already handled it, and records that it
+// has once fn succeeds
+func (r *Relay) Once(ctx context.Context, consumer string, ev models.DomainEvent, fn Subscriber) error {
+	return r.OnceFor(ctx, consumer, ev.EventID, func(ctx context.Context) error {
+		return fn(ctx, ev)
+	})
+}
+
+// OnceFor is Once for messages that aren't outbox events, such as a payment provider's webhook
+// events, keyed by their own ID
+func (r *Relay) OnceFor(ctx context.Context, consumer, id string, fn func(ctx context.Context) error) error {
+	done, err := r.store.Received(ctx, consumer, id)
+	if err != nil || done {
+		return err
+	}
+	if err := fn(ctx); err != nil {
+		return fmt.Errorf("%s: %w", consumer, err)
+	}
+
+	now := r.now().UTC()
+	rec := models.EventReceipt{
+		Consumer:   consumer,
+		EventID:    id,
+		ConsumedAt: now.Format(time.RFC3339),
+		ExpiresAt:  now.Add(r.opts.ReceiptTTL).Unix(),
+		Type:       models.ItemTypeReceipt,
+	}
+	rec.PK, rec.SK = models.EventReceiptKey(consumer, id)
+	return r.store.PutReceipt(ctx, rec)
+}
+
+// Publish makes one pass over the outbox, publishing every due event to its subscribers. An event
+// is claimed before it's published so concurrent relays don't share it; one that can't be handed
+// to every subscriber is retried with backoff and marked failed after MaxAttempts. It returns how
+// many events were published.
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	now := r.now().UTC()
+	due, err := r.store.Outbox(ctx, models.EventStatusPending, now.Format(time.RFC3339), r.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, ev := range due {
+		ev, err := r.update(ctx, ev.EventID, func(ev *models.DomainEvent) error {
+			if ev.Status != models.EventStatusPending {
+				return ErrConflict
+			}
+			ev.Attempts++
+			ev.NextAttemptAt = now.Add(r.backoff(ev.Attempts)).Format(time.RFC3339)
+			return nil
+		})
+		if errors.Is(err, ErrConflict) {
+			continue // another relay has it
+		}
+		if err != nil {
+			return published, err
+		}
+
+		pubErr := r.deliver(ctx, ev)
+		_, err = r.update(ctx, ev.EventID, func(ev *models.DomainEvent) error {
+			switch {
+			case pubErr == nil:
+				ev.Status = models.EventStatusPublished
+				ev.PublishedAt = ev.UpdatedAt
+				ev.LastError = ""
+			case ev.Attempts >= r.opts.MaxAttempts:
+				ev.Status = models.EventStatusFailed
+				ev.LastError = pubErr.Error()
+			default:
+				ev.LastError = pubErr.Error()
+			}
+			return nil
+		})
+		if err != nil {
+			return published, err
+		}
+		if pubErr != nil {
+			r.errorLog.Printf("publishing %s event %s failed (attempt %d): %v", ev.Name, ev.EventID, ev.Attempts, pubErr)
+			continue
+		}
+		published++
+	}
+	return published, nil
+}
+
+// deliver hands an event to every subscriber that wants it and hasn't handled it yet, through
+// the job queue once Dispatch has been called. Every subscriber gets its turn even when an
+// earlier one fails.
+func (r *Relay) deliver(ctx context.Context, ev models.DomainEvent) error {
+	r.mu.RLock()
+	subs, runner := r.subs, r.jobs
+	r.mu.RUnlock()
+
+	var errs []error
+	for _, sub := range subs {
+		if !matches(sub.names, ev.Name) {
+			continue
+		}
+		var err error
+		if runner != nil {
+			// the key makes a redelivered event's jobs duplicates of any still queued
+			j := jobs.Job{Kind: JobDeliver, UniqueKey: "event:" + sub.consumer + ":" + ev.EventID}
+			_, err = runner.EnqueueJob(ctx, j, delivery{EventID: ev.EventID, Consumer: sub.consumer})
+			if errors.Is(err, jobs.ErrDuplicate) {
+				err = nil
+			}
+		} else {
+			err = r.Once(ctx, sub.consumer, ev, sub.fn)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// matches reports whether an event name is one of names, or names is empty
+func matches(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == name || (strings.HasSuffix(n, ".*") && strings.HasPrefix(name, strings.TrimSuffix(n, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns how long to wait after an attempt before the next one
+func (r *Relay) backoff(attempts int) time.Duration {
+	wait := r.opts.RetryBase
+	for i := 1; i < attempts && wait < r.opts.RetryMax; i++ {
+		wait *= 2
+	}
+	if wait > r.opts.RetryMax {
+		wait = r.opts.RetryMax
+	}
+	return wait
+}
+
+// Run publishes due events every interval until ctx is done
+func (r *Relay) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Publish(ctx); err != nil {
+			r.errorLog.Printf("publishing events failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Outbox returns the events in a status, due soonest first, for admins to check on publishing
+func (r *Relay) Outbox(ctx context.Context, status string) ([]models.DomainEvent, error) {
+	return r.store.Outbox(ctx, status, "", 0)
+}
+
+// Retry puts a failed event back in the outbox to be published straight away. Subscribers that
+// already handled it are skipped.
+func (r *Relay) Retry(ctx context.Context, eventID string) (models.DomainEvent, error) {
+	return r.update(ctx, eventID, func(ev *models.DomainEvent) error {
+		if ev.Status != models.EventStatusFailed {
+			return ErrNotAllowed
+		}
+		ev.Status = models.EventStatusPending
+		ev.Attempts = 0
+		ev.NextAttemptAt = ev.UpdatedAt
+		return nil
+	})
+}
+
+// update reads an event, applies mutate and writes it back conditional on the version read
+func (r *Relay) update(ctx context.Context, eventID string, mutate func(*models.DomainEvent) error) (models.DomainEvent, error) {
+	ev, err := r.store.Get(ctx, eventID)
+	if err != nil {
+		return ev, err
+	}
+
+	expected := ev.Version
+	ev.UpdatedAt = r.now().UTC().Format(time.RFC3339)
+	if err := mutate(&ev); err != nil {
+		return ev, err
+	}
+	ev.Version++
+	setKeys(&ev)
+
+	if err := r.store.Update(ctx, ev, expected); err != nil {
+		return ev, err
+	}
+	return ev, nil
+}
+
+// setKeys fills in the single-table keys for an event. Published events leave the outbox index.
+func setKeys(ev *models.DomainEvent) {
+	ev.PK, ev.SK = models.DomainEventKey(ev.EventID)
+	ev.GSI1PK, ev.GSI1SK = "", ""
+	if ev.Status != models.EventStatusPublished {
+		ev.GSI1PK, ev.GSI1SK = models.EventOutboxKey(ev.Status, ev.NextAttemptAt, ev.EventID)
+	}
+	ev.Type = models.ItemTypeEvent
+}
@@ -0,0 +1,283 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeProvider is a deterministic in-memory Provider for tests and local development.
+// Intent and refund IDs are sequential, and intents only succeed when Succeed is called.
+type FakeProvider struct {
+	mu       sync.Mutex
+	seq      int
+	intents  map[string]Intent
+	refunds  map[string]Refund
+	accounts map[string]Account
+	balances map[string]int64  // connected account ID -> what it holds
+	byKey    map[string]string // idempotency key -> intent, refund, account or payout ID
+	payouts  map[string]Payout
+	reversed map[string]int64 // intent ID -> how much of its transfer has been taken back
+}
+
+// NewFakeProvider creates an empty FakeProvider
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		intents:  map[string]Intent{},
+		refunds:  map[string]Refund{},
+		accounts: map[string]Account{},
+		balances: map[string]int64{},
+		byKey:    map[string]string{},
+		payouts:  map[string]Payout{},
+		reversed: map[string]int64{},
+	}
+}
+
+// CreateIntent records a new intent awaiting payment
+func (f *FakeProvider) CreateIntent(ctx context.Context, p IntentParams) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.byKey[p.IdempotencyKey]; ok && p.IdempotencyKey != "" {
+		return f.intents[id], nil
+	}
+
+	f.seq++
+	id := fmt.Sprintf("pi_fake_%04d", f.seq)
+	in := Intent{
+		ID:                  id,
+		Status:              IntentRequiresPaymentMethod,
+		ClientSecret:        id + "_secret",
+		AmountCents:         p.AmountCents,
+		ApplicationFeeCents: p.ApplicationFeeCents,
+		Currency:            p.Currency,
+		TransferDestination: p.TransferDestination,
+		Metadata:            p.Metadata,
+	}
+	if p.CaptureManually {
+		in.Status = IntentRequiresCapture
+	}
+	f.intents[id] = in
+	if p.IdempotencyKey != "" {
+		f.byKey[p.IdempotencyKey] = id
+	}
+	return in, nil
+}
+
+// Capture completes a manually captured intent
+func (f *FakeProvider) Capture(ctx context.Context, intentID string) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	in, ok := f.intents[intentID]
+	if !ok {
+		return Intent{}, ErrIntentNotFound
+	}
+	if in.Status != IntentRequiresCapture {
+		return in, fmt.Errorf("payments: intent %s cannot be captured in status %s", intentID, in.Status)
+	}
+	in.Status = IntentSucceeded
+	in.AmountReceivedCents = in.AmountCents
+	f.intents[intentID] = in
+	f.transfer(in)
+	return in, nil
+}
+
+// Cancel calls off an intent that hasn't succeeded
+func (f *FakeProvider) Cancel(ctx context.Context, intentID string) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	in, ok := f.intents[intentID]
+	if !ok {
+		return Intent{}, ErrIntentNotFound
+	}
+	if in.Status == IntentSucceeded {
+		return in, fmt.Errorf("payments: intent %s has already succeeded", intentID)
+	}
+	in.Status = IntentCanceled
+	f.intents[intentID] = in
+	return in, nil
+}
+
+// Refund records a refund against a succeeded intent
+func (f *FakeProvider) Refund(ctx context.Context, intentID string, amountCents int64, idempotencyKey string) (Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.byKey[idempotencyKey]; ok && idempotencyKey != "" {
+		return f.refunds[id], nil
+	}
+
+	in, ok := f.intents[intentID]
+	if !ok {
+		return Refund{}, ErrIntentNotFound
+	}
+	if in.Status != IntentSucceeded {
+		return Refund{}, fmt.Errorf("payments: intent %s has not succeeded", intentID)
+	}
+	if amountCents == 0 {
+		amountCents = in.AmountReceivedCents
+	}
+	if amountCents > in.AmountReceivedCents {
+		return Refund{}, fmt.Errorf("payments: refund of %d exceeds %d remaining", amountCents, in.AmountReceivedCents)
+	}
+	in.AmountReceivedCents -= amountCents
+	f.intents[intentID] = in
+	if in.TransferDestination != "" {
+		// the transfer and application fee are reversed in proportion, as with reverse_transfer
+		// and refund_application_fee
+		back := amountCents - in.ApplicationFeeCents*amountCents/in.AmountCents
+		f.balances[in.TransferDestination] -= back
+		f.reversed[intentID] += back
+	}
+
+	f.seq++
+	r := Refund{ID: fmt.Sprintf("re_fake_%04d", f.seq), IntentID: intentID, AmountCents: amountCents, Status: "succeeded"}
+	f.refunds[r.ID] = r
+	if idempotencyKey != "" {
+		f.byKey[idempotencyKey] = r.ID
+	}
+	return r, nil
+}
+
+// Retrieve returns an intent
+func (f *FakeProvider) Retrieve(ctx context.Context, intentID string) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	in, ok := f.intents[intentID]
+	if !ok {
+		return Intent{}, ErrIntentNotFound
+	}
+	return in, nil
+}
+
+// ReverseTransfer takes part of a succeeded intent's transfer back from the connected account
+func (f *FakeProvider) ReverseTransfer(ctx context.Context, intentID string, amountCents int64, idempotencyKey string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.byKey[idempotencyKey]; ok && idempotencyKey != "" {
+		return nil
+	}
+	in, ok := f.intents[intentID]
+	if !ok {
+		return ErrIntentNotFound
+	}
+	if in.Status != IntentSucceeded || in.TransferDestination == "" {
+		return fmt.Errorf("payments: intent %s has no transfer to reverse", intentID)
+	}
+	if left := in.AmountCents - in.ApplicationFeeCents - f.reversed[intentID]; amountCents > left {
+		return fmt.Errorf("payments: reversal of %d exceeds %d left of the transfer", amountCents, left)
+	}
+	f.balances[in.TransferDestination] -= amountCents
+	f.reversed[intentID] += amountCents
+
+	f.seq++
+	if idempotencyKey != "" {
+		f.byKey[idempotencyKey] = fmt.Sprintf("trr_fake_%04d", f.seq)
+	}
+	return nil
+}
+
+// Succeed simulates the buyer completing payment for an intent
+func (f *FakeProvider) Succeed(intentID string) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	in, ok := f.intents[intentID]
+	if !ok {
+		return Intent{}, ErrIntentNotFound
+	}
+	if in.Status == IntentCanceled {
+		return in, fmt.Errorf("payments: intent %s was canceled", intentID)
+	}
+	in.Status = IntentSucceeded
+	in.AmountReceivedCents = in.AmountCents
+	f.intents[intentID] = in
+	f.transfer(in)
+	return in, nil
+}
+
+// transfer sends a succeeded destination charge's amount less the application fee to the
+// connected account, as Stripe does when the charge lands. The caller holds f.mu.
+func (f *FakeProvider) transfer(in Intent) {
+	if in.TransferDestination != "" {
+		f.balances[in.TransferDestination] += in.AmountCents - in.ApplicationFeeCents
+	}
+}
+
+// CreateAccount records a connected account that hasn't been onboarded
+func (f *FakeProvider) CreateAccount(ctx context.Context, sellerID, idempotencyKey string) (Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.byKey[idempotencyKey]; ok && idempotencyKey != "" {
+		return f.accounts[id], nil
+	}
+
+	f.seq++
+	a := Account{ID: fmt.Sprintf("acct_fake_%04d", f.seq), Metadata: map[string]string{"seller_id": sellerID}}
+	f.accounts[a.ID] = a
+	if idempotencyKey != "" {
+		f.byKey[idempotencyKey] = a.ID
+	}
+	return a, nil
+}
+
+// AccountLink completes onboarding at once, since there is nothing to fill in, and links straight
+// back to returnURL
+func (f *FakeProvider) AccountLink(ctx context.Context, accountID, refreshURL, returnURL string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.accounts[accountID]
+	if !ok {
+		return "", fmt.Errorf("payments: no account %s", accountID)
+	}
+	a.ChargesEnabled, a.PayoutsEnabled = true, true
+	f.accounts[accountID] = a
+	return returnURL, nil
+}
+
+// RetrieveAccount returns a connected account
+func (f *FakeProvider) RetrieveAccount(ctx context.Context, accountID string) (Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.accounts[accountID]
+	if !ok {
+		return Account{}, fmt.Errorf("payments: no account %s", accountID)
+	}
+	return a, nil
+}
+
+// Payout pays out from a connected account's balance
+func (f *FakeProvider) Payout(ctx context.Context, accountID string, amountCents int64, currency, idempotencyKey string) (Payout, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.byKey[idempotencyKey]; ok && idempotencyKey != "" {
+		return f.payouts[id], nil
+	}
+	if _, ok := f.accounts[accountID]; !ok {
+		return Payout{}, fmt.Errorf("payments: no account %s", accountID)
+	}
+	if amountCents <= 0 || amountCents > f.balances[accountID] {
+		return Payout{}, fmt.Errorf("payments: payout of %d exceeds %d in account %s", amountCents, f.balances[accountID], accountID)
+	}
+	f.balances[accountID] -= amountCents
+
+	f.seq++
+	p := Payout{ID: fmt.Sprintf("po_fake_%04d", f.seq), AmountCents: amountCents, Status: "paid"}
+	f.payouts[p.ID] = p
+	if idempotencyKey != "" {
+		f.byKey[idempotencyKey] = p.ID
+	}
+	return p, nil
+}
+
+// Balance returns what a connected account holds
+func (f *FakeProvider) Balance(ctx context.Context, accountID, currency string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.accounts[accountID]; !ok {
+		return 0, fmt.Errorf("payments: no account %s", accountID)
+	}
+	return f.balances[accountID], nil
+}
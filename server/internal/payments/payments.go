@@ -0,0 +1,445 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// ErrNoPayoutAccount is returned when a seller has not connected a payout account
+var ErrNoPayoutAccount = errors.New("payments: seller has no connected payout account")
+
+// Accounts stores each seller's connected payout account, such as sellers.Service.
+type Accounts interface {
+	Profile(ctx context.Context, sellerID string) (models.SellerProfile, error)
+	SetPayoutAccount(ctx context.Context, sellerID, accountID string, enabled bool) (models.SellerProfile, error)
+}
+
+// FeeFunc returns the platform application fee for an order, in cents
+type FeeFunc func(ctx context.Context, o models.Order) (int64, error)
+
+// RefundRecorder books a refund the provider has made, such as ledger.Service.RecordRefund
+type RefundRecorder func(ctx context.Context, o models.Order, amountCents int64, key string) error
+
+// Payment pairs an order awaiting payment with the client secret the browser confirms it with
+type Payment struct {
+	Order        models.Order
+	ClientSecret string
+}
+
+// Service creates payment intents for orders and marks orders paid from verified webhooks.
+type Service struct {
+	provider      Provider
+	orders        *orders.Service
+	accounts      Accounts
+	webhookSecret string
+	currency      string
+	errorLog      *log.Logger
+
+	mu     sync.Mutex
+	fee    FeeFunc
+	record RefundRecorder
+	events *outbox.Relay
+}
+
+// New creates a payments Service
+func New(provider Provider, o *orders.Service, accounts Accounts, webhookSecret string, errorLog *log.Logger) *Service {
+	return &Service{
+		provider:      provider,
+		orders:        o,
+		accounts:      accounts,
+		webhookSecret: webhookSecret,
+		currency:      "usd",
+		errorLog:      errorLog,
+		fee:           func(context.Context, models.Order) (int64, error) { return 0, nil },
+		record:        func(context.Context, models.Order, int64, string) error { return nil },
+	}
+}
+
+// Provider returns the underlying payment provider
+func (s *Service) Provider() Provider {
+	return s.provider
+}
+
+// WebhookSecret returns the secret webhooks are signed with
+func (s *Service) WebhookSecret() string {
+	return s.webhookSecret
+}
+
+// SetFeeFunc sets how the platform application fee is computed for an order
+func (s *Service) SetFeeFunc(fn FeeFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fee = fn
+}
+
+// SetRefundRecorder sets how refunds of cancelled orders are booked once the provider has made them
+func (s *Service) SetRefundRecorder(fn RefundRecorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record = fn
+}
+
+// Attach refunds buyers automatically when a paid order is cancelled before shipping, and calls
+// off the payment intent of an order cancelled before it was paid, as the cancellations are
+// published from the outbox. A refund that fails is retried with the event, and is only booked
+// once the provider has made it. The relay also remembers which webhook events have been handled.
+func (s *Service) Attach(relay *outbox.Relay) {
+	s.mu.Lock()
+	s.events = relay
+	s.mu.Unlock()
+
+	relay.Subscribe("payments", func(ctx context.Context, ev models.DomainEvent) error {
+		var change orders.Change
+		if err := ev.Decode(&change); err != nil {
+			return err
+		}
+		order := change.Order
+		switch change.Event.From {
+		case models.OrderStatusPaid:
+			return s.refundCancelled(ctx, order)
+		case models.OrderStatusPendingPayment:
+			if order.PaymentIntent == "" {
+				return nil
+			}
+			// if the buyer paid just before the cancel, this fails and the payment is refunded
+			// when its succeeded webhook arrives
+			if _, err := s.provider.Cancel(ctx, order.PaymentIntent); err != nil {
+				s.errorLog.Printf("cancelling intent %s for order %s failed: %v", order.PaymentIntent, order.OrderID, err)
+			}
+		}
+		return nil
+	}, models.EventOrderCancelled)
+}
+
+// refundCancelled refunds a paid order that was cancelled and books the refund. Both steps are
+// keyed by the order, so retrying after either fails never refunds or books it twice.
+func (s *Service) refundCancelled(ctx context.Context, o models.Order) error {
+	key := "order-" + o.OrderID + "-cancel-refund"
+	refund, err := s.RefundOrder(ctx, o, 0, key)
+	if err != nil {
+		return fmt.Errorf("refunding cancelled order %s: %w", o.OrderID, err)
+	}
+
+	s.mu.Lock()
+	record := s.record
+	s.mu.Unlock()
+	return record(ctx, o, refund.AmountCents, key)
+}
+
+// PrepareCheckout makes sure every unpaid order from a checkout has a payment intent and returns them.
+// Intents are created with an idempotency key per order, so reloading the payment page is safe.
+func (s *Service) PrepareCheckout(ctx context.Context, buyerID, checkoutID string) ([]Payment, error) {
+	list, err := s.orders.ForCheckout(ctx, checkoutID)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Payment
+	for _, o := range list {
+		if o.BuyerID != buyerID {
+			return nil, orders.ErrNotAllowed
+		}
+		if o.Status != models.OrderStatusPendingPayment {
+			continue
+		}
+
+		var in Intent
+		if o.PaymentIntent != "" {
+			in, err = s.provider.Retrieve(ctx, o.PaymentIntent)
+		} else {
+			in, err = s.createIntent(ctx, o)
+			if err == nil {
+				o, err = s.orders.Amend(ctx, o.OrderID, orders.System, "payment intent created", func(o *models.Order) {
+					o.PaymentIntent = in.ID
+					o.FeeCents = in.ApplicationFeeCents
+				})
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("preparing payment for order %s: %w", o.OrderID, err)
+		}
+		out = append(out, Payment{Order: o, ClientSecret: in.ClientSecret})
+	}
+	return out, nil
+}
+
+// CanSell reports whether a seller's payout account is ready to be paid, which listing requires
+func (s *Service) CanSell(ctx context.Context, sellerID string) (bool, error) {
+	p, err := s.accounts.Profile(ctx, sellerID)
+	if err != nil {
+		return false, err
+	}
+	return p.PayoutAccountID != "" && p.PayoutsEnabled, nil
+}
+
+// RequirePayoutAccount returns ErrNoPayoutAccount unless a seller can be paid
+func (s *Service) RequirePayoutAccount(ctx context.Context, sellerID string) error {
+	ok, err := s.CanSell(ctx, sellerID)
+	if err == nil && !ok {
+		err = ErrNoPayoutAccount
+	}
+	return err
+}
+
+// OnboardingLink returns a link to the provider's onboarding for a seller's payout account,
+// opening the account first if the seller has none. The seller comes back to returnURL, or to
+// refreshURL when the link has expired.
+func (s *Service) OnboardingLink(ctx context.Context, sellerID, refreshURL, returnURL string) (string, error) {
+	p, err := s.accounts.Profile(ctx, sellerID)
+	if err != nil {
+		return "", err
+	}
+	if p.PayoutAccountID == "" {
+		a, err := s.provider.CreateAccount(ctx, sellerID, "seller-"+sellerID+"-account")
+		if err != nil {
+			return "", err
+		}
+		if p, err = s.accounts.SetPayoutAccount(ctx, sellerID, a.ID, a.Ready()); err != nil {
+			return "", err
+		}
+	}
+	return s.provider.AccountLink(ctx, p.PayoutAccountID, refreshURL, returnURL)
+}
+
+// RefreshAccount asks the provider whether a seller's payout account has finished onboarding,
+// records the answer and returns it
+func (s *Service) RefreshAccount(ctx context.Context, sellerID string) (bool, error) {
+	p, err := s.accounts.Profile(ctx, sellerID)
+	if err != nil || p.PayoutAccountID == "" {
+		return false, err
+	}
+	a, err := s.provider.RetrieveAccount(ctx, p.PayoutAccountID)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.accounts.SetPayoutAccount(ctx, sellerID, a.ID, a.Ready()); err != nil {
+		return false, err
+	}
+	return a.Ready(), nil
+}
+
+// createIntent opens a destination charge for an order, keeping the platform fee
+func (s *Service) createIntent(ctx context.Context, o models.Order) (Intent, error) {
+	seller, err := s.accounts.Profile(ctx, o.SellerID)
+	if err != nil {
+		return Intent{}, err
+	}
+	if seller.PayoutAccountID == "" || !seller.PayoutsEnabled {
+		return Intent{}, ErrNoPayoutAccount
+	}
+	dest := seller.PayoutAccountID
+
+	s.mu.Lock()
+	feeFn := s.fee
+	s.mu.Unlock()
+	fee, err := feeFn(ctx, o)
+	if err != nil {
+		return Intent{}, err
+	}
+
+	return s.provider.CreateIntent(ctx, IntentParams{
+		AmountCents:         o.TotalCents,
+		Currency:            s.currency,
+		ApplicationFeeCents: fee,
+		TransferDestination: dest,
+		TransferGroup:       o.CheckoutID,
+		Metadata:            map[string]string{"order_id": o.OrderID, "checkout_id": o.CheckoutID},
+		IdempotencyKey:      "order-" + o.OrderID + "-intent",
+	})
+}
+
+// ReverseTransfer takes amountCents of what an order's payment transferred to the seller's
+// connected account back to the platform. It is a ledger.ReverseFunc.
+func (s *Service) ReverseTransfer(ctx context.Context, o models.Order, amountCents int64, key string) error {
+	if o.PaymentIntent == "" {
+		return fmt.Errorf("payments: order %s has no payment to reverse", o.OrderID)
+	}
+	return s.provider.ReverseTransfer(ctx, o.PaymentIntent, amountCents, key)
+}
+
+// Payout pays amountCents from a seller's connected account to their bank and returns the
+// provider's payout ID. It is a ledger.PayoutFunc, so the ledger decides how much is owed.
+func (s *Service) Payout(ctx context.Context, sellerID string, amountCents int64, key string) (string, error) {
+	p, err := s.accounts.Profile(ctx, sellerID)
+	if err != nil {
+		return "", err
+	}
+	if p.PayoutAccountID == "" || !p.PayoutsEnabled {
+		return "", ErrNoPayoutAccount
+	}
+	po, err := s.provider.Payout(ctx, p.PayoutAccountID, amountCents, s.currency, key)
+	if err != nil {
+		return "", err
+	}
+	return po.ID, nil
+}
+
+// ConnectedBalance returns what the provider says a seller's connected account holds, for
+// reconciling the ledger against it
+func (s *Service) ConnectedBalance(ctx context.Context, sellerID string) (int64, error) {
+	p, err := s.accounts.Profile(ctx, sellerID)
+	if err != nil {
+		return 0, err
+	}
+	if p.PayoutAccountID == "" {
+		return 0, nil
+	}
+	return s.provider.Balance(ctx, p.PayoutAccountID, s.currency)
+}
+
+// RefundOrder refunds amountCents of an order's payment; zero refunds whatever remains. key makes
+// the refund idempotent, so a retry after a failure never pays the buyer twice.
+func (s *Service) RefundOrder(ctx context.Context, o models.Order, amountCents int64, key string) (Refund, error) {
+	if o.PaymentIntent == "" {
+		return Refund{}, fmt.Errorf("payments: order %s has no payment to refund", o.OrderID)
+	}
+	return s.provider.Refund(ctx, o.PaymentIntent, amountCents, key)
+}
+
+// HandleWebhook verifies a signed webhook payload and applies the event
+func (s *Service) HandleWebhook(ctx context.Context, payload []byte, signature string) error {
+	ev, err := VerifyEvent(payload, signature, s.webhookSecret, DefaultTolerance, time.Now())
+	if err != nil {
+		return err
+	}
+	return s.HandleEvent(ctx, ev)
+}
+
+// HandleEvent applies a verified event. Once attached to the relay, events are processed at most
+// once by ID. Orders are only ever marked paid here, never from the buyer's redirect back to the site.
+func (s *Service) HandleEvent(ctx context.Context, ev Event) error {
+	s.mu.Lock()
+	relay := s.events
+	s.mu.Unlock()
+	if relay == nil {
+		return s.apply(ctx, ev)
+	}
+	return relay.OnceFor(ctx, "stripe", ev.ID, func(ctx context.Context) error {
+		return s.apply(ctx, ev)
+	})
+}
+
+// apply acts on an event by its type
+func (s *Service) apply(ctx context.Context, ev Event) error {
+	switch ev.Type {
+	case "payment_intent.succeeded":
+		return s.intentSucceeded(ctx, ev)
+	case "account.updated":
+		return s.accountUpdated(ctx, ev)
+	default:
+		// other event types are acknowledged but not acted on
+		return nil
+	}
+}
+
+// intentSucceeded marks the intent's order paid once the provider confirms the full amount was received
+func (s *Service) intentSucceeded(ctx context.Context, ev Event) error {
+	var in Intent
+	if err := json.Unmarshal(ev.Data.Object, &in); err != nil {
+		return fmt.Errorf("payments: decoding intent: %w", err)
+	}
+
+	// confirm with the provider rather than trusting the event body alone
+	current, err := s.provider.Retrieve(ctx, in.ID)
+	if err != nil {
+		return err
+	}
+	if current.Status != IntentSucceeded {
+		return fmt.Errorf("payments: intent %s is %s, not succeeded", in.ID, current.Status)
+	}
+
+	orderID := current.Metadata["order_id"]
+	o, err := s.orders.Get(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("payments: order %q for intent %s: %w", orderID, in.ID, err)
+	}
+	if o.PaymentIntent != current.ID {
+		return fmt.Errorf("payments: intent %s does not belong to order %s", current.ID, o.OrderID)
+	}
+	if current.AmountReceivedCents < o.TotalCents {
+		return fmt.Errorf("payments: intent %s received %d of %d", current.ID, current.AmountReceivedCents, o.TotalCents)
+	}
+	if o.Status != models.OrderStatusPendingPayment {
+		paid, err := s.wasPaid(ctx, o.OrderID)
+		if err != nil {
+			return err
+		}
+		if paid {
+			// already applied by an earlier delivery of the same payment
+			return nil
+		}
+		// the order was cancelled before the payment landed, so nothing was sold for it and the
+		// buyer gets it back. An error leaves the event unprocessed so the provider redelivers it.
+		if _, err := s.provider.Refund(ctx, current.ID, 0, "order-"+o.OrderID+"-late-payment-refund"); err != nil {
+			return fmt.Errorf("payments: refunding intent %s for %s order %s: %w", current.ID, o.Status, o.OrderID, err)
+		}
+		return nil
+	}
+
+	_, err = s.orders.Transition(ctx, o.OrderID, models.OrderStatusPaid, orders.System, "payment_intent.succeeded "+current.ID)
+	return err
+}
+
+// accountUpdated records whether a connected account can be paid once the seller finishes, or
+// the provider suspends, its onboarding
+func (s *Service) accountUpdated(ctx context.Context, ev Event) error {
+	var a Account
+	if err := json.Unmarshal(ev.Data.Object, &a); err != nil {
+		return fmt.Errorf("payments: decoding account: %w", err)
+	}
+	sellerID := a.Metadata["seller_id"]
+	if sellerID == "" {
+		return nil // not opened by the marketplace
+	}
+	p, err := s.accounts.Profile(ctx, sellerID)
+	if err != nil {
+		return err
+	}
+	if p.PayoutAccountID != a.ID {
+		return fmt.Errorf("payments: account %s does not belong to seller %s", a.ID, sellerID)
+	}
+	// confirm with the provider rather than trusting the event body alone
+	_, err = s.RefreshAccount(ctx, sellerID)
+	return err
+}
+
+// wasPaid reports whether an order was ever marked paid
+func (s *Service) wasPaid(ctx context.Context, orderID string) (bool, error) {
+	events, err := s.orders.Events(ctx, orderID)
+	if err != nil {
+		return false, err
+	}
+	for _, ev := range events {
+		if ev.To == models.OrderStatusPaid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SimulatedSucceededEvent builds a signed payment_intent.succeeded webhook for an intent the fake
+// provider has marked succeeded, so local development exercises the real webhook path.
+func SimulatedSucceededEvent(in Intent, secret string) ([]byte, string, error) {
+	obj, err := json.Marshal(in)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":   "evt_" + in.ID,
+		"type": "payment_intent.succeeded",
+		"data": map[string]json.RawMessage{"object": obj},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, SignatureHeader(payload, secret, time.Now()), nil
+}
@@ -0,0 +1,373 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/cart"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/sellers"
+)
+
+const secret = "whsec_test"
+
+// countingProvider counts the intents looked up, and fails refunds while failRefunds is set
+type countingProvider struct {
+	*FakeProvider
+	retrieved   atomic.Int64
+	failRefunds atomic.Bool
+}
+
+func (p *countingProvider) Retrieve(ctx context.Context, intentID string) (Intent, error) {
+	p.retrieved.Add(1)
+	return p.FakeProvider.Retrieve(ctx, intentID)
+}
+
+func (p *countingProvider) Refund(ctx context.Context, intentID string, amountCents int64, idempotencyKey string) (Refund, error) {
+	if p.failRefunds.Load() {
+		return Refund{}, errors.New("processor unavailable")
+	}
+	return p.FakeProvider.Refund(ctx, intentID, amountCents, idempotencyKey)
+}
+
+// refund is a refund booked through the service's RefundRecorder
+type refund struct {
+	orderID string
+	amount  int64
+	key     string
+}
+
+// fixture is a payments Service over the fake provider and in-memory stores, with a seller
+// whose payout account is connected and a buyer's checkout awaiting payment
+type fixture struct {
+	svc      *Service
+	provider *countingProvider
+	orders   *orders.Service
+	sellers  *sellers.Service
+	relay    *outbox.Relay
+	refunds  []refund
+	order    models.Order
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	ctx := context.Background()
+	discard := log.New(io.Discard, "", 0)
+
+	ob := outbox.NewMemoryStore()
+	c := catalog.New(catalog.NewMemoryStore(nil))
+	p, err := c.SavePrinting(ctx, models.Printing{Game: "mtg", SetCode: "M10", SetName: "Magic 2010", CardName: "Lightning Bolt", Rarity: "common"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.SaveListing(ctx, models.Listing{SellerID: "seller", PrintingID: p.PrintingID, Condition: "NM", Language: "en", PriceCents: 1000, Quantity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fixture{
+		provider: &countingProvider{FakeProvider: NewFakeProvider()},
+		orders:   orders.New(orders.NewMemoryStore(ob), c, discard),
+		sellers:  sellers.New(sellers.NewMemoryStore(nil)),
+		relay:    outbox.New(ob, outbox.Options{RetryBase: time.Nanosecond}, discard),
+	}
+	f.svc = New(f.provider, f.orders, f.sellers, secret, discard)
+	f.svc.SetFeeFunc(func(context.Context, models.Order) (int64, error) { return 100, nil })
+	f.svc.SetRefundRecorder(func(_ context.Context, o models.Order, amount int64, key string) error {
+		f.refunds = append(f.refunds, refund{orderID: o.OrderID, amount: amount, key: key})
+		return nil
+	})
+	f.svc.Attach(f.relay)
+
+	carts := cart.New(cart.NewMemoryStore(), c)
+	cartOf := models.Cart{UserID: "buyer"}
+	if err := carts.Add(ctx, &cartOf, l.ListingID, 1); err != nil {
+		t.Fatal(err)
+	}
+	view, err := carts.Build(ctx, cartOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := f.orders.Checkout(ctx, "buyer", view, orders.Shipment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.order = list[0]
+	return f
+}
+
+// connect takes the seller through the fake provider's onboarding
+func (f *fixture) connect(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := f.svc.OnboardingLink(ctx, "seller", "https://example.test/refresh", "https://example.test/return"); err != nil {
+		t.Fatal(err)
+	}
+	if ready, err := f.svc.RefreshAccount(ctx, "seller"); err != nil || !ready {
+		t.Fatalf("account ready = %v, %v after onboarding", ready, err)
+	}
+}
+
+// prepare creates the order's payment intent
+func (f *fixture) prepare(t *testing.T) Payment {
+	t.Helper()
+	payments, err := f.svc.PrepareCheckout(context.Background(), "buyer", f.order.CheckoutID)
+	if err != nil {
+		t.Fatalf("preparing checkout: %v", err)
+	}
+	if len(payments) != 1 {
+		t.Fatalf("%d payments, want 1", len(payments))
+	}
+	return payments[0]
+}
+
+// pay has the buyer pay and delivers the provider's signed webhook
+func (f *fixture) pay(t *testing.T, p Payment) []byte {
+	t.Helper()
+	in, err := f.provider.Succeed(p.Order.PaymentIntent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, err := SimulatedSucceededEvent(in, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.svc.HandleWebhook(context.Background(), payload, sig); err != nil {
+		t.Fatalf("handling webhook: %v", err)
+	}
+	return payload
+}
+
+func (f *fixture) status(t *testing.T) string {
+	t.Helper()
+	o, err := f.orders.Get(context.Background(), f.order.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o.Status
+}
+
+func (f *fixture) publish(t *testing.T) {
+	t.Helper()
+	if _, err := f.relay.Publish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepareCheckoutNeedsPayoutAccount(t *testing.T) {
+	f := newFixture(t)
+	if _, err := f.svc.PrepareCheckout(context.Background(), "buyer", f.order.CheckoutID); !errors.Is(err, ErrNoPayoutAccount) {
+		t.Fatalf("err = %v, want ErrNoPayoutAccount", err)
+	}
+	if err := f.svc.RequirePayoutAccount(context.Background(), "seller"); !errors.Is(err, ErrNoPayoutAccount) {
+		t.Fatalf("listing check: err = %v, want ErrNoPayoutAccount", err)
+	}
+}
+
+func TestPrepareCheckoutCreatesIntentOnce(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	f.connect(t)
+
+	first := f.prepare(t)
+	prof, _ := f.sellers.Profile(ctx, "seller")
+	in, err := f.provider.Retrieve(ctx, first.Order.PaymentIntent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.AmountCents != f.order.TotalCents || in.ApplicationFeeCents != 100 || in.TransferDestination != prof.PayoutAccountID {
+		t.Fatalf("intent = %+v, want %d to %s less a 100 fee", in, f.order.TotalCents, prof.PayoutAccountID)
+	}
+	if first.Order.FeeCents != 100 {
+		t.Errorf("order fee = %d, want 100", first.Order.FeeCents)
+	}
+
+	// reloading the payment page reuses the intent
+	if again := f.prepare(t); again.Order.PaymentIntent != first.Order.PaymentIntent || again.ClientSecret != first.ClientSecret {
+		t.Fatalf("second prepare made intent %s, want %s", again.Order.PaymentIntent, first.Order.PaymentIntent)
+	}
+
+	if _, err := f.svc.PrepareCheckout(ctx, "someone-else", f.order.CheckoutID); !errors.Is(err, orders.ErrNotAllowed) {
+		t.Fatalf("another buyer's checkout: err = %v, want ErrNotAllowed", err)
+	}
+}
+
+func TestWebhookMarksOrderPaidOnce(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	f.connect(t)
+	payload := f.pay(t, f.prepare(t))
+
+	if got := f.status(t); got != models.OrderStatusPaid {
+		t.Fatalf("order is %s, want paid", got)
+	}
+
+	// a redelivered event is remembered and not applied again
+	looked := f.provider.retrieved.Load()
+	if err := f.svc.HandleWebhook(ctx, payload, SignatureHeader(payload, secret, time.Now())); err != nil {
+		t.Fatalf("redelivery: %v", err)
+	}
+	if f.provider.retrieved.Load() != looked {
+		t.Error("redelivered event was processed again")
+	}
+	events, _ := f.orders.Events(ctx, f.order.OrderID)
+	paid := 0
+	for _, ev := range events {
+		if ev.To == models.OrderStatusPaid {
+			paid++
+		}
+	}
+	if paid != 1 {
+		t.Errorf("order marked paid %d times, want once", paid)
+	}
+}
+
+func TestWebhookRejectsBadSignature(t *testing.T) {
+	f := newFixture(t)
+	f.connect(t)
+	p := f.prepare(t)
+	in, _ := f.provider.Succeed(p.Order.PaymentIntent)
+	payload, _, err := SimulatedSucceededEvent(in, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.svc.HandleWebhook(context.Background(), payload, SignatureHeader(payload, "whsec_other", time.Now())); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("err = %v, want ErrInvalidSignature", err)
+	}
+	if got := f.status(t); got != models.OrderStatusPendingPayment {
+		t.Fatalf("order is %s after a forged webhook, want pending_payment", got)
+	}
+}
+
+func TestWebhookWaitsForFullAmount(t *testing.T) {
+	f := newFixture(t)
+	f.connect(t)
+	p := f.prepare(t)
+
+	// the event claims success but the provider hasn't taken the payment
+	in, _ := f.provider.Retrieve(context.Background(), p.Order.PaymentIntent)
+	in.Status = IntentSucceeded
+	payload, sig, err := SimulatedSucceededEvent(in, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.svc.HandleWebhook(context.Background(), payload, sig); err == nil {
+		t.Fatal("unconfirmed payment accepted")
+	}
+	if got := f.status(t); got != models.OrderStatusPendingPayment {
+		t.Fatalf("order is %s, want pending_payment", got)
+	}
+}
+
+func TestCancelPaidOrderRefunds(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	f.connect(t)
+	p := f.prepare(t)
+	f.pay(t, p)
+	f.publish(t) // the paid event
+
+	if _, err := f.orders.Transition(ctx, f.order.OrderID, models.OrderStatusCancelled, orders.Seller("seller"), "out of stock"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the refund fails at first, so nothing is booked and the cancellation is retried
+	f.provider.failRefunds.Store(true)
+	f.publish(t)
+	if len(f.refunds) != 0 {
+		t.Fatalf("refund booked although the provider failed: %+v", f.refunds)
+	}
+
+	f.provider.failRefunds.Store(false)
+	f.publish(t)
+	f.publish(t)
+	if len(f.refunds) != 1 {
+		t.Fatalf("%d refunds booked, want 1", len(f.refunds))
+	}
+	if r := f.refunds[0]; r.orderID != f.order.OrderID || r.amount != f.order.TotalCents {
+		t.Errorf("booked %+v, want %d for %s", r, f.order.TotalCents, f.order.OrderID)
+	}
+	in, _ := f.provider.FakeProvider.Retrieve(ctx, p.Order.PaymentIntent)
+	if in.AmountReceivedCents != 0 {
+		t.Errorf("%d left on the intent, want it all refunded", in.AmountReceivedCents)
+	}
+}
+
+func TestCancelUnpaidOrderCancelsIntent(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	f.connect(t)
+	p := f.prepare(t)
+
+	if _, err := f.orders.Transition(ctx, f.order.OrderID, models.OrderStatusCancelled, orders.Buyer("buyer"), "changed my mind"); err != nil {
+		t.Fatal(err)
+	}
+	f.publish(t)
+
+	in, _ := f.provider.FakeProvider.Retrieve(ctx, p.Order.PaymentIntent)
+	if in.Status != IntentCanceled {
+		t.Fatalf("intent is %s, want canceled", in.Status)
+	}
+	if len(f.refunds) != 0 {
+		t.Errorf("unpaid order booked refunds %+v", f.refunds)
+	}
+}
+
+func TestLatePaymentRefunded(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	f.connect(t)
+	p := f.prepare(t)
+
+	// the buyer pays just as the order expires, before the intent is called off
+	if _, err := f.orders.Transition(ctx, f.order.OrderID, models.OrderStatusCancelled, orders.System, "payment not received in time"); err != nil {
+		t.Fatal(err)
+	}
+	f.pay(t, p)
+
+	if got := f.status(t); got != models.OrderStatusCancelled {
+		t.Fatalf("order is %s, want cancelled", got)
+	}
+	in, _ := f.provider.FakeProvider.Retrieve(ctx, p.Order.PaymentIntent)
+	if in.AmountReceivedCents != 0 {
+		t.Fatalf("%d kept from a payment for a cancelled order", in.AmountReceivedCents)
+	}
+}
+
+func TestPayoutFromConnectedAccount(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	f.connect(t)
+	f.pay(t, f.prepare(t))
+
+	held, err := f.svc.ConnectedBalance(ctx, "seller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := f.order.TotalCents - 100; held != want {
+		t.Fatalf("connected account holds %d, want %d", held, want)
+	}
+
+	id, err := f.svc.Payout(ctx, "seller", held, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := f.svc.Payout(ctx, "seller", held, "k1"); again != id {
+		t.Fatalf("retried payout %s, want %s", again, id)
+	}
+	if held, _ = f.svc.ConnectedBalance(ctx, "seller"); held != 0 {
+		t.Fatalf("connected account holds %d after payout, want 0", held)
+	}
+	if _, err := f.svc.Payout(ctx, "nobody", 1, "k2"); !errors.Is(err, ErrNoPayoutAccount) {
+		t.Fatalf("payout to an unconnected seller: err = %v, want ErrNoPayoutAccount", err)
+	}
+}
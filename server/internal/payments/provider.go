@@ -0,0 +1,102 @@
+// Package payments charges buyers and splits funds to connected seller accounts.
+package payments
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/webhooks"
+)
+
+// Intent statuses, mirroring Stripe's PaymentIntent lifecycle
+const (
+	IntentRequiresPaymentMethod = "requires_payment_method"
+	IntentRequiresCapture       = "requires_capture"
+	IntentProcessing            = "processing"
+	IntentSucceeded             = "succeeded"
+	IntentCanceled              = "canceled"
+)
+
+var (
+	// ErrIntentNotFound is returned when the provider has no such intent
+	ErrIntentNotFound = errors.New("payments: intent not found")
+	// ErrInvalidSignature is returned when a webhook payload fails signature verification
+	ErrInvalidSignature = webhooks.ErrInvalidSignature
+)
+
+// IntentParams describes a payment to collect from a buyer
+type IntentParams struct {
+	AmountCents         int64
+	Currency            string
+	ApplicationFeeCents int64  // platform commission kept from the transfer
+	TransferDestination string // connected seller account receiving the remainder
+	TransferGroup       string
+	CaptureManually     bool
+	Metadata            map[string]string
+	IdempotencyKey      string
+}
+
+// Intent is a provider-side payment
+type Intent struct {
+	ID                  string            `json:"id"`
+	Status              string            `json:"status"`
+	ClientSecret        string            `json:"client_secret"`
+	AmountCents         int64             `json:"amount"`
+	AmountReceivedCents int64             `json:"amount_received"`
+	ApplicationFeeCents int64             `json:"application_fee_amount"`
+	Currency            string            `json:"currency"`
+	TransferDestination string            `json:"-"`
+	Metadata            map[string]string `json:"metadata"`
+}
+
+// Refund is a provider-side refund of an intent
+type Refund struct {
+	ID          string `json:"id"`
+	IntentID    string `json:"payment_intent"`
+	AmountCents int64  `json:"amount"`
+	Status      string `json:"status"`
+}
+
+// Payout is money paid from a connected account's balance to the seller's bank
+type Payout struct {
+	ID          string `json:"id"`
+	AmountCents int64  `json:"amount"`
+	Status      string `json:"status"`
+}
+
+// Account is a seller's connected account at the provider, which destination charges pay out to
+type Account struct {
+	ID             string            `json:"id"`
+	ChargesEnabled bool              `json:"charges_enabled"`
+	PayoutsEnabled bool              `json:"payouts_enabled"`
+	Metadata       map[string]string `json:"metadata"`
+}
+
+// Ready reports whether the account has finished onboarding, so payments can be sent to it
+func (a Account) Ready() bool {
+	return a.ChargesEnabled && a.PayoutsEnabled
+}
+
+// Provider is a payment processor.
+type Provider interface {
+	CreateIntent(ctx context.Context, p IntentParams) (Intent, error)
+	Capture(ctx context.Context, intentID string) (Intent, error)
+	// Cancel calls off an intent that hasn't succeeded, so the buyer can no longer pay it
+	Cancel(ctx context.Context, intentID string) (Intent, error)
+	// Refund returns amountCents of a captured intent to the buyer; zero refunds the full amount
+	Refund(ctx context.Context, intentID string, amountCents int64, idempotencyKey string) (Refund, error)
+	Retrieve(ctx context.Context, intentID string) (Intent, error)
+	// ReverseTransfer takes amountCents of an intent's transfer back from the connected account
+	ReverseTransfer(ctx context.Context, intentID string, amountCents int64, idempotencyKey string) error
+
+	// CreateAccount opens a connected account for a seller
+	CreateAccount(ctx context.Context, sellerID, idempotencyKey string) (Account, error)
+	// AccountLink returns a one-time URL that takes a seller through the provider's onboarding for
+	// an account. The seller comes back to returnURL when done, or to refreshURL if the link expired.
+	AccountLink(ctx context.Context, accountID, refreshURL, returnURL string) (string, error)
+	RetrieveAccount(ctx context.Context, accountID string) (Account, error)
+	// Payout pays amountCents from a connected account's balance to the seller's bank
+	Payout(ctx context.Context, accountID string, amountCents int64, currency, idempotencyKey string) (Payout, error)
+	// Balance returns what a connected account holds in a currency, available or still pending
+	Balance(ctx context.Context, accountID, currency string) (int64, error)
+}
@@ -0,0 +1,291 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStripeBaseURL is the Stripe API root
+const DefaultStripeBaseURL = "https://api.stripe.com"
+
+// StripeProvider implements Provider against the Stripe PaymentIntents API using destination charges.
+type StripeProvider struct {
+	secretKey string
+	baseURL   string
+	client    *http.Client
+}
+
+// NewStripeProvider creates a Stripe provider. An empty baseURL uses DefaultStripeBaseURL;
+// overriding it points the client at a mock server.
+func NewStripeProvider(secretKey, baseURL string) *StripeProvider {
+	if baseURL == "" {
+		baseURL = DefaultStripeBaseURL
+	}
+	return &StripeProvider{
+		secretKey: secretKey,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// stripeError is the error envelope returned by the Stripe API
+type stripeError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// stripeIntent is the subset of a Stripe PaymentIntent we read
+type stripeIntent struct {
+	Intent
+	TransferData *struct {
+		Destination string `json:"destination"`
+	} `json:"transfer_data"`
+}
+
+// CreateIntent creates a PaymentIntent that transfers the amount less the application fee to the seller
+func (s *StripeProvider) CreateIntent(ctx context.Context, p IntentParams) (Intent, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(p.AmountCents, 10))
+	form.Set("currency", strings.ToLower(p.Currency))
+	form.Set("automatic_payment_methods[enabled]", "true")
+	if p.ApplicationFeeCents > 0 {
+		form.Set("application_fee_amount", strconv.FormatInt(p.ApplicationFeeCents, 10))
+	}
+	if p.TransferDestination != "" {
+		form.Set("transfer_data[destination]", p.TransferDestination)
+	}
+	if p.TransferGroup != "" {
+		form.Set("transfer_group", p.TransferGroup)
+	}
+	if p.CaptureManually {
+		form.Set("capture_method", "manual")
+	}
+	for k, v := range p.Metadata {
+		form.Set("metadata["+k+"]", v)
+	}
+
+	var out stripeIntent
+	if err := s.do(ctx, http.MethodPost, "/v1/payment_intents", form, p.IdempotencyKey, &out); err != nil {
+		return Intent{}, err
+	}
+	return out.toIntent(), nil
+}
+
+// Capture captures a PaymentIntent created with manual capture
+func (s *StripeProvider) Capture(ctx context.Context, intentID string) (Intent, error) {
+	var out stripeIntent
+	if err := s.do(ctx, http.MethodPost, "/v1/payment_intents/"+url.PathEscape(intentID)+"/capture", url.Values{}, "", &out); err != nil {
+		return Intent{}, err
+	}
+	return out.toIntent(), nil
+}
+
+// Cancel cancels a PaymentIntent that hasn't succeeded
+func (s *StripeProvider) Cancel(ctx context.Context, intentID string) (Intent, error) {
+	var out stripeIntent
+	if err := s.do(ctx, http.MethodPost, "/v1/payment_intents/"+url.PathEscape(intentID)+"/cancel", url.Values{}, "", &out); err != nil {
+		return Intent{}, err
+	}
+	return out.toIntent(), nil
+}
+
+// Refund refunds a PaymentIntent, reversing the seller transfer and application fee proportionally
+func (s *StripeProvider) Refund(ctx context.Context, intentID string, amountCents int64, idempotencyKey string) (Refund, error) {
+	form := url.Values{}
+	form.Set("payment_intent", intentID)
+	form.Set("reverse_transfer", "true")
+	form.Set("refund_application_fee", "true")
+	if amountCents > 0 {
+		form.Set("amount", strconv.FormatInt(amountCents, 10))
+	}
+
+	var out Refund
+	if err := s.do(ctx, http.MethodPost, "/v1/refunds", form, idempotencyKey, &out); err != nil {
+		return Refund{}, err
+	}
+	return out, nil
+}
+
+// Retrieve fetches a PaymentIntent
+func (s *StripeProvider) Retrieve(ctx context.Context, intentID string) (Intent, error) {
+	var out stripeIntent
+	if err := s.do(ctx, http.MethodGet, "/v1/payment_intents/"+url.PathEscape(intentID), nil, "", &out); err != nil {
+		return Intent{}, err
+	}
+	return out.toIntent(), nil
+}
+
+// ReverseTransfer reverses part of the transfer a PaymentIntent's charge made to the connected
+// account. Stripe refuses to reverse more than is left of the transfer.
+func (s *StripeProvider) ReverseTransfer(ctx context.Context, intentID string, amountCents int64, idempotencyKey string) error {
+	var in struct {
+		LatestCharge struct {
+			Transfer string `json:"transfer"`
+		} `json:"latest_charge"`
+	}
+	if err := s.do(ctx, http.MethodGet, "/v1/payment_intents/"+url.PathEscape(intentID)+"?expand[]=latest_charge", nil, "", &in); err != nil {
+		return err
+	}
+	if in.LatestCharge.Transfer == "" {
+		return fmt.Errorf("stripe: intent %s has no transfer to reverse", intentID)
+	}
+
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(amountCents, 10))
+	var out struct {
+		ID string `json:"id"`
+	}
+	return s.do(ctx, http.MethodPost, "/v1/transfers/"+url.PathEscape(in.LatestCharge.Transfer)+"/reversals", form, idempotencyKey, &out)
+}
+
+// CreateAccount creates an Express connected account for a seller. Payouts are manual, so the
+// seller's share of an order waits in the account until the marketplace pays out earnings on
+// completed orders.
+func (s *StripeProvider) CreateAccount(ctx context.Context, sellerID, idempotencyKey string) (Account, error) {
+	form := url.Values{}
+	form.Set("type", "express")
+	form.Set("capabilities[card_payments][requested]", "true")
+	form.Set("capabilities[transfers][requested]", "true")
+	form.Set("settings[payouts][schedule][interval]", "manual")
+	form.Set("metadata[seller_id]", sellerID)
+
+	var out Account
+	if err := s.do(ctx, http.MethodPost, "/v1/accounts", form, idempotencyKey, &out); err != nil {
+		return Account{}, err
+	}
+	return out, nil
+}
+
+// AccountLink creates an onboarding link for a connected account
+func (s *StripeProvider) AccountLink(ctx context.Context, accountID, refreshURL, returnURL string) (string, error) {
+	form := url.Values{}
+	form.Set("account", accountID)
+	form.Set("refresh_url", refreshURL)
+	form.Set("return_url", returnURL)
+	form.Set("type", "account_onboarding")
+
+	var out struct {
+		URL string `json:"url"`
+	}
+	if err := s.do(ctx, http.MethodPost, "/v1/account_links", form, "", &out); err != nil {
+		return "", err
+	}
+	return out.URL, nil
+}
+
+// RetrieveAccount fetches a connected account
+func (s *StripeProvider) RetrieveAccount(ctx context.Context, accountID string) (Account, error) {
+	var out Account
+	if err := s.do(ctx, http.MethodGet, "/v1/accounts/"+url.PathEscape(accountID), nil, "", &out); err != nil {
+		return Account{}, err
+	}
+	return out, nil
+}
+
+// Payout pays out from a connected account's balance
+func (s *StripeProvider) Payout(ctx context.Context, accountID string, amountCents int64, currency, idempotencyKey string) (Payout, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(amountCents, 10))
+	form.Set("currency", strings.ToLower(currency))
+
+	var out Payout
+	if err := s.doAs(ctx, accountID, http.MethodPost, "/v1/payouts", form, idempotencyKey, &out); err != nil {
+		return Payout{}, err
+	}
+	return out, nil
+}
+
+// Balance sums a connected account's available and pending balance in a currency
+func (s *StripeProvider) Balance(ctx context.Context, accountID, currency string) (int64, error) {
+	type amount struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	var out struct {
+		Available []amount `json:"available"`
+		Pending   []amount `json:"pending"`
+	}
+	if err := s.doAs(ctx, accountID, http.MethodGet, "/v1/balance", nil, "", &out); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, a := range append(out.Available, out.Pending...) {
+		if strings.EqualFold(a.Currency, currency) {
+			total += a.Amount
+		}
+	}
+	return total, nil
+}
+
+// do sends a form-encoded request to Stripe as the platform and decodes the JSON response into out
+func (s *StripeProvider) do(ctx context.Context, method, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	return s.doAs(ctx, "", method, path, form, idempotencyKey, out)
+}
+
+// doAs sends a request like do, on behalf of a connected account unless accountID is empty
+func (s *StripeProvider) doAs(ctx context.Context, accountID, method, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.secretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if accountID != "" {
+		req.Header.Set("Stripe-Account", accountID)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("stripe %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("stripe %s %s: reading response: %w", method, path, err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrIntentNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var se stripeError
+		_ = json.Unmarshal(raw, &se)
+		return fmt.Errorf("stripe %s %s: %d %s: %s", method, path, resp.StatusCode, se.Error.Code, se.Error.Message)
+	}
+
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("stripe %s %s: decoding response: %w", method, path, err)
+	}
+	return nil
+}
+
+// toIntent flattens the Stripe response into an Intent
+func (si stripeIntent) toIntent() Intent {
+	in := si.Intent
+	if si.TransferData != nil {
+		in.TransferDestination = si.TransferData.Destination
+	}
+	return in
+}
@@ -0,0 +1,83 @@
+// Package photos stores user-uploaded images, such as listing photos and dispute evidence.
+//
+// Uploads are checked by sniffing their content rather than trusting the declared type, and are
+// stored under a generated name so users never choose the path.
+package photos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+)
+
+var (
+	// ErrTooLarge is returned for uploads over MaxBytes
+	ErrTooLarge = errors.New("photos: image is too large")
+	// ErrUnsupportedType is returned for uploads that aren't a JPEG, PNG or WebP image
+	ErrUnsupportedType = errors.New("photos: only JPEG, PNG and WebP images are supported")
+)
+
+// MaxBytes is the largest photo accepted
+const MaxBytes = 5 << 20
+
+// extensions maps the accepted content types to the file extension they're stored with
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// Storage writes photos and returns the URL they can be viewed at.
+type Storage interface {
+	Put(ctx context.Context, key, contentType string, data []byte) (string, error)
+}
+
+// Save validates an uploaded image and stores it under prefix with a generated name, returning its URL
+func Save(ctx context.Context, st Storage, prefix string, data []byte) (string, error) {
+	if len(data) > MaxBytes {
+		return "", ErrTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	ext, ok := extensions[contentType]
+	if !ok {
+		return "", ErrUnsupportedType
+	}
+	return st.Put(ctx, path.Join(prefix, ids.New()+ext), contentType, data)
+}
+
+// DiskStorage keeps photos on the local filesystem, served from baseURL. It suits development and
+// single-instance deployments; production would put photos in object storage behind a CDN.
+type DiskStorage struct {
+	dir     string
+	baseURL string
+}
+
+// NewDiskStorage stores photos under dir and links to them under baseURL
+func NewDiskStorage(dir, baseURL string) *DiskStorage {
+	return &DiskStorage{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Dir returns the directory photos are written to
+func (d *DiskStorage) Dir() string {
+	return d.dir
+}
+
+// Put writes a photo to disk
+func (d *DiskStorage) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	clean := path.Clean("/" + key)
+	file := filepath.Join(d.dir, filepath.FromSlash(clean))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return "", fmt.Errorf("photos: %w", err)
+	}
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		return "", fmt.Errorf("photos: %w", err)
+	}
+	return d.baseURL + clean, nil
+}
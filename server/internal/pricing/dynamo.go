@@ -0,0 +1,105 @@
+package pricing
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. Sales and prices share their printing's or
+// product's partition; an item ID doesn't say which it is, so reads look in both.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// PutSale writes a sale, replacing any sale with the same key
+func (s *DynamoStore) PutSale(ctx context.Context, sale models.Sale) error {
+	return s.table.Put(ctx, sale, dynamo.Condition{})
+}
+
+// SalesByItem returns a printing's or product's sales since a time, oldest first
+func (s *DynamoStore) SalesByItem(ctx context.Context, itemID, since string) ([]models.Sale, error) {
+	var out []models.Sale
+	for _, pk := range itemPKs(itemID) {
+		var sales []models.Sale
+		q := dynamo.Query{PK: pk, SKFrom: "SALE#" + since, SKTo: dynamo.Through("SALE#")}
+		if err := s.table.Query(ctx, q, &sales); err != nil {
+			return nil, err
+		}
+		out = append(out, sales...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SK < out[j].SK })
+	return out, nil
+}
+
+// SoldItems returns the IDs of the printings and products with sales since a time
+func (s *DynamoStore) SoldItems(ctx context.Context, since string) ([]string, error) {
+	var sales []models.Sale
+	pk, _ := models.RecentSaleKey("", "")
+	if err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, SKFrom: since}, &sales); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, sale := range sales {
+		if !seen[sale.ItemID()] {
+			seen[sale.ItemID()] = true
+			out = append(out, sale.ItemID())
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// PutGuide writes a price guide
+func (s *DynamoStore) PutGuide(ctx context.Context, g models.PriceGuide) error {
+	return s.table.Put(ctx, g, dynamo.Condition{})
+}
+
+// Guides returns a printing's or product's price guides in every condition
+func (s *DynamoStore) Guides(ctx context.Context, itemID string) ([]models.PriceGuide, error) {
+	var out []models.PriceGuide
+	for _, pk := range itemPKs(itemID) {
+		var guides []models.PriceGuide
+		if err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "PRICEGUIDE#"}, &guides); err != nil {
+			return nil, err
+		}
+		out = append(out, guides...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SK < out[j].SK })
+	return out, nil
+}
+
+// PutPoint writes a day of price history
+func (s *DynamoStore) PutPoint(ctx context.Context, p models.PricePoint) error {
+	return s.table.Put(ctx, p, dynamo.Condition{})
+}
+
+// Points returns a printing's or product's price history in a condition from a day on, oldest first
+func (s *DynamoStore) Points(ctx context.Context, itemID, condition, sinceDay string) ([]models.PricePoint, error) {
+	var out []models.PricePoint
+	for _, pk := range itemPKs(itemID) {
+		var points []models.PricePoint
+		_, from := models.PricePointKey(pk, condition, sinceDay)
+		prefix := strings.TrimSuffix(from, sinceDay)
+		if err := s.table.Query(ctx, dynamo.Query{PK: pk, SKFrom: from, SKTo: dynamo.Through(prefix)}, &points); err != nil {
+			return nil, err
+		}
+		out = append(out, points...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Day < out[j].Day })
+	return out, nil
+}
+
+// itemPKs returns the partitions an item's sales and prices may be in
+func itemPKs(itemID string) []string {
+	return []string{models.PricedItemPK(itemID, ""), models.PricedItemPK("", itemID)}
+}
@@ -0,0 +1,108 @@
+package pricing
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	sales  map[string]models.Sale       // PK+SK -> sale
+	guides map[string]models.PriceGuide // PK+SK -> guide
+	points map[string]models.PricePoint // PK+SK -> point
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sales:  map[string]models.Sale{},
+		guides: map[string]models.PriceGuide{},
+		points: map[string]models.PricePoint{},
+	}
+}
+
+// PutSale writes a sale, replacing any sale with the same key
+func (s *MemoryStore) PutSale(ctx context.Context, sale models.Sale) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sales[sale.PK+"|"+sale.SK] = sale
+	return nil
+}
+
+// SalesByItem returns a printing's or product's sales since a time, oldest first
+func (s *MemoryStore) SalesByItem(ctx context.Context, itemID, since string) ([]models.Sale, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Sale
+	for _, sale := range s.sales {
+		if sale.ItemID() == itemID && sale.SoldAt >= since {
+			out = append(out, sale)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SK < out[j].SK })
+	return out, nil
+}
+
+// SoldItems returns the IDs of the printings and products with sales since a time
+func (s *MemoryStore) SoldItems(ctx context.Context, since string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := map[string]bool{}
+	var out []string
+	for _, sale := range s.sales {
+		if sale.SoldAt >= since && !seen[sale.ItemID()] {
+			seen[sale.ItemID()] = true
+			out = append(out, sale.ItemID())
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// PutGuide writes a price guide
+func (s *MemoryStore) PutGuide(ctx context.Context, g models.PriceGuide) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guides[g.PK+"|"+g.SK] = g
+	return nil
+}
+
+// Guides returns a printing's or product's price guides in every condition
+func (s *MemoryStore) Guides(ctx context.Context, itemID string) ([]models.PriceGuide, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.PriceGuide
+	for _, g := range s.guides {
+		if g.ItemID() == itemID {
+			out = append(out, g)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SK < out[j].SK })
+	return out, nil
+}
+
+// PutPoint writes a day of price history
+func (s *MemoryStore) PutPoint(ctx context.Context, p models.PricePoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.points[p.PK+"|"+p.SK] = p
+	return nil
+}
+
+// Points returns a printing's or product's price history in a condition from a day on, oldest first
+func (s *MemoryStore) Points(ctx context.Context, itemID, condition, sinceDay string) ([]models.PricePoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.PricePoint
+	for _, p := range s.points {
+		if p.ItemID() == itemID && p.Condition == condition && p.Day >= sinceDay {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Day < out[j].Day })
+	return out, nil
+}
@@ -0,0 +1,451 @@
+// Package pricing keeps the market price guide: every completed sale is recorded per printing, or
+// sealed product, and condition, and a periodic aggregation turns recent sales into a market price, low, median and
+// high, a trend and a daily price history.
+//
+// Graded cards sell under their grade label ("PSA 10") in place of a condition, so slabs get a
+// guide and history of their own per company and grade, and the every-condition guide covers raw
+// cards only.
+//
+// Sales are recorded when an order completes, so cancelled orders never count, and a completed
+// order that is refunded later has its sales marked refunded and left out from then on.
+// Before anything is computed, sales priced far from the rest of the window (a $100 card sold
+// for $1.00, say) are set aside as outliers so a mis-keyed listing doesn't drag the guide.
+package pricing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// ErrNotFound is returned when a printing or product has no price guide in a condition
+var ErrNotFound = errors.New("pricing: not found")
+
+// dayLayout formats price history days
+const dayLayout = "2006-01-02"
+
+// Store persists sales, price guides and price history.
+type Store interface {
+	// PutSale writes a sale, replacing any sale with the same key
+	PutSale(ctx context.Context, s models.Sale) error
+	// SalesByItem returns a printing's or product's sales since a time, oldest first
+	SalesByItem(ctx context.Context, itemID, since string) ([]models.Sale, error)
+	// SoldItems returns the IDs of the printings and products with sales since a time
+	SoldItems(ctx context.Context, since string) ([]string, error)
+	PutGuide(ctx context.Context, g models.PriceGuide) error
+	// Guides returns a printing's or product's price guides in every condition
+	Guides(ctx context.Context, itemID string) ([]models.PriceGuide, error)
+	// PutPoint writes a day of price history, replacing any point for the same day
+	PutPoint(ctx context.Context, p models.PricePoint) error
+	// Points returns a printing's or product's price history in a condition from a day on, oldest first
+	Points(ctx context.Context, itemID, condition, sinceDay string) ([]models.PricePoint, error)
+}
+
+// Options controls the windows the guide is computed over and how outliers are spotted
+type Options struct {
+	Window       time.Duration // sales older than this don't count toward the guide
+	HalfLife     time.Duration // age at which a sale counts half as much toward the market price
+	TrendPeriod  time.Duration // the trend compares the median of the latest period with the one before
+	HistoryDays  int           // days of daily price history kept up to date
+	OutlierRatio float64       // a sale is only an outlier if it is this many times off the median
+	OutlierScore float64       // ...and its modified z-score is above this
+	MinSales     int           // fewest sales in a window before any are rejected as outliers
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	Window:       30 * 24 * time.Hour,
+	HalfLife:     7 * 24 * time.Hour,
+	TrendPeriod:  7 * 24 * time.Hour,
+	HistoryDays:  90,
+	OutlierRatio: 2,
+	OutlierScore: 3.5,
+	MinSales:     5,
+}
+
+// Service records sales and maintains price guides.
+type Service struct {
+	store    Store
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+}
+
+// New creates a pricing Service
+func New(store Store, opts Options, errorLog *log.Logger) *Service {
+	if opts.Window <= 0 {
+		opts.Window = DefaultOptions.Window
+	}
+	if opts.HalfLife <= 0 {
+		opts.HalfLife = DefaultOptions.HalfLife
+	}
+	if opts.TrendPeriod <= 0 {
+		opts.TrendPeriod = DefaultOptions.TrendPeriod
+	}
+	if opts.HistoryDays <= 0 {
+		opts.HistoryDays = DefaultOptions.HistoryDays
+	}
+	if opts.OutlierRatio <= 1 {
+		opts.OutlierRatio = DefaultOptions.OutlierRatio
+	}
+	if opts.OutlierScore <= 0 {
+		opts.OutlierScore = DefaultOptions.OutlierScore
+	}
+	if opts.MinSales <= 0 {
+		opts.MinSales = DefaultOptions.MinSales
+	}
+	return &Service{store: store, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// Attach records the items of every order that completes, and marks them refunded when a
+// completed order is refunded, as the order's events are published from the outbox. Either event
+// writes the sales from the order's whole history, so they come out right in whatever order the
+// events arrive.
+func (s *Service) Attach(relay *outbox.Relay, o *orders.Service) {
+	relay.Subscribe("pricing", func(ctx context.Context, ev models.DomainEvent) error {
+		var change orders.Change
+		if err := ev.Decode(&change); err != nil {
+			return err
+		}
+		events, err := o.Events(ctx, change.Order.OrderID)
+		if err != nil {
+			return err
+		}
+		var soldAt, refundedAt string
+		for _, e := range events {
+			switch {
+			case e.To == models.OrderStatusCompleted:
+				soldAt = e.At
+			case e.To == models.OrderStatusRefunded && e.From == models.OrderStatusCompleted:
+				refundedAt = e.At
+			}
+		}
+		switch {
+		case soldAt == "":
+			return nil // refunded before it completed, so it never sold
+		case refundedAt != "":
+			return s.Refund(ctx, change.Order, soldAt, refundedAt)
+		}
+		return s.Record(ctx, change.Order, soldAt)
+	}, models.EventOrderCompleted, models.EventOrderRefunded)
+}
+
+// Record stores each item of a completed order as a sale at soldAt
+func (s *Service) Record(ctx context.Context, o models.Order, soldAt string) error {
+	for _, sale := range orderSales(o, soldAt) {
+		if err := s.store.PutSale(ctx, sale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refund marks the sales recorded at soldAt for an order as refunded at refundedAt, so they no
+// longer count toward the guide or show as recent sales
+func (s *Service) Refund(ctx context.Context, o models.Order, soldAt, refundedAt string) error {
+	for _, sale := range orderSales(o, soldAt) {
+		sale.RefundedAt = refundedAt
+		if err := s.store.PutSale(ctx, sale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderSales builds the sales of an order's items sold at soldAt
+func orderSales(o models.Order, soldAt string) []models.Sale {
+	var out []models.Sale
+	for _, it := range o.Items {
+		if it.PrintingID == "" && it.ProductID == "" || it.Quantity < 1 {
+			continue
+		}
+		sale := models.Sale{
+			Type:           models.ItemTypeSale,
+			PrintingID:     it.PrintingID,
+			ProductID:      it.ProductID,
+			OrderID:        o.OrderID,
+			ListingID:      it.ListingID,
+			Condition:      it.Condition,
+			Language:       it.Language,
+			Foil:           it.Foil,
+			Quantity:       it.Quantity,
+			UnitPriceCents: it.UnitPriceCents,
+			SoldAt:         soldAt,
+		}
+		sale.PK, sale.SK = models.SaleKey(models.PricedItemPK(sale.PrintingID, sale.ProductID), soldAt, o.OrderID, it.ListingID)
+		sale.GSI1PK, sale.GSI1SK = models.RecentSaleKey(soldAt, sale.ItemID())
+		out = append(out, sale)
+	}
+	return out
+}
+
+// Guides returns a printing's or product's price guides, the every-condition guide first, then by condition
+// best first, then graded by company and grade, best first
+func (s *Service) Guides(ctx context.Context, itemID string) ([]models.PriceGuide, error) {
+	guides, err := s.store.Guides(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(guides, func(i, j int) bool {
+		a, b := guides[i].Condition, guides[j].Condition
+		if conditionOrder(a) != conditionOrder(b) {
+			return conditionOrder(a) < conditionOrder(b)
+		}
+		return gradeLess(a, b)
+	})
+	return guides, nil
+}
+
+// Guide returns a printing's or product's price guide in a condition, "" for every condition
+func (s *Service) Guide(ctx context.Context, itemID, condition string) (models.PriceGuide, error) {
+	guides, err := s.store.Guides(ctx, itemID)
+	if err != nil {
+		return models.PriceGuide{}, err
+	}
+	for _, g := range guides {
+		if g.Condition == condition {
+			return g, nil
+		}
+	}
+	return models.PriceGuide{}, ErrNotFound
+}
+
+// MarketPrice returns a printing's or product's market price in a condition. It reports false
+// when it hasn't sold in that condition within the guide's window.
+func (s *Service) MarketPrice(ctx context.Context, itemID, condition string) (int64, bool, error) {
+	g, err := s.Guide(ctx, itemID, condition)
+	if errors.Is(err, ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return g.MarketCents, g.Sales > 0, nil
+}
+
+// History returns up to days of a printing's or product's daily price history in a condition,
+// oldest first
+func (s *Service) History(ctx context.Context, itemID, condition string, days int) ([]models.PricePoint, error) {
+	if days <= 0 || days > s.opts.HistoryDays {
+		days = s.opts.HistoryDays
+	}
+	since := s.now().UTC().AddDate(0, 0, -days+1).Format(dayLayout)
+	return s.store.Points(ctx, itemID, condition, since)
+}
+
+// RecentSales returns up to limit of a printing's or product's latest sales, newest first
+func (s *Service) RecentSales(ctx context.Context, itemID string, limit int) ([]models.Sale, error) {
+	since := s.now().UTC().Add(-s.opts.Window).Format(time.RFC3339)
+	sales, err := s.store.SalesByItem(ctx, itemID, since)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.Sale, 0, limit)
+	for i := len(sales) - 1; i >= 0 && len(out) < limit; i-- {
+		if sales[i].RefundedAt == "" {
+			out = append(out, sales[i])
+		}
+	}
+	return out, nil
+}
+
+// Aggregate recomputes the guide and brings the price history up to date for every printing and
+// product with sales recent enough to affect either. It returns how many were updated.
+func (s *Service) Aggregate(ctx context.Context) (int, error) {
+	now := s.now().UTC()
+	since := now.AddDate(0, 0, -s.opts.HistoryDays).Add(-s.opts.Window).Format(time.RFC3339)
+
+	itemIDs, err := s.store.SoldItems(ctx, since)
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, id := range itemIDs {
+		if err := ctx.Err(); err != nil {
+			return updated, err
+		}
+		if err := s.aggregate(ctx, id, since, now); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// Run aggregates the guide now and then on every tick until ctx is cancelled
+func (s *Service) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Aggregate(ctx); err != nil && ctx.Err() == nil {
+			s.errorLog.Printf("aggregating the price guide failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// aggregate recomputes one printing's or product's guides and history from its sales since a time
+func (s *Service) aggregate(ctx context.Context, itemID, since string, now time.Time) error {
+	sales, err := s.store.SalesByItem(ctx, itemID, since)
+	if err != nil {
+		return err
+	}
+	if len(sales) == 0 {
+		return nil
+	}
+	item := priced{printingID: sales[0].PrintingID, productID: sales[0].ProductID}
+	byCondition := map[string][]sample{"": nil}
+	for _, sale := range sales {
+		if sale.RefundedAt != "" {
+			// still rewrite the guide in its condition, which may have no other sales left
+			if _, ok := byCondition[sale.Condition]; !ok {
+				byCondition[sale.Condition] = nil
+			}
+			continue
+		}
+		soldAt, err := time.Parse(time.RFC3339, sale.SoldAt)
+		if err != nil {
+			s.errorLog.Printf("skipping sale %s with bad time %q", sale.SK, sale.SoldAt)
+			continue
+		}
+		smp := sample{cents: sale.UnitPriceCents, qty: sale.Quantity, at: soldAt}
+		if !models.IsGradeLabel(sale.Condition) {
+			byCondition[""] = append(byCondition[""], smp)
+		}
+		byCondition[sale.Condition] = append(byCondition[sale.Condition], smp)
+	}
+
+	updatedAt := now.Format(time.RFC3339)
+	for condition, samples := range byCondition {
+		st := s.summarize(samples, now)
+		g := models.PriceGuide{
+			Type:        models.ItemTypePriceGuide,
+			PrintingID:  item.printingID,
+			ProductID:   item.productID,
+			Condition:   condition,
+			MarketCents: st.market,
+			LowCents:    st.low,
+			MedianCents: st.median,
+			HighCents:   st.high,
+			Sales:       st.sales,
+			Outliers:    st.outliers,
+			TrendBps:    st.trendBps,
+			HasTrend:    st.hasTrend,
+			UpdatedAt:   updatedAt,
+		}
+		g.PK, g.SK = models.PriceGuideKey(item.pk(), condition)
+		if err := s.store.PutGuide(ctx, g); err != nil {
+			return err
+		}
+		if err := s.fillHistory(ctx, item, condition, samples, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillHistory writes the price history days that have no point yet, and rewrites yesterday's and
+// today's, which may have been written before the day's last sales, from the sales in the window
+// ending at each day
+func (s *Service) fillHistory(ctx context.Context, item priced, condition string, samples []sample, now time.Time) error {
+	today := now.Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, -s.opts.HistoryDays+1)
+	yesterday := today.AddDate(0, 0, -1)
+
+	existing, err := s.store.Points(ctx, item.id(), condition, first.Format(dayLayout))
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, p := range existing {
+		have[p.Day] = true
+	}
+
+	for day := first; !day.After(today); day = day.AddDate(0, 0, 1) {
+		key := day.Format(dayLayout)
+		if have[key] && day.Before(yesterday) {
+			continue
+		}
+		end := day.AddDate(0, 0, 1)
+		if day.Equal(today) {
+			end = now
+		}
+		st := s.summarize(samples, end)
+		if st.sales == 0 {
+			continue
+		}
+		p := models.PricePoint{
+			Type:        models.ItemTypePricePoint,
+			PrintingID:  item.printingID,
+			ProductID:   item.productID,
+			Condition:   condition,
+			Day:         key,
+			MarketCents: st.market,
+			LowCents:    st.low,
+			MedianCents: st.median,
+			HighCents:   st.high,
+			Sales:       st.sales,
+		}
+		p.PK, p.SK = models.PricePointKey(item.pk(), condition, key)
+		if err := s.store.PutPoint(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// priced is the printing or sealed product a guide is being computed for
+type priced struct {
+	printingID, productID string
+}
+
+// id is the printing's or product's ID
+func (p priced) id() string {
+	if p.productID != "" {
+		return p.productID
+	}
+	return p.printingID
+}
+
+// pk is the partition the item's prices are kept under
+func (p priced) pk() string {
+	return models.PricedItemPK(p.printingID, p.productID)
+}
+
+// conditionOrder sorts the every-condition guide first and then conditions, or a sealed product's
+// conditions, best first
+func conditionOrder(condition string) int {
+	if condition == "" {
+		return -1
+	}
+	for i, c := range models.SealedConditions {
+		if condition == c {
+			return i
+		}
+	}
+	return models.ConditionRank(condition)
+}
+
+// gradeLess orders grade labels by company and then highest grade first
+func gradeLess(a, b string) bool {
+	ac, ag, _ := strings.Cut(a, " ")
+	bc, bg, _ := strings.Cut(b, " ")
+	if ac != bc {
+		return ac < bc
+	}
+	av, _ := strconv.ParseFloat(ag, 64)
+	bv, _ := strconv.ParseFloat(bg, 64)
+	return av > bv
+}
@@ -0,0 +1,132 @@
+package pricing
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// sample is one sale's unit price, the copies sold at it and when
+type sample struct {
+	cents int64
+	qty   int
+	at    time.Time
+}
+
+// stats summarizes the samples in a window
+type stats struct {
+	market, low, median, high int64
+	sales, outliers           int
+	trendBps                  int64
+	hasTrend                  bool
+}
+
+// summarize computes the guide for the window ending at end from the samples in it
+func (s *Service) summarize(all []sample, end time.Time) stats {
+	start := end.Add(-s.opts.Window)
+	var window []sample
+	for _, smp := range all {
+		if smp.at.After(start) && !smp.at.After(end) {
+			window = append(window, smp)
+		}
+	}
+
+	kept, outliers := s.reject(window)
+	st := stats{outliers: outliers}
+	if len(kept) == 0 {
+		return st
+	}
+
+	st.low, st.high = kept[0].cents, kept[0].cents
+	var weighted, weights float64
+	for _, smp := range kept {
+		st.sales += smp.qty
+		if smp.cents < st.low {
+			st.low = smp.cents
+		}
+		if smp.cents > st.high {
+			st.high = smp.cents
+		}
+		w := float64(smp.qty) * math.Pow(0.5, float64(end.Sub(smp.at))/float64(s.opts.HalfLife))
+		weighted += w * float64(smp.cents)
+		weights += w
+	}
+	st.median = median(kept)
+	st.market = int64(math.Round(weighted / weights))
+
+	// The trend compares the latest period's median with the period before it
+	split := end.Add(-s.opts.TrendPeriod)
+	var recent, prior []sample
+	for _, smp := range kept {
+		switch {
+		case smp.at.After(split):
+			recent = append(recent, smp)
+		case smp.at.After(split.Add(-s.opts.TrendPeriod)):
+			prior = append(prior, smp)
+		}
+	}
+	if len(recent) > 0 && len(prior) > 0 {
+		if before := median(prior); before > 0 {
+			st.trendBps = (median(recent) - before) * 10000 / before
+			st.hasTrend = true
+		}
+	}
+	return st
+}
+
+// reject sets aside sales priced far from the window's median. A sale is an outlier when it is
+// more than OutlierRatio times above or below the median and its modified z-score (its distance
+// from the median over the median absolute deviation) is above OutlierScore. Windows with fewer
+// than MinSales copies are too thin to judge and are kept whole. It returns the kept samples and
+// how many copies were rejected.
+func (s *Service) reject(window []sample) ([]sample, int) {
+	copies := 0
+	for _, smp := range window {
+		copies += smp.qty
+	}
+	if copies < s.opts.MinSales {
+		return window, 0
+	}
+
+	mid := median(window)
+	deviations := make([]sample, len(window))
+	for i, smp := range window {
+		d := smp.cents - mid
+		if d < 0 {
+			d = -d
+		}
+		deviations[i] = sample{cents: d, qty: smp.qty}
+	}
+	mad := median(deviations)
+
+	var kept []sample
+	rejected := 0
+	for i, smp := range window {
+		far := float64(smp.cents) > float64(mid)*s.opts.OutlierRatio || float64(smp.cents)*s.opts.OutlierRatio < float64(mid)
+		unusual := mad == 0 || 0.6745*float64(deviations[i].cents)/float64(mad) > s.opts.OutlierScore
+		if far && unusual {
+			rejected += smp.qty
+			continue
+		}
+		kept = append(kept, smp)
+	}
+	return kept, rejected
+}
+
+// median returns the quantity-weighted median price of samples, the lower middle for an even count
+func median(samples []sample) int64 {
+	sorted := append([]sample(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].cents < sorted[j].cents })
+	total := 0
+	for _, smp := range sorted {
+		total += smp.qty
+	}
+	seen := 0
+	for _, smp := range sorted {
+		seen += smp.qty
+		if 2*seen >= total {
+			return smp.cents
+		}
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// blankLines matches runs of blank lines left by template actions in plain-text email
+var blankLines = regexp.MustCompile(`\n[ \t]*\n(?:[ \t]*\n)+`)
+
+// Email renders templates/email/<name>.email.tmpl with the email layouts. Each email template
+// defines "subject", "html" and "text"; the text part is unescaped so it reads as plain text.
+func Email(name string, data interface{}) (subject, htmlBody, text string, err error) {
+	var tc map[string]*template.Template
+	if app != nil && app.UseCache {
+		tc = app.EmailCache
+	} else {
+		tc, err = CreateEmailTemplateCache()
+		if err != nil {
+			return "", "", "", err
+		}
+	}
+
+	t, ok := tc[name]
+	if !ok {
+		return "", "", "", fmt.Errorf("can't get email template %q from cache", name)
+	}
+
+	var parts [3]string
+	for i, part := range []string{"subject", "email_html", "email_text"} {
+		buf := new(bytes.Buffer)
+		if err := t.ExecuteTemplate(buf, part, data); err != nil {
+			return "", "", "", err
+		}
+		parts[i] = buf.String()
+	}
+	subject = strings.Join(strings.Fields(html.UnescapeString(parts[0])), " ")
+	if subject == "" {
+		return "", "", "", errors.New("email template " + name + " has an empty subject")
+	}
+	text = blankLines.ReplaceAllString(html.UnescapeString(parts[2]), "\n\n")
+	return subject, parts[1], strings.TrimSpace(text) + "\n", nil
+}
+
+// CreateEmailTemplateCache builds a cache of parsed email templates, keyed by name.
+func CreateEmailTemplateCache() (map[string]*template.Template, error) {
+	myCache := map[string]*template.Template{}
+
+	emails, err := filepath.Glob(fmt.Sprintf("%s/email/*.email.tmpl", pathToTemplates))
+	if err != nil {
+		return myCache, err
+	}
+	layouts, err := filepath.Glob(fmt.Sprintf("%s/email/*.layout.tmpl", pathToTemplates))
+	if err != nil {
+		return myCache, err
+	}
+
+	for _, email := range emails {
+		name := strings.TrimSuffix(filepath.Base(email), ".email.tmpl")
+
+		ts, err := template.New(name).Funcs(functions).ParseFiles(append([]string{email}, layouts...)...)
+		if err != nil {
+			return myCache, err
+		}
+
+		myCache[name] = ts
+	}
+
+	return myCache, nil
+}
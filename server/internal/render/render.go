@@ -13,6 +13,7 @@ import (
 	"github.com/justinas/nosurf"
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/config"
 	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
 )
 
 // app holds the application configuration.
@@ -26,6 +27,9 @@ var functions = template.FuncMap{
 	"humanDate":        HumanDate,
 	"formatDate":       FormatDate,
 	"formatStringDate": FormatStringDate,
+	"formatCents":      money.Format,
+	"formatBps":        FormatBps,
+	"formatPercent":    FormatPercent,
 }
 
 // NewTemplates sets the config for the template package
@@ -52,14 +56,46 @@ func FormatStringDate(date string) string {
 	return t.Format("Jan 2, 2006 3:04 PM")
 }
 
+// FormatBps formats a change in basis points as a signed percentage, such as "+3.25%".
+func FormatBps(bps int64) string {
+	sign := "+"
+	if bps < 0 {
+		sign, bps = "-", -bps
+	}
+	return fmt.Sprintf("%s%d.%02d%%", sign, bps/100, bps%100)
+}
+
+// FormatPercent formats a non-negative amount in basis points as a percentage, such as "5%" or "2.50%".
+func FormatPercent(bps int64) string {
+	if bps%100 == 0 {
+		return fmt.Sprintf("%d%%", bps/100)
+	}
+	return fmt.Sprintf("%d.%02d%%", bps/100, bps%100)
+}
+
 // AddDefaultData injects default data (flash messages, CSRF token, etc.) into the template data.
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	td.CSRFToken = nosurf.Token(r)
+	td.CartCount = app.Session.GetInt(r.Context(), "cart_count")
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
+		if app.Messages != nil {
+			n, err := app.Messages.Unread(r.Context(), app.Session.GetString(r.Context(), "user_id"))
+			if err != nil {
+				app.ErrorLog.Printf("counting unread messages failed: %v", err)
+			}
+			td.UnreadMessages = n
+		}
+		if app.Notifications != nil {
+			n, err := app.Notifications.Unread(r.Context(), app.Session.GetString(r.Context(), "user_id"))
+			if err != nil {
+				app.ErrorLog.Printf("counting unread notifications failed: %v", err)
+			}
+			td.UnreadNotices = n
+		}
 	}
 	return td
 }
@@ -0,0 +1,95 @@
+package repricing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. A seller's rules and runs share the
+// seller's partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// PutRule writes a rule
+func (s *DynamoStore) PutRule(ctx context.Context, r models.RepriceRule) error {
+	return s.table.Put(ctx, r, dynamo.Condition{})
+}
+
+// GetRule returns one of a seller's rules
+func (s *DynamoStore) GetRule(ctx context.Context, sellerID, ruleID string) (models.RepriceRule, error) {
+	var r models.RepriceRule
+	pk, sk := models.RepriceRuleKey(sellerID, ruleID)
+	err := s.table.Get(ctx, pk, sk, &r)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return models.RepriceRule{}, ErrNotFound
+	}
+	return r, err
+}
+
+// DeleteRule removes a rule
+func (s *DynamoStore) DeleteRule(ctx context.Context, sellerID, ruleID string) error {
+	pk, sk := models.RepriceRuleKey(sellerID, ruleID)
+	return s.table.Delete(ctx, pk, sk, dynamo.Condition{})
+}
+
+// RulesBySeller returns a seller's rules
+func (s *DynamoStore) RulesBySeller(ctx context.Context, sellerID string) ([]models.RepriceRule, error) {
+	var out []models.RepriceRule
+	pk, _ := models.RepriceRuleKey(sellerID, "")
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "REPRICERULE#"}, &out)
+	return out, err
+}
+
+// EnabledSellers returns the sellers with at least one enabled rule
+func (s *DynamoStore) EnabledSellers(ctx context.Context) ([]string, error) {
+	var rules []models.RepriceRule
+	pk, _ := models.EnabledRepriceRuleKey("", "")
+	if err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk}, &rules); err != nil {
+		return nil, err
+	}
+	// the index is ordered by seller, so each seller's rules are together
+	var out []string
+	for _, r := range rules {
+		if len(out) == 0 || out[len(out)-1] != r.SellerID {
+			out = append(out, r.SellerID)
+		}
+	}
+	return out, nil
+}
+
+// PutRun writes a run
+func (s *DynamoStore) PutRun(ctx context.Context, run models.RepriceRun) error {
+	return s.table.Put(ctx, run, dynamo.Condition{})
+}
+
+// GetRun returns one of a seller's runs. A run's key starts with when it started, so it is found
+// among the seller's runs.
+func (s *DynamoStore) GetRun(ctx context.Context, sellerID, runID string) (models.RepriceRun, error) {
+	runs, err := s.RunsBySeller(ctx, sellerID)
+	if err != nil {
+		return models.RepriceRun{}, err
+	}
+	for _, run := range runs {
+		if run.RunID == runID {
+			return run, nil
+		}
+	}
+	return models.RepriceRun{}, ErrNotFound
+}
+
+// RunsBySeller returns a seller's runs, newest first
+func (s *DynamoStore) RunsBySeller(ctx context.Context, sellerID string) ([]models.RepriceRun, error) {
+	var out []models.RepriceRun
+	pk, _ := models.RepriceRunKey(sellerID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "REPRICERUN#", Descending: true}, &out)
+	return out, err
+}
@@ -0,0 +1,129 @@
+package repricing
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	rules map[string]models.RepriceRule // ruleID -> rule
+	runs  map[string]models.RepriceRun  // runID -> run
+	order map[string]int                // runID -> write order, to break ties between runs in the same second
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		rules: map[string]models.RepriceRule{},
+		runs:  map[string]models.RepriceRun{},
+		order: map[string]int{},
+	}
+}
+
+// PutRule writes a rule
+func (s *MemoryStore) PutRule(ctx context.Context, r models.RepriceRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules[r.RuleID] = r
+	return nil
+}
+
+// GetRule returns one of a seller's rules
+func (s *MemoryStore) GetRule(ctx context.Context, sellerID, ruleID string) (models.RepriceRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.rules[ruleID]
+	if !ok || r.SellerID != sellerID {
+		return models.RepriceRule{}, ErrNotFound
+	}
+	return r, nil
+}
+
+// DeleteRule removes a rule
+func (s *MemoryStore) DeleteRule(ctx context.Context, sellerID, ruleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.rules[ruleID]; ok && r.SellerID == sellerID {
+		delete(s.rules, ruleID)
+	}
+	return nil
+}
+
+// RulesBySeller returns a seller's rules
+func (s *MemoryStore) RulesBySeller(ctx context.Context, sellerID string) ([]models.RepriceRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.RepriceRule
+	for _, r := range s.rules {
+		if r.SellerID == sellerID {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SK < out[j].SK })
+	return out, nil
+}
+
+// EnabledSellers returns the sellers with at least one enabled rule
+func (s *MemoryStore) EnabledSellers(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := map[string]bool{}
+	var out []string
+	for _, r := range s.rules {
+		if r.Enabled && !seen[r.SellerID] {
+			seen[r.SellerID] = true
+			out = append(out, r.SellerID)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// PutRun writes a run
+func (s *MemoryStore) PutRun(ctx context.Context, run models.RepriceRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.Changes = append([]models.RepriceChange(nil), run.Changes...)
+	if _, ok := s.order[run.RunID]; !ok {
+		s.order[run.RunID] = len(s.order)
+	}
+	s.runs[run.RunID] = run
+	return nil
+}
+
+// GetRun returns one of a seller's runs
+func (s *MemoryStore) GetRun(ctx context.Context, sellerID, runID string) (models.RepriceRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	run, ok := s.runs[runID]
+	if !ok || run.SellerID != sellerID {
+		return models.RepriceRun{}, ErrNotFound
+	}
+	run.Changes = append([]models.RepriceChange(nil), run.Changes...)
+	return run, nil
+}
+
+// RunsBySeller returns a seller's runs, newest first
+func (s *MemoryStore) RunsBySeller(ctx context.Context, sellerID string) ([]models.RepriceRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.RepriceRun
+	for _, run := range s.runs {
+		if run.SellerID == sellerID {
+			run.Changes = append([]models.RepriceChange(nil), run.Changes...)
+			out = append(out, run)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].StartedAt != out[j].StartedAt {
+			return out[i].StartedAt > out[j].StartedAt
+		}
+		return s.order[out[i].RunID] > s.order[out[j].RunID]
+	})
+	return out, nil
+}
@@ -0,0 +1,240 @@
+// Package repricing runs sellers' automatic repricing rules over their listings.
+//
+// A rule either sets a price — the market price from the price guide less a percentage, or a
+// cent below the cheapest other Near Mint listing of the printing — or puts a floor under it. Each
+// listing takes its price from the first pricing rule, by priority, that matches it and can price
+// it, and is then raised to the highest matching floor. Rules are scoped by set, rarity and the
+// seller's listing tags.
+//
+// Every pass is recorded as a run: dry runs are reports of what would change, applied runs are
+// the log of what did. The last applied run, or just one rule's part of it, can be rolled back.
+package repricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/pricing"
+)
+
+var (
+	// ErrNotFound is returned when a rule or run doesn't exist
+	ErrNotFound = errors.New("repricing: not found")
+	// ErrInvalid is returned for a rule with an unknown kind or out-of-range amount
+	ErrInvalid = errors.New("repricing: invalid rule")
+	// ErrTooMany is returned when a seller already has the most rules allowed
+	ErrTooMany = errors.New("repricing: too many rules")
+	// ErrNothingToRollBack is returned when there is no applied change left to revert
+	ErrNothingToRollBack = errors.New("repricing: nothing to roll back")
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerSeller   = "seller"
+)
+
+// Store persists repricing rules and runs.
+type Store interface {
+	PutRule(ctx context.Context, r models.RepriceRule) error
+	GetRule(ctx context.Context, sellerID, ruleID string) (models.RepriceRule, error)
+	DeleteRule(ctx context.Context, sellerID, ruleID string) error
+	RulesBySeller(ctx context.Context, sellerID string) ([]models.RepriceRule, error)
+	// EnabledSellers returns the sellers with at least one enabled rule
+	EnabledSellers(ctx context.Context) ([]string, error)
+	PutRun(ctx context.Context, run models.RepriceRun) error
+	GetRun(ctx context.Context, sellerID, runID string) (models.RepriceRun, error)
+	// RunsBySeller returns a seller's runs, newest first
+	RunsBySeller(ctx context.Context, sellerID string) ([]models.RepriceRun, error)
+}
+
+// Options limits how many rules a seller may keep
+type Options struct {
+	MaxRules int
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	MaxRules: 50,
+}
+
+// Service manages repricing rules and runs them.
+type Service struct {
+	store    Store
+	catalog  *catalog.Catalog
+	pricing  *pricing.Service
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+}
+
+// New creates a repricing Service
+func New(store Store, c *catalog.Catalog, p *pricing.Service, opts Options, errorLog *log.Logger) *Service {
+	if opts.MaxRules <= 0 {
+		opts.MaxRules = DefaultOptions.MaxRules
+	}
+	return &Service{store: store, catalog: c, pricing: p, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// AddRule validates and stores a new rule. Rules start disabled so the seller can preview them.
+func (s *Service) AddRule(ctx context.Context, r models.RepriceRule) (models.RepriceRule, error) {
+	r.Name = strings.TrimSpace(r.Name)
+	r.SetCode = strings.ToUpper(strings.TrimSpace(r.SetCode))
+	r.Rarity = strings.ToLower(strings.TrimSpace(r.Rarity))
+	r.Tag = strings.ToLower(strings.TrimSpace(r.Tag))
+	if r.Kind == models.RepriceUndercut && r.AmountCents == 0 {
+		r.AmountCents = 1
+	}
+	if err := validate(r); err != nil {
+		return models.RepriceRule{}, err
+	}
+
+	existing, err := s.store.RulesBySeller(ctx, r.SellerID)
+	if err != nil {
+		return models.RepriceRule{}, err
+	}
+	if len(existing) >= s.opts.MaxRules {
+		return models.RepriceRule{}, ErrTooMany
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	r.RuleID = ids.New()
+	r.Type = models.ItemTypeRepriceRule
+	r.PK, r.SK = models.RepriceRuleKey(r.SellerID, r.RuleID)
+	r.Enabled = false
+	r.CreatedAt, r.UpdatedAt = now, now
+	if err := s.store.PutRule(ctx, r); err != nil {
+		return models.RepriceRule{}, err
+	}
+	return r, nil
+}
+
+// SetEnabled turns a rule on or off
+func (s *Service) SetEnabled(ctx context.Context, sellerID, ruleID string, enabled bool) (models.RepriceRule, error) {
+	r, err := s.store.GetRule(ctx, sellerID, ruleID)
+	if err != nil {
+		return models.RepriceRule{}, err
+	}
+	r.Enabled = enabled
+	r.GSI1PK, r.GSI1SK = "", ""
+	if enabled {
+		r.GSI1PK, r.GSI1SK = models.EnabledRepriceRuleKey(sellerID, ruleID)
+	}
+	r.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	if err := s.store.PutRule(ctx, r); err != nil {
+		return models.RepriceRule{}, err
+	}
+	return r, nil
+}
+
+// DeleteRule removes a rule. Runs it took part in keep their record of it.
+func (s *Service) DeleteRule(ctx context.Context, sellerID, ruleID string) error {
+	if _, err := s.store.GetRule(ctx, sellerID, ruleID); err != nil {
+		return err
+	}
+	return s.store.DeleteRule(ctx, sellerID, ruleID)
+}
+
+// Rules returns a seller's rules in the order they're applied
+func (s *Service) Rules(ctx context.Context, sellerID string) ([]models.RepriceRule, error) {
+	rules, err := s.store.RulesBySeller(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	sortRules(rules)
+	return rules, nil
+}
+
+// History returns a seller's runs, newest first
+func (s *Service) History(ctx context.Context, sellerID string) ([]models.RepriceRun, error) {
+	return s.store.RunsBySeller(ctx, sellerID)
+}
+
+// Report returns one of a seller's runs
+func (s *Service) Report(ctx context.Context, sellerID, runID string) (models.RepriceRun, error) {
+	return s.store.GetRun(ctx, sellerID, runID)
+}
+
+// RunAll applies the enabled rules of every seller that has any. Runs that change nothing and hit
+// no errors aren't recorded. It returns how many listings were repriced.
+func (s *Service) RunAll(ctx context.Context) (int, error) {
+	sellers, err := s.store.EnabledSellers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	repriced := 0
+	for _, sellerID := range sellers {
+		if err := ctx.Err(); err != nil {
+			return repriced, err
+		}
+		run, err := s.Reprice(ctx, sellerID, false, TriggerSchedule, "")
+		if err != nil {
+			s.errorLog.Printf("repricing seller %s failed: %v", sellerID, err)
+			continue
+		}
+		for _, c := range run.Changes {
+			if c.Error == "" {
+				repriced++
+			}
+		}
+	}
+	return repriced, nil
+}
+
+// Run applies every seller's enabled rules now and then on every tick until ctx is cancelled
+func (s *Service) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := s.RunAll(ctx); err != nil && ctx.Err() == nil {
+			s.errorLog.Printf("scheduled repricing failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// validate checks a rule's kind and amounts
+func validate(r models.RepriceRule) error {
+	switch r.Kind {
+	case models.RepriceMarketMinus:
+		if r.PercentBps < 0 || r.PercentBps >= 10000 {
+			return fmt.Errorf("%w: the discount must be from 0%% to under 100%%", ErrInvalid)
+		}
+	case models.RepriceUndercut:
+		if r.AmountCents < 0 {
+			return fmt.Errorf("%w: the undercut can't be negative", ErrInvalid)
+		}
+	case models.RepriceFloor:
+		if r.AmountCents <= 0 {
+			return fmt.Errorf("%w: the floor must be above zero", ErrInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: unknown rule kind %q", ErrInvalid, r.Kind)
+	}
+	if len(r.Name) > 80 {
+		return fmt.Errorf("%w: keep the name under 80 characters", ErrInvalid)
+	}
+	return nil
+}
+
+// sortRules orders rules by priority, then oldest first
+func sortRules(rules []models.RepriceRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority < rules[j].Priority
+		}
+		return rules[i].CreatedAt < rules[j].CreatedAt
+	})
+}
@@ -0,0 +1,283 @@
+package repricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+)
+
+// pass holds what one run has looked up so far
+type pass struct {
+	printings   map[string]models.Printing
+	competitors map[string][]models.Listing // printingID -> every listing of the printing
+}
+
+// Reprice runs a seller's enabled rules over their active listings, plus the rule named by
+// include even if it's disabled, so a seller can preview a rule before enabling it. A dry run
+// only records the report; otherwise the changes are applied and the run is the log of them.
+// A listing that can't be priced or saved is noted on the run and the rest carry on, so the
+// changes already applied are always logged. Scheduled runs that would change nothing and hit no
+// errors aren't recorded.
+func (s *Service) Reprice(ctx context.Context, sellerID string, dryRun bool, trigger, include string) (models.RepriceRun, error) {
+	all, err := s.store.RulesBySeller(ctx, sellerID)
+	if err != nil {
+		return models.RepriceRun{}, err
+	}
+	var rules []models.RepriceRule
+	for _, r := range all {
+		if r.Enabled || r.RuleID == include {
+			rules = append(rules, r)
+		}
+	}
+	sortRules(rules)
+
+	listings, err := s.catalog.ListingsBySeller(ctx, sellerID)
+	if err != nil {
+		return models.RepriceRun{}, err
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	run := models.RepriceRun{
+		Type:      models.ItemTypeRepriceRun,
+		RunID:     ids.New(),
+		SellerID:  sellerID,
+		Status:    models.RepriceRunApplied,
+		Trigger:   trigger,
+		StartedAt: now,
+	}
+	if dryRun {
+		run.Status = models.RepriceRunDryRun
+	}
+	run.PK, run.SK = models.RepriceRunKey(sellerID, now, run.RunID)
+
+	p := &pass{printings: map[string]models.Printing{}, competitors: map[string][]models.Listing{}}
+	for _, l := range listings {
+		if !l.IsActive() {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		run.Listings++
+		change, ok, err := s.price(ctx, p, rules, l)
+		if err != nil {
+			s.errorLog.Printf("repricing listing %s for seller %s failed: %v", l.ListingID, sellerID, err)
+			if run.Failed == 0 {
+				run.Error = err.Error()
+			}
+			run.Failed++
+			continue
+		}
+		if !ok {
+			run.Unchanged++
+			continue
+		}
+		if !dryRun {
+			l.PriceCents = change.NewCents
+			if _, err := s.catalog.SaveListing(ctx, l); errors.Is(err, catalog.ErrConflict) {
+				change.Error = "the listing changed during the run"
+			} else if err != nil {
+				s.errorLog.Printf("saving repriced listing %s failed: %v", l.ListingID, err)
+				change.Error = "the new price couldn't be saved"
+			}
+		}
+		run.Changes = append(run.Changes, change)
+	}
+
+	if trigger == TriggerSchedule && len(run.Changes) == 0 && run.Failed == 0 {
+		return run, ctx.Err()
+	}
+	// The run is written even once ctx is done, since its changes may already be applied
+	if err := s.store.PutRun(context.WithoutCancel(ctx), run); err != nil {
+		return models.RepriceRun{}, err
+	}
+	return run, ctx.Err()
+}
+
+// price works out a listing's new price under the rules. It reports false when the rules leave
+// the price as it is.
+func (s *Service) price(ctx context.Context, p *pass, rules []models.RepriceRule, l models.Listing) (models.RepriceChange, bool, error) {
+	printing, ok := p.printings[l.PrintingID]
+	if !ok {
+		var err error
+		printing, err = s.catalog.Printing(ctx, l.PrintingID)
+		if errors.Is(err, catalog.ErrNotFound) {
+			return models.RepriceChange{}, false, nil
+		}
+		if err != nil {
+			return models.RepriceChange{}, false, err
+		}
+		p.printings[l.PrintingID] = printing
+	}
+
+	change := models.RepriceChange{
+		ListingID:  l.ListingID,
+		PrintingID: l.PrintingID,
+		CardName:   printing.CardName,
+		Condition:  l.Condition,
+		OldCents:   l.PriceCents,
+		NewCents:   l.PriceCents,
+	}
+	priced := false
+	var floor int64
+	for _, r := range rules {
+		if !r.Matches(printing, l) {
+			continue
+		}
+		switch r.Kind {
+		case models.RepriceFloor:
+			if r.AmountCents > floor {
+				floor, change.FloorRuleID = r.AmountCents, r.RuleID
+			}
+		case models.RepriceMarketMinus, models.RepriceUndercut:
+			if priced {
+				continue
+			}
+			cents, reason, err := s.target(ctx, p, r, l)
+			if err != nil {
+				return models.RepriceChange{}, false, err
+			}
+			if reason == "" {
+				continue
+			}
+			change.NewCents, change.RuleID, change.Reason = cents, r.RuleID, reason
+			priced = true
+		}
+	}
+
+	if change.NewCents < floor {
+		change.NewCents = floor
+		change.Reason = fmt.Sprintf("raised to the %s floor", money.Format(floor))
+	} else {
+		change.FloorRuleID = ""
+	}
+	if change.NewCents < 1 {
+		change.NewCents = 1
+	}
+	return change, change.NewCents != change.OldCents, nil
+}
+
+// target returns the price a pricing rule sets for a listing, with the reason. The reason is
+// empty when the rule can't price the listing, such as when the card has no recent sales.
+func (s *Service) target(ctx context.Context, p *pass, r models.RepriceRule, l models.Listing) (int64, string, error) {
+	switch r.Kind {
+	case models.RepriceMarketMinus:
+		market, ok, err := s.pricing.MarketPrice(ctx, l.PrintingID, l.Condition)
+		if err != nil || !ok {
+			return 0, "", err
+		}
+		cents := (market*(10000-r.PercentBps) + 5000) / 10000
+		return cents, fmt.Sprintf("market price %s less %d.%02d%%", money.Format(market), r.PercentBps/100, r.PercentBps%100), nil
+
+	case models.RepriceUndercut:
+		others, ok := p.competitors[l.PrintingID]
+		if !ok {
+			var err error
+			others, err = s.catalog.ListingsByPrinting(ctx, l.PrintingID)
+			if err != nil {
+				return 0, "", err
+			}
+			p.competitors[l.PrintingID] = others
+		}
+		var lowest int64
+		for _, o := range others {
+			if o.SellerID == l.SellerID || !o.IsActive() || o.Condition != models.Conditions[0] || o.Foil != l.Foil {
+				continue
+			}
+			if lowest == 0 || o.PriceCents < lowest {
+				lowest = o.PriceCents
+			}
+		}
+		if lowest == 0 {
+			return 0, "", nil
+		}
+		return lowest - r.AmountCents, fmt.Sprintf("%s below the lowest NM listing at %s", money.Format(r.AmountCents), money.Format(lowest)), nil
+	}
+	return 0, "", nil
+}
+
+// Rollback reverts the seller's last applied run, or with a rule ID, that rule's changes in the
+// last applied run that has any. A listing whose price has moved since the run is left alone.
+// It returns the run and how many listings were reverted.
+func (s *Service) Rollback(ctx context.Context, sellerID, ruleID string) (models.RepriceRun, int, error) {
+	runs, err := s.store.RunsBySeller(ctx, sellerID)
+	if err != nil {
+		return models.RepriceRun{}, 0, err
+	}
+
+	var run models.RepriceRun
+	found := false
+	for _, r := range runs {
+		if r.Status != models.RepriceRunApplied {
+			continue
+		}
+		if ruleID == "" {
+			run, found = r, true
+			break
+		}
+		for _, c := range r.Changes {
+			if c.Touches(ruleID) && c.Error == "" && !c.RolledBack {
+				run, found = r, true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	if !found {
+		return models.RepriceRun{}, 0, ErrNothingToRollBack
+	}
+
+	reverted := 0
+	for i, c := range run.Changes {
+		if c.Error != "" || c.RolledBack || (ruleID != "" && !c.Touches(ruleID)) {
+			continue
+		}
+		l, err := s.catalog.Listing(ctx, c.ListingID)
+		if errors.Is(err, catalog.ErrNotFound) {
+			run.Changes[i].Error = "the listing no longer exists"
+			continue
+		}
+		if err != nil {
+			return models.RepriceRun{}, 0, err
+		}
+		if l.PriceCents != c.NewCents {
+			run.Changes[i].Error = "the price has changed since the run, so it was left as is"
+			continue
+		}
+		l.PriceCents = c.OldCents
+		if _, err := s.catalog.SaveListing(ctx, l); errors.Is(err, catalog.ErrConflict) {
+			run.Changes[i].Error = "the listing changed during the rollback"
+			continue
+		} else if err != nil {
+			return models.RepriceRun{}, 0, err
+		}
+		run.Changes[i].RolledBack = true
+		reverted++
+	}
+
+	// The run is rolled back once none of its changes is left to revert
+	done, some := true, false
+	for _, c := range run.Changes {
+		done = done && (c.RolledBack || c.Error != "")
+		some = some || c.RolledBack
+	}
+	if done && some {
+		run.Status = models.RepriceRunRolledBack
+		run.RolledBackAt = s.now().UTC().Format(time.RFC3339)
+	}
+	if err := s.store.PutRun(ctx, run); err != nil {
+		return models.RepriceRun{}, 0, err
+	}
+	if reverted == 0 {
+		return run, 0, ErrNothingToRollBack
+	}
+	return run, reverted, nil
+}
@@ -0,0 +1,64 @@
+package reviews
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. A review is stored in its order's partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Create stores a new review unless the order already has one
+func (s *DynamoStore) Create(ctx context.Context, r models.Review) error {
+	err := s.table.Put(ctx, r, dynamo.IfNotExists())
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrAlreadyReviewed
+	}
+	return err
+}
+
+// Get returns an order's review
+func (s *DynamoStore) Get(ctx context.Context, orderID string) (models.Review, error) {
+	var r models.Review
+	pk, sk := models.ReviewKey(orderID)
+	err := s.table.Get(ctx, pk, sk, &r)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return r, ErrNotFound
+	}
+	return r, err
+}
+
+// Update writes a review if its stored version is still expectedVersion
+func (s *DynamoStore) Update(ctx context.Context, r models.Review, expectedVersion int64) error {
+	err := s.table.Put(ctx, r, dynamo.IfVersion(expectedVersion))
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
+
+// BySeller returns a seller's reviews, newest first
+func (s *DynamoStore) BySeller(ctx context.Context, sellerID string) ([]models.Review, error) {
+	var out []models.Review
+	pk, _ := models.SellerReviewKey(sellerID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk, SKPrefix: "REVIEW#", Descending: true}, &out)
+	return out, err
+}
+
+// ByStatus returns the reviews in a status, oldest first
+func (s *DynamoStore) ByStatus(ctx context.Context, status string) ([]models.Review, error) {
+	var out []models.Review
+	pk, _ := models.ReviewStatusKey(status, "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI2, PK: pk}, &out)
+	return out, err
+}
@@ -0,0 +1,84 @@
+package reviews
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	reviews map[string]models.Review // orderID -> review
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{reviews: map[string]models.Review{}}
+}
+
+// Create stores a new review unless the order already has one
+func (s *MemoryStore) Create(ctx context.Context, r models.Review) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.reviews[r.OrderID]; ok {
+		return ErrAlreadyReviewed
+	}
+	s.reviews[r.OrderID] = r
+	return nil
+}
+
+// Get returns an order's review
+func (s *MemoryStore) Get(ctx context.Context, orderID string) (models.Review, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.reviews[orderID]
+	if !ok {
+		return r, ErrNotFound
+	}
+	return r, nil
+}
+
+// Update writes a review if its stored version is still expectedVersion
+func (s *MemoryStore) Update(ctx context.Context, r models.Review, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.reviews[r.OrderID]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.Version != expectedVersion {
+		return ErrConflict
+	}
+	s.reviews[r.OrderID] = r
+	return nil
+}
+
+// BySeller returns a seller's reviews, newest first
+func (s *MemoryStore) BySeller(ctx context.Context, sellerID string) ([]models.Review, error) {
+	list := s.filter(func(r models.Review) bool { return r.SellerID == sellerID })
+	sort.Slice(list, func(i, j int) bool { return list[i].GSI1SK > list[j].GSI1SK })
+	return list, nil
+}
+
+// ByStatus returns the reviews in a status, oldest first
+func (s *MemoryStore) ByStatus(ctx context.Context, status string) ([]models.Review, error) {
+	list := s.filter(func(r models.Review) bool { return r.Status == status })
+	sort.Slice(list, func(i, j int) bool { return list[i].GSI2SK < list[j].GSI2SK })
+	return list, nil
+}
+
+// filter returns copies of the reviews matching keep
+func (s *MemoryStore) filter(keep func(models.Review) bool) []models.Review {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Review
+	for _, r := range s.reviews {
+		if keep(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,353 @@
+// Package reviews lets buyers rate a seller once per completed order and keeps each seller's
+// recency-weighted score.
+//
+// A review rates the item as described, shipping speed and communication from 1 to 5 stars. The
+// seller can reply publicly. Anyone signed in can report a review as abusive, which puts it in
+// the admin moderation queue; hidden reviews stop counting toward the seller's score.
+package reviews
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+)
+
+var (
+	// ErrNotFound is returned when an order has no review
+	ErrNotFound = errors.New("reviews: not found")
+	// ErrConflict is returned when a review was changed concurrently
+	ErrConflict = errors.New("reviews: version conflict")
+	// ErrAlreadyReviewed is returned when the order already has a review
+	ErrAlreadyReviewed = errors.New("reviews: order already reviewed")
+	// ErrNotReviewable is returned when the order isn't completed yet
+	ErrNotReviewable = errors.New("reviews: only completed orders can be reviewed")
+	// ErrNotAllowed is returned when the actor may not take the action
+	ErrNotAllowed = errors.New("reviews: not allowed")
+	// ErrInvalid is returned for a missing or out-of-range rating, or text that is too long
+	ErrInvalid = errors.New("reviews: invalid review")
+)
+
+// MaxBodyLength caps the length of review and response text
+const MaxBodyLength = 2000
+
+// Store persists reviews.
+type Store interface {
+	// Create stores a new review, returning ErrAlreadyReviewed if the order has one
+	Create(ctx context.Context, r models.Review) error
+	Get(ctx context.Context, orderID string) (models.Review, error)
+	// Update writes a review conditional on expectedVersion
+	Update(ctx context.Context, r models.Review, expectedVersion int64) error
+	// BySeller returns a seller's reviews in every status, newest first
+	BySeller(ctx context.Context, sellerID string) ([]models.Review, error)
+	// ByStatus returns the reviews in a status, oldest first
+	ByStatus(ctx context.Context, status string) ([]models.Review, error)
+}
+
+// Options controls how seller scores are weighted and cached
+type Options struct {
+	HalfLife time.Duration // age at which a review counts half as much as a new one
+	CacheFor time.Duration // how long a computed score is reused before the weights are refreshed
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	HalfLife: 180 * 24 * time.Hour,
+	CacheFor: time.Hour,
+}
+
+// Ratings is what a buyer submits for an order
+type Ratings struct {
+	Item          int
+	Shipping      int
+	Communication int
+	Body          string
+}
+
+// cachedScore is a computed score and when it was computed
+type cachedScore struct {
+	score models.SellerScore
+	at    time.Time
+}
+
+// Service records reviews and computes seller scores.
+type Service struct {
+	store    Store
+	orders   *orders.Service
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+
+	mu     sync.Mutex
+	scores map[string]cachedScore
+}
+
+// New creates a reviews Service
+func New(store Store, o *orders.Service, opts Options, errorLog *log.Logger) *Service {
+	if opts.HalfLife <= 0 {
+		opts.HalfLife = DefaultOptions.HalfLife
+	}
+	if opts.CacheFor <= 0 {
+		opts.CacheFor = DefaultOptions.CacheFor
+	}
+	return &Service{
+		store:    store,
+		orders:   o,
+		opts:     opts,
+		errorLog: errorLog,
+		now:      time.Now,
+		scores:   map[string]cachedScore{},
+	}
+}
+
+// CanReview reports whether a buyer can review an order now
+func (s *Service) CanReview(ctx context.Context, o models.Order, buyerID string) error {
+	if o.BuyerID != buyerID {
+		return ErrNotAllowed
+	}
+	if o.Status != models.OrderStatusCompleted {
+		return ErrNotReviewable
+	}
+	if _, err := s.store.Get(ctx, o.OrderID); err == nil {
+		return ErrAlreadyReviewed
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Submit records the buyer's review of a completed order
+func (s *Service) Submit(ctx context.Context, o models.Order, buyerID string, in Ratings) (models.Review, error) {
+	for _, stars := range []int{in.Item, in.Shipping, in.Communication} {
+		if stars < 1 || stars > 5 {
+			return models.Review{}, fmt.Errorf("%w: ratings must be between 1 and 5 stars", ErrInvalid)
+		}
+	}
+	body := strings.TrimSpace(in.Body)
+	if len(body) > MaxBodyLength {
+		return models.Review{}, fmt.Errorf("%w: keep it under %d characters", ErrInvalid, MaxBodyLength)
+	}
+	if err := s.CanReview(ctx, o, buyerID); err != nil {
+		return models.Review{}, err
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	r := models.Review{
+		OrderID:             o.OrderID,
+		BuyerID:             o.BuyerID,
+		SellerID:            o.SellerID,
+		ItemRating:          in.Item,
+		ShippingRating:      in.Shipping,
+		CommunicationRating: in.Communication,
+		Body:                body,
+		Status:              models.ReviewStatusPublished,
+		Version:             1,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+	setKeys(&r)
+
+	if err := s.store.Create(ctx, r); err != nil {
+		return r, err
+	}
+	s.invalidate(r.SellerID)
+	return r, nil
+}
+
+// ForOrder returns an order's review
+func (s *Service) ForOrder(ctx context.Context, orderID string) (models.Review, error) {
+	return s.store.Get(ctx, orderID)
+}
+
+// ForSeller returns a seller's visible reviews, newest first
+func (s *Service) ForSeller(ctx context.Context, sellerID string) ([]models.Review, error) {
+	list, err := s.store.BySeller(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	out := list[:0]
+	for _, r := range list {
+		if r.Visible() {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// Respond sets the seller's public reply to a review, replacing any earlier reply
+func (s *Service) Respond(ctx context.Context, orderID, sellerID, body string) (models.Review, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || len(body) > MaxBodyLength {
+		return models.Review{}, fmt.Errorf("%w: write a reply under %d characters", ErrInvalid, MaxBodyLength)
+	}
+	return s.update(ctx, orderID, func(r *models.Review) error {
+		if r.SellerID != sellerID {
+			return ErrNotAllowed
+		}
+		r.Response = body
+		r.RespondedAt = r.UpdatedAt
+		return nil
+	})
+}
+
+// Report flags a review as abusive for an admin to look at. Reviews an admin already kept or
+// hid can't be reported again.
+func (s *Service) Report(ctx context.Context, orderID string, actor orders.Actor, reason string) (models.Review, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return models.Review{}, fmt.Errorf("%w: say what's wrong with the review", ErrInvalid)
+	}
+	return s.update(ctx, orderID, func(r *models.Review) error {
+		if r.Status != models.ReviewStatusPublished || r.ModeratedBy != "" {
+			return nil
+		}
+		r.Status = models.ReviewStatusFlagged
+		r.FlagReason = reason
+		r.FlaggedBy = actor.String()
+		return nil
+	})
+}
+
+// Moderate settles a review in the moderation queue: hide removes it from the seller's page and
+// score, otherwise it is published again
+func (s *Service) Moderate(ctx context.Context, orderID string, actor orders.Actor, hide bool, note string) (models.Review, error) {
+	if actor.Kind != orders.ActorAdmin {
+		return models.Review{}, ErrNotAllowed
+	}
+	r, err := s.update(ctx, orderID, func(r *models.Review) error {
+		r.Status = models.ReviewStatusPublished
+		if hide {
+			r.Status = models.ReviewStatusHidden
+		}
+		r.ModeratedBy = actor.String()
+		r.ModerationNote = strings.TrimSpace(note)
+		return nil
+	})
+	if err == nil {
+		s.invalidate(r.SellerID)
+	}
+	return r, err
+}
+
+// Queue returns the reviews waiting for moderation, oldest first
+func (s *Service) Queue(ctx context.Context) ([]models.Review, error) {
+	return s.store.ByStatus(ctx, models.ReviewStatusFlagged)
+}
+
+// Hidden returns the reviews admins have hidden, oldest first
+func (s *Service) Hidden(ctx context.Context) ([]models.Review, error) {
+	return s.store.ByStatus(ctx, models.ReviewStatusHidden)
+}
+
+// Score returns a seller's recency-weighted score. Each review's weight halves every HalfLife,
+// so a seller's recent orders matter more than how they did years ago.
+func (s *Service) Score(ctx context.Context, sellerID string) (models.SellerScore, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	c, ok := s.scores[sellerID]
+	s.mu.Unlock()
+	if ok && now.Sub(c.at) < s.opts.CacheFor {
+		return c.score, nil
+	}
+
+	list, err := s.ForSeller(ctx, sellerID)
+	if err != nil {
+		return models.SellerScore{SellerID: sellerID}, err
+	}
+	score := Weighted(sellerID, list, now, s.opts.HalfLife)
+
+	s.mu.Lock()
+	s.scores[sellerID] = cachedScore{score: score, at: now}
+	s.mu.Unlock()
+	return score, nil
+}
+
+// Rating returns a seller's overall score, or 0 if they have no reviews. It matches
+// search.SellerRatingFunc.
+func (s *Service) Rating(sellerID string) float64 {
+	score, err := s.Score(context.Background(), sellerID)
+	if err != nil {
+		s.errorLog.Printf("scoring seller %s failed: %v", sellerID, err)
+	}
+	return score.Overall
+}
+
+// Weighted averages reviews with exponentially decaying weights by age
+func Weighted(sellerID string, list []models.Review, now time.Time, halfLife time.Duration) models.SellerScore {
+	score := models.SellerScore{SellerID: sellerID, Count: len(list)}
+
+	var total, item, shipping, comms float64
+	for _, r := range list {
+		created, err := time.Parse(time.RFC3339, r.CreatedAt)
+		if err != nil {
+			continue
+		}
+		age := now.Sub(created)
+		if age < 0 {
+			age = 0
+		}
+		w := math.Pow(0.5, float64(age)/float64(halfLife))
+		total += w
+		item += w * float64(r.ItemRating)
+		shipping += w * float64(r.ShippingRating)
+		comms += w * float64(r.CommunicationRating)
+	}
+	if total == 0 {
+		return score
+	}
+
+	score.Item = round(item / total)
+	score.Shipping = round(shipping / total)
+	score.Communication = round(comms / total)
+	score.Overall = round((item + shipping + comms) / (3 * total))
+	return score
+}
+
+// round keeps two decimal places
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// update applies a change to a review and writes it with a version check
+func (s *Service) update(ctx context.Context, orderID string, mutate func(*models.Review) error) (models.Review, error) {
+	r, err := s.store.Get(ctx, orderID)
+	if err != nil {
+		return r, err
+	}
+
+	expected := r.Version
+	r.UpdatedAt = s.now().UTC().Format(time.RFC3339)
+	if err := mutate(&r); err != nil {
+		return r, err
+	}
+	r.Version++
+	setKeys(&r)
+
+	if err := s.store.Update(ctx, r, expected); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// invalidate drops a seller's cached score after their reviews change
+func (s *Service) invalidate(sellerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.scores, sellerID)
+}
+
+// setKeys fills in the single-table keys for a review
+func setKeys(r *models.Review) {
+	r.PK, r.SK = models.ReviewKey(r.OrderID)
+	r.GSI1PK, r.GSI1SK = models.SellerReviewKey(r.SellerID, r.CreatedAt, r.OrderID)
+	r.GSI2PK, r.GSI2SK = models.ReviewStatusKey(r.Status, r.CreatedAt, r.OrderID)
+	r.Type = models.ItemTypeReview
+}
@@ -0,0 +1,367 @@
+// Package search provides an embedded full-text index over catalog printings, sealed products and
+// active listings.
+package search
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// Document is a denormalized listing joined with its printing or sealed product, as stored in the index
+type Document struct {
+	ListingID       string `json:"listingId"`
+	PrintingID      string `json:"printingId,omitempty"`
+	ProductID       string `json:"productId,omitempty"`
+	Category        string `json:"category"`
+	ProductType     string `json:"productType,omitempty"`
+	SellerID        string `json:"sellerId"`
+	CardName        string `json:"cardName"` // the product's name for sealed product
+	Game            string `json:"game"`
+	SetCode         string `json:"setCode"`
+	SetName         string `json:"setName"`
+	CollectorNumber string `json:"collectorNumber"`
+	Rarity          string `json:"rarity"`
+	ImageURL        string `json:"imageUrl"`
+	Condition       string `json:"condition"` // a grade label such as "PSA 10" for a graded card
+	Graded          bool   `json:"graded"`
+	Grader          string `json:"grader,omitempty"`
+	Grade           string `json:"grade,omitempty"`
+	CertVerified    bool   `json:"certVerified,omitempty"`
+	Language        string `json:"language"`
+	Foil            bool   `json:"foil"`
+	PriceCents      int64  `json:"priceCents"`
+	Quantity        int    `json:"quantity"`
+	AcceptsOffers   bool   `json:"acceptsOffers"`
+	CreatedAt       string `json:"createdAt"`
+}
+
+// text is the document's searchable text: the card or product name, the set, and for sealed
+// product its type, so "booster box" finds boxes whatever they're called
+func (d *Document) text() string {
+	return d.CardName + " " + d.SetName + " " + d.SetCode + " " + models.ProductTypeNames[d.ProductType]
+}
+
+// SellerRatingFunc returns a seller's aggregate rating on a 0-5 scale
+type SellerRatingFunc func(sellerID string) float64
+
+// Index is an in-process inverted index of active listings keyed by card-name terms.
+type Index struct {
+	mu        sync.RWMutex
+	docs      map[string]*Document           // listingID -> document
+	printings map[string]models.Printing     // printingID -> printing
+	products  map[string]models.Product      // productID -> sealed product
+	listings  map[string]models.Listing      // listingID -> source listing, kept to re-join on catalog changes
+	terms     map[string]map[string]struct{} // term -> listingIDs
+	ratings   SellerRatingFunc
+}
+
+// NewIndex creates an empty index
+func NewIndex() *Index {
+	return &Index{
+		docs:      map[string]*Document{},
+		printings: map[string]models.Printing{},
+		products:  map[string]models.Product{},
+		listings:  map[string]models.Listing{},
+		terms:     map[string]map[string]struct{}{},
+		ratings:   func(string) float64 { return 0 },
+	}
+}
+
+// SetSellerRatings sets the function used for the seller rating facet and filter
+func (ix *Index) SetSellerRatings(fn SellerRatingFunc) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.ratings = fn
+}
+
+// Rebuild loads every printing, product and listing from the catalog into the index
+func (ix *Index) Rebuild(ctx context.Context, c *catalog.Catalog) error {
+	printings, err := c.Printings(ctx)
+	if err != nil {
+		return err
+	}
+	products, err := c.Products(ctx)
+	if err != nil {
+		return err
+	}
+	listings, err := c.Listings(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range printings {
+		ix.IndexPrinting(p)
+	}
+	for _, p := range products {
+		ix.IndexProduct(p)
+	}
+	for _, l := range listings {
+		ix.IndexListing(l)
+	}
+	return nil
+}
+
+// Refresh rebuilds the index from the catalog and swaps it in, dropping anything the catalog no
+// longer has. Searches keep using the old contents until the new ones are ready.
+func (ix *Index) Refresh(ctx context.Context, c *catalog.Catalog) error {
+	fresh := NewIndex()
+	if err := fresh.Rebuild(ctx, c); err != nil {
+		return err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.docs, ix.printings, ix.products = fresh.docs, fresh.printings, fresh.products
+	ix.listings, ix.terms = fresh.listings, fresh.terms
+	return nil
+}
+
+// Follow refreshes the index every interval until ctx is done. Listing events reach one process's
+// subscriber only, so every web process follows the catalog itself to pick up the changes
+// published elsewhere.
+func (ix *Index) Follow(ctx context.Context, c *catalog.Catalog, every time.Duration, errorLog *log.Logger) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := ix.Refresh(ctx, c); err != nil && ctx.Err() == nil {
+			errorLog.Printf("refreshing the search index failed: %v", err)
+		}
+	}
+}
+
+// Attach subscribes the index to catalog changes so it stays current incrementally. Printings and
+// products are indexed as they're written; listings follow their events in the outbox, and each
+// event re-reads the listing so one published out of order can't index a stale copy.
+func (ix *Index) Attach(c *catalog.Catalog, relay *outbox.Relay) {
+	c.OnPrintingChange(func(ctx context.Context, p models.Printing) {
+		ix.IndexPrinting(p)
+	})
+	c.OnProductChange(func(ctx context.Context, p models.Product) {
+		ix.IndexProduct(p)
+	})
+	relay.Subscribe("search", func(ctx context.Context, ev models.DomainEvent) error {
+		l, err := c.Listing(ctx, ev.AggregateID)
+		if errors.Is(err, catalog.ErrNotFound) {
+			ix.Remove(ev.AggregateID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ix.IndexListing(l)
+		return nil
+	}, "listing.*")
+}
+
+// IndexPrinting adds or updates a printing and re-joins any listings that reference it
+func (ix *Index) IndexPrinting(p models.Printing) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.printings[p.PrintingID] = p
+	for _, l := range ix.listings {
+		if l.PrintingID == p.PrintingID {
+			ix.put(l)
+		}
+	}
+}
+
+// IndexProduct adds or updates a sealed product and re-joins any listings that reference it
+func (ix *Index) IndexProduct(p models.Product) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.products[p.ProductID] = p
+	for _, l := range ix.listings {
+		if l.ProductID == p.ProductID {
+			ix.put(l)
+		}
+	}
+}
+
+// IndexListing adds, updates or removes a listing depending on whether it is active
+func (ix *Index) IndexListing(l models.Listing) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if !l.IsActive() {
+		ix.remove(l.ListingID)
+		return
+	}
+	ix.listings[l.ListingID] = l
+	ix.put(l)
+}
+
+// Remove deletes a listing from the index
+func (ix *Index) Remove(listingID string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(listingID)
+}
+
+// Len returns the number of indexed listings
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// put joins a listing with its printing or product and (re)writes its terms. Callers hold the lock.
+func (ix *Index) put(l models.Listing) {
+	doc := &Document{
+		ListingID:     l.ListingID,
+		Category:      l.Category(),
+		SellerID:      l.SellerID,
+		Condition:     l.Condition,
+		Language:      l.Language,
+		Foil:          l.Foil,
+		PriceCents:    l.PriceCents,
+		Quantity:      l.Quantity,
+		AcceptsOffers: l.AcceptsOffers,
+		CreatedAt:     l.CreatedAt,
+	}
+	if l.IsSealed() {
+		p, ok := ix.products[l.ProductID]
+		if !ok {
+			// the product has not been indexed yet; IndexProduct will join it later
+			ix.unindexTerms(l.ListingID)
+			delete(ix.docs, l.ListingID)
+			return
+		}
+		doc.ProductID, doc.ProductType = p.ProductID, p.ProductType
+		doc.CardName, doc.Game, doc.SetCode, doc.SetName, doc.ImageURL = p.Name, p.Game, p.SetCode, p.SetName, p.ImageURL
+	} else {
+		p, ok := ix.printings[l.PrintingID]
+		if !ok {
+			// the printing has not been indexed yet; IndexPrinting will join it later
+			ix.unindexTerms(l.ListingID)
+			delete(ix.docs, l.ListingID)
+			return
+		}
+		doc.PrintingID, doc.CardName, doc.Game, doc.SetCode, doc.SetName = p.PrintingID, p.CardName, p.Game, p.SetCode, p.SetName
+		doc.CollectorNumber, doc.Rarity, doc.ImageURL = p.CollectorNumber, p.Rarity, p.ImageURL
+	}
+
+	ix.unindexTerms(l.ListingID)
+	if g := l.Grading; g != nil {
+		doc.Graded, doc.Grader, doc.Grade, doc.CertVerified = true, g.Company, g.Grade, g.VerifiedAt != ""
+	}
+	ix.docs[l.ListingID] = doc
+
+	for _, t := range Tokenize(doc.text()) {
+		set, ok := ix.terms[t]
+		if !ok {
+			set = map[string]struct{}{}
+			ix.terms[t] = set
+		}
+		set[l.ListingID] = struct{}{}
+	}
+}
+
+// remove drops a listing and its terms. Callers hold the lock.
+func (ix *Index) remove(listingID string) {
+	ix.unindexTerms(listingID)
+	delete(ix.docs, listingID)
+	delete(ix.listings, listingID)
+}
+
+// unindexTerms removes a listing from every term posting it appears in. Callers hold the lock.
+func (ix *Index) unindexTerms(listingID string) {
+	doc, ok := ix.docs[listingID]
+	if !ok {
+		return
+	}
+	for _, t := range Tokenize(doc.text()) {
+		if set, ok := ix.terms[t]; ok {
+			delete(set, listingID)
+			if len(set) == 0 {
+				delete(ix.terms, t)
+			}
+		}
+	}
+}
+
+// foldReplacer strips the accents that commonly appear in card names
+var foldReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ñ", "n", "ç", "c", "æ", "ae", "œ", "oe",
+)
+
+// Tokenize lowercases, folds accents and splits text into index terms
+func Tokenize(s string) []string {
+	s = foldReplacer.Replace(strings.ToLower(s))
+	s = strings.ReplaceAll(s, "'", "")
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := map[string]bool{}
+	out := fields[:0]
+	for _, f := range fields {
+		if !seen[f] {
+			seen[f] = true
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// maxEdits is the edit distance tolerated for a query term of the given length
+func maxEdits(n int) int {
+	switch {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// levenshtein returns the edit distance between a and b, giving up once it exceeds limit
+func levenshtein(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,402 @@
+package search
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/money"
+)
+
+// Sort orders
+const (
+	SortRelevance = "relevance"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	SortNewest    = "newest"
+)
+
+const (
+	defaultLimit = 24
+	maxLimit     = 100
+)
+
+// Query describes a search request
+type Query struct {
+	Text            string
+	Categories      []string // single or sealed
+	ProductTypes    []string
+	Games           []string
+	Sets            []string
+	Rarities        []string
+	Conditions      []string // raw conditions; a graded card's condition is its grade label
+	Languages       []string
+	Foil            *bool
+	Graded          *bool
+	Graders         []string
+	Grades          []string
+	MinPriceCents   int64
+	MaxPriceCents   int64 // zero means no upper bound
+	MinSellerRating float64
+	Sort            string
+	Cursor          string
+	Limit           int
+}
+
+// Hit is a single search result
+type Hit struct {
+	Document
+	SellerRating float64 `json:"sellerRating"`
+	Score        float64 `json:"score"`
+}
+
+// FacetCount is the number of hits carrying a facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Result is the response to a Query
+type Result struct {
+	Hits       []Hit                   `json:"hits"`
+	Total      int                     `json:"total"`
+	Facets     map[string][]FacetCount `json:"facets"`
+	NextCursor string                  `json:"nextCursor,omitempty"`
+}
+
+// cursor is the keyset position of the last hit on a page
+type cursor struct {
+	Score   float64 `json:"s,omitempty"`
+	Price   int64   `json:"p,omitempty"`
+	Created string  `json:"c,omitempty"`
+	ID      string  `json:"id"`
+}
+
+// ParseQuery builds a Query from URL parameters
+func ParseQuery(v url.Values) Query {
+	q := Query{
+		Text:         strings.TrimSpace(v.Get("q")),
+		Categories:   nonEmpty(v["category"]),
+		ProductTypes: nonEmpty(v["product_type"]),
+		Games:        nonEmpty(v["game"]),
+		Sets:         nonEmpty(v["set"]),
+		Rarities:     nonEmpty(v["rarity"]),
+		Conditions:   nonEmpty(v["condition"]),
+		Languages:    nonEmpty(v["language"]),
+		Graders:      nonEmpty(v["grader"]),
+		Grades:       nonEmpty(v["grade"]),
+		Sort:         v.Get("sort"),
+		Cursor:       v.Get("cursor"),
+	}
+
+	q.Foil = parseBool(v.Get("foil"))
+	q.Graded = parseBool(v.Get("graded"))
+
+	if c, err := money.Parse(v.Get("min_price")); err == nil && c > 0 {
+		q.MinPriceCents = c
+	}
+	if c, err := money.Parse(v.Get("max_price")); err == nil && c > 0 {
+		q.MaxPriceCents = c
+	}
+	if r, err := strconv.ParseFloat(v.Get("min_rating"), 64); err == nil {
+		q.MinSellerRating = r
+	}
+	if n, err := strconv.Atoi(v.Get("limit")); err == nil {
+		q.Limit = n
+	}
+	return q
+}
+
+// Search runs a query against the index
+func (ix *Index) Search(q Query) Result {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+	if q.Sort == "" {
+		q.Sort = SortNewest
+		if q.Text != "" {
+			q.Sort = SortRelevance
+		}
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	scores, textFiltered := ix.match(q.Text)
+
+	ratings := map[string]float64{}
+	var hits []Hit
+	for id, doc := range ix.docs {
+		score := 0.0
+		if textFiltered {
+			s, ok := scores[id]
+			if !ok {
+				continue
+			}
+			score = s
+		}
+
+		rating, ok := ratings[doc.SellerID]
+		if !ok {
+			rating = ix.ratings(doc.SellerID)
+			ratings[doc.SellerID] = rating
+		}
+
+		if !q.matches(doc, rating) {
+			continue
+		}
+		hits = append(hits, Hit{Document: *doc, SellerRating: rating, Score: score})
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return less(q.Sort, keyOf(hits[i]), keyOf(hits[j])) })
+
+	res := Result{Total: len(hits), Facets: facets(hits)}
+
+	if after, ok := decodeCursor(q.Cursor); ok {
+		start := sort.Search(len(hits), func(i int) bool { return less(q.Sort, after, keyOf(hits[i])) })
+		hits = hits[start:]
+	}
+	if len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+		res.NextCursor = encodeCursor(keyOf(hits[len(hits)-1]))
+	}
+	res.Hits = hits
+	return res
+}
+
+// match scores documents against the query text. The bool is false when there is no text to match.
+// Every query term must match some index term exactly, by prefix, or within the fuzzy edit distance.
+func (ix *Index) match(text string) (map[string]float64, bool) {
+	qterms := Tokenize(text)
+	if len(qterms) == 0 {
+		return nil, false
+	}
+
+	var scores map[string]float64
+	for _, qt := range qterms {
+		termScores := map[string]float64{}
+		limit := maxEdits(len([]rune(qt)))
+
+		for term, postings := range ix.terms {
+			var s float64
+			switch {
+			case term == qt:
+				s = 3
+			case strings.HasPrefix(term, qt):
+				s = 2
+			case limit > 0:
+				d := levenshtein(qt, term, limit)
+				if d > limit {
+					continue
+				}
+				s = 1.5 - 0.5*float64(d)
+			default:
+				continue
+			}
+			for id := range postings {
+				if s > termScores[id] {
+					termScores[id] = s
+				}
+			}
+		}
+
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for id := range scores {
+			s, ok := termScores[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += s
+		}
+	}
+	return scores, true
+}
+
+// matches applies the non-text filters
+func (q Query) matches(d *Document, sellerRating float64) bool {
+	switch {
+	case !in(q.Categories, d.Category),
+		len(q.ProductTypes) > 0 && (d.ProductType == "" || !in(q.ProductTypes, d.ProductType)),
+		!in(q.Games, d.Game),
+		!in(q.Sets, d.SetCode),
+		!in(q.Rarities, d.Rarity),
+		!in(q.Conditions, d.Condition),
+		!in(q.Languages, d.Language),
+		q.Foil != nil && *q.Foil != d.Foil,
+		q.Graded != nil && *q.Graded != d.Graded,
+		len(q.Graders) > 0 && (!d.Graded || !in(q.Graders, d.Grader)),
+		len(q.Grades) > 0 && (!d.Graded || !in(q.Grades, d.Grade)),
+		d.PriceCents < q.MinPriceCents,
+		q.MaxPriceCents > 0 && d.PriceCents > q.MaxPriceCents,
+		sellerRating < q.MinSellerRating:
+		return false
+	}
+	return true
+}
+
+// keyOf extracts the sort key of a hit
+func keyOf(h Hit) cursor {
+	return cursor{Score: h.Score, Price: h.PriceCents, Created: h.CreatedAt, ID: h.ListingID}
+}
+
+// less orders two keys for the given sort, with listing ID as the final tiebreaker
+func less(order string, a, b cursor) bool {
+	switch order {
+	case SortPriceAsc:
+		if a.Price != b.Price {
+			return a.Price < b.Price
+		}
+	case SortPriceDesc:
+		if a.Price != b.Price {
+			return a.Price > b.Price
+		}
+	case SortRelevance:
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Price != b.Price {
+			return a.Price < b.Price
+		}
+	default:
+		if a.Created != b.Created {
+			return a.Created > b.Created
+		}
+	}
+	return a.ID < b.ID
+}
+
+// encodeCursor serializes a sort key into an opaque cursor string
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor string; ok is false for an empty or malformed cursor
+func decodeCursor(s string) (cursor, bool) {
+	var c cursor
+	if s == "" {
+		return c, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, false
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return c, false
+	}
+	return c, true
+}
+
+// priceBands are the buckets used for the price facet, in cents
+var priceBands = []struct {
+	label    string
+	min, max int64
+}{
+	{"under-1", 0, 99},
+	{"1-5", 100, 499},
+	{"5-20", 500, 1999},
+	{"20-100", 2000, 9999},
+	{"100-plus", 10000, -1},
+}
+
+// facets counts facet values over the matching hits
+func facets(hits []Hit) map[string][]FacetCount {
+	counts := map[string]map[string]int{
+		"category": {}, "product_type": {}, "game": {}, "set": {}, "rarity": {}, "condition": {}, "language": {},
+		"foil": {}, "graded": {}, "grader": {}, "grade": {}, "price": {}, "seller_rating": {},
+	}
+
+	for _, h := range hits {
+		counts["category"][h.Category]++
+		counts["product_type"][h.ProductType]++
+		counts["game"][h.Game]++
+		counts["set"][h.SetCode]++
+		counts["rarity"][h.Rarity]++
+		if h.Graded {
+			counts["grader"][h.Grader]++
+			counts["grade"][h.Grade]++
+		} else {
+			counts["condition"][h.Condition]++
+		}
+		counts["language"][h.Language]++
+		counts["foil"][strconv.FormatBool(h.Foil)]++
+		counts["graded"][strconv.FormatBool(h.Graded)]++
+		for _, b := range priceBands {
+			if h.PriceCents >= b.min && (b.max < 0 || h.PriceCents <= b.max) {
+				counts["price"][b.label]++
+				break
+			}
+		}
+		for stars := 4; stars >= 1; stars-- {
+			if h.SellerRating >= float64(stars) {
+				counts["seller_rating"][strconv.Itoa(stars)+"-plus"]++
+			}
+		}
+	}
+
+	out := map[string][]FacetCount{}
+	for name, values := range counts {
+		fc := make([]FacetCount, 0, len(values))
+		for v, n := range values {
+			if v == "" {
+				continue
+			}
+			fc = append(fc, FacetCount{Value: v, Count: n})
+		}
+		sort.Slice(fc, func(i, j int) bool {
+			if fc[i].Count != fc[j].Count {
+				return fc[i].Count > fc[j].Count
+			}
+			return fc[i].Value < fc[j].Value
+		})
+		out[name] = fc
+	}
+	return out
+}
+
+// in reports whether v is allowed by a filter list; an empty list allows everything
+func in(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBool reads a yes or no filter, nil when it is unset or unrecognised
+func parseBool(s string) *bool {
+	var b bool
+	switch s {
+	case "true", "1", "yes":
+		b = true
+	case "false", "0", "no":
+	default:
+		return nil
+	}
+	return &b
+}
+
+// nonEmpty drops blank values from a multi-valued parameter
+func nonEmpty(vals []string) []string {
+	var out []string
+	for _, v := range vals {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,45 @@
+package sellers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// DynamoStore is a Store over the marketplace table
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Get returns a seller profile by seller ID
+func (s *DynamoStore) Get(ctx context.Context, sellerID string) (models.SellerProfile, error) {
+	var p models.SellerProfile
+	pk, sk := models.SellerProfileKey(sellerID)
+	err := s.table.Get(ctx, pk, sk, &p)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+// Put stores a seller profile and its events in one transaction if its stored version is still
+// expectedVersion, 0 for a profile that doesn't exist yet
+func (s *DynamoStore) Put(ctx context.Context, p models.SellerProfile, expectedVersion int64, out []models.DomainEvent) error {
+	cond := dynamo.IfVersion(expectedVersion)
+	if expectedVersion == 0 {
+		cond = dynamo.IfNotExists()
+	}
+	err := s.table.Write(ctx, append([]dynamo.Op{dynamo.PutOp(p, cond)}, outbox.PutOps(out)...)...)
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrConflict
+	}
+	return err
+}
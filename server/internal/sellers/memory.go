@@ -0,0 +1,46 @@
+package sellers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	profiles map[string]models.SellerProfile
+	outbox   *outbox.MemoryStore
+}
+
+// NewMemoryStore creates an empty MemoryStore that writes domain events to ob, or drops them when
+// ob is nil
+func NewMemoryStore(ob *outbox.MemoryStore) *MemoryStore {
+	return &MemoryStore{profiles: map[string]models.SellerProfile{}, outbox: ob}
+}
+
+// Get returns a seller profile by seller ID
+func (s *MemoryStore) Get(ctx context.Context, sellerID string) (models.SellerProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.profiles[sellerID]
+	if !ok {
+		return models.SellerProfile{}, ErrNotFound
+	}
+	return p, nil
+}
+
+// Put stores a seller profile and its events if its stored version is still expectedVersion, 0
+// for a profile that doesn't exist yet
+func (s *MemoryStore) Put(ctx context.Context, p models.SellerProfile, expectedVersion int64, out []models.DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.profiles[p.SellerID].Version != expectedVersion {
+		return ErrConflict
+	}
+	s.profiles[p.SellerID] = p
+	s.outbox.Append(out...)
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package sellers stores seller profiles: marketplace settings for users who sell. Saving a
+// user's first profile gives them the seller role, and that and every change of risk tier are
+// written to the outbox as domain events with the profile.
+package sellers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+var (
+	// ErrNotFound is returned when a seller has no stored profile
+	ErrNotFound = errors.New("sellers: not found")
+	// ErrConflict is returned when a profile was changed concurrently
+	ErrConflict = errors.New("sellers: version conflict")
+)
+
+// Store persists seller profiles.
+type Store interface {
+	Get(ctx context.Context, sellerID string) (models.SellerProfile, error)
+	// Put writes a profile and its domain events together if the stored profile's version is
+	// still expectedVersion, 0 when there is none yet
+	Put(ctx context.Context, p models.SellerProfile, expectedVersion int64, out []models.DomainEvent) error
+}
+
+// TierChange is the payload of a seller's domain events. Previous is empty when the seller has
+// just registered.
+type TierChange struct {
+	Profile  models.SellerProfile
+	Previous string
+}
+
+// Service reads and writes seller profiles.
+type Service struct {
+	store Store
+}
+
+// New creates a seller profile Service
+func New(store Store) *Service {
+	return &Service{store: store}
+}
+
+// Profile returns a seller's profile, or the defaults for a seller who has never saved one
+func (s *Service) Profile(ctx context.Context, sellerID string) (models.SellerProfile, error) {
+	p, err := s.store.Get(ctx, sellerID)
+	if errors.Is(err, ErrNotFound) {
+		return models.SellerProfile{SellerID: sellerID, RiskTier: models.RiskTierStandard}, nil
+	}
+	return p, err
+}
+
+// Save creates or updates a seller profile. p.Version must be the version that was read, 0 for
+// a new seller; ErrConflict is returned if the profile has changed since.
+func (s *Service) Save(ctx context.Context, p models.SellerProfile) (models.SellerProfile, error) {
+	previous, err := s.store.Get(ctx, p.SellerID)
+	if errors.Is(err, ErrNotFound) {
+		previous = models.SellerProfile{}
+	} else if err != nil {
+		return p, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if p.CreatedAt == "" {
+		p.CreatedAt = now
+	}
+	if p.RiskTier == "" {
+		p.RiskTier = models.RiskTierStandard
+	}
+	expected := p.Version
+	p.Version++
+	p.UpdatedAt = now
+	p.PK, p.SK = models.SellerProfileKey(p.SellerID)
+	p.Type = models.ItemTypeSeller
+
+	var out []models.DomainEvent
+	name := ""
+	switch {
+	case previous.Version == 0:
+		name = models.EventSellerRegistered
+	case previous.RiskTier != p.RiskTier:
+		name = models.EventSellerTierChanged
+	}
+	if name != "" {
+		ev, err := outbox.NewEvent(name, "seller", p.SellerID, p.Version, now, TierChange{Profile: p, Previous: previous.RiskTier})
+		if err != nil {
+			return p, err
+		}
+		out = append(out, ev)
+	}
+
+	if err := s.store.Put(ctx, p, expected, out); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// SetPayoutAccount records a seller's connected payout account and whether it can be paid yet,
+// retrying if the profile changes meanwhile
+func (s *Service) SetPayoutAccount(ctx context.Context, sellerID, accountID string, enabled bool) (models.SellerProfile, error) {
+	for {
+		p, err := s.Profile(ctx, sellerID)
+		if err != nil {
+			return p, err
+		}
+		if p.PayoutAccountID == accountID && p.PayoutsEnabled == enabled {
+			return p, nil
+		}
+		p.PayoutAccountID, p.PayoutsEnabled = accountID, enabled
+		p, err = s.Save(ctx, p)
+		if !errors.Is(err, ErrConflict) {
+			return p, err
+		}
+	}
+}
+
+// RiskTier returns a seller's risk tier
+func (s *Service) RiskTier(ctx context.Context, sellerID string) (string, error) {
+	p, err := s.Profile(ctx, sellerID)
+	if err != nil {
+		return "", err
+	}
+	return p.RiskTier, nil
+}
@@ -0,0 +1,35 @@
+package shipping
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// Get returns a seller's shipping profile
+func (s *DynamoStore) Get(ctx context.Context, sellerID string) (models.ShippingProfile, error) {
+	var p models.ShippingProfile
+	pk, sk := models.ShippingProfileKey(sellerID)
+	err := s.table.Get(ctx, pk, sk, &p)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+// Put stores a seller's shipping profile
+func (s *DynamoStore) Put(ctx context.Context, p models.ShippingProfile) error {
+	return s.table.Put(ctx, p, dynamo.Condition{})
+}
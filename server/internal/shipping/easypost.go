@@ -0,0 +1,138 @@
+package shipping
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/easypost"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// easyPostServices are the USPS services each shipping service is sent with
+var easyPostServices = map[string]string{
+	models.ShippingServiceBubble: "GroundAdvantage",
+	models.ShippingServiceBox:    "Priority",
+}
+
+// EasyPostCarrier implements Carrier against the EasyPost shipments API, buying USPS postage.
+type EasyPostCarrier struct {
+	client *easypost.Client
+}
+
+// NewEasyPostCarrier creates an EasyPost carrier
+func NewEasyPostCarrier(c *easypost.Client) *EasyPostCarrier {
+	return &EasyPostCarrier{client: c}
+}
+
+// easyPostAddress is an address as EasyPost takes it
+type easyPostAddress struct {
+	Name    string `json:"name"`
+	Street1 string `json:"street1"`
+	Street2 string `json:"street2,omitempty"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Zip     string `json:"zip"`
+	Country string `json:"country"`
+}
+
+// easyPostRate is a price EasyPost quoted for a shipment
+type easyPostRate struct {
+	ID      string `json:"id"`
+	Carrier string `json:"carrier"`
+	Service string `json:"service"`
+	Rate    string `json:"rate"`
+}
+
+// easyPostShipment is the subset of an EasyPost shipment we read
+type easyPostShipment struct {
+	ID           string         `json:"id"`
+	TrackingCode string         `json:"tracking_code"`
+	Rates        []easyPostRate `json:"rates"`
+	SelectedRate *easyPostRate  `json:"selected_rate"`
+	PostageLabel *struct {
+		LabelURL string `json:"label_url"`
+	} `json:"postage_label"`
+}
+
+// BuyLabel creates a shipment and buys the USPS rate for the requested service. EasyPost has no
+// idempotency keys, so the key goes on the shipment as its reference for matching up by hand.
+func (e *EasyPostCarrier) BuyLabel(ctx context.Context, req LabelRequest) (models.ShippingLabel, error) {
+	service, ok := easyPostServices[req.Service]
+	if !ok {
+		return models.ShippingLabel{}, fmt.Errorf("shipping: %s mail has no trackable label", req.Service)
+	}
+
+	parcel := map[string]interface{}{
+		"weight": math.Ceil(float64(req.Parcel.WeightGrams) / 28.3495), // ounces
+	}
+	if req.Parcel.Envelope {
+		parcel["predefined_package"] = "Letter"
+	}
+
+	var sh easyPostShipment
+	err := e.client.Do(ctx, http.MethodPost, "/v2/shipments", map[string]interface{}{
+		"shipment": map[string]interface{}{
+			"from_address": toEasyPost(req.From),
+			"to_address":   toEasyPost(req.To),
+			"parcel":       parcel,
+			"reference":    req.IdempotencyKey,
+			"options":      map[string]string{"print_custom_1": req.Reference},
+		},
+	}, &sh)
+	if err != nil {
+		return models.ShippingLabel{}, err
+	}
+
+	var rate *easyPostRate
+	for i, r := range sh.Rates {
+		if r.Carrier == "USPS" && r.Service == service {
+			rate = &sh.Rates[i]
+		}
+	}
+	if rate == nil {
+		return models.ShippingLabel{}, fmt.Errorf("shipping: no USPS %s rate for shipment %s", service, sh.ID)
+	}
+
+	var bought easyPostShipment
+	err = e.client.Do(ctx, http.MethodPost, "/v2/shipments/"+url.PathEscape(sh.ID)+"/buy", map[string]interface{}{
+		"rate": map[string]string{"id": rate.ID},
+	}, &bought)
+	if err != nil {
+		return models.ShippingLabel{}, err
+	}
+	if bought.SelectedRate == nil || bought.PostageLabel == nil {
+		return models.ShippingLabel{}, fmt.Errorf("shipping: shipment %s was bought without a label", sh.ID)
+	}
+
+	dollars, err := strconv.ParseFloat(bought.SelectedRate.Rate, 64)
+	if err != nil {
+		return models.ShippingLabel{}, fmt.Errorf("shipping: reading rate %q of shipment %s: %w", bought.SelectedRate.Rate, sh.ID, err)
+	}
+	return models.ShippingLabel{
+		LabelID:        bought.ID,
+		Carrier:        bought.SelectedRate.Carrier,
+		Service:        req.Service,
+		TrackingNumber: bought.TrackingCode,
+		LabelURL:       bought.PostageLabel.LabelURL,
+		CostCents:      int64(math.Round(dollars * 100)),
+		PurchasedAt:    time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// toEasyPost converts an address to EasyPost's shape
+func toEasyPost(a models.Address) easyPostAddress {
+	return easyPostAddress{
+		Name:    a.Name,
+		Street1: a.Line1,
+		Street2: a.Line2,
+		City:    a.City,
+		State:   a.Region,
+		Zip:     a.PostalCode,
+		Country: a.Country,
+	}
+}
@@ -0,0 +1,40 @@
+package shipping
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	profiles map[string]models.ShippingProfile
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{profiles: map[string]models.ShippingProfile{}}
+}
+
+// Get returns a seller's shipping profile
+func (s *MemoryStore) Get(ctx context.Context, sellerID string) (models.ShippingProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.profiles[sellerID]
+	if !ok {
+		return models.ShippingProfile{}, ErrNotFound
+	}
+	p.Methods = append([]models.ShippingMethod(nil), p.Methods...)
+	return p, nil
+}
+
+// Put stores a seller's shipping profile
+func (s *MemoryStore) Put(ctx context.Context, p models.ShippingProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Methods = append([]models.ShippingMethod(nil), p.Methods...)
+	s.profiles[p.SellerID] = p
+	return nil
+}
@@ -0,0 +1,230 @@
+// Package shipping holds seller shipping profiles, prices shipping per seller order at checkout and
+// buys postage labels through a Carrier.
+package shipping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+)
+
+var (
+	// ErrNotFound is returned when a seller has no stored shipping profile
+	ErrNotFound = errors.New("shipping: profile not found")
+	// ErrInvalidProfile is returned when a shipping profile is malformed
+	ErrInvalidProfile = errors.New("shipping: invalid profile")
+	// ErrNoMethod is returned when the chosen method can't ship the order
+	ErrNoMethod = errors.New("shipping: method not available for this order")
+	// ErrNoShipFrom is returned when buying a label for a seller without a ship-from address
+	ErrNoShipFrom = errors.New("shipping: seller has no ship-from address")
+)
+
+// DefaultCountry is the ship-from country assumed for sellers who haven't set one
+const DefaultCountry = "US"
+
+// Countries are the destinations offered at checkout
+var Countries = map[string]string{
+	"US": "United States",
+	"CA": "Canada",
+	"GB": "United Kingdom",
+	"DE": "Germany",
+	"FR": "France",
+	"JP": "Japan",
+	"AU": "Australia",
+}
+
+// Store persists shipping profiles.
+type Store interface {
+	Get(ctx context.Context, sellerID string) (models.ShippingProfile, error)
+	Put(ctx context.Context, p models.ShippingProfile) error
+}
+
+// Rate is the price of one shipping method for a seller order
+type Rate struct {
+	Method    models.ShippingMethod
+	CostCents int64
+}
+
+// Service prices shipping and buys labels.
+type Service struct {
+	store   Store
+	carrier Carrier
+}
+
+// New creates a shipping Service
+func New(store Store, carrier Carrier) *Service {
+	return &Service{store: store, carrier: carrier}
+}
+
+// Attach prices the buyer's chosen shipping method on every order at checkout
+func (s *Service) Attach(o *orders.Service) {
+	o.BeforeCreate(s.Price)
+}
+
+// Price validates an order's chosen shipping method against the seller's profile and sets its cost
+func (s *Service) Price(ctx context.Context, o *models.Order) error {
+	items := 0
+	for _, it := range o.Items {
+		items += it.Quantity
+	}
+
+	rates, err := s.Rates(ctx, o.SellerID, items, o.SubtotalCents, o.ShipTo.Country)
+	if err != nil {
+		return err
+	}
+	for _, r := range rates {
+		if r.Method.MethodID == o.ShippingMethod {
+			o.ShippingCents = r.CostCents
+			o.ShippingName = r.Method.Name
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q for seller %s", ErrNoMethod, o.ShippingMethod, o.SellerID)
+}
+
+// Profile returns a seller's shipping profile, or the default profile for sellers who haven't set one
+func (s *Service) Profile(ctx context.Context, sellerID string) (models.ShippingProfile, error) {
+	p, err := s.store.Get(ctx, sellerID)
+	if errors.Is(err, ErrNotFound) {
+		return DefaultProfile(sellerID), nil
+	}
+	return p, err
+}
+
+// SaveProfile validates and stores a seller's shipping profile
+func (s *Service) SaveProfile(ctx context.Context, p models.ShippingProfile) (models.ShippingProfile, error) {
+	if err := Validate(p); err != nil {
+		return p, err
+	}
+
+	for i := range p.Methods {
+		if p.Methods[i].MethodID == "" {
+			p.Methods[i].MethodID = ids.New()
+		}
+	}
+	p.ShipFrom.Country = strings.ToUpper(p.ShipFrom.Country)
+	p.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	p.PK, p.SK = models.ShippingProfileKey(p.SellerID)
+	p.Type = models.ItemTypeShipping
+
+	if err := s.store.Put(ctx, p); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// Rates returns what each of a seller's methods costs for an order of items cards worth
+// subtotalCents shipped to country, cheapest first. Methods for the wrong zone or too small for
+// the order are left out.
+func (s *Service) Rates(ctx context.Context, sellerID string, items int, subtotalCents int64, country string) ([]Rate, error) {
+	p, err := s.Profile(ctx, sellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	zone := Zone(p, country)
+	var rates []Rate
+	for _, m := range p.Methods {
+		if m.Zone != zone || (m.MaxItems > 0 && items > m.MaxItems) {
+			continue
+		}
+		rates = append(rates, Rate{Method: m, CostCents: Cost(m, items, subtotalCents)})
+	}
+	sort.SliceStable(rates, func(i, j int) bool {
+		return rates[i].CostCents < rates[j].CostCents
+	})
+	return rates, nil
+}
+
+// BuyLabel buys postage for an order from the seller's ship-from address to the buyer
+func (s *Service) BuyLabel(ctx context.Context, o models.Order) (models.ShippingLabel, error) {
+	p, err := s.Profile(ctx, o.SellerID)
+	if err != nil {
+		return models.ShippingLabel{}, err
+	}
+	if p.ShipFrom.Line1 == "" {
+		return models.ShippingLabel{}, ErrNoShipFrom
+	}
+
+	service := models.ShippingServiceBubble
+	for _, m := range p.Methods {
+		if m.MethodID == o.ShippingMethod {
+			service = m.Service
+		}
+	}
+
+	items := 0
+	for _, it := range o.Items {
+		items += it.Quantity
+	}
+
+	return s.carrier.BuyLabel(ctx, LabelRequest{
+		From:           p.ShipFrom,
+		To:             o.ShipTo,
+		Service:        service,
+		Parcel:         ParcelFor(service, items),
+		Reference:      o.OrderID,
+		IdempotencyKey: "order-" + o.OrderID + "-label",
+	})
+}
+
+// Zone returns the zone a destination country falls in for a seller
+func Zone(p models.ShippingProfile, country string) string {
+	from := p.ShipFrom.Country
+	if from == "" {
+		from = DefaultCountry
+	}
+	if strings.EqualFold(from, country) {
+		return models.ShippingZoneDomestic
+	}
+	return models.ShippingZoneInternational
+}
+
+// Cost prices a method: the base cost plus the per-item cost for every card, or nothing once the
+// order reaches the free shipping threshold
+func Cost(m models.ShippingMethod, items int, subtotalCents int64) int64 {
+	if m.FreeOverCents > 0 && subtotalCents >= m.FreeOverCents {
+		return 0
+	}
+	return m.BaseCents + m.PerItemCents*int64(items)
+}
+
+// Validate checks a shipping profile is well formed
+func Validate(p models.ShippingProfile) error {
+	if len(p.Methods) == 0 {
+		return fmt.Errorf("%w: add at least one shipping method", ErrInvalidProfile)
+	}
+	for _, m := range p.Methods {
+		switch {
+		case strings.TrimSpace(m.Name) == "":
+			return fmt.Errorf("%w: every method needs a name", ErrInvalidProfile)
+		case m.Service != models.ShippingServicePWE && m.Service != models.ShippingServiceBubble && m.Service != models.ShippingServiceBox:
+			return fmt.Errorf("%w: %s has an unknown service", ErrInvalidProfile, m.Name)
+		case m.Zone != models.ShippingZoneDomestic && m.Zone != models.ShippingZoneInternational:
+			return fmt.Errorf("%w: %s has an unknown zone", ErrInvalidProfile, m.Name)
+		case m.BaseCents < 0 || m.PerItemCents < 0 || m.FreeOverCents < 0 || m.MaxItems < 0:
+			return fmt.Errorf("%w: %s has a negative amount", ErrInvalidProfile, m.Name)
+		}
+	}
+	return nil
+}
+
+// DefaultProfile is the profile used for sellers who haven't configured shipping
+func DefaultProfile(sellerID string) models.ShippingProfile {
+	return models.ShippingProfile{
+		SellerID: sellerID,
+		ShipFrom: models.Address{Country: DefaultCountry},
+		Methods: []models.ShippingMethod{
+			{MethodID: "default-pwe", Name: "Envelope (untracked)", Service: models.ShippingServicePWE, Zone: models.ShippingZoneDomestic, BaseCents: 100, MaxItems: 10},
+			{MethodID: "default-tracked", Name: "Tracked mailer", Service: models.ShippingServiceBubble, Zone: models.ShippingZoneDomestic, BaseCents: 499, FreeOverCents: 5000},
+			{MethodID: "default-intl", Name: "International tracked", Service: models.ShippingServiceBubble, Zone: models.ShippingZoneInternational, BaseCents: 1499},
+		},
+	}
+}
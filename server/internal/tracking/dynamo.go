@@ -0,0 +1,69 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. Tracking events are stored in their order's
+// partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// PutShipment stores a shipment
+func (s *DynamoStore) PutShipment(ctx context.Context, sh models.Shipment) error {
+	return s.table.Put(ctx, sh, dynamo.Condition{})
+}
+
+// ClaimShipment stores a shipment unless its tracking number is already stored
+func (s *DynamoStore) ClaimShipment(ctx context.Context, sh models.Shipment) (models.Shipment, error) {
+	err := s.table.Put(ctx, sh, dynamo.IfNotExists())
+	if errors.Is(err, dynamo.ErrConflict) {
+		return s.Shipment(ctx, sh.Carrier, sh.TrackingNumber)
+	}
+	return sh, err
+}
+
+// Shipment returns the shipment for a carrier tracking number
+func (s *DynamoStore) Shipment(ctx context.Context, carrier, trackingNumber string) (models.Shipment, error) {
+	var sh models.Shipment
+	pk, sk := models.ShipmentKey(carrier, trackingNumber)
+	err := s.table.Get(ctx, pk, sk, &sh)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return sh, ErrUnknownShipment
+	}
+	return sh, err
+}
+
+// Append stores events that haven't been stored before and returns them
+func (s *DynamoStore) Append(ctx context.Context, events []models.TrackingEvent) ([]models.TrackingEvent, error) {
+	var added []models.TrackingEvent
+	for _, e := range events {
+		err := s.table.Put(ctx, e, dynamo.IfNotExists())
+		if errors.Is(err, dynamo.ErrConflict) {
+			continue
+		}
+		if err != nil {
+			return added, err
+		}
+		added = append(added, e)
+	}
+	return added, nil
+}
+
+// Timeline returns an order's events, oldest first
+func (s *DynamoStore) Timeline(ctx context.Context, orderID string) ([]models.TrackingEvent, error) {
+	var out []models.TrackingEvent
+	pk, _ := models.OrderKey(orderID)
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "TRACKING#"}, &out)
+	return out, err
+}
@@ -0,0 +1,52 @@
+package tracking
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/easypost"
+)
+
+// EasyPostProvider implements Provider with EasyPost trackers.
+type EasyPostProvider struct {
+	client *easypost.Client
+}
+
+// NewEasyPostProvider creates an EasyPost provider
+func NewEasyPostProvider(c *easypost.Client) *EasyPostProvider {
+	return &EasyPostProvider{client: c}
+}
+
+// Track returns the scans on a shipment. EasyPost hands back the existing tracker when one has
+// already been created for the tracking number, so polling doesn't pile up trackers.
+func (e *EasyPostProvider) Track(ctx context.Context, carrier, trackingNumber string) ([]Update, error) {
+	var t easypost.Tracker
+	err := e.client.Do(ctx, http.MethodPost, "/v2/trackers", map[string]interface{}{
+		"tracker": map[string]string{"tracking_code": trackingNumber, "carrier": carrier},
+	}, &t)
+	if err != nil {
+		return nil, err
+	}
+	return trackerUpdates(t), nil
+}
+
+// trackerUpdates converts the scans on an EasyPost tracker to updates
+func trackerUpdates(t easypost.Tracker) []Update {
+	out := make([]Update, 0, len(t.TrackingDetails))
+	for _, d := range t.TrackingDetails {
+		var place []string
+		for _, p := range []string{d.TrackingLocation.City, d.TrackingLocation.State, d.TrackingLocation.Country} {
+			if p != "" {
+				place = append(place, p)
+			}
+		}
+		out = append(out, Update{
+			Status:      d.Status,
+			Description: d.Message,
+			Location:    strings.Join(place, ", "),
+			OccurredAt:  d.Datetime,
+		})
+	}
+	return out
+}
@@ -0,0 +1,32 @@
+package tracking
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeProvider is an in-memory Provider for tests and local development. It reports whatever
+// scans have been added for a tracking number.
+type FakeProvider struct {
+	mu      sync.Mutex
+	updates map[string][]Update // tracking number -> scans
+}
+
+// NewFakeProvider creates an empty FakeProvider
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{updates: map[string][]Update{}}
+}
+
+// Add records a scan the provider will report for a tracking number
+func (f *FakeProvider) Add(trackingNumber string, u Update) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates[trackingNumber] = append(f.updates[trackingNumber], u)
+}
+
+// Track returns the scans added for a tracking number
+func (f *FakeProvider) Track(ctx context.Context, carrier, trackingNumber string) ([]Update, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Update(nil), f.updates[trackingNumber]...), nil
+}
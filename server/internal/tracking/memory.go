@@ -0,0 +1,86 @@
+package tracking
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	shipments map[string]models.Shipment        // PK -> shipment
+	events    map[string][]models.TrackingEvent // order ID -> events
+	seen      map[string]bool                   // event IDs already stored
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		shipments: map[string]models.Shipment{},
+		events:    map[string][]models.TrackingEvent{},
+		seen:      map[string]bool{},
+	}
+}
+
+// PutShipment stores a shipment
+func (s *MemoryStore) PutShipment(ctx context.Context, sh models.Shipment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pk, _ := models.ShipmentKey(sh.Carrier, sh.TrackingNumber)
+	s.shipments[pk] = sh
+	return nil
+}
+
+// ClaimShipment stores a shipment unless its tracking number is already stored
+func (s *MemoryStore) ClaimShipment(ctx context.Context, sh models.Shipment) (models.Shipment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pk, _ := models.ShipmentKey(sh.Carrier, sh.TrackingNumber)
+	if existing, ok := s.shipments[pk]; ok {
+		return existing, nil
+	}
+	s.shipments[pk] = sh
+	return sh, nil
+}
+
+// Shipment returns the shipment for a carrier tracking number
+func (s *MemoryStore) Shipment(ctx context.Context, carrier, trackingNumber string) (models.Shipment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pk, _ := models.ShipmentKey(carrier, trackingNumber)
+	sh, ok := s.shipments[pk]
+	if !ok {
+		return sh, ErrUnknownShipment
+	}
+	return sh, nil
+}
+
+// Append stores events that haven't been stored before and returns them
+func (s *MemoryStore) Append(ctx context.Context, events []models.TrackingEvent) ([]models.TrackingEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var added []models.TrackingEvent
+	for _, e := range events {
+		if s.seen[e.PK+e.SK] {
+			continue
+		}
+		s.seen[e.PK+e.SK] = true
+		s.events[e.OrderID] = append(s.events[e.OrderID], e)
+		added = append(added, e)
+	}
+	return added, nil
+}
+
+// Timeline returns an order's events, oldest first
+func (s *MemoryStore) Timeline(ctx context.Context, orderID string) ([]models.TrackingEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := append([]models.TrackingEvent(nil), s.events[orderID]...)
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].SK < out[j].SK
+	})
+	return out, nil
+}
@@ -0,0 +1,464 @@
+// Package tracking follows shipments once an order ships. Carrier scans arrive as signed EasyPost
+// tracker webhooks or by polling a Provider for shipments that have gone quiet, and are kept as a timeline on the
+// order. A delivery scan marks the order delivered, and delivered orders complete on their own once
+// the buyer's window to report a problem has passed, which releases the seller's funds.
+package tracking
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/easypost"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/orders"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/webhooks"
+)
+
+var (
+	// ErrUnknownShipment is returned for a tracking number no order was shipped with
+	ErrUnknownShipment = errors.New("tracking: no order ships with this tracking number")
+	// ErrTrackingInUse is returned when a tracking number is already attached to another order
+	ErrTrackingInUse = errors.New("tracking: tracking number is already used by another order")
+	// ErrInvalidSignature is returned when a carrier webhook fails signature verification
+	ErrInvalidSignature = webhooks.ErrInvalidSignature
+)
+
+// Store persists shipments and their tracking events.
+type Store interface {
+	PutShipment(ctx context.Context, s models.Shipment) error
+	// ClaimShipment stores a shipment unless one with the same carrier and tracking number exists,
+	// and returns whichever is stored
+	ClaimShipment(ctx context.Context, s models.Shipment) (models.Shipment, error)
+	// Shipment returns the shipment for a carrier tracking number, or ErrUnknownShipment
+	Shipment(ctx context.Context, carrier, trackingNumber string) (models.Shipment, error)
+	// Append stores events, skipping any already stored, and returns the ones that were new
+	Append(ctx context.Context, events []models.TrackingEvent) ([]models.TrackingEvent, error)
+	// Timeline returns an order's tracking events, oldest first
+	Timeline(ctx context.Context, orderID string) ([]models.TrackingEvent, error)
+}
+
+// Update is one scan reported by a carrier
+type Update struct {
+	Status      string    `json:"status"`
+	Description string    `json:"description"`
+	Location    string    `json:"location"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// Provider looks up the scans on a shipment, in the style of EasyPost or AfterShip trackers.
+type Provider interface {
+	Track(ctx context.Context, carrier, trackingNumber string) ([]Update, error)
+}
+
+// Options controls polling and automatic completion
+type Options struct {
+	PollEvery     time.Duration // how often Run polls and completes orders
+	StaleAfter    time.Duration // shipments with no news for this long are polled
+	CompleteAfter time.Duration // delivered orders complete after this long without a problem reported
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	PollEvery:     15 * time.Minute,
+	StaleAfter:    6 * time.Hour,
+	CompleteAfter: 72 * time.Hour,
+}
+
+// HoldFunc reports whether an order must not complete on its own, for example while it is disputed
+type HoldFunc func(ctx context.Context, o models.Order) (bool, error)
+
+// Service records tracking events and moves orders to delivered and completed.
+type Service struct {
+	store    Store
+	provider Provider
+	orders   *orders.Service
+	secret   string
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+
+	mu   sync.Mutex
+	hold HoldFunc
+}
+
+// New creates a tracking Service. webhookSecret verifies carrier webhooks.
+func New(store Store, provider Provider, o *orders.Service, webhookSecret string, opts Options, errorLog *log.Logger) *Service {
+	if opts.PollEvery <= 0 {
+		opts.PollEvery = DefaultOptions.PollEvery
+	}
+	if opts.StaleAfter <= 0 {
+		opts.StaleAfter = DefaultOptions.StaleAfter
+	}
+	if opts.CompleteAfter <= 0 {
+		opts.CompleteAfter = DefaultOptions.CompleteAfter
+	}
+	return &Service{
+		store:    store,
+		provider: provider,
+		orders:   o,
+		secret:   webhookSecret,
+		opts:     opts,
+		errorLog: errorLog,
+		now:      time.Now,
+	}
+}
+
+// Attach starts following every order shipped with a tracking number
+func (s *Service) Attach(o *orders.Service) {
+	o.OnTransition(func(ctx context.Context, order models.Order, ev models.OrderEvent) {
+		if ev.To != models.OrderStatusShipped || order.TrackingNumber == "" {
+			return
+		}
+		if err := s.follow(ctx, order); err != nil {
+			s.errorLog.Printf("tracking shipment for order %s failed: %v", order.OrderID, err)
+		}
+	})
+}
+
+// Claim reserves a tracking number for an order about to ship, so one parcel can't be used to
+// mark several orders delivered. Claiming a number the order already holds succeeds.
+func (s *Service) Claim(ctx context.Context, orderID, carrier, trackingNumber string) error {
+	_, err := s.claim(ctx, orderID, carrier, trackingNumber)
+	return err
+}
+
+// SetHold sets the check that keeps delivered orders from completing on their own
+func (s *Service) SetHold(fn HoldFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hold = fn
+}
+
+// WebhookSecret returns the secret carrier webhooks are signed with
+func (s *Service) WebhookSecret() string {
+	return s.secret
+}
+
+// CompleteAfter returns how long after delivery an order completes on its own
+func (s *Service) CompleteAfter() time.Duration {
+	return s.opts.CompleteAfter
+}
+
+// HandleWebhook verifies and ingests an EasyPost tracker event, signed in its X-Hmac-Signature
+// header. Other events, and updates for shipments the marketplace doesn't know about, are ignored
+// so EasyPost stops retrying them.
+func (s *Service) HandleWebhook(ctx context.Context, payload []byte, signature string) error {
+	if err := easypost.Verify(payload, signature, s.secret); err != nil {
+		return err
+	}
+
+	var ev easypost.Event
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return fmt.Errorf("tracking: decoding webhook: %w", err)
+	}
+	if ev.Description != "tracker.created" && ev.Description != "tracker.updated" {
+		return nil
+	}
+	var t easypost.Tracker
+	if err := json.Unmarshal(ev.Result, &t); err != nil {
+		return fmt.Errorf("tracking: decoding tracker in event %s: %w", ev.ID, err)
+	}
+
+	_, err := s.Ingest(ctx, t.Carrier, t.TrackingCode, models.TrackingSourceWebhook, trackerUpdates(t))
+	if errors.Is(err, ErrUnknownShipment) {
+		return nil
+	}
+	return err
+}
+
+// Ingest records scans for a shipment and marks its order delivered when a delivery scan arrives.
+// Scans already recorded are skipped, so replayed webhooks and repeated polls are harmless. It
+// returns the new events.
+func (s *Service) Ingest(ctx context.Context, carrier, trackingNumber, source string, updates []Update) ([]models.TrackingEvent, error) {
+	sh, err := s.shipment(ctx, carrier, trackingNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// scans from before the order shipped belong to some earlier use of the parcel or number
+	shipped, _ := time.Parse(time.RFC3339, sh.ShippedAt)
+	now := s.now().UTC().Format(time.RFC3339)
+	events := make([]models.TrackingEvent, 0, len(updates))
+	for _, u := range updates {
+		if !u.OccurredAt.IsZero() && u.OccurredAt.Before(shipped) {
+			continue
+		}
+		events = append(events, newEvent(sh, u, source, now))
+	}
+	added, err := s.store.Append(ctx, events)
+	if err != nil {
+		return nil, err
+	}
+
+	sh.CheckedAt = now
+	if err := s.store.PutShipment(ctx, sh); err != nil {
+		return added, err
+	}
+	if len(added) == 0 {
+		return added, nil
+	}
+
+	timeline, err := s.store.Timeline(ctx, sh.OrderID)
+	if err != nil {
+		return added, err
+	}
+	return added, s.apply(ctx, sh.OrderID, timeline, added)
+}
+
+// Timeline returns an order's tracking events, oldest first
+func (s *Service) Timeline(ctx context.Context, orderID string) ([]models.TrackingEvent, error) {
+	return s.store.Timeline(ctx, orderID)
+}
+
+// Poll asks the provider about every shipped order that hasn't had news for a while and returns
+// how many shipments it checked
+func (s *Service) Poll(ctx context.Context) (int, error) {
+	list, err := s.orders.WithStatus(ctx, models.OrderStatusShipped)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := s.now().Add(-s.opts.StaleAfter).UTC().Format(time.RFC3339)
+	checked := 0
+	for _, o := range list {
+		if o.TrackingNumber == "" {
+			continue
+		}
+		sh, err := s.store.Shipment(ctx, carrierKey(o.Carrier), o.TrackingNumber)
+		if errors.Is(err, ErrUnknownShipment) {
+			// shipped before tracking was attached
+			if err := s.follow(ctx, o); err != nil {
+				return checked, err
+			}
+			sh, err = s.store.Shipment(ctx, carrierKey(o.Carrier), o.TrackingNumber)
+		}
+		if err != nil {
+			return checked, err
+		}
+		if sh.CheckedAt > cutoff {
+			continue
+		}
+
+		updates, err := s.provider.Track(ctx, sh.Carrier, sh.TrackingNumber)
+		if err != nil {
+			s.errorLog.Printf("polling %s %s for order %s failed: %v", sh.Carrier, sh.TrackingNumber, o.OrderID, err)
+			continue
+		}
+		if _, err := s.Ingest(ctx, sh.Carrier, sh.TrackingNumber, models.TrackingSourcePoll, updates); err != nil {
+			return checked, err
+		}
+		checked++
+	}
+	return checked, nil
+}
+
+// AutoComplete completes delivered orders whose problem window has passed and which aren't held,
+// and returns how many it completed. Completing an order releases the seller's funds.
+func (s *Service) AutoComplete(ctx context.Context) (int, error) {
+	list, err := s.orders.WithStatus(ctx, models.OrderStatusDelivered)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	hold := s.hold
+	s.mu.Unlock()
+
+	now := s.now()
+	completed := 0
+	for _, o := range list {
+		due, ok := s.CompletesAt(o)
+		if !ok || now.Before(due) {
+			continue
+		}
+		if hold != nil {
+			held, err := hold(ctx, o)
+			if err != nil {
+				return completed, err
+			}
+			if held {
+				continue
+			}
+		}
+		reason := fmt.Sprintf("no problem reported within %s of delivery", s.opts.CompleteAfter)
+		_, err := s.orders.Transition(ctx, o.OrderID, models.OrderStatusCompleted, orders.System, reason)
+		switch {
+		case errors.Is(err, orders.ErrConflict), errors.Is(err, orders.ErrInvalidTransition):
+			// the buyer or an admin got there first
+		case err != nil:
+			return completed, err
+		default:
+			completed++
+		}
+	}
+	return completed, nil
+}
+
+// CompletesAt returns when a delivered order will complete on its own
+func (s *Service) CompletesAt(o models.Order) (time.Time, bool) {
+	if o.Status != models.OrderStatusDelivered || o.DeliveredAt == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, o.DeliveredAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.Add(s.opts.CompleteAfter), true
+}
+
+// Run polls stale shipments and completes delivered orders every PollEvery until ctx is done
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.PollEvery)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Poll(ctx); err != nil {
+			s.errorLog.Printf("tracking poll failed: %v", err)
+		}
+		if _, err := s.AutoComplete(ctx); err != nil {
+			s.errorLog.Printf("auto-completing delivered orders failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Normalize maps a carrier's status onto the marketplace's tracking statuses
+func Normalize(status string) string {
+	switch s := strings.ToLower(strings.TrimSpace(status)); s {
+	case models.TrackingStatusPreTransit, "label_created", "accepted", "unknown", "":
+		return models.TrackingStatusPreTransit
+	case models.TrackingStatusOutForDelivery, "out for delivery":
+		return models.TrackingStatusOutForDelivery
+	case models.TrackingStatusDelivered, "available_for_pickup":
+		return models.TrackingStatusDelivered
+	case models.TrackingStatusException, "failure", "error", "cancelled":
+		return models.TrackingStatusException
+	case models.TrackingStatusReturned, "return_to_sender":
+		return models.TrackingStatusReturned
+	default:
+		return models.TrackingStatusInTransit
+	}
+}
+
+// follow records the shipment for a newly shipped order so scans can be matched back to it
+func (s *Service) follow(ctx context.Context, o models.Order) error {
+	sh, err := s.claim(ctx, o.OrderID, o.Carrier, o.TrackingNumber)
+	if err != nil || sh.ShippedAt != "" {
+		return err
+	}
+	sh.ShippedAt = o.ShippedAt
+	if sh.ShippedAt == "" {
+		// shipped before orders recorded when
+		sh.ShippedAt = o.UpdatedAt
+	}
+	return s.store.PutShipment(ctx, sh)
+}
+
+// claim stores the shipment for an order's tracking number, or returns the one already stored
+// for it. A number recorded without a carrier matches any carrier, so it is checked too.
+func (s *Service) claim(ctx context.Context, orderID, carrier, trackingNumber string) (models.Shipment, error) {
+	existing, err := s.shipment(ctx, carrier, trackingNumber)
+	switch {
+	case err == nil && existing.OrderID != orderID:
+		return existing, ErrTrackingInUse
+	case err != nil && !errors.Is(err, ErrUnknownShipment):
+		return existing, err
+	}
+
+	sh := models.Shipment{
+		Carrier:        carrierKey(carrier),
+		TrackingNumber: trackingNumber,
+		OrderID:        orderID,
+		CreatedAt:      s.now().UTC().Format(time.RFC3339),
+		Type:           models.ItemTypeShipment,
+	}
+	sh.CheckedAt = sh.CreatedAt
+	sh.PK, sh.SK = models.ShipmentKey(sh.Carrier, sh.TrackingNumber)
+	stored, err := s.store.ClaimShipment(ctx, sh)
+	if err == nil && stored.OrderID != orderID {
+		return stored, ErrTrackingInUse
+	}
+	return stored, err
+}
+
+// shipment finds a shipment by carrier and tracking number. Sellers can ship without naming the
+// carrier, so a number recorded without one matches any carrier.
+func (s *Service) shipment(ctx context.Context, carrier, trackingNumber string) (models.Shipment, error) {
+	sh, err := s.store.Shipment(ctx, carrierKey(carrier), trackingNumber)
+	if errors.Is(err, ErrUnknownShipment) && carrier != "" {
+		sh, err = s.store.Shipment(ctx, "", trackingNumber)
+	}
+	return sh, err
+}
+
+// apply brings an order up to date with its tracking timeline
+func (s *Service) apply(ctx context.Context, orderID string, timeline, added []models.TrackingEvent) error {
+	o, err := s.orders.Get(ctx, orderID)
+	if err != nil {
+		return err
+	}
+
+	latest := timeline[len(timeline)-1].Status
+	if latest != o.TrackingStatus {
+		o, err = s.orders.Amend(ctx, orderID, orders.System, "tracking: "+latest, func(o *models.Order) {
+			o.TrackingStatus = latest
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.Status != models.OrderStatusShipped {
+		return nil
+	}
+	for _, e := range added {
+		if e.Status == models.TrackingStatusDelivered {
+			_, err := s.orders.Transition(ctx, orderID, models.OrderStatusDelivered, orders.System, "carrier reported delivery")
+			return err
+		}
+	}
+	return nil
+}
+
+// newEvent builds a tracking event for a scan. The event ID is derived from the scan itself so the
+// same scan arriving by webhook and by polling is stored once.
+func newEvent(sh models.Shipment, u Update, source, receivedAt string) models.TrackingEvent {
+	status := Normalize(u.Status)
+	occurred := u.OccurredAt.UTC().Format(time.RFC3339)
+	if u.OccurredAt.IsZero() {
+		occurred = receivedAt
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{sh.Carrier, sh.TrackingNumber, status, occurred, u.Description}, "|")))
+	e := models.TrackingEvent{
+		EventID:        hex.EncodeToString(sum[:8]),
+		OrderID:        sh.OrderID,
+		Carrier:        sh.Carrier,
+		TrackingNumber: sh.TrackingNumber,
+		Status:         status,
+		Description:    u.Description,
+		Location:       u.Location,
+		OccurredAt:     occurred,
+		Source:         source,
+		ReceivedAt:     receivedAt,
+		Type:           models.ItemTypeTracking,
+	}
+	e.PK, e.SK = models.TrackingEventKey(sh.OrderID, occurred, e.EventID)
+	return e
+}
+
+// carrierKey normalises a carrier name for matching
+func carrierKey(carrier string) string {
+	return strings.ToUpper(strings.TrimSpace(carrier))
+}
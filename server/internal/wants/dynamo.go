@@ -0,0 +1,122 @@
+package wants
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/dynamo"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// DynamoStore is a Store over the marketplace table. A buyer's wants, matches and digests share
+// the buyer's partition.
+type DynamoStore struct {
+	table *dynamo.Table
+}
+
+// NewDynamoStore creates a DynamoStore
+func NewDynamoStore(table *dynamo.Table) *DynamoStore {
+	return &DynamoStore{table: table}
+}
+
+// PutWant creates or replaces a want
+func (s *DynamoStore) PutWant(ctx context.Context, w models.Want) error {
+	return s.table.Put(ctx, w, dynamo.Condition{})
+}
+
+// GetWant returns one of a buyer's wants
+func (s *DynamoStore) GetWant(ctx context.Context, userID, wantID string) (models.Want, error) {
+	var w models.Want
+	pk, sk := models.WantKey(userID, wantID)
+	err := s.table.Get(ctx, pk, sk, &w)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return models.Want{}, ErrNotFound
+	}
+	return w, err
+}
+
+// DeleteWant removes one of a buyer's wants
+func (s *DynamoStore) DeleteWant(ctx context.Context, userID, wantID string) error {
+	pk, sk := models.WantKey(userID, wantID)
+	err := s.table.Delete(ctx, pk, sk, dynamo.IfExists())
+	if errors.Is(err, dynamo.ErrConflict) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// WantsByUser returns a buyer's want list, newest first
+func (s *DynamoStore) WantsByUser(ctx context.Context, userID string) ([]models.Want, error) {
+	pk, _ := models.WantKey(userID, "")
+	return s.wants(ctx, dynamo.Query{PK: pk, SKPrefix: "WANT#"})
+}
+
+// WantsByPrinting returns every want for a printing
+func (s *DynamoStore) WantsByPrinting(ctx context.Context, printingID string) ([]models.Want, error) {
+	pk, _ := models.PrintingWantKey(printingID, "", "")
+	return s.wants(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk})
+}
+
+// AddMatch stores a new match
+func (s *DynamoStore) AddMatch(ctx context.Context, m models.WantMatch) error {
+	return s.table.Put(ctx, m, dynamo.Condition{})
+}
+
+// MatchesByUser returns a buyer's matches, newest first
+func (s *DynamoStore) MatchesByUser(ctx context.Context, userID string) ([]models.WantMatch, error) {
+	var out []models.WantMatch
+	pk, _ := models.WantMatchKey(userID, "", "")
+	err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "WANTMATCH#", Descending: true}, &out)
+	return out, err
+}
+
+// PendingMatches returns the matches not yet sent in a digest, oldest first
+func (s *DynamoStore) PendingMatches(ctx context.Context) ([]models.WantMatch, error) {
+	var out []models.WantMatch
+	pk, _ := models.PendingWantMatchKey("", "", "")
+	err := s.table.Query(ctx, dynamo.Query{Index: dynamo.GSI1, PK: pk}, &out)
+	return out, err
+}
+
+// LastDigest returns the most recent digest sent to a buyer
+func (s *DynamoStore) LastDigest(ctx context.Context, userID string) (models.WantDigest, error) {
+	var out []models.WantDigest
+	pk, _ := models.WantDigestKey(userID, "")
+	if err := s.table.Query(ctx, dynamo.Query{PK: pk, SKPrefix: "WANTDIGEST#", Descending: true, Limit: 1}, &out); err != nil {
+		return models.WantDigest{}, err
+	}
+	if len(out) == 0 {
+		return models.WantDigest{}, ErrNotFound
+	}
+	return out[0], nil
+}
+
+// SaveDigest records a digest, if any, and writes back the matches it sent. A digest with more
+// matches than one transaction holds is written in several, with the digest itself in the last,
+// so a failure part way leaves it unrecorded.
+func (s *DynamoStore) SaveDigest(ctx context.Context, d *models.WantDigest, sent []models.WantMatch) error {
+	ops := make([]dynamo.Op, 0, len(sent)+1)
+	for _, m := range sent {
+		ops = append(ops, dynamo.PutOp(m, dynamo.Condition{}))
+	}
+	if d != nil {
+		ops = append(ops, dynamo.PutOp(*d, dynamo.Condition{}))
+	}
+	for len(ops) > dynamo.MaxTransactItems {
+		if err := s.table.Write(ctx, ops[:dynamo.MaxTransactItems]...); err != nil {
+			return err
+		}
+		ops = ops[dynamo.MaxTransactItems:]
+	}
+	return s.table.Write(ctx, ops...)
+}
+
+// wants returns the wants matching q, newest first
+func (s *DynamoStore) wants(ctx context.Context, q dynamo.Query) ([]models.Want, error) {
+	var out []models.Want
+	if err := s.table.Query(ctx, q, &out); err != nil {
+		return nil, err
+	}
+	sortWants(out)
+	return out, nil
+}
@@ -0,0 +1,149 @@
+package wants
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+)
+
+// MemoryStore is an in-process Store used for development and local runs.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	wants   map[string]models.Want                 // want ID -> want
+	matches map[string]map[string]models.WantMatch // user ID -> SK -> match
+	digests map[string][]models.WantDigest         // user ID -> digests, oldest first
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		wants:   map[string]models.Want{},
+		matches: map[string]map[string]models.WantMatch{},
+		digests: map[string][]models.WantDigest{},
+	}
+}
+
+// PutWant creates or replaces a want
+func (s *MemoryStore) PutWant(ctx context.Context, w models.Want) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wants[w.WantID] = w
+	return nil
+}
+
+// GetWant returns one of a buyer's wants
+func (s *MemoryStore) GetWant(ctx context.Context, userID, wantID string) (models.Want, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w, ok := s.wants[wantID]
+	if !ok || w.UserID != userID {
+		return models.Want{}, ErrNotFound
+	}
+	return w, nil
+}
+
+// DeleteWant removes one of a buyer's wants
+func (s *MemoryStore) DeleteWant(ctx context.Context, userID, wantID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.wants[wantID]; !ok || w.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.wants, wantID)
+	return nil
+}
+
+// WantsByUser returns a buyer's want list, newest first
+func (s *MemoryStore) WantsByUser(ctx context.Context, userID string) ([]models.Want, error) {
+	list := s.filter(func(w models.Want) bool { return w.UserID == userID })
+	sortWants(list)
+	return list, nil
+}
+
+// WantsByPrinting returns every want for a printing
+func (s *MemoryStore) WantsByPrinting(ctx context.Context, printingID string) ([]models.Want, error) {
+	list := s.filter(func(w models.Want) bool { return w.PrintingID == printingID })
+	sortWants(list)
+	return list, nil
+}
+
+// AddMatch stores a new match
+func (s *MemoryStore) AddMatch(ctx context.Context, m models.WantMatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.matches[m.UserID] == nil {
+		s.matches[m.UserID] = map[string]models.WantMatch{}
+	}
+	s.matches[m.UserID][m.SK] = m
+	return nil
+}
+
+// MatchesByUser returns a buyer's matches, newest first
+func (s *MemoryStore) MatchesByUser(ctx context.Context, userID string) ([]models.WantMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]models.WantMatch, 0, len(s.matches[userID]))
+	for _, m := range s.matches[userID] {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SK > out[j].SK })
+	return out, nil
+}
+
+// PendingMatches returns the matches not yet sent in a digest, oldest first
+func (s *MemoryStore) PendingMatches(ctx context.Context) ([]models.WantMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.WantMatch
+	for _, byUser := range s.matches {
+		for _, m := range byUser {
+			if m.GSI1PK != "" {
+				out = append(out, m)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GSI1SK < out[j].GSI1SK })
+	return out, nil
+}
+
+// LastDigest returns the most recent digest sent to a buyer
+func (s *MemoryStore) LastDigest(ctx context.Context, userID string) (models.WantDigest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := s.digests[userID]
+	if len(list) == 0 {
+		return models.WantDigest{}, ErrNotFound
+	}
+	return list[len(list)-1], nil
+}
+
+// SaveDigest records a digest, if any, and writes back the matches it sent
+func (s *MemoryStore) SaveDigest(ctx context.Context, d *models.WantDigest, sent []models.WantMatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d != nil {
+		s.digests[d.UserID] = append(s.digests[d.UserID], *d)
+	}
+	for _, m := range sent {
+		if s.matches[m.UserID] == nil {
+			s.matches[m.UserID] = map[string]models.WantMatch{}
+		}
+		s.matches[m.UserID][m.SK] = m
+	}
+	return nil
+}
+
+// filter returns copies of the wants matching keep
+func (s *MemoryStore) filter(keep func(models.Want) bool) []models.Want {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []models.Want
+	for _, w := range s.wants {
+		if keep(w) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
@@ -0,0 +1,410 @@
+// Package wants keeps buyers' want lists and tells them when a matching copy goes on sale.
+//
+// A want names a printing together with the worst condition, the highest price, the language
+// and the foil finish the buyer will accept. Whenever a listing is published, comes back into
+// stock or drops its price, every want it satisfies gets a match. Matches are batched into at
+// most one digest per buyer every DigestEvery, so a seller loading a binder of cards doesn't
+// send a buyer a message per card.
+package wants
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/catalog"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/ids"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/models"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/optimizer"
+	"github.com/mcgigglepop/tcg-marketplace/server/internal/outbox"
+)
+
+var (
+	// ErrNotFound is returned when a want or digest does not exist
+	ErrNotFound = errors.New("wants: not found")
+	// ErrInvalid is returned for a want with a bad quantity, price or preference
+	ErrInvalid = errors.New("wants: invalid want")
+	// ErrTooMany is returned when a buyer's want list is full
+	ErrTooMany = errors.New("wants: your want list is full")
+)
+
+// Store persists wants, their matches and the digests sent to buyers.
+type Store interface {
+	PutWant(ctx context.Context, w models.Want) error
+	GetWant(ctx context.Context, userID, wantID string) (models.Want, error)
+	DeleteWant(ctx context.Context, userID, wantID string) error
+	// WantsByUser returns a buyer's want list, newest first
+	WantsByUser(ctx context.Context, userID string) ([]models.Want, error)
+	WantsByPrinting(ctx context.Context, printingID string) ([]models.Want, error)
+	AddMatch(ctx context.Context, m models.WantMatch) error
+	// MatchesByUser returns a buyer's matches, newest first
+	MatchesByUser(ctx context.Context, userID string) ([]models.WantMatch, error)
+	// PendingMatches returns the matches not yet sent in a digest, oldest first
+	PendingMatches(ctx context.Context) ([]models.WantMatch, error)
+	// LastDigest returns the most recent digest sent to a buyer, or ErrNotFound
+	LastDigest(ctx context.Context, userID string) (models.WantDigest, error)
+	// SaveDigest records a digest and marks its matches sent in one transaction. d is nil when
+	// nothing was delivered and the matches are only being cleared.
+	SaveDigest(ctx context.Context, d *models.WantDigest, sent []models.WantMatch) error
+}
+
+// Digest is a batch of matches for one buyer
+type Digest struct {
+	UserID  string
+	Matches []models.WantMatch
+}
+
+// Notifier delivers a digest to a buyer. Returning an error leaves the matches pending so they
+// go out with the next attempt.
+type Notifier func(ctx context.Context, d Digest) error
+
+// Options controls digest batching and want list limits
+type Options struct {
+	DigestEvery time.Duration // the shortest gap between two digests to the same buyer
+	MaxWants    int           // entries allowed on one want list
+}
+
+// DefaultOptions are used for any zero Options field
+var DefaultOptions = Options{
+	DigestEvery: time.Hour,
+	MaxWants:    500,
+}
+
+// Service manages want lists, matches them against listings and sends digests.
+type Service struct {
+	store    Store
+	catalog  *catalog.Catalog
+	planner  *optimizer.Optimizer
+	opts     Options
+	errorLog *log.Logger
+	now      func() time.Time
+	notify   Notifier
+}
+
+// New creates a wants Service
+func New(store Store, c *catalog.Catalog, planner *optimizer.Optimizer, opts Options, errorLog *log.Logger) *Service {
+	if opts.DigestEvery <= 0 {
+		opts.DigestEvery = DefaultOptions.DigestEvery
+	}
+	if opts.MaxWants <= 0 {
+		opts.MaxWants = DefaultOptions.MaxWants
+	}
+	return &Service{store: store, catalog: c, planner: planner, opts: opts, errorLog: errorLog, now: time.Now}
+}
+
+// SetNotifier sets how digests are delivered. Without one, digests are only recorded.
+func (s *Service) SetNotifier(fn Notifier) {
+	s.notify = fn
+}
+
+// Attach matches wants against listings as their events are published from the outbox. A match
+// already made at or below a listing's price isn't made again, so redelivery is harmless.
+func (s *Service) Attach(relay *outbox.Relay) {
+	relay.Subscribe("wants", func(ctx context.Context, ev models.DomainEvent) error {
+		var ch catalog.ListingChange
+		if err := ev.Decode(&ch); err != nil {
+			return err
+		}
+		return s.listingChanged(ctx, ch)
+	}, models.EventListingCreated, models.EventListingUpdated)
+}
+
+// Add puts a printing on a buyer's want list
+func (s *Service) Add(ctx context.Context, w models.Want) (models.Want, error) {
+	if w.Foil == "" {
+		w.Foil = models.FoilAny
+	}
+	if err := validate(w); err != nil {
+		return models.Want{}, err
+	}
+	if _, err := s.catalog.Printing(ctx, w.PrintingID); err != nil {
+		if errors.Is(err, catalog.ErrNotFound) {
+			return models.Want{}, fmt.Errorf("%w: unknown printing", ErrInvalid)
+		}
+		return models.Want{}, err
+	}
+
+	existing, err := s.store.WantsByUser(ctx, w.UserID)
+	if err != nil {
+		return models.Want{}, err
+	}
+	if len(existing) >= s.opts.MaxWants {
+		return models.Want{}, ErrTooMany
+	}
+
+	now := s.now().UTC().Format(time.RFC3339)
+	w.WantID = ids.New()
+	w.Type = models.ItemTypeWant
+	w.PK, w.SK = models.WantKey(w.UserID, w.WantID)
+	w.GSI1PK, w.GSI1SK = models.PrintingWantKey(w.PrintingID, w.UserID, w.WantID)
+	w.CreatedAt, w.UpdatedAt = now, now
+	if err := s.store.PutWant(ctx, w); err != nil {
+		return models.Want{}, err
+	}
+	return w, nil
+}
+
+// Remove takes an entry off a buyer's want list
+func (s *Service) Remove(ctx context.Context, userID, wantID string) error {
+	if _, err := s.store.GetWant(ctx, userID, wantID); err != nil {
+		return err
+	}
+	return s.store.DeleteWant(ctx, userID, wantID)
+}
+
+// ForUser returns a buyer's want list, newest first
+func (s *Service) ForUser(ctx context.Context, userID string) ([]models.Want, error) {
+	return s.store.WantsByUser(ctx, userID)
+}
+
+// Matches returns the listings that matched a buyer's wants, newest first
+func (s *Service) Matches(ctx context.Context, userID string) ([]models.WantMatch, error) {
+	return s.store.MatchesByUser(ctx, userID)
+}
+
+// Optimize plans the cheapest way to buy everything on a buyer's want list. req supplies the
+// country and objective; its buyer and cards come from the want list, each want only accepting
+// the copies it asks for.
+func (s *Service) Optimize(ctx context.Context, userID string, req optimizer.Request) (optimizer.Plan, error) {
+	list, err := s.store.WantsByUser(ctx, userID)
+	if err != nil {
+		return optimizer.Plan{}, err
+	}
+	req.BuyerID = userID
+	req.Needs = make([]optimizer.Need, 0, len(list))
+	for _, w := range list {
+		req.Needs = append(req.Needs, optimizer.Need{
+			Key:        w.WantID,
+			PrintingID: w.PrintingID,
+			Quantity:   w.Quantity,
+			Accept:     w.Accepts,
+		})
+	}
+	return s.planner.Solve(ctx, req)
+}
+
+// FlushDigests sends each buyer with pending matches a digest, unless they had one within
+// DigestEvery. It returns how many digests went out.
+func (s *Service) FlushDigests(ctx context.Context) (int, error) {
+	pending, err := s.store.PendingMatches(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var users []string
+	byUser := map[string][]models.WantMatch{}
+	for _, m := range pending {
+		if _, ok := byUser[m.UserID]; !ok {
+			users = append(users, m.UserID)
+		}
+		byUser[m.UserID] = append(byUser[m.UserID], m)
+	}
+
+	now := s.now().UTC()
+	sent := 0
+	for _, userID := range users {
+		last, err := s.store.LastDigest(ctx, userID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return sent, err
+		}
+		if err == nil {
+			at, _ := time.Parse(time.RFC3339, last.SentAt)
+			if now.Sub(at) < s.opts.DigestEvery {
+				continue
+			}
+		}
+
+		ok, err := s.sendDigest(ctx, userID, byUser[userID], now)
+		if err != nil {
+			return sent, err
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// Run flushes digests every interval until ctx is cancelled
+func (s *Service) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if _, err := s.FlushDigests(ctx); err != nil {
+			s.errorLog.Printf("sending want list digests failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendDigest delivers a buyer's pending matches and marks them sent. Matches whose listing has
+// since sold out or been repriced above the want, or whose want was removed, are marked sent
+// without being delivered. It reports whether a digest went out.
+func (s *Service) sendDigest(ctx context.Context, userID string, pending []models.WantMatch, now time.Time) (bool, error) {
+	var live []models.WantMatch
+	for _, m := range pending {
+		fresh, err := s.stillMatches(ctx, m)
+		if err != nil {
+			return false, err
+		}
+		if fresh {
+			live = append(live, m)
+		}
+	}
+
+	if len(live) > 0 && s.notify != nil {
+		if err := s.notify(ctx, Digest{UserID: userID, Matches: live}); err != nil {
+			s.errorLog.Printf("delivering want list digest to %s failed: %v", userID, err)
+			return false, nil
+		}
+	}
+
+	at := now.Format(time.RFC3339)
+	for i := range pending {
+		pending[i].SentAt = at
+		pending[i].GSI1PK, pending[i].GSI1SK = "", ""
+	}
+	// when nothing was delivered there's no digest to record, so the buyer's next one isn't held back
+	var d *models.WantDigest
+	if len(live) > 0 {
+		d = &models.WantDigest{
+			Type:    models.ItemTypeWantDigest,
+			UserID:  userID,
+			Matches: len(live),
+			SentAt:  at,
+		}
+		d.PK, d.SK = models.WantDigestKey(userID, at)
+	}
+	if err := s.store.SaveDigest(ctx, d, pending); err != nil {
+		return false, err
+	}
+	return len(live) > 0, nil
+}
+
+// stillMatches reports whether a match is still worth telling the buyer about
+func (s *Service) stillMatches(ctx context.Context, m models.WantMatch) (bool, error) {
+	w, err := s.store.GetWant(ctx, m.UserID, m.WantID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	l, err := s.catalog.Listing(ctx, m.ListingID)
+	if errors.Is(err, catalog.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return l.IsActive() && w.Accepts(l), nil
+}
+
+// listingChanged records a match for every want a newly available or cheaper listing satisfies
+func (s *Service) listingChanged(ctx context.Context, ch catalog.ListingChange) error {
+	l := ch.Current
+	if !l.IsActive() {
+		return nil
+	}
+	reason := ""
+	switch {
+	case ch.Previous == nil || !ch.Previous.IsActive():
+		reason = models.WantMatchListed
+	case l.PriceCents < ch.Previous.PriceCents:
+		reason = models.WantMatchRepriced
+	default:
+		return nil
+	}
+
+	list, err := s.store.WantsByPrinting(ctx, l.PrintingID)
+	if err != nil {
+		return err
+	}
+	now := s.now().UTC().Format(time.RFC3339)
+	for _, w := range list {
+		if w.UserID == l.SellerID || !w.Accepts(l) {
+			continue
+		}
+		seen, err := s.matchedAtOrBelow(ctx, w, l)
+		if err != nil {
+			return err
+		}
+		if seen {
+			continue
+		}
+
+		m := models.WantMatch{
+			Type:       models.ItemTypeWantMatch,
+			MatchID:    ids.New(),
+			UserID:     w.UserID,
+			WantID:     w.WantID,
+			PrintingID: l.PrintingID,
+			ListingID:  l.ListingID,
+			SellerID:   l.SellerID,
+			PriceCents: l.PriceCents,
+			Condition:  l.Condition,
+			Reason:     reason,
+			CreatedAt:  now,
+		}
+		m.PK, m.SK = models.WantMatchKey(w.UserID, now, m.MatchID)
+		m.GSI1PK, m.GSI1SK = models.PendingWantMatchKey(now, w.UserID, m.MatchID)
+		if err := s.store.AddMatch(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchedAtOrBelow reports whether the buyer was already told about this listing for this want
+// at the same price or lower, so restocks and small bumps don't repeat a match
+func (s *Service) matchedAtOrBelow(ctx context.Context, w models.Want, l models.Listing) (bool, error) {
+	list, err := s.store.MatchesByUser(ctx, w.UserID)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range list {
+		if m.WantID == w.WantID && m.ListingID == l.ListingID && m.PriceCents <= l.PriceCents {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validate checks a want's quantity and preferences
+func validate(w models.Want) error {
+	if w.UserID == "" || w.PrintingID == "" {
+		return fmt.Errorf("%w: choose a card", ErrInvalid)
+	}
+	if w.Quantity < 1 {
+		return fmt.Errorf("%w: quantity must be at least 1", ErrInvalid)
+	}
+	if w.MaxPriceCents < 0 {
+		return fmt.Errorf("%w: max price can't be negative", ErrInvalid)
+	}
+	if w.MinCondition != "" && models.ConditionRank(w.MinCondition) == len(models.Conditions) {
+		return fmt.Errorf("%w: unknown condition %q", ErrInvalid, w.MinCondition)
+	}
+	for _, f := range models.FoilPreferences {
+		if w.Foil == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown foil preference %q", ErrInvalid, w.Foil)
+}
+
+// sortWants orders wants newest first, breaking ties by ID
+func sortWants(ws []models.Want) {
+	sort.Slice(ws, func(i, j int) bool {
+		if ws[i].CreatedAt != ws[j].CreatedAt {
+			return ws[i].CreatedAt > ws[j].CreatedAt
+		}
+		return ws[i].WantID > ws[j].WantID
+	})
+}
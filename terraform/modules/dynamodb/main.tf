@@ -30,6 +30,14 @@ resource "aws_dynamodb_table" "marketplace" {
     name = "GSI2SK" 
     type = "S" 
   }
+  attribute { 
+    name = "GSI3PK" 
+    type = "S" 
+  }
+  attribute { 
+    name = "GSI3SK" 
+    type = "S" 
+  }
 
   # --- GSI1: lookup by email ---
   # GSI1PK = EMAIL#<lowercasedEmail>
@@ -51,6 +59,16 @@ resource "aws_dynamodb_table" "marketplace" {
     projection_type    = "ALL"
   }
 
+  # --- GSI3: marketplace-wide lists for items whose GSI1 and GSI2 are taken ---
+  # GSI3PK = ORDERS#<status> | OFFERS#<status>
+  # GSI3SK = <createdAt>#<id>
+  global_secondary_index {
+    name               = "GSI3"
+    hash_key           = "GSI3PK"
+    range_key          = "GSI3SK"
+    projection_type    = "ALL"
+  }
+
   # --- Resilience & security ---
   point_in_time_recovery { 
     enabled = true 